@@ -0,0 +1,321 @@
+// Package config resolves settings by name from command-line flags,
+// environment variables and an optional YAML file, in that order of
+// precedence. Setting names are the environment variable names used
+// throughout the service, so a file entry like
+//
+//	MONGO_URI: mongodb://db:27017
+//	RESOLUTION_APPROVAL: true
+//
+// is equivalent to exporting the same variables.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	flagValues = map[string]string{}
+	fileValues = map[string]string{}
+	args       []string
+)
+
+// Lookup returns the value of a setting and whether it was set anywhere.
+// Empty values count as unset, matching how the service has always treated
+// empty environment variables.
+func Lookup(key string) (string, bool) {
+	if v := flagValues[key]; v != "" {
+		return v, true
+	}
+	if v := os.Getenv(key); v != "" {
+		return v, true
+	}
+	if v := fileValues[key]; v != "" {
+		return v, true
+	}
+	return "", false
+}
+
+// settingList collects repeated -set KEY=VALUE flags.
+type settingList map[string]string
+
+func (s settingList) String() string { return "" }
+
+func (s settingList) Set(v string) error {
+	key, value, ok := strings.Cut(v, "=")
+	if !ok || !validKey.MatchString(key) {
+		return errors.New("want KEY=VALUE")
+	}
+	s[key] = value
+	return nil
+}
+
+// Load parses the command line, reads the config file it names (or
+// CONFIG_FILE) and validates the result. It must run before anything reads a
+// setting.
+func Load(argv []string) error {
+	fs := flag.NewFlagSet("complain", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	addr := fs.String("addr", "", "listen address (LISTEN_ADDR)")
+	uri := fs.String("mongo-uri", "", "MongoDB connection string (MONGO_URI)")
+	database := fs.String("db", "", "MongoDB database name (MONGO_DATABASE)")
+	seed := fs.Bool("seed", false, "fill an empty database with fake users and complaints, for development (SEED)")
+	settings := settingList{}
+	fs.Var(settings, "set", "set any setting as KEY=VALUE; may be repeated")
+	if err := fs.Parse(argv); err != nil {
+		return err
+	}
+	args = fs.Args()
+
+	for key, value := range settings {
+		flagValues[key] = value
+	}
+	if *seed {
+		flagValues["SEED"] = "true"
+	}
+	for key, value := range map[string]string{"LISTEN_ADDR": *addr, "MONGO_URI": *uri, "MONGO_DATABASE": *database} {
+		if value != "" {
+			flagValues[key] = value
+		}
+	}
+
+	if *path != "" {
+		values, err := readFile(*path)
+		if err != nil {
+			return err
+		}
+		fileValues = values
+	}
+	return validate()
+}
+
+// Args returns the arguments left after the flags, naming an admin command
+// and its own flags when the binary is not run as the server.
+func Args() []string {
+	return args
+}
+
+var validKey = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// readFile loads a flat YAML mapping of setting names to scalar values.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	values := make(map[string]string, len(raw))
+	for key, v := range raw {
+		if !validKey.MatchString(key) {
+			return nil, fmt.Errorf("config %s: %q is not a setting name; use upper-case names like MONGO_URI", path, key)
+		}
+		switch v := v.(type) {
+		case nil:
+		case map[string]interface{}:
+			return nil, fmt.Errorf("config %s: %s must be a single value", path, key)
+		case []interface{}:
+			// Lists are written comma-separated, as the environment
+			// variables expect.
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+func isDuration(v string) error {
+	_, err := time.ParseDuration(v)
+	return err
+}
+
+func isPositiveInt(v string) error {
+	n, err := strconv.Atoi(v)
+	if err == nil && n < 1 {
+		err = errors.New("must be at least 1")
+	}
+	return err
+}
+
+func isBool(v string) error {
+	_, err := strconv.ParseBool(v)
+	return err
+}
+
+func isTimezone(v string) error {
+	_, err := time.LoadLocation(v)
+	return err
+}
+
+func isAddr(v string) error {
+	_, _, err := net.SplitHostPort(v)
+	return err
+}
+
+func oneOf(values ...string) func(string) error {
+	return func(v string) error {
+		for _, allowed := range values {
+			if v == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(values, ", "))
+	}
+}
+
+var isReadPreference = oneOf("primary", "primaryPreferred", "secondary", "secondaryPreferred", "nearest")
+
+func isWriteConcern(v string) error {
+	if v == "majority" {
+		return nil
+	}
+	if n, err := strconv.Atoi(v); err != nil || n < 0 {
+		return errors.New("must be majority or a number of members")
+	}
+	return nil
+}
+
+func isMongoURI(v string) error {
+	u, err := url.Parse(v)
+	if err == nil && u.Scheme != "mongodb" && u.Scheme != "mongodb+srv" {
+		err = errors.New("scheme must be mongodb or mongodb+srv")
+	}
+	return err
+}
+
+func minLength(n int) func(string) error {
+	return func(v string) error {
+		if len(v) < n {
+			return fmt.Errorf("must be at least %d characters", n)
+		}
+		return nil
+	}
+}
+
+// checks covers the settings whose bad values would otherwise only show up
+// as a log line, or a silent fallback to the default, long after startup.
+var checks = map[string]func(string) error{
+	"LISTEN_ADDR":                    isAddr,
+	"HTTP_REDIRECT_ADDR":             isAddr,
+	"TLS_MIN_VERSION":                oneOf("1.2", "1.3"),
+	"TLS_HSTS_MAX_AGE":               isDuration,
+	"MONGO_URI":                      isMongoURI,
+	"MONGO_CONNECT_ATTEMPTS":         isPositiveInt,
+	"MONGO_CONNECT_BACKOFF":          isDuration,
+	"MONGO_READ_PREFERENCE":          isReadPreference,
+	"MONGO_LISTING_READ_PREFERENCE":  isReadPreference,
+	"MONGO_MAX_STALENESS":            isDuration,
+	"MONGO_READ_CONCERN":             oneOf("local", "available", "majority", "linearizable"),
+	"MONGO_WRITE_CONCERN":            isWriteConcern,
+	"MONGO_WRITE_TIMEOUT":            isDuration,
+	"SCHEMA_BOOTSTRAP_TIMEOUT":       isDuration,
+	"DB_READ_TIMEOUT":                isDuration,
+	"DB_WRITE_TIMEOUT":               isDuration,
+	"STORE_BACKEND":                  oneOf("mongo", "postgres"),
+	"BULK_MAX_IDS":                   isPositiveInt,
+	"IMPORT_MAX_BYTES":               isPositiveInt,
+	"IMPORT_MAX_ROWS":                isPositiveInt,
+	"TAG_MAX_LENGTH":                 isPositiveInt,
+	"ACCOUNT_ERASURE_GRACE_PERIOD":   isDuration,
+	"ACCOUNT_ERASURE_CHECK_INTERVAL": isDuration,
+	"IDEMPOTENCY_KEY_TTL":            isDuration,
+	"DUPLICATE_SIMILARITY_WINDOW":    isDuration,
+	"DUPLICATE_SIMILARITY_PERCENT":   isPositiveInt,
+	"SHUTDOWN_TIMEOUT":               isDuration,
+	"SHUTDOWN_DRAIN_DELAY":           isDuration,
+	"READINESS_TIMEOUT":              isDuration,
+	"SERVER_READ_HEADER_TIMEOUT":     isDuration,
+	"SERVER_IDLE_TIMEOUT":            isDuration,
+	"GRPC_ADDR":                      isAddr,
+	"GRAPHQL_MAX_DEPTH":              isPositiveInt,
+	"CACHE_TTL":                      isDuration,
+	"API_KEY_TTL":                    isDuration,
+	"EVENT_BROKER":                   oneOf("nats", "kafka"),
+	"OUTBOX_PUBLISH_INTERVAL":        isDuration,
+	"OUTBOX_BATCH_SIZE":              isPositiveInt,
+	"EVENT_PUBLISH_TIMEOUT":          isDuration,
+	"MIGRATE_ON_START":               isBool,
+	"MIGRATION_TIMEOUT":              isDuration,
+	"SEED":                           isBool,
+	"SEED_USERS":                     isPositiveInt,
+	"SEED_COMPLAINTS":                isPositiveInt,
+	"JWT_SECRET":                     minLength(32),
+	"JWT_ACCESS_TTL":                 isDuration,
+	"JWT_REFRESH_TTL":                isDuration,
+	"SMTP_PORT":                      isPositiveInt,
+	"NOTIFY_QUEUE_SIZE":              isPositiveInt,
+	"NOTIFY_WORKERS":                 isPositiveInt,
+	"NOTIFY_MAX_ATTEMPTS":            isPositiveInt,
+	"NOTIFY_RETRY_DELAY":             isDuration,
+	"CORS_ALLOW_CREDENTIALS":         isBool,
+	"CORS_MAX_AGE":                   isDuration,
+	"RESPONSE_COMPRESSION":           isBool,
+	"COMPRESSION_MIN_BYTES":          isPositiveInt,
+	"RESOLUTION_APPROVAL":            isBool,
+	"AUTO_RESOLVE_ENABLED":           isBool,
+	"AUTO_RESOLVE_AFTER":             isDuration,
+	"AUTO_RESOLVE_DRY_RUN":           isBool,
+	"RETENTION_ENABLED":              isBool,
+	"RETENTION_ARCHIVE_AFTER":        isDuration,
+	"RETENTION_DRY_RUN":              isBool,
+	"RETENTION_INTERVAL":             isDuration,
+	"PRIORITY_AGING_ENABLED":         isBool,
+	"SLA_ESCALATION_INTERVAL":        isDuration,
+	"ESCALATION_CHAIN_INTERVAL":      isDuration,
+	"REOPEN_WINDOW":                  isDuration,
+	"NOTIFICATION_TTL":               isDuration,
+	"CHAT_TIMEOUT":                   isDuration,
+	"SMS_TIMEOUT":                    isDuration,
+	"PHONE_VERIFICATION_TTL":         isDuration,
+	"PHONE_VERIFICATION_ATTEMPTS":    isPositiveInt,
+	"JOB_POLL_INTERVAL":              isDuration,
+	"JOB_LOCK_TIMEOUT":               isDuration,
+	"SCREEN_CLASSIFIER_TIMEOUT":      isDuration,
+	"SCREEN_FAIL_CLOSED":             isBool,
+	"REQUIRE_VERIFIED_EMAIL":         isBool,
+	"EMAIL_VERIFICATION_TTL":         isDuration,
+	"ORG_INVITE_TTL":                 isDuration,
+	"ANONYMOUS_SUBMISSIONS":          isBool,
+	"OIDC_HTTP_TIMEOUT":              isDuration,
+	"OIDC_STATE_TTL":                 isDuration,
+	"SESSION_CHECK_INTERVAL":         isDuration,
+	"AVATAR_MAX_BYTES":               isPositiveInt,
+	"PRIORITY_AGING_STEP":            isDuration,
+	"REPORT_TIMEZONE":                isTimezone,
+}
+
+func validate() error {
+	var problems []string
+	for key, check := range checks {
+		v, ok := Lookup(key)
+		if !ok {
+			continue
+		}
+		if err := check(v); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", key, err))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"complain/models"
+	"complain/store"
+)
+
+// registerPriorityAging schedules bumping unresolved complaints one priority
+// level for every PRIORITY_AGING_STEP they wait without another escalation,
+// up to PRIORITY_AGING_MAX. It only runs when PRIORITY_AGING_ENABLED is set.
+func registerPriorityAging() {
+	if !envBool("PRIORITY_AGING_ENABLED", false) {
+		return
+	}
+	step := envDuration("PRIORITY_AGING_STEP", 7*24*time.Hour)
+	ceiling := priorityRank(envString("PRIORITY_AGING_MAX", "high"))
+	if step <= 0 || ceiling < 0 {
+		log.Printf("priority aging: invalid PRIORITY_AGING_STEP or PRIORITY_AGING_MAX, disabled")
+		return
+	}
+	registerJob("priority-aging", envDuration("PRIORITY_AGING_INTERVAL", time.Hour), func(ctx context.Context) error {
+		return ageComplaints(ctx, step, ceiling)
+	})
+}
+
+func ageComplaints(ctx context.Context, step time.Duration, ceiling int) error {
+	below := bson.A{nil}
+	for _, p := range priorities[:ceiling] {
+		below = append(below, p)
+	}
+	cutoff := time.Now().Add(-step)
+	cursor, err := orgCollection("complaints").Find(ctx, bson.M{
+		"resolved":  false,
+		"priority":  bson.M{"$in": below},
+		"createdAt": bson.M{"$lt": cutoff},
+		"deletedAt": store.NotDeleted(),
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var c models.Complaint
+		if err := cursor.Decode(&c); err != nil {
+			return err
+		}
+		last := c.CreatedAt
+		for _, e := range c.Escalations {
+			if e.At.After(last) {
+				last = e.At
+			}
+		}
+		if last.After(cutoff) {
+			continue
+		}
+		if priorityRank(c.Priority) < 0 && priorityRank(defaultPriority) >= ceiling {
+			continue
+		}
+		reason := fmt.Sprintf("waited %s without escalation", step)
+		if err := escalateComplaint(&c, reason); err != nil {
+			log.Printf("priority aging complaint %s: %v", c.ID.Hex(), err)
+		}
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"complain/models"
+	"complain/store"
+)
+
+// trackingKey marks a request made with an anonymous complaint's tracking
+// token; it holds the complaint's ID.
+const trackingKey contextKey = iota + 300
+
+// anonymousActor stands in for the submitter of an anonymous complaint in
+// comments, history and audit logs.
+const anonymousActor = "anonymous reporter"
+
+func trackingComplaint(r *http.Request) (primitive.ObjectID, bool) {
+	id, ok := r.Context().Value(trackingKey).(primitive.ObjectID)
+	return id, ok
+}
+
+func withTracking(r *http.Request, id primitive.ObjectID) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), trackingKey, id))
+}
+
+// anonymousSubmissionsAllowed reports whether orgID accepts complaints from
+// people without an account. Outside any organization ANONYMOUS_SUBMISSIONS
+// decides.
+func anonymousSubmissionsAllowed(ctx context.Context, orgID string) (bool, error) {
+	if orgID == "" {
+		return envBool("ANONYMOUS_SUBMISSIONS", false), nil
+	}
+	org, err := findOrg(ctx, orgID)
+	return org.AnonymousSubmissions, err
+}
+
+// anonymousSubmission is only returned once: the tracking token is the
+// submitter's one way back to the complaint.
+type anonymousSubmission struct {
+	TrackingToken string           `json:"trackingToken"`
+	Complaint     models.Complaint `json:"complaint"`
+}
+
+// submitAnonymousComplaintHandler files a complaint without an account, in
+// the organization ?orgId= if it accepts them. The complaint has no reporter,
+// so agents see nothing identifying the submitter; only the title, summary,
+// category, priority and rating are taken from the request.
+func submitAnonymousComplaintHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	orgID := r.URL.Query().Get("orgId")
+	allowed, err := anonymousSubmissionsAllowed(dbContext(r), orgID)
+	if err == mongo.ErrNoDocuments {
+		writeFieldError(w, "orgId", "is not a known organization")
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Anonymous complaints are not accepted", http.StatusForbidden)
+		return
+	}
+
+	var req models.Complaint
+	if err := decodeComplaint(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	complaint := models.Complaint{
+		Title:    req.Title,
+		Summary:  req.Summary,
+		Rating:   req.Rating,
+		Category: req.Category,
+		Priority: req.Priority,
+		OrgID:    orgID,
+	}
+	if errs := validateComplaint(&complaint); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	category, err := lookupCategory(dbContext(r), complaint.Category)
+	if errors.Is(err, errUnknownCategory) {
+		writeFieldError(w, "category", err.Error())
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	// Anonymous complaints all share the empty reporter, so the spam check
+	// compares each one with every recent anonymous submission.
+	complaint.ContentHash = contentHash(complaint)
+	spam, err := isSpamBurst(dbContext(r), complaint)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if spam {
+		http.Error(w, "Too many similar complaints, please wait before submitting again", http.StatusTooManyRequests)
+		return
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		serverError(w, err)
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	complaint.RefNumber, err = complaintStore.NextSequence(dbContext(r), complaintSequence)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	complaint.ID = primitive.NewObjectID()
+	complaint.Anonymous = true
+	complaint.TrackingTokenHash = hashToken(token)
+	complaint.Status = models.StatusOpen
+	complaint.CreatedAt = time.Now().UTC()
+	complaint.UpdatedAt = complaint.CreatedAt
+	complaint.DueAt = dueDate(complaint.CreatedAt, complaint.Priority)
+	if reasons := screenComplaint(dbContext(r), complaint); len(reasons) > 0 {
+		complaint.Flagged = true
+		complaint.Moderation = &models.Moderation{Status: models.ModerationFlagged, Reasons: reasons, FlaggedAt: complaint.CreatedAt}
+	} else {
+		complaint.AssignedTo, err = nextAutoAssignee(dbContext(r), category)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+	}
+	err = withOutbox(dbContext(r), func(ctx context.Context) ([]outboxEvent, error) {
+		if _, err := orgCollection("complaints").InsertOne(ctx, complaint); err != nil || complaint.Flagged {
+			return nil, err
+		}
+		return []outboxEvent{outboxEntry(eventComplaintCreated, complaint)}, nil
+	})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	r = withTracking(r, complaint.ID)
+	recordAudit(r, auditSubmit, auditTargetComplaint, complaint.ID, nil, complaint)
+	complaintsSubmittedTotal.Inc()
+	if !complaint.Flagged {
+		go publishEvent(eventComplaintCreated, complaint)
+	}
+
+	computeFields(&complaint, time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(anonymousSubmission{TrackingToken: token, Complaint: complaint})
+}
+
+// trackedComplaint finds the anonymous complaint for the tracking token
+// ?token= and returns the request marked as coming from its submitter.
+func trackedComplaint(w http.ResponseWriter, r *http.Request) (models.Complaint, *http.Request, bool) {
+	var complaint models.Complaint
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeFieldError(w, "token", "is required")
+		return complaint, r, false
+	}
+	err := orgCollection("complaints").FindOne(dbContext(r),
+		bson.M{"trackingTokenHash": hashToken(token), "deletedAt": store.NotDeleted()}).Decode(&complaint)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return complaint, r, false
+	}
+	if err != nil {
+		serverError(w, err)
+		return complaint, r, false
+	}
+	return complaint, withTracking(r, complaint.ID), true
+}
+
+// trackComplaintHandler shows an anonymous submitter their complaint as its
+// reporter would see it.
+func trackComplaintHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	complaint, _, ok := trackedComplaint(w, r)
+	if !ok {
+		return
+	}
+	computeFields(&complaint, time.Now())
+	json.NewEncoder(w).Encode(complaint)
+}
+
+// trackComplaintCommentsHandler lets an anonymous submitter read the public
+// comments on their complaint (GET) and add their own (POST).
+func trackComplaintCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	complaint, r, ok := trackedComplaint(w, r)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		listComments(w, r, complaint.ID)
+	case http.MethodPost:
+		addComment(w, r, complaint)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+const (
+	apiKeyScopeRead  = "read"
+	apiKeyScopeAdmin = "admin"
+
+	// apiKeyPrefix marks API keys, so a bearer token can be told apart from
+	// an access token without trying to parse it.
+	apiKeyPrefix = "ck_"
+)
+
+const (
+	apiKeyKey contextKey = iota + 400
+	apiKeyScopeKey
+)
+
+var errInvalidAPIKey = errors.New("invalid, expired or revoked API key")
+
+type apiKeyRequest struct {
+	Name      string     `json:"name"`
+	Scope     string     `json:"scope"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// sentAPIKey returns the API key of the request, sent as X-API-Key or as a
+// bearer token.
+func sentAPIKey(r *http.Request) (string, bool) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key, true
+	}
+	key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return key, ok && strings.HasPrefix(key, apiKeyPrefix)
+}
+
+// lookupAPIKey finds the live key with the given value and records its use.
+// lastUsedAt is written at most once a minute so busy scripts do not turn
+// every read into a write.
+func lookupAPIKey(ctx context.Context, raw string) (models.APIKey, error) {
+	now := time.Now().UTC()
+	var key models.APIKey
+	err := documents.Collection("api_keys").FindOne(ctx, bson.M{
+		"keyHash":   hashToken(raw),
+		"revokedAt": bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": now},
+	}).Decode(&key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return key, errInvalidAPIKey
+	}
+	if err != nil {
+		return key, err
+	}
+	documents.Collection("api_keys").UpdateOne(ctx, bson.M{"_id": key.ID, "$or": bson.A{
+		bson.M{"lastUsedAt": bson.M{"$exists": false}},
+		bson.M{"lastUsedAt": bson.M{"$lt": now.Add(-time.Minute)}},
+	}}, bson.M{"$set": bson.M{"lastUsedAt": now}})
+	return key, nil
+}
+
+// apiKeyContext attaches key to ctx. The key acts as an admin of its
+// organization named after the key.
+func apiKeyContext(ctx context.Context, key models.APIKey) (context.Context, staffMember) {
+	member := staffMember{Name: "apikey:" + key.Name, Role: roleAdmin, Org: key.OrgID}
+	ctx = context.WithValue(ctx, apiKeyKey, member)
+	return context.WithValue(ctx, apiKeyScopeKey, key.Scope), member
+}
+
+// apiKeyForbids reports whether the API key on ctx, if any, may not make a
+// request with method: read keys only pass safe methods.
+func apiKeyForbids(ctx context.Context, method string) bool {
+	scope, _ := ctx.Value(apiKeyScopeKey).(string)
+	return scope == apiKeyScopeRead && method != http.MethodGet && method != http.MethodHead && method != http.MethodOptions
+}
+
+// withAPIKey authenticates requests that carry an API key.
+func withAPIKey(w http.ResponseWriter, r *http.Request, raw string, next http.Handler) {
+	key, err := lookupAPIKey(r.Context(), raw)
+	if errors.Is(err, errInvalidAPIKey) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	ctx, member := apiKeyContext(r.Context(), key)
+	if apiKeyForbids(ctx, r.Method) {
+		http.Error(w, "API key is read-only", http.StatusForbidden)
+		return
+	}
+	r = r.WithContext(ctx)
+	if info := requestInfoFrom(r.Context()); info != nil {
+		info.User = member.Name
+	}
+	next.ServeHTTP(w, r)
+}
+
+func currentAPIKey(r *http.Request) (staffMember, bool) {
+	member, ok := r.Context().Value(apiKeyKey).(staffMember)
+	return member, ok
+}
+
+// apiKeysHandler lists (GET), creates (POST) and revokes (DELETE, ?id=) API
+// keys. Organization admins manage only their organization's keys. Keys
+// cannot manage keys, so a leaked key cannot mint itself successors.
+func apiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := currentAPIKey(r); ok {
+		http.Error(w, "API keys cannot manage API keys", http.StatusForbidden)
+		return
+	}
+	filter := bson.M{}
+	if org, ok := callerOrg(r); ok {
+		filter["orgId"] = org
+	}
+	switch r.Method {
+	case http.MethodGet:
+		cursor, err := documents.Collection("api_keys").Find(dbContext(r), filter, options.Find().SetSort(bson.M{"createdAt": 1}))
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		keys := []models.APIKey{}
+		if err := cursor.All(dbContext(r), &keys); err != nil {
+			serverError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(keys)
+	case http.MethodPost:
+		createAPIKey(w, r)
+	case http.MethodDelete:
+		oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+		if err != nil {
+			writeFieldError(w, "id", "must be a valid ID")
+			return
+		}
+		filter["_id"] = oid
+		filter["revokedAt"] = bson.M{"$exists": false}
+		now := time.Now().UTC()
+		var before models.APIKey
+		err = documents.Collection("api_keys").FindOneAndUpdate(dbContext(r), filter,
+			bson.M{"$set": bson.M{"revokedAt": now, "revokedBy": actorName(r)}}).Decode(&before)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		after := before
+		after.RevokedAt, after.RevokedBy = &now, actorName(r)
+		recordAudit(r, auditRevokeAPIKey, auditTargetAPIKey, oid, before, after)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req apiKeyRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now().UTC()
+	req.Name = strings.TrimSpace(req.Name)
+	var errs validationErrors
+	if req.Name == "" {
+		errs.add("name", "is required")
+	}
+	if req.Scope != apiKeyScopeRead && req.Scope != apiKeyScopeAdmin {
+		errs.add("scope", "must be read or admin")
+	}
+	expiresAt := now.Add(envDuration("API_KEY_TTL", 90*24*time.Hour))
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) {
+			errs.add("expiresAt", "must be in the future")
+		}
+		expiresAt = req.ExpiresAt.UTC()
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		serverError(w, err)
+		return
+	}
+	secret := apiKeyPrefix + hex.EncodeToString(raw)
+	org, _ := callerOrg(r)
+	key := models.APIKey{
+		ID:        primitive.NewObjectID(),
+		Name:      req.Name,
+		Scope:     req.Scope,
+		OrgID:     org,
+		KeyHash:   hashToken(secret),
+		Prefix:    secret[:len(apiKeyPrefix)+8],
+		CreatedBy: actorName(r),
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	if _, err := documents.Collection("api_keys").InsertOne(dbContext(r), key); err != nil {
+		serverError(w, err)
+		return
+	}
+	recordAudit(r, auditCreateAPIKey, auditTargetAPIKey, key.ID, nil, key)
+
+	// The key is only ever shown here; afterwards only its prefix is.
+	key.Key = secret
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
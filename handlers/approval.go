@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"complain/models"
+)
+
+// approveResolutionHandler lets a manager approve a pending resolution, which
+// resolves the complaint, or reject it back to in_progress.
+func approveResolutionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !canApprove(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("complaintId"))
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+
+	version, ok := requireComplaintVersion(w, r)
+	if !ok {
+		return
+	}
+
+	decision := r.URL.Query().Get("decision")
+	var target string
+	switch decision {
+	case "approve":
+		target = models.StatusResolved
+	case "reject":
+		target = models.StatusInProgress
+	default:
+		writeFieldError(w, "decision", "must be approve or reject")
+		return
+	}
+
+	change := newStatusChange(r, models.StatusPendingApproval, target)
+	approval := models.ResolutionApproval{Decision: decision, DecidedBy: change.ChangedBy, DecidedAt: change.ChangedAt}
+
+	// The agent who asked for approval stays credited as the resolver.
+	update := bson.M{
+		"$set":  bson.M{"status": target, "resolved": target == models.StatusResolved, "approval": approval},
+		"$push": bson.M{"history": change},
+	}
+	if target == models.StatusResolved {
+		update["$set"].(bson.M)["resolvedAt"] = change.ChangedAt
+	} else {
+		update["$unset"] = bson.M{"resolvedBy": ""}
+	}
+
+	var complaint models.Complaint
+	filter := bson.M{"_id": oid, "status": models.StatusPendingApproval}
+	withVersion(filter, update, version)
+	event := eventComplaintResolved
+	if target != models.StatusResolved {
+		event = eventComplaintStatus
+	}
+	err = updateComplaint(dbContext(r), event, filter, update, &complaint)
+	if err == mongo.ErrNoDocuments {
+		pending := orgCollection("complaints").FindOne(dbContext(r), bson.M{"_id": oid, "status": models.StatusPendingApproval}).Err()
+		if pending == nil {
+			writeVersionConflict(w)
+			return
+		}
+		http.Error(w, "No pending resolution for this complaint", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	recordAudit(r, auditResolve, auditTargetComplaint, oid,
+		bson.M{"status": models.StatusPendingApproval}, bson.M{"status": target, "approval": approval})
+	if complaint.Resolved {
+		complaintsResolvedTotal.Inc()
+		go publishEvent(eventComplaintResolved, complaint)
+		go notifyOwner(complaint, templateResolution, "")
+	}
+
+	json.NewEncoder(w).Encode(complaint)
+}
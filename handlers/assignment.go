@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+	"complain/store"
+)
+
+const assignmentSequence = "assignments"
+
+// autoAssignAgents is the AUTO_ASSIGN_AGENTS rotation, a comma-separated list
+// of agents new complaints are handed to in turn. Empty disables auto-assign.
+func autoAssignAgents() []string {
+	var agents []string
+	for _, agent := range strings.Split(envString("AUTO_ASSIGN_AGENTS", ""), ",") {
+		if agent = strings.TrimSpace(agent); agent != "" {
+			agents = append(agents, agent)
+		}
+	}
+	return agents
+}
+
+// nextAutoAssignee picks the next agent in the rotation: the category's team
+// if it has one, else AUTO_ASSIGN_AGENTS. The position is kept in a shared
+// counter per rotation so several app instances still take turns fairly.
+func nextAutoAssignee(ctx context.Context, category models.Category) (string, error) {
+	agents, sequence := autoAssignAgents(), assignmentSequence
+	if len(category.Agents) > 0 {
+		agents, sequence = category.Agents, assignmentSequence+":"+category.ID
+	}
+	if len(agents) == 0 {
+		return "", nil
+	}
+	n, err := complaintStore.NextSequence(ctx, sequence)
+	if err != nil {
+		return "", err
+	}
+	return agents[(n-1)%int64(len(agents))], nil
+}
+
+// assignComplaintHandler assigns a complaint to agent, or hands it over if it
+// already belongs to someone else.
+func assignComplaintHandler(w http.ResponseWriter, r *http.Request) {
+	agent := strings.TrimSpace(r.URL.Query().Get("agent"))
+	if agent == "" {
+		writeFieldError(w, "agent", "is required")
+		return
+	}
+	updateAssignment(w, r, agent)
+}
+
+func unassignComplaintHandler(w http.ResponseWriter, r *http.Request) {
+	updateAssignment(w, r, "")
+}
+
+func updateAssignment(w http.ResponseWriter, r *http.Request, agent string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("complaintId"))
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+	version, ok := requireComplaintVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var complaint models.Complaint
+	err = orgCollection("complaints").FindOne(dbContext(r), bson.M{"_id": oid}).Decode(&complaint)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if complaint.Version != version {
+		writeVersionConflict(w)
+		return
+	}
+	if complaint.AssignedTo == agent {
+		json.NewEncoder(w).Encode(complaint)
+		return
+	}
+
+	before := complaint
+	by, _ := adminIdentity(r)
+	assignment := models.Assignment{From: complaint.AssignedTo, To: agent, By: by, At: time.Now().UTC()}
+	update := bson.M{"$push": bson.M{"assignments": assignment}}
+	if agent == "" {
+		update["$unset"] = bson.M{"assignedTo": ""}
+	} else {
+		update["$set"] = bson.M{"assignedTo": agent}
+	}
+
+	// Matching on the previous assignee keeps two agents grabbing the same
+	// complaint from silently overwriting each other.
+	filter := bson.M{"_id": oid, "assignedTo": assigneeFilter(complaint.AssignedTo)}
+	withVersion(filter, update, version)
+	err = updateComplaint(dbContext(r), eventComplaintAssigned, filter, update, &complaint)
+	if err == mongo.ErrNoDocuments {
+		writeVersionConflict(w)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	recordAudit(r, auditAssign, auditTargetComplaint, oid, before, complaint)
+	if complaint.Resolved && agent != "" {
+		requestLogger(r).Warn("assigned already resolved complaint", "complaint_id", complaint.ID.Hex(), "agent", agent)
+	}
+	if agent != "" {
+		go publishEvent(eventComplaintAssigned, complaint)
+	}
+	if agent != "" && assignment.From == "" {
+		go notifyOwner(complaint, templateAssignment, "")
+	}
+
+	json.NewEncoder(w).Encode(complaint)
+}
+
+func assigneeFilter(agent string) interface{} {
+	if agent == "" {
+		return bson.M{"$in": bson.A{"", nil}}
+	}
+	return agent
+}
+
+// myAssignedComplaintsHandler lists the complaints assigned to the calling
+// agent, newest first, optionally narrowed by status.
+func myAssignedComplaintsHandler(w http.ResponseWriter, r *http.Request) {
+	var errs validationErrors
+	page, pageSize := pageParams(r, 20, 100, &errs)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	agent, _ := adminIdentity(r)
+
+	filter := bson.M{"assignedTo": agent, "deletedAt": store.NotDeleted()}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter = bson.M{"$and": bson.A{filter, statusFilter(status)}}
+	}
+
+	total, err := orgCollection("complaints").CountDocuments(dbContext(r), filter)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	cursor, err := orgCollection("complaints").Find(dbContext(r), filter, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page-1)*pageSize)).
+		SetLimit(int64(pageSize)))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer cursor.Close(dbContext(r))
+
+	resp := pagedComplaints{Page: page, PageSize: pageSize, Total: total, Complaints: []models.Complaint{}}
+	for cursor.Next(dbContext(r)) {
+		var complaint models.Complaint
+		if err := cursor.Decode(&complaint); err != nil {
+			serverError(w, err)
+			return
+		}
+		truncateSummary(&complaint)
+		limitAttachments(&complaint)
+		computeFields(&complaint, time.Now())
+		resp.Complaints = append(resp.Complaints, complaint)
+	}
+	addReporterAvatars(r, resp.Complaints)
+
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+const attachmentBucket = "attachments"
+
+func attachmentsBucket() (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(db, options.GridFSBucket().SetName(attachmentBucket))
+}
+
+// allowedAttachmentTypes is the ATTACHMENT_TYPES list of content types that
+// may be uploaded. Types are sniffed from the file, not taken from the client.
+func allowedAttachmentTypes() map[string]bool {
+	allowed := make(map[string]bool)
+	for _, t := range strings.Split(envString("ATTACHMENT_TYPES", "image/jpeg,image/png,image/gif,image/webp,application/pdf"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			allowed[t] = true
+		}
+	}
+	return allowed
+}
+
+// limitAttachments keeps only the first ATTACHMENT_INLINE_LIMIT attachments in
+// list responses; TotalAttachments still reports them all and the rest are
+// listed by GET /complaints/{id}/attachments. 0 keeps every attachment.
+func limitAttachments(c *models.Complaint) {
+	c.TotalAttachments = len(c.Attachments)
+	if limit := envInt("ATTACHMENT_INLINE_LIMIT", 3); limit > 0 && len(c.Attachments) > limit {
+		c.Attachments = c.Attachments[:limit]
+	}
+}
+
+// attachmentsHandler lists (GET) or uploads (POST, multipart field "file") a
+// complaint's attachments.
+func attachmentsHandler(w http.ResponseWriter, r *http.Request, complaintID primitive.ObjectID) {
+	var complaint models.Complaint
+	err := orgCollection("complaints").FindOne(dbContext(r), bson.M{"_id": complaintID}).Decode(&complaint)
+	if err != nil || !canAccess(r, complaint) {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		attachments := complaint.Attachments
+		if attachments == nil {
+			attachments = []models.Attachment{}
+		}
+		json.NewEncoder(w).Encode(attachments)
+	case http.MethodPost:
+		uploadAttachment(w, r, complaint)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func uploadAttachment(w http.ResponseWriter, r *http.Request, complaint models.Complaint) {
+	maxBytes := int64(envInt("ATTACHMENT_MAX_BYTES", 5<<20))
+	maxCount := envInt("ATTACHMENT_MAX_COUNT", 10)
+	if len(complaint.Attachments) >= maxCount {
+		http.Error(w, fmt.Sprintf("A complaint can have at most %d attachments", maxCount), http.StatusConflict)
+		return
+	}
+
+	// Leave room for the multipart framing around the file itself.
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+1<<20)
+	file, header, err := r.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "Attachment is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		writeFieldError(w, "file", "is required")
+		return
+	}
+	defer file.Close()
+	if header.Size > maxBytes {
+		http.Error(w, "Attachment is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(file, sniff)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		writeFieldError(w, "file", "is empty")
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(sniff[:n]))
+	if !allowedAttachmentTypes()[contentType] {
+		http.Error(w, "Attachment type "+contentType+" is not allowed", http.StatusUnsupportedMediaType)
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	bucket, err := attachmentsBucket()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	attachment := models.Attachment{
+		Filename:    filepath.Base(header.Filename),
+		ContentType: contentType,
+		Size:        header.Size,
+		UploadedBy:  actorName(r),
+		UploadedAt:  time.Now().UTC(),
+	}
+	attachment.ID, err = bucket.UploadFromStream(attachment.Filename, file, options.GridFSUpload().
+		SetMetadata(bson.M{"complaintId": complaint.ID, "contentType": contentType}))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	// The count check is repeated in the filter so concurrent uploads cannot
+	// push a complaint past the limit.
+	res, err := orgCollection("complaints").UpdateOne(dbContext(r),
+		bson.M{"_id": complaint.ID, fmt.Sprintf("attachments.%d", maxCount-1): bson.M{"$exists": false}},
+		bson.M{"$push": bson.M{"attachments": attachment}})
+	if err == nil && res.MatchedCount == 0 {
+		err = errAttachmentLimit
+	}
+	if err != nil {
+		if derr := bucket.Delete(attachment.ID); derr != nil {
+			requestLogger(r).Error("delete orphaned attachment", "attachment_id", attachment.ID.Hex(), "error", derr)
+		}
+		if err == errAttachmentLimit {
+			http.Error(w, fmt.Sprintf("A complaint can have at most %d attachments", maxCount), http.StatusConflict)
+			return
+		}
+		serverError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(attachment)
+}
+
+var errAttachmentLimit = errors.New("attachment limit reached")
+
+func downloadAttachmentHandler(w http.ResponseWriter, r *http.Request, complaintID primitive.ObjectID, attachmentID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fileID, err := primitive.ObjectIDFromHex(attachmentID)
+	if err != nil {
+		writeFieldError(w, "attachmentId", "must be a valid ID")
+		return
+	}
+
+	var complaint models.Complaint
+	err = orgCollection("complaints").FindOne(dbContext(r), bson.M{"_id": complaintID, "attachments._id": fileID}).Decode(&complaint)
+	if err != nil || !canAccess(r, complaint) {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	var attachment models.Attachment
+	for _, a := range complaint.Attachments {
+		if a.ID == fileID {
+			attachment = a
+		}
+	}
+
+	bucket, err := attachmentsBucket()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	stream, err := bucket.OpenDownloadStream(fileID)
+	if err == gridfs.ErrFileNotFound {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer stream.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := io.Copy(w, stream); err != nil {
+		requestLogger(r).Error("stream attachment", "attachment_id", fileID.Hex(), "error", err)
+	}
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+const (
+	auditRegister        = "user.register"
+	auditUpdateProfile   = "user.update_profile"
+	auditSetRole         = "user.set_role"
+	auditRequestErasure  = "user.request_erasure"
+	auditCancelErasure   = "user.cancel_erasure"
+	auditRotateCode      = "user.rotate_code"
+	auditVerifyEmail     = "user.verify_email"
+	auditLinkIdentity    = "user.link_identity"
+	auditVerifyPhone     = "user.verify_phone"
+	auditAcceptInvite    = "user.accept_invite"
+	auditCreateAdmin     = "user.create_admin"
+	auditImportUser      = "user.import"
+	auditResetCode       = "user.reset_code"
+	auditSubmit          = "complaint.submit"
+	auditResolve         = "complaint.resolve"
+	auditTransition      = "complaint.transition"
+	auditAssign          = "complaint.assign"
+	auditTag             = "complaint.tag"
+	auditDelete          = "complaint.delete"
+	auditRestore         = "complaint.restore"
+	auditModerate        = "complaint.moderate"
+	auditEdit            = "complaint.edit"
+	auditPurge           = "complaint.purge"
+	auditImportComplaint = "complaint.import"
+	auditMerge           = "complaint.merge"
+	auditLink            = "complaint.link"
+	auditUnlink          = "complaint.unlink"
+	auditCreateAPIKey    = "api_key.create"
+	auditRevokeAPIKey    = "api_key.revoke"
+
+	auditTargetUser      = "user"
+	auditTargetComplaint = "complaint"
+	auditTargetAPIKey    = "api_key"
+)
+
+// auditSkippedFields are left out of diffs: secrets must never be copied into
+// the log, and history, user complaint lists and stored submission responses
+// are large and already kept elsewhere. updatedAt changes with every write.
+var auditSkippedFields = map[string]bool{
+	"secretCode":         true,
+	"secretCodeHash":     true,
+	"keyHash":            true,
+	"history":            true,
+	"complaints":         true,
+	"idempotentResponse": true,
+	"updatedAt":          true,
+}
+
+// recordAudit stores an audit_logs entry for a mutation. before and after are
+// the target document on either side of it; pass nil for one that did not
+// exist. A failed write is logged rather than failing the request, since the
+// change itself has already happened.
+func recordAudit(r *http.Request, action, targetType string, targetID primitive.ObjectID, before, after interface{}) {
+	org, _ := callerOrg(r)
+	entry := models.AuditLog{
+		Actor:      actorName(r),
+		ActorRole:  callerRole(r),
+		OrgID:      org,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Changes:    auditDiff(before, after),
+		At:         time.Now().UTC(),
+	}
+	if _, err := orgCollection("audit_logs").InsertOne(dbContext(r), entry); err != nil {
+		requestLogger(r).Error("record audit log", "action", action, "target_type", targetType, "target_id", targetID.Hex(), "error", err)
+	}
+}
+
+// auditDiff compares two documents field by field in their stored form.
+func auditDiff(before, after interface{}) map[string]models.FieldChange {
+	b, a := auditFields(before), auditFields(after)
+	changes := make(map[string]models.FieldChange)
+	for k, v := range b {
+		if !sameBSON(v, a[k]) {
+			changes[k] = models.FieldChange{Before: v, After: a[k]}
+		}
+	}
+	for k, v := range a {
+		if _, seen := b[k]; !seen && v != nil {
+			changes[k] = models.FieldChange{After: v}
+		}
+	}
+	return changes
+}
+
+func auditFields(doc interface{}) bson.M {
+	fields := bson.M{}
+	if doc == nil {
+		return fields
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return fields
+	}
+	if err := bson.Unmarshal(raw, &fields); err != nil {
+		return fields
+	}
+	for k := range auditSkippedFields {
+		delete(fields, k)
+	}
+	return fields
+}
+
+func sameBSON(x, y interface{}) bool {
+	bx, errx := bson.Marshal(bson.M{"v": x})
+	by, erry := bson.Marshal(bson.M{"v": y})
+	return errx == nil && erry == nil && bytes.Equal(bx, by)
+}
+
+type auditEntry struct {
+	TargetID primitive.ObjectID `bson:"targetId" json:"targetId"`
+	Action   string             `bson:"action" json:"action"`
+	From     string             `bson:"from" json:"from"`
+	Actor    string             `bson:"actor" json:"actor"`
+	At       time.Time          `bson:"at" json:"at"`
+}
+
+type auditLogResponse struct {
+	Page     int          `json:"page"`
+	PageSize int          `json:"pageSize"`
+	Total    int          `json:"total"`
+	Entries  []auditEntry `json:"entries"`
+}
+
+func parseTimeParam(r *http.Request, key string) (time.Time, bool, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return time.Time{}, false, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t, err == nil, err
+}
+
+// auditLogHandler flattens complaint status histories into a single log,
+// filterable by targetId, action (the status moved to), actor and an
+// RFC3339 from/to range, newest first.
+func auditLogHandler(w http.ResponseWriter, r *http.Request) {
+	var errs validationErrors
+	page, pageSize := pageParams(r, 50, 500, &errs)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	pre := bson.M{"history.0": bson.M{"$exists": true}}
+	if v := r.URL.Query().Get("targetId"); v != "" {
+		oid, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			writeFieldError(w, "targetId", "must be a valid ID")
+			return
+		}
+		pre["_id"] = oid
+	}
+
+	post := bson.M{}
+	if v := r.URL.Query().Get("action"); v != "" {
+		post["action"] = v
+	}
+	if v := r.URL.Query().Get("actor"); v != "" {
+		post["actor"] = v
+	}
+	at := bson.M{}
+	for param, op := range map[string]string{"from": "$gte", "to": "$lte"} {
+		t, ok, err := parseTimeParam(r, param)
+		if err != nil {
+			writeFieldError(w, param, "must be an RFC3339 time")
+			return
+		}
+		if ok {
+			at[op] = t
+		}
+	}
+	if len(at) > 0 {
+		post["at"] = at
+	}
+
+	pipeline := bson.A{
+		bson.M{"$match": pre},
+		bson.M{"$unwind": "$history"},
+		bson.M{"$project": bson.M{
+			"_id":      0,
+			"targetId": "$_id",
+			"action":   "$history.to",
+			"from":     "$history.from",
+			"actor":    "$history.changedBy",
+			"at":       "$history.changedAt",
+		}},
+		bson.M{"$match": post},
+		bson.M{"$sort": bson.D{{Key: "at", Value: -1}, {Key: "targetId", Value: 1}}},
+		bson.M{"$facet": bson.M{
+			"entries": bson.A{bson.M{"$skip": (page - 1) * pageSize}, bson.M{"$limit": pageSize}},
+			"total":   bson.A{bson.M{"$count": "n"}},
+		}},
+	}
+	cursor, err := orgCollection("complaints").Aggregate(dbContext(r), pipeline)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer cursor.Close(dbContext(r))
+
+	var facets []struct {
+		Entries []auditEntry `bson:"entries"`
+		Total   []struct {
+			N int `bson:"n"`
+		} `bson:"total"`
+	}
+	if err := cursor.All(dbContext(r), &facets); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	resp := auditLogResponse{Page: page, PageSize: pageSize, Entries: []auditEntry{}}
+	if len(facets) > 0 {
+		resp.Entries = append(resp.Entries, facets[0].Entries...)
+		if len(facets[0].Total) > 0 {
+			resp.Total = facets[0].Total[0].N
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+type auditLogsResponse struct {
+	Page     int               `json:"page"`
+	PageSize int               `json:"pageSize"`
+	Total    int64             `json:"total"`
+	Entries  []models.AuditLog `json:"entries"`
+}
+
+// auditLogsHandler pages through audit_logs newest first, filterable by
+// action, actor, targetType, targetId and an RFC3339 from/to range.
+func auditLogsHandler(w http.ResponseWriter, r *http.Request) {
+	var errs validationErrors
+	page, pageSize := pageParams(r, 50, 500, &errs)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	filter := bson.M{}
+	for _, param := range []string{"action", "actor", "targetType"} {
+		if v := r.URL.Query().Get(param); v != "" {
+			filter[param] = v
+		}
+	}
+	if v := r.URL.Query().Get("targetId"); v != "" {
+		oid, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			writeFieldError(w, "targetId", "must be a valid ID")
+			return
+		}
+		filter["targetId"] = oid
+	}
+	at := bson.M{}
+	for param, op := range map[string]string{"from": "$gte", "to": "$lte"} {
+		t, ok, err := parseTimeParam(r, param)
+		if err != nil {
+			writeFieldError(w, param, "must be an RFC3339 time")
+			return
+		}
+		if ok {
+			at[op] = t
+		}
+	}
+	if len(at) > 0 {
+		filter["at"] = at
+	}
+
+	total, err := orgCollection("audit_logs").CountDocuments(dbContext(r), filter)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	cursor, err := orgCollection("audit_logs").Find(dbContext(r), filter, options.Find().
+		SetSort(bson.D{{Key: "at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page-1)*pageSize)).
+		SetLimit(int64(pageSize)))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	resp := auditLogsResponse{Page: page, PageSize: pageSize, Total: total, Entries: []models.AuditLog{}}
+	if err := cursor.All(dbContext(r), &resp.Entries); err != nil {
+		serverError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
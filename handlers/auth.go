@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"complain/models"
+)
+
+const (
+	roleAdmin   = "admin"
+	roleManager = "manager"
+	roleAgent   = "agent"
+	roleUser    = "user"
+)
+
+// staffRoles may work on complaints other than their own.
+var staffRoles = []string{roleAdmin, roleManager, roleAgent}
+
+func isStaffRole(role string) bool {
+	for _, r := range staffRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// userRole defaults to user for accounts created before roles existed.
+func userRole(u models.User) string {
+	if u.Role == "" {
+		return roleUser
+	}
+	return u.Role
+}
+
+type staffMember struct {
+	Name string
+	Role string
+	Org  string
+}
+
+// adminTokens maps staff tokens to who holds them. It is loaded from
+// ADMIN_TOKENS, a comma-separated list of name:token[:role[:org]] entries
+// where role defaults to admin and org to none.
+var adminTokens map[string]staffMember
+
+func initAdminTokens() {
+	adminTokens = make(map[string]staffMember)
+	for _, entry := range strings.Split(envString("ADMIN_TOKENS", ""), ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			continue
+		}
+		member := staffMember{Name: parts[0], Role: roleAdmin}
+		if len(parts) > 2 && parts[2] != "" {
+			member.Role = parts[2]
+		}
+		if len(parts) > 3 {
+			member.Org = parts[3]
+		}
+		adminTokens[parts[1]] = member
+	}
+}
+
+// staffIdentity returns the staff member whose token was sent in the
+// X-Admin-Token header, or the signed-in user if they hold a staff role. Users
+// are identified by email.
+func staffIdentity(r *http.Request) (staffMember, bool) {
+	if member, ok := currentAPIKey(r); ok {
+		return member, true
+	}
+	if user, ok := currentTokenUser(r); ok && isStaffRole(user.Role) {
+		return staffMember{Name: user.Email, Role: user.Role, Org: user.OrgID}, true
+	}
+	sent := r.Header.Get("X-Admin-Token")
+	if sent == "" {
+		return staffMember{}, false
+	}
+	for token, member := range adminTokens {
+		if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) == 1 {
+			return member, true
+		}
+	}
+	return staffMember{}, false
+}
+
+func adminIdentity(r *http.Request) (string, bool) {
+	member, ok := staffIdentity(r)
+	return member.Name, ok
+}
+
+// canApprove reports whether the caller may sign off on resolutions.
+func canApprove(r *http.Request) bool {
+	member, ok := staffIdentity(r)
+	return ok && (member.Role == roleManager || member.Role == roleAdmin)
+}
+
+// callerOrg is the organization the caller's queries are confined to. ok is
+// false for callers who see every organization: admins outside any
+// organization, and anonymous callers, who only reach complaints by ID or
+// through public endpoints.
+func callerOrg(r *http.Request) (org string, ok bool) {
+	if member, ok := staffIdentity(r); ok {
+		if member.Role == roleAdmin && member.Org == "" {
+			return "", false
+		}
+		return member.Org, true
+	}
+	if user, ok := currentTokenUser(r); ok {
+		return user.OrgID, true
+	}
+	return "", false
+}
+
+// platformAdmin reports whether the caller is an admin outside any
+// organization, who alone may change settings every organization shares.
+func platformAdmin(r *http.Request) bool {
+	member, ok := staffIdentity(r)
+	return ok && member.Role == roleAdmin && member.Org == ""
+}
+
+// requirePlatformAdmin guards settings shared by every organization.
+// Organization admins get 403.
+func requirePlatformAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return requireRole(roleAdmin)(func(w http.ResponseWriter, r *http.Request) {
+		if !platformAdmin(r) {
+			http.Error(w, "Requires a platform admin", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// callerRole is the role of whoever made the request, or "" if anonymous.
+func callerRole(r *http.Request) string {
+	if member, ok := staffIdentity(r); ok {
+		return member.Role
+	}
+	if user, ok := currentTokenUser(r); ok {
+		return user.Role
+	}
+	return ""
+}
+
+// requireRole only lets callers holding one of the roles through. Anonymous
+// callers get 401 and authenticated callers with another role 403.
+func requireRole(roles ...string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			role := callerRole(r)
+			if role == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "Authentication required", http.StatusUnauthorized)
+				return
+			}
+			for _, allowed := range roles {
+				if role == allowed {
+					next(w, r)
+					return
+				}
+			}
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		}
+	}
+}
+
+// canAccess reports whether the caller may see or act on a complaint: staff
+// may touch any complaint, users only their own. Soft-deleted complaints are
+// left to admins.
+func canAccess(r *http.Request, c models.Complaint) bool {
+	if c.DeletedAt != nil {
+		return callerRole(r) == roleAdmin
+	}
+	if _, ok := staffIdentity(r); ok {
+		return true
+	}
+	userID, ok := currentUserID(r)
+	return ok && userID == c.UserID
+}
+
+func setUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("userId"))
+	if err != nil {
+		writeFieldError(w, "userId", "must be a valid ID")
+		return
+	}
+	role := r.URL.Query().Get("role")
+	if role != roleUser && !isStaffRole(role) {
+		writeFieldError(w, "role", "must be one of user, agent, manager, admin")
+		return
+	}
+
+	var user models.User
+	err = orgCollection("users").FindOneAndUpdate(dbContext(r),
+		bson.M{"_id": oid},
+		bson.M{"$set": bson.M{"role": role}},
+	).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	recordAudit(r, auditSetRole, auditTargetUser, oid, bson.M{"role": user.Role}, bson.M{"role": role})
+
+	user.Role = role
+	json.NewEncoder(w).Encode(user)
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"complain/models"
+	"complain/store"
+)
+
+const systemActor = "system"
+
+// registerAutoResolve schedules resolving open low-priority complaints with
+// no activity for AUTO_RESOLVE_AFTER. It only runs when AUTO_RESOLVE_ENABLED
+// is set, and with AUTO_RESOLVE_DRY_RUN it logs what it would do instead.
+func registerAutoResolve() {
+	if !envBool("AUTO_RESOLVE_ENABLED", false) {
+		return
+	}
+	after := envDuration("AUTO_RESOLVE_AFTER", 30*24*time.Hour)
+	dryRun := envBool("AUTO_RESOLVE_DRY_RUN", false)
+	registerJob("auto-resolve", envDuration("AUTO_RESOLVE_INTERVAL", time.Hour), func(ctx context.Context) error {
+		return resolveStaleComplaints(ctx, after, dryRun)
+	})
+}
+
+func staleFilter(cutoff time.Time) bson.M {
+	return bson.M{
+		"resolved":  false,
+		"priority":  "low",
+		"createdAt": bson.M{"$lt": cutoff},
+		"history":   bson.M{"$not": bson.M{"$elemMatch": bson.M{"changedAt": bson.M{"$gte": cutoff}}}},
+		"deletedAt": store.NotDeleted(),
+	}
+}
+
+func resolveStaleComplaints(ctx context.Context, after time.Duration, dryRun bool) error {
+	cutoff := time.Now().Add(-after)
+	cursor, err := orgCollection("complaints").Find(ctx, staleFilter(cutoff))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	note := fmt.Sprintf("Automatically resolved after %s without activity.", after)
+	for cursor.Next(ctx) {
+		var c models.Complaint
+		if err := cursor.Decode(&c); err != nil {
+			return err
+		}
+		if dryRun {
+			log.Printf("auto-resolve (dry run): would resolve complaint %s", c.ID.Hex())
+			continue
+		}
+
+		now := time.Now().UTC()
+		change := models.StatusChange{From: c.CurrentStatus(), To: models.StatusResolved, ChangedBy: systemActor, ChangedAt: now}
+		filter := staleFilter(cutoff)
+		filter["_id"] = c.ID
+		err := updateComplaint(ctx, eventComplaintResolved, filter, bson.M{
+			"$set": bson.M{
+				"resolved":       true,
+				"status":         models.StatusResolved,
+				"resolvedBy":     systemActor,
+				"resolvedAt":     now,
+				"resolutionNote": note,
+			},
+			"$push": bson.M{"history": change},
+			"$inc":  bson.M{"version": 1},
+		}, &c)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			log.Printf("auto-resolve complaint %s: %v", c.ID.Hex(), err)
+			continue
+		}
+		log.Printf("auto-resolved complaint %s", c.ID.Hex())
+		go notifyOwner(c, templateResolution, note)
+		complaintsResolvedTotal.Inc()
+		go publishEvent(eventComplaintResolved, c)
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+const avatarBucket = "avatars"
+
+var avatarTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true, "image/webp": true}
+
+func avatarsBucket() (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(db, options.GridFSBucket().SetName(avatarBucket))
+}
+
+func avatarURL(userID primitive.ObjectID) string {
+	return apiPrefix + "/users/" + userID.Hex() + "/avatar"
+}
+
+// avatarOwners looks up which of the users matching filter have an avatar.
+// Avatars are decoration, so a failed lookup is logged and shows none.
+func avatarOwners(r *http.Request, filter bson.M) []models.User {
+	filter["avatarId"] = bson.M{"$exists": true}
+	cursor, err := orgCollection("users").Find(dbContext(r), filter, options.Find().SetProjection(bson.M{"_id": 1, "email": 1}))
+	if err == nil {
+		var users []models.User
+		if err = cursor.All(dbContext(r), &users); err == nil {
+			return users
+		}
+	}
+	requestLogger(r).Error("look up avatars", "error", err)
+	return nil
+}
+
+// addReporterAvatars sets ReporterAvatarURL on complaints whose reporter has
+// an avatar.
+func addReporterAvatars(r *http.Request, complaints []models.Complaint) {
+	var ids []primitive.ObjectID
+	for _, c := range complaints {
+		if !c.UserID.IsZero() {
+			ids = append(ids, c.UserID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	has := map[primitive.ObjectID]bool{}
+	for _, u := range avatarOwners(r, bson.M{"_id": bson.M{"$in": ids}}) {
+		has[u.ID] = true
+	}
+	for i := range complaints {
+		if has[complaints[i].UserID] {
+			complaints[i].ReporterAvatarURL = avatarURL(complaints[i].UserID)
+		}
+	}
+}
+
+// addAuthorAvatars sets AuthorAvatarURL on comments whose author is a user
+// with an avatar. Comments name their author by email.
+func addAuthorAvatars(r *http.Request, comments []models.Comment) {
+	var emails []string
+	for _, c := range comments {
+		emails = append(emails, c.Author)
+	}
+	if len(emails) == 0 {
+		return
+	}
+	byEmail := map[string]primitive.ObjectID{}
+	for _, u := range avatarOwners(r, bson.M{"email": bson.M{"$in": emails}}) {
+		byEmail[u.Email] = u.ID
+	}
+	for i := range comments {
+		if id, ok := byEmail[comments[i].Author]; ok {
+			comments[i].AuthorAvatarURL = avatarURL(id)
+		}
+	}
+}
+
+// avatarHandler serves a user's avatar to any signed-in caller.
+func avatarHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := primitive.ObjectIDFromHex(r.URL.Query().Get("userId"))
+	if err != nil {
+		writeFieldError(w, "userId", "must be a valid ID")
+		return
+	}
+	var user models.User
+	err = orgCollection("users").FindOne(dbContext(r), bson.M{"_id": userID}).Decode(&user)
+	if err != nil || user.AvatarID == nil {
+		http.Error(w, "Avatar not found", http.StatusNotFound)
+		return
+	}
+
+	bucket, err := avatarsBucket()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	stream, err := bucket.OpenDownloadStream(*user.AvatarID)
+	if err == gridfs.ErrFileNotFound {
+		http.Error(w, "Avatar not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer stream.Close()
+
+	contentType := "application/octet-stream"
+	var meta struct {
+		ContentType string `bson:"contentType"`
+	}
+	if raw := stream.GetFile().Metadata; raw != nil && bson.Unmarshal(raw, &meta) == nil && meta.ContentType != "" {
+		contentType = meta.ContentType
+	}
+	// A new avatar gets a new file ID, so the ETag changes with it.
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+user.AvatarID.Hex()+`"`)
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if strings.Contains(r.Header.Get("If-None-Match"), user.AvatarID.Hex()) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if _, err := io.Copy(w, stream); err != nil {
+		requestLogger(r).Error("stream avatar", "user_id", userID.Hex(), "error", err)
+	}
+}
+
+// myAvatarHandler replaces (PUT, multipart field "file") or removes (DELETE)
+// the caller's avatar. Images are limited to AVATAR_MAX_BYTES.
+func myAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := currentUserID(r)
+	switch r.Method {
+	case http.MethodPut:
+		uploadAvatar(w, r, userID)
+	case http.MethodDelete:
+		var before models.User
+		err := orgCollection("users").FindOneAndUpdate(dbContext(r),
+			bson.M{"_id": userID}, bson.M{"$unset": bson.M{"avatarId": ""}}).Decode(&before)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		deleteAvatarFile(r, before.AvatarID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func uploadAvatar(w http.ResponseWriter, r *http.Request, userID primitive.ObjectID) {
+	maxBytes := int64(envInt("AVATAR_MAX_BYTES", 1<<20))
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+1<<20)
+	file, header, err := r.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "Avatar is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		writeFieldError(w, "file", "is required")
+		return
+	}
+	defer file.Close()
+	if header.Size > maxBytes {
+		http.Error(w, "Avatar is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(file, sniff)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		writeFieldError(w, "file", "is empty")
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(sniff[:n]))
+	if !avatarTypes[contentType] {
+		http.Error(w, "Avatar must be a JPEG, PNG, GIF or WebP image", http.StatusUnsupportedMediaType)
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	bucket, err := avatarsBucket()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	fileID, err := bucket.UploadFromStream(userID.Hex(), file, options.GridFSUpload().
+		SetMetadata(bson.M{"userId": userID, "contentType": contentType}))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	var before models.User
+	err = orgCollection("users").FindOneAndUpdate(dbContext(r),
+		bson.M{"_id": userID}, bson.M{"$set": bson.M{"avatarId": fileID}}).Decode(&before)
+	if err != nil {
+		deleteAvatarFile(r, &fileID)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		serverError(w, err)
+		return
+	}
+	deleteAvatarFile(r, before.AvatarID)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(avatarResponse{URL: avatarURL(userID)})
+}
+
+type avatarResponse struct {
+	URL string `json:"url"`
+}
+
+func deleteAvatarFile(r *http.Request, id *primitive.ObjectID) {
+	if id == nil {
+		return
+	}
+	bucket, err := avatarsBucket()
+	if err == nil {
+		err = bucket.Delete(*id)
+	}
+	if err != nil && err != gridfs.ErrFileNotFound {
+		requestLogger(r).Error("delete avatar", "file_id", id.Hex(), "error", err)
+	}
+}
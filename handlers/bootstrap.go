@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/migrations"
+)
+
+type indexSpec struct {
+	Collection string
+	Model      mongo.IndexModel
+}
+
+// schemaIndexes lists every index the handlers rely on. Creating an index
+// that already exists with the same keys and options is a no-op, so these
+// are applied on every start.
+var schemaIndexes = []indexSpec{
+	{"users", mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}},
+	// Login looks users up by secret code alone, so two users sharing one
+	// would be indistinguishable.
+	{"users", mongo.IndexModel{
+		Keys: bson.D{{Key: "secretCodeHash", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"secretCodeHash": bson.M{"$type": "string"}}),
+	}},
+	{"users", mongo.IndexModel{
+		Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"identities.subject": bson.M{"$type": "string"}}),
+	}},
+	{"users", mongo.IndexModel{Keys: bson.D{{Key: "orgId", Value: 1}, {Key: "role", Value: 1}}}},
+	{"oidcStates", mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}},
+	{"complaints", mongo.IndexModel{
+		Keys: bson.D{{Key: "refNumber", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"refNumber": bson.M{"$type": "number"}}),
+	}},
+	{"complaints", mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "idempotencyKey", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"idempotencyKey": bson.M{"$type": "string"}}),
+	}},
+	{"complaints", mongo.IndexModel{
+		Keys: bson.D{{Key: "trackingTokenHash", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"trackingTokenHash": bson.M{"$type": "string"}}),
+	}},
+	// The idempotency index is partial, so it cannot serve plain userId
+	// queries such as a user's complaint list.
+	{"complaints", mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}},
+	}},
+	{"complaints", mongo.IndexModel{
+		Keys:    bson.D{{Key: "title", Value: "text"}, {Key: "summary", Value: "text"}},
+		Options: options.Index().SetName("complaint_text").SetWeights(bson.M{"title": 3, "summary": 1}),
+	}},
+	{"complaints", mongo.IndexModel{
+		Keys: bson.D{{Key: "category", Value: 1}, {Key: "resolved", Value: 1}, {Key: "createdAt", Value: 1}},
+	}},
+	{"complaints", mongo.IndexModel{
+		Keys: bson.D{{Key: "assignedTo", Value: 1}, {Key: "createdAt", Value: -1}},
+	}},
+	{"complaints", mongo.IndexModel{
+		Keys: bson.D{{Key: "orgId", Value: 1}, {Key: "createdAt", Value: -1}},
+	}},
+	{"complaints", mongo.IndexModel{
+		Keys: bson.D{{Key: "moderation.flaggedAt", Value: 1}},
+		Options: options.Index().
+			SetPartialFilterExpression(bson.M{"flagged": true}),
+	}},
+	{"comments", mongo.IndexModel{
+		Keys: bson.D{{Key: "complaintId", Value: 1}, {Key: "parentId", Value: 1}, {Key: "createdAt", Value: 1}},
+	}},
+	{"webhookDeliveries", mongo.IndexModel{
+		Keys: bson.D{{Key: "webhookId", Value: 1}, {Key: "at", Value: -1}},
+	}},
+	{"complaints", mongo.IndexModel{Keys: bson.D{{Key: "resolved", Value: 1}, {Key: "updatedAt", Value: 1}}}},
+	{"complaints_archive", mongo.IndexModel{Keys: bson.D{{Key: "orgId", Value: 1}, {Key: "archivedAt", Value: -1}}}},
+	{"complaints_archive", mongo.IndexModel{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}}}},
+	{"complaint_revisions", mongo.IndexModel{Keys: bson.D{{Key: "complaintId", Value: 1}, {Key: "revision", Value: 1}}}},
+	{"notifications", mongo.IndexModel{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "read", Value: 1}, {Key: "createdAt", Value: -1}}}},
+	{"notifications", mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}},
+	{"phoneVerifications", mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}},
+	{"audit_logs", mongo.IndexModel{Keys: bson.D{{Key: "at", Value: -1}}}},
+	{"api_keys", mongo.IndexModel{
+		Keys:    bson.D{{Key: "keyHash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}},
+	{"audit_logs", mongo.IndexModel{Keys: bson.D{{Key: "targetId", Value: 1}, {Key: "at", Value: -1}}}},
+	{"refreshTokens", mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}},
+	{"sessions", mongo.IndexModel{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "lastSeenAt", Value: -1}}}},
+	{"sessions", mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}},
+	{"refreshTokens", mongo.IndexModel{Keys: bson.D{{Key: "sessionId", Value: 1}}}},
+	{"emailVerifications", mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}},
+	{"orgInvites", mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}},
+	{"savedSearches", mongo.IndexModel{Keys: bson.D{{Key: "owner", Value: 1}, {Key: "name", Value: 1}}}},
+}
+
+// bootstrapSchema creates the indexes in schemaIndexes. A failure, such as
+// existing duplicates blocking a unique index, is logged rather than fatal;
+// /readyz keeps the instance out of rotation while a required index is
+// missing.
+func bootstrapSchema() {
+	ctx, cancel := context.WithTimeout(context.Background(), envDuration("SCHEMA_BOOTSTRAP_TIMEOUT", time.Minute))
+	defer cancel()
+
+	for _, spec := range schemaIndexes {
+		if err := documents.EnsureIndex(ctx, spec.Collection, spec.Model); err != nil {
+			log.Printf("create index on %s %v: %v", spec.Collection, spec.Model.Keys, err)
+		}
+	}
+}
+
+// registerExpiry deletes documents past their TTL index once a minute on
+// stores other than Mongo, where the server does not do it.
+func registerExpiry() {
+	expirer, ok := documents.(interface {
+		ExpireDocuments(ctx context.Context) (int64, error)
+	})
+	if !ok {
+		return
+	}
+	registerJob("ttl-expiry", time.Minute, func(ctx context.Context) error {
+		_, err := expirer.ExpireDocuments(ctx)
+		return err
+	})
+}
+
+// migrateOnStart applies pending schema migrations before the indexes, some
+// of which rely on migrated fields. They rewrite what earlier versions left
+// in MongoDB; the PostgreSQL store has only ever written the current shape.
+// With MIGRATE_ON_START off it only warns, leaving them to `complain migrate`.
+func migrateOnStart() {
+	ctx, cancel := context.WithTimeout(context.Background(), envDuration("MIGRATION_TIMEOUT", 10*time.Minute))
+	defer cancel()
+
+	if !envBool("MIGRATE_ON_START", true) {
+		pending, err := migrations.Pending(ctx, db)
+		if err != nil {
+			log.Printf("check schema migrations: %v", err)
+		} else if len(pending) > 0 {
+			log.Printf("%d schema migrations are pending; run complain migrate up", len(pending))
+		}
+		return
+	}
+	if err := migrations.Up(ctx, db, 0); err != nil {
+		log.Fatalf("schema migrations: %v", err)
+	}
+}
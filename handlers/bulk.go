@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+	"complain/store"
+)
+
+const (
+	bulkResolve = "resolve"
+	bulkClose   = "close"
+	bulkAssign  = "assign"
+	bulkTag     = "tag"
+)
+
+type bulkRequest struct {
+	IDs    []string `json:"ids"`
+	Action string   `json:"action"`          // resolve, close, assign or tag
+	Agent  string   `json:"agent,omitempty"` // for assign; empty unassigns
+	Tags   []string `json:"tags,omitempty"`  // for tag
+}
+
+type bulkItemResult struct {
+	ID    string `json:"id"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type bulkResult struct {
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+	Results   []bulkItemResult `json:"results"`
+}
+
+// bulkComplaintsHandler applies one action to many complaints, one update
+// each, and reports the outcome for each ID. Each complaint is updated only
+// if it is still at the version it was loaded at, so a complaint changed
+// concurrently fails on its own without affecting the others.
+func bulkComplaintsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req bulkRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	applyBulk(w, r, req)
+}
+
+func applyBulk(w http.ResponseWriter, r *http.Request, req bulkRequest) {
+	var errs validationErrors
+	switch req.Action {
+	case bulkResolve, bulkClose:
+	case bulkAssign:
+		req.Agent = strings.TrimSpace(req.Agent)
+	case bulkTag:
+		var ok bool
+		if req.Tags, ok = normalizeTags(req.Tags); !ok {
+			errs.add("tags", "contains a tag that is too long")
+		} else if len(req.Tags) == 0 {
+			errs.add("tags", "is required")
+		}
+	default:
+		errs.add("action", "must be resolve, close, assign or tag")
+	}
+	if len(req.IDs) == 0 {
+		errs.add("ids", "is required")
+	} else if max := envInt("BULK_MAX_IDS", 1000); len(req.IDs) > max {
+		errs.add("ids", "has too many entries")
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	if req.Action == bulkResolve && envBool("RESOLUTION_APPROVAL", false) && !canApprove(r) {
+		http.Error(w, "Resolutions require manager approval", http.StatusForbidden)
+		return
+	}
+	if req.Action == bulkAssign && req.Agent != "" {
+		ok, err := isStaffMember(dbContext(r), req.Agent)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if !ok {
+			writeFieldError(w, "agent", "is not a staff member of your organization")
+			return
+		}
+	}
+
+	results := make([]bulkItemResult, len(req.IDs))
+	index := map[primitive.ObjectID][]int{}
+	var oids []primitive.ObjectID
+	for i, id := range req.IDs {
+		results[i].ID = id
+		oid, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			results[i].Error = "invalid ID"
+			continue
+		}
+		if _, seen := index[oid]; !seen {
+			oids = append(oids, oid)
+		}
+		index[oid] = append(index[oid], i)
+	}
+	fail := func(oid primitive.ObjectID, msg string) {
+		for _, i := range index[oid] {
+			results[i].Error = msg
+		}
+	}
+
+	befores := map[primitive.ObjectID]models.Complaint{}
+	if len(oids) > 0 {
+		cursor, err := orgCollection("complaints").Find(dbContext(r),
+			bson.M{"_id": bson.M{"$in": oids}, "deletedAt": store.NotDeleted()})
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		var found []models.Complaint
+		if err := cursor.All(dbContext(r), &found); err != nil {
+			serverError(w, err)
+			return
+		}
+		for _, c := range found {
+			befores[c.ID] = c
+		}
+	}
+
+	now := time.Now().UTC()
+	actor := actorName(r)
+	type pendingUpdate struct {
+		oid            primitive.ObjectID
+		filter, update bson.M
+	}
+	var updates []pendingUpdate
+	done := map[primitive.ObjectID]bool{}
+	for _, oid := range oids {
+		c, ok := befores[oid]
+		if !ok {
+			fail(oid, "not found")
+			continue
+		}
+		filter := bson.M{"_id": oid}
+		var update bson.M
+		switch req.Action {
+		case bulkResolve, bulkClose:
+			to := models.StatusResolved
+			if req.Action == bulkClose {
+				to = models.StatusClosed
+			}
+			from := c.CurrentStatus()
+			if from == to {
+				done[oid] = true
+				continue
+			}
+			allowed, ok := transitions[from][to]
+			if !ok || !allowed(r, c) {
+				fail(oid, "cannot move from "+from+" to "+to)
+				continue
+			}
+			update = transitionUpdate(models.StatusChange{From: from, To: to, ChangedBy: actor, ChangedAt: now})
+			withVersion(filter, update, c.Version)
+		case bulkAssign:
+			if c.AssignedTo == req.Agent {
+				done[oid] = true
+				continue
+			}
+			update = bson.M{"$push": bson.M{"assignments": models.Assignment{From: c.AssignedTo, To: req.Agent, By: actor, At: now}}}
+			if req.Agent == "" {
+				update["$unset"] = bson.M{"assignedTo": ""}
+			} else {
+				update["$set"] = bson.M{"assignedTo": req.Agent}
+			}
+			withVersion(filter, update, c.Version)
+		case bulkTag:
+			update = bson.M{"$addToSet": bson.M{"tags": bson.M{"$each": req.Tags}}}
+		}
+		updates = append(updates, pendingUpdate{oid, filter, update})
+	}
+
+	// Each update reports whether it matched, so a complaint skipped by its
+	// version filter is told apart from one that was changed.
+	var events []outboxEvent
+	for _, u := range updates {
+		var c models.Complaint
+		err := orgCollection("complaints").FindOneAndUpdate(dbContext(r), u.filter, u.update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&c)
+		var we mongo.WriteException
+		switch {
+		case err == mongo.ErrNoDocuments:
+			fail(u.oid, "modified by another request")
+			continue
+		case errors.As(err, &we) && len(we.WriteErrors) > 0:
+			fail(u.oid, we.WriteErrors[0].Message)
+			continue
+		case err != nil:
+			requestLogger(r).Error("bulk update", "complaint_id", u.oid.Hex(), "error", err)
+			fail(u.oid, "could not be updated")
+			continue
+		}
+		done[u.oid] = true
+		if event := bulkEvent(req.Action, befores[u.oid], c); event != "" {
+			events = append(events, outboxEntry(event, c))
+		}
+		bulkFollowUp(r, req.Action, befores[u.oid], c)
+	}
+	if len(events) > 0 {
+		// The updates stay out of a transaction so one failed item does not
+		// undo the rest; their events are stored right after them instead.
+		err := withOutbox(dbContext(r), func(context.Context) ([]outboxEvent, error) {
+			return events, nil
+		})
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+	}
+
+	resp := bulkResult{Results: results}
+	for i := range results {
+		oid, _ := primitive.ObjectIDFromHex(results[i].ID)
+		if results[i].Error == "" && !done[oid] {
+			results[i].Error = "not found"
+		}
+		if results[i].OK = results[i].Error == ""; results[i].OK {
+			resp.Succeeded++
+		} else {
+			resp.Failed++
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// isStaffMember reports whether name is a staff member complaints can be
+// assigned to: a user with a staff role in the caller's organization, or
+// the holder of a staff token there.
+func isStaffMember(ctx context.Context, name string) (bool, error) {
+	org, bound := store.OrgFrom(ctx)
+	for _, member := range adminTokens {
+		if member.Name == name && (!bound || member.Org == org) {
+			return true, nil
+		}
+	}
+	n, err := orgCollection("users").CountDocuments(ctx,
+		bson.M{"email": name, "role": bson.M{"$in": staffRoles}}, options.Count().SetLimit(1))
+	return n > 0, err
+}
+
+// bulkEvent returns the event an applied bulk change is published as, if any.
+func bulkEvent(action string, before, after models.Complaint) string {
+	switch action {
+	case bulkResolve:
+		return eventComplaintResolved
+	case bulkClose:
+		return eventComplaintStatus
+	case bulkAssign:
+		if after.AssignedTo != "" {
+			return eventComplaintAssigned
+		}
+	case bulkTag:
+		if len(after.Tags) != len(before.Tags) {
+			return eventComplaintEdited
+		}
+	}
+	return ""
+}
+
+// bulkFollowUp audits one applied change and sends the events and
+// notifications the single-complaint endpoints would.
+func bulkFollowUp(r *http.Request, action string, before, after models.Complaint) {
+	switch action {
+	case bulkResolve:
+		recordAudit(r, auditResolve, auditTargetComplaint, after.ID, before, after)
+		complaintsResolvedTotal.Inc()
+		go publishEvent(eventComplaintResolved, after)
+		go notifyOwner(after, templateResolution, "")
+	case bulkClose:
+		recordAudit(r, auditTransition, auditTargetComplaint, after.ID, before, after)
+		go publishEvent(eventComplaintStatus, after)
+	case bulkAssign:
+		recordAudit(r, auditAssign, auditTargetComplaint, after.ID, before, after)
+		if after.AssignedTo != "" {
+			go publishEvent(eventComplaintAssigned, after)
+		}
+		if after.AssignedTo != "" && before.AssignedTo == "" {
+			go notifyOwner(after, templateAssignment, "")
+		}
+	case bulkTag:
+		if len(after.Tags) != len(before.Tags) {
+			recordAudit(r, auditTag, auditTargetComplaint, after.ID, before, after)
+		}
+	}
+}
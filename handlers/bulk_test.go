@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"complain/models"
+)
+
+func TestBulkAssign(t *testing.T) {
+	_, token := registerAndLogin(t, newEmail())
+	submit := requireUser(submitComplaintHandler)
+	var ids []string
+	for i := 0; i < 2; i++ {
+		w := call(t, submit, http.MethodPost, "/submitComplaint?force=true", `{"title":"`+primitive.NewObjectID().Hex()+`"}`, token)
+		var c models.Complaint
+		decodeResponse(t, w, &c)
+		ids = append(ids, c.ID.Hex())
+	}
+	bulk := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/admin/complaints/bulk", strings.NewReader(body))
+		r.Header.Set("X-Admin-Token", testAdminToken)
+		w := httptest.NewRecorder()
+		withAuthentication(http.HandlerFunc(bulkComplaintsHandler)).ServeHTTP(w, r)
+		return w
+	}
+
+	if w := bulk(`{"action":"assign","agent":"nobody@example.com","ids":["` + ids[0] + `"]}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("assigning to someone not on staff: %d %s, want 422", w.Code, w.Body)
+	}
+	if c, _ := complaintStore.Complaint(context.Background(), mustObjectID(t, ids[0])); c.AssignedTo != "" || len(c.Assignments) != 0 {
+		t.Errorf("rejected assignment was applied: %+v", c)
+	}
+
+	w := bulk(`{"action":"assign","agent":"Sam","ids":["` + ids[0] + `","` + ids[1] + `","` + ids[0] + `","nonsense","` + primitive.NewObjectID().Hex() + `"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bulk assign: %d %s", w.Code, w.Body)
+	}
+	var res bulkResult
+	decodeResponse(t, w, &res)
+	if res.Succeeded != 3 || res.Failed != 2 || res.Results[3].Error != "invalid ID" || res.Results[4].Error != "not found" {
+		t.Errorf("bulk assign = %+v, want both complaints assigned and the unknown IDs failed", res)
+	}
+	for _, id := range ids {
+		if c, _ := complaintStore.Complaint(context.Background(), mustObjectID(t, id)); c.AssignedTo != "Sam" || len(c.Assignments) != 1 {
+			t.Errorf("complaint %s = %+v, want it assigned to Sam once", id, c)
+		}
+	}
+}
+
+func mustObjectID(t *testing.T, hex string) primitive.ObjectID {
+	t.Helper()
+	oid, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return oid
+}
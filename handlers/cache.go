@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+	"complain/store"
+)
+
+var cacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "cache_requests_total",
+	Help: "Read cache lookups by cache and result (hit, miss or error).",
+}, []string{"cache", "result"})
+
+// cache is the Redis client behind the read cache, or nil when
+// CACHE_REDIS_URL is unset and every read goes to the store.
+var cache *redis.Client
+
+// cacheTimeout bounds each cache round trip. The cache fails open: a slow
+// or unreachable Redis makes reads go to the store, never fail.
+const cacheTimeout = 200 * time.Millisecond
+
+// initCache puts the Redis cache in front of complaintStore. Writes made
+// through the store invalidate their entries at once; writes the handlers
+// make to the collection directly, from this instance or another, are
+// picked up by watchCacheInvalidations. CACHE_TTL bounds how stale an entry
+// can get if an invalidation is missed.
+func initCache() {
+	u := envString("CACHE_REDIS_URL", "")
+	if u == "" {
+		return
+	}
+	opts, err := redis.ParseURL(u)
+	if err != nil {
+		log.Fatalf("CACHE_REDIS_URL: %v", err)
+	}
+	cache = redis.NewClient(opts)
+	complaintStore = cachedComplaintStore{ComplaintStore: complaintStore}
+}
+
+func cacheTTL() time.Duration {
+	return envDuration("CACHE_TTL", 30*time.Second)
+}
+
+func complaintCacheKey(id primitive.ObjectID) string {
+	return "cache:complaint:" + id.Hex()
+}
+
+// listingGenerationKey holds a counter that is part of every key of the
+// user's cached listings, so bumping it drops them all at once without
+// having to find them.
+func listingGenerationKey(userID primitive.ObjectID) string {
+	return "cache:listing-gen:" + userID.Hex()
+}
+
+// invalidateComplaint drops the cached complaint and, when the owner is
+// known, every cached listing of the owner.
+func invalidateComplaint(ctx context.Context, id, userID primitive.ObjectID) {
+	if cache == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cacheTimeout)
+	defer cancel()
+	pipe := cache.TxPipeline()
+	pipe.Del(ctx, complaintCacheKey(id))
+	if !userID.IsZero() {
+		pipe.Incr(ctx, listingGenerationKey(userID))
+		pipe.Expire(ctx, listingGenerationKey(userID), 24*time.Hour)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("cache invalidate %s: %v", id.Hex(), err)
+	}
+}
+
+// cachedComplaintStore caches single-complaint reads. An entry is shared by
+// every caller, so a hit is checked against the context's organization
+// just like a store read.
+type cachedComplaintStore struct {
+	store.ComplaintStore
+}
+
+func (s cachedComplaintStore) Complaint(ctx context.Context, id primitive.ObjectID) (models.Complaint, error) {
+	key := complaintCacheKey(id)
+	lookup, cancel := context.WithTimeout(ctx, cacheTimeout)
+	raw, err := cache.Get(lookup, key).Bytes()
+	cancel()
+	var complaint models.Complaint
+	switch {
+	case err == nil && bson.Unmarshal(raw, &complaint) == nil:
+		cacheRequestsTotal.WithLabelValues("complaint", "hit").Inc()
+		if !store.InOrg(ctx, complaint.OrgID) {
+			return models.Complaint{}, store.ErrNotFound
+		}
+		return complaint, nil
+	case err == nil || errors.Is(err, redis.Nil):
+		cacheRequestsTotal.WithLabelValues("complaint", "miss").Inc()
+	default:
+		cacheRequestsTotal.WithLabelValues("complaint", "error").Inc()
+		log.Printf("cache get %s: %v", key, err)
+	}
+
+	complaint, err = s.ComplaintStore.Complaint(ctx, id)
+	if err != nil {
+		return complaint, err
+	}
+	if raw, err := bson.Marshal(complaint); err == nil {
+		set, cancel := context.WithTimeout(ctx, cacheTimeout)
+		if err := cache.Set(set, key, raw, cacheTTL()).Err(); err != nil {
+			log.Printf("cache set %s: %v", key, err)
+		}
+		cancel()
+	}
+	return complaint, nil
+}
+
+func (s cachedComplaintStore) CreateComplaint(ctx context.Context, complaint models.Complaint) (models.User, error) {
+	user, err := s.ComplaintStore.CreateComplaint(ctx, complaint)
+	invalidateComplaint(ctx, complaint.ID, complaint.UserID)
+	return user, err
+}
+
+func (s cachedComplaintStore) ReleaseIdempotencyKey(ctx context.Context, userID primitive.ObjectID, key string, before time.Time) error {
+	err := s.ComplaintStore.ReleaseIdempotencyKey(ctx, userID, key, before)
+	if err == nil {
+		invalidateUserListings(ctx, userID)
+	}
+	return err
+}
+
+func (s cachedComplaintStore) DeleteComplaint(ctx context.Context, id primitive.ObjectID, deletedBy string) (models.Complaint, error) {
+	complaint, err := s.ComplaintStore.DeleteComplaint(ctx, id, deletedBy)
+	if err == nil {
+		invalidateComplaint(ctx, id, complaint.UserID)
+	}
+	return complaint, err
+}
+
+func (s cachedComplaintStore) RestoreComplaint(ctx context.Context, id primitive.ObjectID) (models.Complaint, error) {
+	complaint, err := s.ComplaintStore.RestoreComplaint(ctx, id)
+	if err == nil {
+		invalidateComplaint(ctx, id, complaint.UserID)
+	}
+	return complaint, err
+}
+
+func invalidateUserListings(ctx context.Context, userID primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cacheTimeout)
+	defer cancel()
+	if err := cache.Incr(ctx, listingGenerationKey(userID)).Err(); err != nil {
+		log.Printf("cache invalidate listings of %s: %v", userID.Hex(), err)
+	}
+}
+
+// cachedListing returns the user's listing for r's query from the cache,
+// or loads and caches it.
+func cachedListing(r *http.Request, userID primitive.ObjectID, load func() (userComplaintsResponse, error)) (userComplaintsResponse, error) {
+	if cache == nil {
+		return load()
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), cacheTimeout)
+	defer cancel()
+	generation, err := cache.Get(ctx, listingGenerationKey(userID)).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		cacheRequestsTotal.WithLabelValues("listing", "error").Inc()
+		log.Printf("cache get listing generation of %s: %v", userID.Hex(), err)
+		return load()
+	}
+	query := sha256.Sum256([]byte(r.URL.Query().Encode()))
+	key := "cache:listing:" + userID.Hex() + ":" + strconv.FormatInt(generation, 10) + ":" + hex.EncodeToString(query[:])
+
+	var resp userComplaintsResponse
+	raw, err := cache.Get(ctx, key).Bytes()
+	switch {
+	case err == nil && json.Unmarshal(raw, &resp) == nil:
+		cacheRequestsTotal.WithLabelValues("listing", "hit").Inc()
+		return resp, nil
+	case err == nil || errors.Is(err, redis.Nil):
+		cacheRequestsTotal.WithLabelValues("listing", "miss").Inc()
+	default:
+		cacheRequestsTotal.WithLabelValues("listing", "error").Inc()
+		log.Printf("cache get %s: %v", key, err)
+	}
+
+	resp, err = load()
+	if err != nil {
+		return resp, err
+	}
+	if raw, err := json.Marshal(resp); err == nil {
+		set, cancel := context.WithTimeout(r.Context(), cacheTimeout)
+		if err := cache.Set(set, key, raw, cacheTTL()).Err(); err != nil {
+			log.Printf("cache set %s: %v", key, err)
+		}
+		cancel()
+	}
+	return resp, nil
+}
+
+// watchCacheInvalidations follows the complaints change stream and drops
+// the entries of every changed complaint, which covers the handlers that
+// update the collection directly and writes from other instances. Change
+// streams need a replica set; without one the cache relies on CACHE_TTL
+// for those writes.
+func watchCacheInvalidations() {
+	if cache == nil {
+		return
+	}
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	pipeline := mongo.Pipeline{{{Key: "$project", Value: bson.M{"documentKey": 1, "fullDocument.userId": 1}}}}
+	for {
+		stream, err := documents.Collection("complaints").Watch(context.Background(), pipeline, opts)
+		if err != nil {
+			log.Printf("cache invalidation stream: %v; entries expire after CACHE_TTL", err)
+			return
+		}
+		for stream.Next(context.Background()) {
+			var event struct {
+				DocumentKey struct {
+					ID primitive.ObjectID `bson:"_id"`
+				} `bson:"documentKey"`
+				FullDocument struct {
+					UserID primitive.ObjectID `bson:"userId"`
+				} `bson:"fullDocument"`
+			}
+			if err := stream.Decode(&event); err != nil {
+				log.Printf("cache invalidation stream: %v", err)
+				continue
+			}
+			invalidateComplaint(context.Background(), event.DocumentKey.ID, event.FullDocument.UserID)
+		}
+		log.Printf("cache invalidation stream ended: %v; reopening", stream.Err())
+		stream.Close(context.Background())
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,372 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+func recategorizeComplaintHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	staff, ok := staffIdentity(r)
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("complaintId"))
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+	category := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("category")))
+	if category == "" {
+		writeFieldError(w, "category", "is required")
+		return
+	}
+	version, ok := requireComplaintVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var complaint models.Complaint
+	err = orgCollection("complaints").FindOne(dbContext(r), bson.M{"_id": oid}).Decode(&complaint)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if complaint.Version != version {
+		writeVersionConflict(w)
+		return
+	}
+	if complaint.Category == category {
+		json.NewEncoder(w).Encode(complaint)
+		return
+	}
+	if err := validateClassification(category, complaint.Priority); err != nil {
+		writeFieldError(w, "category", err.Error())
+		return
+	}
+	if _, err := lookupCategory(dbContext(r), category); err != nil {
+		if errors.Is(err, errUnknownCategory) {
+			writeFieldError(w, "category", err.Error())
+		} else {
+			serverError(w, err)
+		}
+		return
+	}
+
+	filter := bson.M{"_id": oid, "category": complaint.Category}
+	if complaint.Category == "" {
+		filter["category"] = bson.M{"$in": bson.A{nil, ""}}
+	}
+	change := models.Recategorization{From: complaint.Category, To: category, By: staff.Name, At: time.Now().UTC()}
+	update := bson.M{
+		"$set":  bson.M{"category": category},
+		"$push": bson.M{"recategorizations": change},
+	}
+	withVersion(filter, update, version)
+	err = orgCollection("complaints").FindOneAndUpdate(dbContext(r),
+		filter,
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&complaint)
+	if err == mongo.ErrNoDocuments {
+		writeVersionConflict(w)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(complaint)
+}
+
+type categorySuggestion struct {
+	Category string  `json:"category"`
+	Score    float64 `json:"score"`
+	Matches  int     `json:"matches"`
+}
+
+// categorySuggestionsHandler ranks categories that admins recently moved
+// similar complaints into. Each recategorized complaint whose keywords are at
+// least CATEGORY_SUGGEST_MIN_SIMILARITY_PERCENT similar votes for its current
+// category with its similarity; scores are the share of all such votes.
+func categorySuggestionsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := staffIdentity(r); !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("complaintId"))
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+	var errs validationErrors
+	limit := intParam(r, "limit", 3, 1, 20, &errs)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	var complaint models.Complaint
+	err = orgCollection("complaints").FindOne(dbContext(r), bson.M{"_id": oid}).Decode(&complaint)
+	if err != nil {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+
+	since := time.Now().Add(-envDuration("CATEGORY_SUGGEST_WINDOW", 30*24*time.Hour))
+	cursor, err := orgCollection("complaints").Find(dbContext(r),
+		bson.M{"_id": bson.M{"$ne": oid}, "recategorizations.at": bson.M{"$gte": since}},
+		options.Find().
+			SetSort(bson.M{"createdAt": -1}).
+			SetLimit(int64(envInt("CATEGORY_SUGGEST_SAMPLE", 500))).
+			SetProjection(bson.M{"title": 1, "summary": 1, "category": 1}))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	var recent []models.Complaint
+	if err := cursor.All(dbContext(r), &recent); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	minLen := envInt("CLUSTER_MIN_WORD_LENGTH", 3)
+	minSimilarity := float64(envInt("CATEGORY_SUGGEST_MIN_SIMILARITY_PERCENT", 20)) / 100
+	target := keywords(complaint, minLen)
+	byCategory := make(map[string]*categorySuggestion)
+	total := 0.0
+	for _, c := range recent {
+		if c.Category == "" || c.Category == complaint.Category {
+			continue
+		}
+		sim := jaccard(target, keywords(c, minLen))
+		if sim < minSimilarity {
+			continue
+		}
+		s, ok := byCategory[c.Category]
+		if !ok {
+			s = &categorySuggestion{Category: c.Category}
+			byCategory[c.Category] = s
+		}
+		s.Score += sim
+		s.Matches++
+		total += sim
+	}
+
+	suggestions := []categorySuggestion{}
+	for _, s := range byCategory {
+		s.Score /= total
+		suggestions = append(suggestions, *s)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		return suggestions[i].Category < suggestions[j].Category
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	json.NewEncoder(w).Encode(suggestions)
+}
+
+var errUnknownCategory = errors.New("is not a known category")
+
+var categoryIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+type categoryRequest struct {
+	ID          string   `json:"id,omitempty"` // for POST; fixed once created
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Agents      []string `json:"agents,omitempty"`
+	// EscalationChain replaces the category's chain; send it empty to remove it.
+	EscalationChain []models.EscalationLevel `json:"escalationChain,omitempty"`
+}
+
+// lookupCategory returns the category complaints tagged id belong to. Until
+// admins define any categories, every category is accepted as free text and
+// the zero Category is returned; after that, an id that is not defined is
+// errUnknownCategory.
+func lookupCategory(ctx context.Context, id string) (models.Category, error) {
+	var category models.Category
+	if id == "" {
+		return category, nil
+	}
+	err := documents.Collection("categories").FindOne(ctx, bson.M{"_id": id}).Decode(&category)
+	if err != mongo.ErrNoDocuments {
+		return category, err
+	}
+	n, err := documents.Collection("categories").CountDocuments(ctx, bson.M{}, options.Count().SetLimit(1))
+	if err != nil {
+		return category, err
+	}
+	if n > 0 {
+		return category, errUnknownCategory
+	}
+	return category, nil
+}
+
+// categoriesHandler lists categories (GET) to anyone signed in and lets
+// platform admins create (POST), update (PUT ?id=) and remove (DELETE ?id=)
+// them; every organization shares them. Removing a category leaves
+// complaints already in it as they are.
+func categoriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && !platformAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		cursor, err := documents.Collection("categories").Find(dbContext(r), bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		categories := []models.Category{}
+		if err := cursor.All(dbContext(r), &categories); err != nil {
+			serverError(w, err)
+			return
+		}
+		// Routing is internal; reporters only need names to pick from.
+		if !isStaffRole(callerRole(r)) {
+			for i := range categories {
+				categories[i].Agents = nil
+				categories[i].EscalationChain = nil
+			}
+		}
+		json.NewEncoder(w).Encode(categories)
+	case http.MethodPost, http.MethodPut:
+		saveCategory(w, r)
+	case http.MethodDelete:
+		res, err := documents.Collection("categories").DeleteOne(dbContext(r), bson.M{"_id": r.URL.Query().Get("id")})
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if res.DeletedCount == 0 {
+			http.Error(w, "Category not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// validateEscalationChain trims a chain's levels and checks that each names
+// an assignee and waits longer than the one before.
+func validateEscalationChain(levels []models.EscalationLevel, errs *validationErrors) []models.EscalationLevel {
+	var chain []models.EscalationLevel
+	var previous time.Duration
+	for i, level := range levels {
+		field := fmt.Sprintf("escalationChain[%d]", i)
+		level.Name, level.Assignee = strings.TrimSpace(level.Name), strings.TrimSpace(level.Assignee)
+		if level.Assignee == "" {
+			errs.add(field+".assignee", "is required")
+		}
+		after, err := time.ParseDuration(strings.TrimSpace(level.After))
+		switch {
+		case err != nil || after <= 0:
+			errs.add(field+".after", "must be a positive duration such as 24h")
+		case after <= previous:
+			errs.add(field+".after", "must be longer than the previous level's")
+		default:
+			level.After, previous = after.String(), after
+		}
+		chain = append(chain, level)
+	}
+	return chain
+}
+
+func saveCategory(w http.ResponseWriter, r *http.Request) {
+	var req categoryRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var errs validationErrors
+	id := strings.ToLower(strings.TrimSpace(req.ID))
+	if r.Method == http.MethodPut {
+		id = r.URL.Query().Get("id")
+	} else if !categoryIDPattern.MatchString(id) {
+		errs.add("id", "must be 1-32 lowercase letters, digits, - or _, starting with a letter or digit")
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		errs.add("name", "is required")
+	}
+	var agents []string
+	for _, agent := range req.Agents {
+		if agent = strings.TrimSpace(agent); agent != "" && !slices.Contains(agents, agent) {
+			agents = append(agents, agent)
+		}
+	}
+	chain := validateEscalationChain(req.EscalationChain, &errs)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	now := time.Now().UTC()
+	if r.Method == http.MethodPost {
+		admin, _ := adminIdentity(r)
+		category := models.Category{ID: id, Name: name, Description: strings.TrimSpace(req.Description), Agents: agents,
+			EscalationChain: chain, CreatedBy: admin, CreatedAt: now, UpdatedAt: now}
+		_, err := documents.Collection("categories").InsertOne(dbContext(r), category)
+		if mongo.IsDuplicateKeyError(err) {
+			writeFieldError(w, "id", "is already in use")
+			return
+		}
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(category)
+		return
+	}
+
+	var category models.Category
+	err := documents.Collection("categories").FindOneAndUpdate(dbContext(r),
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"name": name, "description": strings.TrimSpace(req.Description), "agents": agents, "escalationChain": chain, "updatedAt": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&category)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(category)
+}
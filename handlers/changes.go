@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+type complaintChange struct {
+	Operation   string             `json:"operation"`
+	ComplaintID primitive.ObjectID `json:"complaintId"`
+	Complaint   *models.Complaint  `json:"complaint,omitempty"`
+	ChangedAt   time.Time          `json:"changedAt"`
+}
+
+type complaintChangesResponse struct {
+	Changes     []complaintChange `json:"changes"`
+	ResumeToken string            `json:"resumeToken,omitempty"`
+	// Resync tells the client its token can no longer be resumed from and it
+	// must reload everything before polling again without a token.
+	Resync bool `json:"resync,omitempty"`
+}
+
+// Server error codes meaning a resume token can no longer be used.
+var staleResumeTokenCodes = []int{260, 280, 286}
+
+func staleResumeToken(err error) bool {
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) {
+		return false
+	}
+	for _, code := range staleResumeTokenCodes {
+		if cmdErr.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+func complaintChangesHandler(w http.ResponseWriter, r *http.Request) {
+	var errs validationErrors
+	limit := intParam(r, "limit", 100, 1, 1000, &errs)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup).SetMaxAwaitTime(time.Second)
+	if token := r.URL.Query().Get("resumeToken"); token != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil || bson.Raw(raw).Validate() != nil {
+			writeResync(w)
+			return
+		}
+		opts.SetResumeAfter(bson.Raw(raw))
+	}
+
+	ctx, cancel := context.WithTimeout(dbContext(r), 10*time.Second)
+	defer cancel()
+
+	stream, err := orgCollection("complaints").Watch(ctx, mongo.Pipeline{}, opts)
+	if staleResumeToken(err) {
+		writeResync(w)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer stream.Close(context.Background())
+
+	resp := complaintChangesResponse{Changes: []complaintChange{}}
+	for len(resp.Changes) < limit && stream.TryNext(ctx) {
+		var event struct {
+			OperationType string `bson:"operationType"`
+			DocumentKey   struct {
+				ID primitive.ObjectID `bson:"_id"`
+			} `bson:"documentKey"`
+			FullDocument *models.Complaint   `bson:"fullDocument"`
+			ClusterTime  primitive.Timestamp `bson:"clusterTime"`
+		}
+		if err := stream.Decode(&event); err != nil {
+			serverError(w, err)
+			return
+		}
+		if event.OperationType == "invalidate" {
+			writeResync(w)
+			return
+		}
+		resp.Changes = append(resp.Changes, complaintChange{
+			Operation:   event.OperationType,
+			ComplaintID: event.DocumentKey.ID,
+			Complaint:   event.FullDocument,
+			ChangedAt:   time.Unix(int64(event.ClusterTime.T), 0).UTC(),
+		})
+	}
+	if staleResumeToken(stream.Err()) {
+		writeResync(w)
+		return
+	}
+	if err := stream.Err(); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	resp.ResumeToken = base64.RawURLEncoding.EncodeToString(stream.ResumeToken())
+	json.NewEncoder(w).Encode(resp)
+}
+
+func writeResync(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusGone)
+	json.NewEncoder(w).Encode(complaintChangesResponse{Changes: []complaintChange{}, Resync: true})
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"complain/models"
+)
+
+const (
+	chatSlack = "slack"
+	chatTeams = "teams"
+)
+
+// chatChannel is a Slack or Microsoft Teams incoming webhook. Categories
+// limits it to complaints in those categories; none means every complaint.
+type chatChannel struct {
+	Name       string   `json:"name"`
+	Kind       string   `json:"kind"`
+	Events     []string `json:"events"`
+	Categories []string `json:"categories,omitempty"`
+	url        string
+}
+
+var (
+	chatChannels []chatChannel
+	chatClient   *http.Client
+)
+
+// initChat reads CHAT_CHANNELS, a comma-separated list of names, and for
+// each name CHAT_<NAME>_URL, CHAT_<NAME>_KIND (slack or teams, guessed from
+// the URL when unset), CHAT_<NAME>_CATEGORIES and CHAT_<NAME>_EVENTS
+// (created, escalated and reopened by default).
+func initChat() {
+	chatChannels = nil
+	chatClient = &http.Client{Timeout: envDuration("CHAT_TIMEOUT", 10*time.Second)}
+	for _, name := range strings.Split(envString("CHAT_CHANNELS", ""), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "CHAT_" + strings.ToUpper(name) + "_"
+		c := chatChannel{Name: name, url: envString(prefix+"URL", "")}
+		u, err := url.Parse(c.url)
+		if c.url == "" || err != nil || u.Scheme != "https" {
+			log.Printf("chat %s: %sURL must be an https URL, channel disabled", name, prefix)
+			continue
+		}
+		c.Kind = strings.ToLower(envString(prefix+"KIND", ""))
+		if c.Kind == "" {
+			c.Kind = chatTeams
+			if strings.HasSuffix(u.Hostname(), "slack.com") {
+				c.Kind = chatSlack
+			}
+		}
+		if c.Kind != chatSlack && c.Kind != chatTeams {
+			log.Printf("chat %s: %sKIND must be slack or teams, channel disabled", name, prefix)
+			continue
+		}
+		c.Categories = splitList(strings.ToLower(envString(prefix+"CATEGORIES", "")))
+		for _, e := range splitList(strings.ToLower(envString(prefix+"EVENTS", "created,escalated,reopened"))) {
+			c.Events = append(c.Events, "complaint."+e)
+		}
+		chatChannels = append(chatChannels, c)
+	}
+}
+
+func (c chatChannel) wants(event string, complaint models.Complaint) bool {
+	return slices.Contains(c.Events, event) &&
+		(len(c.Categories) == 0 || slices.Contains(c.Categories, complaint.Category))
+}
+
+// postChat announces an event in every channel routed to it. Failures are
+// only logged; chat messages are a courtesy, not a delivery guarantee.
+func postChat(event string, complaint models.Complaint) {
+	for _, c := range chatChannels {
+		if c.wants(event, complaint) {
+			if err := c.post(event, complaint); err != nil {
+				log.Printf("chat %s: %s for complaint %s: %v", c.Name, event, complaint.ID.Hex(), err)
+			}
+		}
+	}
+}
+
+func chatHeadline(event string) string {
+	switch event {
+	case eventComplaintCreated:
+		return "New complaint"
+	case eventComplaintEscalated:
+		return "Complaint escalated"
+	case eventComplaintReopened:
+		return "Complaint reopened"
+	}
+	return "Complaint updated (" + event + ")"
+}
+
+// post sends a Slack mrkdwn message or a Teams MessageCard.
+func (c chatChannel) post(event string, complaint models.Complaint) error {
+	link := strings.TrimRight(envString("PUBLIC_URL", "http://localhost:8080"), "/") + apiPrefix + "/complaints/" + complaint.ID.Hex()
+	headline := chatHeadline(event)
+	category := complaint.Category
+	if category == "" {
+		category = "uncategorized"
+	}
+	details := fmt.Sprintf("Priority: %s · Category: %s", complaint.Priority, category)
+	if complaint.AssignedTo != "" {
+		details += " · Assigned to: " + complaint.AssignedTo
+	}
+
+	var msg interface{}
+	if c.Kind == chatSlack {
+		msg = map[string]string{
+			"text": fmt.Sprintf("*%s* <%s|%s %s>\n%s", headline, link, complaint.Reference(), slackEscape(complaint.Title), details),
+		}
+	} else {
+		msg = map[string]interface{}{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    headline + " " + complaint.Reference(),
+			"themeColor": chatColor(complaint.Priority),
+			"title":      headline + ": " + complaint.Reference() + " " + complaint.Title,
+			"text":       details,
+			"potentialAction": []map[string]interface{}{{
+				"@type":   "OpenUri",
+				"name":    "View complaint",
+				"targets": []map[string]string{{"os": "default", "uri": link}},
+			}},
+		}
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	resp, err := chatClient.Post(c.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// slackEscape escapes the characters Slack treats as markup in text.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+func chatColor(priority string) string {
+	switch priority {
+	case "urgent":
+		return "D13438"
+	case "high":
+		return "FF8C00"
+	}
+	return "0078D7"
+}
+
+// chatChannelsHandler lists the configured channels without their URLs,
+// which are secrets.
+func chatChannelsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	channels := append([]chatChannel{}, chatChannels...)
+	json.NewEncoder(w).Encode(channels)
+}
+
+type chatTestResult struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// testChatHandler posts a sample complaint to the channel named by name, so
+// admins can check the URL and formatting. A failed delivery is a 502.
+func testChatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	i := slices.IndexFunc(chatChannels, func(c chatChannel) bool { return c.Name == name })
+	if i < 0 {
+		http.Error(w, "Chat channel not found", http.StatusNotFound)
+		return
+	}
+
+	sample := models.Complaint{ID: primitive.NewObjectID(), Title: "Test message from the complaints service", Priority: defaultPriority, CreatedAt: time.Now().UTC()}
+	if len(chatChannels[i].Categories) > 0 {
+		sample.Category = chatChannels[i].Categories[0]
+	}
+	result := chatTestResult{Name: name, OK: true}
+	if err := chatChannels[i].post(eventComplaintCreated, sample); err != nil {
+		result.OK, result.Error = false, err.Error()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// disallowedCombos parses CATEGORY_PRIORITY_RULES, a comma-separated list of
+// category:priority pairs that may not be used together. A category of * bans
+// the priority everywhere.
+func disallowedCombos() map[string]bool {
+	rules := make(map[string]bool)
+	for _, rule := range strings.Split(envString("CATEGORY_PRIORITY_RULES", "feedback:urgent"), ",") {
+		category, priority, ok := strings.Cut(strings.ToLower(strings.TrimSpace(rule)), ":")
+		if ok && category != "" && priority != "" {
+			rules[category+":"+priority] = true
+		}
+	}
+	return rules
+}
+
+func validateClassification(category, priority string) error {
+	rules := disallowedCombos()
+	if rules[category+":"+priority] || rules["*:"+priority] {
+		return fmt.Errorf("category %q cannot have priority %q", category, priority)
+	}
+	return nil
+}
@@ -0,0 +1,431 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/migrations"
+	"complain/models"
+	"complain/store"
+)
+
+// command is an operational task run as `complain [flags] <name> [flags]`.
+// Commands talk to MongoDB directly, so they work whether or not the server
+// is up; the global flags and settings select the database as for the
+// server.
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"create-admin":      {"create a staff account and print its secret code", createAdminCommand},
+	"reset-secret-code": {"issue a user a new secret code and end their sessions", resetSecretCodeCommand},
+	"reindex":           {"create any missing indexes", reindexCommand},
+	"migrate":           {"apply (up), revert (down) or list (status) schema migrations", migrateCommand},
+	"export-complaints": {"write complaints as CSV or XLSX, with the admin export's filters", exportComplaintsCommand},
+	"purge-deleted":     {"permanently remove complaints soft-deleted before a cutoff", purgeDeletedCommand},
+	"stats":             {"print the admin stats summary as JSON", statsCommand},
+}
+
+// commandActor names whoever ran a command in audit logs.
+func commandActor() string {
+	if user := os.Getenv("USER"); user != "" {
+		return "cli:" + user
+	}
+	return "cli"
+}
+
+// RunCommand runs the admin command name with its arguments.
+func RunCommand(name string, args []string) error {
+	if name == "help" {
+		printCommands(os.Stdout)
+		return nil
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		printCommands(os.Stderr)
+		return fmt.Errorf("unknown command %q", name)
+	}
+	initLogging()
+	return cmd.run(context.Background(), args)
+}
+
+// commandDB connects to MongoDB once a command's flags have checked out. Call
+// the returned function to disconnect.
+func commandDB() func() {
+	openDB()
+	return func() { client.Disconnect(context.Background()) }
+}
+
+func printCommands(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "usage: complain [flags] <command> [command flags]\n\ncommands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-18s %s\n", name, commands[name].usage)
+	}
+}
+
+func commandFlags(name string) *flag.FlagSet {
+	return flag.NewFlagSet(name, flag.ContinueOnError)
+}
+
+// commandRequest stands in for an HTTP request from an admin, so commands
+// can reuse handlers and their parameter parsing. The admin is confined to
+// org if one is given, like an organization admin.
+func commandRequest(ctx context.Context, org string, query url.Values) *http.Request {
+	r, _ := http.NewRequestWithContext(ctx, http.MethodGet, "/?"+query.Encode(), nil)
+	user := tokenUser{Role: roleAdmin, Email: commandActor(), OrgID: org}
+	return r.WithContext(context.WithValue(r.Context(), tokenUserKey, user))
+}
+
+// commandOutput writes a handler's successful response to out and keeps
+// an error response to report.
+type commandOutput struct {
+	out    io.Writer
+	header http.Header
+	status int
+	errBuf bytes.Buffer
+}
+
+func (o *commandOutput) Header() http.Header { return o.header }
+
+func (o *commandOutput) WriteHeader(status int) {
+	if o.status == 0 {
+		o.status = status
+	}
+}
+
+func (o *commandOutput) Write(p []byte) (int, error) {
+	o.WriteHeader(http.StatusOK)
+	if o.status >= 300 {
+		return o.errBuf.Write(p)
+	}
+	return o.out.Write(p)
+}
+
+func (o *commandOutput) err() error {
+	if o.status >= 300 {
+		return fmt.Errorf("%d %s: %s", o.status, http.StatusText(o.status), strings.TrimSpace(o.errBuf.String()))
+	}
+	return nil
+}
+
+// runHandler serves r with h, writing the response body to out.
+func runHandler(h http.HandlerFunc, r *http.Request, out io.Writer) error {
+	o := &commandOutput{out: out, header: http.Header{}}
+	h(o, r)
+	return o.err()
+}
+
+// setFlags copies the flags given on the command line into query.
+func setFlags(fs *flag.FlagSet, query url.Values) {
+	fs.Visit(func(f *flag.Flag) {
+		query.Set(f.Name, f.Value.String())
+	})
+}
+
+func createAdminCommand(ctx context.Context, args []string) error {
+	fs := commandFlags("create-admin")
+	email := fs.String("email", "", "email address (required)")
+	name := fs.String("name", "", "display name (required)")
+	role := fs.String("role", roleAdmin, "agent, manager or admin")
+	org := fs.String("org", "", "organization ID; empty for a platform account")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	addr, err := normalizeEmail(*email)
+	if err != nil {
+		return errors.New("-email must be a valid email address")
+	}
+	if strings.TrimSpace(*name) == "" {
+		return errors.New("-name is required")
+	}
+	if !isStaffRole(*role) {
+		return errors.New("-role must be agent, manager or admin")
+	}
+	defer commandDB()()
+	if *org != "" {
+		if _, err := findOrg(ctx, *org); err == mongo.ErrNoDocuments {
+			return fmt.Errorf("organization %s not found", *org)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	code, err := generateSecretCode()
+	if err != nil {
+		return err
+	}
+	user := models.User{
+		ID:             primitive.NewObjectID(),
+		SecretCodeHash: hashToken(code),
+		Name:           strings.TrimSpace(*name),
+		Email:          addr,
+		Complaints:     []primitive.ObjectID{},
+		Role:           *role,
+		OrgID:          *org,
+		Verified:       true,
+		CreatedAt:      time.Now().UTC(),
+	}
+	user.UpdatedAt = user.CreatedAt
+	err = userStore.CreateUser(ctx, user)
+	if errors.Is(err, store.ErrDuplicate) {
+		return fmt.Errorf("%s is already registered", addr)
+	}
+	if err != nil {
+		return err
+	}
+	recordAudit(commandRequest(ctx, *org, nil), auditCreateAdmin, auditTargetUser, user.ID, nil, user)
+
+	fmt.Printf("created %s %s (%s)\nsecret code: %s\n", user.Role, user.Email, user.ID.Hex(), code)
+	return nil
+}
+
+func resetSecretCodeCommand(ctx context.Context, args []string) error {
+	fs := commandFlags("reset-secret-code")
+	email := fs.String("email", "", "the user's email address (required)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	addr, err := normalizeEmail(*email)
+	if err != nil {
+		return errors.New("-email must be a valid email address")
+	}
+	defer commandDB()()
+	var user models.User
+	err = documents.Collection("users").FindOne(ctx, bson.M{"email": addr}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("no user with email %s", addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	code, err := generateSecretCode()
+	if err != nil {
+		return err
+	}
+	if err := userStore.SetSecretCodeHash(ctx, user.ID, hashToken(code)); err != nil {
+		return err
+	}
+	revoked, err := revokeSessions(ctx, bson.M{"userId": user.ID})
+	if err != nil {
+		return fmt.Errorf("revoke sessions: %w", err)
+	}
+	recordAudit(commandRequest(ctx, user.OrgID, nil), auditResetCode, auditTargetUser, user.ID, nil, nil)
+
+	fmt.Printf("new secret code for %s: %s\nended %d sessions\n", user.Email, code, revoked)
+	return nil
+}
+
+// reindexCommand applies schemaIndexes as the server does on start, then
+// reports any that are still missing.
+func reindexCommand(ctx context.Context, args []string) error {
+	if err := commandFlags("reindex").Parse(args); err != nil {
+		return err
+	}
+	defer commandDB()()
+	bootstrapSchema()
+	missing, err := missingIndexes(ctx)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("indexes still missing: %s", strings.Join(missing, ", "))
+	}
+	fmt.Printf("%d indexes in place\n", len(schemaIndexes))
+	return nil
+}
+
+// migrateCommand runs `migrate up [-to N]`, `migrate down -to N` or
+// `migrate status`.
+func migrateCommand(ctx context.Context, args []string) error {
+	action := "status"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	fs := commandFlags("migrate " + action)
+	to := fs.Int("to", 0, "target version; up defaults to the latest")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch action {
+	case "up", "status":
+	case "down":
+		set := false
+		fs.Visit(func(f *flag.Flag) { set = set || f.Name == "to" })
+		if !set {
+			return errors.New("migrate down needs -to, the version to go back to")
+		}
+	default:
+		return fmt.Errorf("unknown migrate action %q; want up, down or status", action)
+	}
+	defer commandDB()()
+
+	switch action {
+	case "up":
+		return migrations.Up(ctx, db, *to)
+	case "down":
+		return migrations.Down(ctx, db, *to)
+	}
+	applied, err := migrations.Applied(ctx, db)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations.All() {
+		state := "pending"
+		if rec, ok := applied[m.Version]; ok {
+			state = "applied " + rec.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%4d  %-28s %s\n", m.Version, m.Name, state)
+	}
+	return nil
+}
+
+func exportComplaintsCommand(ctx context.Context, args []string) error {
+	fs := commandFlags("export-complaints")
+	format := fs.String("format", "csv", "csv or xlsx")
+	out := fs.String("out", "", "output file; standard output if empty")
+	org := fs.String("org", "", "only this organization's complaints")
+	for _, name := range []string{"resolved", "minRating", "maxRating", "from", "to", "tz", "userId", "category", "tags", "assignedTo", "includeDeleted"} {
+		fs.String(name, "", "as the "+name+" parameter of the admin export")
+	}
+	fs.String("sheetPerCategory", "", "xlsx only: one sheet per category")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "csv" && *format != "xlsx" {
+		return errors.New("-format must be csv or xlsx")
+	}
+	query := url.Values{}
+	setFlags(fs, query)
+	query.Del("out")
+	query.Del("org")
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	defer commandDB()()
+	return runHandler(exportComplaintsHandler, commandRequest(ctx, *org, query), w)
+}
+
+// purgeDeletedCommand removes soft-deleted complaints for good along with
+// their comments, revisions, feedback, notifications and attachments. With
+// -dry-run it only counts them.
+func purgeDeletedCommand(ctx context.Context, args []string) error {
+	fs := commandFlags("purge-deleted")
+	olderThan := fs.Duration("older-than", 30*24*time.Hour, "purge complaints deleted at least this long ago")
+	dryRun := fs.Bool("dry-run", false, "only count what would be purged")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	defer commandDB()()
+	cutoff := time.Now().Add(-*olderThan).UTC()
+	filter := bson.M{"deletedAt": bson.M{"$lt": cutoff}}
+	if *dryRun {
+		n, err := documents.Collection("complaints").CountDocuments(ctx, filter)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d complaints deleted before %s would be purged\n", n, cutoff.Format(time.RFC3339))
+		return nil
+	}
+
+	cursor, err := documents.Collection("complaints").Find(ctx, filter,
+		options.Find().SetProjection(bson.M{"_id": 1, "userId": 1, "attachments": 1, "deletedAt": 1, "deletedBy": 1, "title": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	bucket, err := attachmentsBucket()
+	if err != nil {
+		return err
+	}
+	audit := commandRequest(ctx, "", nil)
+	purged := 0
+	for cursor.Next(ctx) {
+		var c models.Complaint
+		if err := cursor.Decode(&c); err != nil {
+			return err
+		}
+		if err := purgeComplaint(ctx, bucket, c); err != nil {
+			return fmt.Errorf("purge complaint %s: %w", c.ID.Hex(), err)
+		}
+		recordAudit(audit, auditPurge, auditTargetComplaint, c.ID, c, nil)
+		purged++
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	fmt.Printf("purged %d complaints deleted before %s\n", purged, cutoff.Format(time.RFC3339))
+	return nil
+}
+
+// purgeComplaint deletes the complaint last, so a failed purge is still a
+// deleted complaint that the next run picks up again.
+func purgeComplaint(ctx context.Context, bucket *gridfs.Bucket, c models.Complaint) error {
+	for _, a := range c.Attachments {
+		if err := bucket.Delete(a.ID); err != nil && err != gridfs.ErrFileNotFound {
+			return fmt.Errorf("delete attachment %s: %w", a.ID.Hex(), err)
+		}
+	}
+	for _, name := range []string{"comments", "complaint_revisions", "notifications"} {
+		if _, err := documents.Collection(name).DeleteMany(ctx, bson.M{"complaintId": c.ID}); err != nil {
+			return fmt.Errorf("delete %s: %w", name, err)
+		}
+	}
+	if _, err := documents.Collection("feedback").DeleteOne(ctx, bson.M{"_id": c.ID}); err != nil {
+		return fmt.Errorf("delete feedback: %w", err)
+	}
+	if !c.UserID.IsZero() {
+		if _, err := documents.Collection("users").UpdateOne(ctx, bson.M{"_id": c.UserID}, bson.M{"$pull": bson.M{"complaints": c.ID}}); err != nil {
+			return fmt.Errorf("unlink from reporter: %w", err)
+		}
+	}
+	_, err := documents.Collection("complaints").DeleteOne(ctx, bson.M{"_id": c.ID})
+	return err
+}
+
+func statsCommand(ctx context.Context, args []string) error {
+	fs := commandFlags("stats")
+	org := fs.String("org", "", "only this organization's complaints")
+	fs.String("from", "", "created at or after; an RFC3339 time or a date")
+	fs.String("to", "", "created before; an RFC3339 time or a date")
+	fs.String("tz", "", "IANA time zone for dates and daily counts")
+	fs.String("top", "", "number of top categories")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	query := url.Values{}
+	setFlags(fs, query)
+	query.Del("org")
+	defer commandDB()()
+	return runHandler(adminStatsHandler, commandRequest(ctx, *org, query), os.Stdout)
+}
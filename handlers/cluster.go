@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"complain/models"
+	"complain/store"
+)
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true,
+	"by": true, "for": true, "from": true, "has": true, "have": true, "i": true, "in": true, "is": true,
+	"it": true, "my": true, "not": true, "of": true, "on": true, "or": true, "so": true, "that": true,
+	"the": true, "this": true, "to": true, "was": true, "we": true, "with": true, "you": true,
+}
+
+type ComplaintCluster struct {
+	Size           int                  `json:"size"`
+	Keywords       []string             `json:"keywords"`
+	Representative models.Complaint     `json:"representative"`
+	ComplaintIDs   []primitive.ObjectID `json:"complaintIds"`
+}
+
+type clusterBuilder struct {
+	keywords map[string]bool
+	members  []models.Complaint
+}
+
+// keywords returns the normalized, de-duplicated words of a complaint's title
+// and summary, ignoring stop words and very short tokens.
+func keywords(c models.Complaint, minLen int) map[string]bool {
+	words := strings.FieldsFunc(strings.ToLower(c.Title+" "+c.Summary), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	set := make(map[string]bool)
+	for _, w := range words {
+		if len(w) >= minLen && !stopWords[w] {
+			set[w] = true
+		}
+	}
+	return set
+}
+
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for w := range a {
+		if b[w] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// clusterComplaints greedily assigns each complaint to the first cluster whose
+// seed keywords are similar enough, otherwise it starts a new cluster.
+func clusterComplaints(complaints []models.Complaint, threshold float64, minLen int) []*clusterBuilder {
+	var clusters []*clusterBuilder
+	for _, c := range complaints {
+		kw := keywords(c, minLen)
+		var match *clusterBuilder
+		for _, cl := range clusters {
+			if jaccard(kw, cl.keywords) >= threshold {
+				match = cl
+				break
+			}
+		}
+		if match == nil {
+			clusters = append(clusters, &clusterBuilder{keywords: kw, members: []models.Complaint{c}})
+			continue
+		}
+		match.members = append(match.members, c)
+	}
+	return clusters
+}
+
+func queryFloat(r *http.Request, key string, def float64) float64 {
+	if v, err := strconv.ParseFloat(r.URL.Query().Get(key), 64); err == nil {
+		return v
+	}
+	return def
+}
+
+func queryInt(r *http.Request, key string, def int) int {
+	if v, err := strconv.Atoi(r.URL.Query().Get(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+func complaintClustersHandler(w http.ResponseWriter, r *http.Request) {
+	threshold := queryFloat(r, "threshold", float64(envInt("CLUSTER_THRESHOLD_PERCENT", 50))/100)
+	minSize := queryInt(r, "minSize", envInt("CLUSTER_MIN_SIZE", 2))
+	minLen := envInt("CLUSTER_MIN_WORD_LENGTH", 3)
+
+	cursor, err := orgCollection("complaints").Find(dbContext(r), bson.M{"resolved": false, "deletedAt": store.NotDeleted()})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer cursor.Close(dbContext(r))
+
+	var complaints []models.Complaint
+	if err := cursor.All(dbContext(r), &complaints); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	clusters := []ComplaintCluster{}
+	for _, cl := range clusterComplaints(complaints, threshold, minLen) {
+		if len(cl.members) < minSize {
+			continue
+		}
+		shared := make(map[string]bool)
+		for w := range cl.keywords {
+			shared[w] = true
+		}
+		ids := make([]primitive.ObjectID, 0, len(cl.members))
+		for _, m := range cl.members {
+			ids = append(ids, m.ID)
+			mk := keywords(m, minLen)
+			for w := range shared {
+				if !mk[w] {
+					delete(shared, w)
+				}
+			}
+		}
+		kw := make([]string, 0, len(shared))
+		for w := range shared {
+			kw = append(kw, w)
+		}
+		sort.Strings(kw)
+		clusters = append(clusters, ComplaintCluster{
+			Size:           len(cl.members),
+			Keywords:       kw,
+			Representative: cl.members[0],
+			ComplaintIDs:   ids,
+		})
+	}
+	sort.SliceStable(clusters, func(i, j int) bool { return clusters[i].Size > clusters[j].Size })
+
+	json.NewEncoder(w).Encode(clusters)
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+type commentRequest struct {
+	Body     string              `json:"body"`
+	ParentID *primitive.ObjectID `json:"parentId"`
+	// TemplateID fills an empty body from a reply template; staff only.
+	TemplateID string `json:"templateId,omitempty"`
+	// Visibility is public (the default) or internal, which only staff can
+	// write. Replies to internal notes are always internal.
+	Visibility string `json:"visibility,omitempty"`
+}
+
+// hideInternalNotes narrows a comments filter to what the caller may read:
+// everything for staff, public comments for reporters.
+func hideInternalNotes(r *http.Request, filter bson.M) bson.M {
+	if !isStaffRole(callerRole(r)) {
+		filter["visibility"] = bson.M{"$ne": models.CommentInternal}
+	}
+	return filter
+}
+
+type pagedComments struct {
+	Page     int              `json:"page"`
+	PageSize int              `json:"pageSize"`
+	Total    int64            `json:"total"`
+	Comments []models.Comment `json:"comments"`
+}
+
+// commentsHandler serves /complaints/{id}/comments for the complaint's owner
+// and staff.
+func commentsHandler(w http.ResponseWriter, r *http.Request, complaintID primitive.ObjectID) {
+	var complaint models.Complaint
+	err := orgCollection("complaints").FindOne(dbContext(r), bson.M{"_id": complaintID}).Decode(&complaint)
+	if err != nil || !canAccess(r, complaint) {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		listComments(w, r, complaintID)
+	case http.MethodPost:
+		addComment(w, r, complaint)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func addComment(w http.ResponseWriter, r *http.Request, complaint models.Complaint) {
+	complaintID := complaint.ID
+	var req commentRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var errs validationErrors
+	if req.TemplateID != "" && req.Body == "" {
+		if !isStaffRole(callerRole(r)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		body, err := applyReplyTemplate(dbContext(r), req.TemplateID, complaint, actorName(r))
+		if errors.Is(err, errUnknownTemplate) {
+			errs.add("templateId", err.Error())
+		} else if err != nil {
+			serverError(w, err)
+			return
+		}
+		req.Body = body
+	}
+	switch req.Visibility {
+	case "":
+		req.Visibility = models.CommentPublic
+	case models.CommentPublic:
+	case models.CommentInternal:
+		if !isStaffRole(callerRole(r)) {
+			http.Error(w, "Only staff can write internal notes", http.StatusForbidden)
+			return
+		}
+	default:
+		errs.add("visibility", "must be public or internal")
+	}
+	req.Body = strings.TrimSpace(req.Body)
+	if req.Body == "" && req.TemplateID == "" {
+		errs.add("body", "is required")
+	} else if max := envInt("COMMENT_MAX_LENGTH", 5000); utf8.RuneCountInString(req.Body) > max {
+		errs.add("body", "is too long")
+	}
+	if req.ParentID != nil {
+		var parent models.Comment
+		err := documents.Collection("comments").FindOne(dbContext(r),
+			hideInternalNotes(r, bson.M{"_id": *req.ParentID, "complaintId": complaintID}),
+			options.FindOne().SetProjection(bson.M{"visibility": 1})).Decode(&parent)
+		if err == mongo.ErrNoDocuments {
+			errs.add("parentId", "does not belong to this complaint")
+		} else if err != nil {
+			serverError(w, err)
+			return
+		}
+		if parent.Visibility == models.CommentInternal {
+			req.Visibility = models.CommentInternal
+		}
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	comment := models.Comment{
+		ID:          primitive.NewObjectID(),
+		ComplaintID: complaintID,
+		ParentID:    req.ParentID,
+		Author:      actorName(r),
+		AuthorRole:  callerRole(r),
+		Body:        req.Body,
+		Visibility:  req.Visibility,
+		CreatedAt:   time.Now().UTC(),
+	}
+	if _, err := documents.Collection("comments").InsertOne(dbContext(r), comment); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	if comment.Visibility == models.CommentPublic {
+		userID, _ := currentUserID(r)
+		go notifyInApp(notificationComment, complaint,
+			fmt.Sprintf("%s commented on complaint %s %q", comment.Author, complaint.Reference(), complaint.Title), userID)
+	}
+
+	// The counters are a convenience for listings, so a failed bump is not
+	// worth failing a comment that is already stored. They count public
+	// comments only, since reporters see them.
+	if comment.Visibility == models.CommentPublic {
+		orgCollection("complaints").UpdateOne(dbContext(r),
+			bson.M{"_id": complaintID}, bson.M{"$inc": bson.M{"commentCount": 1}})
+		if comment.ParentID != nil {
+			documents.Collection("comments").UpdateOne(dbContext(r),
+				bson.M{"_id": *comment.ParentID}, bson.M{"$inc": bson.M{"replyCount": 1}})
+		}
+	}
+
+	one := []models.Comment{comment}
+	addAuthorAvatars(r, one)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(one[0])
+}
+
+// listComments pages through a complaint's top-level comments, oldest first.
+// Pass parentId to page through the replies to one comment instead.
+func listComments(w http.ResponseWriter, r *http.Request, complaintID primitive.ObjectID) {
+	var errs validationErrors
+	page, pageSize := pageParams(r, 20, 100, &errs)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	filter := bson.M{"complaintId": complaintID, "parentId": bson.M{"$exists": false}}
+	if v := r.URL.Query().Get("parentId"); v != "" {
+		parentID, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			writeFieldError(w, "parentId", "must be a valid ID")
+			return
+		}
+		filter["parentId"] = parentID
+	}
+	hideInternalNotes(r, filter)
+
+	total, err := documents.Collection("comments").CountDocuments(dbContext(r), filter)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	cursor, err := documents.Collection("comments").Find(dbContext(r), filter, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64((page-1)*pageSize)).
+		SetLimit(int64(pageSize)))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	resp := pagedComments{Page: page, PageSize: pageSize, Total: total, Comments: []models.Comment{}}
+	if err := cursor.All(dbContext(r), &resp.Comments); err != nil {
+		serverError(w, err)
+		return
+	}
+	addAuthorAvatars(r, resp.Comments)
+
+	json.NewEncoder(w).Encode(resp)
+}
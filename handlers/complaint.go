@@ -0,0 +1,1414 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+	"complain/store"
+)
+
+// computeFields fills in the response-only fields derived from stored ones.
+func computeFields(c *models.Complaint, now time.Time) {
+	setAge(c, now)
+	setSLAStatus(c, now)
+	// Listings may already have counted the attachments before trimming them.
+	if c.TotalAttachments == 0 {
+		c.TotalAttachments = len(c.Attachments)
+	}
+}
+
+// setAge fills in AgeSeconds from CreatedAt; complaints stored before
+// timestamps were recorded have no age.
+func setAge(c *models.Complaint, now time.Time) {
+	if !c.CreatedAt.IsZero() {
+		c.AgeSeconds = int64(now.Sub(c.CreatedAt) / time.Second)
+	}
+}
+
+// truncateSummary shortens the summary for list responses when
+// LIST_TRUNCATE_SUMMARY is enabled (the default).
+func truncateSummary(c *models.Complaint) {
+	if !envBool("LIST_TRUNCATE_SUMMARY", true) {
+		return
+	}
+	max := envInt("LIST_SUMMARY_MAX_LENGTH", 200)
+	runes := []rune(c.Summary)
+	if max <= 0 || len(runes) <= max {
+		return
+	}
+	c.Summary = string(runes[:max]) + "…"
+	c.SummaryTruncated = true
+}
+
+func newStatusChange(r *http.Request, from, to string) models.StatusChange {
+	return models.StatusChange{From: from, To: to, ChangedBy: actorName(r), ChangedAt: time.Now().UTC()}
+}
+
+// actorName names whoever made the request for audit trails: the staff
+// member's name, else the signed-in user's email, or a placeholder for an
+// anonymous submitter.
+func actorName(r *http.Request) string {
+	if name, ok := adminIdentity(r); ok {
+		return name
+	}
+	if _, ok := trackingComplaint(r); ok {
+		return anonymousActor
+	}
+	if user, ok := currentTokenUser(r); ok {
+		return user.Email
+	}
+	return "unknown"
+}
+
+var client *mongo.Client
+var db *mongo.Database
+
+// userStore and complaintStore back the core user and complaint handlers.
+// documents is the same store seen as collections, which the handlers reach
+// only through it, so a store other than Mongo holds the only copy. db is
+// left with GridFS files and the schema migrations.
+var userStore store.UserStore
+var complaintStore store.ComplaintStore
+var documents store.Documents
+
+// orgCollection is one of the collections whose documents belong to an
+// organization, confined to the organization of each query's context.
+func orgCollection(name string) store.Collection {
+	return documents.OrgCollection(name)
+}
+
+func initDB() {
+	openDB()
+	migrateOnStart()
+	bootstrapSchema()
+	initCounter(complaintSequence)
+}
+
+// openDB connects to MongoDB, retrying while it starts up, and sets up the
+// stores.
+func openDB() {
+	var err error
+	attempts := envInt("MONGO_CONNECT_ATTEMPTS", 5)
+	backoff := envDuration("MONGO_CONNECT_BACKOFF", time.Second)
+	for attempt := 1; ; attempt++ {
+		client, err = connectDB(envString("MONGO_URI", "mongodb://localhost:27017/complain"))
+		if err == nil {
+			break
+		}
+		log.Printf("connect to MongoDB, attempt %d/%d: %v", attempt, attempts, err)
+		if attempt >= attempts {
+			log.Fatal(err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	db = client.Database(envString("MONGO_DATABASE", "complaintsPortal"))
+	listingDB = client.Database(db.Name(),
+		options.Database().SetReadPreference(readPreference("MONGO_LISTING_READ_PREFERENCE", "secondaryPreferred")))
+	mongoStore := store.NewMongo(client, db)
+	userStore, complaintStore, documents = mongoStore, mongoStore, mongoStore
+
+	// STORE_BACKEND=postgres moves every collection to PostgreSQL. MongoDB
+	// keeps GridFS files only.
+	if envString("STORE_BACKEND", "mongo") == "postgres" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		pg, err := store.OpenPostgres(ctx, envString("POSTGRES_DRIVER", "pgx"), envString("POSTGRES_DSN", ""))
+		if err != nil {
+			log.Fatalf("open PostgreSQL store: %v", err)
+		}
+		userStore, complaintStore, documents = pg, pg, pg
+	}
+	initCache()
+}
+
+// connectDB connects and pings, since Connect alone does not talk to the
+// server and would succeed even if MongoDB is not up yet.
+func connectDB(uri string) (*mongo.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c, err := mongo.Connect(ctx, replicationOptions(options.Client().ApplyURI(uri).SetMonitor(commandMonitor())))
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Ping(ctx, nil); err != nil {
+		c.Disconnect(context.Background())
+		return nil, err
+	}
+	return c, nil
+}
+
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req loginRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.SecretCode == "" {
+		writeFieldError(w, "secretCode", "is required")
+		return
+	}
+	user, err := userStore.UserBySecretCodeHash(dbContext(r), hashToken(req.SecretCode))
+	if err != nil || user.Erasing {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	tokens, err := startSession(r, user)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(loginResponse{tokenPair: tokens, User: user})
+}
+
+// registration is what a new user may choose about their account. The rest
+// of the user is set by the server: identities only by the single sign-on
+// callback, and the phone and its verification only by confirming a texted
+// code.
+type registration struct {
+	Name              string `json:"name"`
+	Email             string `json:"email"`
+	Locale            string `json:"locale"`
+	OrgID             string `json:"orgId"`
+	AcknowledgeEmails bool   `json:"acknowledgeEmails"`
+}
+
+func registerHandler(w http.ResponseWriter, r *http.Request) {
+	var reg registration
+	if err := decodeRegistration(r, &reg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user := models.User{
+		Name:              reg.Name,
+		Email:             reg.Email,
+		Locale:            reg.Locale,
+		OrgID:             reg.OrgID,
+		AcknowledgeEmails: reg.AcknowledgeEmails,
+	}
+
+	if errs := validateRegistration(&user); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	if user.OrgID != "" {
+		joins, err := mayJoinOrg(dbContext(r), user.OrgID, user.Email)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if !joins {
+			user.OrgID = ""
+		}
+	}
+
+	user.ID = primitive.NewObjectID()
+	user.Role = roleUser
+	if user.Locale == "" {
+		user.Locale = requestLocale(r)
+	}
+	code, err := generateSecretCode()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	user.SecretCodeHash = hashToken(code)
+	user.Complaints = []primitive.ObjectID{}
+	user.Verified = false
+	user.CreatedAt = time.Now().UTC()
+	user.UpdatedAt = user.CreatedAt
+
+	// The user is only kept if their verification email can be sent, so no
+	// account is left that can never be verified.
+	err = documents.InTransaction(dbContext(r), func(ctx context.Context) error {
+		if err := userStore.CreateUser(ctx, user); err != nil {
+			return err
+		}
+		return startEmailVerification(ctx, user)
+	})
+	if errors.Is(err, store.ErrDuplicate) {
+		http.Error(w, "Email already registered", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	recordAudit(r, auditRegister, auditTargetUser, user.ID, nil, user)
+
+	// This is the only time the code is returned; the user keeps it to log
+	// in, or rotates it if lost.
+	user.SecretCode = code
+	json.NewEncoder(w).Encode(user)
+}
+
+var emailPattern = regexp.MustCompile(`^[^\s@]+@[^\s@]+\.[^\s@]+$`)
+
+// normalizeEmail validates a bare address (no display name) and lowercases it
+// so uniqueness checks are case-insensitive.
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return "", err
+	}
+	if addr.Address != email || !emailPattern.MatchString(email) {
+		return "", errors.New("invalid email address")
+	}
+	return email, nil
+}
+
+type profileUpdate struct {
+	Name       string                `json:"name"`
+	Email      string                `json:"email"`
+	Locale     string                `json:"locale"`
+	SecretCode *string               `json:"secretCode"`
+	Complaints *[]primitive.ObjectID `json:"complaints"`
+	Version    *int                  `json:"version"`
+}
+
+// expectedVersion reads the version a client last saw from the If-Match header,
+// falling back to the version field in the request body.
+func expectedVersion(r *http.Request, body *int) (int, bool) {
+	if v := strings.Trim(r.Header.Get("If-Match"), `"`); v != "" {
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	}
+	if body != nil {
+		return *body, true
+	}
+	return 0, false
+}
+
+// updateProfileHandler replaces the caller's profile (PUT) or changes only
+// the fields sent (PATCH).
+func updateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := currentUserID(r)
+	before, err := userStore.UserByID(dbContext(r), userID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	var update profileUpdate
+	if r.Method == http.MethodPatch {
+		// Fields missing from the body keep these values.
+		update = profileUpdate{Name: before.Name, Email: before.Email, Locale: before.Locale}
+	}
+	if err := decodeJSON(r, &update); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errs := validateProfileUpdate(&update); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	version, ok := expectedVersion(r, update.Version)
+	if !ok {
+		http.Error(w, "A version is required via If-Match or the request body", http.StatusPreconditionRequired)
+		return
+	}
+
+	user, err := userStore.UpdateProfile(dbContext(r), userID, version,
+		store.ProfileUpdate{Name: update.Name, Email: update.Email, Locale: update.Locale})
+	if errors.Is(err, store.ErrDuplicate) {
+		http.Error(w, "Email already registered", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, store.ErrVersionConflict) {
+		http.Error(w, "Profile was modified by another request", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	// A new address has to be verified again.
+	if user.Email != before.Email {
+		if err := startEmailVerification(dbContext(r), user); err != nil {
+			requestLogger(r).Error("start email verification", "user_id", user.ID.Hex(), "error", err)
+		} else {
+			user.Verified = false
+		}
+	}
+	recordAudit(r, auditUpdateProfile, auditTargetUser, user.ID, before, user)
+
+	json.NewEncoder(w).Encode(user)
+}
+
+func submitComplaintHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.Complaint
+	if err := decodeComplaint(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := currentUserID(r)
+	if !req.UserID.IsZero() && req.UserID != userID {
+		http.Error(w, "userId does not match the authenticated user", http.StatusForbidden)
+		return
+	}
+	// Only what a reporter describes is taken from the request; the rest of
+	// the complaint is the server's to set.
+	complaint := models.Complaint{
+		Title:    req.Title,
+		Summary:  req.Summary,
+		Rating:   req.Rating,
+		Category: req.Category,
+		Priority: req.Priority,
+		UserID:   userID,
+	}
+	complaint.OrgID, _ = callerOrg(r)
+	if envBool("REQUIRE_VERIFIED_EMAIL", false) {
+		user, err := userStore.UserByID(dbContext(r), userID)
+		if err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if !user.Verified {
+			http.Error(w, "Please verify your email address before submitting complaints", http.StatusForbidden)
+			return
+		}
+	}
+
+	if errs := validateComplaint(&complaint); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	category, err := lookupCategory(dbContext(r), complaint.Category)
+	if errors.Is(err, errUnknownCategory) {
+		writeFieldError(w, "category", err.Error())
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	complaint.IdempotencyKey = strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+	if len(complaint.IdempotencyKey) > 255 {
+		writeFieldError(w, "Idempotency-Key", "must be at most 255 characters")
+		return
+	}
+	if complaint.IdempotencyKey != "" {
+		// Keys are honoured for IDEMPOTENCY_KEY_TTL; after that the key is
+		// released and the request is treated as a new submission.
+		expired := time.Now().Add(-envDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour))
+		if err := complaintStore.ReleaseIdempotencyKey(dbContext(r), complaint.UserID, complaint.IdempotencyKey, expired); err != nil {
+			serverError(w, err)
+			return
+		}
+		existing, err := complaintStore.ComplaintByIdempotencyKey(dbContext(r), complaint.UserID, complaint.IdempotencyKey)
+		if err == nil {
+			replaySubmission(w, existing)
+			return
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			serverError(w, err)
+			return
+		}
+	}
+
+	// force=true confirms that the caller has seen the possible duplicates
+	// and wants to file the complaint anyway.
+	var force bool
+	if v := r.URL.Query().Get("force"); v != "" {
+		if force, err = strconv.ParseBool(v); err != nil {
+			writeFieldError(w, "force", "must be true or false")
+			return
+		}
+	}
+	if window := envDuration("DUPLICATE_WINDOW", 60*time.Second); window > 0 && !force {
+		recent, err := complaintStore.ComplaintsSince(dbContext(r), complaint.UserID, time.Now().Add(-window))
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		for _, existing := range recent {
+			if existing.Title == complaint.Title && !existing.Resolved {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(existing)
+				return
+			}
+		}
+	}
+	if !force {
+		candidates, err := similarRecentComplaints(dbContext(r), complaint)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if len(candidates) > 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(possibleDuplicates{
+				Message:    "This looks like a complaint you already filed; resubmit with force=true to file it anyway",
+				Candidates: candidates,
+			})
+			return
+		}
+	}
+
+	quota, err := submissionQuota(dbContext(r), complaint.UserID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if msg := quota.exceeded(); msg != "" {
+		if quota.DailyResetIn > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(quota.DailyResetIn.Seconds())+1))
+		}
+		http.Error(w, msg, http.StatusTooManyRequests)
+		return
+	}
+
+	complaint.ContentHash = contentHash(complaint)
+	complaint.MasterID, err = findMaster(dbContext(r), complaint.ContentHash)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	// Submissions linked to a master are expected duplicates, not spam.
+	if complaint.MasterID == nil {
+		spam, err := isSpamBurst(dbContext(r), complaint)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if spam {
+			http.Error(w, "Too many similar complaints, please wait before submitting again", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	complaint.RefNumber, err = complaintStore.NextSequence(dbContext(r), complaintSequence)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	complaint.ID = primitive.NewObjectID()
+	complaint.Status = models.StatusOpen
+	complaint.CreatedAt = time.Now().UTC()
+	complaint.UpdatedAt = complaint.CreatedAt
+	complaint.DueAt = dueDate(complaint.CreatedAt, complaint.Priority)
+	if reasons := screenComplaint(dbContext(r), complaint); len(reasons) > 0 {
+		complaint.Flagged = true
+		complaint.Moderation = &models.Moderation{Status: models.ModerationFlagged, Reasons: reasons, FlaggedAt: complaint.CreatedAt}
+	} else {
+		complaint.AssignedTo, err = nextAutoAssignee(dbContext(r), category)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+	}
+
+	// The response is stored with the complaint so a retry gets exactly what
+	// this request returned, even after the complaint has since changed.
+	response := complaint
+	setQuota(&response, quota)
+	body, err := json.Marshal(response)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if complaint.IdempotencyKey != "" {
+		complaint.IdempotentResponse = body
+	}
+
+	var user models.User
+	err = withOutbox(dbContext(r), func(ctx context.Context) ([]outboxEvent, error) {
+		var err error
+		user, err = complaintStore.CreateComplaint(ctx, complaint)
+		if err != nil || complaint.Flagged {
+			return nil, err
+		}
+		return []outboxEvent{outboxEntry(eventComplaintCreated, complaint)}, nil
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, store.ErrDuplicate) && complaint.IdempotencyKey != "" {
+		// A concurrent retry with the same key won the race.
+		existing, err := complaintStore.ComplaintByIdempotencyKey(dbContext(r), complaint.UserID, complaint.IdempotencyKey)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		replaySubmission(w, existing)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	if complaint.MasterID != nil {
+		incrementLinkedCount(*complaint.MasterID)
+	}
+	recordAudit(r, auditSubmit, auditTargetComplaint, complaint.ID, nil, complaint)
+	go sendAcknowledgment(user, complaint)
+	complaintsSubmittedTotal.Inc()
+	// Staff hear about a held complaint once it is approved.
+	if !complaint.Flagged {
+		go publishEvent(eventComplaintCreated, complaint)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(body, '\n'))
+}
+
+// replaySubmission answers a retried submission with the response the
+// original request got. Complaints stored before responses were kept are
+// returned as they now stand.
+func replaySubmission(w http.ResponseWriter, existing models.Complaint) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	if len(existing.IdempotentResponse) == 0 {
+		json.NewEncoder(w).Encode(existing)
+		return
+	}
+	w.Write(append(existing.IdempotentResponse, '\n'))
+}
+
+const complaintSequence = "complaints"
+
+// initCounter creates a sequence document if it is missing. $setOnInsert
+// leaves an existing counter untouched, so instances starting at the same time
+// cannot reset it.
+func initCounter(name string) {
+	ctx, cancel := backgroundContext()
+	defer cancel()
+	_, err := documents.Collection("counters").UpdateOne(ctx,
+		bson.M{"_id": name},
+		bson.M{"$setOnInsert": bson.M{"seq": int64(0)}},
+		options.Update().SetUpsert(true))
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		log.Printf("init counter %s: %v", name, err)
+	}
+}
+
+type userComplaintsResponse struct {
+	Total      int64              `json:"total"`
+	Resolved   int64              `json:"resolved"`
+	Complaints []models.Complaint `json:"complaints"`
+	NextCursor string             `json:"nextCursor,omitempty"`
+}
+
+// getAllComplaintsForUserHandler pages through the caller's complaints like
+// the admin listing, sorted by createdAt (the default), updatedAt (last
+// activity), rating or status. sort=sla returns every matching complaint in
+// deadline order on one page instead.
+func getAllComplaintsForUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := currentUserID(r)
+	filter, errs := listFilter(r)
+	limit := intParam(r, "limit", 50, 1, 200, &errs)
+	sortBy := r.URL.Query().Get("sort")
+	if sortBy == "" {
+		sortBy = "createdAt"
+	}
+	field, ok := adminSortFields[sortBy]
+	if !ok && sortBy != "sla" {
+		errs.add("sort", "must be createdAt, updatedAt, rating, status or sla")
+	}
+	desc := orderParam(r, &errs)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	if !filter.UserID.IsZero() && filter.UserID != userID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	filter.UserID = userID
+
+	resp, err := cachedListing(r, userID, func() (userComplaintsResponse, error) {
+		return loadUserComplaints(r, filter, sortBy, field, desc, limit)
+	})
+	if errors.Is(err, errInvalidCursor) {
+		writeFieldError(w, "cursor", err.Error())
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	if notModified(w, r, listingETag(resp.Complaints, resp.Total, resp.Resolved, resp.NextCursor)) {
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func loadUserComplaints(r *http.Request, filter store.ComplaintFilter, sortBy, field string, desc bool, limit int) (userComplaintsResponse, error) {
+	var resp userComplaintsResponse
+	var err error
+	if sortBy == "sla" {
+		resp.Complaints, err = complaintStore.Complaints(dbContext(r), filter)
+		if err != nil {
+			return resp, err
+		}
+		now := time.Now()
+		for i := range resp.Complaints {
+			truncateSummary(&resp.Complaints[i])
+			limitAttachments(&resp.Complaints[i])
+			computeFields(&resp.Complaints[i], now)
+		}
+		sortBySLA(resp.Complaints)
+		addReporterAvatars(r, resp.Complaints)
+	} else {
+		resp.Complaints, resp.NextCursor, err = complaintPage(r, filter.BSON(), sortBy, field, desc, limit, nil)
+		if err != nil {
+			return resp, err
+		}
+	}
+	resp.Total, resp.Resolved, err = complaintStore.CountComplaints(dbContext(r), filter.UserID)
+	return resp, err
+}
+
+// sortBySLA puts unresolved complaints first, nearest deadline first so
+// overdue ones lead, followed by unresolved complaints without a deadline and
+// then resolved ones.
+func sortBySLA(complaints []models.Complaint) {
+	rank := func(c models.Complaint) int {
+		switch {
+		case c.Resolved:
+			return 2
+		case c.DueAt.IsZero():
+			return 1
+		default:
+			return 0
+		}
+	}
+	sort.SliceStable(complaints, func(i, j int) bool {
+		ri, rj := rank(complaints[i]), rank(complaints[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return ri == 0 && complaints[i].DueAt.Before(complaints[j].DueAt)
+	})
+}
+
+// listFilter parses the resolved, minRating, maxRating, userId and from/to
+// parameters shared by the complaint listings, reporting every bad parameter
+// at once. from and to may be bare dates, read in the tz time zone.
+func listFilter(r *http.Request) (store.ComplaintFilter, validationErrors) {
+	var filter store.ComplaintFilter
+	var errs validationErrors
+	q := r.URL.Query()
+	if v := q.Get("resolved"); v != "" {
+		resolved, err := strconv.ParseBool(v)
+		if err != nil {
+			errs.add("resolved", "must be true or false")
+		} else {
+			filter.Resolved = &resolved
+		}
+	}
+	filter.MinRating = intParam(r, "minRating", 0, 1, 5, &errs)
+	filter.MaxRating = intParam(r, "maxRating", 0, 1, 5, &errs)
+	if filter.MinRating > 0 && filter.MaxRating > 0 && filter.MinRating > filter.MaxRating {
+		errs.add("minRating", "cannot be greater than maxRating")
+	}
+	loc, err := reportLocation(r)
+	if err != nil {
+		errs.add("tz", "must be an IANA time zone")
+		loc = time.UTC
+	}
+	if filter.From, _, err = parseDateParam(r, "from", loc); err != nil {
+		errs.add("from", "must be an RFC3339 time or a date")
+	}
+	if filter.To, _, err = parseDateParam(r, "to", loc); err != nil {
+		errs.add("to", "must be an RFC3339 time or a date")
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		errs.add("from", "must be before to")
+	}
+	if v := q.Get("userId"); v != "" {
+		if filter.UserID, err = primitive.ObjectIDFromHex(v); err != nil {
+			errs.add("userId", "must be a valid ID")
+		}
+	}
+	filter.Category = strings.ToLower(strings.TrimSpace(q.Get("category")))
+	if v := q.Get("tags"); v != "" {
+		var ok bool
+		if filter.Tags, ok = normalizeTags(strings.Split(v, ",")); !ok {
+			errs.add("tags", "contains a tag that is too long")
+		}
+	}
+	return filter, errs
+}
+
+// adminComplaintFilter builds the Mongo filter shared by the admin listing and
+// export from listFilter's parameters plus assignedTo.
+func adminComplaintFilter(r *http.Request) (bson.M, validationErrors) {
+	parsed, errs := listFilter(r)
+	if v := r.URL.Query().Get("includeDeleted"); v != "" {
+		include, err := strconv.ParseBool(v)
+		switch {
+		case err != nil:
+			errs.add("includeDeleted", "must be true or false")
+		case include && callerRole(r) != roleAdmin:
+			errs.add("includeDeleted", "is only available to admins")
+		default:
+			parsed.IncludeDeleted = include
+		}
+	}
+	filter := parsed.BSON()
+	// Complaints awaiting moderation are only listed in the moderation queue.
+	filter["flagged"] = bson.M{"$ne": true}
+	switch agent := r.URL.Query().Get("assignedTo"); agent {
+	case "":
+	case "unassigned":
+		filter["assignedTo"] = bson.M{"$in": bson.A{"", nil}}
+	default:
+		filter["assignedTo"] = agent
+	}
+	return filter, errs
+}
+
+// duplicateCountStage counts every complaint sharing the document's content
+// hash, the document itself included.
+var duplicateCountStage = bson.M{"$lookup": bson.M{
+	"from": "complaints",
+	"let":  bson.M{"hash": "$contentHash"},
+	"pipeline": bson.A{
+		bson.M{"$match": bson.M{"$expr": bson.M{"$and": bson.A{
+			bson.M{"$gt": bson.A{"$$hash", nil}}, // excludes both null and missing
+			bson.M{"$eq": bson.A{"$contentHash", "$$hash"}},
+		}}}},
+		bson.M{"$count": "n"},
+	},
+	"as": "duplicates",
+}}
+
+type adminComplaintsPage struct {
+	Complaints []models.Complaint `json:"complaints"`
+	NextCursor string             `json:"nextCursor,omitempty"`
+	Total      int64              `json:"total"`
+}
+
+// adminSortParams parses the admin listing's sort and order parameters.
+func adminSortParams(r *http.Request, errs *validationErrors) (sortBy, field string, desc bool) {
+	sortBy = r.URL.Query().Get("sort")
+	if sortBy == "" {
+		sortBy = "createdAt"
+	}
+	field, ok := adminSortFields[sortBy]
+	if !ok {
+		errs.add("sort", "must be createdAt, updatedAt, rating or status")
+	}
+	return sortBy, field, orderParam(r, errs)
+}
+
+// orderParam reports whether order asks for descending order, the default.
+func orderParam(r *http.Request, errs *validationErrors) bool {
+	order := r.URL.Query().Get("order")
+	if order != "" && order != "asc" && order != "desc" {
+		errs.add("order", "must be asc or desc")
+	}
+	return order != "asc"
+}
+
+// getAllComplaintsForAdminHandler pages through complaints in (sort, _id)
+// order. sort is createdAt (the default), updatedAt, rating or status; order is desc
+// (the default) or asc. Pass nextCursor back as cursor for the next page.
+func getAllComplaintsForAdminHandler(w http.ResponseWriter, r *http.Request) {
+	if id := r.URL.Query().Get("savedSearch"); id != "" {
+		var ok bool
+		if r, ok = applySavedSearch(w, r, id); !ok {
+			return
+		}
+	}
+	filter, errs := adminComplaintFilter(r)
+	limit := intParam(r, "limit", 50, 1, 200, &errs)
+	sortBy, field, desc := adminSortParams(r, &errs)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	total, err := listingCollection("complaints").CountDocuments(dbContext(r), filter)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	var extra bson.A
+	if r.URL.Query().Get("includeDuplicateCount") == "true" {
+		extra = bson.A{
+			duplicateCountStage,
+			bson.M{"$set": bson.M{"duplicateCount": bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{
+				bson.M{"$ifNull": bson.A{bson.M{"$first": "$duplicates.n"}, 1}}, 1,
+			}}}}}},
+			bson.M{"$unset": "duplicates"},
+		}
+	}
+	complaints, next, err := complaintPage(r, filter, sortBy, field, desc, limit, extra)
+	if errors.Is(err, errInvalidCursor) {
+		writeFieldError(w, "cursor", err.Error())
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if notModified(w, r, listingETag(complaints, total, next)) {
+		return
+	}
+	json.NewEncoder(w).Encode(adminComplaintsPage{Complaints: complaints, NextCursor: next, Total: total})
+}
+
+// complaintPage returns up to limit complaints matching filter in (field,
+// _id) order, starting after ?cursor= if given, and the cursor for the next
+// page if there is one. extra stages run on the page after it is cut.
+func complaintPage(r *http.Request, filter bson.M, sortBy, field string, desc bool, limit int, extra bson.A) ([]models.Complaint, string, error) {
+	direction := 1
+	if desc {
+		direction = -1
+	}
+	match := filter
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		after, err := decodeCursor(v, sortBy, desc)
+		if err != nil {
+			return nil, "", err
+		}
+		match = bson.M{"$and": bson.A{filter, after.after(field)}}
+	}
+
+	pipeline := append(bson.A{
+		bson.M{"$match": match},
+		bson.M{"$sort": bson.D{{Key: field, Value: direction}, {Key: "_id", Value: direction}}},
+		bson.M{"$limit": limit + 1},
+	}, extra...)
+	cursor, err := listingCollection("complaints").Aggregate(dbContext(r), pipeline)
+	if err != nil {
+		return nil, "", err
+	}
+	var docs []bson.Raw
+	if err := cursor.All(dbContext(r), &docs); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(docs) > limit {
+		docs = docs[:limit]
+		last := docs[limit-1]
+		var id struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := bson.Unmarshal(last, &id); err != nil {
+			return nil, "", err
+		}
+		next, err = pageCursor{Sort: sortBy, Desc: desc, Value: sortValue(last, field), ID: id.ID}.encode()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	complaints := []models.Complaint{}
+	now := time.Now()
+	for _, doc := range docs {
+		var complaint models.Complaint
+		if err := bson.Unmarshal(doc, &complaint); err != nil {
+			return nil, "", err
+		}
+		truncateSummary(&complaint)
+		limitAttachments(&complaint)
+		computeFields(&complaint, now)
+		complaints = append(complaints, complaint)
+	}
+	addReporterAvatars(r, complaints)
+	return complaints, next, nil
+}
+
+func viewComplaintHandler(w http.ResponseWriter, r *http.Request) {
+	complaintID := r.URL.Query().Get("complaintId")
+	oid, err := primitive.ObjectIDFromHex(complaintID)
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+
+	complaint, err := complaintStore.Complaint(dbContext(r), oid)
+	if err != nil {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+	if !canAccess(r, complaint) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if r.URL.Query().Get("includeReporterContext") == "true" {
+		if _, ok := staffIdentity(r); !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		complaint.ReporterContext, err = reporterContext(dbContext(r), complaint)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+	}
+
+	computeFields(&complaint, time.Now())
+	one := []models.Complaint{complaint}
+	addReporterAvatars(r, one)
+	parts := []interface{}{complaintTag(one[0])}
+	for _, other := range complaint.ReporterContext {
+		parts = append(parts, other)
+	}
+	if notModified(w, r, weakETag(parts...)) {
+		return
+	}
+	json.NewEncoder(w).Encode(one[0])
+}
+
+// reporterContext returns the reporter's other unresolved complaints, newest
+// first. Anonymous complaints have no reporter and get an empty list.
+func reporterContext(ctx context.Context, c models.Complaint) ([]models.ReporterComplaint, error) {
+	others := []models.ReporterComplaint{}
+	if c.UserID.IsZero() {
+		return others, nil
+	}
+	cursor, err := orgCollection("complaints").Find(ctx,
+		bson.M{"userId": c.UserID, "_id": bson.M{"$ne": c.ID}, "resolved": false, "deletedAt": store.NotDeleted()},
+		options.Find().
+			SetSort(bson.M{"createdAt": -1}).
+			SetLimit(int64(envInt("REPORTER_CONTEXT_LIMIT", 5))).
+			SetProjection(bson.M{"title": 1, "status": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &others); err != nil {
+		return nil, err
+	}
+	for i := range others {
+		if others[i].Status == "" {
+			others[i].Status = models.StatusOpen
+		}
+	}
+	return others, nil
+}
+
+func resolveComplaintHandler(w http.ResponseWriter, r *http.Request) {
+	complaintID := r.URL.Query().Get("complaintId")
+	oid, err := primitive.ObjectIDFromHex(complaintID)
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+	version, ok := requireComplaintVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var complaint models.Complaint
+	err = orgCollection("complaints").FindOne(dbContext(r), bson.M{"_id": oid, "deletedAt": store.NotDeleted()}).Decode(&complaint)
+	if err != nil || !canAccess(r, complaint) {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+	if complaint.Version != version {
+		writeVersionConflict(w)
+		return
+	}
+
+	var note string
+	if id := r.URL.Query().Get("templateId"); id != "" {
+		note, err = applyReplyTemplate(dbContext(r), id, complaint, actorName(r))
+		if errors.Is(err, errUnknownTemplate) {
+			writeFieldError(w, "templateId", err.Error())
+			return
+		}
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+	}
+
+	target := models.StatusResolved
+	if envBool("RESOLUTION_APPROVAL", false) && !canApprove(r) {
+		target = models.StatusPendingApproval
+	}
+
+	from := complaint.CurrentStatus()
+	if from == models.StatusClosed {
+		http.Error(w, "Complaint is closed", http.StatusConflict)
+		return
+	}
+	if from != models.StatusResolved && from != target {
+		before := complaint
+		change := newStatusChange(r, from, target)
+		complaint.Status = target
+		complaint.Resolved = target == models.StatusResolved
+		complaint.ResolvedBy = change.ChangedBy
+		complaint.History = append(complaint.History, change)
+		set := bson.M{"resolved": complaint.Resolved, "status": complaint.Status, "resolvedBy": complaint.ResolvedBy}
+		if complaint.Resolved {
+			complaint.ResolvedAt = &change.ChangedAt
+			set["resolvedAt"] = change.ChangedAt
+		}
+		if note != "" {
+			complaint.ResolutionNote = note
+			set["resolutionNote"] = note
+		}
+		filter := bson.M{"_id": oid, "deletedAt": store.NotDeleted()}
+		update := bson.M{"$set": set, "$push": bson.M{"history": change}}
+		withVersion(filter, update, version)
+		complaint.Version++
+		var matched bool
+		err := withOutbox(dbContext(r), func(ctx context.Context) ([]outboxEvent, error) {
+			res, err := orgCollection("complaints").UpdateOne(ctx, filter, update)
+			if err != nil {
+				return nil, err
+			}
+			matched = res.MatchedCount > 0
+			if !matched {
+				return nil, nil
+			}
+			event := eventComplaintStatus
+			if complaint.Resolved {
+				event = eventComplaintResolved
+			}
+			return []outboxEvent{outboxEntry(event, complaint)}, nil
+		})
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if !matched {
+			writeVersionConflict(w)
+			return
+		}
+		recordAudit(r, auditResolve, auditTargetComplaint, oid, before, complaint)
+		if complaint.Resolved {
+			complaintsResolvedTotal.Inc()
+			go publishEvent(eventComplaintResolved, complaint)
+			go notifyOwner(complaint, templateResolution, note)
+		}
+	}
+
+	json.NewEncoder(w).Encode(complaint)
+}
+
+func deleteComplaintHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	complaintID := r.URL.Query().Get("complaintId")
+	oid, err := primitive.ObjectIDFromHex(complaintID)
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+
+	complaint, err := complaintStore.Complaint(dbContext(r), oid)
+	if err != nil {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+
+	if !canAccess(r, complaint) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	deleted, err := complaintStore.DeleteComplaint(dbContext(r), oid, actorName(r))
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	recordAudit(r, auditDelete, auditTargetComplaint, complaint.ID, complaint, deleted)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func restoreComplaintHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("complaintId"))
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+
+	complaint, err := complaintStore.Complaint(dbContext(r), oid)
+	if err != nil || complaint.DeletedAt == nil {
+		http.Error(w, "Deleted complaint not found", http.StatusNotFound)
+		return
+	}
+
+	restored, err := complaintStore.RestoreComplaint(dbContext(r), oid)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Deleted complaint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	recordAudit(r, auditRestore, auditTargetComplaint, oid, complaint, restored)
+
+	computeFields(&restored, time.Now())
+	json.NewEncoder(w).Encode(restored)
+}
+
+func complaintHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	complaintID := r.URL.Query().Get("complaintId")
+	oid, err := primitive.ObjectIDFromHex(complaintID)
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+
+	var complaint models.Complaint
+	err = orgCollection("complaints").FindOne(dbContext(r), bson.M{"_id": oid},
+		options.FindOne().SetProjection(bson.M{"history": 1, "userId": 1, "deletedAt": 1})).Decode(&complaint)
+	if err != nil {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+	if !canAccess(r, complaint) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	history := complaint.History
+	if history == nil {
+		history = []models.StatusChange{}
+	}
+	json.NewEncoder(w).Encode(history)
+}
+
+// resolveBatchHandler resolves the complaints whose IDs make up the body. It
+// is the bulk resolve action under its older name.
+func resolveBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var ids []string
+	if err := decodeJSON(r, &ids); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	applyBulk(w, r, bulkRequest{IDs: ids, Action: bulkResolve})
+}
+
+// Run connects to MongoDB, starts the background jobs and serves the API on
+// :8080.
+func Run() {
+	initLogging()
+	initMetrics()
+	shutdownTracing := initTracing()
+	initDB()
+	initNotifier()
+	initAdminTokens()
+	initTicketSync()
+	initSLA()
+	initAuth()
+	initOIDC()
+	initScreening()
+	initChat()
+	initSMS()
+	initI18n()
+	initBroker()
+	if envBool("SEED", false) {
+		seedDatabase()
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		closeBroker()
+		if err := client.Disconnect(ctx); err != nil {
+			log.Printf("disconnect from MongoDB: %v", err)
+		}
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("flush traces: %v", err)
+		}
+	}()
+
+	staffOnly := requireRole(staffRoles...)
+	anyRole := requireRole(append(staffRoles, roleUser)...)
+
+	authLimiter := newRateLimiter("auth", envInt("AUTH_RATE_LIMIT_PER_MINUTE", 10))
+	submitLimiter := newRateLimiter("submit", envInt("SUBMIT_RATE_LIMIT_PER_MINUTE", 5))
+	apiLimiter := newRateLimiter("api", envInt("API_RATE_LIMIT_PER_MINUTE", 600))
+	initRateLimits()
+
+	// The RPC-style routes predate /api/v1 and stay on for existing clients
+	// unless LEGACY_ROUTES is turned off.
+	if envBool("LEGACY_ROUTES", true) {
+		handle("/login", authLimiter.limit(loginHandler))
+		handle("/register", authLimiter.limit(registerHandler))
+		handle("/refresh", authLimiter.limit(refreshHandler))
+		handle("/logout", logoutHandler)
+		handle("/sessions", requireUser(sessionsHandler))
+		handle("/notifications", requireUser(notificationsHandler))
+		handle("/readNotification", requireUser(readNotificationHandler))
+		handle("/readAllNotifications", requireUser(readAllNotificationsHandler))
+		handle("/avatar", anyRole(avatarHandler))
+		handle("/myAvatar", requireUser(myAvatarHandler))
+		handle("/myPhone", authLimiter.limit(requireUser(myPhoneHandler)))
+		handle("/verifyPhone", authLimiter.limit(requireUser(verifyPhoneHandler)))
+		handle("/notificationPreferences", requireUser(notificationPreferencesHandler))
+		handle("/setUserRole", requireRole(roleAdmin)(setUserRoleHandler))
+		handle("/orgs", requireRole(roleAdmin)(orgsHandler))
+		handle("/apiKeys", requireRole(roleAdmin)(apiKeysHandler))
+		handle("/inviteOrgAdmin", requireRole(roleAdmin)(inviteOrgAdminHandler))
+		handle("/acceptInvite", authLimiter.limit(acceptInviteHandler))
+		handle("/updateProfile", requireUser(updateProfileHandler))
+		handle("/exportMyData", requireUser(exportMyDataHandler))
+		handle("/deleteAccount", requireUser(deleteAccountHandler))
+		handle("/cancelAccountDeletion", requireUser(cancelAccountDeletionHandler))
+		handle("/rotateSecretCode", authLimiter.limit(requireUser(rotateSecretCodeHandler)))
+		handle("/verifyEmail", authLimiter.limit(verifyEmailHandler))
+		handle("/oidcLogin", authLimiter.limit(oidcLoginHandler))
+		handle("/oidcCallback", authLimiter.limit(oidcCallbackHandler))
+		handle("/resendVerification", authLimiter.limit(requireUser(resendVerificationHandler)))
+		handle("/submitComplaint", requireUser(submitLimiter.limitCaller(submitComplaintHandler)))
+		handle("/submitAnonymousComplaint", authLimiter.limit(submitAnonymousComplaintHandler))
+		handle("/trackComplaint", authLimiter.limit(trackComplaintHandler))
+		handle("/trackComplaint/comments", authLimiter.limit(trackComplaintCommentsHandler))
+		handle("/getAllComplaintsForUser", requireUser(getAllComplaintsForUserHandler))
+		handle("/getAllComplaintsForAdmin", staffOnly(getAllComplaintsForAdminHandler))
+		handle("/complaints/search", anyRole(searchComplaintsHandler))
+		handle("/complaints/", anyRole(complaintResourceHandler))
+		handle("/viewComplaint", anyRole(viewComplaintHandler))
+		handle("/resolveComplaint", staffOnly(resolveComplaintHandler))
+		handle("/watchComplaint", requireUser(watchComplaintHandler))
+		handle("/unwatchComplaint", requireUser(unwatchComplaintHandler))
+		handle("/watchedComplaints", requireUser(watchedComplaintsHandler))
+		handle("/setFollowUp", requireUser(setFollowUpHandler))
+		handle("/reopenComplaint", requireUser(reopenComplaintHandler))
+		handle("/approveResolution", staffOnly(approveResolutionHandler))
+		handle("/linkTicket", staffOnly(linkTicketHandler))
+		handle("/unlinkTicket", staffOnly(unlinkTicketHandler))
+		handle("/complaintHistory", anyRole(complaintHistoryHandler))
+		handle("/editComplaint", requireUser(editComplaintHandler))
+		handle("/complaintRevisions", anyRole(complaintRevisionsHandler))
+		handle("/complaintFeedback", anyRole(complaintFeedbackHandler))
+		handle("/auditLog", staffOnly(auditLogHandler))
+		handle("/auditLogs", requireRole(roleAdmin)(auditLogsHandler))
+		handle("/deleteComplaint", anyRole(deleteComplaintHandler))
+		handle("/restoreComplaint", requireRole(roleAdmin)(restoreComplaintHandler))
+		handle("/recategorizeComplaint", staffOnly(recategorizeComplaintHandler))
+		handle("/categorySuggestions", staffOnly(categorySuggestionsHandler))
+		handle("/categories", anyRole(categoriesHandler))
+		handle("/replyTemplates", staffOnly(replyTemplatesHandler))
+		handle("/savedSearches", staffOnly(savedSearchesHandler))
+		handle("/tagComplaint", staffOnly(tagComplaintHandler))
+		handle("/untagComplaint", staffOnly(untagComplaintHandler))
+		handle("/complaintTags", requireRole(roleAdmin)(tagsHandler))
+		handle("/webhooks", requirePlatformAdmin(webhooksHandler))
+		handle("/webhookDeliveries", requirePlatformAdmin(webhookDeliveriesHandler))
+		handle("/admin/chat", requirePlatformAdmin(chatChannelsHandler))
+		handle("/admin/chat/test", requirePlatformAdmin(testChatHandler))
+		handle("/assignComplaint", staffOnly(assignComplaintHandler))
+		handle("/unassignComplaint", staffOnly(unassignComplaintHandler))
+		handle("/myAssignedComplaints", staffOnly(myAssignedComplaintsHandler))
+		handle("/resolveBatch", staffOnly(resolveBatchHandler))
+		handle("/admin/complaints/bulk", staffOnly(bulkComplaintsHandler))
+		handle("/masterComplaint", staffOnly(masterComplaintHandler))
+		handle("/mergeComplaint", staffOnly(mergeComplaintHandler))
+		handle("/relatedComplaints", staffOnly(relatedComplaintsHandler))
+		handle("/complaintClusters", staffOnly(complaintClustersHandler))
+		handle("/complaintChanges", staffOnly(complaintChangesHandler))
+		handle("/complaintSocket", staffOnly(complaintSocketHandler))
+		handle("/events", anyRole(eventsHandler))
+		handle("/admin/complaints/export", staffOnly(exportComplaintsHandler))
+		handle("/exportComplaints.csv", staffOnly(exportComplaintsCSVHandler))
+		handle("/exportComplaints.xlsx", staffOnly(exportComplaintsXLSXHandler))
+		handle("/complaintsByReporter", staffOnly(complaintsByReporterHandler))
+		handle("/resolutionTimes", staffOnly(resolutionTimesHandler))
+		handle("/ratingBreakdown", staffOnly(ratingBreakdownHandler))
+		handle("/categoryBreakdown", staffOnly(categoryBreakdownHandler))
+		handle("/complaintHeatmap", staffOnly(complaintHeatmapHandler))
+		handle("/admin/jobs", requirePlatformAdmin(jobsHandler))
+		handle("/admin/import", requireRole(roleAdmin)(importHandler))
+		handle("/admin/archive", requireRole(roleAdmin)(archivedComplaintsHandler))
+		handle("/admin/stats", staffOnly(adminStatsHandler))
+		handle("/admin/dashboard", staffOnly(dashboardHandler))
+		handle("/moderationQueue", requireRole(roleAdmin)(moderationQueueHandler))
+		handle("/approveFlagged", requireRole(roleAdmin)(approveFlaggedHandler))
+		handle("/rejectFlagged", requireRole(roleAdmin)(rejectFlaggedHandler))
+	}
+	registerAPIRoutes(staffOnly, anyRole, authLimiter, submitLimiter)
+	handle("/graphql", graphqlHandler)
+	handle("/time", timeHandler)
+	handle("/healthz", healthzHandler)
+	handle("/readyz", readyzHandler)
+	handle("/metrics", promhttp.Handler().ServeHTTP)
+	handle("/openapi.json", openAPIHandler)
+	handle("/docs", docsHandler)
+	checkAPISpec()
+
+	registerJob("follow-ups", envDuration("FOLLOWUP_CHECK_INTERVAL", time.Minute), sendFollowUps)
+	registerAutoResolve()
+	registerRetention()
+	registerExpiry()
+	registerPriorityAging()
+	registerJob("sla-escalation", envDuration("SLA_ESCALATION_INTERVAL", 5*time.Minute), escalateOverdue)
+	registerJob("escalation-chains", envDuration("ESCALATION_CHAIN_INTERVAL", 5*time.Minute), escalateChains)
+	erasureInterval := envDuration("ACCOUNT_ERASURE_CHECK_INTERVAL", time.Hour)
+	registerJob("account-erasure", erasureInterval, func(ctx context.Context) error {
+		return eraseDueAccounts(ctx, erasureInterval)
+	})
+	registerOutboxPublisher()
+	startJobs()
+	go refreshComplaintGauges(envDuration("METRICS_REFRESH_INTERVAL", 30*time.Second))
+	go watchCacheInvalidations()
+
+	var stopServers []func()
+	if addr := envString("GRPC_ADDR", ""); addr != "" {
+		grpcSrv := serveGRPC(addr, apiLimiter)
+		stopServers = append(stopServers, func() { stopGRPC(grpcSrv, envDuration("SHUTDOWN_TIMEOUT", 30*time.Second)) })
+	}
+	serve(envString("LISTEN_ADDR", ":8080"), withTracing(withRequestLog(withRecovery(withDBTimeout(withCompression(withContentNegotiation(withLocalization(withCORS(withServerTime(withAuthentication(withRateLimit(apiLimiter, withMetrics(http.DefaultServeMux)))))))))))), stopServers...)
+}
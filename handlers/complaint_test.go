@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"complain/models"
+	"complain/store"
+)
+
+// TestMain points the handlers at an in-memory store for the whole run.
+// Handlers leave goroutines behind, such as webhook deliveries, that read
+// the stores after a test ends, so every test shares this one and files
+// its data under users of its own.
+func TestMain(m *testing.M) {
+	memory := store.NewMemory()
+	userStore, complaintStore, documents = memory, memory, memory
+	jwtSecret, accessTTL, refreshTTL = []byte("test secret"), time.Minute, time.Hour
+	adminTokens = map[string]staffMember{
+		testAdminToken: {Name: "Ada", Role: roleAdmin},
+		"agent token":  {Name: "Sam", Role: roleAgent},
+	}
+	initCounter(complaintSequence)
+	os.Exit(m.Run())
+}
+
+const testAdminToken = "admin token"
+
+func newEmail() string {
+	return primitive.NewObjectID().Hex() + "@example.com"
+}
+
+// call serves one request through the authentication middleware, with
+// token as the bearer token unless it is empty.
+func call(t *testing.T, h http.HandlerFunc, method, target, body, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	withAuthentication(h).ServeHTTP(w, r)
+	return w
+}
+
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+		t.Fatalf("decode response %q: %v", w.Body.String(), err)
+	}
+}
+
+// registerAndLogin registers a user and logs them in, returning the user
+// and their access token.
+func registerAndLogin(t *testing.T, email string) (models.User, string) {
+	t.Helper()
+	w := call(t, registerHandler, http.MethodPost, "/register", `{"name":"Ann","email":"`+email+`"}`, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("register: %d %s", w.Code, w.Body)
+	}
+	var user models.User
+	decodeResponse(t, w, &user)
+	if user.SecretCode == "" {
+		t.Fatal("register returned no secret code")
+	}
+	if stored, _ := storedUser(t, strings.ToLower(email)); stored.Verified || stored.Version != 0 {
+		t.Errorf("registered user = %+v, want them unverified at version 0", stored)
+	}
+
+	w = call(t, loginHandler, http.MethodPost, "/login", `{"secretCode":"`+user.SecretCode+`"}`, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", w.Code, w.Body)
+	}
+	var login loginResponse
+	decodeResponse(t, w, &login)
+	if login.User.ID != user.ID || login.AccessToken == "" {
+		t.Fatalf("login = %+v, want a token for user %s", login, user.ID.Hex())
+	}
+	return user, login.AccessToken
+}
+
+// storedUser looks a user up by email, reporting whether one is stored.
+func storedUser(t *testing.T, email string) (models.User, bool) {
+	t.Helper()
+	var user models.User
+	err := documents.Collection("users").FindOne(context.Background(), bson.M{"email": email}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return user, false
+	}
+	if err != nil {
+		t.Fatalf("find user %s: %v", email, err)
+	}
+	return user, true
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	email := newEmail()
+	registerAndLogin(t, strings.ToUpper(email))
+
+	w := call(t, registerHandler, http.MethodPost, "/register", `{"name":"Ann","email":"`+email+`"}`, "")
+	if w.Code != http.StatusConflict {
+		t.Errorf("registering a taken email: %d %s, want 409", w.Code, w.Body)
+	}
+	w = call(t, registerHandler, http.MethodPost, "/register", `{"name":"","email":"not an email"}`, "")
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("registering an invalid user: %d %s, want 422", w.Code, w.Body)
+	}
+	w = call(t, loginHandler, http.MethodPost, "/login", `{"secretCode":"wrong"}`, "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("login with a wrong code: %d %s, want 404", w.Code, w.Body)
+	}
+	w = call(t, loginHandler, http.MethodGet, "/login?secretCode=wrong", "", "")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("login over GET: %d %s, want 405", w.Code, w.Body)
+	}
+}
+
+func TestRegisterRejectsServerFields(t *testing.T) {
+	for _, field := range []string{
+		`"identities":[{"provider":"google","subject":"1"}]`,
+		`"phone":"+14155550123"`,
+		`"phoneVerified":true`,
+		`"notifyChannels":["sms"]`,
+		`"verified":true`,
+		`"version":7`,
+	} {
+		email := newEmail()
+		w := call(t, registerHandler, http.MethodPost, "/register", `{"name":"Ann","email":"`+email+`",`+field+`}`, "")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("registering with %s: %d %s, want 400", field, w.Code, w.Body)
+		}
+		if _, found := storedUser(t, email); found {
+			t.Errorf("registering with %s stored a user", field)
+		}
+	}
+}
+
+func TestRegisterJoinsOrg(t *testing.T) {
+	ctx := context.Background()
+	orgID := primitive.NewObjectID().Hex()
+	if _, err := documents.Collection("organizations").InsertOne(ctx, models.Organization{
+		ID: orgID, Name: "Acme", EmailDomains: []string{"acme.example"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	invited := newEmail()
+	if _, err := documents.Collection("orgInvites").InsertOne(ctx, orgInvite{
+		Hash: primitive.NewObjectID().Hex(), OrgID: orgID, Email: invited, ExpiresAt: time.Now().Add(time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name, email, orgID, want string
+	}{
+		{"allowed domain", primitive.NewObjectID().Hex() + "@acme.example", orgID, orgID},
+		{"invited", invited, orgID, orgID},
+		{"other domain", newEmail(), orgID, ""},
+		{"unknown organization", primitive.NewObjectID().Hex() + "@acme.example", "no-such-org", ""},
+	} {
+		w := call(t, registerHandler, http.MethodPost, "/register", `{"name":"Ann","email":"`+tc.email+`","orgId":"`+tc.orgID+`"}`, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: register: %d %s", tc.name, w.Code, w.Body)
+		}
+		if user, _ := storedUser(t, tc.email); user.OrgID != tc.want {
+			t.Errorf("%s: registered in organization %q, want %q", tc.name, user.OrgID, tc.want)
+		}
+	}
+}
+
+func TestSubmitAndListComplaints(t *testing.T) {
+	user, token := registerAndLogin(t, newEmail())
+	_, other := registerAndLogin(t, newEmail())
+	submit := requireUser(submitComplaintHandler)
+
+	if w := call(t, submit, http.MethodPost, "/submitComplaint", `{"title":"Late parcel"}`, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("submitting signed out: %d %s, want 401", w.Code, w.Body)
+	}
+	if w := call(t, submit, http.MethodPost, "/submitComplaint", `{"title":" "}`, token); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("submitting without a title: %d %s, want 422", w.Code, w.Body)
+	}
+
+	var submitted models.Complaint
+	for _, title := range []string{"Late parcel", "Broken box"} {
+		w := call(t, submit, http.MethodPost, "/submitComplaint", `{"title":"`+title+`","summary":"Never came","rating":2}`, token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("submit %q: %d %s", title, w.Code, w.Body)
+		}
+		decodeResponse(t, w, &submitted)
+		if submitted.UserID != user.ID || submitted.RefNumber == 0 {
+			t.Errorf("submitted = %+v, want it numbered and filed for %s", submitted, user.ID.Hex())
+		}
+	}
+	stored, err := userStore.UserByID(context.Background(), user.ID)
+	if err != nil || len(stored.Complaints) != 2 {
+		t.Errorf("user's complaints = %v, %v, want both submissions linked", stored.Complaints, err)
+	}
+
+	list := requireUser(getAllComplaintsForUserHandler)
+	w := call(t, list, http.MethodGet, "/getAllComplaintsForUser", "", token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("list: %d %s", w.Code, w.Body)
+	}
+	var listed userComplaintsResponse
+	decodeResponse(t, w, &listed)
+	if listed.Total != 2 || len(listed.Complaints) != 2 || listed.Complaints[0].ID != submitted.ID {
+		t.Errorf("listing = %+v, want both complaints, newest first", listed)
+	}
+	w = call(t, list, http.MethodGet, "/getAllComplaintsForUser", "", other)
+	decodeResponse(t, w, &listed)
+	if listed.Total != 0 || len(listed.Complaints) != 0 {
+		t.Errorf("another user's listing = %+v, want it empty", listed)
+	}
+
+	view := requireUser(viewComplaintHandler)
+	w = call(t, view, http.MethodGet, "/viewComplaint?complaintId="+submitted.ID.Hex(), "", token)
+	var viewed models.Complaint
+	if w.Code != http.StatusOK {
+		t.Fatalf("view: %d %s", w.Code, w.Body)
+	}
+	decodeResponse(t, w, &viewed)
+	if viewed.ID != submitted.ID || viewed.Title != "Broken box" {
+		t.Errorf("viewed = %+v, want %s", viewed, submitted.ID.Hex())
+	}
+	if w := call(t, view, http.MethodGet, "/viewComplaint?complaintId="+submitted.ID.Hex(), "", other); w.Code != http.StatusForbidden {
+		t.Errorf("viewing another user's complaint: %d %s, want 403", w.Code, w.Body)
+	}
+}
+
+// TestSubmitIgnoresServerFields checks that a submission keeps only what a
+// reporter describes, whatever else the body says.
+func TestSubmitIgnoresServerFields(t *testing.T) {
+	_, token := registerAndLogin(t, newEmail())
+	submit := requireUser(submitComplaintHandler)
+	for _, tc := range []struct {
+		field string
+		set   func(c models.Complaint) bool
+	}{
+		{`"history":[{"from":"open","to":"resolved","changedBy":"admin","changedAt":"2024-01-01T00:00:00Z"}]`,
+			func(c models.Complaint) bool { return len(c.History) > 0 }},
+		{`"tags":["vip"]`, func(c models.Complaint) bool { return len(c.Tags) > 0 }},
+		{`"escalationLevel":3`, func(c models.Complaint) bool { return c.EscalationLevel != 0 }},
+		{`"revision":4,"editedAt":"2024-01-01T00:00:00Z"`, func(c models.Complaint) bool { return c.Revision != 0 || c.EditedAt != nil }},
+		{`"anonymous":true`, func(c models.Complaint) bool { return c.Anonymous }},
+		{`"externalTicket":{"system":"jira","id":"OPS-1"}`, func(c models.Complaint) bool { return c.ExternalTicket != nil }},
+	} {
+		w := call(t, submit, http.MethodPost, "/submitComplaint?force=true", `{"title":"`+primitive.NewObjectID().Hex()+`",`+tc.field+`}`, token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("submit with %s: %d %s", tc.field, w.Code, w.Body)
+		}
+		var submitted models.Complaint
+		decodeResponse(t, w, &submitted)
+		stored, err := complaintStore.Complaint(context.Background(), submitted.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.set(submitted) || tc.set(stored) {
+			t.Errorf("submitting with %s set it: %+v", tc.field, stored)
+		}
+	}
+}
+
+func TestSubmitComplaintIdempotencyKey(t *testing.T) {
+	user, token := registerAndLogin(t, newEmail())
+	submit := requireUser(submitComplaintHandler)
+
+	var first, second models.Complaint
+	for _, c := range []*models.Complaint{&first, &second} {
+		r := httptest.NewRequest(http.MethodPost, "/submitComplaint", strings.NewReader(`{"title":"Late parcel"}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("Idempotency-Key", "retry-1")
+		w := httptest.NewRecorder()
+		withAuthentication(submit).ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("submit: %d %s", w.Code, w.Body)
+		}
+		decodeResponse(t, w, c)
+	}
+	if first.ID != second.ID {
+		t.Errorf("a retry with the same Idempotency-Key filed %s after %s", second.ID.Hex(), first.ID.Hex())
+	}
+	total, _, err := complaintStore.CountComplaints(context.Background(), user.ID)
+	if err != nil || total != 1 {
+		t.Errorf("complaints on file = %d, %v, want 1", total, err)
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	email, taken := newEmail(), newEmail()
+	_, token := registerAndLogin(t, email)
+	registerAndLogin(t, taken)
+	update := requireUser(updateProfileHandler)
+
+	w := call(t, update, http.MethodPatch, "/updateProfile", `{"name":"Annie","version":0}`, token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("update: %d %s", w.Code, w.Body)
+	}
+	var user models.User
+	decodeResponse(t, w, &user)
+	if user.Name != "Annie" || user.Email != email || user.Version != 1 {
+		t.Errorf("updated = %+v, want the new name at version 1", user)
+	}
+	for _, tc := range []struct {
+		name, body string
+		want       int
+	}{
+		{"stale version", `{"name":"Ann","version":0}`, http.StatusConflict},
+		{"taken email", `{"email":"` + taken + `","version":1}`, http.StatusConflict},
+		{"no version", `{"name":"Ann"}`, http.StatusPreconditionRequired},
+	} {
+		if w := call(t, update, http.MethodPatch, "/updateProfile", tc.body, token); w.Code != tc.want {
+			t.Errorf("%s: %d %s, want %d", tc.name, w.Code, w.Body, tc.want)
+		}
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		fail bool
+	}{
+		{in: "ann@example.com", want: "ann@example.com"},
+		{in: "  Ann@Example.COM ", want: "ann@example.com"},
+		{in: "", fail: true},
+		{in: "ann", fail: true},
+		{in: "ann@localhost", fail: true},
+		{in: "Ann <ann@example.com>", fail: true},
+		{in: "ann@@example.com", fail: true},
+		{in: "ann smith@example.com", fail: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeEmail(tt.in)
+		if tt.fail != (err != nil) {
+			t.Errorf("normalizeEmail(%q) error = %v, want failure %v", tt.in, err, tt.fail)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("normalizeEmail(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
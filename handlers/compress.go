@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// uncompressedTypes are media types not worth compressing again, as prefixes.
+// Attachments and avatars are mostly images, PDFs and archives; XLSX exports
+// are zip files already; event streams must reach the client as written.
+const uncompressedTypes = "image/,video/,audio/,font/woff,application/zip,application/gzip,application/x-gzip," +
+	"application/pdf,application/octet-stream,application/vnd.openxmlformats-,text/event-stream"
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(v, 64); err == nil && q == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipWriter holds back the first COMPRESSION_MIN_BYTES of a response to
+// decide whether it is worth compressing: small bodies, bodiless statuses,
+// responses that set their own Content-Encoding and uncompressedTypes go out
+// as they are.
+type gzipWriter struct {
+	http.ResponseWriter
+	minBytes int
+	excluded []string
+	status   int
+	buf      []byte
+	decided  bool
+	gz       *gzip.Writer
+}
+
+func (g *gzipWriter) WriteHeader(code int) {
+	if g.status == 0 {
+		g.status = code
+	}
+	// Informational responses carry no body and go out at once.
+	if code < http.StatusOK {
+		g.ResponseWriter.WriteHeader(code)
+		g.status = 0
+	}
+}
+
+func (g *gzipWriter) Write(b []byte) (int, error) {
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	if !g.decided {
+		g.buf = append(g.buf, b...)
+		if len(g.buf) < g.minBytes {
+			return len(b), nil
+		}
+		if err := g.decide(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if g.gz != nil {
+		return g.gz.Write(b)
+	}
+	return g.ResponseWriter.Write(b)
+}
+
+// decide sends the headers, compressing if the response qualifies and has
+// reached the threshold, and then whatever was held back.
+func (g *gzipWriter) decide() error {
+	g.decided = true
+	h := g.Header()
+	if len(g.buf) >= g.minBytes && g.compressible() {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+	buf := g.buf
+	g.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if g.gz != nil {
+		_, err = g.gz.Write(buf)
+	} else {
+		_, err = g.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (g *gzipWriter) compressible() bool {
+	h := g.Header()
+	if g.status == http.StatusNoContent || g.status == http.StatusNotModified || h.Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(g.buf)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range g.excluded {
+		if strings.HasPrefix(mediaType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// Flush sends what is held back even below the threshold, since a handler
+// that flushes wants the client to see it now.
+func (g *gzipWriter) Flush() {
+	if !g.decided {
+		if g.status == 0 {
+			g.status = http.StatusOK
+		}
+		if len(g.buf) > 0 && g.compressible() {
+			g.minBytes = 0
+		}
+		if g.decide() != nil {
+			return
+		}
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close sends anything still held back and ends the gzip stream.
+func (g *gzipWriter) close() error {
+	if !g.decided {
+		if g.status == 0 {
+			return nil
+		}
+		if err := g.decide(); err != nil {
+			return err
+		}
+	}
+	if g.gz != nil {
+		return g.gz.Close()
+	}
+	return nil
+}
+
+func (g *gzipWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := g.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	g.decided = true
+	return h.Hijack()
+}
+
+func (g *gzipWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// withCompression gzips responses for clients that accept it, unless
+// RESPONSE_COMPRESSION is off. COMPRESSION_EXCLUDED_TYPES replaces the list
+// of media type prefixes sent uncompressed. Brotli is not offered; gzip is
+// what every client accepts.
+func withCompression(next http.Handler) http.Handler {
+	if !envBool("RESPONSE_COMPRESSION", true) {
+		return next
+	}
+	minBytes := envInt("COMPRESSION_MIN_BYTES", 1024)
+	excluded := splitList(envString("COMPRESSION_EXCLUDED_TYPES", uncompressedTypes))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w, minBytes: minBytes, excluded: excluded}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsPolicy is read from CORS_* settings. With no allowed origins CORS is
+// off and browsers keep enforcing same-origin.
+type corsPolicy struct {
+	origins     []string
+	anyOrigin   bool
+	methods     string
+	headers     string
+	exposed     string
+	credentials bool
+	maxAge      string
+}
+
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func loadCORSPolicy() corsPolicy {
+	origins := splitList(envString("CORS_ALLOWED_ORIGINS", ""))
+	return corsPolicy{
+		origins:     origins,
+		anyOrigin:   slices.Contains(origins, "*"),
+		methods:     strings.Join(splitList(envString("CORS_ALLOWED_METHODS", "GET,POST,PUT,PATCH,DELETE")), ", "),
+		headers:     strings.Join(splitList(envString("CORS_ALLOWED_HEADERS", "Authorization,Content-Type,X-Admin-Token,X-API-Key,Idempotency-Key,X-Request-ID,Last-Event-ID,If-None-Match")), ", "),
+		exposed:     strings.Join(splitList(envString("CORS_EXPOSED_HEADERS", "X-Request-ID,X-Server-Time,Retry-After,Content-Disposition,ETag")), ", "),
+		credentials: envBool("CORS_ALLOW_CREDENTIALS", false),
+		maxAge:      strconv.Itoa(int(envDuration("CORS_MAX_AGE", 10*time.Minute).Seconds())),
+	}
+}
+
+func (p corsPolicy) allows(origin string) bool {
+	return p.anyOrigin || slices.Contains(p.origins, origin)
+}
+
+// withCORS answers preflight requests itself, before authentication and
+// rate limiting could reject them, and adds CORS headers to responses for
+// allowed origins.
+func withCORS(next http.Handler) http.Handler {
+	policy := loadCORSPolicy()
+	if len(policy.origins) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+		}
+		if !policy.allows(origin) {
+			if preflight {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// A wildcard cannot be combined with credentials, so the origin is
+		// echoed back whenever credentials are allowed.
+		if policy.anyOrigin && !policy.credentials {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if policy.credentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if preflight {
+			h.Set("Access-Control-Allow-Methods", policy.methods)
+			h.Set("Access-Control-Allow-Headers", policy.headers)
+			h.Set("Access-Control-Max-Age", policy.maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if policy.exposed != "" {
+			h.Set("Access-Control-Expose-Headers", policy.exposed)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
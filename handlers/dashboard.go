@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"complain/models"
+	"complain/store"
+)
+
+type dashboardCounts struct {
+	Open            int `json:"open"`
+	Reopened        int `json:"reopened"`
+	InProgress      int `json:"inProgress"`
+	PendingApproval int `json:"pendingApproval"`
+	Overdue         int `json:"overdue"`
+	Unassigned      int `json:"unassigned"`
+}
+
+// weekComparison compares the last seven days with the seven before them.
+type weekComparison struct {
+	ThisWeek int `json:"thisWeek"`
+	LastWeek int `json:"lastWeek"`
+	Delta    int `json:"delta"`
+	// PercentChange is null when there was nothing last week to compare with.
+	PercentChange *float64 `json:"percentChange"`
+}
+
+type agentWorkload struct {
+	Agent   string `bson:"_id" json:"agent"`
+	Open    int    `bson:"open" json:"open"`
+	Overdue int    `bson:"overdue" json:"overdue"`
+}
+
+type dashboardSummary struct {
+	GeneratedAt time.Time          `json:"generatedAt"`
+	Counts      dashboardCounts    `json:"counts"`
+	Submitted   weekComparison     `json:"submitted"`
+	Resolved    weekComparison     `json:"resolved"`
+	Workload    []agentWorkload    `json:"workload"`
+	Oldest      []models.Complaint `json:"oldest"`
+}
+
+func compareWeeks(thisWeek, lastWeek int) weekComparison {
+	c := weekComparison{ThisWeek: thisWeek, LastWeek: lastWeek, Delta: thisWeek - lastWeek}
+	if lastWeek > 0 {
+		pct := float64(c.Delta) / float64(lastWeek) * 100
+		c.PercentChange = &pct
+	}
+	return c
+}
+
+// dashboardHandler gathers what an admin dashboard shows in one aggregation:
+// current workflow counts, this week against last, open work per agent and
+// the oldest unresolved complaints (oldest, default 5).
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	var errs validationErrors
+	oldest := intParam(r, "oldest", 5, 1, 50, &errs)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	now := time.Now().UTC()
+	weekAgo, twoWeeksAgo := now.AddDate(0, 0, -7), now.AddDate(0, 0, -14)
+	count := func(match bson.M) bson.A {
+		return bson.A{bson.M{"$match": match}, bson.M{"$count": "n"}}
+	}
+	unresolved := bson.M{"resolved": false}
+	// In aggregation expressions a missing dueAt compares below any date, so
+	// the type is checked first.
+	isOverdue := bson.M{"$and": bson.A{
+		bson.M{"$eq": bson.A{bson.M{"$type": "$dueAt"}, "date"}},
+		bson.M{"$lt": bson.A{"$dueAt", now}},
+	}}
+
+	cursor, err := listingCollection("complaints").Aggregate(dbContext(r), bson.A{
+		bson.M{"$match": bson.M{"deletedAt": store.NotDeleted()}},
+		bson.M{"$facet": bson.M{
+			"open":              count(statusFilter(models.StatusOpen)),
+			"reopened":          count(statusFilter(models.StatusReopened)),
+			"inProgress":        count(statusFilter(models.StatusInProgress)),
+			"pendingApproval":   count(statusFilter(models.StatusPendingApproval)),
+			"overdue":           count(bson.M{"resolved": false, "dueAt": bson.M{"$lt": now}}),
+			"unassigned":        count(bson.M{"resolved": false, "assignedTo": bson.M{"$in": bson.A{nil, ""}}}),
+			"submittedThisWeek": count(bson.M{"createdAt": bson.M{"$gte": weekAgo}}),
+			"submittedLastWeek": count(bson.M{"createdAt": bson.M{"$gte": twoWeeksAgo, "$lt": weekAgo}}),
+			"resolvedThisWeek":  count(bson.M{"resolved": true, "resolvedAt": bson.M{"$gte": weekAgo}}),
+			"resolvedLastWeek":  count(bson.M{"resolved": true, "resolvedAt": bson.M{"$gte": twoWeeksAgo, "$lt": weekAgo}}),
+			"workload": bson.A{
+				bson.M{"$match": bson.M{"resolved": false, "assignedTo": bson.M{"$nin": bson.A{nil, ""}}}},
+				bson.M{"$group": bson.M{
+					"_id":     "$assignedTo",
+					"open":    bson.M{"$sum": 1},
+					"overdue": bson.M{"$sum": bson.M{"$cond": bson.A{isOverdue, 1, 0}}},
+				}},
+				bson.M{"$sort": bson.D{{Key: "open", Value: -1}, {Key: "_id", Value: 1}}},
+			},
+			"oldest": bson.A{
+				bson.M{"$match": unresolved},
+				bson.M{"$sort": bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}},
+				bson.M{"$limit": oldest},
+			},
+		}},
+	})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	type total []struct {
+		N int `bson:"n"`
+	}
+	var facets []struct {
+		Open              total              `bson:"open"`
+		Reopened          total              `bson:"reopened"`
+		InProgress        total              `bson:"inProgress"`
+		PendingApproval   total              `bson:"pendingApproval"`
+		Overdue           total              `bson:"overdue"`
+		Unassigned        total              `bson:"unassigned"`
+		SubmittedThisWeek total              `bson:"submittedThisWeek"`
+		SubmittedLastWeek total              `bson:"submittedLastWeek"`
+		ResolvedThisWeek  total              `bson:"resolvedThisWeek"`
+		ResolvedLastWeek  total              `bson:"resolvedLastWeek"`
+		Workload          []agentWorkload    `bson:"workload"`
+		Oldest            []models.Complaint `bson:"oldest"`
+	}
+	if err := cursor.All(dbContext(r), &facets); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	n := func(t total) int {
+		if len(t) == 0 {
+			return 0
+		}
+		return t[0].N
+	}
+	summary := dashboardSummary{GeneratedAt: now, Workload: []agentWorkload{}, Oldest: []models.Complaint{}}
+	if len(facets) > 0 {
+		f := facets[0]
+		summary.Counts = dashboardCounts{
+			Open:            n(f.Open),
+			Reopened:        n(f.Reopened),
+			InProgress:      n(f.InProgress),
+			PendingApproval: n(f.PendingApproval),
+			Overdue:         n(f.Overdue),
+			Unassigned:      n(f.Unassigned),
+		}
+		summary.Submitted = compareWeeks(n(f.SubmittedThisWeek), n(f.SubmittedLastWeek))
+		summary.Resolved = compareWeeks(n(f.ResolvedThisWeek), n(f.ResolvedLastWeek))
+		summary.Workload = append(summary.Workload, f.Workload...)
+		summary.Oldest = append(summary.Oldest, f.Oldest...)
+	}
+	for i := range summary.Oldest {
+		setSLAStatus(&summary.Oldest[i], now)
+		truncateSummary(&summary.Oldest[i])
+	}
+
+	json.NewEncoder(w).Encode(summary)
+}
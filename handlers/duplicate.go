@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"complain/models"
+)
+
+type duplicateCandidate struct {
+	Complaint  models.Complaint `json:"complaint"`
+	Similarity float64          `json:"similarity"`
+}
+
+// possibleDuplicates is the 409 body when a submission resembles the user's
+// recent complaints. Resubmitting with force=true files it anyway.
+type possibleDuplicates struct {
+	Message    string               `json:"message"`
+	Candidates []duplicateCandidate `json:"candidates"`
+}
+
+// similarRecentComplaints finds the user's unresolved complaints from the
+// last DUPLICATE_SIMILARITY_WINDOW whose title and summary keywords are at
+// least DUPLICATE_SIMILARITY_PERCENT similar to c, most similar first.
+func similarRecentComplaints(ctx context.Context, c models.Complaint) ([]duplicateCandidate, error) {
+	window := envDuration("DUPLICATE_SIMILARITY_WINDOW", 7*24*time.Hour)
+	if window <= 0 {
+		return nil, nil
+	}
+	recent, err := complaintStore.ComplaintsSince(ctx, c.UserID, time.Now().Add(-window))
+	if err != nil {
+		return nil, err
+	}
+
+	minLen := envInt("CLUSTER_MIN_WORD_LENGTH", 3)
+	threshold := float64(envInt("DUPLICATE_SIMILARITY_PERCENT", 60)) / 100
+	target := keywords(c, minLen)
+	var candidates []duplicateCandidate
+	for _, existing := range recent {
+		if existing.Resolved || existing.DeletedAt != nil {
+			continue
+		}
+		if sim := jaccard(target, keywords(existing, minLen)); sim >= threshold {
+			truncateSummary(&existing)
+			candidates = append(candidates, duplicateCandidate{Complaint: existing, Similarity: sim})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Similarity > candidates[j].Similarity })
+	if max := envInt("DUPLICATE_SIMILARITY_MAX_CANDIDATES", 5); len(candidates) > max {
+		candidates = candidates[:max]
+	}
+	return candidates, nil
+}
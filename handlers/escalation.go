@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+	"complain/store"
+)
+
+// Priorities in ascending order of urgency.
+var priorities = []string{"low", "medium", "high", "urgent"}
+
+const defaultPriority = "medium"
+
+func priorityRank(p string) int {
+	for i, name := range priorities {
+		if name == p {
+			return i
+		}
+	}
+	return -1
+}
+
+// reopenEscalationThresholds parses REOPEN_ESCALATION_THRESHOLDS, a
+// comma-separated list of reopen counts at which priority is bumped one level.
+func reopenEscalationThresholds() map[int]bool {
+	thresholds := make(map[int]bool)
+	for _, v := range strings.Split(envString("REOPEN_ESCALATION_THRESHOLDS", "2,4"), ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n > 0 {
+			thresholds[n] = true
+		}
+	}
+	return thresholds
+}
+
+type reopenRequest struct {
+	Reason string `json:"reason"`
+}
+
+// reopenComplaintHandler lets the reporter reopen a complaint that was not
+// actually fixed, within REOPEN_WINDOW of its resolution (0 for no limit).
+// The reason is kept on the history entry and the agent who resolved it is
+// told.
+func reopenComplaintHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("complaintId"))
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+	var req reopenRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Reason = strings.TrimSpace(req.Reason); req.Reason == "" {
+		writeFieldError(w, "reason", "is required")
+		return
+	}
+
+	before, err := complaintStore.Complaint(dbContext(r), oid)
+	userID, _ := currentUserID(r)
+	if err != nil || before.UserID != userID || before.CurrentStatus() != models.StatusResolved {
+		http.Error(w, "Resolved complaint not found", http.StatusNotFound)
+		return
+	}
+	if window := envDuration("REOPEN_WINDOW", 14*24*time.Hour); window > 0 &&
+		before.ResolvedAt != nil && time.Since(*before.ResolvedAt) > window {
+		http.Error(w, "The reopen window for this complaint has passed; please submit a new complaint", http.StatusConflict)
+		return
+	}
+
+	change := newStatusChange(r, models.StatusResolved, models.StatusReopened)
+	change.Reason = req.Reason
+	update := transitionUpdate(change)
+	filter := bson.M{"$and": bson.A{bson.M{"_id": oid}, statusFilter(models.StatusResolved)}}
+	withVersion(filter, update, before.Version)
+	var complaint models.Complaint
+	err = updateComplaint(dbContext(r), eventComplaintReopened, filter, update, &complaint)
+	if err == mongo.ErrNoDocuments {
+		writeVersionConflict(w)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	recordAudit(r, auditTransition, auditTargetComplaint, oid, before, complaint)
+
+	go publishEvent(eventComplaintReopened, complaint)
+	go notifyAssignee(complaint, fmt.Sprintf("Complaint %s reopened", complaint.ID.Hex()),
+		fmt.Sprintf("%q was reopened by its reporter: %s\n", complaint.Title, req.Reason))
+	escalateReopened(&complaint)
+
+	json.NewEncoder(w).Encode(complaint)
+}
+
+// escalateComplaint raises the complaint's priority by one level, records why,
+// and tells the assigned agent. Complaints already at the top are left alone.
+func escalateComplaint(complaint *models.Complaint, reason string) error {
+	rank := priorityRank(complaint.Priority)
+	if rank < 0 {
+		rank = priorityRank(defaultPriority)
+	}
+	if rank == len(priorities)-1 {
+		return nil
+	}
+
+	escalation := models.Escalation{From: complaint.Priority, To: priorities[rank+1], Reason: reason, At: time.Now().UTC()}
+	ctx, cancel := backgroundContext()
+	defer cancel()
+	escalated := *complaint
+	escalated.Priority = escalation.To
+	escalated.Version++
+	escalated.Escalations = append(escalated.Escalations, escalation)
+	err := withOutbox(ctx, func(ctx context.Context) ([]outboxEvent, error) {
+		_, err := orgCollection("complaints").UpdateOne(ctx, bson.M{"_id": complaint.ID}, bson.M{
+			"$set":  bson.M{"priority": escalation.To},
+			"$push": bson.M{"escalations": escalation},
+			"$inc":  bson.M{"version": 1},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []outboxEvent{outboxEntry(eventComplaintEscalated, escalated)}, nil
+	})
+	if err != nil {
+		return err
+	}
+	*complaint = escalated
+
+	go publishEvent(eventComplaintEscalated, *complaint)
+	go notifyOwner(*complaint, templateEscalation, reason)
+
+	go notifyAssignee(*complaint, fmt.Sprintf("Complaint %s escalated to %s", complaint.ID.Hex(), escalation.To),
+		fmt.Sprintf("%q was escalated from %s to %s: %s.\n", complaint.Title, escalation.From, escalation.To, reason))
+	return nil
+}
+
+// notifyAssignee emails the assigned agent when their identifier is an email
+// address; agents referenced by name alone cannot be notified yet.
+func notifyAssignee(complaint models.Complaint, subject, body string) {
+	if notifier == nil || complaint.AssignedTo == "" {
+		return
+	}
+	if _, err := mail.ParseAddress(complaint.AssignedTo); err != nil {
+		return
+	}
+	if err := notifier.Notify(complaint.AssignedTo, subject, body); err != nil {
+		log.Printf("notify assignee of complaint %s: %v", complaint.ID.Hex(), err)
+	}
+}
+
+// escalateOverdue escalates unresolved complaints that are past their due
+// date and tells the admins. Each complaint is claimed by setting
+// slaEscalatedAt, so it is escalated only once.
+func escalateOverdue(ctx context.Context) error {
+	for {
+		now := time.Now().UTC()
+		var complaint models.Complaint
+		err := updateComplaint(ctx, eventComplaintOverdue,
+			bson.M{
+				"resolved":       false,
+				"dueAt":          bson.M{"$lt": now},
+				"slaEscalatedAt": bson.M{"$exists": false},
+				"deletedAt":      store.NotDeleted(),
+			},
+			bson.M{"$set": bson.M{"slaEscalatedAt": now}, "$inc": bson.M{"version": 1}},
+			&complaint)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := escalateComplaint(&complaint, "SLA deadline passed"); err != nil {
+			log.Printf("escalate overdue complaint %s: %v", complaint.ID.Hex(), err)
+		}
+		go publishEvent(eventComplaintOverdue, complaint)
+		go notifyAdmins(complaint.OrgID, fmt.Sprintf("Complaint %s is overdue", complaint.Reference()),
+			fmt.Sprintf("%q was due %s and is now %s priority.\n", complaint.Title, complaint.DueAt.Format(time.RFC1123), complaint.Priority))
+	}
+}
+
+// escalateChains moves unresolved complaints up their category's escalation
+// chain once they have waited past a level's After, straight to the highest
+// level due, reassigning them and recording the step. A complaint changed in
+// the meantime is left for the next run.
+func escalateChains(ctx context.Context) error {
+	cursor, err := documents.Collection("categories").Find(ctx, bson.M{"escalationChain.0": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	var categories []models.Category
+	if err := cursor.All(ctx, &categories); err != nil {
+		return err
+	}
+	for _, category := range categories {
+		for i := len(category.EscalationChain) - 1; i >= 0; i-- {
+			level := category.EscalationChain[i]
+			after, err := time.ParseDuration(level.After)
+			if err != nil || after <= 0 {
+				log.Printf("escalation chain of category %s: level %d: invalid after %q", category.ID, i+1, level.After)
+				continue
+			}
+			if err := escalateToLevel(ctx, category.ID, i+1, level, after); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func escalateToLevel(ctx context.Context, category string, n int, level models.EscalationLevel, after time.Duration) error {
+	cursor, err := orgCollection("complaints").Find(ctx, bson.M{
+		"category":        category,
+		"resolved":        false,
+		"flagged":         bson.M{"$ne": true},
+		"escalationLevel": bson.M{"$not": bson.M{"$gte": n}},
+		"createdAt":       bson.M{"$lt": time.Now().Add(-after)},
+		"deletedAt":       store.NotDeleted(),
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var c models.Complaint
+		if err := cursor.Decode(&c); err != nil {
+			return err
+		}
+		if c.AssignedTo == level.Assignee {
+			continue
+		}
+		now := time.Now().UTC()
+		name := level.Name
+		if name == "" {
+			name = level.Assignee
+		}
+		escalation := models.Escalation{Level: n, From: c.AssignedTo, To: level.Assignee,
+			Reason: fmt.Sprintf("unresolved after %s, escalated to %s", after, name), At: now}
+		assignment := models.Assignment{From: c.AssignedTo, To: level.Assignee, By: "escalation chain", At: now}
+		filter := bson.M{"_id": c.ID, "version": c.Version, "assignedTo": assigneeFilter(c.AssignedTo)}
+		c.AssignedTo, c.EscalationLevel = level.Assignee, n
+		c.Escalations = append(c.Escalations, escalation)
+		c.Assignments = append(c.Assignments, assignment)
+		c.Version++
+		var modified bool
+		err := withOutbox(ctx, func(ctx context.Context) ([]outboxEvent, error) {
+			res, err := orgCollection("complaints").UpdateOne(ctx, filter, bson.M{
+				"$set":  bson.M{"assignedTo": level.Assignee, "escalationLevel": n},
+				"$push": bson.M{"escalations": escalation, "assignments": assignment},
+				"$inc":  bson.M{"version": 1},
+			})
+			if err != nil {
+				return nil, err
+			}
+			if modified = res.ModifiedCount > 0; !modified {
+				return nil, nil
+			}
+			return []outboxEvent{outboxEntry(eventComplaintEscalated, c)}, nil
+		})
+		if err != nil {
+			log.Printf("escalate complaint %s up its chain: %v", c.ID.Hex(), err)
+			continue
+		}
+		if !modified {
+			continue
+		}
+
+		go publishEvent(eventComplaintEscalated, c)
+		go notifyAssignee(c, fmt.Sprintf("Complaint %s escalated to you", c.Reference()),
+			fmt.Sprintf("%q was %s.\n", c.Title, escalation.Reason))
+	}
+	return cursor.Err()
+}
+
+// notifyAdmins alerts the admins of an organization, and the platform admins,
+// on the channels they chose; texts carry only the subject.
+func notifyAdmins(org, subject, body string) {
+	if notifier == nil && sms == nil {
+		return
+	}
+	ctx, cancel := backgroundContext()
+	defer cancel()
+	cursor, err := orgCollection("users").Find(ctx, bson.M{"role": roleAdmin, "orgId": bson.M{"$in": bson.A{nil, "", org}}},
+		options.Find().SetProjection(bson.M{"email": 1, "phone": 1, "phoneVerified": 1, "notifyChannels": 1}))
+	if err != nil {
+		log.Printf("find admins to notify: %v", err)
+		return
+	}
+	var admins []models.User
+	if err := cursor.All(ctx, &admins); err != nil {
+		log.Printf("find admins to notify: %v", err)
+		return
+	}
+	for _, admin := range admins {
+		if notifier != nil && wantsChannel(admin, channelEmail) {
+			if err := notifier.Notify(admin.Email, subject, body); err != nil {
+				log.Printf("notify admin %s: %v", admin.ID.Hex(), err)
+			}
+		}
+		if sms != nil && admin.PhoneVerified && wantsChannel(admin, channelSMS) {
+			if err := sms.Notify(admin.Phone, "", subject); err != nil {
+				log.Printf("text admin %s: %v", admin.ID.Hex(), err)
+			}
+		}
+	}
+}
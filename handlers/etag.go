@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"complain/models"
+)
+
+// weakETag hashes the parts that identify a response into a weak entity tag.
+func weakETag(parts ...interface{}) string {
+	h := sha256.New()
+	for _, p := range parts {
+		fmt.Fprintf(h, "%v\x00", p)
+	}
+	return `W/"` + base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// complaintTag is what a complaint contributes to an ETag. Every stored change
+// moves updatedAt, and the SLA status can change with time alone; ageSeconds
+// is left out, so responses differing only in age count as the same.
+func complaintTag(c models.Complaint) string {
+	duplicates := -1
+	if c.DuplicateCount != nil {
+		duplicates = *c.DuplicateCount
+	}
+	return fmt.Sprintf("%s/%d/%d/%s/%s/%d", c.ID.Hex(), c.Version, c.UpdatedAt.UnixNano(), c.SLAStatus, c.ReporterAvatarURL, duplicates)
+}
+
+// notModified sets the response's ETag and lets clients keep a private copy
+// as long as they revalidate it. It answers 304 and returns true when
+// If-None-Match already names the tag.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// listingETag tags a page of complaints together with the page's own fields,
+// such as its totals and next cursor.
+func listingETag(complaints []models.Complaint, page ...interface{}) string {
+	parts := page
+	for _, c := range complaints {
+		parts = append(parts, complaintTag(c))
+	}
+	return weakETag(parts...)
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+// eventsPipeline narrows the change stream to what the caller may see. Staff
+// see every change; users only see their own complaints, which rules out
+// deletes since those carry no document to check ownership against.
+func eventsPipeline(r *http.Request) mongo.Pipeline {
+	match := bson.M{"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}}}
+	if _, ok := staffIdentity(r); !ok {
+		userID, _ := currentUserID(r)
+		match = bson.M{
+			"operationType":       bson.M{"$in": bson.A{"insert", "update", "replace"}},
+			"fullDocument.userId": userID,
+		}
+	}
+	return mongo.Pipeline{{{Key: "$match", Value: match}}}
+}
+
+// sseEventName maps a change stream operation to the event name clients
+// subscribe to.
+func sseEventName(operation string, updatedFields bson.M) string {
+	switch operation {
+	case "insert":
+		return "created"
+	case "delete":
+		return "deleted"
+	}
+	if updatedFields["resolved"] == true {
+		return "resolved"
+	}
+	return "updated"
+}
+
+// eventsHandler streams complaint created, updated, resolved and deleted
+// events as Server-Sent Events. Each event's id is a resume token, so a
+// reconnecting EventSource picks up where it left off via Last-Event-ID.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// The await time bounds how long TryNext blocks, and so how late a
+	// heartbeat can be.
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup).SetMaxAwaitTime(time.Second)
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(id)
+		if err != nil || bson.Raw(raw).Validate() != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		opts.SetResumeAfter(bson.Raw(raw))
+	}
+
+	ctx, cancel := context.WithCancel(streamContext(r))
+	defer cancel()
+
+	stream, err := orgCollection("complaints").Watch(ctx, eventsPipeline(r), opts)
+	if staleResumeToken(err) {
+		// The client missed too much to resume; tell it to reload rather
+		// than silently skipping events.
+		writeResync(w)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer stream.Close(context.Background())
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(envDuration("SSE_HEARTBEAT_INTERVAL", 15*time.Second))
+	defer heartbeat.Stop()
+
+	for {
+		if stream.TryNext(ctx) {
+			var event struct {
+				OperationType string `bson:"operationType"`
+				DocumentKey   struct {
+					ID primitive.ObjectID `bson:"_id"`
+				} `bson:"documentKey"`
+				FullDocument      *models.Complaint `bson:"fullDocument"`
+				UpdateDescription struct {
+					UpdatedFields bson.M `bson:"updatedFields"`
+				} `bson:"updateDescription"`
+				ClusterTime primitive.Timestamp `bson:"clusterTime"`
+			}
+			if err := stream.Decode(&event); err != nil {
+				return
+			}
+			if event.OperationType == "invalidate" {
+				return
+			}
+			data, err := json.Marshal(complaintChange{
+				Operation:   event.OperationType,
+				ComplaintID: event.DocumentKey.ID,
+				Complaint:   event.FullDocument,
+				ChangedAt:   time.Unix(int64(event.ClusterTime.T), 0).UTC(),
+			})
+			if err != nil {
+				return
+			}
+			id := base64.RawURLEncoding.EncodeToString(stream.ResumeToken())
+			name := sseEventName(event.OperationType, event.UpdateDescription.UpdatedFields)
+			if _, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, name, data); err != nil {
+				return
+			}
+			flusher.Flush()
+			continue
+		}
+		if stream.Err() != nil || ctx.Err() != nil {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		default:
+		}
+	}
+}
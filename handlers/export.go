@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+// exportRow is a complaint with its reporter's name joined in.
+type exportRow struct {
+	models.Complaint `bson:",inline"`
+	UserName         string `bson:"userName"`
+}
+
+// exportColumn is one column of the CSV and Excel exports. value returns a
+// string, number, bool or time, or nil for an empty cell.
+type exportColumn struct {
+	header string
+	value  func(c exportRow) interface{}
+}
+
+func optionalTime(t *time.Time) interface{} {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+// localClock moves t to the same wall-clock time in UTC. Excel cells carry
+// no zone and excelize writes the UTC clock, so this makes a sheet show
+// times in loc.
+func localClock(t time.Time, loc *time.Location) time.Time {
+	l := t.In(loc)
+	return time.Date(l.Year(), l.Month(), l.Day(), l.Hour(), l.Minute(), l.Second(), l.Nanosecond(), time.UTC)
+}
+
+// exportColumns keeps the columns of the original CSV export first, in
+// their original order, so existing spreadsheets keep working.
+var exportColumns = []exportColumn{
+	{"id", func(c exportRow) interface{} { return c.ID.Hex() }},
+	{"title", func(c exportRow) interface{} { return c.Title }},
+	{"summary", func(c exportRow) interface{} { return c.Summary }},
+	{"rating", func(c exportRow) interface{} { return c.Rating }},
+	{"resolved", func(c exportRow) interface{} { return c.Resolved }},
+	{"userId", func(c exportRow) interface{} { return c.UserID.Hex() }},
+	{"createdAt", func(c exportRow) interface{} { return optionalTime(&c.CreatedAt) }},
+	{"refNumber", func(c exportRow) interface{} { return c.RefNumber }},
+	{"userName", func(c exportRow) interface{} { return c.UserName }},
+	{"status", func(c exportRow) interface{} { return c.CurrentStatus() }},
+	{"category", func(c exportRow) interface{} { return c.Category }},
+	{"priority", func(c exportRow) interface{} { return c.Priority }},
+	{"assignedTo", func(c exportRow) interface{} { return c.AssignedTo }},
+	{"resolvedAt", func(c exportRow) interface{} { return optionalTime(c.ResolvedAt) }},
+}
+
+// exportCursor streams the complaints matching filter with their
+// reporters' names. allowDiskUse lets the sort spill for large exports.
+func exportCursor(r *http.Request, filter bson.M, sortBy bson.D) (*mongo.Cursor, error) {
+	return listingCollection("complaints").Aggregate(streamContext(r), bson.A{
+		bson.M{"$match": filter},
+		bson.M{"$sort": sortBy},
+		bson.M{"$lookup": bson.M{"from": "users", "localField": "userId", "foreignField": "_id", "as": "reporter"}},
+		bson.M{"$set": bson.M{"userName": bson.M{"$ifNull": bson.A{bson.M{"$first": "$reporter.name"}, ""}}}},
+		bson.M{"$unset": "reporter"},
+	}, options.Aggregate().SetAllowDiskUse(true))
+}
+
+// exportComplaintsHandler serves /admin/complaints/export, picking the
+// format from the format parameter.
+func exportComplaintsHandler(w http.ResponseWriter, r *http.Request) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "csv":
+		exportComplaintsCSVHandler(w, r)
+	case "xlsx":
+		exportComplaintsXLSXHandler(w, r)
+	default:
+		writeFieldError(w, "format", "must be csv or xlsx")
+	}
+}
+
+func exportComplaintsCSVHandler(w http.ResponseWriter, r *http.Request) {
+	filter, errs := adminComplaintFilter(r)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	loc, _ := reportLocation(r)
+	cursor, err := exportCursor(r, filter, bson.D{{Key: "_id", Value: 1}})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer cursor.Close(streamContext(r))
+
+	filename := fmt.Sprintf("complaints-%s.csv", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	// Rows are written straight from the cursor; the csv.Writer flushes its
+	// buffer to the response as it fills. Once output starts, errors can only
+	// be logged.
+	out := csv.NewWriter(w)
+	record := make([]string, len(exportColumns))
+	for i, col := range exportColumns {
+		record[i] = col.header
+	}
+	out.Write(record)
+	for cursor.Next(streamContext(r)) {
+		var c exportRow
+		if err := cursor.Decode(&c); err != nil {
+			requestLogger(r).Error("export complaints", "error", err)
+			break
+		}
+		for i, col := range exportColumns {
+			switch v := col.value(c).(type) {
+			case nil:
+				record[i] = ""
+			case time.Time:
+				record[i] = v.In(loc).Format(time.RFC3339)
+			case string:
+				record[i] = csvText(v)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		out.Write(record)
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		requestLogger(r).Error("export complaints", "error", err)
+	}
+}
+
+// csvText keeps a spreadsheet from running text as a formula by quoting
+// any that starts like one.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// exportComplaintsXLSXHandler writes the filtered complaints as an Excel
+// workbook. With sheetPerCategory=true each category gets its own sheet.
+// Rows go through excelize's stream writer, which spills to disk rather than
+// holding the sheet in memory, and the export stops at EXPORT_MAX_ROWS.
+func exportComplaintsXLSXHandler(w http.ResponseWriter, r *http.Request) {
+	filter, errs := adminComplaintFilter(r)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	loc, _ := reportLocation(r)
+	perCategory := r.URL.Query().Get("sheetPerCategory") == "true"
+	maxRows := envInt("EXPORT_MAX_ROWS", 100000)
+
+	sortBy := bson.D{{Key: "_id", Value: 1}}
+	if perCategory {
+		sortBy = bson.D{{Key: "category", Value: 1}, {Key: "_id", Value: 1}}
+	}
+	cursor, err := exportCursor(r, filter, sortBy)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer cursor.Close(streamContext(r))
+
+	f := excelize.NewFile()
+	defer f.Close()
+	dateStyle, err := f.NewStyle(&excelize.Style{NumFmt: 22})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	header := make([]interface{}, len(exportColumns))
+	for i, col := range exportColumns {
+		header[i] = col.header
+	}
+	var sw *excelize.StreamWriter
+	category, row, total := "", 0, 0
+	// Sheet1 is the workbook's default sheet, dropped once the others are
+	// written, so no category may take its name.
+	names := sheetNames{"sheet1": true}
+	truncated := false
+	for cursor.Next(streamContext(r)) {
+		if total >= maxRows {
+			truncated = true
+			break
+		}
+		var c exportRow
+		if err := cursor.Decode(&c); err != nil {
+			serverError(w, err)
+			return
+		}
+
+		if sw == nil || perCategory && c.Category != category {
+			name := "Complaints"
+			if perCategory {
+				name = names.add(c.Category)
+			}
+			if sw, err = startSheet(f, sw, name, header); err != nil {
+				serverError(w, err)
+				return
+			}
+			category, row = c.Category, 1
+		}
+
+		row++
+		total++
+		cell, _ := excelize.CoordinatesToCellName(1, row)
+		values := make([]interface{}, len(exportColumns))
+		for i, col := range exportColumns {
+			values[i] = col.value(c)
+			if t, ok := values[i].(time.Time); ok {
+				values[i] = excelize.Cell{StyleID: dateStyle, Value: localClock(t, loc)}
+			}
+		}
+		if err := sw.SetRow(cell, values); err != nil {
+			serverError(w, err)
+			return
+		}
+	}
+	if sw == nil {
+		if sw, err = startSheet(f, nil, "Complaints", header); err != nil {
+			serverError(w, err)
+			return
+		}
+	}
+	if err := sw.Flush(); err != nil {
+		serverError(w, err)
+		return
+	}
+	if idx, err := f.GetSheetIndex("Sheet1"); err == nil && idx >= 0 && f.SheetCount > 1 {
+		f.DeleteSheet("Sheet1")
+	}
+
+	filename := fmt.Sprintf("complaints-%s.xlsx", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if truncated {
+		w.Header().Set("X-Export-Truncated", "true")
+	}
+	if err := f.Write(w); err != nil {
+		requestLogger(r).Error("export complaints", "error", err)
+	}
+}
+
+// startSheet flushes the previous sheet's stream writer, if any, and opens a
+// new sheet with a header row.
+func startSheet(f *excelize.File, prev *excelize.StreamWriter, name string, header []interface{}) (*excelize.StreamWriter, error) {
+	if prev != nil {
+		if err := prev.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := f.NewSheet(name); err != nil {
+		return nil, err
+	}
+	sw, err := f.NewStreamWriter(name)
+	if err != nil {
+		return nil, err
+	}
+	return sw, sw.SetRow("A1", header)
+}
+
+// sheetName makes a category usable as a worksheet name, which Excel limits
+// to 31 characters without []:*?/\.
+func sheetName(category string) string {
+	if category == "" {
+		return "Uncategorized"
+	}
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, category)
+	return truncateRunes(name, 31)
+}
+
+func truncateRunes(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
+
+// sheetNames are the worksheet names taken in a workbook, lowercased since
+// Excel ignores case when comparing them.
+type sheetNames map[string]bool
+
+// add names a category's sheet, numbering it when another category already
+// maps to the same name.
+func (taken sheetNames) add(category string) string {
+	base := sheetName(category)
+	name := base
+	for n := 2; taken[strings.ToLower(name)]; n++ {
+		suffix := fmt.Sprintf(" (%d)", n)
+		name = truncateRunes(base, 31-len(suffix)) + suffix
+	}
+	taken[strings.ToLower(name)] = true
+	return name
+}
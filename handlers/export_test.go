@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSVText(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                  "",
+		"Late parcel":       "Late parcel",
+		"=HYPERLINK(\"x\")": "'=HYPERLINK(\"x\")",
+		"+1 555":            "'+1 555",
+		"-2+3":              "'-2+3",
+		"@SUM(A1)":          "'@SUM(A1)",
+		"\tindented":        "'\tindented",
+		"\rreturn":          "'\rreturn",
+		"a=b":               "a=b",
+		"'already quoted":   "'already quoted",
+	} {
+		if got := csvText(in); got != want {
+			t.Errorf("csvText(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSheetNamesAreUnique(t *testing.T) {
+	long := "Deliveries that arrived late or damaged"
+	names := sheetNames{"sheet1": true}
+	for _, tc := range []struct{ category, want string }{
+		{"", "Uncategorized"},
+		{"Uncategorized", "Uncategorized (2)"},
+		{"a/b", "a_b"},
+		{"a_b", "a_b (2)"},
+		{"A_B", "A_B (3)"},
+		{long, "Deliveries that arrived late or"},
+		{long + " again", "Deliveries that arrived lat (2)"},
+		{"Sheet1", "Sheet1 (2)"},
+	} {
+		if got := names.add(tc.category); got != tc.want {
+			t.Errorf("sheet for %q = %q, want %q", tc.category, got, tc.want)
+		}
+	}
+}
+
+func TestAdminComplaintFilter(t *testing.T) {
+	r := httptest.NewRequest("GET", "/admin/complaints/export?resolved=true&assignedTo=sam", nil)
+	filter, err := adminComplaintFilter(r)
+	if err != nil {
+		t.Fatalf("adminComplaintFilter: %v", err)
+	}
+	if filter["resolved"] != true {
+		t.Errorf("resolved = %v, want true", filter["resolved"])
+	}
+	if filter["assignedTo"] != "sam" {
+		t.Errorf("assignedTo = %v, want sam", filter["assignedTo"])
+	}
+
+	r = httptest.NewRequest("GET", "/admin/complaints/export?resolved=maybe", nil)
+	if _, err := adminComplaintFilter(r); err == nil {
+		t.Error("resolved=maybe was accepted")
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"complain/models"
+)
+
+type feedbackRequest struct {
+	Score   int    `json:"score"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// complaintFeedbackHandler records the reporter's satisfaction with how a
+// resolved complaint was handled (POST, once per complaint) and shows it to
+// the reporter and staff (GET). It is kept apart from the complaint's own
+// rating, which describes the problem rather than the service.
+func complaintFeedbackHandler(w http.ResponseWriter, r *http.Request) {
+	oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("complaintId"))
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		complaint, err := complaintStore.Complaint(dbContext(r), oid)
+		if err != nil || !canAccess(r, complaint) {
+			http.Error(w, "Complaint not found", http.StatusNotFound)
+			return
+		}
+		var feedback models.Feedback
+		err = documents.Collection("feedback").FindOne(dbContext(r), bson.M{"_id": oid}).Decode(&feedback)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "No feedback for this complaint", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(feedback)
+
+	case http.MethodPost:
+		var req feedbackRequest
+		if err := decodeJSON(r, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Comment = strings.TrimSpace(req.Comment)
+		var errs validationErrors
+		if req.Score < 1 || req.Score > 5 {
+			errs.add("score", "must be between 1 and 5")
+		}
+		if utf8.RuneCountInString(req.Comment) > envInt("COMMENT_MAX_LENGTH", 5000) {
+			errs.add("comment", "is too long")
+		}
+		if len(errs) > 0 {
+			writeValidationErrors(w, errs)
+			return
+		}
+
+		complaint, err := complaintStore.Complaint(dbContext(r), oid)
+		userID, _ := currentUserID(r)
+		if err != nil || complaint.UserID != userID {
+			http.Error(w, "Complaint not found", http.StatusNotFound)
+			return
+		}
+		if !models.IsResolvedStatus(complaint.CurrentStatus()) {
+			http.Error(w, "Feedback can be given once the complaint is resolved", http.StatusConflict)
+			return
+		}
+
+		feedback := models.Feedback{
+			ComplaintID: oid,
+			UserID:      userID,
+			Score:       req.Score,
+			Comment:     req.Comment,
+			AssignedTo:  complaint.AssignedTo,
+			SubmittedAt: time.Now().UTC(),
+		}
+		_, err = documents.Collection("feedback").InsertOne(dbContext(r), feedback)
+		if mongo.IsDuplicateKeyError(err) {
+			http.Error(w, "Feedback was already given for this complaint", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(feedback)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+	"complain/store"
+)
+
+// setFollowUpHandler lets the owner of a complaint schedule an email reminder
+// at an RFC3339 time given in the "at" query parameter.
+func setFollowUpHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("complaintId"))
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+	at, err := time.Parse(time.RFC3339, r.URL.Query().Get("at"))
+	if err != nil {
+		writeFieldError(w, "at", "must be an RFC3339 time")
+		return
+	}
+	if !at.After(time.Now()) {
+		writeFieldError(w, "at", "must be in the future")
+		return
+	}
+
+	userID, _ := currentUserID(r)
+
+	var complaint models.Complaint
+	err = orgCollection("complaints").FindOneAndUpdate(dbContext(r),
+		bson.M{"_id": oid, "userId": userID},
+		bson.M{"$set": bson.M{"followUpAt": at.UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&complaint)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(complaint)
+}
+
+// sendFollowUps emails reminders that have come due. Each reminder is claimed
+// by unsetting it before sending, so concurrent instances never send twice.
+func sendFollowUps(ctx context.Context) error {
+	for {
+		var complaint models.Complaint
+		err := orgCollection("complaints").FindOneAndUpdate(ctx,
+			bson.M{"followUpAt": bson.M{"$lte": time.Now()}, "deletedAt": store.NotDeleted()},
+			bson.M{"$unset": bson.M{"followUpAt": ""}},
+		).Decode(&complaint)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		sendFollowUp(complaint)
+	}
+}
+
+func sendFollowUp(complaint models.Complaint) {
+	notifyOwner(complaint, templateReminder, "")
+}
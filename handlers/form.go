@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"complain/models"
+)
+
+var errEmptyBody = errors.New("empty request body")
+
+// decodeJSON strictly decodes a JSON request body: unknown fields are rejected
+// so typos surface as errors instead of being silently dropped.
+func decodeJSON(r *http.Request, v interface{}) error {
+	if r.Body == nil {
+		return errEmptyBody
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errEmptyBody
+		}
+		return err
+	}
+	return nil
+}
+
+func isFormRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/x-www-form-urlencoded"
+}
+
+func decodeRegistration(r *http.Request, reg *registration) error {
+	if !isFormRequest(r) {
+		return decodeJSON(r, reg)
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	reg.Name = r.PostForm.Get("name")
+	reg.Email = r.PostForm.Get("email")
+	reg.Locale = r.PostForm.Get("locale")
+	reg.OrgID = r.PostForm.Get("orgId")
+	reg.AcknowledgeEmails, _ = strconv.ParseBool(r.PostForm.Get("acknowledgeEmails"))
+	return nil
+}
+
+func decodeComplaint(r *http.Request, complaint *models.Complaint) error {
+	if !isFormRequest(r) {
+		return decodeJSON(r, complaint)
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	complaint.Title = r.PostForm.Get("title")
+	complaint.Summary = r.PostForm.Get("summary")
+	complaint.Priority = r.PostForm.Get("priority")
+	complaint.Category = r.PostForm.Get("category")
+	if v := r.PostForm.Get("rating"); v != "" {
+		rating, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid rating %q", v)
+		}
+		complaint.Rating = rating
+	}
+	if v := r.PostForm.Get("userId"); v != "" {
+		oid, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			return fmt.Errorf("invalid userId %q", v)
+		}
+		complaint.UserID = oid
+	}
+	return nil
+}
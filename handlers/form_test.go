@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"complain/models"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr error
+		fail    bool
+		want    models.User
+	}{
+		{name: "empty body", body: "", wantErr: errEmptyBody, fail: true},
+		{name: "garbage", body: "\x00\xff not json", fail: true},
+		{name: "truncated", body: `{"name":"Ann"`, fail: true},
+		{name: "unknown field", body: `{"name":"Ann","nmae":"typo"}`, fail: true},
+		{name: "wrong type", body: `{"name":42}`, fail: true},
+		{name: "valid", body: `{"name":"Ann","email":"ann@example.com"}`, want: models.User{Name: "Ann", Email: "ann@example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			var got models.User
+			err := decodeJSON(r, &got)
+			if tt.fail != (err != nil) {
+				t.Fatalf("decodeJSON(%q) error = %v, want failure %v", tt.body, err, tt.fail)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("decodeJSON(%q) error = %v, want %v", tt.body, err, tt.wantErr)
+			}
+			if !tt.fail && (got.Name != tt.want.Name || got.Email != tt.want.Email) {
+				t.Fatalf("decodeJSON(%q) = %+v, want %+v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeJSONNilBody(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Body = nil
+	var v models.User
+	if err := decodeJSON(r, &v); !errors.Is(err, errEmptyBody) {
+		t.Fatalf("decodeJSON with nil body error = %v, want %v", err, errEmptyBody)
+	}
+}
+
+func TestDecodeRegistration(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantErr     error
+		fail        bool
+		want        registration
+	}{
+		{name: "empty JSON body", contentType: "application/json", body: "", wantErr: errEmptyBody, fail: true},
+		{name: "garbage JSON", contentType: "application/json", body: "}{", fail: true},
+		{name: "unknown JSON field", contentType: "application/json", body: `{"email":"ann@example.com","admin":true}`, fail: true},
+		{name: "identities", contentType: "application/json", body: `{"email":"ann@example.com","identities":[{"provider":"google","subject":"1"}]}`, fail: true},
+		{name: "valid JSON", contentType: "application/json", body: `{"name":"Ann","email":"ann@example.com","acknowledgeEmails":true}`,
+			want: registration{Name: "Ann", Email: "ann@example.com", AcknowledgeEmails: true}},
+		{name: "empty form", contentType: "application/x-www-form-urlencoded", body: ""},
+		{name: "garbage form", contentType: "application/x-www-form-urlencoded", body: "name=%zz", fail: true},
+		{name: "form ignores unknown fields", contentType: "application/x-www-form-urlencoded", body: "name=Ann&admin=true&acknowledgeEmails=nope",
+			want: registration{Name: "Ann"}},
+		{name: "valid form", contentType: "application/x-www-form-urlencoded; charset=utf-8", body: "name=Ann&email=ann%40example.com&locale=fr&orgId=acme&acknowledgeEmails=true",
+			want: registration{Name: "Ann", Email: "ann@example.com", Locale: "fr", OrgID: "acme", AcknowledgeEmails: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			var got registration
+			err := decodeRegistration(r, &got)
+			if tt.fail != (err != nil) {
+				t.Fatalf("decodeRegistration(%q) error = %v, want failure %v", tt.body, err, tt.fail)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("decodeRegistration(%q) error = %v, want %v", tt.body, err, tt.wantErr)
+			}
+			if tt.fail {
+				return
+			}
+			if got.Name != tt.want.Name || got.Email != tt.want.Email || got.Locale != tt.want.Locale ||
+				got.OrgID != tt.want.OrgID || got.AcknowledgeEmails != tt.want.AcknowledgeEmails {
+				t.Fatalf("decodeRegistration(%q) = %+v, want %+v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeComplaint(t *testing.T) {
+	userID := primitive.NewObjectID()
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		fail        bool
+		want        models.Complaint
+	}{
+		{name: "valid JSON", contentType: "application/json", body: `{"title":"Broken","summary":"It broke","rating":4,"userId":"` + userID.Hex() + `"}`,
+			want: models.Complaint{Title: "Broken", Summary: "It broke", Rating: 4, UserID: userID}},
+		{name: "valid form", contentType: "application/x-www-form-urlencoded", body: "title=Broken&summary=It+broke&rating=4&userId=" + userID.Hex(),
+			want: models.Complaint{Title: "Broken", Summary: "It broke", Rating: 4, UserID: userID}},
+		{name: "form without rating", contentType: "application/x-www-form-urlencoded", body: "title=Broken",
+			want: models.Complaint{Title: "Broken"}},
+		{name: "non-numeric rating", contentType: "application/x-www-form-urlencoded", body: "title=Broken&rating=high", fail: true},
+		{name: "malformed userId", contentType: "application/x-www-form-urlencoded", body: "title=Broken&userId=nope", fail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			var got models.Complaint
+			err := decodeComplaint(r, &got)
+			if tt.fail != (err != nil) {
+				t.Fatalf("decodeComplaint(%q) error = %v, want failure %v", tt.body, err, tt.fail)
+			}
+			if !tt.fail && (got.Title != tt.want.Title || got.Summary != tt.want.Summary ||
+				got.Rating != tt.want.Rating || got.UserID != tt.want.UserID) {
+				t.Fatalf("decodeComplaint(%q) = %+v, want %+v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
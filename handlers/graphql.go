@@ -0,0 +1,857 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// This file holds a small GraphQL executor: queries with arguments,
+// variables, aliases, fragments and @skip/@include. Mutations, subscriptions
+// and introspection are not offered; the REST API covers writes. The schema
+// lives in graphql_schema.go.
+
+type gqlTokenKind int
+
+const (
+	gqlEOF gqlTokenKind = iota
+	gqlPunct
+	gqlName
+	gqlInt
+	gqlFloat
+	gqlString
+)
+
+type gqlToken struct {
+	kind  gqlTokenKind
+	value string
+	pos   int
+}
+
+func gqlLex(src string) ([]gqlToken, error) {
+	var tokens []gqlToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "..."):
+			tokens = append(tokens, gqlToken{gqlPunct, "...", i})
+			i += 3
+		case strings.IndexByte("!$()&:=@[]{}|", c) >= 0:
+			tokens = append(tokens, gqlToken{gqlPunct, string(c), i})
+			i++
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			tokens = append(tokens, gqlToken{gqlName, src[i:j], i})
+			i = j
+		case c == '-' || c >= '0' && c <= '9':
+			j, kind := i+1, gqlInt
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || strings.IndexByte(".eE+-", src[j]) >= 0) {
+				if strings.IndexByte(".eE", src[j]) >= 0 {
+					kind = gqlFloat
+				}
+				j++
+			}
+			tokens = append(tokens, gqlToken{kind, src[i:j], i})
+			i = j
+		case c == '"':
+			j := i + 1
+			for j < len(src) && src[j] != '"' && src[j] != '\n' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) || src[j] != '"' {
+				return nil, fmt.Errorf("Unterminated string at %d", i)
+			}
+			var s string
+			if err := json.Unmarshal([]byte(src[i:j+1]), &s); err != nil {
+				return nil, fmt.Errorf("Invalid string at %d", i)
+			}
+			tokens = append(tokens, gqlToken{gqlString, s, i})
+			i = j + 1
+		default:
+			return nil, fmt.Errorf("Unexpected character %q at %d", c, i)
+		}
+	}
+	return append(tokens, gqlToken{gqlEOF, "", len(src)}), nil
+}
+
+// gqlVariable and gqlEnum mark argument values that are not literals.
+type (
+	gqlVariable string
+	gqlEnum     string
+)
+
+type gqlArgument struct {
+	name  string
+	value interface{}
+}
+
+type gqlDirective struct {
+	name string
+	args []gqlArgument
+}
+
+// gqlSelection is a field, a fragment spread (spread set) or an inline
+// fragment (inline set).
+type gqlSelection struct {
+	alias, name string
+	args        []gqlArgument
+	directives  []gqlDirective
+	selections  []gqlSelection
+
+	spread string
+	inline bool
+	on     string
+}
+
+func (s gqlSelection) responseKey() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+type gqlVariableDef struct {
+	name       string
+	nonNull    bool
+	defaultVal interface{}
+	hasDefault bool
+}
+
+type gqlOperation struct {
+	kind, name string
+	variables  []gqlVariableDef
+	selections []gqlSelection
+}
+
+type gqlFragment struct {
+	on         string
+	selections []gqlSelection
+}
+
+type gqlDocument struct {
+	operations []gqlOperation
+	fragments  map[string]gqlFragment
+}
+
+type gqlParser struct {
+	tokens []gqlToken
+	pos    int
+}
+
+func (p *gqlParser) peek() gqlToken { return p.tokens[p.pos] }
+
+func (p *gqlParser) next() gqlToken {
+	t := p.tokens[p.pos]
+	if t.kind != gqlEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *gqlParser) is(punct string) bool {
+	t := p.peek()
+	return t.kind == gqlPunct && t.value == punct
+}
+
+func (p *gqlParser) expect(punct string) error {
+	if t := p.next(); t.kind != gqlPunct || t.value != punct {
+		return fmt.Errorf("Expected %q at %d", punct, t.pos)
+	}
+	return nil
+}
+
+func (p *gqlParser) name() (string, error) {
+	t := p.next()
+	if t.kind != gqlName {
+		return "", fmt.Errorf("Expected a name at %d", t.pos)
+	}
+	return t.value, nil
+}
+
+func parseGraphQL(src string) (gqlDocument, error) {
+	tokens, err := gqlLex(src)
+	if err != nil {
+		return gqlDocument{}, err
+	}
+	p := &gqlParser{tokens: tokens}
+	doc := gqlDocument{fragments: map[string]gqlFragment{}}
+	for p.peek().kind != gqlEOF {
+		if p.is("{") {
+			sel, err := p.selectionSet()
+			if err != nil {
+				return doc, err
+			}
+			doc.operations = append(doc.operations, gqlOperation{kind: "query", selections: sel})
+			continue
+		}
+		keyword, err := p.name()
+		if err != nil {
+			return doc, err
+		}
+		switch keyword {
+		case "query", "mutation", "subscription":
+			op := gqlOperation{kind: keyword}
+			if p.peek().kind == gqlName {
+				op.name = p.next().value
+			}
+			if p.is("(") {
+				if op.variables, err = p.variableDefs(); err != nil {
+					return doc, err
+				}
+			}
+			if _, err := p.directives(); err != nil {
+				return doc, err
+			}
+			if op.selections, err = p.selectionSet(); err != nil {
+				return doc, err
+			}
+			doc.operations = append(doc.operations, op)
+		case "fragment":
+			name, err := p.name()
+			if err != nil {
+				return doc, err
+			}
+			if on, err := p.name(); err != nil || on != "on" {
+				return doc, fmt.Errorf("Expected \"on\" in fragment %s", name)
+			}
+			var frag gqlFragment
+			if frag.on, err = p.name(); err != nil {
+				return doc, err
+			}
+			if _, err := p.directives(); err != nil {
+				return doc, err
+			}
+			if frag.selections, err = p.selectionSet(); err != nil {
+				return doc, err
+			}
+			doc.fragments[name] = frag
+		default:
+			return doc, fmt.Errorf("Unexpected %q", keyword)
+		}
+	}
+	if len(doc.operations) == 0 {
+		return doc, errors.New("The document has no operation")
+	}
+	return doc, nil
+}
+
+func (p *gqlParser) variableDefs() ([]gqlVariableDef, error) {
+	p.next() // (
+	var defs []gqlVariableDef
+	for !p.is(")") {
+		if err := p.expect("$"); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		def := gqlVariableDef{name: name}
+		if def.nonNull, err = p.typeRef(); err != nil {
+			return nil, err
+		}
+		if p.is("=") {
+			p.next()
+			if def.defaultVal, err = p.value(true); err != nil {
+				return nil, err
+			}
+			def.hasDefault = true
+		}
+		defs = append(defs, def)
+	}
+	p.next() // )
+	return defs, nil
+}
+
+// typeRef skips a variable's type, reporting whether it is non-null. Values
+// are checked by the resolvers that read them.
+func (p *gqlParser) typeRef() (nonNull bool, err error) {
+	if p.is("[") {
+		p.next()
+		if _, err := p.typeRef(); err != nil {
+			return false, err
+		}
+		if err := p.expect("]"); err != nil {
+			return false, err
+		}
+	} else if _, err := p.name(); err != nil {
+		return false, err
+	}
+	if p.is("!") {
+		p.next()
+		return true, nil
+	}
+	return false, nil
+}
+
+func (p *gqlParser) selectionSet() ([]gqlSelection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var set []gqlSelection
+	for !p.is("}") {
+		if p.peek().kind == gqlEOF {
+			return nil, errors.New("Unterminated selection set")
+		}
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, sel)
+	}
+	p.next() // }
+	if len(set) == 0 {
+		return nil, errors.New("Empty selection set")
+	}
+	return set, nil
+}
+
+func (p *gqlParser) selection() (gqlSelection, error) {
+	var sel gqlSelection
+	var err error
+	if p.is("...") {
+		p.next()
+		if t := p.peek(); t.kind == gqlName && t.value != "on" {
+			sel.spread = p.next().value
+			sel.directives, err = p.directives()
+			return sel, err
+		}
+		sel.inline = true
+		if t := p.peek(); t.kind == gqlName && t.value == "on" {
+			p.next()
+			if sel.on, err = p.name(); err != nil {
+				return sel, err
+			}
+		}
+		if sel.directives, err = p.directives(); err != nil {
+			return sel, err
+		}
+		sel.selections, err = p.selectionSet()
+		return sel, err
+	}
+
+	if sel.name, err = p.name(); err != nil {
+		return sel, err
+	}
+	if p.is(":") {
+		p.next()
+		sel.alias = sel.name
+		if sel.name, err = p.name(); err != nil {
+			return sel, err
+		}
+	}
+	if p.is("(") {
+		if sel.args, err = p.arguments(false); err != nil {
+			return sel, err
+		}
+	}
+	if sel.directives, err = p.directives(); err != nil {
+		return sel, err
+	}
+	if p.is("{") {
+		sel.selections, err = p.selectionSet()
+	}
+	return sel, err
+}
+
+func (p *gqlParser) arguments(constant bool) ([]gqlArgument, error) {
+	p.next() // (
+	var args []gqlArgument
+	for !p.is(")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		v, err := p.value(constant)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, gqlArgument{name, v})
+	}
+	p.next() // )
+	return args, nil
+}
+
+func (p *gqlParser) directives() ([]gqlDirective, error) {
+	var dirs []gqlDirective
+	for p.is("@") {
+		p.next()
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		d := gqlDirective{name: name}
+		if p.is("(") {
+			if d.args, err = p.arguments(false); err != nil {
+				return nil, err
+			}
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs, nil
+}
+
+func (p *gqlParser) value(constant bool) (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case gqlInt:
+		n, err := strconv.Atoi(t.value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid integer %s", t.value)
+		}
+		return n, nil
+	case gqlFloat:
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid number %s", t.value)
+		}
+		return f, nil
+	case gqlString:
+		return t.value, nil
+	case gqlName:
+		switch t.value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return gqlEnum(t.value), nil
+	case gqlPunct:
+		switch t.value {
+		case "$":
+			if constant {
+				return nil, fmt.Errorf("Variables are not allowed at %d", t.pos)
+			}
+			name, err := p.name()
+			return gqlVariable(name), err
+		case "[":
+			list := []interface{}{}
+			for !p.is("]") {
+				if p.peek().kind == gqlEOF {
+					return nil, errors.New("Unterminated list")
+				}
+				v, err := p.value(constant)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			p.next()
+			return list, nil
+		case "{":
+			obj := map[string]interface{}{}
+			for !p.is("}") {
+				name, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				if obj[name], err = p.value(constant); err != nil {
+					return nil, err
+				}
+			}
+			p.next()
+			return obj, nil
+		}
+	}
+	return nil, fmt.Errorf("Unexpected %q at %d", t.value, t.pos)
+}
+
+// gqlObject is a result object; it keeps its fields in the order the query
+// asked for them.
+type gqlObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newGQLObject() *gqlObject {
+	return &gqlObject{values: map[string]interface{}{}}
+}
+
+func (o *gqlObject) set(key string, v interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+func (o *gqlObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		buf.Write(key)
+		buf.WriteByte(':')
+		v, err := json.Marshal(o.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+type gqlError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// gqlFieldError fails the field of one parent in a batch, leaving it null.
+type gqlFieldError string
+
+// gqlField resolves one field for a whole batch of parents at once, which is
+// what lets nested lists share a single lookup. Resolve returns one value
+// per parent: a value of Type's Go type (or a slice of them) for object
+// fields, a JSON-ready scalar otherwise, or a gqlFieldError.
+type gqlField struct {
+	Type    string // object type of the result; empty for scalars
+	Resolve func(e *gqlExecution, parents []interface{}, args map[string]interface{}) ([]interface{}, error)
+}
+
+type gqlExecution struct {
+	r         *http.Request
+	doc       gqlDocument
+	variables map[string]interface{}
+	errors    []gqlError
+	maxDepth  int
+}
+
+// gqlNode is one parent object being filled in, with its response path.
+type gqlNode struct {
+	value interface{}
+	out   *gqlObject
+	path  []interface{}
+}
+
+// fail records a field error in the caller's language. Argument errors are
+// reported like the REST validation errors, field by field.
+func (e *gqlExecution) fail(path []interface{}, err error) {
+	locale := requestLocale(e.r)
+	msg := translate(locale, err.Error())
+	if errs, ok := err.(validationErrors); ok {
+		msgs := make([]string, len(errs))
+		for i, fe := range errs {
+			msgs[i] = fe.Field + ": " + translate(locale, fe.Message)
+		}
+		msg = strings.Join(msgs, "; ")
+	}
+	e.errors = append(e.errors, gqlError{Message: msg, Path: path})
+}
+
+func appendPath(path []interface{}, elem interface{}) []interface{} {
+	return append(append(make([]interface{}, 0, len(path)+1), path...), elem)
+}
+
+// collectFields flattens fragments and drops skipped selections, merging
+// selections that share a response key.
+func (e *gqlExecution) collectFields(typeName string, set []gqlSelection, into *[]gqlSelection, index map[string]int, seen map[string]bool) error {
+	for _, sel := range set {
+		include, err := e.included(sel.directives)
+		if err != nil {
+			return err
+		}
+		if !include {
+			continue
+		}
+		switch {
+		case sel.spread != "":
+			frag, ok := e.doc.fragments[sel.spread]
+			if !ok {
+				return fmt.Errorf("Unknown fragment %s", sel.spread)
+			}
+			if seen[sel.spread] {
+				return fmt.Errorf("Fragment %s spreads itself", sel.spread)
+			}
+			if frag.on == typeName {
+				seen[sel.spread] = true
+				err := e.collectFields(typeName, frag.selections, into, index, seen)
+				delete(seen, sel.spread)
+				if err != nil {
+					return err
+				}
+			}
+		case sel.inline:
+			if sel.on == "" || sel.on == typeName {
+				if err := e.collectFields(typeName, sel.selections, into, index, seen); err != nil {
+					return err
+				}
+			}
+		default:
+			key := sel.responseKey()
+			if i, ok := index[key]; ok {
+				(*into)[i].selections = append((*into)[i].selections, sel.selections...)
+				continue
+			}
+			index[key] = len(*into)
+			*into = append(*into, sel)
+		}
+	}
+	return nil
+}
+
+func (e *gqlExecution) included(dirs []gqlDirective) (bool, error) {
+	for _, d := range dirs {
+		if d.name != "skip" && d.name != "include" {
+			return false, fmt.Errorf("Unknown directive @%s", d.name)
+		}
+		args, err := e.arguments(d.args)
+		if err != nil {
+			return false, err
+		}
+		cond, ok := args["if"].(bool)
+		if !ok {
+			return false, fmt.Errorf("@%s needs a boolean if argument", d.name)
+		}
+		if d.name == "skip" && cond || d.name == "include" && !cond {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (e *gqlExecution) arguments(args []gqlArgument) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(args))
+	for _, a := range args {
+		v, err := e.resolveValue(a.value)
+		if err != nil {
+			return nil, err
+		}
+		out[a.name] = v
+	}
+	return out, nil
+}
+
+func (e *gqlExecution) resolveValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case gqlVariable:
+		val, ok := e.variables[string(v)]
+		if !ok {
+			return nil, fmt.Errorf("Variable $%s is not defined", v)
+		}
+		return val, nil
+	case gqlEnum:
+		return string(v), nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			var err error
+			if out[i], err = e.resolveValue(item); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			var err error
+			if out[k], err = e.resolveValue(item); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// execute fills in the selections of typeName on every node, one resolver
+// call per field for the whole level.
+func (e *gqlExecution) execute(nodes []gqlNode, typeName string, set []gqlSelection, depth int) error {
+	if depth > e.maxDepth {
+		return fmt.Errorf("The query is nested deeper than %d levels", e.maxDepth)
+	}
+	var fields []gqlSelection
+	if err := e.collectFields(typeName, set, &fields, map[string]int{}, map[string]bool{}); err != nil {
+		return err
+	}
+	for _, f := range fields {
+		key := f.responseKey()
+		if f.name == "__typename" {
+			for _, n := range nodes {
+				n.out.set(key, typeName)
+			}
+			continue
+		}
+		def, ok := gqlSchema[typeName][f.name]
+		if !ok {
+			return fmt.Errorf("Cannot query field %s on type %s", f.name, typeName)
+		}
+		if def.Type == "" && len(f.selections) > 0 {
+			return fmt.Errorf("Field %s of type %s has no subfields", f.name, typeName)
+		}
+		if def.Type != "" && len(f.selections) == 0 {
+			return fmt.Errorf("Field %s of type %s needs a selection of subfields", f.name, typeName)
+		}
+		args, err := e.arguments(f.args)
+		if err != nil {
+			return err
+		}
+
+		parents := make([]interface{}, len(nodes))
+		for i, n := range nodes {
+			parents[i] = n.value
+		}
+		results, err := def.Resolve(e, parents, args)
+		if err != nil {
+			for _, n := range nodes {
+				n.out.set(key, nil)
+				e.fail(appendPath(n.path, key), err)
+			}
+			continue
+		}
+
+		var children []gqlNode
+		for i, n := range nodes {
+			path := appendPath(n.path, key)
+			switch v := results[i].(type) {
+			case gqlFieldError:
+				n.out.set(key, nil)
+				e.fail(path, errors.New(string(v)))
+			case nil:
+				n.out.set(key, nil)
+			case []interface{}:
+				if def.Type == "" {
+					n.out.set(key, v)
+					continue
+				}
+				list := make([]interface{}, len(v))
+				for j, item := range v {
+					obj := newGQLObject()
+					list[j] = obj
+					children = append(children, gqlNode{value: item, out: obj, path: appendPath(path, j)})
+				}
+				n.out.set(key, list)
+			default:
+				if def.Type == "" {
+					n.out.set(key, v)
+					continue
+				}
+				obj := newGQLObject()
+				n.out.set(key, obj)
+				children = append(children, gqlNode{value: v, out: obj, path: path})
+			}
+		}
+		if len(children) > 0 {
+			if err := e.execute(children, def.Type, f.selections, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty"`
+}
+
+type graphqlResponse struct {
+	Data   *gqlObject `json:"data,omitempty"`
+	Errors []gqlError `json:"errors,omitempty"`
+}
+
+func writeGraphQLError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(graphqlResponse{Errors: []gqlError{{Message: translate(requestLocale(r), err.Error())}}})
+}
+
+// graphqlHandler runs a GraphQL query sent as JSON (POST) or as the query,
+// variables and operationName parameters (GET). Each field applies the same
+// role checks as the REST route it mirrors, so one query may come back with
+// some fields null and an error for each. Queries may nest GRAPHQL_MAX_DEPTH
+// levels.
+func graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var req graphqlRequest
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				writeGraphQLError(w, r, http.StatusBadRequest, errors.New("Variables must be a JSON object"))
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := decodeJSON(r, &req); err != nil {
+			writeGraphQLError(w, r, http.StatusBadRequest, err)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	doc, err := parseGraphQL(req.Query)
+	if err != nil {
+		writeGraphQLError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	var op *gqlOperation
+	for i := range doc.operations {
+		if req.OperationName == "" && len(doc.operations) == 1 || doc.operations[i].name == req.OperationName {
+			op = &doc.operations[i]
+		}
+	}
+	if op == nil {
+		writeGraphQLError(w, r, http.StatusBadRequest, errors.New("operationName does not name an operation of the document"))
+		return
+	}
+	if op.kind != "query" {
+		writeGraphQLError(w, r, http.StatusBadRequest, fmt.Errorf("Only query operations are supported, not %s", op.kind))
+		return
+	}
+
+	e := &gqlExecution{r: r, doc: doc, variables: map[string]interface{}{}, maxDepth: envInt("GRAPHQL_MAX_DEPTH", 10)}
+	for _, def := range op.variables {
+		v, ok := req.Variables[def.name]
+		if !ok && def.hasDefault {
+			v, ok = def.defaultVal, true
+		}
+		if (!ok || v == nil) && def.nonNull {
+			writeGraphQLError(w, r, http.StatusBadRequest, fmt.Errorf("Variable $%s is required", def.name))
+			return
+		}
+		e.variables[def.name] = v
+	}
+
+	data := newGQLObject()
+	if err := e.execute([]gqlNode{{out: data}}, "Query", op.selections, 1); err != nil {
+		writeGraphQLError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(graphqlResponse{Data: data, Errors: e.errors})
+}
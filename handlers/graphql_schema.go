@@ -0,0 +1,528 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"complain/models"
+	"complain/store"
+)
+
+// complaintPageResult is what the complaints and myComplaints fields return.
+type complaintPageResult struct {
+	total      int64
+	nextCursor string
+	complaints []models.Complaint
+}
+
+// gqlSchema maps each type to its fields. Query fields check the caller like
+// the REST routes they mirror; nested fields check what the parent alone does
+// not settle, such as who may see a complaint's reporter.
+var gqlSchema map[string]map[string]gqlField
+
+func init() {
+	gqlSchema = map[string]map[string]gqlField{
+		"Query": {
+			"me":           {Type: "User", Resolve: resolveMe},
+			"user":         {Type: "User", Resolve: resolveUser},
+			"complaint":    {Type: "Complaint", Resolve: resolveComplaint},
+			"complaints":   {Type: "ComplaintPage", Resolve: resolveComplaints},
+			"myComplaints": {Type: "ComplaintPage", Resolve: resolveMyComplaints},
+			"stats":        {Type: "Stats", Resolve: resolveStats},
+		},
+		"ComplaintPage": {
+			"total":      scalarField(func(v interface{}) interface{} { return v.(complaintPageResult).total }),
+			"nextCursor": scalarField(func(v interface{}) interface{} { return optional(v.(complaintPageResult).nextCursor) }),
+			"complaints": {Type: "Complaint", Resolve: func(e *gqlExecution, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+				out := make([]interface{}, len(parents))
+				for i, p := range parents {
+					out[i] = complaintList(p.(complaintPageResult).complaints)
+				}
+				return out, nil
+			}},
+		},
+		"Complaint": {
+			"id":                complaintField(func(c models.Complaint) interface{} { return c.ID }),
+			"refNumber":         complaintField(func(c models.Complaint) interface{} { return c.RefNumber }),
+			"title":             complaintField(func(c models.Complaint) interface{} { return c.Title }),
+			"summary":           complaintField(func(c models.Complaint) interface{} { return c.Summary }),
+			"summaryTruncated":  complaintField(func(c models.Complaint) interface{} { return c.SummaryTruncated }),
+			"rating":            complaintField(func(c models.Complaint) interface{} { return c.Rating }),
+			"resolved":          complaintField(func(c models.Complaint) interface{} { return c.Resolved }),
+			"status":            complaintField(func(c models.Complaint) interface{} { return c.Status }),
+			"category":          complaintField(func(c models.Complaint) interface{} { return optional(c.Category) }),
+			"priority":          complaintField(func(c models.Complaint) interface{} { return optional(c.Priority) }),
+			"tags":              complaintField(func(c models.Complaint) interface{} { return stringList(c.Tags) }),
+			"assignedTo":        complaintField(func(c models.Complaint) interface{} { return optional(c.AssignedTo) }),
+			"anonymous":         complaintField(func(c models.Complaint) interface{} { return c.Anonymous }),
+			"orgId":             complaintField(func(c models.Complaint) interface{} { return optional(c.OrgID) }),
+			"version":           complaintField(func(c models.Complaint) interface{} { return c.Version }),
+			"commentCount":      complaintField(func(c models.Complaint) interface{} { return c.CommentCount }),
+			"slaStatus":         complaintField(func(c models.Complaint) interface{} { return optional(c.SLAStatus) }),
+			"ageSeconds":        complaintField(func(c models.Complaint) interface{} { return c.AgeSeconds }),
+			"reporterAvatarUrl": complaintField(func(c models.Complaint) interface{} { return optional(c.ReporterAvatarURL) }),
+			"resolvedBy":        complaintField(func(c models.Complaint) interface{} { return optional(c.ResolvedBy) }),
+			"resolvedAt":        complaintField(func(c models.Complaint) interface{} { return optionalTime(c.ResolvedAt) }),
+			"dueAt":             complaintField(func(c models.Complaint) interface{} { return optionalTime(&c.DueAt) }),
+			"createdAt":         complaintField(func(c models.Complaint) interface{} { return c.CreatedAt }),
+			"updatedAt":         complaintField(func(c models.Complaint) interface{} { return c.UpdatedAt }),
+			"reporter":          {Type: "User", Resolve: resolveReporters},
+			"comments":          {Type: "Comment", Resolve: resolveComments},
+		},
+		"User": {
+			"id":     userField(func(u models.User) interface{} { return u.ID }),
+			"name":   userField(func(u models.User) interface{} { return u.Name }),
+			"email":  userField(func(u models.User) interface{} { return u.Email }),
+			"role":   userField(func(u models.User) interface{} { return optional(u.Role) }),
+			"orgId":  userField(func(u models.User) interface{} { return optional(u.OrgID) }),
+			"locale": userField(func(u models.User) interface{} { return optional(u.Locale) }),
+			"avatarUrl": userField(func(u models.User) interface{} {
+				if u.AvatarID == nil {
+					return nil
+				}
+				return avatarURL(u.ID)
+			}),
+			"verified":   userField(func(u models.User) interface{} { return u.Verified }),
+			"createdAt":  userField(func(u models.User) interface{} { return u.CreatedAt }),
+			"complaints": {Type: "Complaint", Resolve: resolveUserComplaints},
+		},
+		"Comment": {
+			"id":              commentField(func(c models.Comment) interface{} { return c.ID }),
+			"complaintId":     commentField(func(c models.Comment) interface{} { return c.ComplaintID }),
+			"parentId":        commentField(func(c models.Comment) interface{} { return c.ParentID }),
+			"author":          commentField(func(c models.Comment) interface{} { return c.Author }),
+			"authorRole":      commentField(func(c models.Comment) interface{} { return optional(c.AuthorRole) }),
+			"authorAvatarUrl": commentField(func(c models.Comment) interface{} { return optional(c.AuthorAvatarURL) }),
+			"body":            commentField(func(c models.Comment) interface{} { return c.Body }),
+			"visibility":      commentField(func(c models.Comment) interface{} { return c.Visibility }),
+			"replyCount":      commentField(func(c models.Comment) interface{} { return c.ReplyCount }),
+			"createdAt":       commentField(func(c models.Comment) interface{} { return c.CreatedAt }),
+		},
+		"Stats": {
+			"from":                  statsField(func(s adminStats) interface{} { return optionalTime(s.From) }),
+			"to":                    statsField(func(s adminStats) interface{} { return optionalTime(s.To) }),
+			"timezone":              statsField(func(s adminStats) interface{} { return s.Timezone }),
+			"total":                 statsField(func(s adminStats) interface{} { return s.Total }),
+			"averageRating":         statsField(func(s adminStats) interface{} { return s.AverageRating }),
+			"averageSatisfaction":   statsField(func(s adminStats) interface{} { return s.AverageSatisfaction }),
+			"satisfactionResponses": statsField(func(s adminStats) interface{} { return s.SatisfactionResponses }),
+			"avgResolutionSeconds":  statsField(func(s adminStats) interface{} { return s.AvgResolutionSeconds }),
+			"byStatus": {Type: "StatusCount", Resolve: statsList(func(s adminStats) []interface{} {
+				statuses := make([]string, 0, len(s.ByStatus))
+				for status := range s.ByStatus {
+					statuses = append(statuses, status)
+				}
+				sort.Strings(statuses)
+				out := make([]interface{}, len(statuses))
+				for i, status := range statuses {
+					out[i] = map[string]interface{}{"status": status, "count": s.ByStatus[status]}
+				}
+				return out
+			})},
+			"perDay": {Type: "DayCount", Resolve: statsList(func(s adminStats) []interface{} {
+				out := make([]interface{}, len(s.PerDay))
+				for i, d := range s.PerDay {
+					out[i] = map[string]interface{}{"date": d.Date, "count": d.Count}
+				}
+				return out
+			})},
+			"topCategories": {Type: "CategoryCount", Resolve: statsList(func(s adminStats) []interface{} {
+				out := make([]interface{}, len(s.TopCategories))
+				for i, c := range s.TopCategories {
+					out[i] = map[string]interface{}{"category": c.Category, "count": c.Count}
+				}
+				return out
+			})},
+		},
+		"StatusCount":   {"status": mapField("status"), "count": mapField("count")},
+		"DayCount":      {"date": mapField("date"), "count": mapField("count")},
+		"CategoryCount": {"category": mapField("category"), "count": mapField("count")},
+	}
+}
+
+func scalarField(get func(v interface{}) interface{}) gqlField {
+	return gqlField{Resolve: func(e *gqlExecution, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+		out := make([]interface{}, len(parents))
+		for i, p := range parents {
+			out[i] = get(p)
+		}
+		return out, nil
+	}}
+}
+
+func complaintField(get func(models.Complaint) interface{}) gqlField {
+	return scalarField(func(v interface{}) interface{} { return get(v.(models.Complaint)) })
+}
+
+func userField(get func(models.User) interface{}) gqlField {
+	return scalarField(func(v interface{}) interface{} { return get(v.(models.User)) })
+}
+
+func commentField(get func(models.Comment) interface{}) gqlField {
+	return scalarField(func(v interface{}) interface{} { return get(v.(models.Comment)) })
+}
+
+func statsField(get func(adminStats) interface{}) gqlField {
+	return scalarField(func(v interface{}) interface{} { return get(v.(adminStats)) })
+}
+
+func mapField(key string) gqlField {
+	return scalarField(func(v interface{}) interface{} { return v.(map[string]interface{})[key] })
+}
+
+func statsList(list func(adminStats) []interface{}) func(*gqlExecution, []interface{}, map[string]interface{}) ([]interface{}, error) {
+	return func(e *gqlExecution, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+		out := make([]interface{}, len(parents))
+		for i, p := range parents {
+			out[i] = list(p.(adminStats))
+		}
+		return out, nil
+	}
+}
+
+// optional turns empty strings into null.
+func optional(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func stringList(values []string) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
+
+func complaintList(complaints []models.Complaint) []interface{} {
+	out := make([]interface{}, len(complaints))
+	for i, c := range complaints {
+		out[i] = c
+	}
+	return out
+}
+
+// gqlArgsRequest is r with the query string replaced by args, so fields can
+// reuse the REST parameter parsing and its error messages.
+func gqlArgsRequest(r *http.Request, args map[string]interface{}) *http.Request {
+	q := url.Values{}
+	for name, v := range args {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			q.Set(name, v)
+		case bool:
+			q.Set(name, strconv.FormatBool(v))
+		case int:
+			q.Set(name, strconv.Itoa(v))
+		case float64:
+			q.Set(name, strconv.FormatFloat(v, 'f', -1, 64))
+		case []interface{}:
+			parts := make([]string, len(v))
+			for i, item := range v {
+				parts[i] = fmt.Sprint(item)
+			}
+			q.Set(name, strings.Join(parts, ","))
+		default:
+			q.Set(name, fmt.Sprint(v))
+		}
+	}
+	clone := r.Clone(r.Context())
+	clone.URL.RawQuery = q.Encode()
+	return clone
+}
+
+// gqlLimit reads a nested list's limit argument.
+func gqlLimit(e *gqlExecution, args map[string]interface{}, def, max int) (int, error) {
+	var errs validationErrors
+	limit := intParam(gqlArgsRequest(e.r, args), "limit", def, 1, max, &errs)
+	if len(errs) > 0 {
+		return 0, errs
+	}
+	return limit, nil
+}
+
+func gqlObjectID(args map[string]interface{}) (primitive.ObjectID, error) {
+	id, _ := args["id"].(string)
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return oid, validationErrors{{Field: "id", Message: "must be a valid ID"}}
+	}
+	return oid, nil
+}
+
+func resolveMe(e *gqlExecution, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	userID, ok := currentUserID(e.r)
+	if !ok {
+		return nil, errors.New("Authentication required")
+	}
+	user, err := userStore.UserByID(dbContext(e.r), userID)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{user}, nil
+}
+
+func resolveUser(e *gqlExecution, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	if _, ok := staffIdentity(e.r); !ok {
+		return nil, errors.New("Forbidden")
+	}
+	oid, err := gqlObjectID(args)
+	if err != nil {
+		return nil, err
+	}
+	var user models.User
+	if err := orgCollection("users").FindOne(dbContext(e.r), bson.M{"_id": oid}).Decode(&user); err != nil {
+		return nil, errors.New("User not found")
+	}
+	return []interface{}{user}, nil
+}
+
+func resolveComplaint(e *gqlExecution, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	oid, err := gqlObjectID(args)
+	if err != nil {
+		return nil, err
+	}
+	complaint, err := complaintStore.Complaint(dbContext(e.r), oid)
+	if err != nil || !canAccess(e.r, complaint) {
+		return nil, errors.New("Complaint not found")
+	}
+	one := []models.Complaint{complaint}
+	computeFields(&one[0], time.Now())
+	addReporterAvatars(e.r, one)
+	return []interface{}{one[0]}, nil
+}
+
+// resolveComplaints takes the admin listing's parameters as arguments.
+func resolveComplaints(e *gqlExecution, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	if _, ok := staffIdentity(e.r); !ok {
+		return nil, errors.New("Forbidden")
+	}
+	r := gqlArgsRequest(e.r, args)
+	filter, errs := adminComplaintFilter(r)
+	limit := intParam(r, "limit", 50, 1, 200, &errs)
+	sortBy, field, desc := adminSortParams(r, &errs)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	total, err := listingCollection("complaints").CountDocuments(dbContext(r), filter)
+	if err != nil {
+		return nil, err
+	}
+	complaints, next, err := complaintPage(r, filter, sortBy, field, desc, limit, nil)
+	if errors.Is(err, errInvalidCursor) {
+		return nil, validationErrors{{Field: "cursor", Message: err.Error()}}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{complaintPageResult{total, next, complaints}}, nil
+}
+
+// resolveMyComplaints takes the user listing's parameters, except that the
+// sla order is left to the REST listing.
+func resolveMyComplaints(e *gqlExecution, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	userID, ok := currentUserID(e.r)
+	if !ok {
+		return nil, errors.New("Authentication required")
+	}
+	delete(args, "userId")
+	r := gqlArgsRequest(e.r, args)
+	parsed, errs := listFilter(r)
+	limit := intParam(r, "limit", 50, 1, 200, &errs)
+	sortBy, field, desc := adminSortParams(r, &errs)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	parsed.UserID = userID
+	filter := parsed.BSON()
+	total, err := listingCollection("complaints").CountDocuments(dbContext(r), filter)
+	if err != nil {
+		return nil, err
+	}
+	complaints, next, err := complaintPage(r, filter, sortBy, field, desc, limit, nil)
+	if errors.Is(err, errInvalidCursor) {
+		return nil, validationErrors{{Field: "cursor", Message: err.Error()}}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{complaintPageResult{total, next, complaints}}, nil
+}
+
+func resolveStats(e *gqlExecution, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	if _, ok := staffIdentity(e.r); !ok {
+		return nil, errors.New("Forbidden")
+	}
+	q, errs := parseStatsQuery(gqlArgsRequest(e.r, args))
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	stats, err := loadAdminStats(dbContext(e.r), q)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{stats}, nil
+}
+
+// resolveReporters looks up the reporters of every complaint in the batch at
+// once. Reporters see themselves; only staff see other reporters.
+func resolveReporters(e *gqlExecution, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	_, staff := staffIdentity(e.r)
+	self, _ := currentUserID(e.r)
+	var ids []primitive.ObjectID
+	for _, p := range parents {
+		if c := p.(models.Complaint); !c.UserID.IsZero() && (staff || c.UserID == self) {
+			ids = append(ids, c.UserID)
+		}
+	}
+	out := make([]interface{}, len(parents))
+	if len(ids) == 0 {
+		return out, nil
+	}
+	cursor, err := orgCollection("users").Find(dbContext(e.r), bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(dbContext(e.r), &users); err != nil {
+		return nil, err
+	}
+	byID := make(map[primitive.ObjectID]models.User, len(users))
+	for _, u := range users {
+		byID[u.ID] = u
+	}
+	for i, p := range parents {
+		c := p.(models.Complaint)
+		if c.UserID.IsZero() {
+			continue
+		}
+		if !staff && c.UserID != self {
+			out[i] = gqlFieldError("Forbidden")
+			continue
+		}
+		if u, ok := byID[c.UserID]; ok {
+			out[i] = u
+		}
+	}
+	return out, nil
+}
+
+// resolveComments fetches the oldest top-level comments of every complaint
+// in the batch in one aggregation, hiding internal notes from reporters.
+func resolveComments(e *gqlExecution, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	limit, err := gqlLimit(e, args, 20, 100)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, len(parents))
+	for i, p := range parents {
+		ids[i] = p.(models.Complaint).ID
+	}
+	filter := hideInternalNotes(e.r, bson.M{"complaintId": bson.M{"$in": ids}, "parentId": bson.M{"$exists": false}})
+	cursor, err := documents.Collection("comments").Aggregate(dbContext(e.r), bson.A{
+		bson.M{"$match": filter},
+		bson.M{"$sort": bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}},
+		bson.M{"$group": bson.M{"_id": "$complaintId", "comments": bson.M{"$push": "$$ROOT"}}},
+		bson.M{"$project": bson.M{"comments": bson.M{"$slice": bson.A{"$comments", limit}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		ID       primitive.ObjectID `bson:"_id"`
+		Comments []models.Comment   `bson:"comments"`
+	}
+	if err := cursor.All(dbContext(e.r), &groups); err != nil {
+		return nil, err
+	}
+	var all []models.Comment
+	for _, g := range groups {
+		all = append(all, g.Comments...)
+	}
+	addAuthorAvatars(e.r, all)
+	byComplaint := map[primitive.ObjectID][]interface{}{}
+	for _, c := range all {
+		byComplaint[c.ComplaintID] = append(byComplaint[c.ComplaintID], c)
+	}
+	out := make([]interface{}, len(parents))
+	for i, id := range ids {
+		if comments := byComplaint[id]; comments != nil {
+			out[i] = comments
+		} else {
+			out[i] = []interface{}{}
+		}
+	}
+	return out, nil
+}
+
+// resolveUserComplaints fetches the newest complaints of every user in the
+// batch in one aggregation. Users see their own; staff see anyone's.
+func resolveUserComplaints(e *gqlExecution, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	limit, err := gqlLimit(e, args, 20, 100)
+	if err != nil {
+		return nil, err
+	}
+	_, staff := staffIdentity(e.r)
+	self, _ := currentUserID(e.r)
+	var ids []primitive.ObjectID
+	for _, p := range parents {
+		if u := p.(models.User); staff || u.ID == self {
+			ids = append(ids, u.ID)
+		}
+	}
+	out := make([]interface{}, len(parents))
+	byUser := map[primitive.ObjectID][]models.Complaint{}
+	if len(ids) > 0 {
+		cursor, err := orgCollection("complaints").Aggregate(dbContext(e.r), bson.A{
+			bson.M{"$match": bson.M{"userId": bson.M{"$in": ids}, "deletedAt": store.NotDeleted()}},
+			bson.M{"$sort": bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+			bson.M{"$group": bson.M{"_id": "$userId", "complaints": bson.M{"$push": "$$ROOT"}}},
+			bson.M{"$project": bson.M{"complaints": bson.M{"$slice": bson.A{"$complaints", limit}}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		var groups []struct {
+			ID         primitive.ObjectID `bson:"_id"`
+			Complaints []models.Complaint `bson:"complaints"`
+		}
+		if err := cursor.All(dbContext(e.r), &groups); err != nil {
+			return nil, err
+		}
+		var all []models.Complaint
+		for _, g := range groups {
+			all = append(all, g.Complaints...)
+		}
+		now := time.Now()
+		for i := range all {
+			truncateSummary(&all[i])
+			limitAttachments(&all[i])
+			computeFields(&all[i], now)
+		}
+		addReporterAvatars(e.r, all)
+		for _, c := range all {
+			byUser[c.UserID] = append(byUser[c.UserID], c)
+		}
+	}
+	for i, p := range parents {
+		u := p.(models.User)
+		if !staff && u.ID != self {
+			out[i] = gqlFieldError("Forbidden")
+			continue
+		}
+		out[i] = complaintList(byUser[u.ID])
+	}
+	return out, nil
+}
@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"complain/complaintpb"
+)
+
+// grpcHeaders are the metadata keys handed to the REST handlers as the
+// headers of the same name.
+var grpcHeaders = []string{"Authorization", "X-API-Key", "X-Admin-Token", "Accept-Language", "X-Request-ID", "Idempotency-Key", "If-Match"}
+
+// grpcRequest builds the HTTP request a call stands for, with the headers
+// the REST handlers read taken from the incoming metadata.
+func grpcRequest(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Request, error) {
+	var raw []byte
+	if body != nil {
+		var err error
+		if raw, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	target := apiPrefix + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	r, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, h := range grpcHeaders {
+		if v := md.Get(h); len(v) > 0 {
+			r.Header.Set(h, v[0])
+		}
+	}
+	return r, nil
+}
+
+// grpcAuthenticate is the gRPC counterpart of withAuthentication and
+// withRateLimit: an API key or bearer token in the metadata is checked the
+// same way and its caller attached to the context, an invalid one fails the
+// call, and calls count against the caller's limiter bucket. Staff admin
+// tokens travel on to the handlers like any other header.
+func grpcAuthenticate(limiter *rateLimiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		r, err := grpcRequest(ctx, http.MethodPost, "", nil, nil)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if raw, ok := sentAPIKey(r); ok {
+			key, err := lookupAPIKey(ctx, raw)
+			if errors.Is(err, errInvalidAPIKey) {
+				return nil, status.Error(codes.Unauthenticated, err.Error())
+			}
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+			ctx, _ = apiKeyContext(ctx, key)
+		} else {
+			user, present, err := bearerUser(r)
+			if errors.Is(err, errInvalidToken) {
+				return nil, status.Error(codes.Unauthenticated, err.Error())
+			}
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+			if present {
+				ctx = context.WithValue(ctx, tokenUserKey, user)
+			}
+		}
+		if limiter.burst > 0 {
+			ok, retryAfter := limiter.reserve(callerKey(r.WithContext(ctx)))
+			if !ok {
+				grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))))
+				return nil, status.Error(codes.ResourceExhausted, "Too many requests")
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// grpcResponse collects what a REST handler writes for a call.
+type grpcResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (g *grpcResponse) Header() http.Header { return g.header }
+
+func (g *grpcResponse) WriteHeader(code int) {
+	if g.status == 0 {
+		g.status = code
+	}
+}
+
+func (g *grpcResponse) Write(b []byte) (int, error) {
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	return g.body.Write(b)
+}
+
+// grpcCodes maps the statuses the REST handlers answer with to gRPC codes.
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusUnprocessableEntity:   codes.InvalidArgument,
+	http.StatusRequestEntityTooLarge: codes.InvalidArgument,
+	http.StatusUnauthorized:          codes.Unauthenticated,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusGone:                  codes.NotFound,
+	http.StatusConflict:              codes.Aborted,
+	http.StatusPreconditionFailed:    codes.FailedPrecondition,
+	http.StatusPreconditionRequired:  codes.FailedPrecondition,
+	http.StatusTooManyRequests:       codes.ResourceExhausted,
+	http.StatusNotImplemented:        codes.Unimplemented,
+	http.StatusServiceUnavailable:    codes.Unavailable,
+	http.StatusGatewayTimeout:        codes.DeadlineExceeded,
+	499:                              codes.Canceled,
+}
+
+// grpcServer serves ComplaintService by running each call through the
+// /api/v1 route it mirrors, so both APIs share routing, authorization,
+// validation and the store. Responses are read into the proto messages by
+// their JSON names, which match the REST ones.
+type grpcServer struct {
+	complaintpb.UnimplementedComplaintServiceServer
+	api http.Handler
+}
+
+func (s *grpcServer) call(ctx context.Context, method, path string, query url.Values, body interface{}, out proto.Message) error {
+	if apiKeyForbids(ctx, method) {
+		return status.Error(codes.PermissionDenied, "API key is read-only")
+	}
+	r, err := grpcRequest(ctx, method, path, query, body)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	w := &grpcResponse{header: http.Header{}}
+	s.api.ServeHTTP(w, r)
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= http.StatusBadRequest {
+		code, ok := grpcCodes[w.status]
+		if !ok {
+			code = codes.Internal
+		}
+		return status.Error(code, strings.TrimSpace(w.body.String()))
+	}
+	if out == nil || w.body.Len() == 0 {
+		return nil
+	}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(w.body.Bytes(), out); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// setQuery sets the non-empty values among key, value pairs.
+func setQuery(q url.Values, pairs ...string) url.Values {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			q.Set(pairs[i], pairs[i+1])
+		}
+	}
+	return q
+}
+
+func itoa(n int32) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(int(n))
+}
+
+func (s *grpcServer) UpdateProfile(ctx context.Context, req *complaintpb.UpdateProfileRequest) (*complaintpb.User, error) {
+	body := map[string]interface{}{}
+	for k, v := range map[string]string{"name": req.Name, "email": req.Email, "locale": req.Locale} {
+		if v != "" {
+			body[k] = v
+		}
+	}
+	out := &complaintpb.User{}
+	return out, s.call(ctx, http.MethodPatch, "/users/me", nil, body, out)
+}
+
+func (s *grpcServer) SubmitComplaint(ctx context.Context, req *complaintpb.SubmitComplaintRequest) (*complaintpb.Complaint, error) {
+	if req.IdempotencyKey != "" {
+		md, _ := metadata.FromIncomingContext(ctx)
+		md = md.Copy()
+		md.Set("idempotency-key", req.IdempotencyKey)
+		ctx = metadata.NewIncomingContext(ctx, md)
+	}
+	body := map[string]interface{}{"title": req.Title, "summary": req.Summary, "rating": req.Rating}
+	if req.Category != "" {
+		body["category"] = req.Category
+	}
+	if req.Priority != "" {
+		body["priority"] = req.Priority
+	}
+	out := &complaintpb.Complaint{}
+	return out, s.call(ctx, http.MethodPost, "/complaints", nil, body, out)
+}
+
+func (s *grpcServer) GetComplaint(ctx context.Context, req *complaintpb.GetComplaintRequest) (*complaintpb.Complaint, error) {
+	out := &complaintpb.Complaint{}
+	return out, s.call(ctx, http.MethodGet, "/complaints/"+url.PathEscape(req.Id), nil, nil, out)
+}
+
+func (s *grpcServer) ListMyComplaints(ctx context.Context, req *complaintpb.ListMyComplaintsRequest) (*complaintpb.ListMyComplaintsResponse, error) {
+	q := setQuery(url.Values{}, "resolved", req.Resolved, "sort", req.Sort, "order", req.Order, "limit", itoa(req.Limit), "cursor", req.Cursor)
+	out := &complaintpb.ListMyComplaintsResponse{}
+	return out, s.call(ctx, http.MethodGet, "/users/me/complaints", q, nil, out)
+}
+
+func (s *grpcServer) ListComplaints(ctx context.Context, req *complaintpb.ListComplaintsRequest) (*complaintpb.ListComplaintsResponse, error) {
+	q := setQuery(url.Values{}, "resolved", req.Resolved, "sort", req.Sort, "order", req.Order, "limit", itoa(req.Limit),
+		"cursor", req.Cursor, "category", req.Category, "assignedTo", req.AssignedTo, "savedSearch", req.SavedSearch)
+	out := &complaintpb.ListComplaintsResponse{}
+	return out, s.call(ctx, http.MethodGet, "/complaints", q, nil, out)
+}
+
+func (s *grpcServer) EditComplaint(ctx context.Context, req *complaintpb.EditComplaintRequest) (*complaintpb.Complaint, error) {
+	body := map[string]interface{}{}
+	if req.Title != "" {
+		body["title"] = req.Title
+	}
+	if req.Summary != "" {
+		body["summary"] = req.Summary
+	}
+	if req.Rating != 0 {
+		body["rating"] = req.Rating
+	}
+	out := &complaintpb.Complaint{}
+	return out, s.call(ctx, http.MethodPatch, "/complaints/"+url.PathEscape(req.Id), nil, body, out)
+}
+
+func (s *grpcServer) DeleteComplaint(ctx context.Context, req *complaintpb.DeleteComplaintRequest) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, s.call(ctx, http.MethodDelete, "/complaints/"+url.PathEscape(req.Id), nil, nil, nil)
+}
+
+func (s *grpcServer) ResolveComplaint(ctx context.Context, req *complaintpb.ResolveComplaintRequest) (*complaintpb.Complaint, error) {
+	q := setQuery(url.Values{}, "version", itoa(req.Version), "templateId", req.TemplateId)
+	out := &complaintpb.Complaint{}
+	return out, s.call(ctx, http.MethodPatch, "/complaints/"+url.PathEscape(req.Id)+"/resolve", q, nil, out)
+}
+
+func (s *grpcServer) ReopenComplaint(ctx context.Context, req *complaintpb.ReopenComplaintRequest) (*complaintpb.Complaint, error) {
+	out := &complaintpb.Complaint{}
+	return out, s.call(ctx, http.MethodPost, "/complaints/"+url.PathEscape(req.Id)+"/reopen", nil,
+		map[string]interface{}{"reason": req.Reason}, out)
+}
+
+func (s *grpcServer) AssignComplaint(ctx context.Context, req *complaintpb.AssignComplaintRequest) (*complaintpb.Complaint, error) {
+	q := setQuery(url.Values{}, "agent", req.Agent, "version", itoa(req.Version))
+	out := &complaintpb.Complaint{}
+	return out, s.call(ctx, http.MethodPut, "/complaints/"+url.PathEscape(req.Id)+"/assignee", q, nil, out)
+}
+
+func (s *grpcServer) ListComments(ctx context.Context, req *complaintpb.ListCommentsRequest) (*complaintpb.ListCommentsResponse, error) {
+	q := setQuery(url.Values{}, "parentId", req.ParentId, "page", itoa(req.Page), "pageSize", itoa(req.PageSize))
+	out := &complaintpb.ListCommentsResponse{}
+	return out, s.call(ctx, http.MethodGet, "/complaints/"+url.PathEscape(req.ComplaintId)+"/comments", q, nil, out)
+}
+
+func (s *grpcServer) AddComment(ctx context.Context, req *complaintpb.AddCommentRequest) (*complaintpb.Comment, error) {
+	body := map[string]interface{}{"body": req.Body}
+	if req.ParentId != "" {
+		body["parentId"] = req.ParentId
+	}
+	if req.Visibility != "" {
+		body["visibility"] = req.Visibility
+	}
+	out := &complaintpb.Comment{}
+	return out, s.call(ctx, http.MethodPost, "/complaints/"+url.PathEscape(req.ComplaintId)+"/comments", nil, body, out)
+}
+
+// serveGRPC serves ComplaintService on addr until stopGRPC is called. Calls
+// are logged like HTTP requests, and error messages follow Accept-Language
+// metadata.
+func serveGRPC(addr string, limiter *rateLimiter) *grpc.Server {
+	srv := grpc.NewServer(grpc.UnaryInterceptor(grpcAuthenticate(limiter)))
+	complaintpb.RegisterComplaintServiceServer(srv, &grpcServer{api: withRequestLog(withRecovery(withDBTimeout(withLocalization(router))))})
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("grpc: %v", err)
+	}
+	go func() {
+		log.Printf("grpc listening on %s", addr)
+		if err := srv.Serve(lis); err != nil {
+			log.Printf("grpc: %v", err)
+		}
+	}()
+	return srv
+}
+
+// stopGRPC lets calls in flight finish within timeout, then cuts the rest.
+func stopGRPC(srv *grpc.Server, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		srv.Stop()
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"complain/store"
+)
+
+// requiredIndexes are the indexes correctness depends on: the unique ones
+// guard against duplicate data and the text index backs search. An instance
+// pointed at a database without them should not take traffic.
+var requiredIndexes = map[string][]string{
+	"users":      {"email_1", "secretCodeHash_1"},
+	"complaints": {"refNumber_1", "userId_1_idempotencyKey_1", "complaint_text"},
+}
+
+// draining is set once shutdown starts, so load balancers stop routing new
+// requests here while in-flight ones finish.
+var draining atomic.Bool
+
+type readiness struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// healthzHandler reports that the process is up and serving HTTP. It never
+// touches the database, so a Mongo outage does not get the pod restarted.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// readyzHandler reports whether this instance can serve requests: MongoDB
+// answers a ping within READINESS_TIMEOUT, as does PostgreSQL when it holds
+// the store, and the required indexes exist.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), envDuration("READINESS_TIMEOUT", 2*time.Second))
+	defer cancel()
+
+	result := readiness{Status: "ok", Checks: map[string]string{"mongo": "ok", "indexes": "ok", "shutdown": "ok"}}
+	if draining.Load() {
+		result.Checks["shutdown"] = "draining"
+		result.Status = "unavailable"
+	}
+	if pg, ok := documents.(*store.Postgres); ok {
+		result.Checks["postgres"] = "ok"
+		if err := pg.Ping(ctx); err != nil {
+			result.Checks["postgres"] = err.Error()
+			result.Status = "unavailable"
+		}
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		result.Checks["mongo"] = err.Error()
+		result.Checks["indexes"] = "skipped"
+		result.Status = "unavailable"
+	} else if missing, err := missingIndexes(ctx); err != nil {
+		result.Checks["indexes"] = err.Error()
+		result.Status = "unavailable"
+	} else if len(missing) > 0 {
+		result.Checks["indexes"] = "missing " + strings.Join(missing, ", ")
+		result.Status = "unavailable"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+func missingIndexes(ctx context.Context) ([]string, error) {
+	var missing []string
+	for collection, names := range requiredIndexes {
+		indexes, err := documents.Indexes(ctx, collection)
+		if err != nil {
+			return nil, fmt.Errorf("list %s indexes: %w", collection, err)
+		}
+		present := map[string]bool{}
+		for _, name := range indexes {
+			present[name] = true
+		}
+		for _, name := range names {
+			if !present[name] {
+				missing = append(missing, collection+"."+name)
+			}
+		}
+	}
+	return missing, nil
+}
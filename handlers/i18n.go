@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const localeKey contextKey = iota + 200
+
+// messageCatalogs translate user-facing messages from English, by locale. A
+// message that varies holds numbered placeholders, {1}, {2} and so on, which
+// the translation puts wherever its grammar needs them. initI18n adds or
+// overrides entries from MESSAGE_CATALOG_DIR/<locale>.json, a flat object
+// of the same form.
+var messageCatalogs = map[string]map[string]string{
+	"hi": hindiMessages,
+}
+
+var hindiMessages = map[string]string{
+	"A complaint can have at most {1} attachments":                         "एक शिकायत में अधिकतम {1} अनुलग्नक हो सकते हैं",
+	"A version is required via If-Match or the request body":               "If-Match हेडर या अनुरोध के मुख्य भाग में संस्करण देना आवश्यक है",
+	"A version is required via If-Match or the version parameter":          "If-Match हेडर या version पैरामीटर में संस्करण देना आवश्यक है",
+	"API key is read-only":                                                 "API कुंजी केवल पढ़ने के लिए है",
+	"API key not found":                                                    "API कुंजी नहीं मिली",
+	"API keys cannot manage API keys":                                      "API कुंजियाँ API कुंजियों का प्रबंधन नहीं कर सकतीं",
+	"Anonymous complaints are not accepted":                                "गुमनाम शिकायतें स्वीकार नहीं की जातीं",
+	"Archived complaint not found":                                         "संग्रहीत शिकायत नहीं मिली",
+	"Attachment is too large":                                              "अनुलग्नक बहुत बड़ा है",
+	"Attachment not found":                                                 "अनुलग्नक नहीं मिला",
+	"Attachment type {1} is not allowed":                                   "अनुलग्नक का प्रकार {1} स्वीकार्य नहीं है",
+	"Authentication required":                                              "प्रमाणीकरण आवश्यक है",
+	"Avatar is too large":                                                  "अवतार बहुत बड़ा है",
+	"Avatar must be a JPEG, PNG, GIF or WebP image":                        "अवतार JPEG, PNG, GIF या WebP छवि होना चाहिए",
+	"Avatar not found":                                                     "अवतार नहीं मिला",
+	"Cannot move a complaint from {1} to {2}":                              "शिकायत को {1} से {2} में नहीं बदला जा सकता",
+	"Cannot query field {1} on type {2}":                                   "टाइप {2} पर फ़ील्ड {1} की क्वेरी नहीं की जा सकती",
+	"Category not found":                                                   "श्रेणी नहीं मिली",
+	"Chat channel not found":                                               "चैट चैनल नहीं मिला",
+	"Client closed request":                                                "क्लाइंट ने अनुरोध बंद कर दिया",
+	"Complaint has no content hash":                                        "शिकायत का कोई सामग्री हैश नहीं है",
+	"Complaint is closed":                                                  "शिकायत बंद है",
+	"Complaint not found":                                                  "शिकायत नहीं मिली",
+	"Complaint was itself merged into another":                             "यह शिकायत स्वयं किसी अन्य शिकायत में मिला दी गई है",
+	"Complaint was modified by another request; reload and try again":      "शिकायत को किसी अन्य अनुरोध ने बदल दिया है; पुनः लोड करके फिर से प्रयास करें",
+	"Database timed out":                                                   "डेटाबेस का समय समाप्त हो गया",
+	"Deleted complaint not found":                                          "हटाई गई शिकायत नहीं मिली",
+	"Email address is already verified":                                    "ईमेल पता पहले से सत्यापित है",
+	"Email already registered":                                             "यह ईमेल पहले से पंजीकृत है",
+	"Feedback can be given once the complaint is resolved":                 "शिकायत का समाधान होने के बाद ही प्रतिक्रिया दी जा सकती है",
+	"Feedback was already given for this complaint":                        "इस शिकायत के लिए प्रतिक्रिया पहले ही दी जा चुकी है",
+	"Field {1} of type {2} has no subfields":                               "टाइप {2} के फ़ील्ड {1} में कोई उप-फ़ील्ड नहीं है",
+	"Field {1} of type {2} needs a selection of subfields":                 "टाइप {2} के फ़ील्ड {1} के लिए उप-फ़ील्ड चुनना आवश्यक है",
+	"Flagged complaint not found":                                          "चिह्नित शिकायत नहीं मिली",
+	"Forbidden":                                                            "अनुमति नहीं है",
+	"Import file has too many rows":                                        "आयात फ़ाइल में बहुत अधिक पंक्तियाँ हैं",
+	"Invalid Last-Event-ID":                                                "अमान्य Last-Event-ID",
+	"Invalid or expired invitation":                                        "आमंत्रण अमान्य है या उसकी अवधि समाप्त हो चुकी है",
+	"Invalid or expired refresh token":                                     "रिफ्रेश टोकन अमान्य है या उसकी अवधि समाप्त हो चुकी है",
+	"Login has expired, please start again":                                "लॉगिन की अवधि समाप्त हो गई है, कृपया फिर से शुरू करें",
+	"Login provider did not share a valid email address":                   "लॉगिन प्रदाता ने कोई मान्य ईमेल पता साझा नहीं किया",
+	"Login provider has not verified this email address":                   "लॉगिन प्रदाता ने इस ईमेल पते को सत्यापित नहीं किया है",
+	"Login provider is unavailable":                                        "लॉगिन प्रदाता उपलब्ध नहीं है",
+	"Login provider rejected the login":                                    "लॉगिन प्रदाता ने लॉगिन अस्वीकार कर दिया",
+	"Login was not completed: {1}":                                         "लॉगिन पूरा नहीं हुआ: {1}",
+	"Method not allowed":                                                   "यह विधि स्वीकार्य नहीं है",
+	"No feedback for this complaint":                                       "इस शिकायत के लिए कोई प्रतिक्रिया नहीं है",
+	"No pending account deletion":                                          "खाता हटाने का कोई लंबित अनुरोध नहीं है",
+	"No pending code for this number; request a new one":                   "इस नंबर के लिए कोई लंबित कोड नहीं है; नया कोड मांगें",
+	"No pending resolution for this complaint":                             "इस शिकायत का कोई लंबित समाधान नहीं है",
+	"Notification not found":                                               "सूचना नहीं मिली",
+	"Only query operations are supported, not {1}":                         "केवल query ऑपरेशन समर्थित हैं, {1} नहीं",
+	"Only staff can write internal notes":                                  "केवल कर्मचारी ही आंतरिक टिप्पणियाँ लिख सकते हैं",
+	"Organization already exists":                                          "संगठन पहले से मौजूद है",
+	"Organization not found":                                               "संगठन नहीं मिला",
+	"Origin not allowed":                                                   "इस ओरिजिन की अनुमति नहीं है",
+	"Please verify your email address before submitting complaints":        "शिकायत दर्ज करने से पहले कृपया अपना ईमेल पता सत्यापित करें",
+	"Profile was modified by another request":                              "प्रोफ़ाइल को किसी अन्य अनुरोध ने बदल दिया है",
+	"Requires a platform admin":                                            "इसके लिए प्लेटफ़ॉर्म व्यवस्थापक आवश्यक है",
+	"Resolution requires approval; move the complaint to pending_approval": "समाधान के लिए स्वीकृति आवश्यक है; शिकायत को pending_approval में ले जाएँ",
+	"Resolutions require manager approval":                                 "समाधान के लिए प्रबंधक की स्वीकृति आवश्यक है",
+	"Resolved complaint not found":                                         "हल की गई शिकायत नहीं मिली",
+	"Resolved complaints can no longer be edited":                          "हल की गई शिकायतों को अब संपादित नहीं किया जा सकता",
+	"Resolved complaints cannot be masters":                                "हल की गई शिकायतें मुख्य शिकायत नहीं बन सकतीं",
+	"Saved search not found":                                               "सहेजी गई खोज नहीं मिली",
+	"Session not found":                                                    "सत्र नहीं मिला",
+	"Streaming not supported":                                              "स्ट्रीमिंग समर्थित नहीं है",
+	"Template not found":                                                   "टेम्पलेट नहीं मिला",
+	"Text messages are not available":                                      "टेक्स्ट संदेश उपलब्ध नहीं हैं",
+	"The query is nested deeper than {1} levels":                           "क्वेरी {1} स्तरों से अधिक गहराई तक नेस्टेड है",
+	"The reopen window for this complaint has passed; please submit a new complaint": "इस शिकायत को फिर से खोलने की समय-सीमा बीत चुकी है; कृपया नई शिकायत दर्ज करें",
+	"Too many requests": "बहुत अधिक अनुरोध",
+	"Too many similar complaints, please wait before submitting again": "बहुत सारी मिलती-जुलती शिकायतें आई हैं, कृपया फिर से दर्ज करने से पहले प्रतीक्षा करें",
+	"Unknown login provider":                                   "अज्ञात लॉगिन प्रदाता",
+	"User not found":                                           "उपयोगकर्ता नहीं मिला",
+	"Variable ${1} is required":                                "वेरिएबल ${1} आवश्यक है",
+	"Variables must be a JSON object":                          "variables एक JSON ऑब्जेक्ट होना चाहिए",
+	"Verification link is invalid or has expired":              "सत्यापन लिंक अमान्य है या उसकी अवधि समाप्त हो चुकी है",
+	"Webhook not found":                                        "वेबहुक नहीं मिला",
+	"empty request body":                                       "अनुरोध का मुख्य भाग खाली है",
+	"invalid or expired access token":                          "एक्सेस टोकन अमान्य है या उसकी अवधि समाप्त हो चुकी है",
+	"invalid, expired or revoked API key":                      "API कुंजी अमान्य, समाप्त या रद्द है",
+	"operationName does not name an operation of the document": "operationName दस्तावेज़ के किसी ऑपरेशन का नाम नहीं है",
+	"userId does not match the authenticated user":             "userId प्रमाणित उपयोगकर्ता से मेल नहीं खाता",
+
+	// Field messages from writeValidationErrors.
+	"cannot be changed":                                    "बदला नहीं जा सकता",
+	"cannot be greater than maxRating":                     "maxRating से अधिक नहीं हो सकता",
+	"contains a tag that is too long":                      "इसमें एक बहुत लंबा टैग है",
+	"does not belong to this complaint":                    "इस शिकायत से संबंधित नहीं है",
+	"has too many entries":                                 "इसमें बहुत अधिक प्रविष्टियाँ हैं",
+	"invalid cursor":                                       "अमान्य कर्सर",
+	"is already in use":                                    "पहले से उपयोग में है",
+	"is empty":                                             "खाली है",
+	"is incorrect":                                         "गलत है",
+	"is not a known category":                              "कोई ज्ञात श्रेणी नहीं है",
+	"is not a known organization":                          "कोई ज्ञात संगठन नहीं है",
+	"is not a known template":                              "कोई ज्ञात टेम्पलेट नहीं है",
+	"is not a listing parameter":                           "सूची का पैरामीटर नहीं है",
+	"is only available to admins":                          "केवल व्यवस्थापकों के लिए उपलब्ध है",
+	"is required":                                          "आवश्यक है",
+	"is too long":                                          "बहुत लंबा है",
+	"must be 2 to 40 lowercase letters, digits or hyphens": "2 से 40 छोटे अक्षर, अंक या हाइफ़न होने चाहिए",
+	"must be 1-32 lowercase letters, digits, - or _, starting with a letter or digit": "1-32 छोटे अक्षर, अंक, - या _ होने चाहिए, और पहला वर्ण अक्षर या अंक होना चाहिए",
+	"must be a non-negative integer":                       "ऋणेतर पूर्णांक होना चाहिए",
+	"must be a positive duration such as 24h":              "24h जैसी धनात्मक अवधि होनी चाहिए",
+	"must be a valid ID":                                   "मान्य ID होना चाहिए",
+	"must be a valid email address":                        "मान्य ईमेल पता होना चाहिए",
+	"must be a whole number between {1} and {2}":           "{1} और {2} के बीच की पूर्ण संख्या होनी चाहिए",
+	"must be an IANA time zone":                            "IANA समय क्षेत्र होना चाहिए",
+	"must be an RFC3339 time or a date":                    "RFC3339 समय या तारीख होना चाहिए",
+	"must be an RFC3339 time":                              "RFC3339 समय होना चाहिए",
+	"must be an absolute http or https URL":                "पूर्ण http या https URL होना चाहिए",
+	"must be an international number such as +14155550123": "+14155550123 जैसा अंतरराष्ट्रीय नंबर होना चाहिए",
+	"must be approve or reject":                            "approve या reject होना चाहिए",
+	"must be asc or desc":                                  "asc या desc होना चाहिए",
+	"must be at most 255 characters":                       "अधिकतम 255 वर्णों का होना चाहिए",
+	"must be before to":                                    "to से पहले होना चाहिए",
+	"must be between 1 and 5":                              "1 और 5 के बीच होना चाहिए",
+	"must be between 1 and 5, or omitted":                  "1 और 5 के बीच होना चाहिए, या इसे छोड़ दें",
+	"must be createdAt, updatedAt, rating or status":       "createdAt, updatedAt, rating या status होना चाहिए",
+	"must be createdAt, updatedAt, rating, status or sla":  "createdAt, updatedAt, rating, status या sla होना चाहिए",
+	"must be csv or xlsx":                                  "csv या xlsx होना चाहिए",
+	"must be in the future":                                "भविष्य का होना चाहिए",
+	"must be longer than the previous level's":             "पिछले स्तर की अवधि से लंबी होनी चाहिए",
+	"must be one of {1}":                                   "इनमें से एक होना चाहिए: {1}",
+	"must be public or internal":                           "public या internal होना चाहिए",
+	"must be resolve, close, assign or tag":                "resolve, close, assign या tag होना चाहिए",
+	"must be true or false":                                "true या false होना चाहिए",
+	"must contain email, sms or both":                      "email, sms या दोनों होने चाहिए",
+	"must contain only email and sms":                      "केवल email और sms हो सकते हैं",
+	"must list at least one event":                         "कम से कम एक इवेंट होना चाहिए",
+	"sms needs a verified phone number":                    "sms के लिए सत्यापित फ़ोन नंबर आवश्यक है",
+	"{1} is not one of {2}":                                "{1}, {2} में से नहीं है",
+}
+
+// catalogPattern matches a catalog entry with placeholders.
+type catalogPattern struct {
+	re          *regexp.Regexp
+	translation string
+}
+
+var (
+	catalogPatterns = map[string][]catalogPattern{}
+	placeholder     = regexp.MustCompile(`\\\{(\d+)\\\}`)
+)
+
+func initI18n() {
+	if dir := envString("MESSAGE_CATALOG_DIR", ""); dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			log.Fatalf("MESSAGE_CATALOG_DIR: %v", err)
+		}
+		for _, file := range files {
+			b, err := os.ReadFile(file)
+			if err != nil {
+				log.Fatalf("message catalog %s: %v", file, err)
+			}
+			var entries map[string]string
+			if err := json.Unmarshal(b, &entries); err != nil {
+				log.Fatalf("message catalog %s: %v", file, err)
+			}
+			locale := strings.ToLower(strings.TrimSuffix(filepath.Base(file), ".json"))
+			merged := map[string]string{}
+			for k, v := range messageCatalogs[locale] {
+				merged[k] = v
+			}
+			for k, v := range entries {
+				merged[k] = v
+			}
+			messageCatalogs[locale] = merged
+		}
+	}
+
+	for locale, catalog := range messageCatalogs {
+		for msg, translation := range catalog {
+			if !strings.Contains(msg, "{") {
+				continue
+			}
+			re := placeholder.ReplaceAllString(regexp.QuoteMeta(msg), `(?P<p$1>.+?)`)
+			catalogPatterns[locale] = append(catalogPatterns[locale], catalogPattern{
+				re:          regexp.MustCompile("^" + re + "$"),
+				translation: translation,
+			})
+		}
+		// Longer patterns are more specific, so they are tried first.
+		sort.Slice(catalogPatterns[locale], func(i, j int) bool {
+			return len(catalogPatterns[locale][i].re.String()) > len(catalogPatterns[locale][j].re.String())
+		})
+	}
+}
+
+// translate returns msg in locale, or msg itself if the catalog has no
+// entry for it.
+func translate(locale, msg string) string {
+	if t, ok := messageCatalogs[locale][msg]; ok {
+		return t
+	}
+	for _, p := range catalogPatterns[locale] {
+		m := p.re.FindStringSubmatch(msg)
+		if m == nil {
+			continue
+		}
+		t := p.translation
+		for i, name := range p.re.SubexpNames() {
+			if name != "" {
+				t = strings.ReplaceAll(t, "{"+name[1:]+"}", m[i])
+			}
+		}
+		return t
+	}
+	return msg
+}
+
+// supportedLocale maps a language tag to a locale with a catalog, trying
+// the tag and then its base language. English needs none.
+func supportedLocale(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	base, _, _ := strings.Cut(tag, "-")
+	for _, l := range []string{tag, base} {
+		if l == "en" || messageCatalogs[l] != nil {
+			return l
+		}
+	}
+	return ""
+}
+
+// negotiateLocale picks the supported locale an Accept-Language header
+// prefers most, falling back to English. Equal weights keep header order.
+func negotiateLocale(header string) string {
+	best, bestQ := "en", 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if l := supportedLocale(tag); l != "" && q > bestQ {
+			best, bestQ = l, q
+		}
+	}
+	return best
+}
+
+// requestLocale is the locale negotiated for r.
+func requestLocale(r *http.Request) string {
+	if l, ok := r.Context().Value(localeKey).(string); ok {
+		return l
+	}
+	return "en"
+}
+
+// localizedWriter translates the plain-text bodies http.Error writes for
+// error statuses. Messages missing from the catalog, such as database
+// errors, pass through in English.
+type localizedWriter struct {
+	http.ResponseWriter
+	locale    string
+	translate bool
+}
+
+func (l *localizedWriter) WriteHeader(code int) {
+	if code >= http.StatusBadRequest && strings.HasPrefix(l.Header().Get("Content-Type"), "text/plain") {
+		l.translate = true
+		l.Header().Set("Content-Language", l.locale)
+	}
+	l.ResponseWriter.WriteHeader(code)
+}
+
+func (l *localizedWriter) Write(b []byte) (int, error) {
+	if !l.translate {
+		return l.ResponseWriter.Write(b)
+	}
+	msg := strings.TrimSuffix(string(b), "\n")
+	t := translate(l.locale, msg)
+	if t == msg {
+		return l.ResponseWriter.Write(b)
+	}
+	if _, err := io.WriteString(l.ResponseWriter, t+"\n"); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (l *localizedWriter) Flush() {
+	if f, ok := l.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades through.
+func (l *localizedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := l.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	return h.Hijack()
+}
+
+func (l *localizedWriter) Unwrap() http.ResponseWriter {
+	return l.ResponseWriter
+}
+
+// writerLocale finds the locale a response is being translated to.
+func writerLocale(w http.ResponseWriter) string {
+	for {
+		switch lw := w.(type) {
+		case *localizedWriter:
+			return lw.locale
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = lw.Unwrap()
+		default:
+			return "en"
+		}
+	}
+}
+
+// withLocalization negotiates the response language from Accept-Language
+// for error messages and for the notification locale of new accounts.
+func withLocalization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locale := negotiateLocale(r.Header.Get("Accept-Language"))
+		r = r.WithContext(context.WithValue(r.Context(), localeKey, locale))
+		w.Header().Add("Vary", "Accept-Language")
+		if messageCatalogs[locale] != nil {
+			w = &localizedWriter{ResponseWriter: w, locale: locale}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,502 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"complain/models"
+	"complain/store"
+)
+
+// importStatuses are the statuses an imported complaint may arrive in.
+// pending_approval is left out: it needs an approval request the old
+// system cannot supply.
+var importStatuses = []string{models.StatusOpen, models.StatusInProgress, models.StatusResolved, models.StatusClosed, models.StatusReopened}
+
+type importUser struct {
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	OrgID     string    `json:"orgId,omitempty"`
+	Locale    string    `json:"locale,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	row       int
+	errs      validationErrors // found while parsing
+}
+
+type importComplaint struct {
+	Email      string     `json:"email"` // the reporter's
+	Title      string     `json:"title"`
+	Summary    string     `json:"summary"`
+	Rating     int        `json:"rating,omitempty"`
+	Status     string     `json:"status,omitempty"`
+	Category   string     `json:"category,omitempty"`
+	Priority   string     `json:"priority,omitempty"`
+	Tags       []string   `json:"tags,omitempty"`
+	AssignedTo string     `json:"assignedTo,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt,omitempty"`
+	UpdatedAt  time.Time  `json:"updatedAt,omitempty"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+	row        int
+	errs       validationErrors // found while parsing
+}
+
+// importFile is the JSON import format. A CSV import has one row per
+// complaint, with the reporter's columns repeated on each; a row with no
+// title only imports its user.
+type importFile struct {
+	Users      []importUser      `json:"users"`
+	Complaints []importComplaint `json:"complaints"`
+}
+
+// importRowResult reports on one user or complaint of the file. Row is the
+// CSV line, or the position in the JSON list, counting from 1.
+type importRowResult struct {
+	Kind    string           `json:"kind"` // user or complaint
+	Row     int              `json:"row"`
+	Email   string           `json:"email,omitempty"`
+	ID      string           `json:"id,omitempty"`
+	Outcome string           `json:"outcome"` // valid, created, existing or failed
+	Errors  validationErrors `json:"errors,omitempty"`
+}
+
+type importResult struct {
+	DryRun             bool              `json:"dryRun"`
+	UsersCreated       int               `json:"usersCreated"`
+	UsersExisting      int               `json:"usersExisting"`
+	ComplaintsCreated  int               `json:"complaintsCreated"`
+	ComplaintsExisting int               `json:"complaintsExisting"`
+	Failed             int               `json:"failed"`
+	Rows               []importRowResult `json:"rows"`
+}
+
+func (res *importResult) add(row importRowResult) {
+	switch {
+	case row.Outcome == "failed":
+		res.Failed++
+	case row.Outcome == "created" && row.Kind == "user":
+		res.UsersCreated++
+	case row.Outcome == "existing" && row.Kind == "user":
+		res.UsersExisting++
+	case row.Outcome == "created":
+		res.ComplaintsCreated++
+	case row.Outcome == "existing":
+		res.ComplaintsExisting++
+	}
+	res.Rows = append(res.Rows, row)
+}
+
+// importHandler serves POST /admin/import: users and complaints migrated
+// from another system, as a CSV or JSON file sent as the body or as the
+// multipart field file. Users are matched to existing accounts by email;
+// complaints keep their original timestamps and status, and one already
+// imported for the same reporter, content and creation time is reported as
+// existing, so a failed import can be run again. Rows that fail validation
+// are reported and skipped; dryRun=true only validates.
+//
+// Imported users start verified, as their addresses come from the old
+// system, and get a secret code nobody is shown; they reset it to log in.
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var dryRun bool
+	if v := r.URL.Query().Get("dryRun"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			writeFieldError(w, "dryRun", "must be true or false")
+			return
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(envInt("IMPORT_MAX_BYTES", 32<<20)))
+	body, format, err := importBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var file importFile
+	switch format {
+	case "json":
+		dec := json.NewDecoder(body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&file); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for i := range file.Users {
+			file.Users[i].row = i + 1
+		}
+		for i := range file.Complaints {
+			file.Complaints[i].row = i + 1
+		}
+	case "csv":
+		if file, err = parseImportCSV(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		writeFieldError(w, "format", "must be csv or json")
+		return
+	}
+	if len(file.Users)+len(file.Complaints) > envInt("IMPORT_MAX_ROWS", 10000) {
+		http.Error(w, "Import file has too many rows", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	res, err := runImport(r, file, dryRun)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// importBody returns the file to import and its format, taken from the
+// format parameter, else the file name or content type.
+func importBody(r *http.Request) (io.Reader, string, error) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		if format == "" {
+			format = importFormat("", mediaType)
+		}
+		return r.Body, format, nil
+	}
+	f, header, err := r.FormFile("file")
+	if err != nil {
+		return nil, "", fmt.Errorf("file: %w", err)
+	}
+	if format == "" {
+		format = importFormat(header.Filename, header.Header.Get("Content-Type"))
+	}
+	return f, format, nil
+}
+
+func importFormat(name, contentType string) string {
+	switch ext := strings.ToLower(filepath.Ext(name)); {
+	case ext == ".csv", strings.HasPrefix(contentType, "text/csv"):
+		return "csv"
+	case ext == ".json", strings.HasPrefix(contentType, "application/json"):
+		return "json"
+	}
+	return ""
+}
+
+// parseImportCSV reads a CSV import by its header row. Times are RFC 3339
+// and tags are separated by semicolons. The user of each email is taken from
+// the first row with it.
+func parseImportCSV(body io.Reader) (importFile, error) {
+	rd := csv.NewReader(body)
+	rd.FieldsPerRecord = -1
+	header, err := rd.Read()
+	if err != nil {
+		return importFile{}, fmt.Errorf("csv header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, h := range header {
+		columns[strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))] = i
+	}
+	if _, ok := columns["email"]; !ok {
+		return importFile{}, errors.New("csv header: email column is required")
+	}
+
+	var file importFile
+	seen := map[string]bool{}
+	for {
+		record, err := rd.Read()
+		if errors.Is(err, io.EOF) {
+			return file, nil
+		}
+		if err != nil {
+			return importFile{}, err
+		}
+		line, _ := rd.FieldPos(0)
+		get := func(col string) string {
+			if i, ok := columns[col]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		parseTime := func(col string, errs *validationErrors) time.Time {
+			v := get(col)
+			if v == "" {
+				return time.Time{}
+			}
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				errs.add(col, "must be an RFC 3339 time")
+			}
+			return t
+		}
+
+		email := strings.ToLower(get("email"))
+		if !seen[email] {
+			seen[email] = true
+			u := importUser{Email: email, Name: get("name"), OrgID: get("orgId"), Locale: get("locale"), row: line}
+			u.CreatedAt = parseTime("userCreatedAt", &u.errs)
+			file.Users = append(file.Users, u)
+		}
+		if get("title") == "" {
+			continue
+		}
+		c := importComplaint{Email: email, Title: get("title"), Summary: get("summary"), Status: get("status"),
+			Category: get("category"), Priority: get("priority"), AssignedTo: get("assignedTo"), row: line}
+		c.CreatedAt = parseTime("createdAt", &c.errs)
+		c.UpdatedAt = parseTime("updatedAt", &c.errs)
+		if resolvedAt := parseTime("resolvedAt", &c.errs); !resolvedAt.IsZero() {
+			c.ResolvedAt = &resolvedAt
+		}
+		if v := get("rating"); v != "" {
+			if c.Rating, err = strconv.Atoi(v); err != nil {
+				c.errs.add("rating", "must be a whole number")
+			}
+		}
+		if v := get("tags"); v != "" {
+			c.Tags = strings.Split(v, ";")
+		}
+		file.Complaints = append(file.Complaints, c)
+	}
+}
+
+// runImport validates every row and, unless dryRun, writes the valid ones.
+func runImport(r *http.Request, file importFile, dryRun bool) (importResult, error) {
+	res := importResult{DryRun: dryRun, Rows: []importRowResult{}}
+	ctx := dbContext(r)
+	now := time.Now().UTC()
+	callerOrgID, orgBound := callerOrg(r)
+
+	// Existing accounts, by email.
+	var emails []string
+	for i := range file.Users {
+		file.Users[i].Email = strings.ToLower(strings.TrimSpace(file.Users[i].Email))
+		emails = append(emails, file.Users[i].Email)
+	}
+	for i := range file.Complaints {
+		file.Complaints[i].Email = strings.ToLower(strings.TrimSpace(file.Complaints[i].Email))
+	}
+	existing := map[string]models.User{}
+	if len(emails) > 0 {
+		cursor, err := documents.Collection("users").Find(store.Unscoped(ctx), bson.M{"email": bson.M{"$in": emails}})
+		if err != nil {
+			return res, err
+		}
+		var found []models.User
+		if err := cursor.All(ctx, &found); err != nil {
+			return res, err
+		}
+		for _, u := range found {
+			existing[u.Email] = u
+		}
+	}
+
+	// reporters are the users complaints can be filed under: existing
+	// accounts, and the file's valid new users.
+	reporters := map[string]models.User{}
+	orgs := map[string]error{}
+	for _, in := range file.Users {
+		row := importRowResult{Kind: "user", Row: in.row, Email: in.Email, Errors: in.errs}
+		if _, dup := reporters[in.Email]; dup {
+			row.Errors.add("email", "appears more than once in the file")
+		} else if u, ok := existing[in.Email]; ok && orgBound && u.OrgID != callerOrgID {
+			row.Errors.add("email", "belongs to a user of another organization")
+		} else if ok {
+			reporters[in.Email] = u
+			row.ID, row.Outcome = u.ID.Hex(), "existing"
+			res.add(row)
+			continue
+		}
+		user := models.User{Name: strings.TrimSpace(in.Name), Email: in.Email, OrgID: strings.TrimSpace(in.OrgID)}
+		row.Errors = append(row.Errors, validateRegistration(&user)...)
+		switch {
+		case user.OrgID == "":
+			user.OrgID = callerOrgID
+		case orgBound && user.OrgID != callerOrgID:
+			row.Errors.add("orgId", "must be your organization")
+		default:
+			if _, checked := orgs[user.OrgID]; !checked {
+				_, orgs[user.OrgID] = findOrg(ctx, user.OrgID)
+			}
+			if err := orgs[user.OrgID]; errors.Is(err, mongo.ErrNoDocuments) {
+				row.Errors.add("orgId", "is not a known organization")
+			} else if err != nil {
+				return res, err
+			}
+		}
+		if in.CreatedAt.After(now) {
+			row.Errors.add("createdAt", "must not be in the future")
+		}
+		if len(row.Errors) > 0 {
+			row.Outcome = "failed"
+			res.add(row)
+			continue
+		}
+
+		user.ID = primitive.NewObjectID()
+		user.Role = roleUser
+		user.Verified = true
+		user.Locale = in.Locale
+		if user.Locale == "" {
+			user.Locale = "en"
+		}
+		user.Complaints = []primitive.ObjectID{}
+		user.CreatedAt = in.CreatedAt.UTC()
+		row.ID, row.Outcome = user.ID.Hex(), "valid"
+		if !dryRun {
+			code, err := generateSecretCode()
+			if err != nil {
+				return res, err
+			}
+			user.SecretCodeHash = hashToken(code)
+			err = userStore.CreateUser(ctx, user)
+			if errors.Is(err, store.ErrDuplicate) {
+				row.ID, row.Outcome = "", "failed"
+				row.Errors.add("email", "was registered while importing")
+				res.add(row)
+				continue
+			}
+			if err != nil {
+				return res, err
+			}
+			recordAudit(r, auditImportUser, auditTargetUser, user.ID, nil, user)
+			row.Outcome = "created"
+		}
+		reporters[user.Email] = user
+		res.add(row)
+	}
+
+	for _, in := range file.Complaints {
+		row := importRowResult{Kind: "complaint", Row: in.row, Email: in.Email}
+		complaint, err := importedComplaint(ctx, in, reporters, now, actorName(r), &row.Errors)
+		if err != nil {
+			return res, err
+		}
+		if len(row.Errors) > 0 {
+			row.Outcome = "failed"
+			res.add(row)
+			continue
+		}
+
+		// An earlier run of the same import already filed it.
+		var prior models.Complaint
+		err = documents.Collection("complaints").FindOne(store.Unscoped(ctx), bson.M{"userId": complaint.UserID,
+			"contentHash": complaint.ContentHash, "createdAt": complaint.CreatedAt}).Decode(&prior)
+		if err == nil {
+			row.ID, row.Outcome = prior.ID.Hex(), "existing"
+			res.add(row)
+			continue
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return res, err
+		}
+
+		row.ID, row.Outcome = complaint.ID.Hex(), "valid"
+		if !dryRun {
+			if complaint.RefNumber, err = complaintStore.NextSequence(ctx, complaintSequence); err != nil {
+				return res, err
+			}
+			if _, err := complaintStore.CreateComplaint(ctx, complaint); err != nil {
+				return res, err
+			}
+			recordAudit(r, auditImportComplaint, auditTargetComplaint, complaint.ID, nil, complaint)
+			row.Outcome = "created"
+		}
+		res.add(row)
+	}
+	return res, nil
+}
+
+// importedComplaint builds the complaint for a row, recording what is wrong
+// with it in errs. Its history is a single entry moving it to its status
+// when it was last updated, as the old system's history is not imported.
+func importedComplaint(ctx context.Context, in importComplaint, reporters map[string]models.User, now time.Time, actor string, errs *validationErrors) (models.Complaint, error) {
+	complaint := models.Complaint{
+		ID:         primitive.NewObjectID(),
+		Title:      strings.TrimSpace(in.Title),
+		Summary:    in.Summary,
+		Rating:     in.Rating,
+		Category:   in.Category,
+		Priority:   in.Priority,
+		AssignedTo: strings.TrimSpace(in.AssignedTo),
+		Status:     in.Status,
+		CreatedAt:  in.CreatedAt.UTC().Truncate(time.Millisecond),
+		UpdatedAt:  in.UpdatedAt.UTC().Truncate(time.Millisecond),
+	}
+	*errs = append(*errs, in.errs...)
+	user, ok := reporters[in.Email]
+	if !ok {
+		errs.add("email", "is not an existing user or a valid user of the file")
+	}
+	complaint.UserID, complaint.OrgID = user.ID, user.OrgID
+
+	*errs = append(*errs, validateComplaint(&complaint)...)
+	if _, err := lookupCategory(ctx, complaint.Category); errors.Is(err, errUnknownCategory) {
+		errs.add("category", err.Error())
+	} else if err != nil {
+		return complaint, err
+	}
+	if complaint.Tags, ok = normalizeTags(in.Tags); !ok {
+		errs.add("tags", "contains a tag that is too long")
+	}
+	if complaint.Status == "" {
+		complaint.Status = models.StatusOpen
+	}
+	if !slices.Contains(importStatuses, complaint.Status) {
+		errs.add("status", "must be one of "+strings.Join(importStatuses, ", "))
+	}
+
+	if complaint.CreatedAt.IsZero() {
+		complaint.CreatedAt = now.Truncate(time.Millisecond)
+	}
+	if complaint.CreatedAt.After(now) {
+		errs.add("createdAt", "must not be in the future")
+	}
+	if complaint.UpdatedAt.IsZero() {
+		complaint.UpdatedAt = complaint.CreatedAt
+	}
+	if complaint.UpdatedAt.Before(complaint.CreatedAt) {
+		errs.add("updatedAt", "must not be before createdAt")
+	}
+	resolved := complaint.Status == models.StatusResolved || complaint.Status == models.StatusClosed
+	if in.ResolvedAt != nil {
+		resolvedAt := in.ResolvedAt.UTC().Truncate(time.Millisecond)
+		if !resolved {
+			errs.add("resolvedAt", "is only allowed on resolved or closed complaints")
+		} else if resolvedAt.Before(complaint.CreatedAt) || resolvedAt.After(complaint.UpdatedAt) {
+			errs.add("resolvedAt", "must be between createdAt and updatedAt")
+		}
+		complaint.ResolvedAt = &resolvedAt
+	} else if resolved {
+		resolvedAt := complaint.UpdatedAt
+		complaint.ResolvedAt = &resolvedAt
+	}
+
+	complaint.Resolved = resolved
+	if resolved {
+		complaint.ResolvedBy = actor
+	}
+	if complaint.Status != models.StatusOpen {
+		complaint.History = []models.StatusChange{{From: models.StatusOpen, To: complaint.Status, ChangedBy: actor, ChangedAt: complaint.UpdatedAt}}
+	}
+	if complaint.AssignedTo != "" {
+		complaint.Assignments = []models.Assignment{{To: complaint.AssignedTo, By: actor, At: complaint.CreatedAt}}
+	}
+	complaint.DueAt = dueDate(complaint.CreatedAt, complaint.Priority)
+	complaint.ContentHash = contentHash(complaint)
+	return complaint, nil
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+// job is periodic work shared by all instances. Its run state lives in the
+// jobs collection: an instance runs it only after claiming that document,
+// and the next run is due an interval after the last one started, so the
+// job runs once per interval however many instances are up. Work that each
+// instance must do for itself, such as refreshing its own metrics, is not a
+// job.
+type job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+var (
+	jobsMu     sync.Mutex
+	jobs       []job
+	instanceID = newInstanceID()
+)
+
+func newInstanceID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), primitive.NewObjectID().Hex()[18:])
+}
+
+// registerJob adds a job for startJobs to run. A non-positive interval
+// disables it.
+func registerJob(name string, interval time.Duration, run func(ctx context.Context) error) {
+	if interval <= 0 {
+		log.Printf("job %s: disabled", name)
+		return
+	}
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	jobs = append(jobs, job{Name: name, Interval: interval, Run: run})
+}
+
+func startJobs() {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for _, j := range jobs {
+		go j.loop()
+	}
+}
+
+// loop checks whether the job is due every JOB_POLL_INTERVAL, or every
+// interval if that is shorter.
+func (j job) loop() {
+	ctx, cancel := backgroundContext()
+	_, err := documents.Collection("jobs").UpdateOne(ctx,
+		bson.M{"_id": j.Name},
+		bson.M{
+			"$set":         bson.M{"interval": j.Interval.String()},
+			"$setOnInsert": bson.M{"nextRunAt": time.Now().UTC()},
+		},
+		options.Update().SetUpsert(true))
+	cancel()
+	if err != nil {
+		log.Printf("job %s: register: %v", j.Name, err)
+	}
+
+	poll := envDuration("JOB_POLL_INTERVAL", time.Minute)
+	if j.Interval < poll {
+		poll = j.Interval
+	}
+	for range time.Tick(poll) {
+		j.runIfDue()
+	}
+}
+
+// runIfDue claims the job if it is due and not held by another instance,
+// runs it and records the outcome. A claim lapses after JOB_LOCK_TIMEOUT so
+// a crashed instance does not block the job forever; the run's context ends
+// at the same time so it cannot overlap the next holder.
+func (j job) runIfDue() {
+	started := time.Now().UTC()
+	timeout := envDuration("JOB_LOCK_TIMEOUT", 10*time.Minute)
+	claimCtx, cancelClaim := backgroundContext()
+	defer cancelClaim()
+	err := documents.Collection("jobs").FindOneAndUpdate(claimCtx,
+		bson.M{
+			"_id":       j.Name,
+			"nextRunAt": bson.M{"$lte": started},
+			"$or": bson.A{
+				bson.M{"lockedUntil": bson.M{"$exists": false}},
+				bson.M{"lockedUntil": bson.M{"$lt": started}},
+			},
+		},
+		bson.M{"$set": bson.M{"lockedBy": instanceID, "lockedUntil": started.Add(timeout), "lastStartedAt": started}},
+	).Err()
+	if err == mongo.ErrNoDocuments {
+		return
+	}
+	if err != nil {
+		log.Printf("job %s: claim: %v", j.Name, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	runErr := j.Run(ctx)
+	cancel()
+	finished := time.Now().UTC()
+
+	set := bson.M{
+		"nextRunAt":           started.Add(j.Interval),
+		"lastFinishedAt":      finished,
+		"lastDurationSeconds": finished.Sub(started).Seconds(),
+		"lastError":           "",
+	}
+	inc := bson.M{"runs": 1}
+	if runErr != nil {
+		log.Printf("job %s: %v", j.Name, runErr)
+		set["lastError"] = runErr.Error()
+		inc["failures"] = 1
+	}
+	update := bson.M{"$set": set, "$unset": bson.M{"lockedBy": "", "lockedUntil": ""}, "$inc": inc}
+	releaseCtx, cancelRelease := backgroundContext()
+	defer cancelRelease()
+	if _, err := documents.Collection("jobs").UpdateOne(releaseCtx, bson.M{"_id": j.Name, "lockedBy": instanceID}, update); err != nil {
+		log.Printf("job %s: release: %v", j.Name, err)
+	}
+}
+
+// jobsHandler reports the run state of every job, including jobs this
+// instance has disabled but others still run.
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cursor, err := documents.Collection("jobs").Find(dbContext(r), bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	states := []models.Job{}
+	if err := cursor.All(dbContext(r), &states); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(states)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+// masterComplaint designates the complaint that new submissions with the same
+// content hash are linked to. Documents are keyed by the hash.
+type masterComplaint struct {
+	ContentHash  string             `bson:"_id" json:"contentHash"`
+	ComplaintID  primitive.ObjectID `bson:"complaintId" json:"complaintId"`
+	DesignatedBy string             `bson:"designatedBy" json:"designatedBy"`
+	DesignatedAt time.Time          `bson:"designatedAt" json:"designatedAt"`
+}
+
+// masterComplaintHandler designates (POST) or clears (DELETE) the master
+// complaint for the given complaint's content-hash cluster.
+func masterComplaintHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	staff, ok := staffIdentity(r)
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("complaintId"))
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+	var complaint models.Complaint
+	err = orgCollection("complaints").FindOne(dbContext(r), bson.M{"_id": oid}).Decode(&complaint)
+	if err != nil {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+	if complaint.ContentHash == "" {
+		http.Error(w, "Complaint has no content hash", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		_, err := documents.Collection("masters").DeleteOne(dbContext(r),
+			bson.M{"_id": complaint.ContentHash, "complaintId": oid})
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if complaint.Resolved {
+		http.Error(w, "Resolved complaints cannot be masters", http.StatusConflict)
+		return
+	}
+	master := masterComplaint{
+		ContentHash:  complaint.ContentHash,
+		ComplaintID:  oid,
+		DesignatedBy: staff.Name,
+		DesignatedAt: time.Now().UTC(),
+	}
+	_, err = documents.Collection("masters").ReplaceOne(dbContext(r),
+		bson.M{"_id": master.ContentHash}, master, options.Replace().SetUpsert(true))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(master)
+}
+
+// findMaster returns the unresolved master complaint for a content hash when
+// DUPLICATE_AUTO_LINK is enabled, or nil when there is none.
+func findMaster(ctx context.Context, hash string) (*primitive.ObjectID, error) {
+	if !envBool("DUPLICATE_AUTO_LINK", false) || hash == "" {
+		return nil, nil
+	}
+	var master masterComplaint
+	err := documents.Collection("masters").FindOne(ctx, bson.M{"_id": hash}).Decode(&master)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = orgCollection("complaints").FindOne(ctx,
+		bson.M{"_id": master.ComplaintID, "resolved": false},
+		options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &master.ComplaintID, nil
+}
+
+func incrementLinkedCount(masterID primitive.ObjectID) {
+	ctx, cancel := backgroundContext()
+	defer cancel()
+	_, err := orgCollection("complaints").UpdateOne(ctx,
+		bson.M{"_id": masterID}, bson.M{"$inc": bson.M{"linkedCount": 1}})
+	if err != nil {
+		log.Printf("increment linked count of master %s: %v", masterID.Hex(), err)
+	}
+}
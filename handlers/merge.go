@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+	"complain/store"
+)
+
+type mergeRequest struct {
+	Duplicates []string `json:"duplicates"`
+}
+
+type mergeResult struct {
+	Primary models.Complaint `json:"primary"`
+	Results []bulkItemResult `json:"results"`
+}
+
+// mergeComplaintHandler merges duplicates into the primary complaint
+// (complaintId). Each duplicate's comments and attachments move to the
+// primary and the duplicate is closed pointing at it. Duplicates are merged
+// one at a time and a duplicate changed concurrently fails on its own, so
+// the outcome is reported for each.
+func mergeComplaintHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	primaryID, err := primitive.ObjectIDFromHex(r.URL.Query().Get("complaintId"))
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+	var req mergeRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Duplicates) == 0 {
+		writeFieldError(w, "duplicates", "is required")
+		return
+	}
+	if len(req.Duplicates) > envInt("BULK_MAX_IDS", 1000) {
+		writeFieldError(w, "duplicates", "has too many entries")
+		return
+	}
+
+	var primary models.Complaint
+	err = orgCollection("complaints").FindOne(dbContext(r),
+		bson.M{"_id": primaryID, "deletedAt": store.NotDeleted()}).Decode(&primary)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if primary.MergedInto != nil {
+		http.Error(w, "Complaint was itself merged into another", http.StatusConflict)
+		return
+	}
+
+	results := make([]bulkItemResult, len(req.Duplicates))
+	for i, id := range req.Duplicates {
+		results[i].ID = id
+		oid, err := primitive.ObjectIDFromHex(id)
+		switch {
+		case err != nil:
+			results[i].Error = "invalid ID"
+		case oid == primaryID:
+			results[i].Error = "cannot merge a complaint into itself"
+		default:
+			msg, err := mergeDuplicate(r, primary, oid)
+			if err != nil {
+				serverError(w, err)
+				return
+			}
+			results[i].OK, results[i].Error = msg == "", msg
+		}
+	}
+
+	err = orgCollection("complaints").FindOne(dbContext(r), bson.M{"_id": primaryID}).Decode(&primary)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(mergeResult{Primary: primary, Results: results})
+}
+
+// mergeDuplicate merges one duplicate into primary, returning why it could
+// not when the duplicate is the problem.
+func mergeDuplicate(r *http.Request, primary models.Complaint, oid primitive.ObjectID) (string, error) {
+	var dup models.Complaint
+	err := orgCollection("complaints").FindOne(dbContext(r),
+		bson.M{"_id": oid, "deletedAt": store.NotDeleted()}).Decode(&dup)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "not found", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if dup.MergedInto != nil {
+		return "already merged", nil
+	}
+	if dup.OrgID != primary.OrgID {
+		return "belongs to another organization", nil
+	}
+
+	// Closing the duplicate takes its attachments off it in the same write,
+	// so they are moved exactly once.
+	change := newStatusChange(r, dup.CurrentStatus(), models.StatusClosed)
+	change.Reason = "Merged into " + primary.ID.Hex()
+	if primary.RefNumber > 0 {
+		change.Reason = fmt.Sprintf("Merged into #%d", primary.RefNumber)
+	}
+	update := transitionUpdate(change)
+	set := update["$set"].(bson.M)
+	set["mergedInto"] = primary.ID
+	set["commentCount"] = 0
+	if !dup.Resolved {
+		set["resolvedAt"], set["resolvedBy"] = change.ChangedAt, change.ChangedBy
+		set["resolutionNote"] = change.Reason
+	}
+	update["$unset"] = bson.M{"attachments": ""}
+	filter := bson.M{"_id": oid, "mergedInto": bson.M{"$exists": false}}
+	withVersion(filter, update, dup.Version)
+	var after models.Complaint
+	err = withOutbox(dbContext(r), func(ctx context.Context) ([]outboxEvent, error) {
+		before := dup
+		err := orgCollection("complaints").FindOneAndUpdate(ctx, filter, update).Decode(&before)
+		if err != nil {
+			return nil, err
+		}
+
+		moved, err := orgCollection("comments").UpdateMany(ctx, bson.M{"complaintId": oid},
+			bson.M{"$set": bson.M{"complaintId": primary.ID, "mergedFrom": oid}})
+		if err != nil {
+			return nil, err
+		}
+		primaryUpdate := bson.M{
+			"$addToSet": bson.M{"mergedFrom": oid},
+			"$inc":      bson.M{"commentCount": moved.ModifiedCount},
+		}
+		if len(before.Attachments) > 0 {
+			primaryUpdate["$push"] = bson.M{"attachments": bson.M{"$each": before.Attachments}}
+		}
+		var merged models.Complaint
+		err = orgCollection("complaints").FindOneAndUpdate(ctx, bson.M{"_id": primary.ID}, primaryUpdate,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&merged)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := orgCollection("complaints").FindOne(ctx, bson.M{"_id": oid}).Decode(&after); err != nil {
+			return nil, err
+		}
+		dup = before
+		return []outboxEvent{outboxEntry(eventComplaintStatus, after), outboxEntry(eventComplaintEdited, merged)}, nil
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "was modified by another request", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	recordAudit(r, auditMerge, auditTargetComplaint, oid, dup, after)
+	go publishEvent(eventComplaintStatus, after)
+	return "", nil
+}
+
+// relatedComplaintsHandler links (POST) or unlinks (DELETE) two related
+// complaints, complaintId and relatedId, without merging them. Links go
+// both ways.
+func relatedComplaintsHandler(w http.ResponseWriter, r *http.Request) {
+	op := map[string]string{http.MethodPost: "$addToSet", http.MethodDelete: "$pull"}[r.Method]
+	if op == "" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var errs validationErrors
+	oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("complaintId"))
+	if err != nil {
+		errs.add("complaintId", "must be a valid ID")
+	}
+	relatedID, err := primitive.ObjectIDFromHex(r.URL.Query().Get("relatedId"))
+	if err != nil {
+		errs.add("relatedId", "must be a valid ID")
+	} else if relatedID == oid {
+		errs.add("relatedId", "must be another complaint")
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	ids := []primitive.ObjectID{oid, relatedID}
+	n, err := orgCollection("complaints").CountDocuments(dbContext(r),
+		bson.M{"_id": bson.M{"$in": ids}, "deletedAt": store.NotDeleted()})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if n != 2 {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+
+	var before, complaint models.Complaint
+	err = orgCollection("complaints").FindOneAndUpdate(dbContext(r), bson.M{"_id": oid},
+		bson.M{op: bson.M{"related": relatedID}}).Decode(&before)
+	if err == nil {
+		_, err = orgCollection("complaints").UpdateOne(dbContext(r), bson.M{"_id": relatedID},
+			bson.M{op: bson.M{"related": oid}})
+	}
+	if err == nil {
+		err = orgCollection("complaints").FindOne(dbContext(r), bson.M{"_id": oid}).Decode(&complaint)
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if slices.Contains(before.Related, relatedID) != slices.Contains(complaint.Related, relatedID) {
+		action := auditLink
+		if op == "$pull" {
+			action = auditUnlink
+		}
+		recordAudit(r, action, auditTargetComplaint, oid, before, complaint)
+	}
+	json.NewEncoder(w).Encode(complaint)
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+
+	"complain/store"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by route, method and status code.",
+	}, []string{"path", "method", "status"})
+
+	httpRequestErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_request_errors_total",
+		Help: "HTTP requests answered with a 5xx status, by route and method.",
+	}, []string{"path", "method"})
+
+	// Created by initMetrics, since the buckets are configurable and settings
+	// are not loaded yet when package variables are initialized.
+	httpRequestDuration *prometheus.HistogramVec
+
+	// httpRequestLatency reports p50/p90/p99 directly, for dashboards that
+	// cannot run histogram_quantile over the buckets above.
+	httpRequestLatency = promauto.NewSummaryVec(prometheus.SummaryOpts{
+		Name:       "http_request_latency_seconds",
+		Help:       "HTTP request latency percentiles by route.",
+		Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		MaxAge:     10 * time.Minute,
+	}, []string{"path"})
+
+	complaintsByStatus = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "complaints",
+		Help: "Stored complaints by resolved status.",
+	}, []string{"resolved"})
+
+	// Per-hour figures come from rate() or increase() over these counters.
+	complaintsSubmittedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "complaints_submitted_total",
+		Help: "Complaints submitted.",
+	})
+	complaintsResolvedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "complaints_resolved_total",
+		Help: "Complaints resolved, by any route including auto-resolution.",
+	})
+
+	mongoCommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "mongo_command_duration_seconds",
+		Help: "MongoDB command latency by command name and outcome.",
+	}, []string{"command", "outcome"})
+)
+
+func initMetrics() {
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by route and method.",
+		Buckets: latencyBuckets(),
+	}, []string{"path", "method"})
+}
+
+// mongoMonitor times every command the driver sends. Commands are labelled
+// by name only (find, insert, aggregate, ...), which keeps the series count
+// fixed however many collections there are.
+func mongoMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			mongoCommandDuration.WithLabelValues(e.CommandName, "ok").Observe(e.Duration.Seconds())
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			mongoCommandDuration.WithLabelValues(e.CommandName, "error").Observe(e.Duration.Seconds())
+		},
+	}
+}
+
+// latencyBuckets reads METRICS_LATENCY_BUCKETS, a comma-separated list of
+// upper bounds in seconds, defaulting to the Prometheus defaults.
+func latencyBuckets() []float64 {
+	v := envString("METRICS_LATENCY_BUCKETS", "")
+	if v == "" {
+		return prometheus.DefBuckets
+	}
+	var buckets []float64
+	for _, field := range strings.Split(v, ",") {
+		b, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			log.Printf("invalid METRICS_LATENCY_BUCKETS=%q, using defaults", v)
+			return prometheus.DefBuckets
+		}
+		buckets = append(buckets, b)
+	}
+	sort.Float64s(buckets)
+	return buckets
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades through the recorder.
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	s.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// withMetrics records request counts and latencies. Requests are labelled by
+// the registered mux pattern rather than the raw path to keep cardinality
+// bounded.
+func withMetrics(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := routePattern(r)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		mux.ServeHTTP(rec, r)
+
+		elapsed := time.Since(start).Seconds()
+		httpRequestDuration.WithLabelValues(path, r.Method).Observe(elapsed)
+		httpRequestLatency.WithLabelValues(path).Observe(elapsed)
+		httpRequestsTotal.WithLabelValues(path, r.Method, strconv.Itoa(rec.status)).Inc()
+		if rec.status >= http.StatusInternalServerError {
+			httpRequestErrorsTotal.WithLabelValues(path, r.Method).Inc()
+		}
+	})
+}
+
+func refreshComplaintGauges(every time.Duration) {
+	for {
+		refreshComplaintGauge()
+		time.Sleep(every)
+	}
+}
+
+func refreshComplaintGauge() {
+	ctx, cancel := backgroundContext()
+	defer cancel()
+	cursor, err := listingCollection("complaints").Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"deletedAt": store.NotDeleted()}},
+		bson.M{"$group": bson.M{"_id": "$resolved", "count": bson.M{"$sum": 1}}},
+	})
+	if err != nil {
+		log.Printf("refresh complaint gauges: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+	counts := map[bool]float64{false: 0, true: 0}
+	for cursor.Next(ctx) {
+		var group struct {
+			Resolved bool `bson:"_id"`
+			Count    int  `bson:"count"`
+		}
+		if err := cursor.Decode(&group); err == nil {
+			counts[group.Resolved] += float64(group.Count)
+		}
+	}
+	for resolved, n := range counts {
+		complaintsByStatus.WithLabelValues(strconv.FormatBool(resolved)).Set(n)
+	}
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+	"complain/store"
+)
+
+// ContentScreener inspects a new complaint and returns the reasons it should
+// be held for moderation, or none to let it through.
+type ContentScreener interface {
+	Screen(ctx context.Context, c models.Complaint) ([]string, error)
+}
+
+// screeners run in order on every submission; initScreening sets them up
+// from SCREEN_KEYWORDS and SCREEN_CLASSIFIER_URL.
+var screeners []ContentScreener
+
+// keywordScreener flags complaints containing any of a list of words or
+// phrases, ignoring case.
+type keywordScreener struct {
+	patterns map[string]*regexp.Regexp
+}
+
+func newKeywordScreener(list string) *keywordScreener {
+	s := &keywordScreener{patterns: map[string]*regexp.Regexp{}}
+	for _, kw := range strings.Split(list, ",") {
+		if kw = strings.ToLower(strings.TrimSpace(kw)); kw != "" {
+			s.patterns[kw] = regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(kw) + `\b`)
+		}
+	}
+	return s
+}
+
+func (s *keywordScreener) Screen(ctx context.Context, c models.Complaint) ([]string, error) {
+	var reasons []string
+	for kw, re := range s.patterns {
+		if re.MatchString(c.Title) || re.MatchString(c.Summary) {
+			reasons = append(reasons, fmt.Sprintf("contains %q", kw))
+		}
+	}
+	return reasons, nil
+}
+
+// httpScreener posts {"title", "summary", "category"} to an external
+// classifier and expects {"flagged": bool, "reasons": [...]} back.
+type httpScreener struct {
+	url    string
+	client *http.Client
+}
+
+func (s httpScreener) Screen(ctx context.Context, c models.Complaint) ([]string, error) {
+	body, err := json.Marshal(map[string]string{"title": c.Title, "summary": c.Summary, "category": c.Category})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("classifier: %s", resp.Status)
+	}
+	var verdict struct {
+		Flagged bool     `json:"flagged"`
+		Reasons []string `json:"reasons"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&verdict); err != nil {
+		return nil, err
+	}
+	if !verdict.Flagged {
+		return nil, nil
+	}
+	if len(verdict.Reasons) == 0 {
+		return []string{"flagged by classifier"}, nil
+	}
+	return verdict.Reasons, nil
+}
+
+func initScreening() {
+	if list := envString("SCREEN_KEYWORDS", ""); list != "" {
+		screeners = append(screeners, newKeywordScreener(list))
+	}
+	if u := envString("SCREEN_CLASSIFIER_URL", ""); u != "" {
+		screeners = append(screeners, httpScreener{url: u, client: &http.Client{Timeout: envDuration("SCREEN_CLASSIFIER_TIMEOUT", 5*time.Second)}})
+	}
+}
+
+// screenComplaint collects every screener's reasons. A screener that fails
+// is logged and skipped so an unavailable classifier does not block
+// submissions, unless SCREEN_FAIL_CLOSED holds them for review instead.
+func screenComplaint(ctx context.Context, c models.Complaint) []string {
+	var reasons []string
+	for _, s := range screeners {
+		found, err := s.Screen(ctx, c)
+		if err != nil {
+			log.Printf("screen complaint: %v", err)
+			if envBool("SCREEN_FAIL_CLOSED", false) {
+				reasons = append(reasons, "screening unavailable")
+			}
+			continue
+		}
+		reasons = append(reasons, found...)
+	}
+	return reasons
+}
+
+// moderationQueueHandler lists complaints awaiting review, oldest first.
+func moderationQueueHandler(w http.ResponseWriter, r *http.Request) {
+	var errs validationErrors
+	page, pageSize := pageParams(r, 20, 100, &errs)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	filter := bson.M{"flagged": true, "deletedAt": store.NotDeleted()}
+	total, err := orgCollection("complaints").CountDocuments(dbContext(r), filter)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	cursor, err := orgCollection("complaints").Find(dbContext(r), filter, options.Find().
+		SetSort(bson.D{{Key: "moderation.flaggedAt", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64((page-1)*pageSize)).
+		SetLimit(int64(pageSize)))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	complaints := []models.Complaint{}
+	if err := cursor.All(dbContext(r), &complaints); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(pagedComplaints{Page: page, PageSize: pageSize, Total: total, Complaints: complaints})
+}
+
+// approveFlaggedHandler releases a flagged complaint into the normal
+// workflow: it is auto-assigned and announced as if it had just arrived.
+func approveFlaggedHandler(w http.ResponseWriter, r *http.Request) {
+	moderate(w, r, models.ModerationApproved)
+}
+
+// rejectFlaggedHandler soft-deletes a flagged complaint, so an admin can
+// still restore it.
+func rejectFlaggedHandler(w http.ResponseWriter, r *http.Request) {
+	moderate(w, r, models.ModerationRejected)
+}
+
+func moderate(w http.ResponseWriter, r *http.Request, decision string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("complaintId"))
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+
+	now := time.Now().UTC()
+	set := bson.M{"moderation.status": decision, "moderation.reviewedBy": actorName(r), "moderation.reviewedAt": now}
+	if decision == models.ModerationRejected {
+		set["deletedAt"], set["deletedBy"] = now, actorName(r)
+	}
+	var before models.Complaint
+	err = orgCollection("complaints").FindOneAndUpdate(dbContext(r),
+		bson.M{"_id": oid, "flagged": true, "deletedAt": store.NotDeleted()},
+		bson.M{"$set": set, "$unset": bson.M{"flagged": ""}, "$inc": bson.M{"version": 1}},
+	).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Flagged complaint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	after := before
+	after.Flagged = false
+	after.Version++
+	var moderation models.Moderation
+	if before.Moderation != nil {
+		moderation = *before.Moderation
+	}
+	moderation.Status, moderation.ReviewedBy, moderation.ReviewedAt = decision, actorName(r), &now
+	after.Moderation = &moderation
+	if decision == models.ModerationRejected {
+		after.DeletedAt, after.DeletedBy = &now, actorName(r)
+	} else {
+		releaseApproved(r, &after)
+	}
+	recordAudit(r, auditModerate, auditTargetComplaint, oid, before, after)
+
+	json.NewEncoder(w).Encode(after)
+}
+
+// releaseApproved does what submission skipped for a held complaint. A
+// failed auto-assignment leaves it unassigned for a manual pick rather than
+// undoing the approval. The created event goes to the outbox with the
+// assignment, as the complaint is announced with its assignee.
+func releaseApproved(r *http.Request, c *models.Complaint) {
+	var agent string
+	if c.AssignedTo == "" {
+		category, err := lookupCategory(dbContext(r), c.Category)
+		if err != nil && !errors.Is(err, errUnknownCategory) {
+			requestLogger(r).Error("route approved complaint", "complaint_id", c.ID.Hex(), "error", err)
+		}
+		agent, err = nextAutoAssignee(dbContext(r), category)
+		if err != nil {
+			requestLogger(r).Error("route approved complaint", "complaint_id", c.ID.Hex(), "error", err)
+		}
+	}
+	released := *c
+	err := withOutbox(dbContext(r), func(ctx context.Context) ([]outboxEvent, error) {
+		released = *c
+		if agent != "" {
+			_, err := orgCollection("complaints").UpdateOne(ctx,
+				bson.M{"_id": c.ID, "assignedTo": bson.M{"$in": bson.A{nil, ""}}},
+				bson.M{"$set": bson.M{"assignedTo": agent}, "$inc": bson.M{"version": 1}})
+			if err != nil {
+				return nil, err
+			}
+			released.AssignedTo = agent
+			released.Version++
+		}
+		return []outboxEvent{outboxEntry(eventComplaintCreated, released)}, nil
+	})
+	if err != nil {
+		requestLogger(r).Error("route approved complaint", "complaint_id", c.ID.Hex(), "error", err)
+	} else {
+		*c = released
+	}
+	go publishEvent(eventComplaintCreated, *c)
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// prefersXML reports whether an Accept header ranks application/xml (or
+// text/xml) above application/json. Without a header, or on a tie, JSON wins.
+func prefersXML(header string) bool {
+	if header == "" {
+		return false
+	}
+	quality := func(want string) float64 {
+		best, specificity := 0.0, -1
+		for _, part := range strings.Split(header, ",") {
+			mediaType, params, _ := strings.Cut(part, ";")
+			mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+			q := 1.0
+			if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+			// The most specific matching range decides, as in RFC 9110.
+			level := -1
+			switch {
+			case mediaType == want:
+				level = 2
+			case mediaType == strings.SplitN(want, "/", 2)[0]+"/*":
+				level = 1
+			case mediaType == "*/*":
+				level = 0
+			}
+			if level > specificity {
+				best, specificity = q, level
+			}
+		}
+		return best
+	}
+	xmlQ := max(quality("application/xml"), quality("text/xml"))
+	return xmlQ > 0 && xmlQ > quality("application/json")
+}
+
+// jsonToXML writes a JSON document as XML under a <response> element. Object
+// members become elements named after their keys, in order, and array
+// elements become <item>s. Keys that are not XML names, such as categories
+// used as map keys, become <entry key="..."> instead.
+func jsonToXML(dst io.Writer, body []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	enc := xml.NewEncoder(dst)
+	if _, err := io.WriteString(dst, xml.Header); err != nil {
+		return err
+	}
+	if err := writeXMLValue(enc, dec, xml.StartElement{Name: xml.Name{Local: "response"}}); err != nil {
+		return err
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(dst, "\n")
+	return err
+}
+
+func writeXMLValue(enc *xml.Encoder, dec *json.Decoder, start xml.StartElement) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	switch v := tok.(type) {
+	case json.Delim:
+		for dec.More() {
+			child := xml.StartElement{Name: xml.Name{Local: "item"}}
+			if v == '{' {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				child = xmlElement(keyTok.(string))
+			}
+			if err := writeXMLValue(enc, dec, child); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil { // closing delimiter
+			return err
+		}
+	case nil:
+		// null is an empty element.
+	default:
+		if err := enc.EncodeToken(xml.CharData(fmt.Sprint(v))); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+func xmlElement(key string) xml.StartElement {
+	if isXMLName(key) {
+		return xml.StartElement{Name: xml.Name{Local: key}}
+	}
+	return xml.StartElement{Name: xml.Name{Local: "entry"}, Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: key}}}
+}
+
+func isXMLName(s string) bool {
+	if s == "" || strings.HasPrefix(strings.ToLower(s), "xml") {
+		return false
+	}
+	for i, c := range s {
+		letter := c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+		if !letter && (i == 0 || c != '-' && c != '.' && (c < '0' || c > '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// xmlWriter holds back a response to rewrite it as XML once the handler is
+// done. Only JSON bodies are rewritten; anything else, and any response the
+// handler flushes, goes out untouched.
+type xmlWriter struct {
+	http.ResponseWriter
+	status      int
+	buf         bytes.Buffer
+	passthrough bool
+}
+
+func (x *xmlWriter) WriteHeader(code int) {
+	if x.passthrough {
+		x.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if x.status == 0 {
+		x.status = code
+	}
+}
+
+func (x *xmlWriter) Write(b []byte) (int, error) {
+	if x.passthrough {
+		return x.ResponseWriter.Write(b)
+	}
+	if x.status == 0 {
+		x.status = http.StatusOK
+	}
+	return x.buf.Write(b)
+}
+
+// release stops holding back and sends what was buffered as it is.
+func (x *xmlWriter) release() error {
+	x.passthrough = true
+	if x.status != 0 {
+		x.ResponseWriter.WriteHeader(x.status)
+	}
+	_, err := x.ResponseWriter.Write(x.buf.Bytes())
+	x.buf.Reset()
+	return err
+}
+
+func (x *xmlWriter) finish() error {
+	if x.passthrough {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(x.Header().Get("Content-Type"))
+	body := bytes.TrimSpace(x.buf.Bytes())
+	if mediaType != "application/json" && (mediaType != "" || len(body) == 0 || !json.Valid(body)) {
+		return x.release()
+	}
+	var out bytes.Buffer
+	if err := jsonToXML(&out, body); err != nil {
+		return x.release()
+	}
+	h := x.Header()
+	h.Set("Content-Type", "application/xml; charset=utf-8")
+	h.Del("Content-Length")
+	x.ResponseWriter.WriteHeader(x.status)
+	_, err := x.ResponseWriter.Write(out.Bytes())
+	return err
+}
+
+func (x *xmlWriter) Flush() {
+	if !x.passthrough && x.release() != nil {
+		return
+	}
+	if f, ok := x.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (x *xmlWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := x.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	x.passthrough = true
+	return h.Hijack()
+}
+
+func (x *xmlWriter) Unwrap() http.ResponseWriter {
+	return x.ResponseWriter
+}
+
+// withContentNegotiation serves the JSON responses of every endpoint as XML
+// to clients whose Accept header prefers it.
+func withContentNegotiation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		if r.Header.Get("Upgrade") != "" || !prefersXML(r.Header.Get("Accept")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		xw := &xmlWriter{ResponseWriter: w}
+		defer xw.finish()
+		next.ServeHTTP(xw, r)
+	})
+}
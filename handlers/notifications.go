@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+const notificationComment = "comment.added"
+
+type pagedNotifications struct {
+	Page          int                   `json:"page"`
+	PageSize      int                   `json:"pageSize"`
+	Total         int64                 `json:"total"`
+	Unread        int64                 `json:"unread"`
+	Notifications []models.Notification `json:"notifications"`
+}
+
+// notifyInApp leaves a notification for the complaint's reporter and
+// watchers, except whoever caused it. Notifications expire after
+// NOTIFICATION_TTL, read or not.
+func notifyInApp(kind string, c models.Complaint, message string, except primitive.ObjectID) {
+	recipients := append([]primitive.ObjectID{c.UserID}, c.Watchers...)
+	now := time.Now().UTC()
+	seen := map[primitive.ObjectID]bool{except: true, primitive.NilObjectID: true}
+	var docs []interface{}
+	for _, id := range recipients {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		docs = append(docs, models.Notification{
+			ID:          primitive.NewObjectID(),
+			UserID:      id,
+			Type:        kind,
+			ComplaintID: c.ID,
+			Message:     message,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(envDuration("NOTIFICATION_TTL", 90*24*time.Hour)),
+		})
+	}
+	if len(docs) == 0 {
+		return
+	}
+	ctx, cancel := backgroundContext()
+	defer cancel()
+	if _, err := documents.Collection("notifications").InsertMany(ctx, docs); err != nil {
+		log.Printf("%s notifications for complaint %s: %v", kind, c.ID.Hex(), err)
+	}
+}
+
+// eventNotification is the in-app message for a complaint event, or "" for
+// events users are not notified of.
+func eventNotification(event string, c models.Complaint) string {
+	switch event {
+	case eventComplaintResolved:
+		return fmt.Sprintf("Complaint %s %q was resolved", c.Reference(), c.Title)
+	case eventComplaintAssigned:
+		return fmt.Sprintf("Complaint %s %q is being handled", c.Reference(), c.Title)
+	}
+	return ""
+}
+
+// notificationsHandler pages through the caller's notifications, newest
+// first; unread=true leaves out those already read.
+func notificationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var errs validationErrors
+	page, pageSize := pageParams(r, 20, 100, &errs)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	userID, _ := currentUserID(r)
+	unread := bson.M{"userId": userID, "read": false}
+	filter := bson.M{"userId": userID}
+	if r.URL.Query().Get("unread") == "true" {
+		filter = unread
+	}
+	coll := documents.Collection("notifications")
+	resp := pagedNotifications{Page: page, PageSize: pageSize, Notifications: []models.Notification{}}
+	var err error
+	if resp.Total, err = coll.CountDocuments(dbContext(r), filter); err != nil {
+		serverError(w, err)
+		return
+	}
+	if resp.Unread, err = coll.CountDocuments(dbContext(r), unread); err != nil {
+		serverError(w, err)
+		return
+	}
+	cursor, err := coll.Find(dbContext(r), filter, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page-1)*pageSize)).
+		SetLimit(int64(pageSize)))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if err := cursor.All(dbContext(r), &resp.Notifications); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// readNotificationHandler marks one of the caller's notifications read.
+func readNotificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		writeFieldError(w, "id", "must be a valid ID")
+		return
+	}
+
+	userID, _ := currentUserID(r)
+	res, err := documents.Collection("notifications").UpdateOne(dbContext(r),
+		bson.M{"_id": oid, "userId": userID},
+		bson.M{"$set": bson.M{"read": true}})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if res.MatchedCount == 0 {
+		http.Error(w, "Notification not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readAllNotificationsHandler marks all of the caller's notifications read.
+func readAllNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := currentUserID(r)
+	_, err := documents.Collection("notifications").UpdateMany(dbContext(r),
+		bson.M{"userId": userID, "read": false},
+		bson.M{"$set": bson.M{"read": true}})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
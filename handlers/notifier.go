@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"complain/models"
+)
+
+// Notifier delivers a plain-text message to a single recipient.
+type Notifier interface {
+	Notify(to, subject, body string) error
+}
+
+type smtpNotifier struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func (n smtpNotifier) Notify(to, subject, body string) error {
+	msg := strings.Join([]string{
+		"From: " + n.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		body,
+	}, "\r\n")
+	return smtp.SendMail(n.addr, n.auth, n.from, []string{to}, []byte(msg))
+}
+
+type notification struct {
+	to, subject, body string
+}
+
+// queuedNotifier hands messages to background workers so request handlers
+// never wait on the mail server. Failed sends are retried with exponential
+// backoff and dropped, with a log line, after the last attempt.
+type queuedNotifier struct {
+	next        Notifier
+	queue       chan notification
+	maxAttempts int
+	retryDelay  time.Duration
+}
+
+func newQueuedNotifier(next Notifier, size, workers, maxAttempts int, retryDelay time.Duration) *queuedNotifier {
+	n := &queuedNotifier{
+		next:        next,
+		queue:       make(chan notification, size),
+		maxAttempts: maxAttempts,
+		retryDelay:  retryDelay,
+	}
+	for i := 0; i < workers; i++ {
+		go n.work()
+	}
+	return n
+}
+
+func (n *queuedNotifier) Notify(to, subject, body string) error {
+	select {
+	case n.queue <- notification{to, subject, body}:
+		return nil
+	default:
+		return errors.New("notification queue is full")
+	}
+}
+
+func (n *queuedNotifier) work() {
+	for msg := range n.queue {
+		delay := n.retryDelay
+		for attempt := 1; ; attempt++ {
+			err := n.next.Notify(msg.to, msg.subject, msg.body)
+			if err == nil {
+				break
+			}
+			if attempt >= n.maxAttempts {
+				log.Printf("give up notifying %s after %d attempts: %v", msg.to, attempt, err)
+				break
+			}
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
+
+// notifier is nil when SMTP_HOST is unset, which disables all email.
+var notifier Notifier
+
+var (
+	ackEnabled     bool
+	ackExpectedSLA time.Duration
+)
+
+func initNotifier() {
+	host := envString("SMTP_HOST", "")
+	if host != "" {
+		var auth smtp.Auth
+		if user := envString("SMTP_USERNAME", ""); user != "" {
+			auth = smtp.PlainAuth("", user, envString("SMTP_PASSWORD", ""), host)
+		}
+		mailer := smtpNotifier{
+			addr: fmt.Sprintf("%s:%d", host, envInt("SMTP_PORT", 587)),
+			from: envString("SMTP_FROM", "complaints@localhost"),
+			auth: auth,
+		}
+		notifier = newQueuedNotifier(mailer,
+			envInt("NOTIFY_QUEUE_SIZE", 1000),
+			envInt("NOTIFY_WORKERS", 2),
+			envInt("NOTIFY_MAX_ATTEMPTS", 5),
+			envDuration("NOTIFY_RETRY_DELAY", 5*time.Second))
+	}
+
+	ackEnabled = envBool("ACK_AUTO_REPLY", false)
+	ackExpectedSLA = envDuration("ACK_EXPECTED_SLA", 72*time.Hour)
+}
+
+// sendAcknowledgment emails the submitter a receipt for their complaint. It is
+// meant to run in its own goroutine; failures are logged and otherwise ignored.
+func sendAcknowledgment(user models.User, complaint models.Complaint) {
+	if !ackEnabled || !user.AcknowledgeEmails {
+		return
+	}
+	sendTemplated(user, complaint, templateAcknowledgment, "")
+}
+
+// notifyOwner notifies the user who filed the complaint.
+func notifyOwner(c models.Complaint, kind, note string) {
+	if notifier == nil && sms == nil {
+		return
+	}
+	ctx, cancel := backgroundContext()
+	defer cancel()
+	var user models.User
+	if err := orgCollection("users").FindOne(ctx, bson.M{"_id": c.UserID}).Decode(&user); err != nil {
+		return
+	}
+	sendTemplated(user, c, kind, note)
+}
+
+// sendTemplated renders a notification in the user's locale and sends it on
+// the channels the user chose. A text message carries only the subject line.
+func sendTemplated(user models.User, c models.Complaint, kind, note string) {
+	email := notifier != nil && user.Email != "" && wantsChannel(user, channelEmail)
+	text := sms != nil && smsKinds[kind] && user.Phone != "" && user.PhoneVerified && wantsChannel(user, channelSMS)
+	if !email && !text {
+		return
+	}
+	subject, body, err := renderNotification(user.Locale, kind, notificationData{
+		UserName:    user.Name,
+		ComplaintID: c.ID.Hex(),
+		Reference:   c.Reference(),
+		Title:       c.Title,
+		Status:      c.CurrentStatus(),
+		Priority:    c.Priority,
+		ExpectedSLA: ackExpectedSLA.String(),
+		Note:        note,
+	})
+	if err != nil {
+		log.Printf("%s notification for complaint %s: %v", kind, c.ID.Hex(), err)
+		return
+	}
+	if email {
+		if err := notifier.Notify(user.Email, subject, body); err != nil {
+			log.Printf("%s email for complaint %s: %v", kind, c.ID.Hex(), err)
+		}
+	}
+	if text {
+		if err := sms.Notify(user.Phone, "", subject); err != nil {
+			log.Printf("%s text for complaint %s: %v", kind, c.ID.Hex(), err)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"complain/models"
+	"complain/store"
+)
+
+// oidcProvider is an OpenID Connect identity provider users can log in
+// with. OIDC_PROVIDERS lists them by name; each reads
+// OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET and OIDC_<NAME>_ISSUER.
+// google and microsoft know their issuer; microsoft takes the Entra tenant
+// from OIDC_MICROSOFT_TENANT and defaults to any work or personal account.
+type oidcProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// TrustEmail accepts the email claim without email_verified, for
+	// providers such as single-tenant Entra ID that vouch for every address
+	// they issue but do not send the claim.
+	TrustEmail bool
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+	keysAt    time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcState is a login in progress, stored by the hash of the state
+// parameter until the provider redirects back.
+type oidcState struct {
+	Hash      string    `bson:"_id"`
+	Provider  string    `bson:"provider"`
+	Nonce     string    `bson:"nonce"`
+	Verifier  string    `bson:"verifier"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+type oidcClaims struct {
+	jwt.RegisteredClaims
+	Email         string      `json:"email"`
+	EmailVerified interface{} `json:"email_verified"` // a bool, or "true" from some providers
+	Name          string      `json:"name"`
+	Nonce         string      `json:"nonce"`
+	TenantID      string      `json:"tid"`
+}
+
+var (
+	oidcProviders map[string]*oidcProvider
+	oidcClient    *http.Client
+)
+
+func initOIDC() {
+	oidcProviders = map[string]*oidcProvider{}
+	oidcClient = &http.Client{Timeout: envDuration("OIDC_HTTP_TIMEOUT", 10*time.Second)}
+	for _, name := range strings.Split(envString("OIDC_PROVIDERS", ""), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		p := &oidcProvider{
+			Name:         name,
+			Issuer:       envString(prefix+"ISSUER", defaultIssuer(name)),
+			ClientID:     envString(prefix+"CLIENT_ID", ""),
+			ClientSecret: envString(prefix+"CLIENT_SECRET", ""),
+			TrustEmail:   envBool(prefix+"TRUST_EMAIL", false),
+		}
+		if p.Issuer == "" || p.ClientID == "" {
+			log.Printf("oidc %s: %sISSUER and %sCLIENT_ID are required, provider disabled", name, prefix, prefix)
+			continue
+		}
+		oidcProviders[name] = p
+	}
+}
+
+func defaultIssuer(name string) string {
+	switch name {
+	case "google":
+		return "https://accounts.google.com"
+	case "microsoft":
+		return "https://login.microsoftonline.com/" + envString("OIDC_MICROSOFT_TENANT", "common") + "/v2.0"
+	}
+	return ""
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// config fetches the provider's discovery document once and keeps it.
+func (p *oidcProvider) config(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	var d oidcDiscovery
+	if err := p.getJSON(ctx, strings.TrimRight(p.Issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// key returns the RSA key an ID token was signed with. Providers rotate keys,
+// so an unknown kid refetches the key set, at most once a minute.
+func (p *oidcProvider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	d, err := p.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	if time.Since(p.keysAt) < time.Minute {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	p.keys, p.keysAt = map[string]*rsa.PublicKey{}, time.Now()
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		p.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// redirectURI is where the provider sends the user back to. It must be
+// registered with the provider exactly as built here.
+func (p *oidcProvider) redirectURI() string {
+	return strings.TrimRight(envString("PUBLIC_URL", "http://localhost:8080"), "/") + apiPrefix + "/auth/" + p.Name + "/callback"
+}
+
+func randomToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func lookupProvider(w http.ResponseWriter, r *http.Request) (*oidcProvider, bool) {
+	p, ok := oidcProviders[r.URL.Query().Get("provider")]
+	if !ok {
+		http.Error(w, "Unknown login provider", http.StatusNotFound)
+	}
+	return p, ok
+}
+
+// oidcLoginHandler sends the browser to the provider's login page. The
+// state, nonce and PKCE verifier are kept for OIDC_STATE_TTL to check the
+// callback against.
+func oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p, ok := lookupProvider(w, r)
+	if !ok {
+		return
+	}
+	d, err := p.config(dbContext(r))
+	if err != nil {
+		requestLogger(r).Error("oidc discovery", "provider", p.Name, "error", err)
+		http.Error(w, "Login provider is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	var tokens [3]string
+	for i := range tokens {
+		if tokens[i], err = randomToken(); err != nil {
+			serverError(w, err)
+			return
+		}
+	}
+	state, nonce, verifier := tokens[0], tokens[1], tokens[2]
+	_, err = documents.Collection("oidcStates").InsertOne(dbContext(r), oidcState{
+		Hash:      hashToken(state),
+		Provider:  p.Name,
+		Nonce:     nonce,
+		Verifier:  verifier,
+		ExpiresAt: time.Now().Add(envDuration("OIDC_STATE_TTL", 10*time.Minute)).UTC(),
+	})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.redirectURI()},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	http.Redirect(w, r, d.AuthorizationEndpoint+"?"+q.Encode(), http.StatusFound)
+}
+
+// oidcCallbackHandler completes a login: it redeems the code, verifies the
+// ID token and logs in the user the identity belongs to. An identity seen
+// for the first time is linked to the user with the same verified email, or
+// a new user is registered. The response is the same as /login, or a
+// redirect to OIDC_REDIRECT_URL with the tokens in the fragment.
+func oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p, ok := lookupProvider(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		http.Error(w, "Login was not completed: "+e, http.StatusUnauthorized)
+		return
+	}
+
+	var state oidcState
+	err := documents.Collection("oidcStates").FindOneAndDelete(dbContext(r), bson.M{
+		"_id":       hashToken(q.Get("state")),
+		"provider":  p.Name,
+		"expiresAt": bson.M{"$gt": time.Now().UTC()},
+	}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Login has expired, please start again", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	claims, err := p.exchange(dbContext(r), q.Get("code"), state)
+	if err != nil {
+		requestLogger(r).Error("oidc callback", "provider", p.Name, "error", err)
+		http.Error(w, "Login provider rejected the login", http.StatusBadGateway)
+		return
+	}
+	email, err := normalizeEmail(claims.Email)
+	if err != nil {
+		http.Error(w, "Login provider did not share a valid email address", http.StatusForbidden)
+		return
+	}
+	if !p.TrustEmail && claims.EmailVerified != true && claims.EmailVerified != "true" {
+		http.Error(w, "Login provider has not verified this email address", http.StatusForbidden)
+		return
+	}
+
+	user, err := oidcUser(r, p.Name, claims.Subject, email, claims.Name)
+	if errors.Is(err, store.ErrDuplicate) {
+		http.Error(w, "Email already registered", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if user.Erasing {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	tokens, err := startSession(r, user)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if target := envString("OIDC_REDIRECT_URL", ""); target != "" {
+		fragment := url.Values{
+			"access_token":  {tokens.AccessToken},
+			"refresh_token": {tokens.RefreshToken},
+			"token_type":    {tokens.TokenType},
+			"expires_in":    {fmt.Sprint(tokens.ExpiresIn)},
+		}
+		http.Redirect(w, r, target+"#"+fragment.Encode(), http.StatusFound)
+		return
+	}
+	json.NewEncoder(w).Encode(loginResponse{tokenPair: tokens, User: user})
+}
+
+// exchange redeems an authorization code and returns the verified claims of
+// the ID token that comes back.
+func (p *oidcProvider) exchange(ctx context.Context, code string, state oidcState) (oidcClaims, error) {
+	var claims oidcClaims
+	d, err := p.config(ctx)
+	if err != nil {
+		return claims, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURI()},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code_verifier": {state.Verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return claims, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return claims, err
+	}
+	defer resp.Body.Close()
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return claims, fmt.Errorf("token endpoint: %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || token.IDToken == "" {
+		return claims, fmt.Errorf("token endpoint: %s: %s %s", resp.Status, token.Error, token.ErrorDescription)
+	}
+
+	_, err = jwt.ParseWithClaims(token.IDToken, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Name}), jwt.WithAudience(p.ClientID), jwt.WithExpirationRequired())
+	if err != nil {
+		return claims, err
+	}
+	// Multi-tenant issuers such as Microsoft's "common" endpoint advertise
+	// a {tenantid} placeholder that each token fills in.
+	if want := strings.ReplaceAll(d.Issuer, "{tenantid}", claims.TenantID); claims.Issuer != want {
+		return claims, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if claims.Nonce != state.Nonce {
+		return claims, errors.New("nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return claims, errors.New("missing subject")
+	}
+	return claims, nil
+}
+
+// oidcUser finds the user an identity belongs to, linking it to the user
+// registered with the same email or registering a new one.
+func oidcUser(r *http.Request, provider, subject, email, name string) (models.User, error) {
+	identity := models.Identity{Provider: provider, Subject: subject}
+	var user models.User
+	err := orgCollection("users").FindOne(dbContext(r),
+		bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}}}).Decode(&user)
+	if err != mongo.ErrNoDocuments {
+		return user, err
+	}
+
+	var before models.User
+	err = orgCollection("users").FindOneAndUpdate(dbContext(r),
+		bson.M{"email": email},
+		bson.M{"$push": bson.M{"identities": identity}, "$set": bson.M{"verified": true}},
+	).Decode(&before)
+	if err == nil {
+		user = before
+		user.Identities = append(append([]models.Identity{}, before.Identities...), identity)
+		user.Verified = true
+		recordAudit(r, auditLinkIdentity, auditTargetUser, user.ID, before, user)
+		return user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return user, err
+	}
+
+	if name = strings.TrimSpace(name); name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	user = models.User{
+		ID:         primitive.NewObjectID(),
+		Name:       name,
+		Email:      email,
+		Role:       roleUser,
+		Verified:   true,
+		Complaints: []primitive.ObjectID{},
+		Identities: []models.Identity{identity},
+		Locale:     requestLocale(r),
+		CreatedAt:  time.Now().UTC(),
+	}
+	user.UpdatedAt = user.CreatedAt
+	if err := userStore.CreateUser(dbContext(r), user); err != nil {
+		return user, err
+	}
+	recordAudit(r, auditRegister, auditTargetUser, user.ID, nil, user)
+	return user, nil
+}
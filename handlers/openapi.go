@@ -0,0 +1,695 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"complain/models"
+)
+
+// Who may call an operation, as enforced by the route guards in Run.
+const (
+	accessPublic = "public"
+	accessUser   = "user"
+	accessAny    = "any"
+	accessStaff  = "staff"
+	accessAdmin  = "admin"
+	// accessPlatform is an admin outside any organization.
+	accessPlatform = "platform"
+)
+
+type apiParam struct {
+	Name        string
+	In          string // query, path or header; defaults to query
+	Type        string // string, integer, boolean or date-time; defaults to string
+	Required    bool
+	Description string
+}
+
+// apiOperation documents one method on one path. Body and Response are zero
+// values of the types the handler decodes and encodes; their schemas are
+// derived from the structs' json tags so the spec follows the code.
+type apiOperation struct {
+	Method      string
+	Path        string
+	Summary     string
+	Tag         string
+	Access      string
+	Params      []apiParam
+	Body        interface{}
+	Response    interface{}
+	Status      int    // success status, 200 if unset
+	ContentType string // response types, comma-separated; application/json and application/xml if unset
+}
+
+var (
+	complaintIDParam   = apiParam{Name: "complaintId", Required: true, Description: "Complaint ID"}
+	pathIDParam        = apiParam{Name: "id", In: "path", Required: true, Description: "Complaint ID"}
+	pageParam          = apiParam{Name: "page", Type: "integer", Description: "1-based page number"}
+	pageSizeParam      = apiParam{Name: "pageSize", Type: "integer", Description: "Results per page"}
+	statusParam        = apiParam{Name: "status", Description: "Only complaints in this status"}
+	ifMatchParam       = apiParam{Name: "If-Match", In: "header", Description: "Complaint version the update is based on"}
+	versionParam       = apiParam{Name: "version", Type: "integer", Description: "Complaint version, if If-Match is not sent"}
+	trackingTokenParam = apiParam{Name: "token", Required: true, Description: "Tracking token from an anonymous submission"}
+	listFilterParams   = []apiParam{
+		{Name: "resolved", Type: "boolean"},
+		{Name: "minRating", Type: "integer"},
+		{Name: "maxRating", Type: "integer"},
+		{Name: "from", Description: "Created at or after; an RFC3339 time or a date"},
+		{Name: "to", Description: "Created before; an RFC3339 time or a date"},
+		{Name: "tz", Description: "IANA time zone for dates and exported times"},
+		{Name: "userId", Description: "Reporter's user ID"},
+		{Name: "category"},
+		{Name: "tags", Description: "Comma-separated; complaints carrying all of them"},
+	}
+	adminFilterParams = append(append([]apiParam{}, listFilterParams...),
+		apiParam{Name: "assignedTo", Description: `Agent name, or "unassigned"`},
+		apiParam{Name: "includeDeleted", Type: "boolean", Description: "Include soft-deleted complaints; admins only"},
+	)
+)
+
+var apiOperations = []apiOperation{
+	{Method: "post", Path: "/register", Summary: "Register a user", Tag: "auth", Access: accessPublic,
+		Body: models.User{}, Response: models.User{}},
+	{Method: "post", Path: "/login", Summary: "Exchange a secret code for tokens", Tag: "auth", Access: accessPublic,
+		Body: loginRequest{}, Response: loginResponse{}},
+	{Method: "post", Path: "/refresh", Summary: "Rotate a refresh token", Tag: "auth", Access: accessPublic,
+		Body: refreshRequest{}, Response: tokenPair{}},
+	{Method: "post", Path: "/logout", Summary: "End the session of a refresh token, or of the caller's access token", Tag: "auth", Access: accessPublic,
+		Body: refreshRequest{}, Status: http.StatusNoContent},
+	{Method: "get", Path: "/sessions", Summary: "List the caller's active sessions", Tag: "auth", Access: accessUser,
+		Response: []session{}},
+	{Method: "delete", Path: "/sessions", Summary: "Revoke one of the caller's sessions", Tag: "auth", Access: accessUser,
+		Params: []apiParam{{Name: "id", Required: true, Description: "Session ID"}}, Status: http.StatusNoContent},
+	{Method: "get", Path: "/notifications", Summary: "The caller's in-app notifications, newest first, with the unread count", Tag: "collaboration", Access: accessUser,
+		Params: []apiParam{{Name: "unread", Type: "boolean", Description: "Only unread notifications"}, pageParam, pageSizeParam}, Response: pagedNotifications{}},
+	{Method: "post", Path: "/readNotification", Summary: "Mark a notification read", Tag: "collaboration", Access: accessUser,
+		Params: []apiParam{{Name: "id", Required: true, Description: "Notification ID"}}, Status: http.StatusNoContent},
+	{Method: "post", Path: "/readAllNotifications", Summary: "Mark all of the caller's notifications read", Tag: "collaboration", Access: accessUser,
+		Status: http.StatusNoContent},
+	{Method: "get", Path: "/oidcLogin", Summary: "Start a single sign-on login", Tag: "auth", Access: accessPublic,
+		Params: []apiParam{{Name: "provider", Required: true, Description: "A provider named in OIDC_PROVIDERS"}}, Status: http.StatusFound},
+	{Method: "get", Path: "/oidcCallback", Summary: "Finish a single sign-on login", Tag: "auth", Access: accessPublic,
+		Params:   []apiParam{{Name: "provider", Required: true}, {Name: "code", Required: true}, {Name: "state", Required: true}},
+		Response: loginResponse{}},
+	{Method: "get", Path: "/verifyEmail", Summary: "Confirm an email address with the emailed token", Tag: "auth", Access: accessPublic,
+		Params: []apiParam{{Name: "token", Required: true}}, Response: models.User{}},
+	{Method: "post", Path: "/resendVerification", Summary: "Email the caller a new verification link", Tag: "auth", Access: accessUser,
+		Status: http.StatusAccepted},
+	{Method: "post", Path: "/setUserRole", Summary: "Change a user's role", Tag: "auth", Access: accessAdmin,
+		Params: []apiParam{{Name: "userId", Required: true}, {Name: "role", Required: true}}, Response: models.User{}},
+	{Method: "get", Path: "/orgs", Summary: "List organizations; organization admins see only their own", Tag: "auth", Access: accessAdmin,
+		Response: []models.Organization{}},
+	{Method: "post", Path: "/orgs", Summary: "Create an organization", Tag: "auth", Access: accessPlatform,
+		Body: models.Organization{}, Response: models.Organization{}, Status: http.StatusCreated},
+	{Method: "get", Path: "/apiKeys", Summary: "List API keys; organization admins see only their own", Tag: "auth", Access: accessAdmin,
+		Response: []models.APIKey{}},
+	{Method: "post", Path: "/apiKeys", Summary: "Create an API key; the key is only returned here", Tag: "auth", Access: accessAdmin,
+		Body: apiKeyRequest{}, Response: models.APIKey{}, Status: http.StatusCreated},
+	{Method: "delete", Path: "/apiKeys", Summary: "Revoke an API key", Tag: "auth", Access: accessAdmin,
+		Params: []apiParam{{Name: "id", Required: true}}, Status: http.StatusNoContent},
+	{Method: "post", Path: "/inviteOrgAdmin", Summary: "Email an invitation to become an admin of an organization", Tag: "auth", Access: accessAdmin,
+		Params: []apiParam{{Name: "orgId", Required: true}}, Body: inviteRequest{}, Response: orgInvite{}, Status: http.StatusAccepted},
+	{Method: "post", Path: "/acceptInvite", Summary: "Create an organization admin account from an invitation", Tag: "auth", Access: accessPublic,
+		Params: []apiParam{{Name: "token", Required: true}}, Body: acceptInviteRequest{}, Response: models.User{}, Status: http.StatusCreated},
+	{Method: "put", Path: "/updateProfile", Summary: "Update the caller's profile", Tag: "auth", Access: accessUser,
+		Body: profileUpdate{}, Response: models.User{}},
+	{Method: "patch", Path: "/updateProfile", Summary: "Change some of the caller's profile fields", Tag: "auth", Access: accessUser,
+		Body: profileUpdate{}, Response: models.User{}},
+	{Method: "put", Path: "/myAvatar", Summary: "Upload the caller's avatar (multipart field file)", Tag: "auth", Access: accessUser,
+		Response: avatarResponse{}, Status: http.StatusCreated},
+	{Method: "delete", Path: "/myAvatar", Summary: "Remove the caller's avatar", Tag: "auth", Access: accessUser,
+		Status: http.StatusNoContent},
+	{Method: "put", Path: "/myPhone", Summary: "Set the caller's phone number and text it a verification code", Tag: "auth", Access: accessUser,
+		Body: phoneRequest{}, Response: models.User{}, Status: http.StatusAccepted},
+	{Method: "delete", Path: "/myPhone", Summary: "Remove the caller's phone number", Tag: "auth", Access: accessUser,
+		Status: http.StatusNoContent},
+	{Method: "post", Path: "/verifyPhone", Summary: "Confirm the caller's phone number with the texted code", Tag: "auth", Access: accessUser,
+		Body: phoneCodeRequest{}, Response: models.User{}},
+	{Method: "get", Path: "/notificationPreferences", Summary: "The channels the caller is notified on", Tag: "auth", Access: accessUser,
+		Response: notificationPreferences{}},
+	{Method: "put", Path: "/notificationPreferences", Summary: "Choose email, sms or both for notifications", Tag: "auth", Access: accessUser,
+		Body: notificationPreferences{}, Response: notificationPreferences{}},
+	{Method: "get", Path: "/avatar", Summary: "Download a user's avatar", Tag: "auth", Access: accessAny,
+		Params: []apiParam{{Name: "userId", Required: true}}, ContentType: "image/jpeg,image/png,image/gif,image/webp"},
+	{Method: "get", Path: "/exportMyData", Summary: "Download everything stored about the caller", Tag: "auth", Access: accessUser,
+		Response: dataExport{}},
+	{Method: "delete", Path: "/deleteAccount", Summary: "Schedule the caller's account for erasure", Tag: "auth", Access: accessUser,
+		Response: erasureSchedule{}, Status: http.StatusAccepted},
+	{Method: "post", Path: "/cancelAccountDeletion", Summary: "Cancel a scheduled account erasure", Tag: "auth", Access: accessUser,
+		Status: http.StatusNoContent},
+	{Method: "post", Path: "/rotateSecretCode", Summary: "Replace the caller's secret code and sign out other sessions", Tag: "auth", Access: accessUser,
+		Response: secretCodeResponse{}},
+
+	{Method: "post", Path: "/submitComplaint", Summary: "Submit a complaint", Tag: "complaints", Access: accessUser,
+		Params: []apiParam{{Name: "Idempotency-Key", In: "header",
+			Description: "Retries with the same key within IDEMPOTENCY_KEY_TTL get the original response, marked with Idempotent-Replayed"},
+			{Name: "force", Type: "boolean", Description: "Submit even if similar recent complaints are found (409 lists them otherwise)"}}, Body: models.Complaint{}, Response: models.Complaint{}},
+	{Method: "post", Path: "/submitAnonymousComplaint", Summary: "Submit a complaint without an account and get a tracking token", Tag: "complaints", Access: accessPublic,
+		Params: []apiParam{{Name: "orgId", Description: "Organization to file in; it must accept anonymous complaints"}},
+		Body:   models.Complaint{}, Response: anonymousSubmission{}, Status: http.StatusCreated},
+	{Method: "get", Path: "/trackComplaint", Summary: "View an anonymous complaint by its tracking token", Tag: "complaints", Access: accessPublic,
+		Params: []apiParam{trackingTokenParam}, Response: models.Complaint{}},
+	{Method: "get", Path: "/trackComplaint/comments", Summary: "List the public comments on an anonymous complaint", Tag: "collaboration", Access: accessPublic,
+		Params: []apiParam{trackingTokenParam, {Name: "parentId", Description: "List replies to this comment"}, pageParam, pageSizeParam}, Response: pagedComments{}},
+	{Method: "post", Path: "/trackComplaint/comments", Summary: "Comment on an anonymous complaint as its submitter", Tag: "collaboration", Access: accessPublic,
+		Params: []apiParam{trackingTokenParam}, Body: commentRequest{}, Response: models.Comment{}, Status: http.StatusCreated},
+	{Method: "get", Path: "/getAllComplaintsForUser", Summary: "List the caller's complaints", Tag: "complaints", Access: accessUser,
+		Params: append([]apiParam{
+			{Name: "limit", Type: "integer"},
+			{Name: "cursor", Description: "nextCursor from the previous page"},
+			{Name: "sort", Description: "createdAt, updatedAt, rating, status or sla"},
+			{Name: "order", Description: "asc or desc"},
+		}, listFilterParams...), Response: userComplaintsResponse{}},
+	{Method: "get", Path: "/getAllComplaintsForAdmin", Summary: "Page through all complaints", Tag: "complaints", Access: accessStaff,
+		Params: append([]apiParam{
+			{Name: "limit", Type: "integer"},
+			{Name: "cursor", Description: "nextCursor from the previous page"},
+			{Name: "savedSearch", Description: "ID of a saved search whose parameters fill in those not given"},
+			{Name: "sort", Description: "createdAt, updatedAt, rating or status"},
+			{Name: "order", Description: "asc or desc"},
+			{Name: "includeDuplicateCount", Type: "boolean"},
+		}, adminFilterParams...), Response: adminComplaintsPage{}},
+	{Method: "get", Path: "/complaints/search", Summary: "Full-text search over titles and summaries", Tag: "complaints", Access: accessAny,
+		Params: []apiParam{{Name: "q", Required: true}, pageParam, pageSizeParam}, Response: pagedComplaints{}},
+	{Method: "get", Path: "/viewComplaint", Summary: "Get one complaint", Tag: "complaints", Access: accessAny,
+		Params: []apiParam{complaintIDParam, {Name: "includeReporterContext", Type: "boolean"}}, Response: models.Complaint{}},
+	{Method: "delete", Path: "/deleteComplaint", Summary: "Soft-delete a complaint", Tag: "complaints", Access: accessAny,
+		Params: []apiParam{complaintIDParam}, Status: http.StatusNoContent},
+	{Method: "post", Path: "/restoreComplaint", Summary: "Restore a soft-deleted complaint", Tag: "complaints", Access: accessAdmin,
+		Params: []apiParam{complaintIDParam}, Response: models.Complaint{}},
+	{Method: "patch", Path: "/editComplaint", Summary: "Edit an unresolved complaint's title, summary or rating", Tag: "complaints", Access: accessUser,
+		Params: []apiParam{complaintIDParam}, Body: complaintEdit{}, Response: models.Complaint{}},
+	{Method: "get", Path: "/complaintRevisions", Summary: "A complaint's content edits", Tag: "complaints", Access: accessAny,
+		Params: []apiParam{complaintIDParam}, Response: []models.ComplaintRevision{}},
+	{Method: "get", Path: "/complaintFeedback", Summary: "The reporter's satisfaction feedback on a resolved complaint", Tag: "complaints", Access: accessAny,
+		Params: []apiParam{complaintIDParam}, Response: models.Feedback{}},
+	{Method: "post", Path: "/complaintFeedback", Summary: "Rate how a resolved complaint was handled", Tag: "complaints", Access: accessUser,
+		Params: []apiParam{complaintIDParam}, Body: feedbackRequest{}, Response: models.Feedback{}, Status: http.StatusCreated},
+	{Method: "get", Path: "/complaintHistory", Summary: "A complaint's status history", Tag: "complaints", Access: accessAny,
+		Params: []apiParam{complaintIDParam}, Response: []models.StatusChange{}},
+
+	{Method: "post", Path: "/complaints/{id}/transition", Summary: "Move a complaint to another status", Tag: "workflow", Access: accessAny,
+		Params: []apiParam{pathIDParam, ifMatchParam, versionParam}, Body: transitionRequest{}, Response: models.Complaint{}},
+	{Method: "post", Path: "/resolveComplaint", Summary: "Resolve a complaint", Tag: "workflow", Access: accessStaff,
+		Params: []apiParam{complaintIDParam, ifMatchParam, versionParam,
+			{Name: "templateId", Description: "Reply template to use as the resolution note"}}, Response: models.Complaint{}},
+	{Method: "post", Path: "/resolveBatch", Summary: "Resolve several complaints", Tag: "workflow", Access: accessStaff,
+		Body: []string{}, Response: bulkResult{}},
+	{Method: "post", Path: "/admin/complaints/bulk", Summary: "Resolve, close, assign or tag many complaints", Tag: "workflow", Access: accessStaff,
+		Body: bulkRequest{}, Response: bulkResult{}},
+	{Method: "post", Path: "/reopenComplaint", Summary: "Reopen a resolved complaint that was not fixed", Tag: "workflow", Access: accessUser,
+		Params: []apiParam{complaintIDParam}, Body: reopenRequest{}, Response: models.Complaint{}},
+	{Method: "post", Path: "/approveResolution", Summary: "Approve or reject a pending resolution", Tag: "workflow", Access: accessStaff,
+		Params: []apiParam{complaintIDParam, {Name: "decision", Required: true, Description: "approve or reject"}, ifMatchParam, versionParam}, Response: models.Complaint{}},
+	{Method: "post", Path: "/assignComplaint", Summary: "Assign or reassign a complaint", Tag: "workflow", Access: accessStaff,
+		Params: []apiParam{complaintIDParam, {Name: "agent", Required: true}, ifMatchParam, versionParam}, Response: models.Complaint{}},
+	{Method: "post", Path: "/unassignComplaint", Summary: "Unassign a complaint", Tag: "workflow", Access: accessStaff,
+		Params: []apiParam{complaintIDParam, ifMatchParam, versionParam}, Response: models.Complaint{}},
+	{Method: "get", Path: "/myAssignedComplaints", Summary: "List complaints assigned to the caller", Tag: "workflow", Access: accessStaff,
+		Params: []apiParam{statusParam, pageParam, pageSizeParam}, Response: pagedComplaints{}},
+	{Method: "post", Path: "/recategorizeComplaint", Summary: "Move a complaint to another category", Tag: "workflow", Access: accessStaff,
+		Params: []apiParam{complaintIDParam, {Name: "category", Required: true}, ifMatchParam, versionParam}, Response: models.Complaint{}},
+	{Method: "get", Path: "/categorySuggestions", Summary: "Suggest categories for a complaint", Tag: "workflow", Access: accessStaff,
+		Params: []apiParam{complaintIDParam, {Name: "limit", Type: "integer"}}, Response: []categorySuggestion{}},
+	{Method: "post", Path: "/tagComplaint", Summary: "Add tags to a complaint", Tag: "workflow", Access: accessStaff,
+		Params: []apiParam{complaintIDParam}, Body: tagsRequest{}, Response: models.Complaint{}},
+	{Method: "post", Path: "/untagComplaint", Summary: "Remove a tag from a complaint", Tag: "workflow", Access: accessStaff,
+		Params: []apiParam{complaintIDParam, {Name: "tag", Required: true}}, Response: models.Complaint{}},
+	{Method: "get", Path: "/complaintTags", Summary: "Tags in use with their complaint counts", Tag: "workflow", Access: accessAdmin,
+		Response: []tagUsage{}},
+	{Method: "get", Path: "/moderationQueue", Summary: "Complaints held by content screening", Tag: "workflow", Access: accessAdmin,
+		Params: []apiParam{pageParam, pageSizeParam}, Response: pagedComplaints{}},
+	{Method: "post", Path: "/approveFlagged", Summary: "Release a held complaint into the workflow", Tag: "workflow", Access: accessAdmin,
+		Params: []apiParam{complaintIDParam}, Response: models.Complaint{}},
+	{Method: "post", Path: "/rejectFlagged", Summary: "Reject a held complaint, soft-deleting it", Tag: "workflow", Access: accessAdmin,
+		Params: []apiParam{complaintIDParam}, Response: models.Complaint{}},
+	{Method: "get", Path: "/categories", Summary: "List complaint categories", Tag: "workflow", Access: accessAny,
+		Response: []models.Category{}},
+	{Method: "post", Path: "/categories", Summary: "Create a category", Tag: "workflow", Access: accessPlatform,
+		Body: categoryRequest{}, Response: models.Category{}, Status: http.StatusCreated},
+	{Method: "put", Path: "/categories", Summary: "Update a category's name, description and routing", Tag: "workflow", Access: accessPlatform,
+		Params: []apiParam{{Name: "id", Required: true}}, Body: categoryRequest{}, Response: models.Category{}},
+	{Method: "delete", Path: "/categories", Summary: "Remove a category", Tag: "workflow", Access: accessPlatform,
+		Params: []apiParam{{Name: "id", Required: true}}, Status: http.StatusNoContent},
+	{Method: "get", Path: "/replyTemplates", Summary: "List canned responses", Tag: "workflow", Access: accessStaff,
+		Response: []models.ReplyTemplate{}},
+	{Method: "post", Path: "/replyTemplates", Summary: "Create a canned response", Tag: "workflow", Access: accessPlatform,
+		Body: replyTemplateRequest{}, Response: models.ReplyTemplate{}, Status: http.StatusCreated},
+	{Method: "put", Path: "/replyTemplates", Summary: "Update a canned response", Tag: "workflow", Access: accessPlatform,
+		Params: []apiParam{{Name: "id", Required: true}}, Body: replyTemplateRequest{}, Response: models.ReplyTemplate{}},
+	{Method: "delete", Path: "/replyTemplates", Summary: "Remove a canned response", Tag: "workflow", Access: accessPlatform,
+		Params: []apiParam{{Name: "id", Required: true}}, Status: http.StatusNoContent},
+	{Method: "get", Path: "/savedSearches", Summary: "List the caller's saved searches", Tag: "workflow", Access: accessStaff,
+		Response: []models.SavedSearch{}},
+	{Method: "post", Path: "/savedSearches", Summary: "Save a named set of listing parameters", Tag: "workflow", Access: accessStaff,
+		Body: savedSearchRequest{}, Response: models.SavedSearch{}, Status: http.StatusCreated},
+	{Method: "put", Path: "/savedSearches", Summary: "Update a saved search", Tag: "workflow", Access: accessStaff,
+		Params: []apiParam{{Name: "id", Required: true}}, Body: savedSearchRequest{}, Response: models.SavedSearch{}},
+	{Method: "delete", Path: "/savedSearches", Summary: "Remove a saved search", Tag: "workflow", Access: accessStaff,
+		Params: []apiParam{{Name: "id", Required: true}}, Status: http.StatusNoContent},
+	{Method: "post", Path: "/masterComplaint", Summary: "Designate a complaint as its duplicates' master", Tag: "workflow", Access: accessStaff,
+		Params: []apiParam{complaintIDParam}, Response: masterComplaint{}},
+	{Method: "delete", Path: "/masterComplaint", Summary: "Clear a duplicate cluster's master", Tag: "workflow", Access: accessStaff,
+		Params: []apiParam{complaintIDParam}, Status: http.StatusNoContent},
+	{Method: "post", Path: "/mergeComplaint", Summary: "Merge duplicates into this complaint, moving their comments and attachments and closing them", Tag: "workflow", Access: accessStaff,
+		Params: []apiParam{complaintIDParam}, Body: mergeRequest{}, Response: mergeResult{}},
+	{Method: "post", Path: "/relatedComplaints", Summary: "Link two related complaints without merging them", Tag: "workflow", Access: accessStaff,
+		Params: []apiParam{complaintIDParam, {Name: "relatedId", Required: true}}, Response: models.Complaint{}},
+	{Method: "delete", Path: "/relatedComplaints", Summary: "Unlink two related complaints", Tag: "workflow", Access: accessStaff,
+		Params: []apiParam{complaintIDParam, {Name: "relatedId", Required: true}}, Response: models.Complaint{}},
+	{Method: "post", Path: "/linkTicket", Summary: "Link an external ticket", Tag: "workflow", Access: accessStaff,
+		Params: []apiParam{complaintIDParam}, Body: models.ExternalTicket{}, Response: models.Complaint{}},
+	{Method: "post", Path: "/unlinkTicket", Summary: "Unlink the external ticket", Tag: "workflow", Access: accessStaff,
+		Params: []apiParam{complaintIDParam}, Response: models.Complaint{}},
+
+	{Method: "get", Path: "/complaints/{id}/report.pdf", Summary: "Printable PDF of a complaint, its history and comments", Tag: "complaints", Access: accessAny,
+		Params: []apiParam{pathIDParam}, ContentType: "application/pdf"},
+	{Method: "get", Path: "/complaints/{id}/comments", Summary: "List comments; internal notes are shown to staff only", Tag: "collaboration", Access: accessAny,
+		Params: []apiParam{pathIDParam, {Name: "parentId", Description: "List replies to this comment"}, pageParam, pageSizeParam}, Response: pagedComments{}},
+	{Method: "post", Path: "/complaints/{id}/comments", Summary: "Add a comment, reply or staff-only internal note", Tag: "collaboration", Access: accessAny,
+		Params: []apiParam{pathIDParam}, Body: commentRequest{}, Response: models.Comment{}, Status: http.StatusCreated},
+	{Method: "get", Path: "/complaints/{id}/attachments", Summary: "List attachments", Tag: "collaboration", Access: accessAny,
+		Params: []apiParam{pathIDParam}, Response: []models.Attachment{}},
+	{Method: "post", Path: "/complaints/{id}/attachments", Summary: "Upload an attachment (multipart field \"file\")", Tag: "collaboration", Access: accessAny,
+		Params: []apiParam{pathIDParam}, Response: models.Attachment{}, Status: http.StatusCreated},
+	{Method: "get", Path: "/complaints/{id}/attachments/{fileId}", Summary: "Download an attachment", Tag: "collaboration", Access: accessAny,
+		Params: []apiParam{pathIDParam, {Name: "fileId", In: "path", Required: true}}, ContentType: "application/octet-stream"},
+	{Method: "post", Path: "/watchComplaint", Summary: "Watch a complaint", Tag: "collaboration", Access: accessUser,
+		Params: []apiParam{complaintIDParam}, Response: models.Complaint{}},
+	{Method: "post", Path: "/unwatchComplaint", Summary: "Stop watching a complaint", Tag: "collaboration", Access: accessUser,
+		Params: []apiParam{complaintIDParam}, Response: models.Complaint{}},
+	{Method: "get", Path: "/watchedComplaints", Summary: "List watched complaints", Tag: "collaboration", Access: accessUser,
+		Params: []apiParam{statusParam, pageParam, pageSizeParam}, Response: pagedComplaints{}},
+	{Method: "post", Path: "/setFollowUp", Summary: "Schedule a follow-up reminder", Tag: "collaboration", Access: accessUser,
+		Params: []apiParam{complaintIDParam, {Name: "at", Type: "date-time", Required: true}}, Response: models.Complaint{}},
+
+	{Method: "get", Path: "/complaintChanges", Summary: "Poll the complaint change feed", Tag: "events", Access: accessStaff,
+		Params: []apiParam{{Name: "resumeToken"}, {Name: "limit", Type: "integer"}}, Response: complaintChangesResponse{}},
+	{Method: "get", Path: "/complaintSocket", Summary: "Complaint changes over WebSocket", Tag: "events", Access: accessStaff},
+	{Method: "get", Path: "/events", Summary: "Complaint events as Server-Sent Events", Tag: "events", Access: accessAny,
+		Params: []apiParam{{Name: "Last-Event-ID", In: "header"}}, ContentType: "text/event-stream"},
+	{Method: "get", Path: "/webhooks", Summary: "List webhooks", Tag: "events", Access: accessPlatform,
+		Response: []models.Webhook{}},
+	{Method: "post", Path: "/webhooks", Summary: "Register a webhook", Tag: "events", Access: accessPlatform,
+		Body: webhookRequest{}, Response: models.Webhook{}, Status: http.StatusCreated},
+	{Method: "get", Path: "/admin/chat", Summary: "List the Slack and Teams channels from CHAT_CHANNELS", Tag: "events", Access: accessPlatform,
+		Response: []chatChannel{}},
+	{Method: "post", Path: "/admin/chat/test", Summary: "Post a test message to a chat channel", Tag: "events", Access: accessPlatform,
+		Params: []apiParam{{Name: "name", Required: true, Description: "Channel name"}}, Response: chatTestResult{}},
+	{Method: "delete", Path: "/webhooks", Summary: "Remove a webhook", Tag: "events", Access: accessPlatform,
+		Params: []apiParam{{Name: "id", Required: true}}, Status: http.StatusNoContent},
+	{Method: "get", Path: "/webhookDeliveries", Summary: "List webhook delivery attempts", Tag: "events", Access: accessPlatform,
+		Params: []apiParam{{Name: "webhookId"}, {Name: "failed", Type: "boolean"}, {Name: "limit", Type: "integer"}}, Response: []models.WebhookDelivery{}},
+
+	{Method: "get", Path: "/admin/jobs", Summary: "Background job run state", Tag: "audit", Access: accessPlatform,
+		Response: []models.Job{}},
+	{Method: "post", Path: "/admin/import", Summary: "Import users and complaints from a CSV or JSON file, as the body or multipart field file", Tag: "audit", Access: accessAdmin,
+		Params: []apiParam{{Name: "format", Description: "csv or json; taken from the file name or content type if unset"}, {Name: "dryRun", Type: "boolean", Description: "Only validate"}},
+		Body:   importFile{}, Response: importResult{}},
+	{Method: "get", Path: "/admin/archive", Summary: "Query complaints archived by the retention job, or fetch one with its comments by complaintId", Tag: "audit", Access: accessAdmin,
+		Params: []apiParam{{Name: "complaintId"}, {Name: "refNumber", Type: "integer"}, {Name: "userId"}, {Name: "category"}, {Name: "status"},
+			{Name: "from", Type: "date-time"}, {Name: "to", Type: "date-time"}, pageParam, pageSizeParam},
+		Response: archivedComplaintsResponse{}},
+
+	{Method: "get", Path: "/auditLog", Summary: "Status changes across all complaints", Tag: "audit", Access: accessStaff,
+		Params:   []apiParam{{Name: "targetId"}, {Name: "action"}, {Name: "actor"}, {Name: "from", Type: "date-time"}, {Name: "to", Type: "date-time"}, pageParam, pageSizeParam},
+		Response: auditLogResponse{}},
+	{Method: "get", Path: "/auditLogs", Summary: "Query the audit log", Tag: "audit", Access: accessAdmin,
+		Params:   []apiParam{{Name: "targetId"}, {Name: "targetType"}, {Name: "action"}, {Name: "actor"}, {Name: "from", Type: "date-time"}, {Name: "to", Type: "date-time"}, pageParam, pageSizeParam},
+		Response: auditLogsResponse{}},
+
+	{Method: "get", Path: "/admin/complaints/export", Summary: "Export complaints as CSV or Excel", Tag: "reports", Access: accessStaff,
+		Params: append([]apiParam{
+			{Name: "format", Description: "csv (default) or xlsx"},
+			{Name: "sheetPerCategory", Type: "boolean", Description: "xlsx only"},
+		}, adminFilterParams...),
+		ContentType: "text/csv,application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	{Method: "get", Path: "/exportComplaints.csv", Summary: "Export complaints as CSV", Tag: "reports", Access: accessStaff,
+		Params: adminFilterParams, ContentType: "text/csv"},
+	{Method: "get", Path: "/exportComplaints.xlsx", Summary: "Export complaints as Excel", Tag: "reports", Access: accessStaff,
+		Params:      append([]apiParam{{Name: "sheetPerCategory", Type: "boolean"}}, adminFilterParams...),
+		ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	{Method: "get", Path: "/complaintClusters", Summary: "Group similar open complaints", Tag: "reports", Access: accessStaff,
+		Params: []apiParam{{Name: "threshold", Description: "Similarity between 0 and 1"}, {Name: "minSize", Type: "integer"}}, Response: []ComplaintCluster{}},
+	{Method: "get", Path: "/complaintsByReporter", Summary: "Complaints grouped by reporter", Tag: "reports", Access: accessStaff,
+		Params: []apiParam{pageParam, pageSizeParam}, Response: reporterGroupsResponse{}},
+	{Method: "get", Path: "/resolutionTimes", Summary: "Average resolution time per agent", Tag: "reports", Access: accessStaff,
+		Response: resolutionTimesResponse{}},
+	{Method: "get", Path: "/ratingBreakdown", Summary: "Complaint counts per rating", Tag: "reports", Access: accessStaff,
+		Response: map[string]int{}},
+	{Method: "get", Path: "/admin/stats", Summary: "Complaint analytics", Tag: "reports", Access: accessStaff,
+		Params: []apiParam{
+			{Name: "from", Description: "Created at or after; an RFC3339 time or a date"},
+			{Name: "to", Description: "Created before; an RFC3339 time or a date"},
+			{Name: "tz", Description: "IANA time zone for dates and daily counts"},
+			{Name: "top", Type: "integer", Description: "Number of top categories"},
+		},
+		Response: adminStats{}},
+	{Method: "get", Path: "/admin/dashboard", Summary: "Dashboard summary with week-over-week trends", Tag: "reports", Access: accessStaff,
+		Params: []apiParam{{Name: "oldest", Type: "integer", Description: "Number of oldest unresolved complaints"}}, Response: dashboardSummary{}},
+	{Method: "get", Path: "/categoryBreakdown", Summary: "Complaint counts per category", Tag: "reports", Access: accessStaff,
+		Response: []categoryStats{}},
+	{Method: "get", Path: "/complaintHeatmap", Summary: "Submissions by weekday and hour", Tag: "reports", Access: accessStaff,
+		Params: []apiParam{{Name: "tz", Description: "IANA time zone"}}, Response: complaintHeatmap{}},
+
+	{Method: "get", Path: "/graphql", Summary: "Run a GraphQL query given as query, variables and operationName", Tag: "reports", Access: accessPublic,
+		Params: []apiParam{
+			{Name: "query", Required: true, Description: "GraphQL query document"},
+			{Name: "variables", Description: "JSON object of variable values"},
+			{Name: "operationName", Description: "Operation to run if the document has several"},
+		},
+		Response: graphqlResponse{}, ContentType: "application/json"},
+	{Method: "post", Path: "/graphql", Summary: "Run a GraphQL query", Tag: "reports", Access: accessPublic,
+		Body: graphqlRequest{}, Response: graphqlResponse{}, ContentType: "application/json"},
+
+	{Method: "get", Path: "/time", Summary: "Server clock", Tag: "system", Access: accessPublic, Response: serverTime{}},
+	{Method: "get", Path: "/healthz", Summary: "Liveness probe", Tag: "system", Access: accessPublic, Response: map[string]string{}},
+	{Method: "get", Path: "/readyz", Summary: "Readiness probe; 503 when MongoDB or its indexes are unavailable", Tag: "system", Access: accessPublic,
+		Response: readiness{}},
+	{Method: "get", Path: "/metrics", Summary: "Prometheus metrics", Tag: "system", Access: accessPublic, ContentType: "text/plain"},
+	{Method: "get", Path: "/openapi.json", Summary: "This specification", Tag: "system", Access: accessPublic},
+	{Method: "get", Path: "/docs", Summary: "Interactive API documentation", Tag: "system", Access: accessPublic, ContentType: "text/html"},
+}
+
+// schemaBuilder turns Go types into OpenAPI schemas, collecting named structs
+// as reusable components.
+type schemaBuilder struct {
+	components map[string]interface{}
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+)
+
+func schemaName(t reflect.Type) string {
+	r := []rune(t.Name())
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case objectIDType:
+		return map[string]interface{}{"type": "string", "pattern": "^[0-9a-f]{24}$"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := b.schema(t.Elem())
+		if _, isRef := s["$ref"]; !isRef {
+			s["nullable"] = true
+		}
+		return s
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		name := schemaName(t)
+		if _, ok := b.components[name]; !ok {
+			b.components[name] = map[string]interface{}{} // placeholder for recursive types
+			b.components[name] = b.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+// object describes a struct the way encoding/json writes it: json tag names,
+// "-" fields skipped, embedded structs flattened and omitempty fields
+// optional.
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	var required []string
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				walk(f.Type)
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = b.schema(f.Type)
+			if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Ptr {
+				required = append(required, name)
+			}
+		}
+	}
+	walk(t)
+	obj := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		sort.Strings(required)
+		obj["required"] = required
+	}
+	return obj
+}
+
+func paramSchema(typ string) map[string]interface{} {
+	switch typ {
+	case "integer", "boolean":
+		return map[string]interface{}{"type": typ}
+	case "date-time":
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	return map[string]interface{}{"type": "string"}
+}
+
+func securityFor(access string) []map[string][]string {
+	switch access {
+	case accessPublic:
+		return []map[string][]string{}
+	case accessStaff, accessAdmin, accessPlatform, accessAny:
+		return []map[string][]string{{"bearerAuth": {}}, {"adminToken": {}}, {"apiKey": {}}}
+	}
+	return []map[string][]string{{"bearerAuth": {}}}
+}
+
+var accessNotes = map[string]string{
+	accessUser:     "Requires a signed-in user.",
+	accessAny:      "Requires a signed-in user or staff member; users only reach their own complaints.",
+	accessStaff:    "Requires an agent, manager or admin.",
+	accessAdmin:    "Requires an admin.",
+	accessPlatform: "Requires an admin outside any organization.",
+}
+
+func buildOpenAPISpec() map[string]interface{} {
+	b := &schemaBuilder{components: map[string]interface{}{}}
+	errorSchema := b.schema(reflect.TypeOf(struct {
+		Errors validationErrors `json:"errors"`
+	}{}))
+
+	paths := map[string]map[string]interface{}{}
+	for _, op := range servedOperations() {
+		var params []map[string]interface{}
+		for _, p := range op.Params {
+			in := p.In
+			if in == "" {
+				in = "query"
+			}
+			param := map[string]interface{}{"name": p.Name, "in": in, "schema": paramSchema(p.Type)}
+			if p.Required {
+				param["required"] = true
+			}
+			if p.Description != "" {
+				param["description"] = p.Description
+			}
+			params = append(params, param)
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]interface{}{"description": http.StatusText(status)}
+		if status != http.StatusNoContent {
+			contentType := op.ContentType
+			if contentType == "" {
+				contentType = "application/json,application/xml"
+			}
+			media := map[string]interface{}{}
+			if op.Response != nil {
+				media["schema"] = b.schema(reflect.TypeOf(op.Response))
+			}
+			content := map[string]interface{}{}
+			for _, t := range strings.Split(contentType, ",") {
+				content[t] = media
+			}
+			success["content"] = content
+		}
+		responses := map[string]interface{}{fmt.Sprint(status): success}
+		if len(op.Params) > 0 || op.Body != nil {
+			responses["422"] = map[string]interface{}{
+				"description": "Invalid fields or parameters",
+				"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": errorSchema}},
+			}
+		}
+		if op.Access != accessPublic {
+			responses["401"] = map[string]interface{}{"description": "Not signed in"}
+			responses["403"] = map[string]interface{}{"description": "Not allowed"}
+		}
+
+		operation := map[string]interface{}{
+			"summary":   op.Summary,
+			"tags":      []string{op.Tag},
+			"responses": responses,
+			"security":  securityFor(op.Access),
+		}
+		if note := accessNotes[op.Access]; note != "" {
+			operation["description"] = note
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if op.Body != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": b.schema(reflect.TypeOf(op.Body))},
+				},
+			}
+		}
+		if paths[op.Path] == nil {
+			paths[op.Path] = map[string]interface{}{}
+		}
+		paths[op.Path][op.Method] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Complaints API",
+			"version": envString("API_VERSION", "1.0.0"),
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": b.components,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"adminToken": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-Admin-Token"},
+				"apiKey":     map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+	}
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+)
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		var err error
+		if openAPIJSON, err = json.Marshal(buildOpenAPISpec()); err != nil {
+			log.Printf("build OpenAPI spec: %v", err)
+		}
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIJSON)
+}
+
+var docsPage = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Complaints API</title>
+<link rel="stylesheet" href="{{.}}/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="{{.}}/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`))
+
+// docsHandler serves Swagger UI for the spec. The UI's assets are loaded from
+// SWAGGER_UI_ASSETS, a swagger-ui-dist build, so nothing has to be vendored.
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	docsPage.Execute(w, envString("SWAGGER_UI_ASSETS", "https://unpkg.com/swagger-ui-dist@5"))
+}
+
+var registeredRoutes []string
+
+// handle registers a route and remembers its pattern so checkAPISpec can spot
+// routes missing from apiOperations.
+func handle(pattern string, h http.HandlerFunc) {
+	registeredRoutes = append(registeredRoutes, pattern)
+	http.HandleFunc(pattern, h)
+}
+
+// servedOperations lists the operations of the registered routes: the /api/v1
+// routes, plus the legacy ones unless LEGACY_ROUTES turned them off.
+func servedOperations() []apiOperation {
+	var ops []apiOperation
+	for _, op := range apiOperations {
+		if routeRegistered(op.Path) {
+			ops = append(ops, op)
+		}
+	}
+	return append(ops, v1Operations()...)
+}
+
+// routeRegistered reports whether a ServeMux pattern serves path. Patterns
+// ending in "/" serve everything below them.
+func routeRegistered(path string) bool {
+	for _, pattern := range registeredRoutes {
+		if pattern == path || strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAPISpec logs registered routes that the spec does not document, so a
+// new endpoint without an apiOperations entry is noticed at startup.
+func checkAPISpec() {
+	ops := servedOperations()
+	for _, pattern := range registeredRoutes {
+		found := false
+		for _, op := range ops {
+			found = found || op.Path == pattern || strings.HasSuffix(pattern, "/") && strings.HasPrefix(op.Path, pattern)
+		}
+		if !found {
+			log.Printf("route %s is missing from the OpenAPI spec", pattern)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+	"complain/store"
+)
+
+// orgIDPattern keeps organization IDs short slugs, since they appear in
+// tokens and ADMIN_TOKENS entries.
+var orgIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,39}$`)
+
+// orgInvite is a pending invitation to become an admin of an organization,
+// stored by token hash like email verifications.
+type orgInvite struct {
+	Hash      string    `bson:"_id" json:"-"`
+	OrgID     string    `bson:"orgId" json:"orgId"`
+	Email     string    `bson:"email" json:"email"`
+	InvitedBy string    `bson:"invitedBy" json:"invitedBy"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+}
+
+func findOrg(ctx context.Context, id string) (models.Organization, error) {
+	var org models.Organization
+	err := documents.Collection("organizations").FindOne(ctx, bson.M{"_id": id}).Decode(&org)
+	return org, err
+}
+
+// mayJoinOrg reports whether someone registering with email may join the
+// organization: it must exist and have invited the address or allow its
+// domain.
+func mayJoinOrg(ctx context.Context, orgID, email string) (bool, error) {
+	org, err := findOrg(ctx, orgID)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if slices.Contains(org.EmailDomains, email[strings.LastIndex(email, "@")+1:]) {
+		return true, nil
+	}
+	invites, err := documents.Collection("orgInvites").CountDocuments(ctx,
+		bson.M{"orgId": orgID, "email": email, "expiresAt": bson.M{"$gt": time.Now().UTC()}},
+		options.Count().SetLimit(1))
+	return invites > 0, err
+}
+
+// orgsHandler lists organizations (GET) and lets platform admins create them
+// (POST). Organization admins only see their own.
+func orgsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		filter := bson.M{}
+		if org, ok := callerOrg(r); ok {
+			filter["_id"] = org
+		}
+		cursor, err := documents.Collection("organizations").Find(dbContext(r), filter, options.Find().SetSort(bson.M{"name": 1}))
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		orgs := []models.Organization{}
+		if err := cursor.All(dbContext(r), &orgs); err != nil {
+			serverError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(orgs)
+
+	case http.MethodPost:
+		if !platformAdmin(r) {
+			http.Error(w, "Requires a platform admin", http.StatusForbidden)
+			return
+		}
+		var org models.Organization
+		if err := decodeJSON(r, &org); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var errs validationErrors
+		org.ID = strings.ToLower(strings.TrimSpace(org.ID))
+		if !orgIDPattern.MatchString(org.ID) {
+			errs.add("id", "must be 2 to 40 lowercase letters, digits or hyphens")
+		}
+		if org.Name = strings.TrimSpace(org.Name); org.Name == "" {
+			errs.add("name", "is required")
+		}
+		for i, domain := range org.EmailDomains {
+			org.EmailDomains[i] = strings.ToLower(strings.TrimSpace(domain))
+			if !strings.Contains(org.EmailDomains[i], ".") || strings.Contains(org.EmailDomains[i], "@") {
+				errs.add("emailDomains", "must be domains such as example.com")
+				break
+			}
+		}
+		if len(errs) > 0 {
+			writeValidationErrors(w, errs)
+			return
+		}
+		org.CreatedBy = actorName(r)
+		org.CreatedAt = time.Now().UTC()
+		_, err := documents.Collection("organizations").InsertOne(dbContext(r), org)
+		if mongo.IsDuplicateKeyError(err) {
+			http.Error(w, "Organization already exists", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(org)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+type inviteRequest struct {
+	Email string `json:"email"`
+}
+
+// inviteOrgAdminHandler emails a link to /acceptInvite that makes whoever
+// follows it an admin of the organization ?orgId=. Platform admins may
+// invite to any organization, organization admins only to their own. The
+// link is valid for ORG_INVITE_TTL.
+func inviteOrgAdminHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	orgID := r.URL.Query().Get("orgId")
+	if own, ok := callerOrg(r); ok && own != orgID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	org, err := findOrg(dbContext(r), orgID)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Organization not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	var req inviteRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		writeFieldError(w, "email", "must be a valid email address")
+		return
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		serverError(w, err)
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	ttl := envDuration("ORG_INVITE_TTL", 7*24*time.Hour)
+	invite := orgInvite{
+		Hash:      hashToken(token),
+		OrgID:     org.ID,
+		Email:     email,
+		InvitedBy: actorName(r),
+		ExpiresAt: time.Now().Add(ttl).UTC(),
+	}
+	if _, err := documents.Collection("orgInvites").InsertOne(dbContext(r), invite); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	link := strings.TrimRight(envString("PUBLIC_URL", "http://localhost:8080"), "/") + "/acceptInvite?token=" + url.QueryEscape(token)
+	go sendOrgInvite(requestLocale(r), invite, org, link, ttl)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(invite)
+}
+
+// sendOrgInvite writes in the inviter's language, since nothing is known yet
+// about the invitee's.
+func sendOrgInvite(locale string, invite orgInvite, org models.Organization, link string, ttl time.Duration) {
+	if notifier == nil {
+		log.Printf("invite to organization %s: no mailer configured", org.ID)
+		return
+	}
+	subject, body, err := renderNotification(locale, templateOrgInvite, notificationData{
+		OrgName:   org.Name,
+		Link:      link,
+		ExpiresIn: ttl.String(),
+	})
+	if err == nil {
+		err = notifier.Notify(invite.Email, subject, body)
+	}
+	if err != nil {
+		log.Printf("invite to organization %s: %v", org.ID, err)
+	}
+}
+
+type acceptInviteRequest struct {
+	Name string `json:"name"`
+}
+
+// acceptInviteHandler creates the invited admin's account from the token
+// ?token= and returns it with its secret code, like registration. The
+// address is taken as verified since the invitation reached it. Invitations
+// are single use.
+func acceptInviteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeFieldError(w, "token", "is required")
+		return
+	}
+	var req acceptInviteRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Name = strings.TrimSpace(req.Name); req.Name == "" {
+		writeFieldError(w, "name", "is required")
+		return
+	}
+
+	var invite orgInvite
+	err := documents.Collection("orgInvites").FindOne(dbContext(r),
+		bson.M{"_id": hashToken(token), "expiresAt": bson.M{"$gt": time.Now().UTC()}}).Decode(&invite)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Invalid or expired invitation", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	code, err := generateSecretCode()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	user := models.User{
+		ID:             primitive.NewObjectID(),
+		SecretCodeHash: hashToken(code),
+		Name:           req.Name,
+		Email:          invite.Email,
+		Complaints:     []primitive.ObjectID{},
+		Role:           roleAdmin,
+		OrgID:          invite.OrgID,
+		Verified:       true,
+		Locale:         requestLocale(r),
+		CreatedAt:      time.Now().UTC(),
+	}
+	user.UpdatedAt = user.CreatedAt
+	err = userStore.CreateUser(dbContext(r), user)
+	if errors.Is(err, store.ErrDuplicate) {
+		http.Error(w, "Email already registered", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if _, err := documents.Collection("orgInvites").DeleteOne(dbContext(r), bson.M{"_id": invite.Hash}); err != nil {
+		requestLogger(r).Error("consume organization invite", "org_id", invite.OrgID, "error", err)
+	}
+	recordAudit(r, auditAcceptInvite, auditTargetUser, user.ID, nil, user)
+
+	user.SecretCode = code
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+// The events published to the broker. The finer webhook event that caused
+// each is sent along as its change.
+const (
+	brokerComplaintCreated  = "complaint.created"
+	brokerComplaintUpdated  = "complaint.updated"
+	brokerComplaintResolved = "complaint.resolved"
+)
+
+var outboxPublishedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "outbox_events_published_total",
+	Help: "Outbox events published to the message broker, by result (published or failed).",
+}, []string{"result"})
+
+// outboxEvent is a broker event waiting in the outbox collection to be
+// published. It is written in the same transaction as the change it
+// reports and deleted once the broker has acknowledged it.
+type outboxEvent struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Event       string             `bson:"event"`
+	ComplaintID primitive.ObjectID `bson:"complaintId"`
+	Payload     []byte             `bson:"payload"`
+	CreatedAt   time.Time          `bson:"createdAt"`
+	Attempts    int                `bson:"attempts,omitempty"`
+	LastError   string             `bson:"lastError,omitempty"`
+}
+
+type brokerPayload struct {
+	ID         string           `json:"id"`
+	Event      string           `json:"event"`
+	Change     string           `json:"change"`
+	OccurredAt time.Time        `json:"occurredAt"`
+	Complaint  models.Complaint `json:"complaint"`
+}
+
+// eventBroker is where outbox events are published. Publish returns once
+// the broker has durably accepted the message.
+type eventBroker interface {
+	Publish(ctx context.Context, event outboxEvent) error
+	Close() error
+}
+
+// broker is nil unless EVENT_BROKER is set; without one nothing is written
+// to the outbox.
+var broker eventBroker
+
+// initBroker connects to the broker named by EVENT_BROKER: nats, which
+// publishes to the JetStream subject EVENT_TOPIC (a stream must capture
+// it), or kafka, which writes to the topic EVENT_TOPIC keyed by complaint,
+// so each complaint's events stay in order. EVENT_BROKER_URL lists the
+// servers, comma-separated.
+func initBroker() {
+	kind := envString("EVENT_BROKER", "")
+	topic := envString("EVENT_TOPIC", "complaints")
+	urls := envString("EVENT_BROKER_URL", "")
+	switch kind {
+	case "":
+		return
+	case "nats":
+		if urls == "" {
+			urls = nats.DefaultURL
+		}
+		nc, err := nats.Connect(urls, nats.Name("complain"), nats.MaxReconnects(-1))
+		if err != nil {
+			log.Fatalf("EVENT_BROKER_URL: %v", err)
+		}
+		js, err := jetstream.New(nc)
+		if err != nil {
+			log.Fatalf("EVENT_BROKER: %v", err)
+		}
+		broker = natsBroker{conn: nc, js: js, subject: topic}
+	case "kafka":
+		if urls == "" {
+			urls = "localhost:9092"
+		}
+		broker = kafkaBroker{writer: &kafka.Writer{
+			Addr:         kafka.TCP(splitList(urls)...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			BatchTimeout: 10 * time.Millisecond,
+		}}
+	default:
+		log.Fatalf("EVENT_BROKER: unknown broker %q", kind)
+	}
+	log.Printf("publishing complaint events to %s %s", kind, topic)
+}
+
+type natsBroker struct {
+	conn    *nats.Conn
+	js      jetstream.JetStream
+	subject string
+}
+
+// Publish sets the event ID as the message ID, so JetStream drops a
+// redelivery that arrives within the stream's duplicate window.
+func (b natsBroker) Publish(ctx context.Context, event outboxEvent) error {
+	_, err := b.js.Publish(ctx, b.subject, event.Payload, jetstream.WithMsgID(event.ID.Hex()))
+	return err
+}
+
+func (b natsBroker) Close() error {
+	return b.conn.Drain()
+}
+
+type kafkaBroker struct {
+	writer *kafka.Writer
+}
+
+func (b kafkaBroker) Publish(ctx context.Context, event outboxEvent) error {
+	return b.writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(event.ComplaintID.Hex()),
+		Value: event.Payload,
+		Headers: []kafka.Header{
+			{Key: "event-id", Value: []byte(event.ID.Hex())},
+			{Key: "event", Value: []byte(event.Event)},
+		},
+	})
+}
+
+func (b kafkaBroker) Close() error {
+	return b.writer.Close()
+}
+
+// outboxEntry builds the outbox event for a change, given as one of the
+// webhook events, to the complaint as it stands after the change.
+func outboxEntry(change string, c models.Complaint) outboxEvent {
+	event := brokerComplaintUpdated
+	switch change {
+	case eventComplaintCreated:
+		event = brokerComplaintCreated
+	case eventComplaintResolved:
+		event = brokerComplaintResolved
+	}
+	entry := outboxEvent{ID: primitive.NewObjectID(), Event: event, ComplaintID: c.ID, CreatedAt: time.Now().UTC()}
+	entry.Payload, _ = json.Marshal(brokerPayload{
+		ID:         entry.ID.Hex(),
+		Event:      event,
+		Change:     change,
+		OccurredAt: entry.CreatedAt,
+		Complaint:  c,
+	})
+	return entry
+}
+
+// withOutbox runs write in a transaction that also stores the outbox events
+// it returns, so an event is published exactly when its change commits. A
+// write that changes nothing returns no events. write may run more than
+// once, as transactions are retried on transient errors. The outbox lives
+// in the store with the complaints, so this holds for PostgreSQL as for
+// MongoDB. A standalone mongod has no transactions; there the events are
+// stored right after the write, and a crash in between loses them.
+func withOutbox(ctx context.Context, write func(ctx context.Context) ([]outboxEvent, error)) error {
+	if broker == nil {
+		_, err := write(ctx)
+		return err
+	}
+	return inTransaction(ctx, func(ctx context.Context) error {
+		return writeOutbox(ctx, write)
+	})
+}
+
+// inTransaction runs fn in a transaction of the store, or in the caller's
+// if ctx already carries one. fn is retried on transient errors.
+func inTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return documents.InTransaction(ctx, fn)
+}
+
+func writeOutbox(ctx context.Context, write func(ctx context.Context) ([]outboxEvent, error)) error {
+	events, err := write(ctx)
+	if err != nil || len(events) == 0 {
+		return err
+	}
+	docs := make([]interface{}, len(events))
+	for i, e := range events {
+		docs[i] = e
+	}
+	_, err = documents.Collection("outbox").InsertMany(ctx, docs)
+	return err
+}
+
+// registerOutboxPublisher publishes the outbox every OUTBOX_PUBLISH_INTERVAL.
+// Being a job, it runs on one instance at a time.
+func registerOutboxPublisher() {
+	if broker == nil {
+		return
+	}
+	registerJob("outbox-publisher", envDuration("OUTBOX_PUBLISH_INTERVAL", 5*time.Second), publishOutbox)
+}
+
+// publishOutbox publishes waiting events oldest first and deletes each once
+// the broker has it, so delivery is at least once: an event whose deletion
+// fails is published again on the next run, and consumers deduplicate by
+// event ID. It stops at the first failure so events are not published out
+// of order, and leaves the rest for the next run.
+func publishOutbox(ctx context.Context) error {
+	for {
+		cursor, err := documents.Collection("outbox").Find(ctx, bson.M{},
+			options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(envInt("OUTBOX_BATCH_SIZE", 100))))
+		if err != nil {
+			return err
+		}
+		var events []outboxEvent
+		if err := cursor.All(ctx, &events); err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		for _, e := range events {
+			pubCtx, cancel := context.WithTimeout(ctx, envDuration("EVENT_PUBLISH_TIMEOUT", 10*time.Second))
+			err := broker.Publish(pubCtx, e)
+			cancel()
+			if err != nil {
+				outboxPublishedTotal.WithLabelValues("failed").Inc()
+				documents.Collection("outbox").UpdateOne(ctx, bson.M{"_id": e.ID},
+					bson.M{"$inc": bson.M{"attempts": 1}, "$set": bson.M{"lastError": err.Error()}})
+				return fmt.Errorf("publish %s %s: %w", e.Event, e.ID.Hex(), err)
+			}
+			outboxPublishedTotal.WithLabelValues("published").Inc()
+			if _, err := documents.Collection("outbox").DeleteOne(ctx, bson.M{"_id": e.ID}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// closeBroker flushes and closes the broker connection on shutdown.
+func closeBroker() {
+	if broker == nil {
+		return
+	}
+	if err := broker.Close(); err != nil {
+		log.Printf("close event broker: %v", err)
+	}
+}
+
+// updateComplaint applies update to the complaint matching filter, decodes
+// the result into complaint and records change in the outbox with it. It
+// returns mongo.ErrNoDocuments when nothing matched.
+func updateComplaint(ctx context.Context, change string, filter, update bson.M, complaint *models.Complaint) error {
+	return withOutbox(ctx, func(ctx context.Context) ([]outboxEvent, error) {
+		err := orgCollection("complaints").FindOneAndUpdate(ctx, filter, update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(complaint)
+		if err != nil {
+			return nil, err
+		}
+		return []outboxEvent{outboxEntry(change, *complaint)}, nil
+	})
+}
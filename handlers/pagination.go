@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// adminSortFields maps the sort query values to document fields.
+var adminSortFields = map[string]string{
+	"createdAt": "createdAt",
+	"updatedAt": "updatedAt",
+	"rating":    "rating",
+	"status":    "status",
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// pageCursor marks the last document of a page. It remembers the sort it was
+// issued for so it cannot be replayed against a different ordering.
+type pageCursor struct {
+	Sort  string             `bson:"s"`
+	Desc  bool               `bson:"d"`
+	Value interface{}        `bson:"v"`
+	ID    primitive.ObjectID `bson:"id"`
+}
+
+func (c pageCursor) encode() (string, error) {
+	raw, err := bson.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeCursor(s, sort string, desc bool) (pageCursor, error) {
+	var c pageCursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || bson.Unmarshal(raw, &c) != nil {
+		return c, errInvalidCursor
+	}
+	if c.Sort != sort || c.Desc != desc {
+		return c, errInvalidCursor
+	}
+	return c, nil
+}
+
+// after matches the documents that come after the cursor in (field, _id)
+// order. Missing values sort before everything ascending and after
+// everything descending, as in MongoDB, but comparison operators never match
+// them, so they get explicit clauses.
+func (c pageCursor) after(field string) bson.M {
+	cmp := "$gt"
+	if c.Desc {
+		cmp = "$lt"
+	}
+	tie := bson.M{field: c.Value, "_id": bson.M{cmp: c.ID}}
+	switch {
+	case c.Value == nil && c.Desc:
+		return tie
+	case c.Value == nil:
+		return bson.M{"$or": bson.A{bson.M{field: bson.M{"$ne": nil}}, tie}}
+	case c.Desc:
+		return bson.M{"$or": bson.A{bson.M{field: bson.M{cmp: c.Value}}, tie, bson.M{field: nil}}}
+	default:
+		return bson.M{"$or": bson.A{bson.M{field: bson.M{cmp: c.Value}}, tie}}
+	}
+}
+
+// sortValue returns the value of field in a raw document, or nil if missing.
+func sortValue(doc bson.Raw, field string) interface{} {
+	v, err := doc.LookupErr(field)
+	if err != nil {
+		return nil
+	}
+	var out interface{}
+	if v.Unmarshal(&out) != nil {
+		return nil
+	}
+	return out
+}
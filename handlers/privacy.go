@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+// erasedActor replaces an erased user's email wherever it was recorded as the
+// author of an action.
+const erasedActor = "erased user"
+
+type dataExport struct {
+	ExportedAt time.Time          `json:"exportedAt"`
+	Profile    models.User        `json:"profile"`
+	Complaints []models.Complaint `json:"complaints"`
+	// Comments holds every comment on the user's complaints and every
+	// comment the user wrote elsewhere.
+	Comments []models.Comment `json:"comments"`
+	// ArchivedComplaints holds the user's complaints the retention job has
+	// archived, with their public comments.
+	ArchivedComplaints []models.ArchivedComplaint `json:"archivedComplaints"`
+}
+
+type erasureSchedule struct {
+	ErasureScheduledFor time.Time `json:"erasureScheduledFor"`
+}
+
+// exportMyDataHandler returns everything stored about the caller as one JSON
+// download, soft-deleted complaints included.
+func exportMyDataHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := currentUserID(r)
+	user, err := userStore.UserByID(dbContext(r), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	export := dataExport{ExportedAt: time.Now().UTC(), Profile: user, Complaints: []models.Complaint{}, Comments: []models.Comment{}, ArchivedComplaints: []models.ArchivedComplaint{}}
+	cursor, err := orgCollection("complaints").Find(dbContext(r), bson.M{"userId": userID},
+		options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if err := cursor.All(dbContext(r), &export.Complaints); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	ids := make([]primitive.ObjectID, len(export.Complaints))
+	for i, c := range export.Complaints {
+		ids[i] = c.ID
+	}
+	cursor, err = documents.Collection("comments").Find(dbContext(r),
+		bson.M{"$or": bson.A{bson.M{"complaintId": bson.M{"$in": ids}}, bson.M{"author": user.Email}}, "visibility": bson.M{"$ne": models.CommentInternal}},
+		options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if err := cursor.All(dbContext(r), &export.Comments); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	cursor, err = orgCollection("complaints_archive").Find(dbContext(r), bson.M{"userId": userID},
+		options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if err := cursor.All(dbContext(r), &export.ArchivedComplaints); err != nil {
+		serverError(w, err)
+		return
+	}
+	for i, c := range export.ArchivedComplaints {
+		public := []models.Comment{}
+		for _, comment := range c.Comments {
+			if comment.Visibility != models.CommentInternal {
+				public = append(public, comment)
+			}
+		}
+		export.ArchivedComplaints[i].Comments = public
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="data-export-%s.json"`, userID.Hex()))
+	json.NewEncoder(w).Encode(export)
+}
+
+// deleteAccountHandler schedules the caller's account for erasure once
+// ACCOUNT_ERASURE_GRACE_PERIOD has passed. Until then the user can still sign
+// in and cancel. Asking again keeps the original date.
+func deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := currentUserID(r)
+	var before models.User
+	if err := orgCollection("users").FindOne(dbContext(r), bson.M{"_id": userID}).Decode(&before); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	at := time.Now().Add(envDuration("ACCOUNT_ERASURE_GRACE_PERIOD", 30*24*time.Hour)).UTC()
+	var user models.User
+	err := orgCollection("users").FindOneAndUpdate(dbContext(r),
+		bson.M{"_id": userID},
+		bson.M{"$min": bson.M{"erasureScheduledFor": at}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	recordAudit(r, auditRequestErasure, auditTargetUser, userID, before, user)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(erasureSchedule{ErasureScheduledFor: *user.ErasureScheduledFor})
+}
+
+// cancelAccountDeletionHandler undoes deleteAccountHandler while the grace
+// period is still running.
+func cancelAccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := currentUserID(r)
+	var before models.User
+	err := orgCollection("users").FindOneAndUpdate(dbContext(r),
+		bson.M{"_id": userID, "erasureScheduledFor": bson.M{"$gt": time.Now()}, "erasing": bson.M{"$ne": true}},
+		bson.M{"$unset": bson.M{"erasureScheduledFor": ""}},
+	).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "No pending account deletion", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	after := before
+	after.ErasureScheduledFor = nil
+	recordAudit(r, auditCancelErasure, auditTargetUser, userID, before, after)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// eraseDueAccounts erases accounts whose grace period has ended. Each one is
+// claimed by setting erasing, which also ends the undo window; a claimed
+// account whose erasure failed part way is picked up again once the claim is
+// older than retryAfter.
+func eraseDueAccounts(ctx context.Context, retryAfter time.Duration) error {
+	for {
+		var user models.User
+		err := orgCollection("users").FindOneAndUpdate(ctx,
+			bson.M{"$or": bson.A{
+				bson.M{"erasureScheduledFor": bson.M{"$lte": time.Now()}, "erasing": bson.M{"$ne": true}},
+				bson.M{"erasing": true, "erasingSince": bson.M{"$lt": time.Now().Add(-retryAfter)}},
+			}},
+			bson.M{"$set": bson.M{"erasing": true, "erasingSince": time.Now().UTC()}},
+		).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("claim account for erasure: %w", err)
+		}
+		if err := eraseAccount(ctx, user); err != nil {
+			log.Printf("erase account %s: %v", user.ID.Hex(), err)
+			continue
+		}
+		log.Printf("erased account %s", user.ID.Hex())
+	}
+}
+
+// eraseAccount detaches the user's complaints, live and archived, which are
+// kept anonymously, replaces their email wherever it names them as an actor,
+// and deletes the account. Complaint titles and summaries are the user's own
+// text and are left as written. Every step is idempotent so a failed erasure
+// can be retried.
+func eraseAccount(ctx context.Context, user models.User) error {
+	complaints := documents.Collection("complaints")
+	archive := documents.Collection("complaints_archive")
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"detach complaints", func() error {
+			_, err := complaints.UpdateMany(ctx, bson.M{"userId": user.ID}, bson.M{
+				"$set":   bson.M{"userId": primitive.NilObjectID},
+				"$unset": bson.M{"followUpAt": "", "idempotencyKey": ""},
+			})
+			return err
+		}},
+		{"detach archived complaints", func() error {
+			_, err := archive.UpdateMany(ctx, bson.M{"userId": user.ID}, bson.M{
+				"$set":   bson.M{"userId": primitive.NilObjectID},
+				"$unset": bson.M{"followUpAt": "", "idempotencyKey": ""},
+			})
+			return err
+		}},
+		{"detach feedback", func() error {
+			_, err := documents.Collection("feedback").UpdateMany(ctx, bson.M{"userId": user.ID}, bson.M{"$set": bson.M{"userId": primitive.NilObjectID}})
+			return err
+		}},
+		{"remove watches", func() error {
+			if _, err := complaints.UpdateMany(ctx, bson.M{"watchers": user.ID}, bson.M{"$pull": bson.M{"watchers": user.ID}}); err != nil {
+				return err
+			}
+			_, err := archive.UpdateMany(ctx, bson.M{"watchers": user.ID}, bson.M{"$pull": bson.M{"watchers": user.ID}})
+			return err
+		}},
+		{"anonymize history", func() error {
+			return replaceInArray(ctx, complaints, "history", "changedBy", user.Email)
+		}},
+		{"anonymize attachments", func() error {
+			return replaceInArray(ctx, complaints, "attachments", "uploadedBy", user.Email)
+		}},
+		{"anonymize revisions", func() error {
+			_, err := documents.Collection("complaint_revisions").UpdateMany(ctx, bson.M{"editedBy": user.Email}, bson.M{"$set": bson.M{"editedBy": erasedActor}})
+			return err
+		}},
+		{"anonymize comments", func() error {
+			_, err := documents.Collection("comments").UpdateMany(ctx, bson.M{"author": user.Email}, bson.M{"$set": bson.M{"author": erasedActor}})
+			return err
+		}},
+		{"anonymize archive", func() error {
+			for _, f := range [][2]string{{"history", "changedBy"}, {"attachments", "uploadedBy"}, {"comments", "author"}, {"revisions", "editedBy"}} {
+				if err := replaceInArray(ctx, archive, f[0], f[1], user.Email); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{"anonymize audit logs", func() error {
+			if _, err := documents.Collection("audit_logs").UpdateMany(ctx, bson.M{"actor": user.Email}, bson.M{"$set": bson.M{"actor": erasedActor}}); err != nil {
+				return err
+			}
+			_, err := documents.Collection("audit_logs").UpdateMany(ctx,
+				bson.M{"targetType": auditTargetUser, "targetId": user.ID},
+				bson.M{"$unset": bson.M{"changes": ""}})
+			return err
+		}},
+		{"revoke refresh tokens", func() error {
+			_, err := documents.Collection("refreshTokens").DeleteMany(ctx, bson.M{"userId": user.ID})
+			return err
+		}},
+		{"revoke sessions", func() error {
+			_, err := revokeSessions(ctx, bson.M{"userId": user.ID})
+			return err
+		}},
+		{"delete notifications", func() error {
+			_, err := documents.Collection("notifications").DeleteMany(ctx, bson.M{"userId": user.ID})
+			return err
+		}},
+		{"delete phone verifications", func() error {
+			_, err := documents.Collection("phoneVerifications").DeleteOne(ctx, bson.M{"_id": user.ID})
+			return err
+		}},
+		{"delete email verifications", func() error {
+			_, err := documents.Collection("emailVerifications").DeleteMany(ctx, bson.M{"userId": user.ID})
+			return err
+		}},
+		{"delete avatar", func() error {
+			if user.AvatarID == nil {
+				return nil
+			}
+			bucket, err := avatarsBucket()
+			if err == nil {
+				err = bucket.Delete(*user.AvatarID)
+			}
+			if err == gridfs.ErrFileNotFound {
+				return nil
+			}
+			return err
+		}},
+		{"delete user", func() error {
+			_, err := documents.Collection("users").DeleteOne(ctx, bson.M{"_id": user.ID})
+			return err
+		}},
+	}
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			return fmt.Errorf("%s: %w", step.name, err)
+		}
+	}
+	return nil
+}
+
+// manyUpdater is the part of a collection replaceInArray needs, met by the
+// store's complaints and by Mongo's archive alike.
+type manyUpdater interface {
+	UpdateMany(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+}
+
+// replaceInArray sets field to erasedActor in every element of array whose
+// field is email.
+func replaceInArray(ctx context.Context, coll manyUpdater, array, field, email string) error {
+	_, err := coll.UpdateMany(ctx,
+		bson.M{array + "." + field: email},
+		bson.M{"$set": bson.M{array + ".$[e]." + field: erasedActor}},
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: bson.A{bson.M{"e." + field: email}}}))
+	return err
+}
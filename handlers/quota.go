@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"complain/models"
+)
+
+// submissionQuotas is what a user may still file before a submission: open
+// complaints under MAX_OPEN_COMPLAINTS_PER_USER and submissions in the last
+// 24 hours under MAX_SUBMISSIONS_PER_DAY. A quota whose limit is not
+// configured is nil.
+type submissionQuotas struct {
+	Open  *int
+	Daily *int
+	// DailyResetIn is how long until the oldest submission in the window
+	// stops counting, set once the daily quota is used up.
+	DailyResetIn time.Duration
+}
+
+func submissionQuota(ctx context.Context, userID primitive.ObjectID) (submissionQuotas, error) {
+	var q submissionQuotas
+	if limit := envInt("MAX_OPEN_COMPLAINTS_PER_USER", 0); limit > 0 {
+		total, resolved, err := complaintStore.CountComplaints(ctx, userID)
+		if err != nil {
+			return q, err
+		}
+		remaining := max(limit-int(total-resolved), 0)
+		q.Open = &remaining
+	}
+	if limit := envInt("MAX_SUBMISSIONS_PER_DAY", 0); limit > 0 {
+		since := time.Now().Add(-24 * time.Hour)
+		recent, err := complaintStore.ComplaintsSince(ctx, userID, since)
+		if err != nil {
+			return q, err
+		}
+		remaining := max(limit-len(recent), 0)
+		q.Daily = &remaining
+		if remaining == 0 {
+			oldest := time.Now()
+			for _, c := range recent {
+				if c.CreatedAt.Before(oldest) {
+					oldest = c.CreatedAt
+				}
+			}
+			q.DailyResetIn = oldest.Sub(since)
+		}
+	}
+	return q, nil
+}
+
+// exceeded explains which quota blocks a submission, or returns "" if none
+// does.
+func (q submissionQuotas) exceeded() string {
+	switch {
+	case q.Open != nil && *q.Open == 0:
+		return "Open complaint limit reached, please wait for existing complaints to be resolved"
+	case q.Daily != nil && *q.Daily == 0:
+		return fmt.Sprintf("Daily limit of %d submissions reached, please try again in %s",
+			envInt("MAX_SUBMISSIONS_PER_DAY", 0), q.DailyResetIn.Round(time.Minute))
+	}
+	return ""
+}
+
+// setQuota fills in the quota fields of a submission response with what is
+// left after that submission.
+func setQuota(c *models.Complaint, q submissionQuotas) {
+	margin := envInt("QUOTA_WARNING_MARGIN", 2)
+	if q.Open != nil {
+		remaining := *q.Open - 1
+		c.RemainingQuota = &remaining
+		c.QuotaWarning = c.QuotaWarning || remaining <= margin
+	}
+	if q.Daily != nil {
+		remaining := *q.Daily - 1
+		c.RemainingDailyQuota = &remaining
+		c.QuotaWarning = c.QuotaWarning || remaining <= margin
+	}
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+var rateLimitedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "rate_limited_requests_total",
+	Help: "Requests rejected with 429, by limiter.",
+}, []string{"limiter"})
+
+// limitStore holds token buckets. take removes a token from the named bucket
+// if one is available and otherwise reports how long until one will be.
+type limitStore interface {
+	take(key string, rate, burst float64) (ok bool, retryAfter time.Duration)
+}
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// memoryLimitStore keeps buckets in process, which is exact for a single
+// instance.
+type memoryLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func (s *memoryLimitStore) take(key string, rate, burst float64) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst}
+		s.buckets[key] = b
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.lastSeen).Seconds()*rate)
+	}
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// cleanup drops buckets idle for longer than a bucket takes to refill, since
+// a new bucket would behave identically.
+func (s *memoryLimitStore) cleanup(every, idle time.Duration) {
+	for range time.Tick(every) {
+		s.mu.Lock()
+		for key, b := range s.buckets {
+			if time.Since(b.lastSeen) >= idle {
+				delete(s.buckets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// takeScript runs the token bucket inside Redis so that every instance draws
+// from the same bucket. Redis's clock is used so instances need not agree on
+// the time.
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(b[1]) or burst
+local ts = tonumber(b[2]) or now
+tokens = math.min(burst, tokens + (now - ts) * rate)
+local ok = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  ok = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('EXPIRE', KEYS[1], math.ceil(burst / rate) + 1)
+local wait = 0
+if ok == 0 then wait = (1 - tokens) / rate end
+return {ok, tostring(wait)}
+`)
+
+type redisLimitStore struct {
+	client *redis.Client
+}
+
+// take fails open: if Redis is unreachable requests are let through, since
+// refusing all traffic is worse than briefly not limiting it.
+func (s redisLimitStore) take(key string, rate, burst float64) (bool, time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	res, err := takeScript.Run(ctx, s.client, []string{"ratelimit:" + key},
+		strconv.FormatFloat(rate, 'f', -1, 64), strconv.FormatFloat(burst, 'f', -1, 64)).Slice()
+	if err != nil || len(res) != 2 {
+		log.Printf("rate limit %s: %v", key, err)
+		return true, 0
+	}
+	if ok, _ := res[0].(int64); ok == 1 {
+		return true, 0
+	}
+	waitText, _ := res[1].(string)
+	wait, _ := strconv.ParseFloat(waitText, 64)
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+var (
+	limits   limitStore
+	limiters []*rateLimiter
+)
+
+// initRateLimits picks the bucket store, once every limiter has been created.
+// With RATE_LIMIT_REDIS_URL set, limits are shared by every instance using
+// that Redis; otherwise each instance limits on its own.
+func initRateLimits() {
+	if u := envString("RATE_LIMIT_REDIS_URL", ""); u != "" {
+		opts, err := redis.ParseURL(u)
+		if err != nil {
+			log.Fatalf("RATE_LIMIT_REDIS_URL: %v", err)
+		}
+		limits = redisLimitStore{client: redis.NewClient(opts)}
+		return
+	}
+	// Buckets from every limiter share the map, so the slowest refill decides
+	// when a bucket is safe to drop.
+	var idle time.Duration
+	for _, l := range limiters {
+		if d := time.Duration(l.burst / l.rate * float64(time.Second)); d > idle {
+			idle = d
+		}
+	}
+	memory := &memoryLimitStore{buckets: make(map[string]*bucket)}
+	limits = memory
+	go memory.cleanup(envDuration("RATE_LIMIT_CLEANUP", envDuration("AUTH_RATE_LIMIT_CLEANUP", 5*time.Minute)), idle)
+}
+
+// rateLimiter allows perMinute requests per key per minute, in bursts of up
+// to perMinute. A limit of 0 or less disables it.
+type rateLimiter struct {
+	name  string
+	rate  float64 // tokens per second
+	burst float64
+}
+
+func newRateLimiter(name string, perMinute int) *rateLimiter {
+	l := &rateLimiter{name: name, rate: float64(perMinute) / 60, burst: float64(perMinute)}
+	if perMinute > 0 {
+		limiters = append(limiters, l)
+	}
+	return l
+}
+
+// reserve takes a token from key's bucket, or says how long until there is
+// one.
+func (l *rateLimiter) reserve(key string) (bool, time.Duration) {
+	ok, retryAfter := limits.take(l.name+":"+key, l.rate, l.burst)
+	if !ok {
+		rateLimitedTotal.WithLabelValues(l.name).Inc()
+	}
+	return ok, retryAfter
+}
+
+func (l *rateLimiter) allow(w http.ResponseWriter, key string) bool {
+	ok, retryAfter := l.reserve(key)
+	if ok {
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+	return false
+}
+
+// limit keys the bucket by client IP, for routes callers reach before they
+// have an identity.
+func (l *rateLimiter) limit(next http.HandlerFunc) http.HandlerFunc {
+	if l.burst <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if l.allow(w, "ip:"+clientIP(r)) {
+			next(w, r)
+		}
+	}
+}
+
+// limitCaller keys the bucket by the authenticated user or staff member,
+// falling back to the client IP, so users behind one NAT do not share a
+// bucket.
+func (l *rateLimiter) limitCaller(next http.HandlerFunc) http.HandlerFunc {
+	if l.burst <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if l.allow(w, callerKey(r)) {
+			next(w, r)
+		}
+	}
+}
+
+// withRateLimit applies limiter to every request except health probes and
+// metrics scrapes.
+func withRateLimit(limiter *rateLimiter, next http.Handler) http.Handler {
+	limited := limiter.limitCaller(next.ServeHTTP)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz", "/readyz", "/metrics":
+			next.ServeHTTP(w, r)
+		default:
+			limited(w, r)
+		}
+	})
+}
+
+func callerKey(r *http.Request) string {
+	if id, ok := currentUserID(r); ok {
+		return "user:" + id.Hex()
+	}
+	if staff, ok := staffIdentity(r); ok {
+		return "staff:" + staff.Name
+	}
+	return "ip:" + clientIP(r)
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
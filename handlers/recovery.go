@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var httpPanicsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "http_panics_total",
+	Help: "Handler panics recovered, by route.",
+}, []string{"path"})
+
+// panicRecorder notes whether the handler started its response, since a
+// 500 can only be sent if it has not.
+type panicRecorder struct {
+	*statusRecorder
+	wrote bool
+}
+
+func (p *panicRecorder) WriteHeader(code int) {
+	p.wrote = true
+	p.statusRecorder.WriteHeader(code)
+}
+
+func (p *panicRecorder) Write(b []byte) (int, error) {
+	p.wrote = true
+	return p.statusRecorder.Write(b)
+}
+
+// withRecovery turns a panicking handler into a 500 carrying the request
+// ID, which the log line with the panic and its stack also has, so the
+// instance keeps serving and the failure can be traced. The panic value
+// stays out of the response. It sits inside withRequestLog, which assigns
+// the ID. http.ErrAbortHandler is passed on, as it is how a handler asks
+// the server to drop the connection.
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &panicRecorder{statusRecorder: &statusRecorder{ResponseWriter: w, status: http.StatusOK}}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			httpPanicsTotal.WithLabelValues(routePattern(r)).Inc()
+			requestLogger(r).Error("panic", "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+			if rec.wrote {
+				// Too late for a clean error; drop the connection so the
+				// client does not take a truncated body for a whole one.
+				panic(http.ErrAbortHandler)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Del("Content-Length")
+			w.Header().Del("Content-Encoding")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(struct {
+				Error     string `json:"error"`
+				RequestID string `json:"requestId"`
+			}{"Internal server error", requestID(r.Context())})
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
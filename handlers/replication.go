@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+
+	"complain/store"
+)
+
+// listingDB is db with MONGO_LISTING_READ_PREFERENCE, secondaryPreferred by
+// default, for listings, searches, exports and stats. Those tolerate
+// replication lag and are the heaviest reads, so they go to secondaries
+// when there are any; everything else reads from the primary.
+var listingDB *mongo.Database
+
+// listingCollection is orgCollection reading with the listing preference.
+func listingCollection(name string) store.Collection {
+	if _, onMongo := documents.(*store.Mongo); !onMongo {
+		return documents.OrgCollection(name)
+	}
+	return store.Scope(listingDB.Collection(name))
+}
+
+// readPreference parses one of the read preference settings. Max staleness
+// only applies to modes that may read from a secondary.
+func readPreference(name, fallback string) *readpref.ReadPref {
+	mode, err := readpref.ModeFromString(envString(name, fallback))
+	if err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+	var opts []readpref.Option
+	if staleness := envDuration("MONGO_MAX_STALENESS", 0); staleness > 0 && mode != readpref.PrimaryMode {
+		opts = append(opts, readpref.WithMaxStaleness(staleness))
+	}
+	pref, err := readpref.New(mode, opts...)
+	if err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+	return pref
+}
+
+// writeConcern is MONGO_WRITE_CONCERN, "majority" by default so an
+// acknowledged mutation survives a failover, or a number of members.
+func writeConcern() *writeconcern.WriteConcern {
+	wc := &writeconcern.WriteConcern{W: "majority", WTimeout: envDuration("MONGO_WRITE_TIMEOUT", 10*time.Second)}
+	if v := envString("MONGO_WRITE_CONCERN", "majority"); v != "majority" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("MONGO_WRITE_CONCERN: %v", err)
+		}
+		wc.W = n
+	}
+	return wc
+}
+
+// replicationOptions applies the read preference, read concern and write
+// concern settings to the client. An unset read preference or read concern
+// keeps whatever the connection string asks for; the write concern always
+// applies, overriding any w in the connection string.
+func replicationOptions(opts *options.ClientOptions) *options.ClientOptions {
+	if envString("MONGO_READ_PREFERENCE", "") != "" {
+		opts.SetReadPreference(readPreference("MONGO_READ_PREFERENCE", "primary"))
+	}
+	if level := envString("MONGO_READ_CONCERN", ""); level != "" {
+		opts.SetReadConcern(readconcern.New(readconcern.Level(level)))
+	}
+	return opts.SetWriteConcern(writeConcern())
+}
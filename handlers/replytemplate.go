@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+var errUnknownTemplate = errors.New("is not a known template")
+
+type replyTemplateRequest struct {
+	Name string `json:"name"`
+	Body string `json:"body"`
+}
+
+// replyTemplatesHandler lists canned responses (GET) to staff and lets
+// platform admins create (POST), update (PUT ?id=) and remove (DELETE ?id=)
+// them; every organization shares them.
+func replyTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && !platformAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		cursor, err := documents.Collection("templates").Find(dbContext(r), bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		templates := []models.ReplyTemplate{}
+		if err := cursor.All(dbContext(r), &templates); err != nil {
+			serverError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(templates)
+	case http.MethodPost, http.MethodPut:
+		saveReplyTemplate(w, r)
+	case http.MethodDelete:
+		oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+		if err != nil {
+			writeFieldError(w, "id", "must be a valid ID")
+			return
+		}
+		res, err := documents.Collection("templates").DeleteOne(dbContext(r), bson.M{"_id": oid})
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if res.DeletedCount == 0 {
+			http.Error(w, "Template not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func saveReplyTemplate(w http.ResponseWriter, r *http.Request) {
+	var req replyTemplateRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var errs validationErrors
+	var oid primitive.ObjectID
+	if r.Method == http.MethodPut {
+		var err error
+		if oid, err = primitive.ObjectIDFromHex(r.URL.Query().Get("id")); err != nil {
+			errs.add("id", "must be a valid ID")
+		}
+	}
+	name, body := strings.TrimSpace(req.Name), strings.TrimSpace(req.Body)
+	if name == "" {
+		errs.add("name", "is required")
+	}
+	if body == "" {
+		errs.add("body", "is required")
+	} else if utf8.RuneCountInString(body) > envInt("COMMENT_MAX_LENGTH", 5000) {
+		errs.add("body", "is too long")
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	now := time.Now().UTC()
+	if r.Method == http.MethodPost {
+		admin, _ := adminIdentity(r)
+		template := models.ReplyTemplate{ID: primitive.NewObjectID(), Name: name, Body: body,
+			CreatedBy: admin, CreatedAt: now, UpdatedAt: now}
+		if _, err := documents.Collection("templates").InsertOne(dbContext(r), template); err != nil {
+			serverError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(template)
+		return
+	}
+
+	var template models.ReplyTemplate
+	err := documents.Collection("templates").FindOneAndUpdate(dbContext(r),
+		bson.M{"_id": oid},
+		bson.M{"$set": bson.M{"name": name, "body": body, "updatedAt": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&template)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(template)
+}
+
+// applyReplyTemplate renders the template with the given ID for a complaint.
+// {{userName}}, {{complaintTitle}}, {{reference}} and {{agentName}} are
+// replaced; anything else is left as written. An ID that is malformed or not
+// found is errUnknownTemplate.
+func applyReplyTemplate(ctx context.Context, id string, c models.Complaint, agent string) (string, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return "", errUnknownTemplate
+	}
+	var template models.ReplyTemplate
+	err = documents.Collection("templates").FindOne(ctx, bson.M{"_id": oid}).Decode(&template)
+	if err == mongo.ErrNoDocuments {
+		return "", errUnknownTemplate
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// A reporter whose account is gone is greeted without a name.
+	var user models.User
+	if err := orgCollection("users").FindOne(ctx, bson.M{"_id": c.UserID}).Decode(&user); err != nil && err != mongo.ErrNoDocuments {
+		return "", err
+	}
+	return strings.NewReplacer(
+		"{{userName}}", user.Name,
+		"{{complaintTitle}}", c.Title,
+		"{{reference}}", c.Reference(),
+		"{{agentName}}", agent,
+	).Replace(template.Body), nil
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+// complaintReport is what the PDF report template can refer to.
+type complaintReport struct {
+	Complaint   models.Complaint
+	Comments    []models.Comment
+	GeneratedAt time.Time
+	GeneratedBy string
+}
+
+// builtinReportTemplate lays the report out in a line-based markup that
+// renderReportPDF understands: "# " starts the title, "## " a section
+// heading and "- " a list item; a blank line adds space and any other line
+// is a paragraph. A leading backslash is dropped and makes the rest of the
+// line plain text, which the text function uses to keep user input from
+// being read as markup.
+const builtinReportTemplate = `# Complaint {{.Complaint.Reference}}
+{{text .Complaint.Title}}
+
+## Details
+- Status: {{.Complaint.CurrentStatus}}
+{{- with .Complaint.Category}}
+- Category: {{.}}{{end}}
+{{- with .Complaint.Priority}}
+- Priority: {{.}}{{end}}
+- Rating: {{.Complaint.Rating}}
+- Submitted: {{date .Complaint.CreatedAt}}
+{{- with .Complaint.AssignedTo}}
+- Assigned to: {{.}}{{end}}
+{{- if .Complaint.ResolvedAt}}
+- Resolved: {{date .Complaint.ResolvedAt}}{{with .Complaint.ResolvedBy}} by {{.}}{{end}}{{end}}
+{{- with .Complaint.ResolutionNote}}
+- Resolution note: {{.}}{{end}}
+{{- with .Complaint.ExternalTicket}}
+- External ticket: {{.System}} {{.ID}}{{with .Status}} ({{.}}){{end}}{{end}}
+
+## Summary
+{{text .Complaint.Summary}}
+{{- if .Complaint.Attachments}}
+
+## Attachments
+{{- range .Complaint.Attachments}}
+- {{.Filename}} ({{.Size}} bytes, uploaded {{date .UploadedAt}} by {{.UploadedBy}}){{end}}
+{{- end}}
+
+## Status history
+{{- range .Complaint.History}}
+- {{date .ChangedAt}}: {{.From}} to {{.To}} by {{.ChangedBy}}{{else}}
+No status changes.{{end}}
+
+## Comments
+{{- range .Comments}}
+- {{date .CreatedAt}}, {{.Author}}{{with .AuthorRole}} ({{.}}){{end}}{{if eq .Visibility "internal"}}, internal note{{end}}:
+{{text .Body}}{{else}}
+No comments.{{end}}
+
+Generated {{date .GeneratedAt}} by {{.GeneratedBy}}.
+`
+
+var (
+	reportTemplateOnce sync.Once
+	reportTemplate     *template.Template
+	reportTemplateErr  error
+)
+
+// loadReportTemplate parses REPORT_TEMPLATE_FILE if set, else the built-in
+// layout.
+func loadReportTemplate() (*template.Template, error) {
+	reportTemplateOnce.Do(func() {
+		text := builtinReportTemplate
+		if path := envString("REPORT_TEMPLATE_FILE", ""); path != "" {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				reportTemplateErr = err
+				return
+			}
+			text = string(b)
+		}
+		reportTemplate, reportTemplateErr = template.New("report").Funcs(template.FuncMap{
+			"date": reportDate,
+			"text": reportText,
+		}).Parse(text)
+	})
+	return reportTemplate, reportTemplateErr
+}
+
+func reportDate(v interface{}) string {
+	switch t := v.(type) {
+	case time.Time:
+		if t.IsZero() {
+			return "unknown"
+		}
+		return t.UTC().Format("2006-01-02 15:04 UTC")
+	case *time.Time:
+		if t == nil {
+			return "unknown"
+		}
+		return reportDate(*t)
+	}
+	return fmt.Sprint(v)
+}
+
+func reportText(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = "\\" + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// complaintReportHandler serves /complaints/{id}/report.pdf to the
+// complaint's owner and staff.
+func complaintReportHandler(w http.ResponseWriter, r *http.Request, complaintID primitive.ObjectID) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var complaint models.Complaint
+	err := orgCollection("complaints").FindOne(dbContext(r), bson.M{"_id": complaintID}).Decode(&complaint)
+	if err != nil || !canAccess(r, complaint) {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+
+	report := complaintReport{Complaint: complaint, Comments: []models.Comment{}, GeneratedAt: time.Now(), GeneratedBy: actorName(r)}
+	cursor, err := documents.Collection("comments").Find(dbContext(r), hideInternalNotes(r, bson.M{"complaintId": complaintID}),
+		options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if err := cursor.All(dbContext(r), &report.Comments); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	t, err := loadReportTemplate()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	var text bytes.Buffer
+	if err := t.Execute(&text, report); err != nil {
+		serverError(w, err)
+		return
+	}
+	// The PDF is built in memory so a rendering error can still be reported
+	// with a proper status; a single complaint's report is small.
+	var pdf bytes.Buffer
+	if err := renderReportPDF(&pdf, text.String()); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", "complaint-"+complaint.Reference()+".pdf"))
+	w.Write(pdf.Bytes())
+}
+
+// renderReportPDF lays out the report markup on A4 pages. The built-in PDF
+// fonts only cover Latin-1, so REPORT_FONT_FILE can name a UTF-8 TrueType
+// font for reports in other scripts.
+func renderReportPDF(out *bytes.Buffer, markup string) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	family, translate := "Helvetica", pdf.UnicodeTranslatorFromDescriptor("")
+	if path := envString("REPORT_FONT_FILE", ""); path != "" {
+		pdf.AddUTF8Font("report", "", path)
+		pdf.AddUTF8Font("report", "B", path)
+		family, translate = "report", func(s string) string { return s }
+	}
+	pdf.SetMargins(20, 20, 20)
+	pdf.SetAutoPageBreak(true, 20)
+	pdf.AliasNbPages("")
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-15)
+		pdf.SetFont(family, "", 8)
+		pdf.CellFormat(0, 10, fmt.Sprintf("Page %d/{nb}", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	pdf.AddPage()
+
+	for _, line := range strings.Split(markup, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		switch {
+		case strings.HasPrefix(line, "\\"):
+			pdf.SetFont(family, "", 10)
+			pdf.MultiCell(0, 5, translate(line[1:]), "", "L", false)
+		case strings.HasPrefix(line, "# "):
+			pdf.SetFont(family, "B", 18)
+			pdf.MultiCell(0, 9, translate(line[2:]), "", "L", false)
+			pdf.Ln(2)
+		case strings.HasPrefix(line, "## "):
+			pdf.Ln(3)
+			pdf.SetFont(family, "B", 13)
+			pdf.MultiCell(0, 7, translate(line[3:]), "B", "L", false)
+			pdf.Ln(2)
+		case strings.HasPrefix(line, "- "):
+			pdf.SetFont(family, "", 10)
+			pdf.SetX(25)
+			pdf.MultiCell(0, 5, translate("• "+line[2:]), "", "L", false)
+		case line == "":
+			pdf.Ln(3)
+		default:
+			pdf.SetFont(family, "", 10)
+			pdf.MultiCell(0, 5, translate(line), "", "L", false)
+		}
+	}
+	return pdf.Output(out)
+}
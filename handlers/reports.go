@@ -0,0 +1,543 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+	"complain/store"
+)
+
+type complaintHeatmap struct {
+	Timezone string `json:"timezone"`
+	// Counts is indexed by day of week (0 = Sunday) and then hour of day.
+	Counts [7][24]int `json:"counts"`
+}
+
+// reportLocation resolves the timezone used for bucketing reports, preferring
+// the tz query parameter over REPORT_TIMEZONE.
+func reportLocation(r *http.Request) (*time.Location, error) {
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		tz = envString("REPORT_TIMEZONE", "UTC")
+	}
+	return time.LoadLocation(tz)
+}
+
+// parseDateParam reads an RFC3339 time, or a bare date taken as midnight in
+// loc so that a report's day boundaries follow its time zone.
+func parseDateParam(r *http.Request, key string, loc *time.Location) (time.Time, bool, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return time.Time{}, false, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, v, loc); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t, err == nil, err
+}
+
+func complaintHeatmapHandler(w http.ResponseWriter, r *http.Request) {
+	loc, err := reportLocation(r)
+	if err != nil {
+		writeFieldError(w, "tz", "must be an IANA time zone")
+		return
+	}
+
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"createdAt": bson.M{"$type": "date"}, "deletedAt": store.NotDeleted()}},
+		bson.M{"$group": bson.M{
+			"_id": bson.M{
+				"day":  bson.M{"$dayOfWeek": bson.M{"date": "$createdAt", "timezone": loc.String()}},
+				"hour": bson.M{"$hour": bson.M{"date": "$createdAt", "timezone": loc.String()}},
+			},
+			"count": bson.M{"$sum": 1},
+		}},
+	}
+	cursor, err := listingCollection("complaints").Aggregate(dbContext(r), pipeline)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer cursor.Close(dbContext(r))
+
+	heatmap := complaintHeatmap{Timezone: loc.String()}
+	for cursor.Next(dbContext(r)) {
+		var bucket struct {
+			ID struct {
+				Day  int `bson:"day"`
+				Hour int `bson:"hour"`
+			} `bson:"_id"`
+			Count int `bson:"count"`
+		}
+		if err := cursor.Decode(&bucket); err != nil {
+			serverError(w, err)
+			return
+		}
+		// $dayOfWeek is 1-based starting on Sunday.
+		heatmap.Counts[bucket.ID.Day-1][bucket.ID.Hour] = bucket.Count
+	}
+
+	json.NewEncoder(w).Encode(heatmap)
+}
+
+type reporterGroup struct {
+	UserID       primitive.ObjectID `bson:"_id" json:"userId"`
+	ReporterName string             `bson:"reporterName" json:"reporterName"`
+	Count        int                `bson:"count" json:"count"`
+	Complaints   []models.Complaint `bson:"complaints" json:"complaints"`
+}
+
+type reporterGroupsResponse struct {
+	Page        int             `json:"page"`
+	PageSize    int             `json:"pageSize"`
+	TotalGroups int             `json:"totalGroups"`
+	Groups      []reporterGroup `json:"groups"`
+	// Anonymous holds complaints without a reporter; it is not paginated.
+	Anonymous reporterGroup `json:"anonymous"`
+}
+
+// anonymousFilter matches complaints that have no reporter attached.
+var anonymousFilter = bson.M{"userId": bson.M{"$in": bson.A{nil, primitive.NilObjectID}}, "deletedAt": store.NotDeleted()}
+
+func complaintsByReporterHandler(w http.ResponseWriter, r *http.Request) {
+	var errs validationErrors
+	page, pageSize := pageParams(r, 20, 100, &errs)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"userId": bson.M{"$nin": bson.A{nil, primitive.NilObjectID}}, "deletedAt": store.NotDeleted()}},
+		bson.M{"$sort": bson.M{"createdAt": -1}},
+		bson.M{"$group": bson.M{
+			"_id":        "$userId",
+			"complaints": bson.M{"$push": "$$ROOT"},
+			"count":      bson.M{"$sum": 1},
+			"latest":     bson.M{"$max": "$createdAt"},
+		}},
+		bson.M{"$sort": bson.D{{Key: "latest", Value: -1}, {Key: "_id", Value: 1}}},
+		bson.M{"$facet": bson.M{
+			"groups": bson.A{
+				bson.M{"$skip": (page - 1) * pageSize},
+				bson.M{"$limit": pageSize},
+				bson.M{"$lookup": bson.M{"from": "users", "localField": "_id", "foreignField": "_id", "as": "reporter"}},
+				bson.M{"$set": bson.M{"reporterName": bson.M{"$ifNull": bson.A{bson.M{"$first": "$reporter.name"}, ""}}}},
+				bson.M{"$unset": "reporter"},
+			},
+			"total": bson.A{bson.M{"$count": "n"}},
+		}},
+	}
+	cursor, err := listingCollection("complaints").Aggregate(dbContext(r), pipeline)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer cursor.Close(dbContext(r))
+
+	var facets []struct {
+		Groups []reporterGroup `bson:"groups"`
+		Total  []struct {
+			N int `bson:"n"`
+		} `bson:"total"`
+	}
+	if err := cursor.All(dbContext(r), &facets); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	resp := reporterGroupsResponse{Page: page, PageSize: pageSize, Groups: []reporterGroup{}}
+	if len(facets) > 0 {
+		resp.Groups = append(resp.Groups, facets[0].Groups...)
+		if len(facets[0].Total) > 0 {
+			resp.TotalGroups = facets[0].Total[0].N
+		}
+	}
+
+	anonCursor, err := listingCollection("complaints").Find(dbContext(r), anonymousFilter,
+		options.Find().SetSort(bson.M{"createdAt": -1}).SetLimit(int64(pageSize)))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	resp.Anonymous.Complaints = []models.Complaint{}
+	if err := anonCursor.All(dbContext(r), &resp.Anonymous.Complaints); err != nil {
+		serverError(w, err)
+		return
+	}
+	anonCount, err := listingCollection("complaints").CountDocuments(dbContext(r), anonymousFilter)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	resp.Anonymous.Count = int(anonCount)
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ratingBreakdownHandler counts complaints per rating. Ratings outside 1-5 are
+// reported under "invalid" so bad legacy data stays visible.
+func ratingBreakdownHandler(w http.ResponseWriter, r *http.Request) {
+	cursor, err := listingCollection("complaints").Aggregate(dbContext(r), bson.A{
+		bson.M{"$match": bson.M{"deletedAt": store.NotDeleted()}},
+		bson.M{"$group": bson.M{"_id": "$rating", "count": bson.M{"$sum": 1}}},
+	})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer cursor.Close(dbContext(r))
+
+	breakdown := map[string]int{"1": 0, "2": 0, "3": 0, "4": 0, "5": 0, "invalid": 0}
+	for cursor.Next(dbContext(r)) {
+		var group struct {
+			Rating interface{} `bson:"_id"`
+			Count  int         `bson:"count"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			serverError(w, err)
+			return
+		}
+		key := "invalid"
+		switch rating := group.Rating.(type) {
+		case int32:
+			if rating >= 1 && rating <= 5 {
+				key = strconv.Itoa(int(rating))
+			}
+		case int64:
+			if rating >= 1 && rating <= 5 {
+				key = strconv.Itoa(int(rating))
+			}
+		}
+		breakdown[key] += group.Count
+	}
+
+	json.NewEncoder(w).Encode(breakdown)
+}
+
+type categoryStats struct {
+	Category string `bson:"_id" json:"category"` // empty for uncategorized
+	Name     string `bson:"-" json:"name,omitempty"`
+	Total    int    `bson:"total" json:"total"`
+	Open     int    `bson:"-" json:"open"`
+	Resolved int    `bson:"resolved" json:"resolved"`
+}
+
+// categoryBreakdownHandler counts complaints per category, busiest first.
+// Defined categories without complaints are listed with zero counts.
+func categoryBreakdownHandler(w http.ResponseWriter, r *http.Request) {
+	cursor, err := listingCollection("complaints").Aggregate(dbContext(r), bson.A{
+		bson.M{"$match": bson.M{"deletedAt": store.NotDeleted()}},
+		bson.M{"$group": bson.M{
+			"_id":      bson.M{"$ifNull": bson.A{"$category", ""}},
+			"total":    bson.M{"$sum": 1},
+			"resolved": bson.M{"$sum": bson.M{"$cond": bson.A{"$resolved", 1, 0}}},
+		}},
+	})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	stats := []categoryStats{}
+	if err := cursor.All(dbContext(r), &stats); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	cursor, err = documents.Collection("categories").Find(dbContext(r), bson.M{})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	var categories []models.Category
+	if err := cursor.All(dbContext(r), &categories); err != nil {
+		serverError(w, err)
+		return
+	}
+	names := make(map[string]string, len(categories))
+	for _, c := range categories {
+		names[c.ID] = c.Name
+	}
+	for i := range stats {
+		stats[i].Open = stats[i].Total - stats[i].Resolved
+		stats[i].Name = names[stats[i].Category]
+		delete(names, stats[i].Category)
+	}
+	for id, name := range names {
+		stats = append(stats, categoryStats{Category: id, Name: name})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Total != stats[j].Total {
+			return stats[i].Total > stats[j].Total
+		}
+		return stats[i].Category < stats[j].Category
+	})
+
+	json.NewEncoder(w).Encode(stats)
+}
+
+type agentResolutionStats struct {
+	Agent                string  `bson:"_id" json:"agent"`
+	Resolved             int     `bson:"resolved" json:"resolved"`
+	AvgResolutionSeconds float64 `bson:"avgResolutionSeconds" json:"avgResolutionSeconds"`
+}
+
+type resolutionTimesResponse struct {
+	Overall agentResolutionStats   `json:"overall"`
+	Agents  []agentResolutionStats `json:"agents"`
+}
+
+// resolutionTimesHandler reports each agent's average time from submission to
+// resolution, fastest first, alongside the overall average.
+func resolutionTimesHandler(w http.ResponseWriter, r *http.Request) {
+	group := func(id interface{}) bson.M {
+		return bson.M{"$group": bson.M{
+			"_id":                  id,
+			"resolved":             bson.M{"$sum": 1},
+			"avgResolutionSeconds": bson.M{"$avg": "$durationSeconds"},
+		}}
+	}
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{
+			"resolved":   true,
+			"resolvedAt": bson.M{"$type": "date"},
+			"createdAt":  bson.M{"$type": "date"},
+			"deletedAt":  store.NotDeleted(),
+		}},
+		bson.M{"$project": bson.M{
+			"resolvedBy":      bson.M{"$ifNull": bson.A{"$resolvedBy", "unknown"}},
+			"durationSeconds": bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{"$resolvedAt", "$createdAt"}}, 1000}},
+		}},
+		bson.M{"$facet": bson.M{
+			"agents":  bson.A{group("$resolvedBy"), bson.M{"$sort": bson.D{{Key: "avgResolutionSeconds", Value: 1}, {Key: "_id", Value: 1}}}},
+			"overall": bson.A{group("overall")},
+		}},
+	}
+	cursor, err := listingCollection("complaints").Aggregate(dbContext(r), pipeline)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer cursor.Close(dbContext(r))
+
+	var facets []struct {
+		Agents  []agentResolutionStats `bson:"agents"`
+		Overall []agentResolutionStats `bson:"overall"`
+	}
+	if err := cursor.All(dbContext(r), &facets); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	resp := resolutionTimesResponse{Overall: agentResolutionStats{Agent: "overall"}, Agents: []agentResolutionStats{}}
+	if len(facets) > 0 {
+		resp.Agents = append(resp.Agents, facets[0].Agents...)
+		if len(facets[0].Overall) > 0 {
+			resp.Overall = facets[0].Overall[0]
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+type dayCount struct {
+	Date  string `bson:"_id" json:"date"`
+	Count int    `bson:"count" json:"count"`
+}
+
+type categoryCount struct {
+	Category string `bson:"_id" json:"category"`
+	Count    int    `bson:"count" json:"count"`
+}
+
+type adminStats struct {
+	From     *time.Time     `json:"from,omitempty"`
+	To       *time.Time     `json:"to,omitempty"`
+	Timezone string         `json:"timezone"`
+	Total    int            `json:"total"`
+	ByStatus map[string]int `json:"byStatus"`
+	// AverageRating covers complaints rated 1-5 and is null when there are
+	// none.
+	AverageRating *float64 `json:"averageRating"`
+	// AverageSatisfaction is the mean feedback score given after resolution,
+	// from SatisfactionResponses answers.
+	AverageSatisfaction   *float64 `json:"averageSatisfaction"`
+	SatisfactionResponses int      `json:"satisfactionResponses"`
+	AvgResolutionSeconds  *float64 `json:"avgResolutionSeconds"`
+	// PerDay counts submissions on each of the 30 days up to to, or today.
+	PerDay        []dayCount      `json:"perDay"`
+	TopCategories []categoryCount `json:"topCategories"`
+}
+
+// statsQuery is the range, time zone and category count of an admin stats
+// summary.
+type statsQuery struct {
+	from, to       time.Time
+	hasFrom, hasTo bool
+	loc            *time.Location
+	top            int
+}
+
+func parseStatsQuery(r *http.Request) (statsQuery, validationErrors) {
+	var errs validationErrors
+	var q statsQuery
+	var err error
+	if q.loc, err = reportLocation(r); err != nil {
+		errs.add("tz", "must be an IANA time zone")
+		q.loc = time.UTC
+	}
+	if q.from, q.hasFrom, err = parseDateParam(r, "from", q.loc); err != nil {
+		errs.add("from", "must be an RFC3339 time or a date")
+	}
+	if q.to, q.hasTo, err = parseDateParam(r, "to", q.loc); err != nil {
+		errs.add("to", "must be an RFC3339 time or a date")
+	}
+	if q.hasFrom && q.hasTo && !q.from.Before(q.to) {
+		errs.add("from", "must be before to")
+	}
+	q.top = intParam(r, "top", 5, 1, 50, &errs)
+	return q, errs
+}
+
+// adminStatsHandler summarizes complaints created in the optional from/to
+// range in a single aggregation; only the grouped counts leave the database.
+func adminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	q, errs := parseStatsQuery(r)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	stats, err := loadAdminStats(dbContext(r), q)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(stats)
+}
+
+func loadAdminStats(ctx context.Context, q statsQuery) (adminStats, error) {
+	match := bson.M{"deletedAt": store.NotDeleted()}
+	created := bson.M{}
+	if q.hasFrom {
+		created["$gte"] = q.from
+	}
+	if q.hasTo {
+		created["$lt"] = q.to
+	}
+	if len(created) > 0 {
+		match["createdAt"] = created
+	}
+
+	end := time.Now().In(q.loc)
+	if q.hasTo {
+		end = q.to.In(q.loc)
+	}
+	lastDay := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, q.loc)
+	firstDay := lastDay.AddDate(0, 0, -29)
+
+	cursor, err := listingCollection("complaints").Aggregate(ctx, bson.A{
+		bson.M{"$match": match},
+		bson.M{"$facet": bson.M{
+			"total": bson.A{bson.M{"$count": "n"}},
+			"byStatus": bson.A{bson.M{"$group": bson.M{
+				"_id": bson.M{"$ifNull": bson.A{"$status", bson.M{"$cond": bson.A{"$resolved", models.StatusResolved, models.StatusOpen}}}},
+				"n":   bson.M{"$sum": 1},
+			}}},
+			"rating": bson.A{
+				bson.M{"$match": bson.M{"rating": bson.M{"$gte": 1, "$lte": 5}}},
+				bson.M{"$group": bson.M{"_id": nil, "avg": bson.M{"$avg": "$rating"}}},
+			},
+			"satisfaction": bson.A{
+				bson.M{"$lookup": bson.M{"from": "feedback", "localField": "_id", "foreignField": "_id", "as": "feedback"}},
+				bson.M{"$unwind": "$feedback"},
+				bson.M{"$group": bson.M{"_id": nil, "avg": bson.M{"$avg": "$feedback.score"}, "n": bson.M{"$sum": 1}}},
+			},
+			"resolution": bson.A{
+				bson.M{"$match": bson.M{"resolved": true, "resolvedAt": bson.M{"$type": "date"}, "createdAt": bson.M{"$type": "date"}}},
+				bson.M{"$group": bson.M{"_id": nil, "avg": bson.M{"$avg": bson.M{
+					"$divide": bson.A{bson.M{"$subtract": bson.A{"$resolvedAt", "$createdAt"}}, 1000},
+				}}}},
+			},
+			"perDay": bson.A{
+				bson.M{"$match": bson.M{"createdAt": bson.M{"$gte": firstDay, "$lt": lastDay.AddDate(0, 0, 1)}}},
+				bson.M{"$group": bson.M{
+					"_id":   bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$createdAt", "timezone": q.loc.String()}},
+					"count": bson.M{"$sum": 1},
+				}},
+			},
+			"topCategories": bson.A{
+				bson.M{"$match": bson.M{"category": bson.M{"$nin": bson.A{nil, ""}}}},
+				bson.M{"$group": bson.M{"_id": "$category", "count": bson.M{"$sum": 1}}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+				bson.M{"$limit": q.top},
+			},
+		}},
+	})
+	if err != nil {
+		return adminStats{}, err
+	}
+	type group struct {
+		ID  string  `bson:"_id"`
+		N   int     `bson:"n"`
+		Avg float64 `bson:"avg"`
+	}
+	var facets []struct {
+		Total         []group         `bson:"total"`
+		ByStatus      []group         `bson:"byStatus"`
+		Rating        []group         `bson:"rating"`
+		Satisfaction  []group         `bson:"satisfaction"`
+		Resolution    []group         `bson:"resolution"`
+		PerDay        []dayCount      `bson:"perDay"`
+		TopCategories []categoryCount `bson:"topCategories"`
+	}
+	if err := cursor.All(ctx, &facets); err != nil {
+		return adminStats{}, err
+	}
+
+	stats := adminStats{Timezone: q.loc.String(), ByStatus: map[string]int{}, PerDay: []dayCount{}, TopCategories: []categoryCount{}}
+	if q.hasFrom {
+		stats.From = &q.from
+	}
+	if q.hasTo {
+		stats.To = &q.to
+	}
+	perDay := map[string]int{}
+	if len(facets) > 0 {
+		f := facets[0]
+		if len(f.Total) > 0 {
+			stats.Total = f.Total[0].N
+		}
+		for _, g := range f.ByStatus {
+			stats.ByStatus[g.ID] = g.N
+		}
+		if len(f.Rating) > 0 {
+			stats.AverageRating = &f.Rating[0].Avg
+		}
+		if len(f.Satisfaction) > 0 {
+			stats.AverageSatisfaction = &f.Satisfaction[0].Avg
+			stats.SatisfactionResponses = f.Satisfaction[0].N
+		}
+		if len(f.Resolution) > 0 {
+			stats.AvgResolutionSeconds = &f.Resolution[0].Avg
+		}
+		for _, d := range f.PerDay {
+			perDay[d.Date] = d.Count
+		}
+		stats.TopCategories = append(stats.TopCategories, f.TopCategories...)
+	}
+	// Days without submissions have no group, so the series is filled in here.
+	for day := firstDay; !day.After(lastDay); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		stats.PerDay = append(stats.PerDay, dayCount{Date: date, Count: perDay[date]})
+	}
+	return stats, nil
+}
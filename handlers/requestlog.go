@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const requestInfoKey contextKey = iota + 100
+
+// requestInfo travels in the request context. The logging middleware creates
+// it; inner middleware fills in what only it knows, such as the user.
+type requestInfo struct {
+	ID   string
+	User string
+}
+
+// Incoming IDs are echoed into logs and headers, so only accept ones that
+// cannot smuggle in anything odd.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey).(*requestInfo)
+	return info
+}
+
+// requestID returns the request's correlation ID, or "" outside a request.
+func requestID(ctx context.Context) string {
+	if info := requestInfoFrom(ctx); info != nil {
+		return info.ID
+	}
+	return ""
+}
+
+// requestLogger returns a logger that tags every line with the request ID, so
+// errors logged by handlers line up with the access log entry.
+func requestLogger(r *http.Request) *slog.Logger {
+	return slog.Default().With("request_id", requestID(r.Context()))
+}
+
+func initLogging() {
+	level := slog.LevelInfo
+	if envBool("LOG_DEBUG", false) {
+		level = slog.LevelDebug
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+}
+
+// errorRecorder keeps the start of 5xx response bodies, which hold the
+// handler's error message, so the access log line carries the cause.
+type errorRecorder struct {
+	*statusRecorder
+	body []byte
+}
+
+func (e *errorRecorder) Write(b []byte) (int, error) {
+	if e.status >= http.StatusInternalServerError && len(e.body) < 512 {
+		e.body = append(e.body, b[:min(len(b), 512-len(e.body))]...)
+	}
+	return e.statusRecorder.Write(b)
+}
+
+// withRequestLog assigns each request an X-Request-ID, keeping a well-formed
+// one sent by the client or a proxy, and writes one JSON access log line per
+// request once it completes.
+func withRequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		info := &requestInfo{ID: id}
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey, info))
+
+		rec := &errorRecorder{statusRecorder: &statusRecorder{ResponseWriter: w, status: http.StatusOK}}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+
+		user := info.User
+		if staff, ok := staffIdentity(r); ok {
+			user = staff.Name
+		}
+		attrs := []slog.Attr{
+			slog.String("request_id", id),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("user", user),
+			slog.String("remote", r.RemoteAddr),
+		}
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+		}
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+			attrs = append(attrs, slog.String("error", strings.TrimSpace(string(rec.body))))
+		}
+		slog.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
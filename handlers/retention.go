@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+	"complain/store"
+)
+
+var complaintsArchivedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "complaints_archived_total",
+	Help: "Resolved complaints moved to the archive by the retention job.",
+})
+
+type archivedComplaintsResponse struct {
+	Page       int                        `json:"page"`
+	PageSize   int                        `json:"pageSize"`
+	Total      int64                      `json:"total"`
+	Complaints []models.ArchivedComplaint `json:"complaints"`
+}
+
+// registerRetention schedules archiving resolved complaints untouched for
+// RETENTION_ARCHIVE_AFTER. It only runs when RETENTION_ENABLED is set, and
+// with RETENTION_DRY_RUN it logs what it would archive instead.
+func registerRetention() {
+	if !envBool("RETENTION_ENABLED", false) {
+		return
+	}
+	after := envDuration("RETENTION_ARCHIVE_AFTER", 365*24*time.Hour)
+	dryRun := envBool("RETENTION_DRY_RUN", false)
+	registerJob("retention", envDuration("RETENTION_INTERVAL", time.Hour), func(ctx context.Context) error {
+		return archiveResolvedComplaints(ctx, after, dryRun)
+	})
+}
+
+// retentionFilter matches resolved complaints last changed before cutoff.
+// Complaints written before updatedAt was stamped go by their creation.
+func retentionFilter(cutoff time.Time) bson.M {
+	return bson.M{
+		"resolved": true,
+		"$or": bson.A{
+			bson.M{"updatedAt": bson.M{"$lt": cutoff}},
+			bson.M{"updatedAt": bson.M{"$exists": false}, "createdAt": bson.M{"$lt": cutoff}},
+		},
+	}
+}
+
+func archiveResolvedComplaints(ctx context.Context, after time.Duration, dryRun bool) error {
+	cutoff := time.Now().Add(-after).UTC()
+	cursor, err := documents.Collection("complaints").Find(ctx, retentionFilter(cutoff))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	archived := 0
+	for cursor.Next(ctx) {
+		var c models.Complaint
+		if err := cursor.Decode(&c); err != nil {
+			return err
+		}
+		if dryRun {
+			log.Printf("retention (dry run): would archive complaint %s", c.ID.Hex())
+			continue
+		}
+		ok, err := archiveComplaint(ctx, c)
+		if err != nil {
+			log.Printf("archive complaint %s: %v", c.ID.Hex(), err)
+			continue
+		}
+		if ok {
+			archived++
+			complaintsArchivedTotal.Inc()
+			invalidateComplaint(ctx, c.ID, c.UserID)
+		}
+	}
+	if archived > 0 {
+		log.Printf("archived %d complaints resolved before %s", archived, cutoff.Format(time.RFC3339))
+	}
+	return cursor.Err()
+}
+
+// archiveComplaint copies c with its comments and revisions into
+// complaints_archive and then deletes it and what hangs off it. Feedback and
+// attachment files are kept: reports still count the feedback and the
+// archived complaint still lists its attachments. A complaint that changed
+// since it was read, say reopened, is left alone, which archiveComplaint
+// reports as false. The moves run in one transaction where there are
+// transactions; elsewhere the copy comes first, so a failed run at worst
+// leaves a copy that the next run overwrites.
+func archiveComplaint(ctx context.Context, c models.Complaint) (bool, error) {
+	var archived bool
+	err := inTransaction(ctx, func(ctx context.Context) error {
+		archived = false
+		doc := models.ArchivedComplaint{Complaint: c, Comments: []models.Comment{}, ArchivedAt: time.Now().UTC()}
+		cursor, err := documents.Collection("comments").Find(ctx, bson.M{"complaintId": c.ID}, options.Find().SetSort(bson.M{"createdAt": 1}))
+		if err != nil {
+			return err
+		}
+		if err := cursor.All(ctx, &doc.Comments); err != nil {
+			return err
+		}
+		cursor, err = documents.Collection("complaint_revisions").Find(ctx, bson.M{"complaintId": c.ID}, options.Find().SetSort(bson.M{"revision": 1}))
+		if err != nil {
+			return err
+		}
+		if err := cursor.All(ctx, &doc.Revisions); err != nil {
+			return err
+		}
+		_, err = documents.Collection("complaints_archive").ReplaceOne(ctx, bson.M{"_id": c.ID}, doc, options.Replace().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("copy to archive: %w", err)
+		}
+
+		res, err := documents.Collection("complaints").DeleteOne(ctx, bson.M{"_id": c.ID, "version": store.VersionFilter(c.Version)})
+		if err != nil {
+			return err
+		}
+		if res.DeletedCount == 0 {
+			_, err := documents.Collection("complaints_archive").DeleteOne(ctx, bson.M{"_id": c.ID})
+			return err
+		}
+		for _, name := range []string{"comments", "complaint_revisions", "notifications"} {
+			if _, err := documents.Collection(name).DeleteMany(ctx, bson.M{"complaintId": c.ID}); err != nil {
+				return fmt.Errorf("delete %s: %w", name, err)
+			}
+		}
+		if !c.UserID.IsZero() {
+			if _, err := documents.Collection("users").UpdateOne(ctx, bson.M{"_id": c.UserID}, bson.M{"$pull": bson.M{"complaints": c.ID}}); err != nil {
+				return fmt.Errorf("unlink from reporter: %w", err)
+			}
+		}
+		archived = true
+		return nil
+	})
+	return archived, err
+}
+
+// archivedComplaintsHandler looks up archived complaints: one by
+// complaintId, with its comments and revisions, or a page of them filtered
+// by refNumber, userId, category, status and an RFC3339 from/to range on
+// submission, most recently archived first. Organization admins see only
+// their organization's.
+func archivedComplaintsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	archive := orgCollection("complaints_archive")
+	if v := r.URL.Query().Get("complaintId"); v != "" {
+		oid, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			writeFieldError(w, "complaintId", "must be a valid ID")
+			return
+		}
+		var c models.ArchivedComplaint
+		err = archive.FindOne(dbContext(r), bson.M{"_id": oid}).Decode(&c)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(w, "Archived complaint not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(c)
+		return
+	}
+
+	var errs validationErrors
+	page, pageSize := pageParams(r, 50, 500, &errs)
+	ref := intParam(r, "refNumber", 0, 1, math.MaxInt, &errs)
+	filter := bson.M{}
+	if ref > 0 {
+		filter["refNumber"] = ref
+	}
+	if v := r.URL.Query().Get("userId"); v != "" {
+		oid, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			errs.add("userId", "must be a valid ID")
+		}
+		filter["userId"] = oid
+	}
+	for _, param := range []string{"category", "status"} {
+		if v := r.URL.Query().Get(param); v != "" {
+			filter[param] = v
+		}
+	}
+	createdAt := bson.M{}
+	for param, op := range map[string]string{"from": "$gte", "to": "$lte"} {
+		t, ok, err := parseTimeParam(r, param)
+		if err != nil {
+			errs.add(param, "must be an RFC3339 time")
+		}
+		if ok {
+			createdAt[op] = t
+		}
+	}
+	if len(createdAt) > 0 {
+		filter["createdAt"] = createdAt
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	total, err := archive.CountDocuments(dbContext(r), filter)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	cursor, err := archive.Find(dbContext(r), filter, options.Find().
+		SetProjection(bson.M{"comments": 0, "revisions": 0}).
+		SetSort(bson.D{{Key: "archivedAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page-1)*pageSize)).
+		SetLimit(int64(pageSize)))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	resp := archivedComplaintsResponse{Page: page, PageSize: pageSize, Total: total, Complaints: []models.ArchivedComplaint{}}
+	if err := cursor.All(dbContext(r), &resp.Complaints); err != nil {
+		serverError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
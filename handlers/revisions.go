@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+	"complain/store"
+)
+
+// complaintEdit holds the content fields a reporter may change; fields left
+// out are kept.
+type complaintEdit struct {
+	Title   *string `json:"title"`
+	Summary *string `json:"summary"`
+	Rating  *int    `json:"rating"`
+}
+
+// editComplaintHandler lets the reporter correct an unresolved complaint.
+// Each edit is stored in complaint_revisions with the fields it changed. An
+// edit that content screening flags holds the complaint for moderation, as
+// a flagged submission would be.
+func editComplaintHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("complaintId"))
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+	var edit complaintEdit
+	if err := decodeJSON(r, &edit); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	before, err := complaintStore.Complaint(dbContext(r), oid)
+	userID, _ := currentUserID(r)
+	if err != nil || before.UserID != userID {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+	if before.Resolved || before.CurrentStatus() == models.StatusClosed {
+		http.Error(w, "Resolved complaints can no longer be edited", http.StatusConflict)
+		return
+	}
+
+	after := before
+	if edit.Title != nil {
+		after.Title = *edit.Title
+	}
+	if edit.Summary != nil {
+		after.Summary = *edit.Summary
+	}
+	if edit.Rating != nil {
+		after.Rating = *edit.Rating
+	}
+	var errs validationErrors
+	if strings.TrimSpace(after.Title) == "" {
+		errs.add("title", "is required")
+	}
+	if after.Rating < 0 || after.Rating > 5 {
+		errs.add("rating", "must be between 1 and 5, or omitted")
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	changes := map[string]models.FieldChange{}
+	if after.Title != before.Title {
+		changes["title"] = models.FieldChange{Before: before.Title, After: after.Title}
+	}
+	if after.Summary != before.Summary {
+		changes["summary"] = models.FieldChange{Before: before.Summary, After: after.Summary}
+	}
+	if after.Rating != before.Rating {
+		changes["rating"] = models.FieldChange{Before: before.Rating, After: after.Rating}
+	}
+	if len(changes) == 0 {
+		computeFields(&before, time.Now())
+		json.NewEncoder(w).Encode(before)
+		return
+	}
+
+	now := time.Now().UTC()
+	after.ContentHash = contentHash(after)
+	after.Revision++
+	after.Version++
+	after.EditedAt = &now
+	set := bson.M{
+		"title":       after.Title,
+		"summary":     after.Summary,
+		"rating":      after.Rating,
+		"contentHash": after.ContentHash,
+		"editedAt":    now,
+	}
+	if !before.Flagged {
+		if reasons := screenComplaint(dbContext(r), after); len(reasons) > 0 {
+			after.Flagged = true
+			after.Moderation = &models.Moderation{Status: models.ModerationFlagged, Reasons: reasons, FlaggedAt: now}
+			set["flagged"], set["moderation"] = true, after.Moderation
+		}
+	}
+
+	// Matching on the revision number makes concurrent edits fail rather
+	// than silently overwrite each other.
+	var matched bool
+	err = withOutbox(dbContext(r), func(ctx context.Context) ([]outboxEvent, error) {
+		res, err := orgCollection("complaints").UpdateOne(ctx,
+			bson.M{"_id": oid, "revision": store.VersionFilter(before.Revision), "resolved": false, "deletedAt": store.NotDeleted()},
+			bson.M{"$set": set, "$inc": bson.M{"revision": 1, "version": 1}})
+		if err != nil {
+			return nil, err
+		}
+		matched = res.MatchedCount > 0
+		if !matched || after.Flagged {
+			return nil, nil
+		}
+		return []outboxEvent{outboxEntry(eventComplaintEdited, after)}, nil
+	})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if !matched {
+		writeVersionConflict(w)
+		return
+	}
+
+	revision := models.ComplaintRevision{
+		ID:          primitive.NewObjectID(),
+		ComplaintID: oid,
+		Revision:    after.Revision,
+		Changes:     changes,
+		EditedBy:    actorName(r),
+		EditedAt:    now,
+	}
+	if _, err := documents.Collection("complaint_revisions").InsertOne(dbContext(r), revision); err != nil {
+		requestLogger(r).Error("record complaint revision", "complaint_id", oid.Hex(), "error", err)
+	}
+	recordAudit(r, auditEdit, auditTargetComplaint, oid, before, after)
+	if !after.Flagged {
+		go publishEvent(eventComplaintEdited, after)
+	}
+
+	computeFields(&after, now)
+	json.NewEncoder(w).Encode(after)
+}
+
+// complaintRevisionsHandler lists a complaint's edits, oldest first.
+func complaintRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("complaintId"))
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+
+	var complaint models.Complaint
+	err = orgCollection("complaints").FindOne(dbContext(r), bson.M{"_id": oid},
+		options.FindOne().SetProjection(bson.M{"userId": 1, "deletedAt": 1})).Decode(&complaint)
+	if err == mongo.ErrNoDocuments || (err == nil && !canAccess(r, complaint)) {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	cursor, err := documents.Collection("complaint_revisions").Find(dbContext(r), bson.M{"complaintId": oid},
+		options.Find().SetSort(bson.D{{Key: "revision", Value: 1}}))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	revisions := []models.ComplaintRevision{}
+	if err := cursor.All(dbContext(r), &revisions); err != nil {
+		serverError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(revisions)
+}
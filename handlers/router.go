@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const apiPrefix = "/api/v1"
+
+// apiRoute is a REST route under /api/v1. Each one is served by the handler
+// of an existing route, named by Legacy as the "method /path" of its
+// apiOperations entry; the handler sees the legacy method, and the path
+// variables in Vars are handed to it as the query parameters it already
+// reads. The OpenAPI entry is derived from the legacy one the same way.
+type apiRoute struct {
+	Method  string
+	Path    string
+	Legacy  string
+	Vars    map[string]string // path variable -> query parameter
+	Handler http.HandlerFunc
+}
+
+var (
+	router    = mux.NewRouter()
+	apiRoutes []apiRoute
+)
+
+var idVar = map[string]string{"id": "complaintId"}
+
+// withComplaintID adapts the /complaints/{id}/... subresource handlers,
+// which take the parsed ID, to mux routes.
+func withComplaintID(next func(http.ResponseWriter, *http.Request, primitive.ObjectID)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		oid, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			writeFieldError(w, "id", "must be a valid ID")
+			return
+		}
+		next(w, r, oid)
+	}
+}
+
+func (rt apiRoute) serve(w http.ResponseWriter, r *http.Request) {
+	method, _, _ := strings.Cut(rt.Legacy, " ")
+	legacy := r.WithContext(r.Context())
+	legacy.Method = strings.ToUpper(method)
+	if len(rt.Vars) > 0 {
+		u := *r.URL
+		q := u.Query()
+		vars := mux.Vars(r)
+		for v, param := range rt.Vars {
+			q.Set(param, vars[v])
+		}
+		u.RawQuery = q.Encode()
+		legacy.URL = &u
+	}
+	rt.Handler(w, legacy)
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+// registerAPIRoutes mounts the /api/v1 routes. Static segments are
+// registered before {id} so /complaints/search is not read as an ID.
+func registerAPIRoutes(staffOnly, anyRole func(http.HandlerFunc) http.HandlerFunc, authLimiter, submitLimiter *rateLimiter) {
+	adminOnly, platformOnly := requireRole(roleAdmin), requirePlatformAdmin
+	apiRoutes = []apiRoute{
+		{"POST", "/auth/register", "post /register", nil, authLimiter.limit(registerHandler)},
+		{"POST", "/auth/login", "post /login", nil, authLimiter.limit(loginHandler)},
+		{"POST", "/auth/refresh", "post /refresh", nil, authLimiter.limit(refreshHandler)},
+		{"POST", "/auth/logout", "post /logout", nil, logoutHandler},
+		{"GET", "/auth/{provider}/login", "get /oidcLogin", map[string]string{"provider": "provider"}, authLimiter.limit(oidcLoginHandler)},
+		{"GET", "/auth/{provider}/callback", "get /oidcCallback", map[string]string{"provider": "provider"}, authLimiter.limit(oidcCallbackHandler)},
+		{"GET", "/auth/verify-email", "get /verifyEmail", nil, authLimiter.limit(verifyEmailHandler)},
+		{"PUT", "/users/me", "put /updateProfile", nil, requireUser(updateProfileHandler)},
+		{"PATCH", "/users/me", "patch /updateProfile", nil, requireUser(updateProfileHandler)},
+		{"PUT", "/users/me/avatar", "put /myAvatar", nil, requireUser(myAvatarHandler)},
+		{"DELETE", "/users/me/avatar", "delete /myAvatar", nil, requireUser(myAvatarHandler)},
+		{"PUT", "/users/me/phone", "put /myPhone", nil, authLimiter.limit(requireUser(myPhoneHandler))},
+		{"DELETE", "/users/me/phone", "delete /myPhone", nil, authLimiter.limit(requireUser(myPhoneHandler))},
+		{"POST", "/users/me/phone/verification", "post /verifyPhone", nil, authLimiter.limit(requireUser(verifyPhoneHandler))},
+		{"GET", "/users/me/notification-preferences", "get /notificationPreferences", nil, requireUser(notificationPreferencesHandler)},
+		{"PUT", "/users/me/notification-preferences", "put /notificationPreferences", nil, requireUser(notificationPreferencesHandler)},
+		{"DELETE", "/users/me", "delete /deleteAccount", nil, requireUser(deleteAccountHandler)},
+		{"DELETE", "/users/me/erasure", "post /cancelAccountDeletion", nil, requireUser(cancelAccountDeletionHandler)},
+		{"GET", "/users/me/sessions", "get /sessions", nil, requireUser(sessionsHandler)},
+		{"DELETE", "/users/me/sessions/{id}", "delete /sessions", map[string]string{"id": "id"}, requireUser(sessionsHandler)},
+		{"GET", "/users/me/export", "get /exportMyData", nil, requireUser(exportMyDataHandler)},
+		{"POST", "/users/me/verification", "post /resendVerification", nil, authLimiter.limit(requireUser(resendVerificationHandler))},
+		{"POST", "/users/me/rotate-code", "post /rotateSecretCode", nil, authLimiter.limit(requireUser(rotateSecretCodeHandler))},
+		{"GET", "/users/me/complaints", "get /getAllComplaintsForUser", nil, requireUser(getAllComplaintsForUserHandler)},
+		{"GET", "/users/{id}/avatar", "get /avatar", map[string]string{"id": "userId"}, anyRole(avatarHandler)},
+		{"GET", "/users/me/notifications", "get /notifications", nil, requireUser(notificationsHandler)},
+		{"POST", "/users/me/notifications/read-all", "post /readAllNotifications", nil, requireUser(readAllNotificationsHandler)},
+		{"POST", "/users/me/notifications/{id}/read", "post /readNotification", map[string]string{"id": "id"}, requireUser(readNotificationHandler)},
+		{"GET", "/users/me/watched", "get /watchedComplaints", nil, requireUser(watchedComplaintsHandler)},
+		{"GET", "/users/me/assigned", "get /myAssignedComplaints", nil, staffOnly(myAssignedComplaintsHandler)},
+		{"PUT", "/users/{userId}/role", "post /setUserRole", map[string]string{"userId": "userId"}, adminOnly(setUserRoleHandler)},
+		{"GET", "/orgs", "get /orgs", nil, adminOnly(orgsHandler)},
+		{"POST", "/orgs", "post /orgs", nil, platformOnly(orgsHandler)},
+		{"GET", "/api-keys", "get /apiKeys", nil, adminOnly(apiKeysHandler)},
+		{"POST", "/api-keys", "post /apiKeys", nil, adminOnly(apiKeysHandler)},
+		{"DELETE", "/api-keys/{id}", "delete /apiKeys", map[string]string{"id": "id"}, adminOnly(apiKeysHandler)},
+		{"POST", "/orgs/{orgId}/invites", "post /inviteOrgAdmin", map[string]string{"orgId": "orgId"}, adminOnly(inviteOrgAdminHandler)},
+		{"POST", "/auth/invites/accept", "post /acceptInvite", nil, authLimiter.limit(acceptInviteHandler)},
+
+		{"POST", "/complaints", "post /submitComplaint", nil, requireUser(submitLimiter.limitCaller(submitComplaintHandler))},
+		{"GET", "/complaints", "get /getAllComplaintsForAdmin", nil, staffOnly(getAllComplaintsForAdminHandler)},
+		{"GET", "/complaints/search", "get /complaints/search", nil, anyRole(searchComplaintsHandler)},
+		{"GET", "/complaints/clusters", "get /complaintClusters", nil, staffOnly(complaintClustersHandler)},
+		{"GET", "/complaints/changes", "get /complaintChanges", nil, staffOnly(complaintChangesHandler)},
+		{"GET", "/complaints/socket", "get /complaintSocket", nil, staffOnly(complaintSocketHandler)},
+		{"POST", "/complaints/resolve", "post /resolveBatch", nil, staffOnly(resolveBatchHandler)},
+		{"POST", "/complaints/bulk", "post /admin/complaints/bulk", nil, staffOnly(bulkComplaintsHandler)},
+		{"POST", "/complaints/anonymous", "post /submitAnonymousComplaint", nil, authLimiter.limit(submitAnonymousComplaintHandler)},
+		{"GET", "/complaints/tracked", "get /trackComplaint", nil, authLimiter.limit(trackComplaintHandler)},
+		{"GET", "/complaints/tracked/comments", "get /trackComplaint/comments", nil, authLimiter.limit(trackComplaintCommentsHandler)},
+		{"POST", "/complaints/tracked/comments", "post /trackComplaint/comments", nil, authLimiter.limit(trackComplaintCommentsHandler)},
+		{"GET", "/complaints/{id}", "get /viewComplaint", idVar, anyRole(viewComplaintHandler)},
+		{"DELETE", "/complaints/{id}", "delete /deleteComplaint", idVar, anyRole(deleteComplaintHandler)},
+		{"PATCH", "/complaints/{id}/restore", "post /restoreComplaint", idVar, adminOnly(restoreComplaintHandler)},
+		{"PATCH", "/complaints/{id}", "patch /editComplaint", idVar, requireUser(editComplaintHandler)},
+		{"GET", "/complaints/{id}/history", "get /complaintHistory", idVar, anyRole(complaintHistoryHandler)},
+		{"GET", "/complaints/{id}/revisions", "get /complaintRevisions", idVar, anyRole(complaintRevisionsHandler)},
+		{"GET", "/complaints/{id}/feedback", "get /complaintFeedback", idVar, anyRole(complaintFeedbackHandler)},
+		{"POST", "/complaints/{id}/feedback", "post /complaintFeedback", idVar, requireUser(complaintFeedbackHandler)},
+		{"POST", "/complaints/{id}/transition", "post /complaints/{id}/transition", nil, anyRole(withComplaintID(transitionComplaintHandler))},
+		{"PATCH", "/complaints/{id}/resolve", "post /resolveComplaint", idVar, staffOnly(resolveComplaintHandler)},
+		{"POST", "/complaints/{id}/reopen", "post /reopenComplaint", idVar, requireUser(reopenComplaintHandler)},
+		{"PATCH", "/complaints/{id}/reopen", "post /reopenComplaint", idVar, requireUser(reopenComplaintHandler)},
+		{"POST", "/complaints/{id}/approval", "post /approveResolution", idVar, staffOnly(approveResolutionHandler)},
+		{"PUT", "/complaints/{id}/assignee", "post /assignComplaint", idVar, staffOnly(assignComplaintHandler)},
+		{"DELETE", "/complaints/{id}/assignee", "post /unassignComplaint", idVar, staffOnly(unassignComplaintHandler)},
+		{"PUT", "/complaints/{id}/category", "post /recategorizeComplaint", idVar, staffOnly(recategorizeComplaintHandler)},
+		{"POST", "/complaints/{id}/tags", "post /tagComplaint", idVar, staffOnly(tagComplaintHandler)},
+		{"DELETE", "/complaints/{id}/tags/{tag}", "post /untagComplaint", map[string]string{"id": "complaintId", "tag": "tag"}, staffOnly(untagComplaintHandler)},
+		{"GET", "/complaints/{id}/category-suggestions", "get /categorySuggestions", idVar, staffOnly(categorySuggestionsHandler)},
+		{"PUT", "/complaints/{id}/master", "post /masterComplaint", idVar, staffOnly(masterComplaintHandler)},
+		{"DELETE", "/complaints/{id}/master", "delete /masterComplaint", idVar, staffOnly(masterComplaintHandler)},
+		{"POST", "/complaints/{id}/merge", "post /mergeComplaint", idVar, staffOnly(mergeComplaintHandler)},
+		{"PUT", "/complaints/{id}/related/{relatedId}", "post /relatedComplaints", map[string]string{"id": "complaintId", "relatedId": "relatedId"}, staffOnly(relatedComplaintsHandler)},
+		{"DELETE", "/complaints/{id}/related/{relatedId}", "delete /relatedComplaints", map[string]string{"id": "complaintId", "relatedId": "relatedId"}, staffOnly(relatedComplaintsHandler)},
+		{"PUT", "/complaints/{id}/ticket", "post /linkTicket", idVar, staffOnly(linkTicketHandler)},
+		{"DELETE", "/complaints/{id}/ticket", "post /unlinkTicket", idVar, staffOnly(unlinkTicketHandler)},
+		{"PUT", "/complaints/{id}/watch", "post /watchComplaint", idVar, requireUser(watchComplaintHandler)},
+		{"DELETE", "/complaints/{id}/watch", "post /unwatchComplaint", idVar, requireUser(unwatchComplaintHandler)},
+		{"PUT", "/complaints/{id}/follow-up", "post /setFollowUp", idVar, requireUser(setFollowUpHandler)},
+		{"GET", "/complaints/{id}/report.pdf", "get /complaints/{id}/report.pdf", nil, anyRole(withComplaintID(complaintReportHandler))},
+		{"GET", "/complaints/{id}/comments", "get /complaints/{id}/comments", nil, anyRole(withComplaintID(commentsHandler))},
+		{"POST", "/complaints/{id}/comments", "post /complaints/{id}/comments", nil, anyRole(withComplaintID(commentsHandler))},
+		{"GET", "/complaints/{id}/attachments", "get /complaints/{id}/attachments", nil, anyRole(withComplaintID(attachmentsHandler))},
+		{"POST", "/complaints/{id}/attachments", "post /complaints/{id}/attachments", nil, anyRole(withComplaintID(attachmentsHandler))},
+		{"GET", "/complaints/{id}/attachments/{fileId}", "get /complaints/{id}/attachments/{fileId}", nil,
+			anyRole(withComplaintID(func(w http.ResponseWriter, r *http.Request, oid primitive.ObjectID) {
+				downloadAttachmentHandler(w, r, oid, mux.Vars(r)["fileId"])
+			}))},
+
+		{"GET", "/tags", "get /complaintTags", nil, adminOnly(tagsHandler)},
+		{"GET", "/moderation", "get /moderationQueue", nil, adminOnly(moderationQueueHandler)},
+		{"POST", "/moderation/{id}/approve", "post /approveFlagged", idVar, adminOnly(approveFlaggedHandler)},
+		{"POST", "/moderation/{id}/reject", "post /rejectFlagged", idVar, adminOnly(rejectFlaggedHandler)},
+		{"GET", "/categories", "get /categories", nil, anyRole(categoriesHandler)},
+		{"POST", "/categories", "post /categories", nil, platformOnly(categoriesHandler)},
+		{"PUT", "/categories/{id}", "put /categories", map[string]string{"id": "id"}, platformOnly(categoriesHandler)},
+		{"DELETE", "/categories/{id}", "delete /categories", map[string]string{"id": "id"}, platformOnly(categoriesHandler)},
+		{"GET", "/templates", "get /replyTemplates", nil, staffOnly(replyTemplatesHandler)},
+		{"POST", "/templates", "post /replyTemplates", nil, platformOnly(replyTemplatesHandler)},
+		{"PUT", "/templates/{id}", "put /replyTemplates", map[string]string{"id": "id"}, platformOnly(replyTemplatesHandler)},
+		{"DELETE", "/templates/{id}", "delete /replyTemplates", map[string]string{"id": "id"}, platformOnly(replyTemplatesHandler)},
+		{"GET", "/saved-searches", "get /savedSearches", nil, staffOnly(savedSearchesHandler)},
+		{"POST", "/saved-searches", "post /savedSearches", nil, staffOnly(savedSearchesHandler)},
+		{"PUT", "/saved-searches/{id}", "put /savedSearches", map[string]string{"id": "id"}, staffOnly(savedSearchesHandler)},
+		{"DELETE", "/saved-searches/{id}", "delete /savedSearches", map[string]string{"id": "id"}, staffOnly(savedSearchesHandler)},
+
+		{"GET", "/events", "get /events", nil, anyRole(eventsHandler)},
+		{"GET", "/webhooks", "get /webhooks", nil, platformOnly(webhooksHandler)},
+		{"POST", "/webhooks", "post /webhooks", nil, platformOnly(webhooksHandler)},
+		{"GET", "/webhooks/deliveries", "get /webhookDeliveries", nil, platformOnly(webhookDeliveriesHandler)},
+		{"DELETE", "/webhooks/{id}", "delete /webhooks", map[string]string{"id": "id"}, platformOnly(webhooksHandler)},
+		{"GET", "/integrations/chat", "get /admin/chat", nil, platformOnly(chatChannelsHandler)},
+		{"POST", "/integrations/chat/{name}/test", "post /admin/chat/test", map[string]string{"name": "name"}, platformOnly(testChatHandler)},
+		{"GET", "/jobs", "get /admin/jobs", nil, platformOnly(jobsHandler)},
+		{"POST", "/imports", "post /admin/import", nil, adminOnly(importHandler)},
+		{"GET", "/archive/complaints", "get /admin/archive", nil, adminOnly(archivedComplaintsHandler)},
+		{"GET", "/archive/complaints/{id}", "get /admin/archive", idVar, adminOnly(archivedComplaintsHandler)},
+		{"GET", "/audit/status-changes", "get /auditLog", nil, staffOnly(auditLogHandler)},
+		{"GET", "/audit/logs", "get /auditLogs", nil, adminOnly(auditLogsHandler)},
+
+		{"GET", "/exports/complaints", "get /admin/complaints/export", nil, staffOnly(exportComplaintsHandler)},
+		{"GET", "/exports/complaints.csv", "get /exportComplaints.csv", nil, staffOnly(exportComplaintsCSVHandler)},
+		{"GET", "/exports/complaints.xlsx", "get /exportComplaints.xlsx", nil, staffOnly(exportComplaintsXLSXHandler)},
+		{"GET", "/reports/by-reporter", "get /complaintsByReporter", nil, staffOnly(complaintsByReporterHandler)},
+		{"GET", "/reports/resolution-times", "get /resolutionTimes", nil, staffOnly(resolutionTimesHandler)},
+		{"GET", "/reports/ratings", "get /ratingBreakdown", nil, staffOnly(ratingBreakdownHandler)},
+		{"GET", "/reports/heatmap", "get /complaintHeatmap", nil, staffOnly(complaintHeatmapHandler)},
+		{"GET", "/reports/stats", "get /admin/stats", nil, staffOnly(adminStatsHandler)},
+		{"GET", "/reports/dashboard", "get /admin/dashboard", nil, staffOnly(dashboardHandler)},
+		{"GET", "/reports/categories", "get /categoryBreakdown", nil, staffOnly(categoryBreakdownHandler)},
+	}
+
+	// Routes go on the root router rather than a PathPrefix subrouter, which
+	// answers a method mismatch with 404 instead of 405.
+	router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowed)
+	for _, rt := range apiRoutes {
+		router.HandleFunc(apiPrefix+rt.Path, rt.serve).Methods(rt.Method)
+	}
+	handle(apiPrefix+"/", router.ServeHTTP)
+}
+
+// routePattern names the route a request is for, for metrics and span
+// names: the mux template for /api/v1 routes, else the ServeMux pattern.
+func routePattern(r *http.Request) string {
+	var match mux.RouteMatch
+	if router.Match(r, &match) && match.Route != nil {
+		if tmpl, err := match.Route.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	if _, pattern := http.DefaultServeMux.Handler(r); pattern != "" {
+		return pattern
+	}
+	return "unmatched"
+}
+
+// v1Operations derives the spec entries for the /api/v1 routes from the
+// legacy entries they mirror.
+func v1Operations() []apiOperation {
+	legacy := make(map[string]apiOperation, len(apiOperations))
+	for _, op := range apiOperations {
+		legacy[op.Method+" "+op.Path] = op
+	}
+	var ops []apiOperation
+	for _, rt := range apiRoutes {
+		op, ok := legacy[rt.Legacy]
+		if !ok {
+			log.Printf("route %s %s%s mirrors %q, which the OpenAPI spec does not document", rt.Method, apiPrefix, rt.Path, rt.Legacy)
+			continue
+		}
+		op.Method = strings.ToLower(rt.Method)
+		op.Path = apiPrefix + rt.Path
+		params := make([]apiParam, 0, len(op.Params))
+		for _, p := range op.Params {
+			for v, param := range rt.Vars {
+				if p.Name == param && p.In == "" {
+					p.Name, p.In, p.Required = v, "path", true
+				}
+			}
+			params = append(params, p)
+		}
+		op.Params = params
+		ops = append(ops, op)
+	}
+	return ops
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+// savedSearchParams are the admin listing parameters a saved search may
+// hold; paging parameters such as cursor belong to a single request.
+var savedSearchParams = map[string]bool{
+	"resolved": true, "minRating": true, "maxRating": true, "from": true, "to": true, "tz": true,
+	"userId": true, "category": true, "tags": true, "assignedTo": true, "includeDeleted": true,
+	"sort": true, "order": true, "limit": true, "includeDuplicateCount": true,
+}
+
+type savedSearchRequest struct {
+	Name   string            `json:"name"`
+	Params map[string]string `json:"params"`
+}
+
+// savedSearchesHandler lets staff list (GET), create (POST), update (PUT
+// ?id=) and remove (DELETE ?id=) their own saved searches.
+func savedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	owner := actorName(r)
+	switch r.Method {
+	case http.MethodGet:
+		cursor, err := documents.Collection("savedSearches").Find(dbContext(r), bson.M{"owner": owner}, options.Find().SetSort(bson.M{"name": 1}))
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		searches := []models.SavedSearch{}
+		if err := cursor.All(dbContext(r), &searches); err != nil {
+			serverError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(searches)
+	case http.MethodPost, http.MethodPut:
+		saveSavedSearch(w, r, owner)
+	case http.MethodDelete:
+		oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+		if err != nil {
+			writeFieldError(w, "id", "must be a valid ID")
+			return
+		}
+		res, err := documents.Collection("savedSearches").DeleteOne(dbContext(r), bson.M{"_id": oid, "owner": owner})
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if res.DeletedCount == 0 {
+			http.Error(w, "Saved search not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// saveSavedSearch checks the parameters the way the listing will read them,
+// so a saved search cannot fail every time it is applied.
+func saveSavedSearch(w http.ResponseWriter, r *http.Request, owner string) {
+	var req savedSearchRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var errs validationErrors
+	var oid primitive.ObjectID
+	if r.Method == http.MethodPut {
+		var err error
+		if oid, err = primitive.ObjectIDFromHex(r.URL.Query().Get("id")); err != nil {
+			errs.add("id", "must be a valid ID")
+		}
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		errs.add("name", "is required")
+	}
+	params := map[string]string{}
+	query := url.Values{}
+	for k, v := range req.Params {
+		if !savedSearchParams[k] {
+			errs.add("params."+k, "is not a listing parameter")
+			continue
+		}
+		if v = strings.TrimSpace(v); v != "" {
+			params[k] = v
+			query.Set(k, v)
+		}
+	}
+	probe := r.Clone(r.Context())
+	probe.URL = &url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	_, listErrs := adminComplaintFilter(probe)
+	intParam(probe, "limit", 50, 1, 200, &listErrs)
+	adminSortParams(probe, &listErrs)
+	for _, e := range listErrs {
+		errs.add("params."+e.Field, e.Message)
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	now := time.Now().UTC()
+	if r.Method == http.MethodPost {
+		search := models.SavedSearch{ID: primitive.NewObjectID(), Owner: owner, Name: name, Params: params,
+			CreatedAt: now, UpdatedAt: now}
+		if _, err := documents.Collection("savedSearches").InsertOne(dbContext(r), search); err != nil {
+			serverError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(search)
+		return
+	}
+
+	var search models.SavedSearch
+	err := documents.Collection("savedSearches").FindOneAndUpdate(dbContext(r),
+		bson.M{"_id": oid, "owner": owner},
+		bson.M{"$set": bson.M{"name": name, "params": params, "updatedAt": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&search)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Saved search not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(search)
+}
+
+// applySavedSearch returns r with the caller's saved search id filled in
+// under its own query parameters, which win where both are given.
+func applySavedSearch(w http.ResponseWriter, r *http.Request, id string) (*http.Request, bool) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		writeFieldError(w, "savedSearch", "must be a valid ID")
+		return r, false
+	}
+	var search models.SavedSearch
+	err = documents.Collection("savedSearches").FindOne(dbContext(r), bson.M{"_id": oid, "owner": actorName(r)}).Decode(&search)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Saved search not found", http.StatusNotFound)
+		return r, false
+	}
+	if err != nil {
+		serverError(w, err)
+		return r, false
+	}
+
+	q := r.URL.Query()
+	q.Del("savedSearch")
+	for k, v := range search.Params {
+		if !q.Has(k) {
+			q.Set(k, v)
+		}
+	}
+	applied := r.Clone(r.Context())
+	u := *r.URL
+	u.RawQuery = q.Encode()
+	applied.URL = &u
+	return applied, true
+}
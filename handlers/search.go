@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+	"complain/store"
+)
+
+// searchComplaintsHandler runs a text search over titles and summaries,
+// best matches first. Staff search every complaint, users only their own.
+func searchComplaintsHandler(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeFieldError(w, "q", "is required")
+		return
+	}
+	var errs validationErrors
+	page, pageSize := pageParams(r, 20, 100, &errs)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	filter := bson.M{"$text": bson.M{"$search": q}, "deletedAt": store.NotDeleted()}
+	if _, ok := staffIdentity(r); !ok {
+		userID, _ := currentUserID(r)
+		filter["userId"] = userID
+	}
+
+	total, err := listingCollection("complaints").CountDocuments(dbContext(r), filter)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	score := bson.M{"$meta": "textScore"}
+	cursor, err := listingCollection("complaints").Find(dbContext(r), filter, options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page-1)*pageSize)).
+		SetLimit(int64(pageSize)))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer cursor.Close(dbContext(r))
+
+	resp := pagedComplaints{Page: page, PageSize: pageSize, Total: total, Complaints: []models.Complaint{}}
+	for cursor.Next(dbContext(r)) {
+		var complaint models.Complaint
+		if err := cursor.Decode(&complaint); err != nil {
+			serverError(w, err)
+			return
+		}
+		truncateSummary(&complaint)
+		limitAttachments(&complaint)
+		computeFields(&complaint, time.Now())
+		resp.Complaints = append(resp.Complaints, complaint)
+	}
+	addReporterAvatars(r, resp.Complaints)
+
+	json.NewEncoder(w).Encode(resp)
+}
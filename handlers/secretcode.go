@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"complain/store"
+)
+
+// secretCodeEncoding avoids lowercase and padding so codes are easy to read
+// out and type.
+var secretCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateSecretCode returns 120 random bits as a 24 character code. Only its
+// hash is stored, and login looks users up by that hash, so the code must
+// carry enough entropy on its own to resist guessing.
+func generateSecretCode() (string, error) {
+	raw := make([]byte, 15)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return secretCodeEncoding.EncodeToString(raw), nil
+}
+
+type secretCodeResponse struct {
+	SecretCode string `json:"secretCode"`
+}
+
+// rotateSecretCodeHandler issues the caller a new secret code, which stops
+// the old one from logging in, and revokes every session of the caller's,
+// including the one making the request.
+func rotateSecretCodeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := currentUserID(r)
+	code, err := generateSecretCode()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	err = userStore.SetSecretCodeHash(dbContext(r), userID, hashToken(code))
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if _, err := revokeSessions(dbContext(r), bson.M{"userId": userID}); err != nil {
+		requestLogger(r).Error("revoke sessions", "user_id", userID.Hex(), "error", err)
+	}
+	recordAudit(r, auditRotateCode, auditTargetUser, userID, nil, nil)
+
+	json.NewEncoder(w).Encode(secretCodeResponse{SecretCode: code})
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+// seedDomain is reserved for testing, so seeded addresses never reach
+// anyone.
+const seedDomain = "example.test"
+
+var (
+	seedFirstNames = []string{"Aarav", "Priya", "Rohan", "Ananya", "Vikram", "Meera", "Arjun", "Kavya", "Sameer", "Isha", "Dev", "Nisha"}
+	seedLastNames  = []string{"Sharma", "Patel", "Iyer", "Reddy", "Gupta", "Nair", "Singh", "Das", "Menon", "Joshi"}
+	seedCategories = []models.Category{
+		{ID: "billing", Name: "Billing", Description: "Charges, refunds and invoices"},
+		{ID: "delivery", Name: "Delivery", Description: "Late, missing or damaged orders"},
+		{ID: "product", Name: "Product", Description: "Defects and quality problems"},
+		{ID: "service", Name: "Customer service", Description: "How a request was handled"},
+	}
+	seedSubjects = map[string][]string{
+		"billing":  {"Charged twice for one order", "Refund not received", "Wrong amount on invoice", "Subscription renewed without notice"},
+		"delivery": {"Package arrived damaged", "Order is two weeks late", "Delivered to the wrong address", "Items missing from parcel"},
+		"product":  {"Device stops charging", "Screen cracked after a week", "Parts missing from the box", "Colour differs from the listing"},
+		"service":  {"Support chat closed without reply", "Agent was rude on the phone", "Ticket ignored for days", "Promised callback never came"},
+	}
+	seedComments = []string{
+		"Any update on this?",
+		"Attaching the order number for reference.",
+		"Thanks, that helps.",
+		"This is still happening.",
+	}
+)
+
+// seedDatabase fills an empty database with SEED_USERS users, three staff
+// accounts and SEED_COMPLAINTS complaints spread over every status, for
+// development. It refuses a database that already has users, so it never
+// mixes fake data into real data; drop the database to reseed. The secret
+// codes of the staff accounts and the first user are logged.
+func seedDatabase() {
+	ctx := context.Background()
+	existing, err := documents.Collection("users").CountDocuments(ctx, bson.M{})
+	if err != nil {
+		log.Fatalf("seed: %v", err)
+	}
+	if existing > 0 {
+		log.Printf("seed: the database already has %d users; not seeding", existing)
+		return
+	}
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	now := time.Now().UTC()
+
+	for _, c := range seedCategories {
+		c.CreatedBy = "seed"
+		c.CreatedAt, c.UpdatedAt = now, now
+		if _, err := documents.Collection("categories").UpdateOne(ctx, bson.M{"_id": c.ID},
+			bson.M{"$setOnInsert": c}, options.Update().SetUpsert(true)); err != nil {
+			log.Fatalf("seed categories: %v", err)
+		}
+	}
+
+	newUser := func(name, email, role string, createdAt time.Time) models.User {
+		code, err := generateSecretCode()
+		if err != nil {
+			log.Fatalf("seed: %v", err)
+		}
+		return models.User{
+			ID:             primitive.NewObjectID(),
+			SecretCode:     code,
+			SecretCodeHash: hashToken(code),
+			Name:           name,
+			Email:          email,
+			Complaints:     []primitive.ObjectID{},
+			Role:           role,
+			Verified:       true,
+			Locale:         "en",
+			CreatedAt:      createdAt,
+			UpdatedAt:      createdAt,
+		}
+	}
+	staff := []models.User{
+		newUser("Seed Admin", "admin@"+seedDomain, roleAdmin, now),
+		newUser("Seed Manager", "manager@"+seedDomain, roleManager, now),
+		newUser("Seed Agent", "agent@"+seedDomain, roleAgent, now),
+	}
+	agents := []string{staff[1].Email, staff[2].Email}
+	users := make([]models.User, envInt("SEED_USERS", 20))
+	for i := range users {
+		first := seedFirstNames[rng.Intn(len(seedFirstNames))]
+		last := seedLastNames[rng.Intn(len(seedLastNames))]
+		users[i] = newUser(first+" "+last, fmt.Sprintf("user%d@%s", i+1, seedDomain), roleUser,
+			now.Add(-time.Duration(60+rng.Intn(30))*24*time.Hour))
+	}
+
+	statuses := []string{models.StatusOpen, models.StatusInProgress, models.StatusPendingApproval,
+		models.StatusResolved, models.StatusClosed, models.StatusReopened}
+	var complaints, comments []interface{}
+	for i := 0; i < envInt("SEED_COMPLAINTS", 100); i++ {
+		owner := &users[rng.Intn(len(users))]
+		category := seedCategories[rng.Intn(len(seedCategories))].ID
+		subjects := seedSubjects[category]
+		created := now.Add(-time.Duration(rng.Int63n(int64(60 * 24 * time.Hour))))
+		status := statuses[rng.Intn(len(statuses))]
+		c := models.Complaint{
+			ID:        primitive.NewObjectID(),
+			RefNumber: int64(i + 1),
+			Title:     subjects[rng.Intn(len(subjects))],
+			Summary:   "Seeded complaint for development. " + seedComments[rng.Intn(len(seedComments))],
+			Rating:    1 + rng.Intn(5),
+			UserID:    owner.ID,
+			Category:  category,
+			Priority:  priorities[rng.Intn(len(priorities))],
+			Status:    models.StatusOpen,
+			CreatedAt: created,
+			UpdatedAt: created,
+		}
+		c.DueAt = dueDate(created, c.Priority)
+		c.ContentHash = contentHash(c)
+
+		// Walk the complaint through the workflow to its status so its
+		// history, assignment and resolution fields agree.
+		at := created
+		move := func(to, by string) {
+			at = at.Add(time.Duration(1+rng.Intn(48)) * time.Hour)
+			c.History = append(c.History, models.StatusChange{From: c.Status, To: to, ChangedBy: by, ChangedAt: at})
+			c.Status, c.UpdatedAt = to, at
+			c.Version++
+		}
+		if status != models.StatusOpen {
+			agent := agents[rng.Intn(len(agents))]
+			c.AssignedTo = agent
+			c.Assignments = []models.Assignment{{To: agent, By: staff[0].Email, At: created}}
+			move(models.StatusInProgress, agent)
+			switch status {
+			case models.StatusPendingApproval:
+				move(models.StatusPendingApproval, agent)
+			case models.StatusResolved, models.StatusClosed, models.StatusReopened:
+				move(models.StatusResolved, agent)
+				resolvedAt := at
+				c.Resolved, c.ResolvedAt, c.ResolvedBy = true, &resolvedAt, agent
+				if status == models.StatusClosed {
+					move(models.StatusClosed, staff[0].Email)
+				}
+				if status == models.StatusReopened {
+					move(models.StatusReopened, owner.Email)
+					c.History[len(c.History)-1].Reason = "The problem came back"
+					c.Resolved, c.ResolvedAt, c.ResolvedBy = false, nil, ""
+					c.ReopenCount = 1
+				}
+			}
+		}
+
+		for j := rng.Intn(4); j > 0; j-- {
+			author, role, visibility := owner.Email, roleUser, models.CommentPublic
+			if c.AssignedTo != "" && rng.Intn(2) == 0 {
+				author, role = c.AssignedTo, roleAgent
+				if rng.Intn(3) == 0 {
+					visibility = models.CommentInternal
+				}
+			}
+			comments = append(comments, models.Comment{
+				ID:          primitive.NewObjectID(),
+				ComplaintID: c.ID,
+				Author:      author,
+				AuthorRole:  role,
+				Body:        seedComments[rng.Intn(len(seedComments))],
+				Visibility:  visibility,
+				CreatedAt:   created.Add(time.Duration(1+rng.Intn(72)) * time.Hour),
+			})
+			c.CommentCount++
+		}
+		owner.Complaints = append(owner.Complaints, c.ID)
+		complaints = append(complaints, c)
+	}
+
+	docs := make([]interface{}, 0, len(staff)+len(users))
+	for _, u := range append(staff, users...) {
+		docs = append(docs, u)
+	}
+	if _, err := documents.Collection("users").InsertMany(ctx, docs); err != nil {
+		log.Fatalf("seed users: %v", err)
+	}
+	if len(complaints) > 0 {
+		if _, err := documents.Collection("complaints").InsertMany(ctx, complaints); err != nil {
+			log.Fatalf("seed complaints: %v", err)
+		}
+		// Keep the reference sequence ahead of the seeded numbers.
+		if _, err := documents.Collection("counters").UpdateOne(ctx, bson.M{"_id": complaintSequence},
+			bson.M{"$max": bson.M{"seq": int64(len(complaints))}}, options.Update().SetUpsert(true)); err != nil {
+			log.Fatalf("seed counter: %v", err)
+		}
+	}
+	if len(comments) > 0 {
+		if _, err := documents.Collection("comments").InsertMany(ctx, comments); err != nil {
+			log.Fatalf("seed comments: %v", err)
+		}
+	}
+
+	log.Printf("seed: created %d users, %d staff accounts, %d complaints and %d comments",
+		len(users), len(staff), len(complaints), len(comments))
+	for _, u := range append(staff, users[:1]...) {
+		log.Printf("seed: %s (%s) secret code %s", u.Email, u.Role, u.SecretCode)
+	}
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serve runs the HTTP server, over TLS when it is configured, until SIGINT
+// or SIGTERM, then stops accepting connections and gives in-flight requests
+// SHUTDOWN_TIMEOUT to finish.
+// onShutdown runs after that, to stop other listeners.
+// Long-lived streams (SSE, change feeds) are told to stop through their
+// request context, since they would otherwise hold shutdown open until the
+// timeout.
+func serve(addr string, handler http.Handler, onShutdown ...func()) {
+	baseCtx, stopStreams := context.WithCancel(context.Background())
+	defer stopStreams()
+
+	setup := loadTLS()
+	if setup.config != nil {
+		handler = withHSTS(handler)
+	}
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		TLSConfig:         setup.config,
+		ReadHeaderTimeout: envDuration("SERVER_READ_HEADER_TIMEOUT", 10*time.Second),
+		IdleTimeout:       envDuration("SERVER_IDLE_TIMEOUT", 2*time.Minute),
+		BaseContext:       func(net.Listener) context.Context { return baseCtx },
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		if setup.config == nil {
+			log.Printf("listening on %s", addr)
+			errs <- srv.ListenAndServe()
+			return
+		}
+		log.Printf("listening on %s with TLS", addr)
+		errs <- srv.ListenAndServeTLS("", "")
+	}()
+	var redirect *http.Server
+	if redirectAddr := envString("HTTP_REDIRECT_ADDR", ""); redirectAddr != "" && setup.config != nil {
+		redirect = redirectServer(redirectAddr, addr, setup.manager)
+		go func() {
+			log.Printf("redirecting HTTP on %s to HTTPS", redirectAddr)
+			errs <- redirect.ListenAndServe()
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("server: %v", err)
+		}
+		return
+	case sig := <-signals:
+		log.Printf("received %s, shutting down", sig)
+	}
+
+	// Fail readiness first and give the load balancer SHUTDOWN_DRAIN_DELAY to
+	// notice, so it stops sending requests before the listener closes.
+	draining.Store(true)
+	time.Sleep(envDuration("SHUTDOWN_DRAIN_DELAY", 0))
+
+	ctx, cancel := context.WithTimeout(context.Background(), envDuration("SHUTDOWN_TIMEOUT", 30*time.Second))
+	defer cancel()
+	srv.RegisterOnShutdown(stopStreams)
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %v; closing remaining connections", err)
+		srv.Close()
+	}
+	if redirect != nil {
+		redirect.Close()
+	}
+	for _, stop := range onShutdown {
+		stop()
+	}
+	log.Printf("server stopped")
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type serverTime struct {
+	Time   string `json:"time"`
+	UnixMs int64  `json:"unixMs"`
+}
+
+func timeHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	json.NewEncoder(w).Encode(serverTime{
+		Time:   now.UTC().Format(time.RFC3339Nano),
+		UnixMs: now.UnixMilli(),
+	})
+}
+
+// withServerTime stamps every response with the server clock in Unix
+// milliseconds so clients can correct for skew with more precision than the
+// second-granularity Date header.
+func withServerTime(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Server-Time", strconv.FormatInt(time.Now().UnixMilli(), 10))
+		next.ServeHTTP(w, r)
+	})
+}
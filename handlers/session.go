@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+// session is one login: the refresh tokens it rotates through and the
+// access tokens they issue all carry its ID, so revoking it cuts them all
+// off. It expires with its latest refresh token.
+type session struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	UserID     primitive.ObjectID `bson:"userId" json:"-"`
+	UserAgent  string             `bson:"userAgent,omitempty" json:"userAgent,omitempty"`
+	IP         string             `bson:"ip,omitempty" json:"ip,omitempty"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	LastSeenAt time.Time          `bson:"lastSeenAt" json:"lastSeenAt"`
+	ExpiresAt  time.Time          `bson:"expiresAt" json:"expiresAt"`
+	Current    bool               `bson:"-" json:"current"`
+}
+
+// startSession records a new login from r and issues its first tokens.
+func startSession(r *http.Request, user models.User) (tokenPair, error) {
+	now := time.Now().UTC()
+	s := session{
+		ID:         primitive.NewObjectID(),
+		UserID:     user.ID,
+		UserAgent:  r.UserAgent(),
+		IP:         clientIP(r),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(refreshTTL),
+	}
+	if _, err := documents.Collection("sessions").InsertOne(dbContext(r), s); err != nil {
+		return tokenPair{}, err
+	}
+	return issueTokens(dbContext(r), user, s.ID)
+}
+
+// sessionChecks remembers when each session was last confirmed active, so
+// access tokens are checked against the database at most once per
+// SESSION_CHECK_INTERVAL per instance. A session revoked on another instance
+// can therefore outlive revocation by up to that interval.
+var (
+	sessionChecksMu sync.Mutex
+	sessionChecks   = map[primitive.ObjectID]time.Time{}
+)
+
+// sessionActive reports whether a session is still live and, while checking,
+// records that it was just used.
+func sessionActive(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	interval := envDuration("SESSION_CHECK_INTERVAL", 30*time.Second)
+	now := time.Now()
+	sessionChecksMu.Lock()
+	checked, ok := sessionChecks[id]
+	if len(sessionChecks) > 10000 {
+		for sid, at := range sessionChecks {
+			if now.Sub(at) >= interval {
+				delete(sessionChecks, sid)
+			}
+		}
+	}
+	sessionChecksMu.Unlock()
+	if ok && now.Sub(checked) < interval {
+		return true, nil
+	}
+
+	res, err := documents.Collection("sessions").UpdateOne(ctx,
+		bson.M{"_id": id, "expiresAt": bson.M{"$gt": now.UTC()}},
+		bson.M{"$set": bson.M{"lastSeenAt": now.UTC()}})
+	if err != nil {
+		return false, err
+	}
+	sessionChecksMu.Lock()
+	defer sessionChecksMu.Unlock()
+	if res.MatchedCount == 0 {
+		delete(sessionChecks, id)
+		return false, nil
+	}
+	sessionChecks[id] = now
+	return true, nil
+}
+
+// revokeSessions ends the sessions matching filter along with their refresh
+// tokens.
+func revokeSessions(ctx context.Context, filter bson.M) (int64, error) {
+	cursor, err := documents.Collection("sessions").Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var found []session
+	if err := cursor.All(ctx, &found); err != nil {
+		return 0, err
+	}
+	ids := make([]primitive.ObjectID, len(found))
+	sessionChecksMu.Lock()
+	for i, s := range found {
+		ids[i] = s.ID
+		delete(sessionChecks, s.ID)
+	}
+	sessionChecksMu.Unlock()
+
+	if _, err := documents.Collection("refreshTokens").DeleteMany(ctx, bson.M{"sessionId": bson.M{"$in": ids}}); err != nil {
+		return 0, err
+	}
+	res, err := documents.Collection("sessions").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+// sessionsHandler lists the caller's active sessions (GET), most recently
+// used first, and revokes one of them (DELETE ?id=).
+func sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := currentTokenUser(r)
+	switch r.Method {
+	case http.MethodGet:
+		cursor, err := documents.Collection("sessions").Find(dbContext(r),
+			bson.M{"userId": caller.ID, "expiresAt": bson.M{"$gt": time.Now().UTC()}},
+			options.Find().SetSort(bson.D{{Key: "lastSeenAt", Value: -1}}))
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		sessions := []session{}
+		if err := cursor.All(dbContext(r), &sessions); err != nil {
+			serverError(w, err)
+			return
+		}
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == caller.SessionID
+		}
+		json.NewEncoder(w).Encode(sessions)
+	case http.MethodDelete:
+		id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+		if err != nil {
+			writeFieldError(w, "id", "must be a valid ID")
+			return
+		}
+		n, err := revokeSessions(dbContext(r), bson.M{"_id": id, "userId": caller.ID})
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if n == 0 {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
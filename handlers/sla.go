@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"complain/models"
+)
+
+const (
+	slaOnTrack  = "on_track"
+	slaAtRisk   = "at_risk"
+	slaBreached = "breached"
+)
+
+// slaDefaults are the resolution targets per priority, overridable with
+// SLA_LOW, SLA_MEDIUM, SLA_HIGH and SLA_URGENT.
+var slaDefaults = map[string]time.Duration{
+	"low":    7 * 24 * time.Hour,
+	"medium": 72 * time.Hour,
+	"high":   24 * time.Hour,
+	"urgent": 4 * time.Hour,
+}
+
+func slaFor(priority string) time.Duration {
+	if priorityRank(priority) < 0 {
+		priority = defaultPriority
+	}
+	return envDuration("SLA_"+strings.ToUpper(priority), slaDefaults[priority])
+}
+
+// setSLAStatus labels open complaints against their deadline. A complaint is
+// at risk once less than SLA_AT_RISK_PERCENT of its window remains.
+func setSLAStatus(c *models.Complaint, now time.Time) {
+	if c.Resolved || c.DueAt.IsZero() {
+		return
+	}
+	remaining := c.DueAt.Sub(now)
+	window := c.DueAt.Sub(c.CreatedAt)
+	if schedule != nil && remaining > 0 {
+		remaining = schedule.between(now, c.DueAt)
+		window = schedule.between(c.CreatedAt, c.DueAt)
+	}
+	switch {
+	case remaining <= 0:
+		c.SLAStatus = slaBreached
+	case window > 0 && float64(remaining) < float64(window)*float64(envInt("SLA_AT_RISK_PERCENT", 20))/100:
+		c.SLAStatus = slaAtRisk
+	default:
+		c.SLAStatus = slaOnTrack
+	}
+}
+
+// businessSchedule describes when SLA clocks run. Time outside working hours,
+// on non-working weekdays and on holidays does not count toward deadlines.
+type businessSchedule struct {
+	loc      *time.Location
+	open     time.Duration // offset from midnight
+	close    time.Duration
+	days     map[time.Weekday]bool
+	holidays map[string]bool // YYYY-MM-DD in loc
+}
+
+// schedule is nil when BUSINESS_HOURS_SLA is off; SLAs then run on
+// wall-clock time.
+var schedule *businessSchedule
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func initSLA() {
+	if !envBool("BUSINESS_HOURS_SLA", false) {
+		return
+	}
+	s, err := parseBusinessSchedule(
+		envString("BUSINESS_TIMEZONE", "UTC"),
+		envString("BUSINESS_HOURS", "09:00-17:00"),
+		envString("BUSINESS_DAYS", "mon,tue,wed,thu,fri"),
+		envString("BUSINESS_HOLIDAYS", ""),
+	)
+	if err != nil {
+		log.Fatalf("business hours: %v", err)
+	}
+	schedule = s
+}
+
+func parseBusinessSchedule(tz, hours, days, holidays string) (*businessSchedule, error) {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, err
+	}
+	openStr, closeStr, ok := strings.Cut(hours, "-")
+	if !ok {
+		return nil, fmt.Errorf("hours %q must look like 09:00-17:00", hours)
+	}
+	open, err := parseClock(openStr)
+	if err != nil {
+		return nil, err
+	}
+	closing, err := parseClock(closeStr)
+	if err != nil {
+		return nil, err
+	}
+	if closing <= open {
+		return nil, fmt.Errorf("hours %q close before they open", hours)
+	}
+
+	s := &businessSchedule{loc: loc, open: open, close: closing, days: map[time.Weekday]bool{}, holidays: map[string]bool{}}
+	for _, d := range strings.Split(days, ",") {
+		name := strings.ToLower(strings.TrimSpace(d))
+		wd, ok := weekdays[name]
+		if !ok && len(name) > 3 {
+			wd, ok = weekdays[name[:3]]
+			ok = ok && strings.ToLower(wd.String()) == name
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday %q", d)
+		}
+		s.days[wd] = true
+	}
+	for _, h := range strings.Split(holidays, ",") {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", h); err != nil {
+			return nil, fmt.Errorf("holiday %q: %v", h, err)
+		}
+		s.holidays[h] = true
+	}
+	if len(s.days) == 0 {
+		return nil, fmt.Errorf("no working days configured")
+	}
+	return s, nil
+}
+
+func parseClock(v string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(v))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// workingWindow returns the business hours on the day containing t, or false
+// if nobody works that day.
+func (s *businessSchedule) workingWindow(t time.Time) (time.Time, time.Time, bool) {
+	y, m, d := t.In(s.loc).Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, s.loc)
+	if !s.days[midnight.Weekday()] || s.holidays[midnight.Format("2006-01-02")] {
+		return time.Time{}, time.Time{}, false
+	}
+	return midnight.Add(s.open), midnight.Add(s.close), true
+}
+
+func nextMidnight(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+}
+
+// add returns the moment d of business time after start.
+func (s *businessSchedule) add(start time.Time, d time.Duration) time.Time {
+	t := start
+	// Bounded so a schedule with only holidays cannot loop forever.
+	for i := 0; i < 3660; i++ {
+		if open, closing, ok := s.workingWindow(t); ok {
+			if t.Before(open) {
+				t = open
+			}
+			if t.Before(closing) {
+				avail := closing.Sub(t)
+				if d <= avail {
+					return t.Add(d)
+				}
+				d -= avail
+			}
+		}
+		t = nextMidnight(t, s.loc)
+	}
+	return t.Add(d)
+}
+
+// between returns the business time elapsed from a to b.
+func (s *businessSchedule) between(a, b time.Time) time.Duration {
+	var total time.Duration
+	for t := a; t.Before(b); t = nextMidnight(t, s.loc) {
+		open, closing, ok := s.workingWindow(t)
+		if !ok {
+			continue
+		}
+		from, to := maxTime(t, open), minTime(b, closing)
+		if to.After(from) {
+			total += to.Sub(from)
+		}
+	}
+	return total
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// dueDate computes when a complaint created at start breaches its SLA.
+func dueDate(start time.Time, priority string) time.Time {
+	if schedule != nil {
+		return schedule.add(start, slaFor(priority)).UTC()
+	}
+	return start.Add(slaFor(priority))
+}
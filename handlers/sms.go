@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+// SMSSender delivers a text message to a phone number in E.164 form.
+type SMSSender interface {
+	SendSMS(to, body string) error
+}
+
+// twilioSMS sends through Twilio's Messages API.
+type twilioSMS struct {
+	apiURL     string
+	accountSID string
+	authToken  string
+	from       string
+	client     *http.Client
+}
+
+func (t twilioSMS) SendSMS(to, body string) error {
+	form := url.Values{"To": {to}, "From": {t.from}, "Body": {body}}
+	req, err := http.NewRequest(http.MethodPost,
+		t.apiURL+"/2010-04-01/Accounts/"+url.PathEscape(t.accountSID)+"/Messages.json",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(t.accountSID, t.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("twilio: %s %s", resp.Status, apiErr.Message)
+	}
+	return nil
+}
+
+// smsNotifier adapts an SMSSender to the Notifier queue. Texts carry only
+// the body.
+type smsNotifier struct {
+	sender SMSSender
+}
+
+func (n smsNotifier) Notify(to, subject, body string) error {
+	return n.sender.SendSMS(to, body)
+}
+
+// sms is nil unless SMS_PROVIDER names a configured provider, which
+// disables text messages and phone verification.
+var sms Notifier
+
+// smsKinds are the notifications also sent by text to users who chose it.
+var smsKinds = map[string]bool{templateResolution: true, templateEscalation: true}
+
+const (
+	channelEmail = "email"
+	channelSMS   = "sms"
+)
+
+func initSMS() {
+	sms = nil
+	switch provider := envString("SMS_PROVIDER", ""); provider {
+	case "":
+		return
+	case "twilio":
+		t := twilioSMS{
+			apiURL:     strings.TrimRight(envString("TWILIO_API_URL", "https://api.twilio.com"), "/"),
+			accountSID: envString("TWILIO_ACCOUNT_SID", ""),
+			authToken:  envString("TWILIO_AUTH_TOKEN", ""),
+			from:       envString("TWILIO_FROM", ""),
+			client:     &http.Client{Timeout: envDuration("SMS_TIMEOUT", 10*time.Second)},
+		}
+		if t.accountSID == "" || t.authToken == "" || t.from == "" {
+			log.Printf("sms: TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_FROM are required, sms disabled")
+			return
+		}
+		sms = newQueuedNotifier(smsNotifier{t},
+			envInt("NOTIFY_QUEUE_SIZE", 1000),
+			envInt("NOTIFY_WORKERS", 2),
+			envInt("NOTIFY_MAX_ATTEMPTS", 5),
+			envDuration("NOTIFY_RETRY_DELAY", 5*time.Second))
+	default:
+		log.Printf("sms: unknown SMS_PROVIDER %q, sms disabled", provider)
+	}
+}
+
+// wantsChannel reports whether the user chose a notification channel. Users
+// who never chose get email only.
+func wantsChannel(user models.User, channel string) bool {
+	if len(user.NotifyChannels) == 0 {
+		return channel == channelEmail
+	}
+	return slices.Contains(user.NotifyChannels, channel)
+}
+
+var phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// normalizePhone strips the spaces, dashes, dots and parentheses people
+// type and checks what is left is an E.164 number.
+func normalizePhone(s string) (string, bool) {
+	s = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "").Replace(s)
+	return s, phonePattern.MatchString(s)
+}
+
+// phoneVerification is the pending code for a user's phone number, one per
+// user. Like secret codes, only the code's hash is kept.
+type phoneVerification struct {
+	UserID    primitive.ObjectID `bson:"_id"`
+	Phone     string             `bson:"phone"`
+	CodeHash  string             `bson:"codeHash"`
+	Attempts  int                `bson:"attempts"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+}
+
+type phoneRequest struct {
+	Phone string `json:"phone"`
+}
+
+type phoneCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// myPhoneHandler sets the caller's phone number and texts it a six-digit
+// code (PUT), or removes it and stops text messages (DELETE). A new number
+// is unverified, and nothing but the code is sent to it, until
+// verifyPhoneHandler accepts the code.
+func myPhoneHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := currentUserID(r)
+	switch r.Method {
+	case http.MethodPut:
+	case http.MethodDelete:
+		_, err := orgCollection("users").UpdateOne(dbContext(r), bson.M{"_id": userID}, bson.M{
+			"$unset": bson.M{"phone": "", "phoneVerified": ""},
+			"$pull":  bson.M{"notifyChannels": channelSMS},
+			"$inc":   bson.M{"version": 1},
+		})
+		if err == nil {
+			_, err = documents.Collection("phoneVerifications").DeleteOne(dbContext(r), bson.M{"_id": userID})
+		}
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if sms == nil {
+		http.Error(w, "Text messages are not available", http.StatusServiceUnavailable)
+		return
+	}
+	var req phoneRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	phone, ok := normalizePhone(req.Phone)
+	if !ok {
+		writeFieldError(w, "phone", "must be an international number such as +14155550123")
+		return
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	ttl := envDuration("PHONE_VERIFICATION_TTL", 10*time.Minute)
+	_, err = documents.Collection("phoneVerifications").ReplaceOne(dbContext(r), bson.M{"_id": userID},
+		phoneVerification{UserID: userID, Phone: phone, CodeHash: hashToken(code), ExpiresAt: time.Now().Add(ttl).UTC()},
+		options.Replace().SetUpsert(true))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	var user models.User
+	err = orgCollection("users").FindOneAndUpdate(dbContext(r), bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"phone": phone, "phoneVerified": false}, "$inc": bson.M{"version": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	if err := sms.Notify(phone, "", fmt.Sprintf("Your verification code is %s. It expires in %s.", code, ttl)); err != nil {
+		requestLogger(r).Error("send phone verification", "user_id", userID.Hex(), "error", err)
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(user)
+}
+
+// verifyPhoneHandler checks the code texted to the caller's current number.
+// After PHONE_VERIFICATION_ATTEMPTS wrong codes the code is void and a new
+// one has to be requested.
+func verifyPhoneHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req phoneCodeRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Code = strings.TrimSpace(req.Code); req.Code == "" {
+		writeFieldError(w, "code", "is required")
+		return
+	}
+
+	userID, _ := currentUserID(r)
+	user, err := userStore.UserByID(dbContext(r), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	var pending phoneVerification
+	err = documents.Collection("phoneVerifications").FindOne(dbContext(r), bson.M{
+		"_id":       userID,
+		"phone":     user.Phone,
+		"expiresAt": bson.M{"$gt": time.Now().UTC()},
+		"attempts":  bson.M{"$lt": envInt("PHONE_VERIFICATION_ATTEMPTS", 5)},
+	}).Decode(&pending)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "No pending code for this number; request a new one", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(req.Code)), []byte(pending.CodeHash)) != 1 {
+		documents.Collection("phoneVerifications").UpdateOne(dbContext(r), bson.M{"_id": userID}, bson.M{"$inc": bson.M{"attempts": 1}})
+		writeFieldError(w, "code", "is incorrect")
+		return
+	}
+
+	if _, err := documents.Collection("phoneVerifications").DeleteOne(dbContext(r), bson.M{"_id": userID}); err != nil {
+		serverError(w, err)
+		return
+	}
+	err = orgCollection("users").FindOneAndUpdate(dbContext(r),
+		bson.M{"_id": userID, "phone": pending.Phone},
+		bson.M{"$set": bson.M{"phoneVerified": true}, "$inc": bson.M{"version": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "No pending code for this number; request a new one", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	recordAudit(r, auditVerifyPhone, auditTargetUser, userID, bson.M{"phoneVerified": false}, bson.M{"phoneVerified": true})
+
+	json.NewEncoder(w).Encode(user)
+}
+
+type notificationPreferences struct {
+	Channels []string `json:"channels"`
+}
+
+// notificationPreferencesHandler shows (GET) or replaces (PUT) the channels
+// the caller is notified on. Choosing sms needs a verified phone number.
+func notificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := currentUserID(r)
+	user, err := userStore.UserByID(dbContext(r), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		prefs := notificationPreferences{Channels: []string{}}
+		for _, c := range []string{channelEmail, channelSMS} {
+			if wantsChannel(user, c) {
+				prefs.Channels = append(prefs.Channels, c)
+			}
+		}
+		json.NewEncoder(w).Encode(prefs)
+	case http.MethodPut:
+		var req notificationPreferences
+		if err := decodeJSON(r, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var channels []string
+		var errs validationErrors
+		for _, c := range req.Channels {
+			c = strings.ToLower(strings.TrimSpace(c))
+			switch {
+			case c != channelEmail && c != channelSMS:
+				errs.add("channels", "must contain only email and sms")
+			case c == channelSMS && !user.PhoneVerified:
+				errs.add("channels", "sms needs a verified phone number")
+			case !slices.Contains(channels, c):
+				channels = append(channels, c)
+			}
+		}
+		if len(channels) == 0 && len(errs) == 0 {
+			errs.add("channels", "must contain email, sms or both")
+		}
+		if len(errs) > 0 {
+			writeValidationErrors(w, errs)
+			return
+		}
+		_, err := orgCollection("users").UpdateOne(dbContext(r), bson.M{"_id": userID},
+			bson.M{"$set": bson.M{"notifyChannels": channels}, "$inc": bson.M{"version": 1}})
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(notificationPreferences{Channels: channels})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
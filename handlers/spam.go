@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+
+	"complain/models"
+)
+
+// contentHash fingerprints a complaint by its normalized keywords, so
+// submissions differing only in case, punctuation or word order match.
+func contentHash(c models.Complaint) string {
+	kw := keywords(c, envInt("CLUSTER_MIN_WORD_LENGTH", 3))
+	words := make([]string, 0, len(kw))
+	for w := range kw {
+		words = append(words, w)
+	}
+	sort.Strings(words)
+	sum := sha256.Sum256([]byte(strings.Join(words, " ")))
+	return hex.EncodeToString(sum[:])
+}
+
+// isSpamBurst reports whether the user already submitted SPAM_MAX_SIMILAR
+// complaints within SPAM_WINDOW that match this one by content hash or keyword
+// similarity.
+func isSpamBurst(ctx context.Context, complaint models.Complaint) (bool, error) {
+	maxSimilar := envInt("SPAM_MAX_SIMILAR", 3)
+	window := envDuration("SPAM_WINDOW", 10*time.Minute)
+	if maxSimilar <= 0 || window <= 0 {
+		return false, nil
+	}
+	threshold := float64(envInt("SPAM_SIMILARITY_PERCENT", 80)) / 100
+	minLen := envInt("CLUSTER_MIN_WORD_LENGTH", 3)
+
+	recent, err := complaintStore.ComplaintsSince(ctx, complaint.UserID, time.Now().Add(-window))
+	if err != nil {
+		return false, err
+	}
+
+	kw := keywords(complaint, minLen)
+	similar := 0
+	for _, recent := range recent {
+		if recent.ContentHash == complaint.ContentHash || jaccard(kw, keywords(recent, minLen)) >= threshold {
+			similar++
+		}
+		if similar >= maxSimilar {
+			return true, nil
+		}
+	}
+	return false, nil
+}
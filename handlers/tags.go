@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+	"complain/store"
+)
+
+type tagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+type tagUsage struct {
+	Tag   string `bson:"_id" json:"tag"`
+	Count int    `bson:"count" json:"count"`
+}
+
+// normalizeTags lowercases and trims tags, dropping blanks and repeats, and
+// reports whether every tag is of an acceptable length.
+func normalizeTags(tags []string) ([]string, bool) {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		if utf8.RuneCountInString(t) > envInt("TAG_MAX_LENGTH", 32) {
+			return nil, false
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	return out, true
+}
+
+// tagComplaintHandler adds the tags in the body to a complaint. Tags already
+// on it are left as they are.
+func tagComplaintHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req tagsRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	updateTags(w, r, "$addToSet", req.Tags)
+}
+
+// untagComplaintHandler removes the tag named by the tag parameter.
+func untagComplaintHandler(w http.ResponseWriter, r *http.Request) {
+	updateTags(w, r, "$pull", []string{r.URL.Query().Get("tag")})
+}
+
+func updateTags(w http.ResponseWriter, r *http.Request, op string, tags []string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("complaintId"))
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+	tags, ok := normalizeTags(tags)
+	field := "tags"
+	if op == "$pull" {
+		field = "tag"
+	}
+	if !ok {
+		writeFieldError(w, field, "contains a tag that is too long")
+		return
+	}
+	if len(tags) == 0 {
+		writeFieldError(w, field, "is required")
+		return
+	}
+
+	update := bson.M{op: bson.M{"tags": bson.M{"$each": tags}}}
+	if op == "$pull" {
+		update = bson.M{op: bson.M{"tags": bson.M{"$in": tags}}}
+	}
+	var before models.Complaint
+	err = orgCollection("complaints").FindOneAndUpdate(dbContext(r),
+		bson.M{"_id": oid, "deletedAt": store.NotDeleted()},
+		update,
+	).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	// The update's effect is applied to the earlier copy rather than read
+	// back, so the audit entry shows exactly this change.
+	after := before
+	after.Tags = nil
+	for _, t := range before.Tags {
+		if op == "$addToSet" || !slices.Contains(tags, t) {
+			after.Tags = append(after.Tags, t)
+		}
+	}
+	if op == "$addToSet" {
+		for _, t := range tags {
+			if !slices.Contains(after.Tags, t) {
+				after.Tags = append(after.Tags, t)
+			}
+		}
+	}
+	if len(after.Tags) != len(before.Tags) {
+		recordAudit(r, auditTag, auditTargetComplaint, oid, before, after)
+	}
+
+	json.NewEncoder(w).Encode(after)
+}
+
+// tagsHandler lists every tag in use on complaints that are not deleted, most
+// used first.
+func tagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cursor, err := orgCollection("complaints").Aggregate(dbContext(r), bson.A{
+		bson.M{"$match": bson.M{"tags.0": bson.M{"$exists": true}, "deletedAt": store.NotDeleted()}},
+		bson.M{"$unwind": "$tags"},
+		bson.M{"$group": bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}},
+		bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	usage := []tagUsage{}
+	if err := cursor.All(dbContext(r), &usage); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(usage)
+}
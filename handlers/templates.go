@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+const (
+	templateAcknowledgment = "acknowledgment"
+	templateResolution     = "resolution"
+	templateReminder       = "reminder"
+	templateAssignment     = "assignment"
+	templateVerification   = "verification"
+	templateEscalation     = "escalation"
+	templateOrgInvite      = "org_invite"
+)
+
+// notificationData is what notification templates can refer to.
+type notificationData struct {
+	UserName    string
+	ComplaintID string
+	Reference   string
+	Title       string
+	Status      string
+	Priority    string
+	ExpectedSLA string
+	Note        string
+	Link        string
+	ExpiresIn   string
+	OrgName     string
+}
+
+// builtinTemplates are the English and Hindi fallbacks, by locale. The first
+// line of each is the subject and the rest the body.
+var builtinTemplates = map[string]map[string]string{
+	"en": {
+		templateAcknowledgment: "We received your complaint {{.Reference}}\n" +
+			"Hi {{.UserName}},\n\nThanks for reporting {{printf \"%q\" .Title}}. Your reference number is {{.Reference}}.\n" +
+			"We aim to respond within {{.ExpectedSLA}}.\n",
+		templateResolution: "Your complaint {{.Reference}} was resolved\n" +
+			"Hi {{.UserName}},\n\nYour complaint {{printf \"%q\" .Title}} has been resolved.{{if .Note}} {{.Note}}{{end}}\n" +
+			"\nHow satisfied are you with how it was handled? You can rate our service from the complaint page.\n",
+		templateAssignment: "Your complaint {{.Reference}} is being handled\n" +
+			"Hi {{.UserName}},\n\nYour complaint {{printf \"%q\" .Title}} has been assigned to a member of our support team.\n",
+		templateVerification: "Confirm your email address\n" +
+			"Hi {{.UserName}},\n\nPlease confirm this is your email address by opening {{.Link}}\n" +
+			"The link expires in {{.ExpiresIn}}. If you did not register, you can ignore this email.\n",
+		templateEscalation: "Your complaint {{.Reference}} was escalated to {{.Priority}} priority\n" +
+			"Hi {{.UserName}},\n\nYour complaint {{printf \"%q\" .Title}} now has {{.Priority}} priority{{if .Note}} ({{.Note}}){{end}}.\n",
+		templateOrgInvite: "You are invited to administer {{.OrgName}}\n" +
+			"Hi,\n\nYou have been invited to become an admin of {{.OrgName}}. Accept the invitation by opening {{.Link}}\n" +
+			"The link expires in {{.ExpiresIn}}. If you were not expecting this, you can ignore this email.\n",
+		templateReminder: "Reminder: complaint {{.Reference}}\n" +
+			"Hi {{.UserName}},\n\nYou asked us to remind you to check on {{printf \"%q\" .Title}}. It is currently {{.Status}}.\n",
+	},
+	"hi": {
+		templateAcknowledgment: "हमें आपकी शिकायत {{.Reference}} मिल गई है\n" +
+			"नमस्ते {{.UserName}},\n\n{{printf \"%q\" .Title}} की सूचना देने के लिए धन्यवाद। आपकी संदर्भ संख्या {{.Reference}} है।\n" +
+			"हमारा लक्ष्य {{.ExpectedSLA}} के भीतर जवाब देना है।\n",
+		templateResolution: "आपकी शिकायत {{.Reference}} का समाधान हो गया\n" +
+			"नमस्ते {{.UserName}},\n\nआपकी शिकायत {{printf \"%q\" .Title}} का समाधान कर दिया गया है।{{if .Note}} {{.Note}}{{end}}\n" +
+			"\nइसे जिस तरह संभाला गया, उससे आप कितने संतुष्ट हैं? आप शिकायत पृष्ठ पर हमारी सेवा को रेटिंग दे सकते हैं।\n",
+		templateAssignment: "आपकी शिकायत {{.Reference}} पर काम हो रहा है\n" +
+			"नमस्ते {{.UserName}},\n\nआपकी शिकायत {{printf \"%q\" .Title}} हमारी सहायता टीम के एक सदस्य को सौंप दी गई है।\n",
+		templateVerification: "अपने ईमेल पते की पुष्टि करें\n" +
+			"नमस्ते {{.UserName}},\n\nकृपया {{.Link}} खोलकर पुष्टि करें कि यह आपका ईमेल पता है।\n" +
+			"यह लिंक {{.ExpiresIn}} में समाप्त हो जाएगा। यदि आपने पंजीकरण नहीं किया है, तो इस ईमेल को अनदेखा करें।\n",
+		templateEscalation: "आपकी शिकायत {{.Reference}} की प्राथमिकता बढ़ाकर {{.Priority}} कर दी गई\n" +
+			"नमस्ते {{.UserName}},\n\nआपकी शिकायत {{printf \"%q\" .Title}} की प्राथमिकता अब {{.Priority}} है{{if .Note}} ({{.Note}}){{end}}।\n",
+		templateOrgInvite: "आपको {{.OrgName}} का व्यवस्थापक बनने के लिए आमंत्रित किया गया है\n" +
+			"नमस्ते,\n\nआपको {{.OrgName}} का व्यवस्थापक बनने के लिए आमंत्रित किया गया है। आमंत्रण स्वीकार करने के लिए {{.Link}} खोलें।\n" +
+			"यह लिंक {{.ExpiresIn}} में समाप्त हो जाएगा। यदि आपको इसकी अपेक्षा नहीं थी, तो इस ईमेल को अनदेखा करें।\n",
+		templateReminder: "अनुस्मारक: शिकायत {{.Reference}}\n" +
+			"नमस्ते {{.UserName}},\n\nआपने हमसे {{printf \"%q\" .Title}} की स्थिति देखने की याद दिलाने को कहा था। इसकी वर्तमान स्थिति {{.Status}} है।\n",
+	},
+}
+
+var templateCache sync.Map // "<locale>/<kind>" -> *template.Template, or nil if absent
+
+// loadTemplate finds the template for a locale, reading
+// NOTIFY_TEMPLATE_DIR/<locale>/<kind>.tmpl when present. English and Hindi fall
+// back to the built-in templates.
+func loadTemplate(locale, kind string) *template.Template {
+	key := locale + "/" + kind
+	if t, ok := templateCache.Load(key); ok {
+		return t.(*template.Template)
+	}
+
+	var text string
+	if dir := envString("NOTIFY_TEMPLATE_DIR", ""); dir != "" {
+		if b, err := os.ReadFile(filepath.Join(dir, filepath.Base(locale), kind+".tmpl")); err == nil {
+			text = string(b)
+		}
+	}
+	if text == "" {
+		text = builtinTemplates[locale][kind]
+	}
+
+	var t *template.Template
+	if text != "" {
+		t, _ = template.New(key).Parse(text)
+	}
+	templateCache.Store(key, t)
+	return t
+}
+
+// candidateLocales lists the locales to try: the user's exact locale, its base
+// language, the configured default, then English.
+func candidateLocales(locale string) []string {
+	var locales []string
+	add := func(l string) {
+		l = strings.ToLower(strings.TrimSpace(l))
+		for _, seen := range locales {
+			if seen == l {
+				return
+			}
+		}
+		if l != "" {
+			locales = append(locales, l)
+		}
+	}
+	add(locale)
+	if base, _, ok := strings.Cut(locale, "-"); ok {
+		add(base)
+	}
+	add(envString("NOTIFY_DEFAULT_LOCALE", "en"))
+	add("en")
+	return locales
+}
+
+func renderNotification(locale, kind string, data notificationData) (string, string, error) {
+	for _, l := range candidateLocales(locale) {
+		t := loadTemplate(l, kind)
+		if t == nil {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return "", "", err
+		}
+		subject, body, _ := strings.Cut(buf.String(), "\n")
+		return strings.TrimSpace(subject), body, nil
+	}
+	return "", "", fmt.Errorf("no %s template", kind)
+}
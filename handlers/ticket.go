@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+// TicketTracker fetches the current status of a linked ticket.
+type TicketTracker interface {
+	TicketStatus(ctx context.Context, ticket models.ExternalTicket) (string, error)
+}
+
+// ticketTracker is nil when TICKET_STATUS_URL is unset; linked tickets are
+// then stored but never synced.
+var ticketTracker TicketTracker
+
+// httpTicketTracker asks a bridge service for ticket status with
+// GET <base>?system=...&id=..., expecting {"status": "..."} back.
+type httpTicketTracker struct {
+	baseURL string
+	client  *http.Client
+}
+
+func (t httpTicketTracker) TicketStatus(ctx context.Context, ticket models.ExternalTicket) (string, error) {
+	q := url.Values{"system": {ticket.System}, "id": {ticket.ID}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.baseURL+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("ticket status: %s", resp.Status)
+	}
+	var body struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	return body.Status, nil
+}
+
+func initTicketSync() {
+	base := envString("TICKET_STATUS_URL", "")
+	if base == "" {
+		return
+	}
+	ticketTracker = httpTicketTracker{baseURL: base, client: &http.Client{Timeout: 10 * time.Second}}
+	registerJob("ticket-sync", envDuration("TICKET_SYNC_INTERVAL", 15*time.Minute), syncTickets)
+}
+
+func syncTickets(ctx context.Context) error {
+	cursor, err := orgCollection("complaints").Find(ctx, bson.M{"externalTicket": bson.M{"$exists": true}},
+		options.Find().SetProjection(bson.M{"externalTicket": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var c models.Complaint
+		if err := cursor.Decode(&c); err != nil || c.ExternalTicket == nil {
+			continue
+		}
+		reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		status, err := ticketTracker.TicketStatus(reqCtx, *c.ExternalTicket)
+		cancel()
+		if err != nil {
+			log.Printf("sync ticket %s/%s: %v", c.ExternalTicket.System, c.ExternalTicket.ID, err)
+			continue
+		}
+		_, err = orgCollection("complaints").UpdateOne(ctx, bson.M{"_id": c.ID}, bson.M{"$set": bson.M{
+			"externalTicket.status":   status,
+			"externalTicket.syncedAt": time.Now().UTC(),
+		}})
+		if err != nil {
+			log.Printf("sync ticket %s/%s: %v", c.ExternalTicket.System, c.ExternalTicket.ID, err)
+		}
+	}
+	return cursor.Err()
+}
+
+func linkTicketHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("complaintId"))
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+
+	var ticket models.ExternalTicket
+	if err := decodeJSON(r, &ticket); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ticket.System = strings.TrimSpace(ticket.System)
+	ticket.ID = strings.TrimSpace(ticket.ID)
+	var errs validationErrors
+	if ticket.System == "" {
+		errs.add("system", "is required")
+	}
+	if ticket.ID == "" {
+		errs.add("id", "is required")
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	ticket.SyncedAt = time.Time{}
+
+	updateTicket(w, r, oid, bson.M{"$set": bson.M{"externalTicket": ticket}})
+}
+
+func unlinkTicketHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("complaintId"))
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+
+	updateTicket(w, r, oid, bson.M{"$unset": bson.M{"externalTicket": ""}})
+}
+
+func updateTicket(w http.ResponseWriter, r *http.Request, oid primitive.ObjectID, update bson.M) {
+	var complaint models.Complaint
+	bumpVersion(update)
+	err := orgCollection("complaints").FindOneAndUpdate(dbContext(r), bson.M{"_id": oid}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&complaint)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(complaint)
+}
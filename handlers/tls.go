@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsSetup is how the server terminates TLS: with a certificate loaded
+// from TLS_CERT_FILE and TLS_KEY_FILE, or with certificates Let's Encrypt
+// issues for the TLS_AUTOCERT_HOSTS. Both nil means plain HTTP, for
+// deployments that terminate TLS in front of the service.
+type tlsSetup struct {
+	config  *tls.Config
+	manager *autocert.Manager
+}
+
+// loadTLS reads the TLS settings, exiting on a certificate that cannot be
+// loaded so a misconfigured instance never comes up without TLS.
+func loadTLS() tlsSetup {
+	certFile, keyFile := envString("TLS_CERT_FILE", ""), envString("TLS_KEY_FILE", "")
+	hosts := envString("TLS_AUTOCERT_HOSTS", "")
+	switch {
+	case hosts != "" && (certFile != "" || keyFile != ""):
+		log.Fatal("TLS_AUTOCERT_HOSTS cannot be combined with TLS_CERT_FILE or TLS_KEY_FILE")
+	case (certFile == "") != (keyFile == ""):
+		log.Fatal("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	case hosts != "":
+		var names []string
+		for _, h := range strings.Split(hosts, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				names = append(names, h)
+			}
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(names...),
+			Cache:      autocert.DirCache(envString("TLS_AUTOCERT_CACHE_DIR", "autocert-cache")),
+			Email:      envString("TLS_AUTOCERT_EMAIL", ""),
+		}
+		cfg := modernTLS()
+		cfg.GetCertificate = m.GetCertificate
+		// Lets the TLS-ALPN-01 challenge be answered on the HTTPS port, so
+		// issuance works even without the port 80 redirect listener.
+		cfg.NextProtos = append(cfg.NextProtos, "acme-tls/1")
+		return tlsSetup{config: cfg, manager: m}
+	case certFile != "":
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			log.Fatalf("load TLS certificate: %v", err)
+		}
+		cfg := modernTLS()
+		cfg.Certificates = []tls.Certificate{cert}
+		return tlsSetup{config: cfg}
+	}
+	return tlsSetup{}
+}
+
+// modernTLS allows TLS 1.2, or TLS_MIN_VERSION 1.3, with forward-secret
+// AEAD suites only. Go picks the TLS 1.3 suites itself.
+func modernTLS() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	if envString("TLS_MIN_VERSION", "1.2") == "1.3" {
+		cfg.MinVersion = tls.VersionTLS13
+	}
+	return cfg
+}
+
+// withHSTS tells browsers to use HTTPS only, for TLS_HSTS_MAX_AGE. It is
+// off by default since it cannot be taken back before the age runs out.
+func withHSTS(next http.Handler) http.Handler {
+	maxAge := envDuration("TLS_HSTS_MAX_AGE", 0)
+	if maxAge <= 0 {
+		return next
+	}
+	value := "max-age=" + strconv.Itoa(int(maxAge/time.Second))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// redirectServer listens on HTTP_REDIRECT_ADDR, typically :80, and sends
+// every request to the same URL over HTTPS on the listen address's port.
+// In autocert mode it also answers HTTP-01 challenges.
+func redirectServer(addr, httpsAddr string, manager *autocert.Manager) *http.Server {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+	if manager != nil {
+		handler = manager.HTTPHandler(handler)
+	}
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: envDuration("SERVER_READ_HEADER_TIMEOUT", 10*time.Second),
+		IdleTimeout:       envDuration("SERVER_IDLE_TIMEOUT", 2*time.Minute),
+	}
+}
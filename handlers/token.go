@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"complain/models"
+)
+
+const tokenIssuer = "complain"
+
+var (
+	jwtSecret  []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+)
+
+// initAuth loads the JWT signing key from JWT_SECRET. Without one a random key
+// is generated, which works for a single instance but logs everyone out on
+// restart.
+func initAuth() {
+	jwtSecret = []byte(envString("JWT_SECRET", ""))
+	if len(jwtSecret) == 0 {
+		jwtSecret = make([]byte, 32)
+		if _, err := rand.Read(jwtSecret); err != nil {
+			log.Fatalf("generate JWT secret: %v", err)
+		}
+		log.Printf("JWT_SECRET is not set, using a random key; tokens will not survive a restart")
+	}
+	accessTTL = envDuration("JWT_ACCESS_TTL", 15*time.Minute)
+	refreshTTL = envDuration("JWT_REFRESH_TTL", 30*24*time.Hour)
+}
+
+type tokenPair struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int64  `json:"expiresIn"`
+}
+
+// loginRequest carries the secret code in the body, so it stays out of
+// access logs, proxies and browser history.
+type loginRequest struct {
+	SecretCode string `json:"secretCode"`
+}
+
+type loginResponse struct {
+	tokenPair
+	User models.User `json:"user"`
+}
+
+// refreshToken is stored by the SHA-256 of the opaque token so a database
+// leak does not hand out sessions.
+type refreshToken struct {
+	Hash      string             `bson:"_id"`
+	UserID    primitive.ObjectID `bson:"userId"`
+	SessionID primitive.ObjectID `bson:"sessionId,omitempty"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// accessClaims carries the user's role, email and organization so
+// authorization checks do not need a database round trip. Role changes apply
+// from the next refresh. The token ID is the session it belongs to.
+type accessClaims struct {
+	Role  string `json:"role,omitempty"`
+	Email string `json:"email,omitempty"`
+	Org   string `json:"org,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// tokenUser is the caller authenticated by an access token.
+type tokenUser struct {
+	ID        primitive.ObjectID
+	Role      string
+	Email     string
+	OrgID     string
+	SessionID primitive.ObjectID
+}
+
+func issueTokens(ctx context.Context, user models.User, sessionID primitive.ObjectID) (tokenPair, error) {
+	now := time.Now()
+	access, err := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims{
+		Role:  userRole(user),
+		Email: user.Email,
+		Org:   user.OrgID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   user.ID.Hex(),
+			ID:        sessionID.Hex(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTTL)),
+		},
+	}).SignedString(jwtSecret)
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return tokenPair{}, err
+	}
+	refresh := base64.RawURLEncoding.EncodeToString(raw)
+	_, err = documents.Collection("refreshTokens").InsertOne(ctx, refreshToken{
+		Hash:      hashToken(refresh),
+		UserID:    user.ID,
+		SessionID: sessionID,
+		ExpiresAt: now.Add(refreshTTL).UTC(),
+	})
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	return tokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(accessTTL.Seconds()),
+	}, nil
+}
+
+func parseAccessToken(token string) (tokenUser, error) {
+	var claims accessClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}), jwt.WithIssuer(tokenIssuer), jwt.WithExpirationRequired())
+	if err != nil {
+		return tokenUser{}, err
+	}
+	id, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return tokenUser{}, err
+	}
+	// Tokens issued before sessions existed have no session ID and stay
+	// valid until they expire.
+	sid, _ := primitive.ObjectIDFromHex(claims.ID)
+	return tokenUser{ID: id, Role: claims.Role, Email: claims.Email, OrgID: claims.Org, SessionID: sid}, nil
+}
+
+type contextKey int
+
+const tokenUserKey contextKey = iota
+
+var errInvalidToken = errors.New("invalid or expired access token")
+
+// bearerUser validates the Authorization bearer token, if any, and checks
+// that its session has not been revoked. present is false when the request
+// carries no token at all.
+func bearerUser(r *http.Request) (user tokenUser, present bool, err error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return tokenUser{}, false, nil
+	}
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return tokenUser{}, true, errInvalidToken
+	}
+	user, err = parseAccessToken(token)
+	if err != nil {
+		return tokenUser{}, true, errInvalidToken
+	}
+	if !user.SessionID.IsZero() {
+		active, err := sessionActive(r.Context(), user.SessionID)
+		if err != nil {
+			return tokenUser{}, true, err
+		}
+		if !active {
+			return tokenUser{}, true, errInvalidToken
+		}
+	}
+	return user, true, nil
+}
+
+// withAuthentication attaches the token's user to the request context when a
+// bearer token is sent, and rejects requests whose token is invalid. Requests
+// without one pass through; routes decide whether they need a caller.
+func withAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, ok := sentAPIKey(r); ok {
+			withAPIKey(w, r, key, next)
+			return
+		}
+		user, present, err := bearerUser(r)
+		if err != nil && !errors.Is(err, errInvalidToken) {
+			serverError(w, err)
+			return
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if present {
+			r = r.WithContext(context.WithValue(r.Context(), tokenUserKey, user))
+			if info := requestInfoFrom(r.Context()); info != nil {
+				info.User = user.ID.Hex()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireUser guards routes that only signed-in users may call.
+func requireUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := currentUserID(r); !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func currentTokenUser(r *http.Request) (tokenUser, bool) {
+	user, ok := r.Context().Value(tokenUserKey).(tokenUser)
+	return user, ok
+}
+
+// currentUserID returns the user authenticated by the request's access token.
+func currentUserID(r *http.Request) (primitive.ObjectID, bool) {
+	user, ok := currentTokenUser(r)
+	return user.ID, ok
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// refreshHandler exchanges a refresh token for a new token pair. Refresh
+// tokens are single use: the presented one is revoked.
+func refreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req refreshRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var stored refreshToken
+	err := documents.Collection("refreshTokens").FindOneAndDelete(dbContext(r),
+		bson.M{"_id": hashToken(req.RefreshToken), "expiresAt": bson.M{"$gt": time.Now()}},
+	).Decode(&stored)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	var user models.User
+	err = orgCollection("users").FindOne(dbContext(r), bson.M{"_id": stored.UserID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	// A refresh token from before sessions existed starts one.
+	var tokens tokenPair
+	if stored.SessionID.IsZero() {
+		tokens, err = startSession(r, user)
+	} else {
+		tokens, err = issueTokens(dbContext(r), user, stored.SessionID)
+		if err == nil {
+			_, err = documents.Collection("sessions").UpdateOne(dbContext(r), bson.M{"_id": stored.SessionID}, bson.M{"$set": bson.M{
+				"lastSeenAt": time.Now().UTC(),
+				"expiresAt":  time.Now().Add(refreshTTL).UTC(),
+			}})
+		}
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// logoutHandler ends the session of the refresh token in the body or, with
+// no body, of the caller's access token.
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req refreshRequest
+	err := decodeJSON(r, &req)
+	caller, authenticated := currentTokenUser(r)
+	if errors.Is(err, errEmptyBody) && authenticated && !caller.SessionID.IsZero() {
+		if _, err := revokeSessions(dbContext(r), bson.M{"_id": caller.SessionID}); err != nil {
+			serverError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var stored refreshToken
+	err = documents.Collection("refreshTokens").FindOneAndDelete(dbContext(r), bson.M{"_id": hashToken(req.RefreshToken)}).Decode(&stored)
+	if err != nil && err != mongo.ErrNoDocuments {
+		serverError(w, err)
+		return
+	}
+	if !stored.SessionID.IsZero() {
+		if _, err := revokeSessions(dbContext(r), bson.M{"_id": stored.SessionID}); err != nil {
+			serverError(w, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func signTestToken(t *testing.T, claims accessClaims, key []byte) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return token
+}
+
+func TestRequireUser(t *testing.T) {
+	saved := jwtSecret
+	jwtSecret = []byte("test secret")
+	t.Cleanup(func() { jwtSecret = saved })
+	id := primitive.NewObjectID()
+	now := time.Now()
+	valid := accessClaims{
+		Role:  "agent",
+		Email: "ann@example.com",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   id.Hex(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+	}
+	expired := valid
+	expired.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+	otherIssuer := valid
+	otherIssuer.Issuer = "someone-else"
+	noExpiry := valid
+	noExpiry.ExpiresAt = nil
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"valid", "Bearer " + signTestToken(t, valid, jwtSecret), http.StatusOK},
+		{"not bearer", "Basic " + signTestToken(t, valid, jwtSecret), http.StatusUnauthorized},
+		{"expired", "Bearer " + signTestToken(t, expired, jwtSecret), http.StatusUnauthorized},
+		{"other issuer", "Bearer " + signTestToken(t, otherIssuer, jwtSecret), http.StatusUnauthorized},
+		{"no expiry", "Bearer " + signTestToken(t, noExpiry, jwtSecret), http.StatusUnauthorized},
+		{"other key", "Bearer " + signTestToken(t, valid, []byte("other secret")), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		var got tokenUser
+		h := withAuthentication(requireUser(func(w http.ResponseWriter, r *http.Request) {
+			got, _ = currentTokenUser(r)
+		}))
+		r := httptest.NewRequest("GET", "/complaints", nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if tt.want == http.StatusOK && (got.ID != id || got.Role != "agent" || got.Email != "ann@example.com") {
+			t.Errorf("%s: caller %+v, want %s with role agent", tt.name, got, id.Hex())
+		}
+	}
+}
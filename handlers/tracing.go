@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"complain/store"
+)
+
+// initTracing exports spans over OTLP/HTTP to
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, a full URL such as
+// http://collector:4318/v1/traces. Without an endpoint the global tracer
+// stays a no-op and instrumentation costs next to nothing. The returned
+// function flushes buffered spans and must run before exit.
+func initTracing() func(context.Context) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	endpoint := envString("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	if endpoint == "" {
+		return func(context.Context) error { return nil }
+	}
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		log.Printf("tracing disabled: %v", err)
+		return func(context.Context) error { return nil }
+	}
+
+	ratio, err := strconv.ParseFloat(envString("OTEL_TRACES_SAMPLE_RATIO", "1"), 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		log.Printf("invalid OTEL_TRACES_SAMPLE_RATIO, sampling every trace")
+		ratio = 1
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", envString("OTEL_SERVICE_NAME", "complain")),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown
+}
+
+// withTracing starts a server span per request, continuing any trace the
+// caller propagated. Spans are named by method and route pattern so that
+// requests for different complaints group together.
+func withTracing(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + routePattern(r)
+		}),
+	)
+}
+
+type dbContextKey struct{}
+
+// withDBTimeout bounds the database work done for each request, by
+// DB_READ_TIMEOUT for GET and HEAD and DB_WRITE_TIMEOUT otherwise. A read
+// is abandoned as soon as the client hangs up; a write is not, since a
+// client hanging up must not abort it halfway, so only its deadline stops
+// it.
+func withDBTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, timeout := r.Context(), envDuration("DB_READ_TIMEOUT", 10*time.Second)
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			ctx, timeout = context.WithoutCancel(ctx), envDuration("DB_WRITE_TIMEOUT", 30*time.Second)
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), dbContextKey{}, ctx)))
+	})
+}
+
+// dbContext is the context for database work done on behalf of r, with the
+// deadline and cancellation withDBTimeout gave it. It carries the request's
+// span, so Mongo spans nest under the handler's, and is confined to the
+// caller's organization. Requests that did not pass through withDBTimeout,
+// like the CLI's, get no deadline.
+func dbContext(r *http.Request) context.Context {
+	ctx, ok := r.Context().Value(dbContextKey{}).(context.Context)
+	if !ok {
+		ctx = context.WithoutCancel(r.Context())
+	}
+	return orgContext(r, ctx)
+}
+
+// streamContext is for responses that stream for as long as the client
+// keeps reading, like exports and change feeds: it has no deadline and ends
+// when the client hangs up.
+func streamContext(r *http.Request) context.Context {
+	return orgContext(r, r.Context())
+}
+
+// backgroundContext bounds database work done outside any request, like
+// jobs and notifications, by DB_WRITE_TIMEOUT.
+func backgroundContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), envDuration("DB_WRITE_TIMEOUT", 30*time.Second))
+}
+
+// serverError answers a failed database call: 504 if it ran out of time,
+// 499, the status nginx logs for it, if the client hung up first, and 500
+// otherwise.
+func serverError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err):
+		http.Error(w, "Database timed out", http.StatusGatewayTimeout)
+	case errors.Is(err, context.Canceled):
+		http.Error(w, "Client closed request", 499)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// orgContext confines the users and complaints reached through ctx to the
+// organization of r's caller.
+func orgContext(r *http.Request, ctx context.Context) context.Context {
+	if org, ok := callerOrg(r); ok {
+		return store.WithOrg(ctx, org)
+	}
+	return ctx
+}
+
+// commandMonitor traces and times every command the driver sends.
+func commandMonitor() *event.CommandMonitor {
+	return mongoMonitors(otelmongo.NewMonitor(), mongoMonitor())
+}
+
+// mongoMonitors fans driver command events out to tracing and metrics, as
+// the client accepts only one monitor.
+func mongoMonitors(monitors ...*event.CommandMonitor) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			for _, m := range monitors {
+				if m.Started != nil {
+					m.Started(ctx, e)
+				}
+			}
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			for _, m := range monitors {
+				if m.Succeeded != nil {
+					m.Succeeded(ctx, e)
+				}
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			for _, m := range monitors {
+				if m.Failed != nil {
+					m.Failed(ctx, e)
+				}
+			}
+		},
+	}
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"complain/models"
+)
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validationErrors collects every problem with a payload so clients can show
+// them all at once.
+type validationErrors []fieldError
+
+func (v *validationErrors) add(field, message string) {
+	*v = append(*v, fieldError{Field: field, Message: message})
+}
+
+func (v validationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = e.Field + ": " + e.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// writeValidationErrors answers 422 for payloads that parsed but break the
+// rules; bodies that cannot be decoded at all stay 400.
+func writeValidationErrors(w http.ResponseWriter, errs validationErrors) {
+	if locale := writerLocale(w); locale != "en" {
+		translated := make(validationErrors, len(errs))
+		for i, e := range errs {
+			e.Message = translate(locale, e.Message)
+			translated[i] = e
+		}
+		errs = translated
+		w.Header().Set("Content-Language", locale)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(struct {
+		Errors validationErrors `json:"errors"`
+	}{errs})
+}
+
+// writeFieldError reports a single bad field or query parameter in the same
+// format as writeValidationErrors.
+func writeFieldError(w http.ResponseWriter, field, message string) {
+	writeValidationErrors(w, validationErrors{{Field: field, Message: message}})
+}
+
+// intParam reads an optional integer query parameter, recording an error if
+// it is not a whole number between min and max.
+func intParam(r *http.Request, key string, def, min, max int, errs *validationErrors) int {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min || n > max {
+		errs.add(key, fmt.Sprintf("must be a whole number between %d and %d", min, max))
+		return def
+	}
+	return n
+}
+
+// pageParams reads the page and pageSize parameters of offset-paged listings.
+func pageParams(r *http.Request, defSize, maxSize int, errs *validationErrors) (int, int) {
+	page := intParam(r, "page", 1, 1, math.MaxInt32, errs)
+	pageSize := intParam(r, "pageSize", defSize, 1, maxSize, errs)
+	return page, pageSize
+}
+
+// validateComplaint normalizes and checks a submitted complaint. The priority
+// defaults to medium and the category is lowercased before checking.
+func validateComplaint(c *models.Complaint) validationErrors {
+	var errs validationErrors
+	if strings.TrimSpace(c.Title) == "" {
+		errs.add("title", "is required")
+	}
+	if c.Rating < 0 || c.Rating > 5 {
+		errs.add("rating", "must be between 1 and 5, or omitted")
+	}
+	if c.Priority == "" {
+		c.Priority = defaultPriority
+	}
+	if priorityRank(c.Priority) < 0 {
+		errs.add("priority", "must be one of "+strings.Join(priorities, ", "))
+	} else {
+		c.Category = strings.ToLower(strings.TrimSpace(c.Category))
+		if err := validateClassification(c.Category, c.Priority); err != nil {
+			errs.add("category", err.Error())
+		}
+	}
+	return errs
+}
+
+// validateRegistration checks a new user and normalizes their email.
+func validateRegistration(u *models.User) validationErrors {
+	var errs validationErrors
+	if strings.TrimSpace(u.Name) == "" {
+		errs.add("name", "is required")
+	}
+	email, err := normalizeEmail(u.Email)
+	if err != nil {
+		errs.add("email", "must be a valid email address")
+	} else {
+		u.Email = email
+	}
+	return errs
+}
+
+// validateProfileUpdate checks a profile update and normalizes its email.
+func validateProfileUpdate(u *profileUpdate) validationErrors {
+	var errs validationErrors
+	if u.SecretCode != nil {
+		errs.add("secretCode", "cannot be changed")
+	}
+	if u.Complaints != nil {
+		errs.add("complaints", "cannot be changed")
+	}
+	email, err := normalizeEmail(u.Email)
+	if err != nil {
+		errs.add("email", "must be a valid email address")
+	} else {
+		u.Email = email
+	}
+	return errs
+}
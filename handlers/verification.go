@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+// emailVerification is a pending verification, stored by token hash like
+// refresh tokens. It is bound to the address it was sent to, so a link for
+// an address the user has since changed verifies nothing.
+type emailVerification struct {
+	Hash      string             `bson:"_id"`
+	UserID    primitive.ObjectID `bson:"userId"`
+	Email     string             `bson:"email"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+}
+
+// startEmailVerification marks the user unverified and emails them a link to
+// /verifyEmail. The link is valid for EMAIL_VERIFICATION_TTL and points at
+// PUBLIC_URL; sending happens in the background.
+func startEmailVerification(ctx context.Context, user models.User) error {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	ttl := envDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour)
+	_, err := documents.Collection("emailVerifications").InsertOne(ctx, emailVerification{
+		Hash:      hashToken(token),
+		UserID:    user.ID,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(ttl).UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = orgCollection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"verified": false}})
+	if err != nil {
+		return err
+	}
+
+	link := strings.TrimRight(envString("PUBLIC_URL", "http://localhost:8080"), "/") + "/verifyEmail?token=" + url.QueryEscape(token)
+	go sendVerificationEmail(user, link, ttl)
+	return nil
+}
+
+func sendVerificationEmail(user models.User, link string, ttl time.Duration) {
+	if notifier == nil {
+		log.Printf("verification email for user %s: no mailer configured", user.ID.Hex())
+		return
+	}
+	subject, body, err := renderNotification(user.Locale, templateVerification, notificationData{
+		UserName:  user.Name,
+		Link:      link,
+		ExpiresIn: ttl.String(),
+	})
+	if err == nil {
+		err = notifier.Notify(user.Email, subject, body)
+	}
+	if err != nil {
+		log.Printf("verification email for user %s: %v", user.ID.Hex(), err)
+	}
+}
+
+// verifyEmailHandler consumes a verification token. Tokens are single use:
+// a second visit to the same link reports it as invalid.
+func verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeFieldError(w, "token", "is required")
+		return
+	}
+	var pending emailVerification
+	err := documents.Collection("emailVerifications").FindOneAndDelete(dbContext(r), bson.M{
+		"_id":       hashToken(token),
+		"expiresAt": bson.M{"$gt": time.Now().UTC()},
+	}).Decode(&pending)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Verification link is invalid or has expired", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	var user models.User
+	err = orgCollection("users").FindOneAndUpdate(dbContext(r),
+		bson.M{"_id": pending.UserID, "email": pending.Email},
+		bson.M{"$set": bson.M{"verified": true}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Verification link is invalid or has expired", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	recordAudit(r, auditVerifyEmail, auditTargetUser, user.ID, bson.M{"verified": false}, bson.M{"verified": true})
+
+	json.NewEncoder(w).Encode(user)
+}
+
+// resendVerificationHandler emails the caller a fresh link, for when the
+// first one expired or never arrived. Earlier links stay valid until they
+// expire.
+func resendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := currentUserID(r)
+	user, err := userStore.UserByID(dbContext(r), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if user.Verified {
+		http.Error(w, "Email address is already verified", http.StatusConflict)
+		return
+	}
+	if err := startEmailVerification(dbContext(r), user); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"complain/store"
+)
+
+// requireComplaintVersion reads the complaint version a workflow update was
+// based on, from If-Match or the version query parameter.
+func requireComplaintVersion(w http.ResponseWriter, r *http.Request) (int, bool) {
+	var body *int
+	if v := r.URL.Query().Get("version"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeFieldError(w, "version", "must be a non-negative integer")
+			return 0, false
+		}
+		body = &n
+	}
+	version, ok := expectedVersion(r, body)
+	if !ok {
+		http.Error(w, "A version is required via If-Match or the version parameter", http.StatusPreconditionRequired)
+		return 0, false
+	}
+	return version, true
+}
+
+// withVersion makes update apply only to the complaint at version, and bumps
+// the version when it does.
+func withVersion(filter, update bson.M, version int) {
+	filter["version"] = store.VersionFilter(version)
+	bumpVersion(update)
+}
+
+func bumpVersion(update bson.M) {
+	inc, _ := update["$inc"].(bson.M)
+	if inc == nil {
+		inc = bson.M{}
+		update["$inc"] = inc
+	}
+	inc["version"] = 1
+}
+
+func writeVersionConflict(w http.ResponseWriter) {
+	http.Error(w, "Complaint was modified by another request; reload and try again", http.StatusConflict)
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+	"complain/store"
+)
+
+// statusFilter matches complaints in the given status, treating complaints
+// stored before Status existed by their resolved flag.
+func statusFilter(status string) bson.M {
+	switch status {
+	case models.StatusResolved:
+		return bson.M{"$or": bson.A{
+			bson.M{"status": models.StatusResolved},
+			bson.M{"status": bson.M{"$exists": false}, "resolved": true},
+		}}
+	case models.StatusOpen:
+		return bson.M{"$or": bson.A{
+			bson.M{"status": models.StatusOpen},
+			bson.M{"status": bson.M{"$exists": false}, "resolved": false},
+		}}
+	default:
+		return bson.M{"status": status}
+	}
+}
+
+func watchComplaintHandler(w http.ResponseWriter, r *http.Request) {
+	updateWatchers(w, r, "$addToSet")
+}
+
+func unwatchComplaintHandler(w http.ResponseWriter, r *http.Request) {
+	updateWatchers(w, r, "$pull")
+}
+
+func updateWatchers(w http.ResponseWriter, r *http.Request, op string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("complaintId"))
+	if err != nil {
+		writeFieldError(w, "complaintId", "must be a valid ID")
+		return
+	}
+	userID, _ := currentUserID(r)
+
+	var complaint models.Complaint
+	err = orgCollection("complaints").FindOneAndUpdate(dbContext(r),
+		bson.M{"_id": oid},
+		bson.M{op: bson.M{"watchers": userID}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&complaint)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(complaint)
+}
+
+type pagedComplaints struct {
+	Page       int                `json:"page"`
+	PageSize   int                `json:"pageSize"`
+	Total      int64              `json:"total"`
+	Complaints []models.Complaint `json:"complaints"`
+}
+
+// watchedComplaintsHandler lists the complaints the caller is watching, newest
+// first, optionally narrowed by status.
+func watchedComplaintsHandler(w http.ResponseWriter, r *http.Request) {
+	var errs validationErrors
+	page, pageSize := pageParams(r, 20, 100, &errs)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	userID, _ := currentUserID(r)
+
+	filter := bson.M{"watchers": userID, "deletedAt": store.NotDeleted()}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter = bson.M{"$and": bson.A{filter, statusFilter(status)}}
+	}
+
+	total, err := orgCollection("complaints").CountDocuments(dbContext(r), filter)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	cursor, err := orgCollection("complaints").Find(dbContext(r), filter, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page-1)*pageSize)).
+		SetLimit(int64(pageSize)))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer cursor.Close(dbContext(r))
+
+	resp := pagedComplaints{Page: page, PageSize: pageSize, Total: total, Complaints: []models.Complaint{}}
+	for cursor.Next(dbContext(r)) {
+		var complaint models.Complaint
+		if err := cursor.Decode(&complaint); err != nil {
+			serverError(w, err)
+			return
+		}
+		truncateSummary(&complaint)
+		limitAttachments(&complaint)
+		computeFields(&complaint, time.Now())
+		resp.Complaints = append(resp.Complaints, complaint)
+	}
+	addReporterAvatars(r, resp.Complaints)
+
+	json.NewEncoder(w).Encode(resp)
+}
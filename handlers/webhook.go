@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+const (
+	eventComplaintCreated   = "complaint.created"
+	eventComplaintAssigned  = "complaint.assigned"
+	eventComplaintResolved  = "complaint.resolved"
+	eventComplaintReopened  = "complaint.reopened"
+	eventComplaintStatus    = "complaint.status_changed"
+	eventComplaintOverdue   = "complaint.overdue"
+	eventComplaintEdited    = "complaint.edited"
+	eventComplaintEscalated = "complaint.escalated"
+)
+
+var webhookEvents = []string{
+	eventComplaintCreated,
+	eventComplaintAssigned,
+	eventComplaintResolved,
+	eventComplaintReopened,
+	eventComplaintStatus,
+	eventComplaintOverdue,
+	eventComplaintEdited,
+	eventComplaintEscalated,
+}
+
+type webhookPayload struct {
+	ID         string           `json:"id"`
+	Event      string           `json:"event"`
+	OccurredAt time.Time        `json:"occurredAt"`
+	Complaint  models.Complaint `json:"complaint"`
+}
+
+// webhookTarget is somewhere an event is delivered. The legacy WEBHOOK_URL
+// target has no ID, so its deliveries are only logged.
+type webhookTarget struct {
+	ID     primitive.ObjectID
+	URL    string
+	Secret string
+}
+
+// publishEvent delivers an event to every webhook subscribed to it, plus
+// resolutions to WEBHOOK_URL when set. It runs in its own goroutine and only
+// logs failures.
+func publishEvent(event string, complaint models.Complaint) {
+	if msg := eventNotification(event, complaint); msg != "" {
+		notifyInApp(event, complaint, msg, primitive.NilObjectID)
+	}
+	postChat(event, complaint)
+	var targets []webhookTarget
+	if u := envString("WEBHOOK_URL", ""); u != "" && event == eventComplaintResolved {
+		targets = append(targets, webhookTarget{URL: u, Secret: envString("WEBHOOK_SECRET", "")})
+	}
+	ctx, cancel := backgroundContext()
+	defer cancel()
+	cursor, err := documents.Collection("webhooks").Find(ctx, bson.M{"events": event})
+	if err != nil {
+		log.Printf("load webhooks for %s: %v", event, err)
+	} else {
+		var hooks []models.Webhook
+		if err := cursor.All(ctx, &hooks); err != nil {
+			log.Printf("load webhooks for %s: %v", event, err)
+		}
+		for _, h := range hooks {
+			targets = append(targets, webhookTarget{ID: h.ID, URL: h.URL, Secret: h.Secret})
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	eventID := primitive.NewObjectID().Hex()
+	payload, err := json.Marshal(webhookPayload{
+		ID:         eventID,
+		Event:      event,
+		OccurredAt: time.Now().UTC(),
+		Complaint:  complaint,
+	})
+	if err != nil {
+		log.Printf("webhook %s for complaint %s: %v", event, complaint.ID.Hex(), err)
+		return
+	}
+	for _, t := range targets {
+		go deliverWebhook(t, event, eventID, complaint.ID, payload)
+	}
+}
+
+// deliverWebhook posts the payload, retrying with exponential backoff, and
+// records each attempt in webhookDeliveries.
+func deliverWebhook(t webhookTarget, event, eventID string, complaintID primitive.ObjectID, payload []byte) {
+	attempts := envInt("WEBHOOK_ATTEMPTS", 3)
+	backoff := envDuration("WEBHOOK_BACKOFF", time.Second)
+	for attempt := 1; attempt <= attempts; attempt++ {
+		status, err := postWebhook(t, event, eventID, payload)
+		if !t.ID.IsZero() {
+			delivery := models.WebhookDelivery{
+				WebhookID:   t.ID,
+				EventID:     eventID,
+				Event:       event,
+				ComplaintID: complaintID,
+				Attempt:     attempt,
+				StatusCode:  status,
+				Succeeded:   err == nil,
+				At:          time.Now().UTC(),
+			}
+			if err != nil {
+				delivery.Error = err.Error()
+			}
+			ctx, cancel := backgroundContext()
+			if _, derr := documents.Collection("webhookDeliveries").InsertOne(ctx, delivery); derr != nil {
+				log.Printf("record webhook delivery: %v", derr)
+			}
+			cancel()
+		}
+		if err == nil {
+			return
+		}
+		log.Printf("webhook %s for complaint %s, attempt %d/%d: %v", event, complaintID.Hex(), attempt, attempts, err)
+		if attempt < attempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+// signPayload is the hex HMAC-SHA256 of "<timestamp>.<body>". Including the
+// timestamp lets receivers reject replayed deliveries.
+func signPayload(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func postWebhook(t webhookTarget, event, eventID string, payload []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), envDuration("WEBHOOK_TIMEOUT", 10*time.Second))
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set("X-Webhook-Delivery", eventID)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	if t.Secret != "" {
+		req.Header.Set("X-Webhook-Signature", "sha256="+signPayload(t.Secret, timestamp, payload))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// webhooksHandler lists (GET), registers (POST) and removes (DELETE ?id=)
+// webhooks. The signing secret is only returned by POST.
+func webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		cursor, err := documents.Collection("webhooks").Find(dbContext(r), bson.M{},
+			options.Find().SetSort(bson.M{"createdAt": 1}).SetProjection(bson.M{"secret": 0}))
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		hooks := []models.Webhook{}
+		if err := cursor.All(dbContext(r), &hooks); err != nil {
+			serverError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(hooks)
+	case http.MethodPost:
+		createWebhook(w, r)
+	case http.MethodDelete:
+		oid, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+		if err != nil {
+			writeFieldError(w, "id", "must be a valid ID")
+			return
+		}
+		res, err := documents.Collection("webhooks").DeleteOne(dbContext(r), bson.M{"_id": oid})
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if res.DeletedCount == 0 {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func createWebhook(w http.ResponseWriter, r *http.Request) {
+	var req webhookRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var errs validationErrors
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		errs.add("url", "must be an absolute http or https URL")
+	}
+	if len(req.Events) == 0 {
+		errs.add("events", "must list at least one event")
+	}
+	for _, e := range req.Events {
+		if !slices.Contains(webhookEvents, e) {
+			errs.add("events", fmt.Sprintf("%q is not one of %s", e, strings.Join(webhookEvents, ", ")))
+		}
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		serverError(w, err)
+		return
+	}
+	createdBy, _ := adminIdentity(r)
+	hook := models.Webhook{
+		ID:        primitive.NewObjectID(),
+		URL:       req.URL,
+		Events:    req.Events,
+		Secret:    hex.EncodeToString(raw),
+		CreatedBy: createdBy,
+		CreatedAt: time.Now().UTC(),
+	}
+	if _, err := documents.Collection("webhooks").InsertOne(dbContext(r), hook); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hook)
+}
+
+// webhookDeliveriesHandler lists recent delivery attempts, newest first,
+// optionally for one webhook (webhookId) or only failures (failed=true).
+func webhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	filter := bson.M{}
+	if v := r.URL.Query().Get("webhookId"); v != "" {
+		oid, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			writeFieldError(w, "webhookId", "must be a valid ID")
+			return
+		}
+		filter["webhookId"] = oid
+	}
+	if r.URL.Query().Get("failed") == "true" {
+		filter["succeeded"] = false
+	}
+	var errs validationErrors
+	limit := intParam(r, "limit", 50, 1, 500, &errs)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	cursor, err := documents.Collection("webhookDeliveries").Find(dbContext(r), filter,
+		options.Find().SetSort(bson.D{{Key: "at", Value: -1}}).SetLimit(int64(limit)))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	deliveries := []models.WebhookDelivery{}
+	if err := cursor.All(dbContext(r), &deliveries); err != nil {
+		serverError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(deliveries)
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// socketSubscription is the first frame a client sends after connecting.
+// Empty fields match everything.
+type socketSubscription struct {
+	Operations []string `json:"operations"`
+	Category   string   `json:"category"`
+	Priority   string   `json:"priority"`
+	AssignedTo string   `json:"assignedTo"`
+	UserID     string   `json:"userId"`
+}
+
+func (s socketSubscription) pipeline() (mongo.Pipeline, error) {
+	match := bson.M{}
+	if len(s.Operations) > 0 {
+		match["operationType"] = bson.M{"$in": s.Operations}
+	}
+	if s.Category != "" {
+		match["fullDocument.category"] = s.Category
+	}
+	if s.Priority != "" {
+		match["fullDocument.priority"] = s.Priority
+	}
+	if s.AssignedTo != "" {
+		match["fullDocument.assignedTo"] = s.AssignedTo
+	}
+	if s.UserID != "" {
+		oid, err := primitive.ObjectIDFromHex(s.UserID)
+		if err != nil {
+			return nil, err
+		}
+		match["fullDocument.userId"] = oid
+	}
+	return mongo.Pipeline{{{Key: "$match", Value: match}}}, nil
+}
+
+// complaintSocketHandler streams complaint change events as JSON frames. The
+// client must send a socketSubscription frame within SOCKET_SUBSCRIBE_TIMEOUT
+// of connecting; after that the connection only carries events and
+// keepalives.
+func complaintSocketHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	pingInterval := envDuration("SOCKET_PING_INTERVAL", 30*time.Second)
+	pongWait := 2 * pingInterval
+
+	conn.SetReadDeadline(time.Now().Add(envDuration("SOCKET_SUBSCRIBE_TIMEOUT", 10*time.Second)))
+	var sub socketSubscription
+	if err := conn.ReadJSON(&sub); err != nil {
+		closeSocket(conn, websocket.CloseUnsupportedData, "expected a subscription frame")
+		return
+	}
+	pipeline, err := sub.pipeline()
+	if err != nil {
+		closeSocket(conn, websocket.CloseUnsupportedData, "invalid userId")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(streamContext(r))
+	defer cancel()
+
+	stream, err := orgCollection("complaints").Watch(ctx, pipeline,
+		options.ChangeStream().SetFullDocument(options.UpdateLookup))
+	if err != nil {
+		closeSocket(conn, websocket.CloseInternalServerErr, err.Error())
+		return
+	}
+	defer stream.Close(context.Background())
+
+	// The read loop only exists to process pongs and close frames; any
+	// error means the client has gone and the stream should stop.
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(5*time.Second)); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	for stream.Next(ctx) {
+		var event struct {
+			OperationType string `bson:"operationType"`
+			DocumentKey   struct {
+				ID primitive.ObjectID `bson:"_id"`
+			} `bson:"documentKey"`
+			FullDocument *models.Complaint   `bson:"fullDocument"`
+			ClusterTime  primitive.Timestamp `bson:"clusterTime"`
+		}
+		if err := stream.Decode(&event); err != nil {
+			closeSocket(conn, websocket.CloseInternalServerErr, err.Error())
+			return
+		}
+		if event.OperationType == "invalidate" {
+			closeSocket(conn, websocket.CloseGoingAway, "change stream invalidated")
+			return
+		}
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		err := conn.WriteJSON(complaintChange{
+			Operation:   event.OperationType,
+			ComplaintID: event.DocumentKey.ID,
+			Complaint:   event.FullDocument,
+			ChangedAt:   time.Unix(int64(event.ClusterTime.T), 0).UTC(),
+		})
+		if err != nil {
+			return
+		}
+	}
+	if ctx.Err() == nil {
+		closeSocket(conn, websocket.CloseInternalServerErr, "change stream closed")
+	}
+}
+
+func closeSocket(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"complain/models"
+)
+
+// transitionRule decides whether the caller may move a complaint along one
+// edge of the workflow.
+type transitionRule func(r *http.Request, c models.Complaint) bool
+
+func byStaff(r *http.Request, c models.Complaint) bool {
+	_, ok := staffIdentity(r)
+	return ok
+}
+
+func byApprover(r *http.Request, c models.Complaint) bool {
+	return canApprove(r)
+}
+
+// transitions lists every allowed status change and who may make it. Owners
+// can close or reopen their own resolved complaints; everything else is staff
+// work, and approvals are left to managers.
+var transitions = map[string]map[string]transitionRule{
+	models.StatusOpen: {
+		models.StatusInProgress:      byStaff,
+		models.StatusPendingApproval: byStaff,
+		models.StatusResolved:        byStaff,
+	},
+	models.StatusInProgress: {
+		models.StatusOpen:            byStaff,
+		models.StatusPendingApproval: byStaff,
+		models.StatusResolved:        byStaff,
+	},
+	models.StatusPendingApproval: {
+		models.StatusInProgress: byApprover,
+		models.StatusResolved:   byApprover,
+	},
+	models.StatusResolved: {
+		models.StatusClosed:   canAccess,
+		models.StatusReopened: canAccess,
+	},
+	models.StatusClosed: {
+		models.StatusReopened: byStaff,
+	},
+	models.StatusReopened: {
+		models.StatusInProgress:      byStaff,
+		models.StatusPendingApproval: byStaff,
+		models.StatusResolved:        byStaff,
+	},
+}
+
+type transitionRequest struct {
+	To string `json:"to"`
+}
+
+// complaintResourceHandler serves the /complaints/{id}/... subresources.
+func complaintResourceHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/complaints/"), "/"), "/")
+	if len(parts) < 2 {
+		http.NotFound(w, r)
+		return
+	}
+	oid, err := primitive.ObjectIDFromHex(parts[0])
+	if err != nil {
+		writeFieldError(w, "id", "must be a valid ID")
+		return
+	}
+
+	switch {
+	case len(parts) == 2 && parts[1] == "transition":
+		transitionComplaintHandler(w, r, oid)
+	case len(parts) == 2 && parts[1] == "comments":
+		commentsHandler(w, r, oid)
+	case len(parts) == 2 && parts[1] == "attachments":
+		attachmentsHandler(w, r, oid)
+	case len(parts) == 2 && parts[1] == "report.pdf":
+		complaintReportHandler(w, r, oid)
+	case len(parts) == 3 && parts[1] == "attachments":
+		downloadAttachmentHandler(w, r, oid, parts[2])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func transitionComplaintHandler(w http.ResponseWriter, r *http.Request, oid primitive.ObjectID) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req transitionRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	version, ok := requireComplaintVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var complaint models.Complaint
+	err := orgCollection("complaints").FindOne(dbContext(r), bson.M{"_id": oid}).Decode(&complaint)
+	if err != nil || !canAccess(r, complaint) {
+		http.Error(w, "Complaint not found", http.StatusNotFound)
+		return
+	}
+	if complaint.Version != version {
+		writeVersionConflict(w)
+		return
+	}
+
+	from := complaint.CurrentStatus()
+	allowed, ok := transitions[from][req.To]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Cannot move a complaint from %s to %s", from, req.To), http.StatusConflict)
+		return
+	}
+	if !allowed(r, complaint) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if req.To == models.StatusResolved && from != models.StatusPendingApproval &&
+		envBool("RESOLUTION_APPROVAL", false) && !canApprove(r) {
+		http.Error(w, "Resolution requires approval; move the complaint to pending_approval", http.StatusForbidden)
+		return
+	}
+
+	before := complaint
+	change := newStatusChange(r, from, req.To)
+	update := transitionUpdate(change)
+
+	// Matching on the old status and version makes concurrent transitions
+	// fail cleanly instead of skipping a step in the workflow.
+	filter := bson.M{"$and": bson.A{bson.M{"_id": oid}, statusFilter(from)}}
+	withVersion(filter, update, version)
+	event := eventComplaintStatus
+	switch req.To {
+	case models.StatusResolved:
+		event = eventComplaintResolved
+	case models.StatusReopened:
+		event = eventComplaintReopened
+	}
+	err = updateComplaint(dbContext(r), event, filter, update, &complaint)
+	if err == mongo.ErrNoDocuments {
+		writeVersionConflict(w)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	recordAudit(r, auditTransition, auditTargetComplaint, oid, before, complaint)
+
+	go publishEvent(event, complaint)
+	switch req.To {
+	case models.StatusResolved:
+		complaintsResolvedTotal.Inc()
+		go notifyOwner(complaint, templateResolution, "")
+	case models.StatusReopened:
+		escalateReopened(&complaint)
+	}
+
+	json.NewEncoder(w).Encode(complaint)
+}
+
+// transitionUpdate is the update recording change, which must be an allowed
+// edge of transitions.
+func transitionUpdate(change models.StatusChange) bson.M {
+	set := bson.M{"status": change.To, "resolved": models.IsResolvedStatus(change.To)}
+	update := bson.M{"$set": set, "$push": bson.M{"history": change}}
+	switch change.To {
+	case models.StatusResolved:
+		set["resolvedAt"] = change.ChangedAt
+		if change.From != models.StatusPendingApproval {
+			set["resolvedBy"] = change.ChangedBy
+		}
+	case models.StatusPendingApproval:
+		set["resolvedBy"] = change.ChangedBy
+	case models.StatusReopened:
+		update["$unset"] = bson.M{"resolvedBy": "", "resolvedAt": "", "resolutionNote": ""}
+		update["$inc"] = bson.M{"reopenCount": 1}
+	case models.StatusOpen, models.StatusInProgress:
+		if change.From == models.StatusPendingApproval {
+			update["$unset"] = bson.M{"resolvedBy": ""}
+		}
+	}
+	return update
+}
+
+// escalateReopened bumps the priority of complaints that keep coming back.
+func escalateReopened(complaint *models.Complaint) {
+	if !reopenEscalationThresholds()[complaint.ReopenCount] {
+		return
+	}
+	if err := escalateComplaint(complaint, fmt.Sprintf("reopened %d times", complaint.ReopenCount)); err != nil {
+		log.Printf("escalate complaint %s: %v", complaint.ID.Hex(), err)
+	}
+}
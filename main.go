@@ -0,0 +1,22 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"complain/config"
+	"complain/handlers"
+)
+
+func main() {
+	if err := config.Load(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+	if args := config.Args(); len(args) > 0 {
+		if err := handlers.RunCommand(args[0], args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	handlers.Run()
+}
@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Documents written before the structs carried explicit bson tags have the
+// driver's lowercased field names.
+var fieldRenames = []struct{ collection, from, to string }{
+	{"users", "secretcode", "secretCode"},
+	{"complaints", "userid", "userId"},
+}
+
+func init() {
+	register(Migration{
+		Version: 1,
+		Name:    "normalize field names",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			for _, rn := range fieldRenames {
+				if _, err := db.Collection(rn.collection).UpdateMany(ctx,
+					bson.M{rn.from: bson.M{"$exists": true}},
+					bson.M{"$rename": bson.M{rn.from: rn.to}}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
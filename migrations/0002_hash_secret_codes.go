@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Users registered before codes were hashed keep their codes, which stay
+// short until rotated. The plaintext is gone afterwards, so this cannot be
+// reverted.
+func init() {
+	register(Migration{
+		Version: 2,
+		Name:    "hash secret codes",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			users := db.Collection("users")
+			cursor, err := users.Find(ctx, bson.M{"secretCode": bson.M{"$type": "string"}})
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(ctx)
+			for cursor.Next(ctx) {
+				var user struct {
+					ID   interface{} `bson:"_id"`
+					Code string      `bson:"secretCode"`
+				}
+				if err := cursor.Decode(&user); err != nil {
+					return err
+				}
+				sum := sha256.Sum256([]byte(user.Code))
+				if _, err := users.UpdateOne(ctx,
+					bson.M{"_id": user.ID, "secretCode": user.Code},
+					bson.M{"$set": bson.M{"secretCodeHash": hex.EncodeToString(sum[:])}, "$unset": bson.M{"secretCode": ""}}); err != nil {
+					return err
+				}
+			}
+			return cursor.Err()
+		},
+	})
+}
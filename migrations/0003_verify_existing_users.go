@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Users registered before email verification existed count as verified, so
+// turning on REQUIRE_VERIFIED_EMAIL does not lock them out.
+func init() {
+	register(Migration{
+		Version: 3,
+		Name:    "verify existing users",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("users").UpdateMany(ctx,
+				bson.M{"verified": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"verified": true}})
+			return err
+		},
+	})
+}
@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Users and complaints stored before the store kept createdAt and updatedAt
+// are dated from their ObjectIDs.
+func init() {
+	register(Migration{
+		Version: 4,
+		Name:    "backfill timestamps",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			for _, name := range []string{"users", "complaints"} {
+				coll := db.Collection(name)
+				if _, err := coll.UpdateMany(ctx, bson.M{"createdAt": bson.M{"$exists": false}},
+					mongo.Pipeline{{{Key: "$set", Value: bson.M{"createdAt": bson.M{"$toDate": "$_id"}}}}}); err != nil {
+					return err
+				}
+				if _, err := coll.UpdateMany(ctx, bson.M{"updatedAt": bson.M{"$exists": false}},
+					mongo.Pipeline{{{Key: "$set", Value: bson.M{"updatedAt": "$createdAt"}}}}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Complaints filed before the status workflow only have resolved. Marking
+// them statusBackfilled lets Down find them again; it leaves alone those
+// whose status has moved on since.
+func init() {
+	register(Migration{
+		Version: 5,
+		Name:    "backfill complaint status",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("complaints").UpdateMany(ctx,
+				bson.M{"status": bson.M{"$in": bson.A{nil, ""}}},
+				mongo.Pipeline{{{Key: "$set", Value: bson.M{
+					"status":           bson.M{"$cond": bson.A{"$resolved", "resolved", "open"}},
+					"statusBackfilled": true,
+				}}}})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("complaints").UpdateMany(ctx,
+				bson.M{"statusBackfilled": true, "$expr": bson.M{"$eq": bson.A{
+					"$status", bson.M{"$cond": bson.A{"$resolved", "resolved", "open"}},
+				}}},
+				bson.M{"$unset": bson.M{"status": ""}})
+			if err != nil {
+				return err
+			}
+			_, err = db.Collection("complaints").UpdateMany(ctx,
+				bson.M{"statusBackfilled": true},
+				bson.M{"$unset": bson.M{"statusBackfilled": ""}})
+			return err
+		},
+	})
+}
@@ -0,0 +1,189 @@
+// Package migrations brings stored documents up to the shape the handlers
+// expect. Each migration lives in its own file, numbered by version, and
+// records itself in the schema_migrations collection once applied, so it
+// runs once per database however many instances start.
+//
+// A migration must be safe to run again: an instance that dies between
+// applying one and recording it runs it a second time. Write migrations
+// against the collections as they were when the migration was added, not
+// through the current models, which keep changing.
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration is one versioned change. Down undoes Up, and is nil for
+// migrations that cannot be undone, such as hashing secret codes.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database) error
+	Down    func(ctx context.Context, db *mongo.Database) error
+}
+
+// Record is a migration's entry in schema_migrations.
+type Record struct {
+	Version   int       `bson:"_id" json:"version"`
+	Name      string    `bson:"name" json:"name"`
+	AppliedAt time.Time `bson:"appliedAt" json:"appliedAt"`
+}
+
+const collection = "schema_migrations"
+
+// lockID is the schema_migrations document held while migrations run. Its
+// string ID keeps it apart from the numbered records.
+const lockID = "lock"
+
+var registry = map[int]Migration{}
+
+func register(m Migration) {
+	if _, ok := registry[m.Version]; ok {
+		panic(fmt.Sprintf("migration %d registered twice", m.Version))
+	}
+	registry[m.Version] = m
+}
+
+// All returns the known migrations in version order.
+func All() []Migration {
+	all := make([]Migration, 0, len(registry))
+	for _, m := range registry {
+		all = append(all, m)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	return all
+}
+
+// Applied returns the records of the migrations applied to db.
+func Applied(ctx context.Context, db *mongo.Database) (map[int]Record, error) {
+	cursor, err := db.Collection(collection).Find(ctx, bson.M{"_id": bson.M{"$type": "number"}})
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]Record, len(records))
+	for _, rec := range records {
+		applied[rec.Version] = rec
+	}
+	return applied, nil
+}
+
+// Pending returns the known migrations not yet applied to db.
+func Pending(ctx context.Context, db *mongo.Database) ([]Migration, error) {
+	applied, err := Applied(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, m := range All() {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies the pending migrations up to and including version to, or all
+// of them if to is 0, stopping at the first failure.
+func Up(ctx context.Context, db *mongo.Database, to int) error {
+	return withLock(ctx, db, func() error {
+		pending, err := Pending(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, m := range pending {
+			if to > 0 && m.Version > to {
+				break
+			}
+			start := time.Now()
+			if err := m.Up(ctx, db); err != nil {
+				return fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+			}
+			rec := Record{Version: m.Version, Name: m.Name, AppliedAt: time.Now().UTC()}
+			if _, err := db.Collection(collection).InsertOne(ctx, rec); err != nil {
+				return fmt.Errorf("record migration %d: %w", m.Version, err)
+			}
+			log.Printf("applied migration %d %s in %s", m.Version, m.Name, time.Since(start).Round(time.Millisecond))
+		}
+		return nil
+	})
+}
+
+// Down reverts the applied migrations above version to, newest first. It
+// stops before a migration that cannot be undone.
+func Down(ctx context.Context, db *mongo.Database, to int) error {
+	return withLock(ctx, db, func() error {
+		applied, err := Applied(ctx, db)
+		if err != nil {
+			return err
+		}
+		all := All()
+		for i := len(all) - 1; i >= 0; i-- {
+			m := all[i]
+			if m.Version <= to {
+				break
+			}
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if m.Down == nil {
+				return fmt.Errorf("migration %d %s cannot be reverted", m.Version, m.Name)
+			}
+			if err := m.Down(ctx, db); err != nil {
+				return fmt.Errorf("revert migration %d %s: %w", m.Version, m.Name, err)
+			}
+			if _, err := db.Collection(collection).DeleteOne(ctx, bson.M{"_id": m.Version}); err != nil {
+				return fmt.Errorf("unrecord migration %d: %w", m.Version, err)
+			}
+			log.Printf("reverted migration %d %s", m.Version, m.Name)
+		}
+		return nil
+	})
+}
+
+// lockTTL bounds how long a lock left by a crashed instance blocks others.
+const lockTTL = 10 * time.Minute
+
+var errLocked = errors.New("migrations are locked by another instance")
+
+// withLock runs fn while holding the schema_migrations lock, waiting for an
+// instance that is already migrating to finish.
+func withLock(ctx context.Context, db *mongo.Database, fn func() error) error {
+	coll := db.Collection(collection)
+	for {
+		now := time.Now().UTC()
+		err := coll.FindOneAndUpdate(ctx,
+			bson.M{"_id": lockID, "expiresAt": bson.M{"$lt": now}},
+			bson.M{"$set": bson.M{"lockedAt": now, "expiresAt": now.Add(lockTTL)}},
+			options.FindOneAndUpdate().SetUpsert(true)).Err()
+		if err == nil || err == mongo.ErrNoDocuments {
+			break
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return errLocked
+		case <-time.After(time.Second):
+		}
+	}
+	defer func() {
+		if _, err := coll.DeleteOne(context.Background(), bson.M{"_id": lockID}); err != nil {
+			log.Printf("release migration lock: %v", err)
+		}
+	}()
+	return fn()
+}
@@ -0,0 +1,507 @@
+// Package models holds the documents stored in MongoDB and returned by the API.
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type User struct {
+	ID primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	// SecretCode is only set on the responses that issue a code; the database
+	// keeps SecretCodeHash.
+	SecretCode     string               `bson:"-" json:"secretCode,omitempty"`
+	SecretCodeHash string               `bson:"secretCodeHash,omitempty" json:"-"`
+	Name           string               `bson:"name" json:"name"`
+	Email          string               `bson:"email" json:"email"`
+	Complaints     []primitive.ObjectID `bson:"complaints" json:"complaints"`
+	Role           string               `bson:"role,omitempty" json:"role,omitempty"`
+	// OrgID is the organization the user belongs to. Users outside any
+	// organization see only complaints outside one too, except admins, who
+	// administer every organization.
+	OrgID string `bson:"orgId,omitempty" json:"orgId,omitempty"`
+	// Verified is set once the user follows the link emailed to their
+	// current address.
+	Verified bool `bson:"verified" json:"verified"`
+	// AvatarID is the GridFS file of the user's avatar, if they uploaded one.
+	AvatarID *primitive.ObjectID `bson:"avatarId,omitempty" json:"-"`
+	// Identities are the single sign-on accounts linked to the user.
+	Identities []Identity `bson:"identities,omitempty" json:"identities,omitempty"`
+	// Phone is an E.164 number, used for text messages once PhoneVerified.
+	Phone         string `bson:"phone,omitempty" json:"phone,omitempty"`
+	PhoneVerified bool   `bson:"phoneVerified,omitempty" json:"phoneVerified"`
+	// NotifyChannels are where notifications go: email, sms or both. Users
+	// who never chose get email.
+	NotifyChannels []string `bson:"notifyChannels,omitempty" json:"notifyChannels,omitempty"`
+	// AcknowledgeEmails opts the user into an email receipt for each submission.
+	AcknowledgeEmails bool `bson:"acknowledgeEmails" json:"acknowledgeEmails"`
+	// Locale selects the language of notification emails, e.g. "en" or "pt-BR".
+	Locale string `bson:"locale,omitempty" json:"locale,omitempty"`
+	// Version is incremented on every profile update for optimistic locking.
+	Version int `bson:"version" json:"version"`
+	// ErasureScheduledFor is when the account is due to be erased, if the
+	// user asked for it. Erasing is set once erasure has begun and can no
+	// longer be cancelled.
+	ErasureScheduledFor *time.Time `bson:"erasureScheduledFor,omitempty" json:"erasureScheduledFor,omitempty"`
+	Erasing             bool       `bson:"erasing,omitempty" json:"-"`
+	ErasingSince        *time.Time `bson:"erasingSince,omitempty" json:"-"`
+	// CreatedAt and UpdatedAt are kept by the store.
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Identity is an account at an OpenID Connect provider, named by the
+// provider's subject identifier.
+type Identity struct {
+	Provider string `bson:"provider" json:"provider"`
+	Subject  string `bson:"subject" json:"subject"`
+}
+
+type Complaint struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	RefNumber int64              `bson:"refNumber,omitempty" json:"refNumber,omitempty"`
+	Title     string             `bson:"title" json:"title"`
+	Summary   string             `bson:"summary" json:"summary"`
+	Rating    int                `bson:"rating" json:"rating"`
+	Resolved  bool               `bson:"resolved" json:"resolved"`
+	// OrgID is the reporter's organization at submission.
+	OrgID string `bson:"orgId,omitempty" json:"orgId,omitempty"`
+	// Anonymous complaints were filed without an account and have no
+	// reporter; their submitter follows them with the tracking token whose
+	// hash is TrackingTokenHash.
+	Anonymous         bool   `bson:"anonymous,omitempty" json:"anonymous,omitempty"`
+	TrackingTokenHash string `bson:"trackingTokenHash,omitempty" json:"-"`
+	// Version is incremented whenever the complaint's workflow state changes
+	// (status, assignee, category, priority, linked ticket), for optimistic
+	// locking. Counters such as comments and attachments do not bump it.
+	Version int `bson:"version" json:"version"`
+	// DeletedAt is set when the complaint is soft-deleted. Deleted complaints
+	// drop out of listings and are visible only to admins, who can restore
+	// them.
+	DeletedAt  *time.Time         `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedBy  string             `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
+	Status     string             `bson:"status,omitempty" json:"status,omitempty"`
+	UserID     primitive.ObjectID `bson:"userId" json:"userId"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt  time.Time          `bson:"updatedAt" json:"updatedAt"`
+	DueAt      time.Time          `bson:"dueAt,omitempty" json:"dueAt,omitempty"`
+	ResolvedBy string             `bson:"resolvedBy,omitempty" json:"resolvedBy,omitempty"`
+	ResolvedAt *time.Time         `bson:"resolvedAt,omitempty" json:"resolvedAt"`
+	// ResolutionNote explains a resolution: the reply template an agent
+	// applied, or why it was resolved automatically.
+	ResolutionNote string         `bson:"resolutionNote,omitempty" json:"resolutionNote,omitempty"`
+	FollowUpAt     *time.Time     `bson:"followUpAt,omitempty" json:"followUpAt,omitempty"`
+	AssignedTo     string         `bson:"assignedTo,omitempty" json:"assignedTo,omitempty"`
+	Assignments    []Assignment   `bson:"assignments,omitempty" json:"assignments,omitempty"`
+	CommentCount   int            `bson:"commentCount,omitempty" json:"commentCount"`
+	Attachments    []Attachment   `bson:"attachments,omitempty" json:"attachments,omitempty"`
+	History        []StatusChange `bson:"history,omitempty" json:"history,omitempty"`
+
+	Category          string             `bson:"category,omitempty" json:"category,omitempty"`
+	Tags              []string           `bson:"tags,omitempty" json:"tags,omitempty"`
+	Recategorizations []Recategorization `bson:"recategorizations,omitempty" json:"recategorizations,omitempty"`
+	Priority          string             `bson:"priority,omitempty" json:"priority,omitempty"`
+	ReopenCount       int                `bson:"reopenCount,omitempty" json:"reopenCount,omitempty"`
+	Escalations       []Escalation       `bson:"escalations,omitempty" json:"escalations,omitempty"`
+	// EscalationLevel is how far up its category's escalation chain the
+	// complaint has moved; 0 while it is with the agent first assigned.
+	EscalationLevel int `bson:"escalationLevel,omitempty" json:"escalationLevel,omitempty"`
+	// SLAEscalatedAt is when the complaint was escalated for missing DueAt.
+	SLAEscalatedAt *time.Time `bson:"slaEscalatedAt,omitempty" json:"slaEscalatedAt,omitempty"`
+
+	ExternalTicket *ExternalTicket     `bson:"externalTicket,omitempty" json:"externalTicket,omitempty"`
+	Approval       *ResolutionApproval `bson:"approval,omitempty" json:"approval,omitempty"`
+	// Flagged complaints were held by content screening and stay out of the
+	// agent queues until an admin reviews them.
+	Flagged    bool        `bson:"flagged,omitempty" json:"flagged,omitempty"`
+	Moderation *Moderation `bson:"moderation,omitempty" json:"moderation,omitempty"`
+
+	ContentHash string `bson:"contentHash,omitempty" json:"contentHash,omitempty"`
+	// Revision counts the reporter's edits and EditedAt is the latest one.
+	Revision int                  `bson:"revision,omitempty" json:"revision,omitempty"`
+	EditedAt *time.Time           `bson:"editedAt,omitempty" json:"editedAt,omitempty"`
+	Watchers []primitive.ObjectID `bson:"watchers,omitempty" json:"watchers,omitempty"`
+	// MasterID links an automatically merged duplicate to its master, whose
+	// LinkedCount counts such duplicates.
+	MasterID    *primitive.ObjectID `bson:"masterId,omitempty" json:"masterId,omitempty"`
+	LinkedCount int                 `bson:"linkedCount,omitempty" json:"linkedCount,omitempty"`
+	// MergedInto is the primary complaint an agent merged this duplicate
+	// into, and MergedFrom the duplicates merged into this one. Related
+	// complaints are linked both ways without merging.
+	MergedInto *primitive.ObjectID  `bson:"mergedInto,omitempty" json:"mergedInto,omitempty"`
+	MergedFrom []primitive.ObjectID `bson:"mergedFrom,omitempty" json:"mergedFrom,omitempty"`
+	Related    []primitive.ObjectID `bson:"related,omitempty" json:"related,omitempty"`
+	// DuplicateCount is only filled in by listings that ask for it.
+	DuplicateCount *int `bson:"duplicateCount,omitempty" json:"duplicateCount,omitempty"`
+
+	// ReporterContext lists the reporter's other open complaints on request.
+	ReporterContext []ReporterComplaint `bson:"-" json:"reporterContext,omitempty"`
+
+	// IdempotencyKey is the client-supplied Idempotency-Key header, unique per user.
+	IdempotencyKey string `bson:"idempotencyKey,omitempty" json:"-"`
+	// IdempotentResponse is the submission response body, replayed to retries
+	// that reuse IdempotencyKey.
+	IdempotentResponse []byte `bson:"idempotentResponse,omitempty" json:"-"`
+
+	// ReporterAvatarURL is filled in on responses when the reporter has an
+	// avatar.
+	ReporterAvatarURL string `bson:"-" json:"reporterAvatarUrl,omitempty"`
+	// RemainingQuota, RemainingDailyQuota and QuotaWarning are only set on
+	// submission responses.
+	RemainingQuota      *int `bson:"-" json:"remainingQuota,omitempty"`
+	RemainingDailyQuota *int `bson:"-" json:"remainingDailyQuota,omitempty"`
+	QuotaWarning        bool `bson:"-" json:"quotaWarning,omitempty"`
+
+	SummaryTruncated bool   `bson:"-" json:"summaryTruncated,omitempty"`
+	AgeSeconds       int64  `bson:"-" json:"ageSeconds,omitempty"`
+	SLAStatus        string `bson:"-" json:"slaStatus,omitempty"`
+	TotalAttachments int    `bson:"-" json:"totalAttachments,omitempty"`
+}
+
+// StatusChange is one entry in a complaint's audit trail.
+type StatusChange struct {
+	From      string    `bson:"from" json:"from"`
+	To        string    `bson:"to" json:"to"`
+	ChangedBy string    `bson:"changedBy" json:"changedBy"`
+	ChangedAt time.Time `bson:"changedAt" json:"changedAt"`
+	// Reason is given by reporters reopening a complaint.
+	Reason string `bson:"reason,omitempty" json:"reason,omitempty"`
+}
+
+// Comment is a message on a complaint. Replies point at the comment they
+// answer through ParentID.
+type Comment struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	ComplaintID primitive.ObjectID  `bson:"complaintId" json:"complaintId"`
+	ParentID    *primitive.ObjectID `bson:"parentId,omitempty" json:"parentId,omitempty"`
+	Author      string              `bson:"author" json:"author"`
+	AuthorRole  string              `bson:"authorRole,omitempty" json:"authorRole,omitempty"`
+	// AuthorAvatarURL is filled in on responses when the author has an avatar.
+	AuthorAvatarURL string `bson:"-" json:"authorAvatarUrl,omitempty"`
+	Body            string `bson:"body" json:"body"`
+	// Visibility is public or internal; internal notes are for staff only.
+	// Comments written before it existed have none and are public.
+	Visibility string `bson:"visibility,omitempty" json:"visibility"`
+	ReplyCount int    `bson:"replyCount" json:"replyCount"`
+	// MergedFrom is the duplicate the comment was written on, for comments
+	// carried over when it was merged.
+	MergedFrom *primitive.ObjectID `bson:"mergedFrom,omitempty" json:"mergedFrom,omitempty"`
+	CreatedAt  time.Time           `bson:"createdAt" json:"createdAt"`
+}
+
+const (
+	CommentPublic   = "public"
+	CommentInternal = "internal"
+)
+
+// Attachment describes a file stored in GridFS for a complaint. ID is the
+// GridFS file ID.
+type Attachment struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	Filename    string             `bson:"filename" json:"filename"`
+	ContentType string             `bson:"contentType" json:"contentType"`
+	Size        int64              `bson:"size" json:"size"`
+	UploadedBy  string             `bson:"uploadedBy" json:"uploadedBy"`
+	UploadedAt  time.Time          `bson:"uploadedAt" json:"uploadedAt"`
+}
+
+// Webhook is an admin-registered endpoint that receives signed event
+// payloads. Secret is only shown when the webhook is created.
+type Webhook struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	URL       string             `bson:"url" json:"url"`
+	Events    []string           `bson:"events" json:"events"`
+	Secret    string             `bson:"secret" json:"secret,omitempty"`
+	CreatedBy string             `bson:"createdBy" json:"createdBy"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// APIKey lets a script or another service act as staff without a person's
+// credentials. Only the key's hash is stored; the key itself is shown once,
+// when it is created. A read scope only allows reads; an admin scope acts as
+// an admin of Org.
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	Name       string             `bson:"name" json:"name"`
+	Scope      string             `bson:"scope" json:"scope"`
+	OrgID      string             `bson:"orgId,omitempty" json:"orgId,omitempty"`
+	KeyHash    string             `bson:"keyHash" json:"-"`
+	Prefix     string             `bson:"prefix" json:"prefix"`
+	Key        string             `bson:"-" json:"key,omitempty"`
+	CreatedBy  string             `bson:"createdBy" json:"createdBy"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	ExpiresAt  time.Time          `bson:"expiresAt" json:"expiresAt"`
+	LastUsedAt *time.Time         `bson:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	RevokedBy  string             `bson:"revokedBy,omitempty" json:"revokedBy,omitempty"`
+}
+
+// Notification is an in-app message for a user about one of their own or
+// watched complaints. Type is the event that caused it.
+type Notification struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	UserID      primitive.ObjectID `bson:"userId" json:"-"`
+	Type        string             `bson:"type" json:"type"`
+	ComplaintID primitive.ObjectID `bson:"complaintId" json:"complaintId"`
+	Message     string             `bson:"message" json:"message"`
+	Read        bool               `bson:"read" json:"read"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+	ExpiresAt   time.Time          `bson:"expiresAt" json:"-"`
+}
+
+// ReplyTemplate is an admin-managed canned response agents can apply when
+// commenting on or resolving a complaint.
+type ReplyTemplate struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	Name      string             `bson:"name" json:"name"`
+	Body      string             `bson:"body" json:"body"`
+	CreatedBy string             `bson:"createdBy" json:"createdBy"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// SavedSearch is a named set of admin listing parameters, kept for the staff
+// member who saved it.
+type SavedSearch struct {
+	ID    primitive.ObjectID `bson:"_id" json:"id"`
+	Owner string             `bson:"owner" json:"owner"`
+	Name  string             `bson:"name" json:"name"`
+	// Params are query parameters of the listing, such as category,
+	// assignedTo, sort and order.
+	Params    map[string]string `bson:"params" json:"params"`
+	CreatedAt time.Time         `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time         `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Organization is a tenant. Its users and complaints carry its ID as OrgID
+// and are invisible to other organizations.
+type Organization struct {
+	ID        string    `bson:"_id" json:"id"`
+	Name      string    `bson:"name" json:"name"`
+	CreatedBy string    `bson:"createdBy" json:"createdBy"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	// AnonymousSubmissions lets people without an account file complaints
+	// in the organization.
+	AnonymousSubmissions bool `bson:"anonymousSubmissions,omitempty" json:"anonymousSubmissions"`
+	// EmailDomains let people with an address at one of them join the
+	// organization when they register; anyone else needs an invite.
+	EmailDomains []string `bson:"emailDomains,omitempty" json:"emailDomains,omitempty"`
+}
+
+// Category is an admin-managed complaint category. ID is the value stored in
+// Complaint.Category.
+type Category struct {
+	ID          string `bson:"_id" json:"id"`
+	Name        string `bson:"name" json:"name"`
+	Description string `bson:"description,omitempty" json:"description,omitempty"`
+	// Agents is the team new complaints in the category are routed to in
+	// turn, instead of the AUTO_ASSIGN_AGENTS rotation.
+	Agents []string `bson:"agents,omitempty" json:"agents,omitempty"`
+	// EscalationChain lists, in order, who complaints in the category move
+	// on to while they stay unresolved, above the agent first assigned.
+	EscalationChain []EscalationLevel `bson:"escalationChain,omitempty" json:"escalationChain,omitempty"`
+	CreatedBy       string            `bson:"createdBy" json:"createdBy"`
+	CreatedAt       time.Time         `bson:"createdAt" json:"createdAt"`
+	UpdatedAt       time.Time         `bson:"updatedAt" json:"updatedAt"`
+}
+
+// EscalationLevel is a step of an escalation chain: complaints unresolved
+// After their submission are reassigned to Assignee.
+type EscalationLevel struct {
+	Name     string `bson:"name,omitempty" json:"name,omitempty"`
+	Assignee string `bson:"assignee" json:"assignee"`
+	// After is a duration such as "24h" and grows from one level to the next.
+	After string `bson:"after" json:"after"`
+}
+
+// Job is the shared run state of a background job.
+type Job struct {
+	Name      string    `bson:"_id" json:"name"`
+	Interval  string    `bson:"interval" json:"interval"`
+	NextRunAt time.Time `bson:"nextRunAt" json:"nextRunAt"`
+	// LockedBy is the instance running the job, which holds it until
+	// LockedUntil.
+	LockedBy            string     `bson:"lockedBy,omitempty" json:"lockedBy,omitempty"`
+	LockedUntil         *time.Time `bson:"lockedUntil,omitempty" json:"lockedUntil,omitempty"`
+	LastStartedAt       *time.Time `bson:"lastStartedAt,omitempty" json:"lastStartedAt,omitempty"`
+	LastFinishedAt      *time.Time `bson:"lastFinishedAt,omitempty" json:"lastFinishedAt,omitempty"`
+	LastDurationSeconds float64    `bson:"lastDurationSeconds" json:"lastDurationSeconds"`
+	LastError           string     `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	Runs                int64      `bson:"runs" json:"runs"`
+	Failures            int64      `bson:"failures" json:"failures"`
+}
+
+// WebhookDelivery records one attempt to deliver an event to a webhook.
+type WebhookDelivery struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	WebhookID   primitive.ObjectID `bson:"webhookId" json:"webhookId"`
+	EventID     string             `bson:"eventId" json:"eventId"`
+	Event       string             `bson:"event" json:"event"`
+	ComplaintID primitive.ObjectID `bson:"complaintId" json:"complaintId"`
+	Attempt     int                `bson:"attempt" json:"attempt"`
+	StatusCode  int                `bson:"statusCode,omitempty" json:"statusCode,omitempty"`
+	Error       string             `bson:"error,omitempty" json:"error,omitempty"`
+	Succeeded   bool               `bson:"succeeded" json:"succeeded"`
+	At          time.Time          `bson:"at" json:"at"`
+}
+
+// AuditLog records one mutating operation: who did it, to what, and which
+// top-level fields it changed.
+type AuditLog struct {
+	ID         primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Actor      string                 `bson:"actor" json:"actor"`
+	ActorRole  string                 `bson:"actorRole,omitempty" json:"actorRole,omitempty"`
+	OrgID      string                 `bson:"orgId,omitempty" json:"orgId,omitempty"`
+	Action     string                 `bson:"action" json:"action"`
+	TargetType string                 `bson:"targetType" json:"targetType"`
+	TargetID   primitive.ObjectID     `bson:"targetId" json:"targetId"`
+	Changes    map[string]FieldChange `bson:"changes,omitempty" json:"changes,omitempty"`
+	At         time.Time              `bson:"at" json:"at"`
+}
+
+// ComplaintRevision is one edit of a complaint's content by its reporter.
+// Revision counts from 1 for the first edit.
+type ComplaintRevision struct {
+	ID          primitive.ObjectID     `bson:"_id" json:"id"`
+	ComplaintID primitive.ObjectID     `bson:"complaintId" json:"complaintId"`
+	Revision    int                    `bson:"revision" json:"revision"`
+	Changes     map[string]FieldChange `bson:"changes" json:"changes"`
+	EditedBy    string                 `bson:"editedBy" json:"editedBy"`
+	EditedAt    time.Time              `bson:"editedAt" json:"editedAt"`
+}
+
+// ArchivedComplaint is a resolved complaint the retention job moved out of
+// the complaints collection, kept whole with its comments and revisions.
+type ArchivedComplaint struct {
+	Complaint  `bson:",inline"`
+	Comments   []Comment           `bson:"comments" json:"comments,omitempty"`
+	Revisions  []ComplaintRevision `bson:"revisions,omitempty" json:"revisions,omitempty"`
+	ArchivedAt time.Time           `bson:"archivedAt" json:"archivedAt"`
+}
+
+// Feedback is a reporter's satisfaction survey answer for a resolved
+// complaint, one per complaint. AssignedTo is the agent who handled it.
+type Feedback struct {
+	ComplaintID primitive.ObjectID `bson:"_id" json:"complaintId"`
+	UserID      primitive.ObjectID `bson:"userId" json:"-"`
+	Score       int                `bson:"score" json:"score"`
+	Comment     string             `bson:"comment,omitempty" json:"comment,omitempty"`
+	AssignedTo  string             `bson:"assignedTo,omitempty" json:"assignedTo,omitempty"`
+	SubmittedAt time.Time          `bson:"submittedAt" json:"submittedAt"`
+}
+
+// FieldChange is a field's value before and after an operation. A missing
+// side means the field was added or removed.
+type FieldChange struct {
+	Before interface{} `bson:"before,omitempty" json:"before,omitempty"`
+	After  interface{} `bson:"after,omitempty" json:"after,omitempty"`
+}
+
+// Assignment records a complaint changing hands. From is empty on first
+// assignment and To is empty when the complaint is unassigned.
+type Assignment struct {
+	From string    `bson:"from,omitempty" json:"from,omitempty"`
+	To   string    `bson:"to,omitempty" json:"to,omitempty"`
+	By   string    `bson:"by" json:"by"`
+	At   time.Time `bson:"at" json:"at"`
+}
+
+const (
+	StatusOpen            = "open"
+	StatusInProgress      = "in_progress"
+	StatusPendingApproval = "pending_approval"
+	StatusResolved        = "resolved"
+	StatusClosed          = "closed"
+	StatusReopened        = "reopened"
+)
+
+// IsResolvedStatus reports whether a complaint in the given status counts as
+// resolved. The stored resolved flag is kept in step with it so older clients
+// and queries on it keep working.
+func IsResolvedStatus(status string) bool {
+	return status == StatusResolved || status == StatusClosed
+}
+
+func StatusOf(resolved bool) string {
+	if resolved {
+		return StatusResolved
+	}
+	return StatusOpen
+}
+
+// CurrentStatus falls back to the resolved flag for complaints stored before
+// Status was tracked.
+func (c Complaint) CurrentStatus() string {
+	if c.Status != "" {
+		return c.Status
+	}
+	return StatusOf(c.Resolved)
+}
+
+// Reference is the human-readable complaint number, falling back to the ID for
+// complaints filed before numbering existed.
+func (c Complaint) Reference() string {
+	if c.RefNumber > 0 {
+		return fmt.Sprintf("C-%06d", c.RefNumber)
+	}
+	return c.ID.Hex()
+}
+
+type ReporterComplaint struct {
+	ID     primitive.ObjectID `bson:"_id" json:"id"`
+	Title  string             `bson:"title" json:"title"`
+	Status string             `bson:"status" json:"status"`
+}
+
+// Escalation records an automatic priority bump or, when Level is set, a
+// move up the category's escalation chain, with From and To as assignees.
+type Escalation struct {
+	Level  int       `bson:"level,omitempty" json:"level,omitempty"`
+	From   string    `bson:"from" json:"from"`
+	To     string    `bson:"to" json:"to"`
+	Reason string    `bson:"reason" json:"reason"`
+	At     time.Time `bson:"at" json:"at"`
+}
+
+// ExternalTicket links a complaint to an issue in an outside tracker.
+type ExternalTicket struct {
+	System   string    `bson:"system" json:"system"`
+	ID       string    `bson:"id" json:"id"`
+	URL      string    `bson:"url,omitempty" json:"url,omitempty"`
+	Status   string    `bson:"status,omitempty" json:"status,omitempty"`
+	SyncedAt time.Time `bson:"syncedAt,omitempty" json:"syncedAt,omitempty"`
+}
+
+// ResolutionApproval records a manager's decision on a pending resolution.
+type ResolutionApproval struct {
+	Decision  string    `bson:"decision" json:"decision"`
+	DecidedBy string    `bson:"decidedBy" json:"decidedBy"`
+	DecidedAt time.Time `bson:"decidedAt" json:"decidedAt"`
+}
+
+// Moderation decisions.
+const (
+	ModerationFlagged  = "flagged"
+	ModerationApproved = "approved"
+	ModerationRejected = "rejected"
+)
+
+// Moderation records why content screening held a complaint and how it was
+// reviewed.
+type Moderation struct {
+	Status     string     `bson:"status" json:"status"`
+	Reasons    []string   `bson:"reasons" json:"reasons"`
+	FlaggedAt  time.Time  `bson:"flaggedAt" json:"flaggedAt"`
+	ReviewedBy string     `bson:"reviewedBy,omitempty" json:"reviewedBy,omitempty"`
+	ReviewedAt *time.Time `bson:"reviewedAt,omitempty" json:"reviewedAt,omitempty"`
+}
+
+// Recategorization records an admin moving a complaint to another category.
+type Recategorization struct {
+	From string    `bson:"from" json:"from"`
+	To   string    `bson:"to" json:"to"`
+	By   string    `bson:"by" json:"by"`
+	At   time.Time `bson:"at" json:"at"`
+}
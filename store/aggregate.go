@@ -0,0 +1,656 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// pipeline runs aggregation stages over documents in memory. from resolves
+// the collection a $lookup joins, which it reads unscoped, as MongoDB's
+// $lookup ignores the organization the pipeline's own collection is
+// confined to.
+type pipeline struct {
+	ctx  context.Context
+	vars map[string]interface{}
+	text *textIndex
+	from func(name string) Collection
+}
+
+// stages converts a pipeline from a caller to a list of stage documents.
+func stages(v interface{}) ([]bson.D, error) {
+	if v == nil {
+		return nil, nil
+	}
+	n, err := normalize(v)
+	if err != nil {
+		return nil, err
+	}
+	a, ok := n.(bson.A)
+	if !ok {
+		return nil, fmt.Errorf("store: a pipeline must be an array, not %T", v)
+	}
+	out := make([]bson.D, len(a))
+	for i, s := range a {
+		d, ok := s.(bson.D)
+		if !ok || len(d) != 1 {
+			return nil, fmt.Errorf("store: pipeline stage %d must be a document with one field", i)
+		}
+		out[i] = d
+	}
+	return out, nil
+}
+
+func (p *pipeline) run(docs []bson.D, stages []bson.D) ([]bson.D, error) {
+	for _, st := range stages {
+		var err error
+		if docs, err = p.stage(docs, st[0].Key, st[0].Value); err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+func (p *pipeline) stage(docs []bson.D, name string, arg interface{}) ([]bson.D, error) {
+	switch name {
+	case "$match":
+		filter, ok := arg.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("store: $match needs a document")
+		}
+		m := matcher{text: p.text, vars: p.vars}
+		var out []bson.D
+		for _, d := range docs {
+			ok, err := m.match(d, filter)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				out = append(out, d)
+			}
+		}
+		return out, nil
+	case "$sort":
+		spec, ok := arg.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("store: $sort needs a document")
+		}
+		rows := make([]row, len(docs))
+		for i, d := range docs {
+			rows[i] = row{doc: d}
+		}
+		if err := sortRows(rows, spec); err != nil {
+			return nil, err
+		}
+		for i, r := range rows {
+			docs[i] = r.doc
+		}
+		return docs, nil
+	case "$limit", "$skip":
+		n, ok := toInt64(arg)
+		if !ok || n < 0 {
+			return nil, fmt.Errorf("store: %s needs a non-negative integer", name)
+		}
+		if n > int64(len(docs)) {
+			n = int64(len(docs))
+		}
+		if name == "$limit" {
+			return docs[:n], nil
+		}
+		return docs[n:], nil
+	case "$count":
+		field, ok := arg.(string)
+		if !ok || field == "" {
+			return nil, fmt.Errorf("store: $count needs a field name")
+		}
+		if len(docs) == 0 {
+			return nil, nil
+		}
+		return []bson.D{{{Key: field, Value: number(int64(len(docs)))}}}, nil
+	case "$project":
+		spec, ok := arg.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("store: $project needs a document")
+		}
+		return p.each(docs, func(d bson.D) (bson.D, error) {
+			return project(d, spec, newEvaluator(d, p.vars), 0)
+		})
+	case "$set", "$addFields":
+		spec, ok := arg.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("store: %s needs a document", name)
+		}
+		return p.each(docs, func(d bson.D) (bson.D, error) {
+			return addFields(d, spec, newEvaluator(d, p.vars))
+		})
+	case "$unset":
+		fields, ok := arg.(bson.A)
+		if !ok {
+			fields = bson.A{arg}
+		}
+		return p.each(docs, func(d bson.D) (bson.D, error) {
+			for _, f := range fields {
+				name, ok := f.(string)
+				if !ok {
+					return nil, fmt.Errorf("store: $unset needs field names")
+				}
+				d = unsetPath(d, splitPath(name))
+			}
+			return d, nil
+		})
+	case "$replaceRoot", "$replaceWith":
+		expr := arg
+		if name == "$replaceRoot" {
+			spec, _ := arg.(bson.D)
+			expr, _ = get(spec, "newRoot")
+		}
+		return p.each(docs, func(d bson.D) (bson.D, error) {
+			v, err := newEvaluator(d, p.vars).eval(expr)
+			if err != nil {
+				return nil, err
+			}
+			root, ok := v.(bson.D)
+			if !ok {
+				return nil, fmt.Errorf("store: %s needs a document, not %s", name, typeName(v))
+			}
+			return root, nil
+		})
+	case "$unwind":
+		return unwind(docs, arg)
+	case "$group":
+		spec, ok := arg.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("store: $group needs a document")
+		}
+		return p.group(docs, spec)
+	case "$facet":
+		spec, ok := arg.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("store: $facet needs a document")
+		}
+		out := bson.D{}
+		for _, f := range spec {
+			sub, err := stages(f.Value)
+			if err != nil {
+				return nil, err
+			}
+			res, err := p.run(append([]bson.D{}, docs...), sub)
+			if err != nil {
+				return nil, err
+			}
+			a := make(bson.A, len(res))
+			for i, d := range res {
+				a[i] = d
+			}
+			out = append(out, bson.E{Key: f.Key, Value: a})
+		}
+		return []bson.D{out}, nil
+	case "$lookup":
+		spec, ok := arg.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("store: $lookup needs a document")
+		}
+		return p.lookup(docs, spec)
+	}
+	return nil, fmt.Errorf("store: unsupported pipeline stage %s", name)
+}
+
+func (p *pipeline) each(docs []bson.D, fn func(bson.D) (bson.D, error)) ([]bson.D, error) {
+	out := make([]bson.D, len(docs))
+	for i, d := range docs {
+		var err error
+		if out[i], err = fn(d); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// addFields sets each field of spec to its expression's value; $$REMOVE
+// unsets it.
+func addFields(d bson.D, spec bson.D, ev *evaluator) (bson.D, error) {
+	for _, f := range spec {
+		v, err := ev.eval(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		if v == missing {
+			d = unsetPath(d, splitPath(f.Key))
+			continue
+		}
+		if d, err = setPath(d, splitPath(f.Key), v); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// project applies a projection, of inclusions, exclusions, computed fields
+// and {$meta: "textScore"}, which is score.
+func project(d bson.D, spec bson.D, ev *evaluator, score float64) (bson.D, error) {
+	include, exclude := false, false
+	keepID := true
+	var computed bson.D
+	for _, f := range spec {
+		if f.Key == "_id" && !truthy(f.Value) {
+			keepID = false
+			continue
+		}
+		switch {
+		case isFlag(f.Value) && truthy(f.Value):
+			include = true
+		case isFlag(f.Value):
+			exclude = true
+		case isMeta(f.Value):
+			computed = append(computed, f)
+		default:
+			include = true
+			computed = append(computed, f)
+		}
+	}
+	if include && exclude {
+		return nil, fmt.Errorf("store: a projection cannot both include and exclude fields")
+	}
+
+	var out bson.D
+	if include {
+		out = bson.D{}
+		if id, ok := get(d, "_id"); ok && keepID {
+			out = append(out, bson.E{Key: "_id", Value: id})
+		}
+		for _, f := range spec {
+			if f.Key == "_id" || !isFlag(f.Value) || !truthy(f.Value) {
+				continue
+			}
+			path := splitPath(f.Key)
+			if v, ok := getPath(d, path); ok {
+				var err error
+				if out, err = setPath(out, path, v); err != nil {
+					return nil, err
+				}
+			}
+		}
+	} else {
+		out = append(bson.D{}, d...)
+		for _, f := range spec {
+			if isFlag(f.Value) && (f.Key != "_id" || !keepID) {
+				out = unsetPath(out, splitPath(f.Key))
+			}
+		}
+	}
+	for _, f := range computed {
+		var v interface{} = score
+		if !isMeta(f.Value) {
+			var err error
+			if v, err = ev.eval(f.Value); err != nil {
+				return nil, err
+			}
+			if v == missing {
+				continue
+			}
+		}
+		var err error
+		if out, err = setPath(out, splitPath(f.Key), v); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func isFlag(v interface{}) bool {
+	_, isBool := v.(bool)
+	return isBool || isNumber(v)
+}
+
+func isMeta(v interface{}) bool {
+	d, ok := v.(bson.D)
+	if !ok || len(d) != 1 || d[0].Key != "$meta" {
+		return false
+	}
+	return d[0].Value == "textScore"
+}
+
+func unwind(docs []bson.D, arg interface{}) ([]bson.D, error) {
+	path, preserve := "", false
+	switch x := arg.(type) {
+	case string:
+		path = x
+	case bson.D:
+		p, _ := get(x, "path")
+		path, _ = p.(string)
+		keep, _ := get(x, "preserveNullAndEmptyArrays")
+		preserve = truthy(keep)
+	}
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("store: $unwind needs a $-prefixed path")
+	}
+	parts := splitPath(path[1:])
+	var out []bson.D
+	for _, d := range docs {
+		v, _ := getPath(d, parts)
+		a, isArray := v.(bson.A)
+		switch {
+		case isArray && len(a) > 0:
+			for _, e := range a {
+				u, err := setPath(d, parts, e)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, u)
+			}
+		case !isArray && !isNullish(v):
+			out = append(out, d)
+		case preserve:
+			if isArray {
+				d = unsetPath(d, parts)
+			}
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+func (p *pipeline) group(docs []bson.D, spec bson.D) ([]bson.D, error) {
+	idExpr, ok := get(spec, "_id")
+	if !ok {
+		return nil, fmt.Errorf("store: $group needs an _id")
+	}
+	type field struct {
+		name, op string
+		expr     interface{}
+	}
+	var fields []field
+	for _, f := range spec {
+		if f.Key == "_id" {
+			continue
+		}
+		acc, ok := f.Value.(bson.D)
+		if !ok || len(acc) != 1 {
+			return nil, fmt.Errorf("store: $group field %s needs one accumulator", f.Key)
+		}
+		fields = append(fields, field{name: f.Key, op: acc[0].Key, expr: acc[0].Value})
+	}
+
+	type bucket struct {
+		id   interface{}
+		accs []*accumulator
+	}
+	var buckets []*bucket
+	index := map[string]*bucket{}
+	for _, d := range docs {
+		ev := newEvaluator(d, p.vars)
+		id, err := ev.eval(idExpr)
+		if err != nil {
+			return nil, err
+		}
+		if id == missing {
+			id = nil
+		}
+		key := groupKey(id)
+		b := index[key]
+		if b == nil {
+			b = &bucket{id: id}
+			for _, f := range fields {
+				b.accs = append(b.accs, newAccumulator(f.op))
+			}
+			index[key] = b
+			buckets = append(buckets, b)
+		}
+		for i, f := range fields {
+			v, err := ev.eval(f.expr)
+			if err != nil {
+				return nil, err
+			}
+			b.accs[i].add(v)
+		}
+	}
+	out := make([]bson.D, len(buckets))
+	for i, b := range buckets {
+		d := bson.D{{Key: "_id", Value: b.id}}
+		for j, f := range fields {
+			d = append(d, bson.E{Key: f.name, Value: b.accs[j].result()})
+		}
+		out[i] = d
+	}
+	return out, nil
+}
+
+// groupKey gives values that $group puts together the same key: numbers by
+// value, null with missing.
+func groupKey(v interface{}) string {
+	var b strings.Builder
+	writeKey(&b, v)
+	return b.String()
+}
+
+func writeKey(b *strings.Builder, v interface{}) {
+	switch x := v.(type) {
+	case nil, missingValue:
+		b.WriteString("null")
+	case int32, int64, float64:
+		b.WriteString("n" + strconv.FormatFloat(toFloat(x), 'g', -1, 64))
+	case string:
+		b.WriteString(strconv.Quote(x))
+	case bson.D:
+		b.WriteByte('{')
+		for _, e := range x {
+			b.WriteString(strconv.Quote(e.Key) + ":")
+			writeKey(b, e.Value)
+			b.WriteByte(',')
+		}
+		b.WriteByte('}')
+	case bson.A:
+		b.WriteByte('[')
+		for _, e := range x {
+			writeKey(b, e)
+			b.WriteByte(',')
+		}
+		b.WriteByte(']')
+	default:
+		fmt.Fprintf(b, "%s:%v", typeName(v), v)
+	}
+}
+
+func (p *pipeline) lookup(docs []bson.D, spec bson.D) ([]bson.D, error) {
+	str := func(key string) string {
+		v, _ := get(spec, key)
+		s, _ := v.(string)
+		return s
+	}
+	from, as := str("from"), str("as")
+	if from == "" || as == "" {
+		return nil, fmt.Errorf("store: $lookup needs from and as")
+	}
+	coll := p.from(from)
+	ctx := Unscoped(p.ctx)
+	if sub, ok := get(spec, "pipeline"); ok {
+		subStages, err := stages(sub)
+		if err != nil {
+			return nil, err
+		}
+		foreign, err := findAll(ctx, coll, bson.D{})
+		if err != nil {
+			return nil, err
+		}
+		let, _ := get(spec, "let")
+		letSpec, _ := let.(bson.D)
+		return p.each(docs, func(d bson.D) (bson.D, error) {
+			vars := map[string]interface{}{}
+			for k, v := range p.vars {
+				vars[k] = v
+			}
+			ev := newEvaluator(d, p.vars)
+			for _, l := range letSpec {
+				v, err := ev.eval(l.Value)
+				if err != nil {
+					return nil, err
+				}
+				vars[l.Key] = v
+			}
+			inner := &pipeline{ctx: p.ctx, vars: vars, from: p.from}
+			res, err := inner.run(append([]bson.D{}, foreign...), subStages)
+			if err != nil {
+				return nil, err
+			}
+			return setPath(d, splitPath(as), docsArray(res))
+		})
+	}
+
+	localField, foreignField := str("localField"), str("foreignField")
+	if localField == "" || foreignField == "" {
+		return nil, fmt.Errorf("store: $lookup needs localField and foreignField or a pipeline")
+	}
+	locals := make([]bson.A, len(docs))
+	all := bson.A{}
+	for i, d := range docs {
+		for _, v := range resolve(d, splitPath(localField)) {
+			if a, ok := v.(bson.A); ok {
+				locals[i] = append(locals[i], a...)
+				continue
+			}
+			if v == missing {
+				v = nil
+			}
+			locals[i] = append(locals[i], v)
+		}
+		all = append(all, locals[i]...)
+	}
+	foreign, err := findAll(ctx, coll, bson.D{{Key: foreignField, Value: bson.D{{Key: "$in", Value: all}}}})
+	if err != nil {
+		return nil, err
+	}
+	var m matcher
+	out := make([]bson.D, len(docs))
+	for i, d := range docs {
+		filter := bson.D{{Key: foreignField, Value: bson.D{{Key: "$in", Value: locals[i]}}}}
+		var joined []bson.D
+		for _, f := range foreign {
+			ok, err := m.match(f, filter)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				joined = append(joined, f)
+			}
+		}
+		if out[i], err = setPath(d, splitPath(as), docsArray(joined)); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func docsArray(docs []bson.D) bson.A {
+	a := make(bson.A, len(docs))
+	for i, d := range docs {
+		a[i] = d
+	}
+	return a
+}
+
+// findAll reads every document of coll matching filter.
+func findAll(ctx context.Context, coll Collection, filter interface{}) ([]bson.D, error) {
+	cursor, err := coll.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return readAll(ctx, cursor)
+}
+
+func readAll(ctx context.Context, cursor *mongo.Cursor) ([]bson.D, error) {
+	defer cursor.Close(ctx)
+	var docs []bson.D
+	for cursor.Next(ctx) {
+		d, err := toDoc(cursor.Current)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, d)
+	}
+	return docs, cursor.Err()
+}
+
+// row is a document on its way out of a query, with its text score.
+type row struct {
+	doc   bson.D
+	score float64
+}
+
+// sortRows orders rows by a sort specification. An array sorts by its
+// smallest element ascending and its largest descending, and
+// {$meta: "textScore"} sorts by score, best first.
+func sortRows(rows []row, spec bson.D) error {
+	type key struct {
+		parts []string
+		dir   int
+		meta  bool
+	}
+	keys := make([]key, len(spec))
+	for i, f := range spec {
+		if isMeta(f.Value) {
+			keys[i] = key{meta: true}
+			continue
+		}
+		n, ok := toInt64(f.Value)
+		if !ok || (n != 1 && n != -1) {
+			return fmt.Errorf("store: sort direction for %s must be 1 or -1", f.Key)
+		}
+		keys[i] = key{parts: splitPath(f.Key), dir: int(n)}
+	}
+	values := make([][]interface{}, len(rows))
+	for i, r := range rows {
+		values[i] = make([]interface{}, len(keys))
+		for j, k := range keys {
+			if !k.meta {
+				values[i][j] = sortValue(r.doc, k.parts, k.dir)
+			}
+		}
+	}
+	perm := make([]int, len(rows))
+	for i := range perm {
+		perm[i] = i
+	}
+	sort.SliceStable(perm, func(a, b int) bool {
+		ia, ib := perm[a], perm[b]
+		for j, k := range keys {
+			if k.meta {
+				if c := cmpFloat(rows[ib].score, rows[ia].score); c != 0 {
+					return c < 0
+				}
+				continue
+			}
+			if c := compare(values[ia][j], values[ib][j]) * k.dir; c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	sorted := make([]row, len(rows))
+	for i, p := range perm {
+		sorted[i] = rows[p]
+	}
+	copy(rows, sorted)
+	return nil
+}
+
+func sortValue(d bson.D, parts []string, dir int) interface{} {
+	var best interface{} = missing
+	first := true
+	for _, v := range resolve(d, parts) {
+		candidates := []interface{}{v}
+		if a, ok := v.(bson.A); ok && len(a) > 0 {
+			candidates = a
+		}
+		for _, c := range candidates {
+			if first || compare(c, best)*dir < 0 {
+				best, first = c, false
+			}
+		}
+	}
+	return best
+}
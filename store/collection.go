@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection is the document access the handlers have to a store's
+// collections: the part of *mongo.Collection they use, confined to the
+// organization of each call's context and stamping updatedAt on updates, as
+// Scoped does. Cursors and results are the mongo driver's own, so callers
+// decode and check errors the same way against every store.
+type Collection interface {
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+	FindOneAndUpdate(ctx context.Context, filter, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult
+	FindOneAndDelete(ctx context.Context, filter interface{}, opts ...*options.FindOneAndDeleteOptions) *mongo.SingleResult
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error)
+	UpdateOne(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	UpdateMany(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	ReplaceOne(ctx context.Context, filter, replacement interface{}, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error)
+	DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error)
+	CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error)
+	Distinct(ctx context.Context, field string, filter interface{}, opts ...*options.DistinctOptions) ([]interface{}, error)
+	Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error)
+	Watch(ctx context.Context, pipeline interface{}, opts ...*options.ChangeStreamOptions) (ChangeStream, error)
+}
+
+// ChangeStream is the part of *mongo.ChangeStream the handlers use. Events
+// have MongoDB's shape: operationType, documentKey, fullDocument,
+// updateDescription and clusterTime.
+type ChangeStream interface {
+	Next(ctx context.Context) bool
+	TryNext(ctx context.Context) bool
+	Decode(v interface{}) error
+	Err() error
+	Close(ctx context.Context) error
+	ResumeToken() bson.Raw
+}
+
+// Documents is a store seen as collections of documents. The handlers keep
+// every collection in it, so the PostgreSQL and in-memory stores hold the
+// only copy.
+type Documents interface {
+	Collection(name string) Collection
+	// OrgCollection returns a collection confined to the organization of
+	// each call's context, as Collection already is for users and
+	// complaints.
+	OrgCollection(name string) Collection
+	// InTransaction runs fn so that its writes to the store's collections
+	// commit or roll back together, or in the caller's transaction if ctx
+	// already carries one. fn may run more than once.
+	InTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+	// EnsureIndex creates an index on one of the store's collections if it
+	// does not exist. Stores other than Mongo scan collections, so of their
+	// indexes only the unique, text and TTL ones, which change what queries
+	// and writes do, have any effect; TTL ones through ExpireDocuments.
+	EnsureIndex(ctx context.Context, name string, model mongo.IndexModel) error
+	// Indexes returns the names of a collection's indexes.
+	Indexes(ctx context.Context, name string) ([]string, error)
+}
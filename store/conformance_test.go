@@ -0,0 +1,689 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+// The conformance tests hold every store to MongoDB's behavior, through the
+// semantic interfaces and through Documents as the handlers use it.
+
+func newTestComplaint(t *testing.T, s testStore, c models.Complaint) models.Complaint {
+	t.Helper()
+	if c.ID.IsZero() {
+		c.ID = primitive.NewObjectID()
+	}
+	if _, err := s.CreateComplaint(context.Background(), c); err != nil {
+		t.Fatalf("CreateComplaint: %v", err)
+	}
+	got, err := s.Complaint(context.Background(), c.ID)
+	if err != nil {
+		t.Fatalf("Complaint: %v", err)
+	}
+	return got
+}
+
+// requireTransactions skips a test on a standalone mongod.
+func requireTransactions(t *testing.T, s testStore) {
+	t.Helper()
+	m, ok := s.(*Mongo)
+	if !ok {
+		return
+	}
+	session, err := m.client.StartSession()
+	if err != nil {
+		t.Fatalf("StartSession: %v", err)
+	}
+	defer session.EndSession(context.Background())
+	_, err = session.WithTransaction(context.Background(), func(sc mongo.SessionContext) (interface{}, error) {
+		return m.db.Collection("probe").InsertOne(sc, bson.M{})
+	})
+	if TransactionsUnsupported(err) {
+		t.Skip("the server has no transactions")
+	}
+}
+
+func TestUsers(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s testStore) {
+		ctx := context.Background()
+		user := newTestUser(t, s)
+
+		dup := models.User{ID: primitive.NewObjectID(), Email: user.Email, Complaints: []primitive.ObjectID{}}
+		if err := s.CreateUser(ctx, dup); err != ErrDuplicate {
+			t.Errorf("CreateUser with a taken email = %v, want ErrDuplicate", err)
+		}
+
+		if err := s.SetSecretCodeHash(ctx, user.ID, "hash-1"); err != nil {
+			t.Fatalf("SetSecretCodeHash: %v", err)
+		}
+		got, err := s.UserBySecretCodeHash(ctx, "hash-1")
+		if err != nil || got.ID != user.ID {
+			t.Errorf("UserBySecretCodeHash = %v, %v, want user %s", got.ID.Hex(), err, user.ID.Hex())
+		}
+		if got.UpdatedAt.IsZero() {
+			t.Error("SetSecretCodeHash did not stamp updatedAt")
+		}
+		if err := s.SetSecretCodeHash(ctx, primitive.NewObjectID(), "hash-2"); err != ErrNotFound {
+			t.Errorf("SetSecretCodeHash of a missing user = %v, want ErrNotFound", err)
+		}
+		if _, err := s.UserBySecretCodeHash(ctx, "no-such-hash"); err != ErrNotFound {
+			t.Errorf("UserBySecretCodeHash of an unknown hash = %v, want ErrNotFound", err)
+		}
+
+		updated, err := s.UpdateProfile(ctx, user.ID, 0, ProfileUpdate{Name: "Bea", Email: user.Email, Locale: "de"})
+		if err != nil {
+			t.Fatalf("UpdateProfile: %v", err)
+		}
+		if updated.Name != "Bea" || updated.Locale != "de" || updated.Version != 1 {
+			t.Errorf("UpdateProfile = %+v, want Bea, de at version 1", updated)
+		}
+		if _, err := s.UpdateProfile(ctx, user.ID, 0, ProfileUpdate{Name: "Cy", Email: user.Email}); err != ErrVersionConflict {
+			t.Errorf("UpdateProfile at a stale version = %v, want ErrVersionConflict", err)
+		}
+		if _, err := s.UpdateProfile(ctx, primitive.NewObjectID(), 0, ProfileUpdate{Email: "x@example.com"}); err != ErrNotFound {
+			t.Errorf("UpdateProfile of a missing user = %v, want ErrNotFound", err)
+		}
+		other := newTestUser(t, s)
+		if _, err := s.UpdateProfile(ctx, other.ID, 0, ProfileUpdate{Email: user.Email}); err != ErrDuplicate {
+			t.Errorf("UpdateProfile to a taken email = %v, want ErrDuplicate", err)
+		}
+	})
+}
+
+func TestComplaints(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s testStore) {
+		ctx := context.Background()
+		user := newTestUser(t, s)
+
+		orphan := models.Complaint{ID: primitive.NewObjectID(), UserID: primitive.NewObjectID(), Title: "orphan"}
+		if _, err := s.CreateComplaint(ctx, orphan); err != ErrNotFound {
+			t.Errorf("CreateComplaint for a missing user = %v, want ErrNotFound", err)
+		}
+		if _, err := s.Complaint(ctx, orphan.ID); err != ErrNotFound {
+			t.Errorf("a complaint for a missing user was kept: %v", err)
+		}
+
+		keyed := newTestComplaint(t, s, models.Complaint{UserID: user.ID, Title: "keyed", IdempotencyKey: "k1", Rating: 2})
+		again := models.Complaint{ID: primitive.NewObjectID(), UserID: user.ID, Title: "again", IdempotencyKey: "k1"}
+		if _, err := s.CreateComplaint(ctx, again); err != ErrDuplicate {
+			t.Errorf("CreateComplaint reusing an idempotency key = %v, want ErrDuplicate", err)
+		}
+		if got, err := s.ComplaintByIdempotencyKey(ctx, user.ID, "k1"); err != nil || got.ID != keyed.ID {
+			t.Errorf("ComplaintByIdempotencyKey = %s, %v, want %s", got.ID.Hex(), err, keyed.ID.Hex())
+		}
+		if err := s.ReleaseIdempotencyKey(ctx, user.ID, "k1", time.Now().Add(time.Minute)); err != nil {
+			t.Fatalf("ReleaseIdempotencyKey: %v", err)
+		}
+		if _, err := s.ComplaintByIdempotencyKey(ctx, user.ID, "k1"); err != ErrNotFound {
+			t.Errorf("ComplaintByIdempotencyKey after release = %v, want ErrNotFound", err)
+		}
+
+		resolved := newTestComplaint(t, s, models.Complaint{UserID: user.ID, Title: "resolved", Resolved: true, Rating: 5,
+			Category: "billing", Tags: []string{"urgent", "refund"}})
+		open := newTestComplaint(t, s, models.Complaint{UserID: user.ID, Title: "open", Rating: 4, Tags: []string{"urgent"}})
+
+		yes := true
+		for _, tc := range []struct {
+			name   string
+			filter ComplaintFilter
+			want   []primitive.ObjectID
+		}{
+			{"user", ComplaintFilter{UserID: user.ID}, []primitive.ObjectID{keyed.ID, resolved.ID, open.ID}},
+			{"resolved", ComplaintFilter{UserID: user.ID, Resolved: &yes}, []primitive.ObjectID{resolved.ID}},
+			{"tags", ComplaintFilter{UserID: user.ID, Tags: []string{"urgent"}}, []primitive.ObjectID{resolved.ID, open.ID}},
+			{"all tags", ComplaintFilter{UserID: user.ID, Tags: []string{"urgent", "refund"}}, []primitive.ObjectID{resolved.ID}},
+			{"category", ComplaintFilter{UserID: user.ID, Category: "billing"}, []primitive.ObjectID{resolved.ID}},
+			{"rating", ComplaintFilter{UserID: user.ID, MinRating: 3, MaxRating: 4}, []primitive.ObjectID{open.ID}},
+			{"to", ComplaintFilter{UserID: user.ID, To: keyed.CreatedAt}, nil},
+		} {
+			got, err := s.Complaints(ctx, tc.filter)
+			if err != nil {
+				t.Fatalf("Complaints %s: %v", tc.name, err)
+			}
+			if !sameIDs(got, tc.want) {
+				t.Errorf("Complaints %s = %v, want %v", tc.name, complaintIDs(got), tc.want)
+			}
+		}
+
+		since, err := s.ComplaintsSince(ctx, user.ID, resolved.CreatedAt)
+		if err != nil {
+			t.Fatalf("ComplaintsSince: %v", err)
+		}
+		if len(since) < 2 {
+			t.Errorf("ComplaintsSince = %v, want at least the last two", complaintIDs(since))
+		}
+
+		deleted, err := s.DeleteComplaint(ctx, open.ID, "admin@example.com")
+		if err != nil {
+			t.Fatalf("DeleteComplaint: %v", err)
+		}
+		if deleted.DeletedAt == nil || deleted.DeletedBy != "admin@example.com" {
+			t.Errorf("DeleteComplaint = %+v, want it marked deleted", deleted)
+		}
+		if _, err := s.DeleteComplaint(ctx, open.ID, "admin@example.com"); err != ErrNotFound {
+			t.Errorf("DeleteComplaint twice = %v, want ErrNotFound", err)
+		}
+		total, resolvedCount, err := s.CountComplaints(ctx, user.ID)
+		if err != nil || total != 2 || resolvedCount != 1 {
+			t.Errorf("CountComplaints = %d, %d, %v, want 2, 1", total, resolvedCount, err)
+		}
+		if got, _ := s.Complaints(ctx, ComplaintFilter{UserID: user.ID, IncludeDeleted: true}); len(got) != 3 {
+			t.Errorf("Complaints including deleted = %v, want 3", complaintIDs(got))
+		}
+		restored, err := s.RestoreComplaint(ctx, open.ID)
+		if err != nil || restored.DeletedAt != nil {
+			t.Errorf("RestoreComplaint = %+v, %v, want it restored", restored, err)
+		}
+		if _, err := s.RestoreComplaint(ctx, open.ID); err != ErrNotFound {
+			t.Errorf("RestoreComplaint twice = %v, want ErrNotFound", err)
+		}
+
+		name := "seq-" + primitive.NewObjectID().Hex()
+		for want := int64(1); want <= 3; want++ {
+			if got, err := s.NextSequence(ctx, name); err != nil || got != want {
+				t.Errorf("NextSequence = %d, %v, want %d", got, err, want)
+			}
+		}
+	})
+}
+
+func complaintIDs(complaints []models.Complaint) []primitive.ObjectID {
+	out := make([]primitive.ObjectID, len(complaints))
+	for i, c := range complaints {
+		out[i] = c.ID
+	}
+	return out
+}
+
+func sameIDs(complaints []models.Complaint, want []primitive.ObjectID) bool {
+	if len(complaints) != len(want) {
+		return false
+	}
+	have := map[primitive.ObjectID]bool{}
+	for _, c := range complaints {
+		have[c.ID] = true
+	}
+	for _, id := range want {
+		if !have[id] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestOrganizationScope(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s testStore) {
+		ctx := context.Background()
+		org := "org-" + primitive.NewObjectID().Hex()
+		inside := models.User{ID: primitive.NewObjectID(), Email: primitive.NewObjectID().Hex() + "@example.com",
+			OrgID: org, Complaints: []primitive.ObjectID{}}
+		if err := s.CreateUser(ctx, inside); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		outside := newTestUser(t, s)
+		mine := newTestComplaint(t, s, models.Complaint{UserID: inside.ID, OrgID: org, Title: "mine"})
+		newTestComplaint(t, s, models.Complaint{UserID: outside.ID, Title: "theirs"})
+
+		scoped := WithOrg(ctx, org)
+		if _, err := s.UserByID(scoped, outside.ID); err != ErrNotFound {
+			t.Errorf("UserByID across organizations = %v, want ErrNotFound", err)
+		}
+		if _, err := s.UserByID(scoped, inside.ID); err != nil {
+			t.Errorf("UserByID in the organization: %v", err)
+		}
+		got, err := s.Complaints(scoped, ComplaintFilter{})
+		if err != nil {
+			t.Fatalf("Complaints: %v", err)
+		}
+		if !sameIDs(got, []primitive.ObjectID{mine.ID}) {
+			t.Errorf("Complaints in the organization = %v, want only %s", complaintIDs(got), mine.ID.Hex())
+		}
+		n, err := s.Collection("complaints").CountDocuments(scoped, bson.M{})
+		if err != nil || n != 1 {
+			t.Errorf("CountDocuments in the organization = %d, %v, want 1", n, err)
+		}
+		res, err := s.Collection("complaints").UpdateMany(scoped, bson.M{"title": "theirs"}, bson.M{"$set": bson.M{"title": "taken"}})
+		if err != nil || res.MatchedCount != 0 {
+			t.Errorf("UpdateMany across organizations matched %v, %v, want nothing", res, err)
+		}
+	})
+}
+
+func TestCollectionQueries(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s testStore) {
+		ctx := context.Background()
+		user := newTestUser(t, s)
+		base := time.Now().UTC().Truncate(time.Millisecond)
+		for i := 0; i < 5; i++ {
+			newTestComplaint(t, s, models.Complaint{UserID: user.ID, Title: string(rune('a' + i)), Rating: i + 1,
+				Category: []string{"billing", "delivery"}[i%2], Tags: []string{"t" + string(rune('0'+i%3))},
+				CreatedAt: base.Add(time.Duration(i) * time.Minute)})
+		}
+		complaints := s.Collection("complaints")
+
+		cursor, err := complaints.Find(ctx, bson.M{"userId": user.ID, "rating": bson.M{"$gte": 2}},
+			options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetSkip(1).SetLimit(2).
+				SetProjection(bson.M{"title": 1}))
+		if err != nil {
+			t.Fatalf("Find: %v", err)
+		}
+		var page []bson.M
+		if err := cursor.All(ctx, &page); err != nil {
+			t.Fatalf("Find: %v", err)
+		}
+		if len(page) != 2 || page[0]["title"] != "d" || page[1]["title"] != "c" || page[0]["rating"] != nil {
+			t.Errorf("Find page = %v, want titles d and c only", page)
+		}
+
+		cursor, err = complaints.Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"userId": user.ID}}},
+			{{Key: "$group", Value: bson.M{"_id": "$category", "n": bson.M{"$sum": 1}, "avg": bson.M{"$avg": "$rating"}}}},
+			{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		})
+		if err != nil {
+			t.Fatalf("Aggregate: %v", err)
+		}
+		var groups []struct {
+			ID  string  `bson:"_id"`
+			N   int     `bson:"n"`
+			Avg float64 `bson:"avg"`
+		}
+		if err := cursor.All(ctx, &groups); err != nil {
+			t.Fatalf("Aggregate: %v", err)
+		}
+		if len(groups) != 2 || groups[0].ID != "billing" || groups[0].N != 3 || groups[0].Avg != 3 || groups[1].N != 2 {
+			t.Errorf("Aggregate groups = %+v, want billing 3 averaging 3 and delivery 2", groups)
+		}
+
+		cursor, err = complaints.Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"userId": user.ID, "title": "a"}}},
+			{{Key: "$lookup", Value: bson.M{"from": "users", "localField": "userId", "foreignField": "_id", "as": "reporter"}}},
+			{{Key: "$unwind", Value: "$reporter"}},
+			{{Key: "$project", Value: bson.M{"email": "$reporter.email"}}},
+		})
+		if err != nil {
+			t.Fatalf("Aggregate $lookup: %v", err)
+		}
+		var joined []bson.M
+		if err := cursor.All(ctx, &joined); err != nil {
+			t.Fatalf("Aggregate $lookup: %v", err)
+		}
+		if len(joined) != 1 || joined[0]["email"] != user.Email {
+			t.Errorf("Aggregate $lookup = %v, want the reporter's email", joined)
+		}
+
+		tags, err := complaints.Distinct(ctx, "tags", bson.M{"userId": user.ID})
+		if err != nil || len(tags) != 3 {
+			t.Errorf("Distinct tags = %v, %v, want 3", tags, err)
+		}
+
+		res, err := complaints.UpdateMany(ctx, bson.M{"userId": user.ID, "category": "delivery"},
+			bson.M{"$inc": bson.M{"rating": 10}, "$push": bson.M{"tags": "late"}})
+		if err != nil || res.MatchedCount != 2 || res.ModifiedCount != 2 {
+			t.Errorf("UpdateMany = %+v, %v, want 2 matched and modified", res, err)
+		}
+		n, err := complaints.CountDocuments(ctx, bson.M{"userId": user.ID, "rating": bson.M{"$gt": 10}, "tags": "late"})
+		if err != nil || n != 2 {
+			t.Errorf("CountDocuments after UpdateMany = %d, %v, want 2", n, err)
+		}
+
+		var before models.Complaint
+		err = complaints.FindOneAndUpdate(ctx, bson.M{"userId": user.ID, "title": "a"},
+			bson.M{"$set": bson.M{"resolved": true}}).Decode(&before)
+		if err != nil || before.Resolved {
+			t.Errorf("FindOneAndUpdate returned %+v, %v, want the document before the update", before, err)
+		}
+		var after models.Complaint
+		if err := complaints.FindOne(ctx, bson.M{"_id": before.ID}).Decode(&after); err != nil {
+			t.Fatalf("FindOne: %v", err)
+		}
+		if !after.Resolved || after.ResolvedAt == nil || after.UpdatedAt.Before(before.UpdatedAt) {
+			t.Errorf("resolving gave %+v, want resolvedAt and updatedAt stamped", after)
+		}
+		if err := complaints.FindOne(ctx, bson.M{"_id": primitive.NewObjectID()}).Err(); err != mongo.ErrNoDocuments {
+			t.Errorf("FindOne of a missing document = %v, want ErrNoDocuments", err)
+		}
+	})
+}
+
+// TestSortedPages pages through complaints whose sort field is missing or
+// not a date in some of them, which sort by type as MongoDB orders types.
+func TestSortedPages(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s testStore) {
+		ctx := context.Background()
+		user := newTestUser(t, s)
+		base := time.Now().UTC().Truncate(time.Millisecond)
+		complaints := s.Collection("complaints")
+		if _, err := complaints.InsertMany(ctx, []interface{}{
+			bson.M{"userId": user.ID, "title": "old", "createdAt": base},
+			bson.M{"userId": user.ID, "title": "undated"},
+			bson.M{"userId": user.ID, "title": "new", "createdAt": base.Add(time.Hour)},
+			bson.M{"userId": user.ID, "title": "text", "createdAt": "yesterday"},
+			bson.M{"userId": user.ID.Hex(), "title": "by hex", "createdAt": base.Add(2 * time.Hour)},
+		}); err != nil {
+			t.Fatalf("InsertMany: %v", err)
+		}
+		page := func(filter bson.M, dir int, skip, limit int64) []string {
+			t.Helper()
+			cursor, err := complaints.Find(ctx, filter, options.Find().
+				SetSort(bson.D{{Key: "createdAt", Value: dir}}).SetSkip(skip).SetLimit(limit))
+			if err != nil {
+				t.Fatalf("Find: %v", err)
+			}
+			var found []struct {
+				Title string `bson:"title"`
+			}
+			if err := cursor.All(ctx, &found); err != nil {
+				t.Fatalf("Find: %v", err)
+			}
+			titles := make([]string, len(found))
+			for i, c := range found {
+				titles[i] = c.Title
+			}
+			return titles
+		}
+		for _, tc := range []struct {
+			dir         int
+			skip, limit int64
+			want        []string
+		}{
+			{-1, 0, 2, []string{"new", "old"}},
+			{1, 0, 2, []string{"undated", "text"}},
+			{1, 1, 2, []string{"text", "old"}},
+			{-1, 2, 5, []string{"text", "undated"}},
+		} {
+			if got := page(bson.M{"userId": user.ID}, tc.dir, tc.skip, tc.limit); !slices.Equal(got, tc.want) {
+				t.Errorf("page sorted %d skipping %d of %d = %v, want %v", tc.dir, tc.skip, tc.limit, got, tc.want)
+			}
+		}
+		if got := page(bson.M{"userId": user.ID.Hex()}, 1, 0, 1); !slices.Equal(got, []string{"by hex"}) {
+			t.Errorf("page of the hex userId = %v, want by hex", got)
+		}
+	})
+}
+
+func TestCollectionWrites(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s testStore) {
+		ctx := context.Background()
+		counters := s.Collection("counters")
+		name := "c-" + primitive.NewObjectID().Hex()
+
+		var counter struct {
+			Seq int64 `bson:"seq"`
+		}
+		err := counters.FindOneAndUpdate(ctx, bson.M{"_id": name}, bson.M{"$inc": bson.M{"seq": int64(5)}},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&counter)
+		if err != nil || counter.Seq != 5 {
+			t.Errorf("upserting FindOneAndUpdate = %d, %v, want 5", counter.Seq, err)
+		}
+		res, err := counters.UpdateOne(ctx, bson.M{"_id": name}, bson.M{"$setOnInsert": bson.M{"seq": int64(0)}},
+			options.Update().SetUpsert(true))
+		if err != nil || res.MatchedCount != 1 || res.ModifiedCount != 0 || res.UpsertedID != nil {
+			t.Errorf("$setOnInsert on an existing document = %+v, %v, want it left alone", res, err)
+		}
+
+		user := newTestUser(t, s)
+		c := newTestComplaint(t, s, models.Complaint{UserID: user.ID, Title: "history",
+			History: []models.StatusChange{{To: "open", ChangedBy: "ann@example.com"}, {To: "closed", ChangedBy: "bob@example.com"}}})
+		_, err = s.Collection("complaints").UpdateOne(ctx, bson.M{"_id": c.ID},
+			bson.M{"$set": bson.M{"history.$[e].changedBy": "erased"}},
+			options.Update().SetArrayFilters(options.ArrayFilters{Filters: bson.A{bson.M{"e.changedBy": "ann@example.com"}}}))
+		if err != nil {
+			t.Fatalf("UpdateOne with array filters: %v", err)
+		}
+		got, _ := s.Complaint(ctx, c.ID)
+		if len(got.History) != 2 || got.History[0].ChangedBy != "erased" || got.History[1].ChangedBy != "bob@example.com" {
+			t.Errorf("history after the array filter update = %+v", got.History)
+		}
+
+		archive := s.OrgCollection("complaints_archive")
+		archived := primitive.NewObjectID()
+		for _, title := range []string{"first", "second"} {
+			res, err := archive.ReplaceOne(ctx, bson.M{"_id": archived}, bson.M{"title": title, "orgId": "org-a"},
+				options.Replace().SetUpsert(true))
+			if err != nil {
+				t.Fatalf("ReplaceOne: %v", err)
+			}
+			if upserted := res.UpsertedID != nil; upserted != (title == "first") {
+				t.Errorf("ReplaceOne of %q upserted %v", title, res.UpsertedID)
+			}
+		}
+		var copied bson.M
+		if err := archive.FindOne(ctx, bson.M{"_id": archived}).Decode(&copied); err != nil || copied["title"] != "second" {
+			t.Errorf("archive after two ReplaceOne = %v, %v, want the second", copied, err)
+		}
+		if err := archive.FindOne(WithOrg(ctx, "org-b"), bson.M{"_id": archived}).Err(); err != mongo.ErrNoDocuments {
+			t.Errorf("FindOne across organizations = %v, want ErrNoDocuments", err)
+		}
+
+		outbox := s.Collection("outbox")
+		first, second := primitive.NewObjectID(), primitive.NewObjectID()
+		bulk, err := outbox.BulkWrite(ctx, []mongo.WriteModel{
+			mongo.NewInsertOneModel().SetDocument(bson.M{"_id": first, "event": "a"}),
+			mongo.NewInsertOneModel().SetDocument(bson.M{"_id": first, "event": "dup"}),
+			mongo.NewInsertOneModel().SetDocument(bson.M{"_id": second, "event": "b"}),
+		}, options.BulkWrite().SetOrdered(false))
+		if !mongo.IsDuplicateKeyError(err) || bulk == nil || bulk.InsertedCount != 2 {
+			t.Errorf("unordered BulkWrite with a duplicate = %+v, %v, want 2 inserted and a duplicate key error", bulk, err)
+		}
+		del, err := outbox.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": bson.A{first, second}}})
+		if err != nil || del.DeletedCount != 2 {
+			t.Errorf("DeleteMany = %+v, %v, want 2", del, err)
+		}
+
+		if err := s.EnsureIndex(ctx, "complaints", mongo.IndexModel{
+			Keys: bson.D{{Key: "refNumber", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"refNumber": bson.M{"$type": "number"}}),
+		}); err != nil {
+			t.Fatalf("EnsureIndex: %v", err)
+		}
+		names, err := s.Indexes(ctx, "complaints")
+		if err != nil || !contains(names, "refNumber_1") {
+			t.Errorf("Indexes = %v, %v, want refNumber_1", names, err)
+		}
+		newTestComplaint(t, s, models.Complaint{UserID: user.ID, Title: "numbered", RefNumber: 42})
+		_, err = s.CreateComplaint(ctx, models.Complaint{ID: primitive.NewObjectID(), UserID: user.ID, Title: "renumbered", RefNumber: 42})
+		if err != ErrDuplicate {
+			t.Errorf("CreateComplaint with a taken refNumber = %v, want ErrDuplicate", err)
+		}
+		_, err = s.Collection("complaints").UpdateOne(ctx, bson.M{"_id": c.ID}, bson.M{"$set": bson.M{"refNumber": 42}})
+		if !mongo.IsDuplicateKeyError(err) {
+			t.Errorf("UpdateOne to a taken refNumber = %v, want a duplicate key error", err)
+		}
+		if _, err := s.Collection("complaints").UpdateOne(ctx, bson.M{"_id": c.ID}, bson.M{"$set": bson.M{"refNumber": 43}}); err != nil {
+			t.Errorf("UpdateOne to a free refNumber: %v", err)
+		}
+	})
+}
+
+func TestTextSearch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s testStore) {
+		ctx := context.Background()
+		if err := s.EnsureIndex(ctx, "complaints", mongo.IndexModel{
+			Keys:    bson.D{{Key: "title", Value: "text"}, {Key: "summary", Value: "text"}},
+			Options: options.Index().SetName("complaint_text").SetWeights(bson.M{"title": 3, "summary": 1}),
+		}); err != nil {
+			t.Fatalf("EnsureIndex: %v", err)
+		}
+		user := newTestUser(t, s)
+		inSummary := newTestComplaint(t, s, models.Complaint{UserID: user.ID, Title: "Late parcel", Summary: "The refund never arrived"})
+		inTitle := newTestComplaint(t, s, models.Complaint{UserID: user.ID, Title: "Refunds refused", Summary: "No answer"})
+		newTestComplaint(t, s, models.Complaint{UserID: user.ID, Title: "Broken box", Summary: "Crushed"})
+
+		score := bson.M{"score": bson.M{"$meta": "textScore"}}
+		cursor, err := s.Collection("complaints").Find(ctx, bson.M{"$text": bson.M{"$search": "refund"}, "userId": user.ID},
+			options.Find().SetProjection(score).SetSort(score))
+		if err != nil {
+			t.Fatalf("Find $text: %v", err)
+		}
+		var found []models.Complaint
+		if err := cursor.All(ctx, &found); err != nil {
+			t.Fatalf("Find $text: %v", err)
+		}
+		if len(found) != 2 || found[0].ID != inTitle.ID || found[1].ID != inSummary.ID {
+			t.Errorf("$text search = %v, want the title match before the summary match", complaintIDs(found))
+		}
+	})
+}
+
+func TestTransactionRollback(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s testStore) {
+		requireTransactions(t, s)
+		ctx := context.Background()
+		user := newTestUser(t, s)
+		c := newTestComplaint(t, s, models.Complaint{UserID: user.ID, Title: "before"})
+		event := primitive.NewObjectID()
+
+		errAbort := errors.New("abort")
+		err := s.InTransaction(ctx, func(ctx context.Context) error {
+			if _, err := s.Collection("complaints").UpdateOne(ctx, bson.M{"_id": c.ID}, bson.M{"$set": bson.M{"title": "after"}}); err != nil {
+				return err
+			}
+			if _, err := s.Collection("outbox").InsertOne(ctx, bson.M{"_id": event, "complaintId": c.ID}); err != nil {
+				return err
+			}
+			return errAbort
+		})
+		if err != errAbort {
+			t.Fatalf("InTransaction = %v, want the error from fn", err)
+		}
+		if got, _ := s.Complaint(ctx, c.ID); got.Title != "before" {
+			t.Errorf("a rolled back update was kept: title %q", got.Title)
+		}
+		if err := s.Collection("outbox").FindOne(ctx, bson.M{"_id": event}).Err(); err != mongo.ErrNoDocuments {
+			t.Errorf("a rolled back outbox event was kept: %v", err)
+		}
+
+		err = s.InTransaction(ctx, func(ctx context.Context) error {
+			if _, err := s.Collection("complaints").UpdateOne(ctx, bson.M{"_id": c.ID}, bson.M{"$set": bson.M{"title": "after"}}); err != nil {
+				return err
+			}
+			_, err := s.Collection("outbox").InsertOne(ctx, bson.M{"_id": event, "complaintId": c.ID})
+			return err
+		})
+		if err != nil {
+			t.Fatalf("InTransaction: %v", err)
+		}
+		if got, _ := s.Complaint(ctx, c.ID); got.Title != "after" {
+			t.Errorf("a committed update was lost: title %q", got.Title)
+		}
+		if err := s.Collection("outbox").FindOne(ctx, bson.M{"_id": event}).Err(); err != nil {
+			t.Errorf("a committed outbox event was lost: %v", err)
+		}
+	})
+}
+
+func TestChangeStream(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s testStore) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		opts := options.ChangeStream().SetFullDocument(options.UpdateLookup).SetMaxAwaitTime(100 * time.Millisecond)
+		stream, err := s.Collection("complaints").Watch(ctx, mongo.Pipeline{}, opts)
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.Code == 40573 {
+			t.Skip("the server has no change streams")
+		}
+		if err != nil {
+			t.Fatalf("Watch: %v", err)
+		}
+		defer stream.Close(ctx)
+
+		user := newTestUser(t, s)
+		c := newTestComplaint(t, s, models.Complaint{UserID: user.ID, Title: "watched"})
+		if _, err := s.Collection("complaints").UpdateOne(ctx, bson.M{"_id": c.ID}, bson.M{"$set": bson.M{"resolved": true}}); err != nil {
+			t.Fatalf("UpdateOne: %v", err)
+		}
+
+		type event struct {
+			OperationType string `bson:"operationType"`
+			DocumentKey   struct {
+				ID primitive.ObjectID `bson:"_id"`
+			} `bson:"documentKey"`
+			FullDocument      models.Complaint    `bson:"fullDocument"`
+			ClusterTime       primitive.Timestamp `bson:"clusterTime"`
+			UpdateDescription struct {
+				UpdatedFields bson.M `bson:"updatedFields"`
+			} `bson:"updateDescription"`
+		}
+		next := func(stream ChangeStream) (event, bson.Raw) {
+			t.Helper()
+			if !stream.Next(ctx) {
+				t.Fatalf("stream ended: %v", stream.Err())
+			}
+			var e event
+			if err := stream.Decode(&e); err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			return e, stream.ResumeToken()
+		}
+
+		inserted, token := next(stream)
+		if inserted.OperationType != "insert" || inserted.DocumentKey.ID != c.ID || inserted.FullDocument.Title != "watched" {
+			t.Errorf("first event = %+v, want the insert", inserted)
+		}
+		updated, _ := next(stream)
+		if updated.OperationType != "update" || updated.DocumentKey.ID != c.ID || !updated.FullDocument.Resolved ||
+			updated.UpdateDescription.UpdatedFields["resolved"] != true {
+			t.Errorf("second event = %+v, want the update with the document looked up", updated)
+		}
+		if updated.ClusterTime.Compare(inserted.ClusterTime) <= 0 {
+			t.Errorf("cluster times %v then %v do not increase", inserted.ClusterTime, updated.ClusterTime)
+		}
+
+		resumed, err := s.Collection("complaints").Watch(ctx, mongo.Pipeline{}, options.ChangeStream().
+			SetFullDocument(options.UpdateLookup).SetResumeAfter(token))
+		if err != nil {
+			t.Fatalf("Watch resuming: %v", err)
+		}
+		defer resumed.Close(ctx)
+		if again, _ := next(resumed); again.OperationType != "update" || again.DocumentKey.ID != c.ID {
+			t.Errorf("first event after resuming = %+v, want the update", again)
+		}
+	})
+}
+
+func TestExpireDocuments(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s testStore) {
+		expirer, ok := s.(interface {
+			ExpireDocuments(ctx context.Context) (int64, error)
+		})
+		if !ok {
+			t.Skip("the server expires documents itself")
+		}
+		ctx := context.Background()
+		if err := s.EnsureIndex(ctx, "sessions", mongo.IndexModel{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		}); err != nil {
+			t.Fatalf("EnsureIndex: %v", err)
+		}
+		sessions := s.Collection("sessions")
+		now := time.Now().UTC()
+		if _, err := sessions.InsertMany(ctx, []interface{}{
+			bson.M{"_id": "expired", "expiresAt": now.Add(-time.Minute)},
+			bson.M{"_id": "live", "expiresAt": now.Add(time.Hour)},
+			bson.M{"_id": "undated", "expiresAt": "tomorrow"},
+		}); err != nil {
+			t.Fatalf("InsertMany: %v", err)
+		}
+		n, err := expirer.ExpireDocuments(ctx)
+		if err != nil || n != 1 {
+			t.Errorf("ExpireDocuments = %d, %v, want 1", n, err)
+		}
+		left, err := sessions.Distinct(ctx, "_id", bson.M{})
+		if err != nil || len(left) != 2 {
+			t.Errorf("sessions left = %v, %v, want live and undated", left, err)
+		}
+	})
+}
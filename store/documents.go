@@ -0,0 +1,205 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+// The engine implements UserStore and ComplaintStore with the queries Mongo
+// runs, against its own collections, so the stores built on it keep users
+// and complaints where the handlers' direct collection access finds them.
+
+func duplicate(err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (e *engine) CreateUser(ctx context.Context, user models.User) error {
+	stampCreated(&user.CreatedAt, &user.UpdatedAt)
+	_, err := e.Collection("users").InsertOne(ctx, user)
+	return duplicate(err)
+}
+
+func (e *engine) UserBySecretCodeHash(ctx context.Context, hash string) (models.User, error) {
+	var user models.User
+	err := e.Collection("users").FindOne(ctx, bson.M{"secretCodeHash": hash}).Decode(&user)
+	return user, notFound(err)
+}
+
+func (e *engine) SetSecretCodeHash(ctx context.Context, id primitive.ObjectID, hash string) error {
+	res, err := e.Collection("users").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"secretCodeHash": hash}})
+	if err != nil {
+		return duplicate(err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (e *engine) UserByID(ctx context.Context, id primitive.ObjectID) (models.User, error) {
+	var user models.User
+	err := e.Collection("users").FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+	return user, notFound(err)
+}
+
+func (e *engine) UpdateProfile(ctx context.Context, id primitive.ObjectID, version int, update ProfileUpdate) (models.User, error) {
+	var user models.User
+	err := e.InTransaction(ctx, func(ctx context.Context) error {
+		users := e.Collection("users")
+		err := users.FindOneAndUpdate(ctx,
+			bson.M{"_id": id, "version": VersionFilter(version)},
+			bson.M{
+				"$set": bson.M{"name": update.Name, "email": update.Email, "locale": update.Locale},
+				"$inc": bson.M{"version": 1},
+			},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			if users.FindOne(ctx, bson.M{"_id": id}).Err() == nil {
+				return ErrVersionConflict
+			}
+			return ErrNotFound
+		}
+		return duplicate(err)
+	})
+	return user, err
+}
+
+// CreateComplaint inserts the complaint and links it to its user in one
+// unit of work, or in the caller's if ctx carries one.
+func (e *engine) CreateComplaint(ctx context.Context, complaint models.Complaint) (models.User, error) {
+	stampCreated(&complaint.CreatedAt, &complaint.UpdatedAt)
+	var user models.User
+	err := e.InTransaction(ctx, func(ctx context.Context) error {
+		if _, err := e.Collection("complaints").InsertOne(ctx, complaint); err != nil {
+			return duplicate(err)
+		}
+		err := e.Collection("users").FindOneAndUpdate(ctx,
+			bson.M{"_id": complaint.UserID},
+			bson.M{"$push": bson.M{"complaints": complaint.ID}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&user)
+		return notFound(err)
+	})
+	return user, err
+}
+
+func (e *engine) Complaint(ctx context.Context, id primitive.ObjectID) (models.Complaint, error) {
+	var complaint models.Complaint
+	err := e.Collection("complaints").FindOne(ctx, bson.M{"_id": id}).Decode(&complaint)
+	return complaint, notFound(err)
+}
+
+func (e *engine) findComplaints(ctx context.Context, filter bson.M) ([]models.Complaint, error) {
+	cursor, err := e.Collection("complaints").Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	complaints := []models.Complaint{}
+	if err := cursor.All(ctx, &complaints); err != nil {
+		return nil, err
+	}
+	return complaints, nil
+}
+
+func (e *engine) Complaints(ctx context.Context, filter ComplaintFilter) ([]models.Complaint, error) {
+	return e.findComplaints(ctx, filter.BSON())
+}
+
+func (e *engine) ComplaintsSince(ctx context.Context, userID primitive.ObjectID, since time.Time) ([]models.Complaint, error) {
+	return e.findComplaints(ctx, bson.M{"userId": userID, "createdAt": bson.M{"$gte": since}})
+}
+
+func (e *engine) ComplaintByIdempotencyKey(ctx context.Context, userID primitive.ObjectID, key string) (models.Complaint, error) {
+	var complaint models.Complaint
+	err := e.Collection("complaints").FindOne(ctx, bson.M{"userId": userID, "idempotencyKey": key}).Decode(&complaint)
+	return complaint, notFound(err)
+}
+
+func (e *engine) ReleaseIdempotencyKey(ctx context.Context, userID primitive.ObjectID, key string, before time.Time) error {
+	_, err := e.Collection("complaints").UpdateMany(ctx,
+		bson.M{"userId": userID, "idempotencyKey": key, "createdAt": bson.M{"$lt": before}},
+		bson.M{"$unset": bson.M{"idempotencyKey": "", "idempotentResponse": ""}})
+	return err
+}
+
+func (e *engine) CountComplaints(ctx context.Context, userID primitive.ObjectID) (int64, int64, error) {
+	complaints := e.Collection("complaints")
+	total, err := complaints.CountDocuments(ctx, bson.M{"userId": userID, "deletedAt": NotDeleted()})
+	if err != nil {
+		return 0, 0, err
+	}
+	resolved, err := complaints.CountDocuments(ctx, bson.M{"userId": userID, "resolved": true, "deletedAt": NotDeleted()})
+	return total, resolved, err
+}
+
+func (e *engine) DeleteComplaint(ctx context.Context, id primitive.ObjectID, deletedBy string) (models.Complaint, error) {
+	var complaint models.Complaint
+	err := e.Collection("complaints").FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "deletedAt": NotDeleted()},
+		bson.M{"$set": bson.M{"deletedAt": time.Now().UTC(), "deletedBy": deletedBy}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&complaint)
+	return complaint, notFound(err)
+}
+
+func (e *engine) RestoreComplaint(ctx context.Context, id primitive.ObjectID) (models.Complaint, error) {
+	var complaint models.Complaint
+	err := e.Collection("complaints").FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "deletedAt": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"deletedAt": "", "deletedBy": ""}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&complaint)
+	return complaint, notFound(err)
+}
+
+func (e *engine) NextSequence(ctx context.Context, name string) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := e.Collection("counters").FindOneAndUpdate(ctx,
+		bson.M{"_id": name},
+		bson.M{"$inc": bson.M{"seq": int64(1)}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	return counter.Seq, err
+}
+
+// storeIndexes are the unique indexes the methods above rely on, created
+// when an engine store is opened. The handlers' bootstrap creates the rest
+// under the same names.
+var storeIndexes = []struct {
+	collection string
+	model      mongo.IndexModel
+}{
+	{"users", mongo.IndexModel{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)}},
+	{"users", mongo.IndexModel{
+		Keys: bson.D{{Key: "secretCodeHash", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"secretCodeHash": bson.M{"$type": "string"}}),
+	}},
+	{"complaints", mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "idempotencyKey", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"idempotencyKey": bson.M{"$type": "string"}}),
+	}},
+}
+
+func (e *engine) ensureStoreIndexes(ctx context.Context) error {
+	for _, ix := range storeIndexes {
+		if err := e.EnsureIndex(ctx, ix.collection, ix.model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
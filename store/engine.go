@@ -0,0 +1,868 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// engine gives a backend that only stores documents MongoDB's collection
+// semantics: queries, updates, pipelines, unique and text indexes and change
+// streams are evaluated here, over the documents the backend hands back.
+// The PostgreSQL and in-memory stores are engines over their backends.
+type engine struct {
+	b backend
+
+	mu      sync.RWMutex
+	indexes map[string][]*indexDef
+}
+
+// backend keeps the documents of an engine's collections in insertion
+// order, the keys of their unique indexes, the index definitions and a log
+// of changes.
+type backend interface {
+	// begin starts a unit of work; one that does not write may read without
+	// a transaction.
+	begin(ctx context.Context, write bool) (txn, error)
+	loadIndexes(ctx context.Context) ([]indexDef, error)
+	// changes returns the logged changes after position after, oldest
+	// first, with the first position still logged and the latest one.
+	// Positions increase with each change but need not be consecutive.
+	changes(ctx context.Context, after int64) (events []change, oldest, latest int64, err error)
+	// changed, if not nil, is closed when changes are logged.
+	changed() <-chan struct{}
+	// retryable reports whether a transaction failed on a conflict with
+	// another and may succeed if run again.
+	retryable(err error) bool
+}
+
+// hint is what a backend may use of a filter to narrow a scan: the ids and
+// organization it confines documents to and its conditions on other
+// top-level fields. All are optional, and the engine matches the full
+// filter on what comes back. A find also passes its sort and how many
+// documents it wants, which a backend may apply to the documents it can
+// tell match when exact says the hint holds the whole filter.
+type hint struct {
+	ids   []string
+	org   *string
+	conds []cond
+	exact bool
+	sort  bson.D
+	limit int64
+}
+
+// cond is a filter's condition on a top-level field: a value to equal or a
+// document of operators.
+type cond struct {
+	field string
+	value interface{}
+}
+
+type txn interface {
+	scan(coll string, h hint) ([]bson.D, error)
+	// lock returns the current versions of the documents with the given ids,
+	// locked against other writers until the unit ends.
+	lock(coll string, ids []string) ([]bson.D, error)
+	// insert stores a new document, reporting false if its _id is taken.
+	insert(coll string, doc bson.D) (bool, error)
+	replace(coll string, doc bson.D) error
+	remove(coll string, id interface{}) error
+	// claim records key in a unique index as belonging to the document id
+	// unless it already belongs to another, whose id it returns.
+	claim(coll, index, key, id string) (string, error)
+	release(coll, index, key string) error
+	saveIndex(def indexDef) error
+	// record queues a change event, logged when the unit commits.
+	record(coll string, event bson.D)
+	commit() error
+	rollback() error
+}
+
+// change is a logged change event.
+type change struct {
+	seq   int64
+	at    time.Time
+	coll  string
+	event bson.D
+}
+
+type txnKey struct{ e *engine }
+
+func newEngine(ctx context.Context, b backend) (*engine, error) {
+	e := &engine{b: b, indexes: map[string][]*indexDef{}}
+	defs, err := b.loadIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range defs {
+		defs[i].prepare()
+		e.indexes[defs[i].Collection] = append(e.indexes[defs[i].Collection], &defs[i])
+	}
+	return e, nil
+}
+
+// Collection returns one of the store's collections. Users and complaints
+// are confined to the organization of each call's context and stamped on
+// update, as Scoped does for MongoDB.
+func (e *engine) Collection(name string) Collection {
+	c := engineCollection{e: e, name: name}
+	if orgScoped(name) {
+		return scopedCollection{c}
+	}
+	return c
+}
+
+func (e *engine) OrgCollection(name string) Collection {
+	return scopedCollection{engineCollection{e: e, name: name}}
+}
+
+// orgScoped reports whether a collection holds organization-owned documents.
+func orgScoped(name string) bool {
+	return name == "users" || name == "complaints"
+}
+
+// InTransaction runs fn in one unit of work, so its writes commit together
+// and, for the in-memory store, without other writers in between.
+func (e *engine) InTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txnKey{e}).(txn); ok {
+		return fn(ctx)
+	}
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		var t txn
+		if t, err = e.b.begin(ctx, true); err != nil {
+			return err
+		}
+		if err = fn(context.WithValue(ctx, txnKey{e}, t)); err != nil {
+			t.rollback()
+		} else {
+			err = t.commit()
+		}
+		if !e.b.retryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// run runs fn in ctx's unit of work, or in one of its own, retried as
+// InTransaction does.
+func (e *engine) run(ctx context.Context, write bool, fn func(t txn) error) error {
+	if t, ok := ctx.Value(txnKey{e}).(txn); ok {
+		return fn(t)
+	}
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		var t txn
+		if t, err = e.b.begin(ctx, write); err != nil {
+			return err
+		}
+		if err = fn(t); err != nil {
+			t.rollback()
+		} else {
+			err = t.commit()
+		}
+		if !e.b.retryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// indexDef is an index as the engine keeps it. Only unique and text
+// indexes change what it does; the others are kept for their names.
+type indexDef struct {
+	Collection string `bson:"collection"`
+	Name       string `bson:"name"`
+	Keys       bson.D `bson:"keys"`
+	Unique     bool   `bson:"unique,omitempty"`
+	Sparse     bool   `bson:"sparse,omitempty"`
+	Partial    bson.D `bson:"partial,omitempty"`
+	Weights    bson.D `bson:"weights,omitempty"`
+	TTL        *int32 `bson:"ttl,omitempty"`
+	text       *textIndex
+	paths      [][]string
+}
+
+func (d *indexDef) prepare() {
+	d.paths = nil
+	text := false
+	for _, k := range d.Keys {
+		d.paths = append(d.paths, splitPath(k.Key))
+		if k.Value == "text" {
+			text = true
+		}
+	}
+	if !text {
+		return
+	}
+	d.text = &textIndex{name: d.Name, weights: map[string]float64{}}
+	for _, k := range d.Keys {
+		if k.Value == "text" {
+			d.text.weights[k.Key] = 1
+		}
+	}
+	for _, w := range d.Weights {
+		if isNumber(w.Value) {
+			d.text.weights[w.Key] = toFloat(w.Value)
+		}
+	}
+}
+
+func (e *engine) defs(coll string) []*indexDef {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.indexes[coll]
+}
+
+func (e *engine) textIndex(coll string) *textIndex {
+	for _, d := range e.defs(coll) {
+		if d.text != nil {
+			return d.text
+		}
+	}
+	return nil
+}
+
+// indexName is the name MongoDB gives an index: its fields and directions
+// joined by underscores.
+func indexName(keys bson.D) string {
+	parts := make([]string, 0, 2*len(keys))
+	for _, k := range keys {
+		parts = append(parts, k.Key, fmt.Sprint(k.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+// EnsureIndex creates an index unless one of the name exists. A unique
+// index is built over the documents already stored, and fails if two of
+// them share a key.
+func (e *engine) EnsureIndex(ctx context.Context, name string, model mongo.IndexModel) error {
+	keys, err := asDoc(model.Keys, "index keys")
+	if err != nil {
+		return err
+	}
+	opts := options.MergeIndexOptions(model.Options)
+	def := indexDef{Collection: name, Keys: keys, Name: indexName(keys)}
+	if opts.Name != nil {
+		def.Name = *opts.Name
+	}
+	def.Unique = opts.Unique != nil && *opts.Unique
+	def.Sparse = opts.Sparse != nil && *opts.Sparse
+	def.TTL = opts.ExpireAfterSeconds
+	if opts.PartialFilterExpression != nil {
+		if def.Partial, err = asDoc(opts.PartialFilterExpression, "partial filter"); err != nil {
+			return err
+		}
+	}
+	if opts.Weights != nil {
+		if def.Weights, err = asDoc(opts.Weights, "weights"); err != nil {
+			return err
+		}
+	}
+	for _, d := range e.defs(name) {
+		if d.Name == def.Name {
+			return nil
+		}
+	}
+	def.prepare()
+	err = e.InTransaction(ctx, func(ctx context.Context) error {
+		t := ctx.Value(txnKey{e}).(txn)
+		if def.Unique {
+			docs, err := t.scan(name, hint{})
+			if err != nil {
+				return err
+			}
+			for _, d := range docs {
+				for _, key := range def.keys(d) {
+					owner, err := t.claim(name, def.Name, key, idKey(idOf(d)))
+					if err != nil {
+						return err
+					}
+					if owner != idKey(idOf(d)) {
+						return duplicateError(name, def.Name, key)
+					}
+				}
+			}
+		}
+		return t.saveIndex(def)
+	})
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.indexes[name] = append(e.indexes[name], &def)
+	e.mu.Unlock()
+	return nil
+}
+
+// Indexes returns the names of a collection's indexes, _id_ included.
+func (e *engine) Indexes(ctx context.Context, name string) ([]string, error) {
+	names := []string{"_id_"}
+	for _, d := range e.defs(name) {
+		names = append(names, d.Name)
+	}
+	return names, nil
+}
+
+// ExpireDocuments deletes the documents past their TTL index's expiry, as
+// MongoDB's TTL monitor does once a minute, and returns how many it deleted.
+// Like MongoDB it goes by the earliest date in the indexed field and leaves
+// documents without one.
+func (e *engine) ExpireDocuments(ctx context.Context) (int64, error) {
+	e.mu.RLock()
+	var ttl []*indexDef
+	for _, defs := range e.indexes {
+		for _, d := range defs {
+			if d.TTL != nil && len(d.Keys) == 1 {
+				ttl = append(ttl, d)
+			}
+		}
+	}
+	e.mu.RUnlock()
+	var deleted int64
+	for _, d := range ttl {
+		cutoff := time.Now().Add(-time.Duration(*d.TTL) * time.Second)
+		res, err := engineCollection{e: e, name: d.Collection}.DeleteMany(ctx, bson.M{d.Keys[0].Key: bson.M{"$lt": cutoff}})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += res.DeletedCount
+	}
+	return deleted, nil
+}
+
+// keys returns the keys a document has in a unique index: none if the
+// partial filter excludes it, one per element where a field is an array.
+// Fields under the same array are taken from the same element, so a
+// compound index on identities.provider and identities.subject keys each
+// identity rather than every pairing of providers and subjects.
+func (d *indexDef) keys(doc bson.D) []string {
+	if d.Partial != nil {
+		var m matcher
+		if ok, err := m.match(doc, d.Partial); err != nil || !ok {
+			return nil
+		}
+	}
+	tuples := indexTuples(doc, d.paths)
+	seen := map[string]bool{}
+	var keys []string
+	for _, t := range tuples {
+		if d.Sparse && allMissing(t) {
+			continue
+		}
+		for i, v := range t {
+			if v == missing {
+				t[i] = nil
+			}
+		}
+		k := groupKey(bson.A(t))
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func allMissing(t []interface{}) bool {
+	for _, v := range t {
+		if v != missing && v != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func indexTuples(v interface{}, paths [][]string) [][]interface{} {
+	if a, ok := v.(bson.A); ok && len(a) > 0 {
+		var out [][]interface{}
+		for _, e := range a {
+			out = append(out, indexTuples(e, paths)...)
+		}
+		return out
+	}
+	d, isDoc := v.(bson.D)
+	tuples := [][]interface{}{make([]interface{}, len(paths))}
+	groups := map[string][]int{}
+	var order []string
+	for i, p := range paths {
+		if len(p) == 0 {
+			for _, t := range tuples {
+				t[i] = v
+			}
+			continue
+		}
+		if !isDoc {
+			for _, t := range tuples {
+				t[i] = missing
+			}
+			continue
+		}
+		if _, ok := groups[p[0]]; !ok {
+			order = append(order, p[0])
+		}
+		groups[p[0]] = append(groups[p[0]], i)
+	}
+	for _, field := range order {
+		idx := groups[field]
+		sub := make([][]string, len(idx))
+		for j, i := range idx {
+			sub[j] = paths[i][1:]
+		}
+		child, _ := get(d, field)
+		subTuples := indexTuples(child, sub)
+		var next [][]interface{}
+		for _, t := range tuples {
+			for _, st := range subTuples {
+				n := append([]interface{}{}, t...)
+				for j, i := range idx {
+					n[i] = st[j]
+				}
+				next = append(next, n)
+			}
+		}
+		tuples = next
+	}
+	return tuples
+}
+
+func duplicateError(coll, index, key string) error {
+	return mongo.WriteException{WriteErrors: mongo.WriteErrors{{
+		Code:    11000,
+		Message: fmt.Sprintf("E11000 duplicate key error collection: %s index: %s dup key: %s", coll, index, key),
+	}}}
+}
+
+// put writes a change to one document, keeping the unique indexes and the
+// change log in step: old is nil for an insert and doc nil for a delete.
+func (e *engine) put(t txn, coll string, old, doc bson.D) error {
+	var id interface{}
+	if doc != nil {
+		id = idOf(doc)
+	} else {
+		id = idOf(old)
+	}
+	owner := idKey(id)
+
+	type claim struct{ index, key string }
+	var claimed, released []claim
+	for _, def := range e.defs(coll) {
+		if !def.Unique {
+			continue
+		}
+		var before, after []string
+		if old != nil {
+			before = def.keys(old)
+		}
+		if doc != nil {
+			after = def.keys(doc)
+		}
+		for _, k := range after {
+			if contains(before, k) {
+				continue
+			}
+			got, err := t.claim(coll, def.Name, k, owner)
+			if err != nil {
+				return err
+			}
+			if got != owner {
+				for _, c := range claimed {
+					t.release(coll, c.index, c.key)
+				}
+				return duplicateError(coll, def.Name, k)
+			}
+			claimed = append(claimed, claim{def.Name, k})
+		}
+		for _, k := range before {
+			if !contains(after, k) {
+				released = append(released, claim{def.Name, k})
+			}
+		}
+	}
+
+	var err error
+	switch {
+	case old == nil:
+		var ok bool
+		if ok, err = t.insert(coll, doc); err == nil && !ok {
+			err = duplicateError(coll, "_id_", owner)
+		}
+	case doc == nil:
+		err = t.remove(coll, id)
+	default:
+		err = t.replace(coll, doc)
+	}
+	if err != nil {
+		for _, c := range claimed {
+			t.release(coll, c.index, c.key)
+		}
+		return err
+	}
+	for _, c := range released {
+		if err := t.release(coll, c.index, c.key); err != nil {
+			return err
+		}
+	}
+	t.record(coll, changeEvent(coll, old, doc))
+	return nil
+}
+
+func contains(keys []string, k string) bool {
+	for _, have := range keys {
+		if have == k {
+			return true
+		}
+	}
+	return false
+}
+
+// changeEvent describes a write as a change stream reports it: the whole
+// document for inserts and updates, and for updates the top-level fields
+// set and removed.
+func changeEvent(coll string, old, doc bson.D) bson.D {
+	ns := bson.D{{Key: "coll", Value: coll}}
+	switch {
+	case old == nil:
+		return bson.D{
+			{Key: "operationType", Value: "insert"}, {Key: "ns", Value: ns},
+			{Key: "documentKey", Value: bson.D{{Key: "_id", Value: idOf(doc)}}},
+			{Key: "fullDocument", Value: doc},
+		}
+	case doc == nil:
+		return bson.D{
+			{Key: "operationType", Value: "delete"}, {Key: "ns", Value: ns},
+			{Key: "documentKey", Value: bson.D{{Key: "_id", Value: idOf(old)}}},
+		}
+	}
+	updated := bson.D{}
+	for _, f := range doc {
+		if v, ok := get(old, f.Key); !ok || !equal(v, f.Value) || typeName(v) != typeName(f.Value) {
+			updated = append(updated, f)
+		}
+	}
+	removed := bson.A{}
+	for _, f := range old {
+		if _, ok := get(doc, f.Key); !ok {
+			removed = append(removed, f.Key)
+		}
+	}
+	return bson.D{
+		{Key: "operationType", Value: "update"}, {Key: "ns", Value: ns},
+		{Key: "documentKey", Value: bson.D{{Key: "_id", Value: idOf(doc)}}},
+		{Key: "updateDescription", Value: bson.D{{Key: "updatedFields", Value: updated}, {Key: "removedFields", Value: removed}}},
+		{Key: "fullDocument", Value: doc},
+	}
+}
+
+// hintOf picks the conditions on top-level fields out of a filter, at its
+// top level or under $and. Any other operator, such as $or, leaves the hint
+// inexact.
+func hintOf(filter bson.D) hint {
+	h := hint{exact: true}
+	var walk func(f bson.D)
+	walk = func(f bson.D) {
+		for _, e := range f {
+			switch {
+			case e.Key == "$and":
+				clauses, _ := e.Value.(bson.A)
+				for _, c := range clauses {
+					if d, ok := c.(bson.D); ok {
+						walk(d)
+					} else {
+						h.exact = false
+					}
+				}
+			case strings.HasPrefix(e.Key, "$"):
+				h.exact = false
+			case e.Key == "_id" && h.ids == nil && idsOf(e.Value, &h.ids):
+			case e.Key == "orgId" && h.org == nil && orgIn(e.Value, &h):
+			default:
+				h.conds = append(h.conds, cond{e.Key, e.Value})
+			}
+		}
+	}
+	walk(filter)
+	return h
+}
+
+// idsOf sets ids to the values an _id condition allows, if it is one of
+// them or $in a list.
+func idsOf(v interface{}, ids *[]string) bool {
+	ops, isOps := operators(v)
+	switch {
+	case isOps && len(ops) == 1 && ops[0].Key == "$in":
+		list, ok := ops[0].Value.(bson.A)
+		if !ok {
+			return false
+		}
+		*ids = make([]string, 0, len(list))
+		for _, v := range list {
+			*ids = append(*ids, idKey(v))
+		}
+	case isOps && len(ops) == 1 && ops[0].Key == "$eq":
+		*ids = []string{idKey(ops[0].Value)}
+	case isOps:
+		return false
+	default:
+		if _, isDoc := v.(bson.D); isDoc {
+			return false
+		}
+		*ids = []string{idKey(v)}
+	}
+	return true
+}
+
+// orgIn sets the hint's organization from an orgId condition naming one,
+// or matching documents without one as orgMatch does. Equality with the
+// empty string also matches no organization but not a missing one, so it
+// leaves the hint inexact.
+func orgIn(v interface{}, h *hint) bool {
+	if s, ok := v.(string); ok {
+		h.org = &s
+		h.exact = h.exact && s != ""
+		return true
+	}
+	if ops, ok := operators(v); ok && len(ops) == 1 && ops[0].Key == "$in" {
+		if list, _ := ops[0].Value.(bson.A); len(list) == 2 && isNullish(list[0]) && list[1] == "" {
+			none := ""
+			h.org = &none
+			return true
+		}
+	}
+	return false
+}
+
+// orgOf is the organization a document is stored under for hints.
+func orgOf(doc bson.D) string {
+	org, _ := get(doc, "orgId")
+	s, _ := org.(string)
+	return s
+}
+
+// query finds the documents of coll matching filter, with their text
+// scores.
+func (e *engine) query(t txn, coll string, filter bson.D) ([]row, error) {
+	return e.queryHinted(t, coll, filter, hintOf(filter))
+}
+
+// queryHinted is query with the hint to scan by, which may carry a sort
+// and limit.
+func (e *engine) queryHinted(t txn, coll string, filter bson.D, h hint) ([]row, error) {
+	docs, err := t.scan(coll, h)
+	if err != nil {
+		return nil, err
+	}
+	m := matcher{text: e.textIndex(coll)}
+	var rows []row
+	for _, d := range docs {
+		ok, err := m.match(d, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rows = append(rows, row{doc: d, score: m.score})
+		}
+	}
+	return rows, nil
+}
+
+// relock locks the given documents and returns the ones that still match
+// filter, in the order given.
+func (e *engine) relock(t txn, coll string, filter bson.D, rows []row) ([]row, error) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, len(rows))
+	for i, r := range rows {
+		ids[i] = idKey(idOf(r.doc))
+	}
+	docs, err := t.lock(coll, ids)
+	if err != nil {
+		return nil, err
+	}
+	current := map[string]bson.D{}
+	for _, d := range docs {
+		current[idKey(idOf(d))] = d
+	}
+	m := matcher{text: e.textIndex(coll)}
+	var out []row
+	for _, id := range ids {
+		d, ok := current[id]
+		if !ok {
+			continue
+		}
+		ok, err := m.match(d, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out = append(out, row{doc: d, score: m.score})
+		}
+	}
+	return out, nil
+}
+
+type updateResult struct {
+	matched, modified int64
+	upsertedID        interface{}
+	before, after     bson.D
+}
+
+// update applies up to the first document matching filter in sort order,
+// or to all of them, upserting if asked and none matches.
+func (e *engine) update(t txn, coll string, filter bson.D, up *update, many, upsert bool, sortSpec bson.D) (updateResult, error) {
+	var res updateResult
+	for attempt := 0; ; attempt++ {
+		rows, err := e.query(t, coll, filter)
+		if err != nil {
+			return res, err
+		}
+		if sortSpec != nil {
+			if err := sortRows(rows, sortSpec); err != nil {
+				return res, err
+			}
+		}
+		for len(rows) > 0 {
+			batch := rows
+			if !many {
+				batch = rows[:1]
+			}
+			rows = rows[len(batch):]
+			locked, err := e.relock(t, coll, filter, batch)
+			if err != nil {
+				return res, err
+			}
+			for _, r := range locked {
+				doc, err := up.apply(r.doc, false)
+				if err != nil {
+					return res, err
+				}
+				res.matched++
+				res.before, res.after = r.doc, doc
+				if !sameDoc(r.doc, doc) {
+					if err := e.put(t, coll, r.doc, doc); err != nil {
+						return res, err
+					}
+					res.modified++
+				}
+				if !many {
+					return res, nil
+				}
+			}
+		}
+		if res.matched > 0 || !upsert {
+			return res, nil
+		}
+
+		seed, err := upsertSeed(filter)
+		if err != nil {
+			return res, err
+		}
+		doc, err := up.apply(seed, true)
+		if err != nil {
+			return res, err
+		}
+		doc = withID(doc)
+		err = e.put(t, coll, nil, doc)
+		if mongo.IsDuplicateKeyError(err) && attempt == 0 {
+			// Another writer inserted the document first: update it instead.
+			continue
+		}
+		if err != nil {
+			return res, err
+		}
+		res.upsertedID, res.after = idOf(doc), doc
+		return res, nil
+	}
+}
+
+// withID gives a new document an ObjectID _id unless it has one, putting
+// _id first as MongoDB does.
+func withID(doc bson.D) bson.D {
+	id, ok := get(doc, "_id")
+	if !ok {
+		id = primitive.NewObjectID()
+	}
+	rest, _ := remove(append(bson.D{}, doc...), "_id")
+	return append(bson.D{{Key: "_id", Value: id}}, rest...)
+}
+
+func sameDoc(a, b bson.D) bool {
+	ra, errA := bson.Marshal(a)
+	rb, errB := bson.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ra, rb)
+}
+
+func (e *engine) delete(t txn, coll string, filter bson.D, many bool, sortSpec bson.D) ([]bson.D, error) {
+	rows, err := e.query(t, coll, filter)
+	if err != nil {
+		return nil, err
+	}
+	if sortSpec != nil {
+		if err := sortRows(rows, sortSpec); err != nil {
+			return nil, err
+		}
+	}
+	var deleted []bson.D
+	for len(rows) > 0 {
+		batch := rows
+		if !many {
+			batch = rows[:1]
+		}
+		rows = rows[len(batch):]
+		locked, err := e.relock(t, coll, filter, batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range locked {
+			if err := e.put(t, coll, r.doc, nil); err != nil {
+				return nil, err
+			}
+			deleted = append(deleted, r.doc)
+			if !many {
+				return deleted, nil
+			}
+		}
+	}
+	return deleted, nil
+}
+
+func (e *engine) insert(t txn, coll string, document interface{}) (interface{}, error) {
+	doc, err := toDoc(document)
+	if err != nil {
+		return nil, err
+	}
+	doc = withID(doc)
+	return idOf(doc), e.put(t, coll, nil, doc)
+}
+
+func errorResult(err error) *mongo.SingleResult {
+	return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+}
+
+func singleResult(doc bson.D, err error) *mongo.SingleResult {
+	if err != nil {
+		return errorResult(err)
+	}
+	if doc == nil {
+		return errorResult(mongo.ErrNoDocuments)
+	}
+	return mongo.NewSingleResultFromDocument(doc, nil, nil)
+}
+
+func cursorOf(docs []bson.D) (*mongo.Cursor, error) {
+	list := make([]interface{}, len(docs))
+	for i, d := range docs {
+		list[i] = d
+	}
+	return mongo.NewCursorFromDocuments(list, nil, nil)
+}
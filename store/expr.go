@@ -0,0 +1,661 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// evaluator computes aggregation expressions against one document.
+type evaluator struct {
+	root interface{}
+	vars map[string]interface{}
+}
+
+func newEvaluator(root bson.D, vars map[string]interface{}) *evaluator {
+	return &evaluator{root: root, vars: vars}
+}
+
+func (ev *evaluator) eval(expr interface{}) (interface{}, error) {
+	switch x := expr.(type) {
+	case string:
+		if strings.HasPrefix(x, "$$") {
+			return ev.variable(x[2:])
+		}
+		if strings.HasPrefix(x, "$") {
+			return fieldPath(ev.root, splitPath(x[1:])), nil
+		}
+		return x, nil
+	case bson.A:
+		out := make(bson.A, len(x))
+		for i, e := range x {
+			v, err := ev.eval(e)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+	case bson.D:
+		if len(x) == 1 && strings.HasPrefix(x[0].Key, "$") {
+			return ev.operator(x[0].Key, x[0].Value)
+		}
+		out := bson.D{}
+		for _, e := range x {
+			v, err := ev.eval(e.Value)
+			if err != nil {
+				return nil, err
+			}
+			if v != missing {
+				out = append(out, bson.E{Key: e.Key, Value: v})
+			}
+		}
+		return out, nil
+	}
+	return expr, nil
+}
+
+func (ev *evaluator) variable(ref string) (interface{}, error) {
+	name, rest, _ := strings.Cut(ref, ".")
+	var v interface{}
+	switch name {
+	case "ROOT", "CURRENT":
+		v = ev.root
+	case "REMOVE":
+		return missing, nil
+	case "NOW":
+		v = primitive.NewDateTimeFromTime(time.Now())
+	default:
+		var ok bool
+		if v, ok = ev.vars[name]; !ok {
+			return nil, fmt.Errorf("store: undefined variable $$%s", name)
+		}
+	}
+	if rest == "" {
+		return v, nil
+	}
+	return fieldPath(v, splitPath(rest)), nil
+}
+
+// args evaluates an operator's arguments, an array or a single expression.
+func (ev *evaluator) args(v interface{}) ([]interface{}, error) {
+	list, ok := v.(bson.A)
+	if !ok {
+		list = bson.A{v}
+	}
+	out := make([]interface{}, len(list))
+	for i, e := range list {
+		var err error
+		if out[i], err = ev.eval(e); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (ev *evaluator) operator(op string, arg interface{}) (interface{}, error) {
+	switch op {
+	case "$literal":
+		return arg, nil
+	case "$cond":
+		return ev.cond(arg)
+	case "$and", "$or":
+		for _, e := range asArray(arg) {
+			v, err := ev.eval(e)
+			if err != nil {
+				return nil, err
+			}
+			if truthy(v) != (op == "$and") {
+				return op == "$or", nil
+			}
+		}
+		return op == "$and", nil
+	case "$dayOfWeek", "$hour", "$dateToString", "$year", "$month", "$dayOfMonth", "$minute":
+		return ev.dateOperator(op, arg)
+	}
+
+	args, err := ev.args(arg)
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "$ifNull":
+		for _, v := range args {
+			if !isNullish(v) {
+				return v, nil
+			}
+		}
+		if len(args) == 0 {
+			return nil, nil
+		}
+		return args[len(args)-1], nil
+	case "$not":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("store: $not takes 1 argument")
+		}
+		return !truthy(args[0]), nil
+	case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte", "$cmp":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("store: %s takes 2 arguments", op)
+		}
+		c := compare(args[0], args[1])
+		if c == 0 && typeRank(args[0]) == 2 && (args[0] == missing) != (args[1] == missing) {
+			// In expressions a missing field sorts below null.
+			c = -1
+			if args[0] != missing {
+				c = 1
+			}
+		}
+		switch op {
+		case "$eq":
+			return c == 0, nil
+		case "$ne":
+			return c != 0, nil
+		case "$gt":
+			return c > 0, nil
+		case "$gte":
+			return c >= 0, nil
+		case "$lt":
+			return c < 0, nil
+		case "$lte":
+			return c <= 0, nil
+		}
+		return int32(c), nil
+	case "$type":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("store: $type takes 1 argument")
+		}
+		return typeName(args[0]), nil
+	case "$add", "$subtract", "$multiply", "$divide", "$mod":
+		return arithmetic(op, args)
+	case "$abs":
+		if len(args) != 1 || isNullish(args[0]) {
+			return nil, nil
+		}
+		if n, ok := toInt64(args[0]); ok && !isFloat(args[0]) {
+			if n < 0 {
+				n = -n
+			}
+			return number(n), nil
+		}
+		return math.Abs(toFloat(args[0])), nil
+	case "$sum", "$avg", "$max", "$min":
+		values := args
+		if len(args) == 1 {
+			if a, ok := args[0].(bson.A); ok {
+				values = a
+			}
+		}
+		acc := newAccumulator(op)
+		for _, v := range values {
+			acc.add(v)
+		}
+		return acc.result(), nil
+	case "$first", "$last":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("store: %s takes 1 argument", op)
+		}
+		a, ok := args[0].(bson.A)
+		switch {
+		case isNullish(args[0]):
+			return nil, nil
+		case !ok:
+			return nil, fmt.Errorf("store: %s needs an array", op)
+		case len(a) == 0:
+			return missing, nil
+		case op == "$first":
+			return a[0], nil
+		}
+		return a[len(a)-1], nil
+	case "$arrayElemAt":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("store: $arrayElemAt takes 2 arguments")
+		}
+		a, ok := args[0].(bson.A)
+		i, isInt := toInt64(args[1])
+		if !ok || !isInt {
+			return nil, nil
+		}
+		if i < 0 {
+			i += int64(len(a))
+		}
+		if i < 0 || i >= int64(len(a)) {
+			return missing, nil
+		}
+		return a[i], nil
+	case "$size":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("store: $size takes 1 argument")
+		}
+		a, ok := args[0].(bson.A)
+		if !ok {
+			return nil, fmt.Errorf("store: $size needs an array, not %s", typeName(args[0]))
+		}
+		return int32(len(a)), nil
+	case "$slice":
+		return slice(args)
+	case "$in":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("store: $in takes 2 arguments")
+		}
+		a, ok := args[1].(bson.A)
+		if !ok {
+			return nil, fmt.Errorf("store: $in needs an array")
+		}
+		for _, v := range a {
+			if equal(v, args[0]) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$concat":
+		var b strings.Builder
+		for _, v := range args {
+			if isNullish(v) {
+				return nil, nil
+			}
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("store: $concat needs strings, not %s", typeName(v))
+			}
+			b.WriteString(s)
+		}
+		return b.String(), nil
+	case "$toLower", "$toUpper":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("store: %s takes 1 argument", op)
+		}
+		s := fmt.Sprint(args[0])
+		if isNullish(args[0]) {
+			s = ""
+		}
+		if op == "$toLower" {
+			return strings.ToLower(s), nil
+		}
+		return strings.ToUpper(s), nil
+	}
+	return nil, fmt.Errorf("store: unsupported expression operator %s", op)
+}
+
+func asArray(v interface{}) bson.A {
+	if a, ok := v.(bson.A); ok {
+		return a
+	}
+	return bson.A{v}
+}
+
+func (ev *evaluator) cond(arg interface{}) (interface{}, error) {
+	var ifExpr, thenExpr, elseExpr interface{}
+	switch x := arg.(type) {
+	case bson.A:
+		if len(x) != 3 {
+			return nil, fmt.Errorf("store: $cond takes 3 arguments")
+		}
+		ifExpr, thenExpr, elseExpr = x[0], x[1], x[2]
+	case bson.D:
+		ifExpr, _ = get(x, "if")
+		thenExpr, _ = get(x, "then")
+		elseExpr, _ = get(x, "else")
+	default:
+		return nil, fmt.Errorf("store: $cond needs an array or a document")
+	}
+	v, err := ev.eval(ifExpr)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(v) {
+		return ev.eval(thenExpr)
+	}
+	return ev.eval(elseExpr)
+}
+
+func isFloat(v interface{}) bool {
+	switch v.(type) {
+	case float64, primitive.Decimal128:
+		return true
+	}
+	return false
+}
+
+func arithmetic(op string, args []interface{}) (interface{}, error) {
+	for _, v := range args {
+		if isNullish(v) {
+			return nil, nil
+		}
+	}
+	if op == "$subtract" || op == "$divide" || op == "$mod" {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("store: %s takes 2 arguments", op)
+		}
+	}
+	if op == "$subtract" {
+		a, aDate := args[0].(primitive.DateTime)
+		b, bDate := args[1].(primitive.DateTime)
+		switch {
+		case aDate && bDate:
+			return int64(a) - int64(b), nil
+		case aDate && isNumber(args[1]):
+			return primitive.DateTime(int64(a) - int64(math.Round(toFloat(args[1])))), nil
+		}
+	}
+	var date *primitive.DateTime
+	ints := true
+	for i, v := range args {
+		if d, ok := v.(primitive.DateTime); ok && op == "$add" && date == nil {
+			date = &d
+			args[i] = int64(d)
+			continue
+		}
+		if !isNumber(v) {
+			return nil, fmt.Errorf("store: %s needs numbers, not %s", op, typeName(v))
+		}
+		if isFloat(v) {
+			ints = false
+		}
+	}
+	if op == "$divide" {
+		d := toFloat(args[1])
+		if d == 0 {
+			return nil, fmt.Errorf("store: can't $divide by zero")
+		}
+		return toFloat(args[0]) / d, nil
+	}
+	if ints {
+		var acc int64
+		for i, v := range args {
+			n, _ := toInt64(v)
+			switch {
+			case i == 0:
+				acc = n
+			case op == "$add":
+				acc += n
+			case op == "$subtract":
+				acc -= n
+			case op == "$multiply":
+				acc *= n
+			case op == "$mod":
+				if n == 0 {
+					return nil, fmt.Errorf("store: can't $mod by zero")
+				}
+				acc %= n
+			}
+		}
+		if date != nil {
+			return primitive.DateTime(acc), nil
+		}
+		return number(acc), nil
+	}
+	var acc float64
+	for i, v := range args {
+		f := toFloat(v)
+		switch {
+		case i == 0:
+			acc = f
+		case op == "$add":
+			acc += f
+		case op == "$subtract":
+			acc -= f
+		case op == "$multiply":
+			acc *= f
+		case op == "$mod":
+			acc = math.Mod(acc, f)
+		}
+	}
+	if date != nil {
+		return primitive.DateTime(int64(math.Round(acc))), nil
+	}
+	return acc, nil
+}
+
+func slice(args []interface{}) (interface{}, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, fmt.Errorf("store: $slice takes 2 or 3 arguments")
+	}
+	if isNullish(args[0]) {
+		return nil, nil
+	}
+	a, ok := args[0].(bson.A)
+	if !ok {
+		return nil, fmt.Errorf("store: $slice needs an array")
+	}
+	first, ok := toInt64(args[1])
+	if !ok {
+		return nil, fmt.Errorf("store: $slice needs integer arguments")
+	}
+	n := int64(len(a))
+	var start, count int64
+	if len(args) == 2 {
+		if first >= 0 {
+			start, count = 0, first
+		} else {
+			start, count = n+first, -first
+		}
+	} else {
+		if count, ok = toInt64(args[2]); !ok || count <= 0 {
+			return nil, fmt.Errorf("store: $slice needs a positive count")
+		}
+		start = first
+		if start < 0 {
+			start += n
+		}
+	}
+	start = max(0, min(start, n))
+	end := max(start, min(start+count, n))
+	return append(bson.A{}, a[start:end]...), nil
+}
+
+func (ev *evaluator) dateOperator(op string, arg interface{}) (interface{}, error) {
+	var dateExpr, zoneExpr, formatExpr interface{} = arg, nil, nil
+	if d, ok := arg.(bson.D); ok && len(d) > 0 && !strings.HasPrefix(d[0].Key, "$") {
+		dateExpr, _ = get(d, "date")
+		zoneExpr, _ = get(d, "timezone")
+		formatExpr, _ = get(d, "format")
+	}
+	v, err := ev.eval(dateExpr)
+	if err != nil {
+		return nil, err
+	}
+	if isNullish(v) {
+		return nil, nil
+	}
+	dt, ok := v.(primitive.DateTime)
+	if !ok {
+		return nil, fmt.Errorf("store: %s needs a date, not %s", op, typeName(v))
+	}
+	loc := time.UTC
+	if zoneExpr != nil {
+		z, err := ev.eval(zoneExpr)
+		if err != nil {
+			return nil, err
+		}
+		if loc, err = location(z); err != nil {
+			return nil, err
+		}
+	}
+	t := dt.Time().In(loc)
+	switch op {
+	case "$dayOfWeek":
+		return int32(t.Weekday()) + 1, nil
+	case "$hour":
+		return int32(t.Hour()), nil
+	case "$minute":
+		return int32(t.Minute()), nil
+	case "$year":
+		return int32(t.Year()), nil
+	case "$month":
+		return int32(t.Month()), nil
+	case "$dayOfMonth":
+		return int32(t.Day()), nil
+	}
+	format := "%Y-%m-%dT%H:%M:%S.%LZ"
+	if formatExpr != nil {
+		f, err := ev.eval(formatExpr)
+		if err != nil {
+			return nil, err
+		}
+		if format, ok = f.(string); !ok {
+			return nil, fmt.Errorf("store: $dateToString needs a string format")
+		}
+	}
+	return formatDate(t, format), nil
+}
+
+func location(z interface{}) (*time.Location, error) {
+	name, ok := z.(string)
+	if !ok {
+		return nil, fmt.Errorf("store: timezone must be a string")
+	}
+	if strings.HasPrefix(name, "+") || strings.HasPrefix(name, "-") {
+		t, err := time.Parse("-07:00", name)
+		if err != nil {
+			if t, err = time.Parse("-0700", name); err != nil {
+				return nil, fmt.Errorf("store: bad timezone %q", name)
+			}
+		}
+		_, offset := t.Zone()
+		return time.FixedZone(name, offset), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("store: bad timezone %q", name)
+	}
+	return loc, nil
+}
+
+func formatDate(t time.Time, format string) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 'L':
+			fmt.Fprintf(&b, "%03d", t.Nanosecond()/int(time.Millisecond))
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'w':
+			fmt.Fprintf(&b, "%d", int(t.Weekday())+1)
+		case 'u':
+			wd := int(t.Weekday())
+			if wd == 0 {
+				wd = 7
+			}
+			fmt.Fprintf(&b, "%d", wd)
+		case 'z':
+			b.WriteString(t.Format("-0700"))
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(format[i])
+		}
+	}
+	return b.String()
+}
+
+// accumulator folds values as $group's accumulators and the array forms of
+// $sum, $avg, $max and $min do.
+type accumulator struct {
+	op      string
+	n       int
+	ints    bool
+	isum    int64
+	fsum    float64
+	best    interface{}
+	values  bson.A
+	started bool
+}
+
+func newAccumulator(op string) *accumulator {
+	return &accumulator{op: op, ints: true, best: missing}
+}
+
+func (a *accumulator) add(v interface{}) {
+	switch a.op {
+	case "$sum", "$avg":
+		if !isNumber(v) {
+			return
+		}
+		a.n++
+		if n, ok := toInt64(v); ok && a.ints && !isFloat(v) {
+			a.isum += n
+		} else {
+			if a.ints {
+				a.fsum, a.ints = float64(a.isum), false
+			}
+			a.fsum += toFloat(v)
+		}
+	case "$max", "$min":
+		if isNullish(v) {
+			return
+		}
+		c := compare(v, a.best)
+		if a.best == missing || (a.op == "$max" && c > 0) || (a.op == "$min" && c < 0) {
+			a.best = v
+		}
+	case "$push":
+		a.values = append(a.values, v)
+	case "$addToSet":
+		for _, have := range a.values {
+			if equal(have, v) {
+				return
+			}
+		}
+		a.values = append(a.values, v)
+	case "$first":
+		if !a.started {
+			a.best, a.started = v, true
+		}
+	case "$last":
+		a.best = v
+	case "$count":
+		a.n++
+	}
+}
+
+func (a *accumulator) result() interface{} {
+	switch a.op {
+	case "$sum":
+		if a.ints {
+			return number(a.isum)
+		}
+		return a.fsum
+	case "$avg":
+		if a.n == 0 {
+			return nil
+		}
+		if a.ints {
+			return float64(a.isum) / float64(a.n)
+		}
+		return a.fsum / float64(a.n)
+	case "$push", "$addToSet":
+		if a.values == nil {
+			return bson.A{}
+		}
+		return a.values
+	case "$count":
+		return number(int64(a.n))
+	}
+	if a.best == missing {
+		return nil
+	}
+	return a.best
+}
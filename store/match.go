@@ -0,0 +1,462 @@
+package store
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// matcher applies MongoDB query filters to documents. text is the
+// collection's text index, which $text searches; score is the text score of
+// the document last matched. vars are the variables $expr sees, those of a
+// $lookup's let.
+type matcher struct {
+	text  *textIndex
+	vars  map[string]interface{}
+	score float64
+}
+
+// match reports whether doc satisfies filter, a normalized query document.
+func (m *matcher) match(doc bson.D, filter bson.D) (bool, error) {
+	for _, e := range filter {
+		ok, err := m.matchElem(doc, e)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (m *matcher) matchElem(doc bson.D, e bson.E) (bool, error) {
+	switch e.Key {
+	case "$and", "$or", "$nor":
+		clauses, ok := e.Value.(bson.A)
+		if !ok || len(clauses) == 0 {
+			return false, fmt.Errorf("store: %s needs a non-empty array", e.Key)
+		}
+		for _, c := range clauses {
+			sub, ok := c.(bson.D)
+			if !ok {
+				return false, fmt.Errorf("store: %s entries must be documents", e.Key)
+			}
+			ok, err := m.match(doc, sub)
+			if err != nil {
+				return false, err
+			}
+			switch {
+			case e.Key == "$and" && !ok:
+				return false, nil
+			case e.Key == "$or" && ok:
+				return true, nil
+			case e.Key == "$nor" && ok:
+				return false, nil
+			}
+		}
+		return e.Key != "$or", nil
+	case "$expr":
+		v, err := newEvaluator(doc, m.vars).eval(e.Value)
+		return truthy(v), err
+	case "$text":
+		return m.matchText(doc, e.Value)
+	case "$comment":
+		return true, nil
+	}
+	if strings.HasPrefix(e.Key, "$") {
+		return false, fmt.Errorf("store: unknown query operator %s", e.Key)
+	}
+	vals := resolve(doc, splitPath(e.Key))
+	if ops, ok := operators(e.Value); ok {
+		return matchOps(vals, ops)
+	}
+	return anyValue(vals, func(v interface{}) bool { return matchEq(v, e.Value) }), nil
+}
+
+// operators returns cond as a list of query operators if it is one.
+func operators(cond interface{}) (bson.D, bool) {
+	d, ok := cond.(bson.D)
+	if !ok || len(d) == 0 || !strings.HasPrefix(d[0].Key, "$") {
+		return nil, false
+	}
+	return d, true
+}
+
+// anyValue reports whether pred holds for one of the values a path reached,
+// or for an element of one that is an array.
+func anyValue(vals []interface{}, pred func(interface{}) bool) bool {
+	for _, v := range vals {
+		if pred(v) {
+			return true
+		}
+		if a, ok := v.(bson.A); ok {
+			for _, e := range a {
+				if pred(e) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func matchEq(v, want interface{}) bool {
+	if re, ok := want.(primitive.Regex); ok {
+		if _, isRegex := v.(primitive.Regex); !isRegex {
+			return matchRegex(v, re)
+		}
+	}
+	return equal(v, want)
+}
+
+func matchOps(vals []interface{}, ops bson.D) (bool, error) {
+	for _, op := range ops {
+		var ok bool
+		var err error
+		switch op.Key {
+		case "$options":
+			continue
+		case "$regex":
+			re, rerr := regexOperand(op.Value, ops)
+			if rerr != nil {
+				return false, rerr
+			}
+			ok = anyValue(vals, func(v interface{}) bool { return matchRegex(v, re) })
+		default:
+			ok, err = matchOp(vals, op.Key, op.Value)
+		}
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func matchOp(vals []interface{}, op string, arg interface{}) (bool, error) {
+	switch op {
+	case "$eq":
+		return anyValue(vals, func(v interface{}) bool { return matchEq(v, arg) }), nil
+	case "$ne":
+		return !anyValue(vals, func(v interface{}) bool { return matchEq(v, arg) }), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		return anyValue(vals, func(v interface{}) bool { return compareOp(op, v, arg) }), nil
+	case "$in", "$nin":
+		list, ok := arg.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("store: %s needs an array", op)
+		}
+		in := anyValue(vals, func(v interface{}) bool {
+			for _, want := range list {
+				if matchEq(v, want) {
+					return true
+				}
+			}
+			return false
+		})
+		return in == (op == "$in"), nil
+	case "$exists":
+		exists := false
+		for _, v := range vals {
+			if v != missing {
+				exists = true
+			}
+		}
+		return exists == truthy(arg), nil
+	case "$type":
+		types, ok := arg.(bson.A)
+		if !ok {
+			types = bson.A{arg}
+		}
+		return anyValue(vals, func(v interface{}) bool {
+			for _, t := range types {
+				if hasType(v, t) {
+					return true
+				}
+			}
+			return false
+		}), nil
+	case "$not":
+		if re, ok := arg.(primitive.Regex); ok {
+			return !anyValue(vals, func(v interface{}) bool { return matchRegex(v, re) }), nil
+		}
+		ops, ok := operators(arg)
+		if !ok {
+			return false, fmt.Errorf("store: $not needs operators or a regular expression")
+		}
+		ok, err := matchOps(vals, ops)
+		return !ok, err
+	case "$elemMatch":
+		cond, ok := arg.(bson.D)
+		if !ok {
+			return false, fmt.Errorf("store: $elemMatch needs a document")
+		}
+		return elemMatch(vals, cond)
+	case "$all":
+		list, ok := arg.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("store: $all needs an array")
+		}
+		if len(list) == 0 {
+			return false, nil
+		}
+		for _, want := range list {
+			if !anyValue(vals, func(v interface{}) bool { return matchEq(v, want) }) {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "$size":
+		n, ok := toInt64(arg)
+		if !ok {
+			return false, fmt.Errorf("store: $size needs a number")
+		}
+		for _, v := range vals {
+			if a, ok := v.(bson.A); ok && int64(len(a)) == n {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("store: unknown query operator %s", op)
+}
+
+// compareOp applies a comparison operator. Like MongoDB it only compares
+// values of the same type, so {$lt: date} never matches a missing field.
+func compareOp(op string, v, arg interface{}) bool {
+	if typeRank(v) != typeRank(arg) {
+		return false
+	}
+	c := compare(v, arg)
+	switch op {
+	case "$gt":
+		return c > 0
+	case "$gte":
+		return c >= 0
+	case "$lt":
+		return c < 0
+	}
+	return c <= 0
+}
+
+func hasType(v, t interface{}) bool {
+	name, ok := t.(string)
+	if n, isNum := toInt64(t); isNum {
+		name, ok = typeCodes[n], true
+	}
+	if !ok || v == missing {
+		return false
+	}
+	if name == "number" {
+		return isNumber(v)
+	}
+	return typeName(v) == name
+}
+
+func elemMatch(vals []interface{}, cond bson.D) (bool, error) {
+	ops, isOps := operators(cond)
+	if isOps {
+		switch ops[0].Key {
+		case "$and", "$or", "$nor", "$expr":
+			isOps = false
+		}
+	}
+	var m matcher
+	for _, v := range vals {
+		a, ok := v.(bson.A)
+		if !ok {
+			continue
+		}
+		for _, e := range a {
+			var ok bool
+			var err error
+			if isOps {
+				ok, err = matchOps([]interface{}{e}, ops)
+			} else if d, isDoc := e.(bson.D); isDoc {
+				ok, err = m.match(d, cond)
+			}
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func regexOperand(v interface{}, ops bson.D) (primitive.Regex, error) {
+	re := primitive.Regex{}
+	switch p := v.(type) {
+	case string:
+		re.Pattern = p
+	case primitive.Regex:
+		re = p
+	default:
+		return re, fmt.Errorf("store: $regex needs a string")
+	}
+	if o, ok := get(ops, "$options"); ok {
+		re.Options, _ = o.(string)
+	}
+	return re, nil
+}
+
+func matchRegex(v interface{}, re primitive.Regex) bool {
+	s, ok := v.(string)
+	if !ok {
+		return false
+	}
+	r, err := compileRegex(re)
+	return err == nil && r.MatchString(s)
+}
+
+func compileRegex(re primitive.Regex) (*regexp.Regexp, error) {
+	flags := ""
+	for _, o := range re.Options {
+		if strings.ContainsRune("ims", o) {
+			flags += string(o)
+		}
+	}
+	if flags != "" {
+		flags = "(?" + flags + ")"
+	}
+	return regexp.Compile(flags + re.Pattern)
+}
+
+// textIndex is a collection's text index: the weight of each indexed field.
+type textIndex struct {
+	name    string
+	weights map[string]float64
+}
+
+// matchText applies a $text search. Terms are matched case-insensitively
+// after dropping common English endings and stop words; a document matches
+// if it has any term, every quoted phrase and no negated term. Scores sum
+// each field's weight times its share of matching words plus one half per
+// term found, which ranks like MongoDB without reproducing its exact values.
+func (m *matcher) matchText(doc bson.D, arg interface{}) (bool, error) {
+	if m.text == nil {
+		return false, fmt.Errorf("store: $text needs a text index")
+	}
+	spec, ok := arg.(bson.D)
+	if !ok {
+		return false, fmt.Errorf("store: $text needs a document")
+	}
+	search, _ := get(spec, "$search")
+	q, ok := search.(string)
+	if !ok {
+		return false, fmt.Errorf("store: $text needs a $search string")
+	}
+	terms, negated, phrases := parseSearch(q)
+
+	m.score = 0
+	found := false
+	var text strings.Builder
+	for field, weight := range m.text.weights {
+		for _, v := range resolve(doc, splitPath(field)) {
+			s, ok := v.(string)
+			if !ok {
+				continue
+			}
+			text.WriteString(strings.ToLower(s))
+			text.WriteByte('\n')
+			words := stemWords(s)
+			for _, w := range negated {
+				if words[w] > 0 {
+					return false, nil
+				}
+			}
+			total := 0
+			for _, n := range words {
+				total += n
+			}
+			for _, t := range terms {
+				if n := words[t]; n > 0 {
+					found = true
+					m.score += weight * (float64(n)/float64(total) + 0.5)
+				}
+			}
+		}
+	}
+	for _, p := range phrases {
+		if !strings.Contains(text.String(), p) {
+			return false, nil
+		}
+	}
+	return found, nil
+}
+
+func parseSearch(q string) (terms, negated, phrases []string) {
+	for {
+		start := strings.IndexByte(q, '"')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(q[start+1:], '"')
+		if end < 0 {
+			break
+		}
+		phrase := q[start+1 : start+1+end]
+		phrases = append(phrases, strings.ToLower(phrase))
+		q = q[:start] + " " + phrase + " " + q[start+2+end:]
+	}
+	for _, f := range strings.Fields(q) {
+		neg := strings.HasPrefix(f, "-")
+		for w := range stemWords(f) {
+			if neg {
+				negated = append(negated, w)
+			} else {
+				terms = append(terms, w)
+			}
+		}
+	}
+	return terms, negated, phrases
+}
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true,
+	"by": true, "for": true, "if": true, "in": true, "into": true, "is": true, "it": true, "no": true,
+	"not": true, "of": true, "on": true, "or": true, "such": true, "that": true, "the": true,
+	"their": true, "then": true, "there": true, "these": true, "they": true, "this": true, "to": true,
+	"was": true, "will": true, "with": true,
+}
+
+// stemWords counts the stemmed words of s.
+func stemWords(s string) map[string]int {
+	words := map[string]int{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	}) {
+		w = strings.Trim(w, "'")
+		w = strings.TrimSuffix(w, "'s")
+		if w == "" || stopWords[w] {
+			continue
+		}
+		words[stem(w)]++
+	}
+	return words
+}
+
+// stem drops an English plural or verb ending and then a final e, so that
+// charge, charges and charged all index as charg.
+func stem(w string) string {
+	switch {
+	case strings.HasSuffix(w, "ies") && len(w) > 4:
+		return w[:len(w)-3] + "y"
+	case strings.HasSuffix(w, "ing") && len(w) > 5:
+		w = w[:len(w)-3]
+	case strings.HasSuffix(w, "ed") && len(w) > 4:
+		w = w[:len(w)-2]
+	case strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "ss") && len(w) > 3:
+		w = w[:len(w)-1]
+	}
+	if strings.HasSuffix(w, "e") && len(w) > 3 {
+		w = w[:len(w)-1]
+	}
+	return w
+}
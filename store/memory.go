@@ -0,0 +1,275 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// memoryLogSize is how many changes the in-memory store keeps for change
+// streams to resume from.
+const memoryLogSize = 10000
+
+// Memory implements UserStore, ComplaintStore and Documents in process
+// memory. It is meant for tests and keeps nothing across restarts. A unit
+// of work that writes holds the store exclusively until it ends, so
+// transactions are serializable.
+type Memory struct {
+	*engine
+}
+
+func NewMemory() *Memory {
+	ctx := context.Background()
+	e, err := newEngine(ctx, newMemoryBackend())
+	if err == nil {
+		err = e.ensureStoreIndexes(ctx)
+	}
+	if err != nil {
+		// Nothing in an empty store can fail to index.
+		panic(err)
+	}
+	return &Memory{engine: e}
+}
+
+type memoryBackend struct {
+	mu      sync.RWMutex
+	colls   map[string]*memoryCollection
+	keys    map[[3]string]string
+	indexes []indexDef
+
+	log    []change
+	seq    int64
+	notify chan struct{}
+}
+
+// memoryCollection keeps documents marshaled, so nothing a caller holds
+// aliases what is stored, in insertion order.
+type memoryCollection struct {
+	order []string
+	docs  map[string]bson.Raw
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{
+		colls:  map[string]*memoryCollection{},
+		keys:   map[[3]string]string{},
+		notify: make(chan struct{}),
+	}
+}
+
+func (b *memoryBackend) collection(name string) *memoryCollection {
+	c, ok := b.colls[name]
+	if !ok {
+		c = &memoryCollection{docs: map[string]bson.Raw{}}
+		b.colls[name] = c
+	}
+	return c
+}
+
+func (b *memoryBackend) begin(ctx context.Context, write bool) (txn, error) {
+	if write {
+		b.mu.Lock()
+	} else {
+		b.mu.RLock()
+	}
+	return &memoryTxn{b: b, write: write}, nil
+}
+
+func (b *memoryBackend) loadIndexes(ctx context.Context) ([]indexDef, error) {
+	return nil, nil
+}
+
+func (b *memoryBackend) changes(ctx context.Context, after int64) ([]change, int64, int64, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	oldest := b.seq + 1
+	if len(b.log) > 0 {
+		oldest = b.log[0].seq
+	}
+	var out []change
+	if after < b.seq {
+		from := max(0, int(after-oldest+1))
+		out = append(out, b.log[min(from, len(b.log)):]...)
+	}
+	return out, oldest, b.seq, nil
+}
+
+func (b *memoryBackend) changed() <-chan struct{} {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.notify
+}
+
+func (b *memoryBackend) retryable(err error) bool {
+	return false
+}
+
+// memoryTxn writes in place and undoes its writes on rollback.
+type memoryTxn struct {
+	b      *memoryBackend
+	write  bool
+	undo   []func()
+	events []change
+	done   bool
+}
+
+func (t *memoryTxn) scan(coll string, h hint) ([]bson.D, error) {
+	c, ok := t.b.colls[coll]
+	if !ok {
+		return nil, nil
+	}
+	ids := c.order
+	if h.ids != nil {
+		ids = h.ids
+	}
+	var docs []bson.D
+	for _, id := range ids {
+		raw, ok := c.docs[id]
+		if !ok {
+			continue
+		}
+		d, err := toDoc(raw)
+		if err != nil {
+			return nil, err
+		}
+		if h.org == nil || orgOf(d) == *h.org {
+			docs = append(docs, d)
+		}
+	}
+	return docs, nil
+}
+
+func (t *memoryTxn) lock(coll string, ids []string) ([]bson.D, error) {
+	return t.scan(coll, hint{ids: ids})
+}
+
+func (t *memoryTxn) insert(coll string, doc bson.D) (bool, error) {
+	c := t.b.collection(coll)
+	id := idKey(idOf(doc))
+	if _, taken := c.docs[id]; taken {
+		return false, nil
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return false, err
+	}
+	c.docs[id] = raw
+	c.order = append(c.order, id)
+	t.undo = append(t.undo, func() {
+		delete(c.docs, id)
+		c.order = c.order[:len(c.order)-1]
+	})
+	return true, nil
+}
+
+func (t *memoryTxn) replace(coll string, doc bson.D) error {
+	c := t.b.collection(coll)
+	id := idKey(idOf(doc))
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	old := c.docs[id]
+	c.docs[id] = raw
+	t.undo = append(t.undo, func() { c.docs[id] = old })
+	return nil
+}
+
+func (t *memoryTxn) remove(coll string, id interface{}) error {
+	c := t.b.collection(coll)
+	key := idKey(id)
+	old, ok := c.docs[key]
+	if !ok {
+		return nil
+	}
+	order := c.order
+	delete(c.docs, key)
+	for i, have := range c.order {
+		if have == key {
+			c.order = append(append([]string{}, c.order[:i]...), c.order[i+1:]...)
+			break
+		}
+	}
+	t.undo = append(t.undo, func() {
+		c.docs[key] = old
+		c.order = order
+	})
+	return nil
+}
+
+func (t *memoryTxn) claim(coll, index, key, id string) (string, error) {
+	k := [3]string{coll, index, key}
+	if owner, ok := t.b.keys[k]; ok {
+		return owner, nil
+	}
+	t.b.keys[k] = id
+	t.undo = append(t.undo, func() { delete(t.b.keys, k) })
+	return id, nil
+}
+
+func (t *memoryTxn) release(coll, index, key string) error {
+	k := [3]string{coll, index, key}
+	owner, ok := t.b.keys[k]
+	if !ok {
+		return nil
+	}
+	delete(t.b.keys, k)
+	t.undo = append(t.undo, func() { t.b.keys[k] = owner })
+	return nil
+}
+
+func (t *memoryTxn) saveIndex(def indexDef) error {
+	n := len(t.b.indexes)
+	t.b.indexes = append(t.b.indexes, def)
+	t.undo = append(t.undo, func() { t.b.indexes = t.b.indexes[:n] })
+	return nil
+}
+
+func (t *memoryTxn) record(coll string, event bson.D) {
+	t.events = append(t.events, change{coll: coll, event: event})
+}
+
+func (t *memoryTxn) commit() error {
+	if t.done {
+		return nil
+	}
+	t.done = true
+	if !t.write {
+		t.b.mu.RUnlock()
+		return nil
+	}
+	defer t.b.mu.Unlock()
+	if len(t.events) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	for _, c := range t.events {
+		t.b.seq++
+		c.seq, c.at = t.b.seq, now
+		t.b.log = append(t.b.log, c)
+	}
+	if over := len(t.b.log) - memoryLogSize; over > 0 {
+		t.b.log = append([]change{}, t.b.log[over:]...)
+	}
+	close(t.b.notify)
+	t.b.notify = make(chan struct{})
+	return nil
+}
+
+func (t *memoryTxn) rollback() error {
+	if t.done {
+		return nil
+	}
+	t.done = true
+	if !t.write {
+		t.b.mu.RUnlock()
+		return nil
+	}
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		t.undo[i]()
+	}
+	t.b.mu.Unlock()
+	return nil
+}
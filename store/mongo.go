@@ -0,0 +1,336 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+
+	"complain/models"
+)
+
+// Mongo implements UserStore, ComplaintStore and Documents on a MongoDB
+// database.
+type Mongo struct {
+	client *mongo.Client
+	db     *mongo.Database
+}
+
+func NewMongo(client *mongo.Client, db *mongo.Database) *Mongo {
+	return &Mongo{client: client, db: db}
+}
+
+// scoped returns a collection of organization-owned documents confined to
+// the organization of each call's context.
+func (m *Mongo) scoped(name string) Scoped {
+	return Scope(m.db.Collection(name))
+}
+
+// Collection returns one of the database's collections, users and
+// complaints scoped as Scoped does.
+func (m *Mongo) Collection(name string) Collection {
+	if orgScoped(name) {
+		return m.scoped(name)
+	}
+	return mongoCollection{m.db.Collection(name)}
+}
+
+func (m *Mongo) OrgCollection(name string) Collection {
+	return m.scoped(name)
+}
+
+// mongoCollection is a collection that is not organization-owned.
+type mongoCollection struct {
+	*mongo.Collection
+}
+
+func (c mongoCollection) Watch(ctx context.Context, pipeline interface{}, opts ...*options.ChangeStreamOptions) (ChangeStream, error) {
+	return watch(c.Collection, ctx, pipeline, opts)
+}
+
+// InTransaction runs fn in a transaction, or in the caller's if ctx already
+// carries one. fn is retried on transient errors. A standalone mongod
+// rejects transactions, so there fn runs again without one.
+func (m *Mongo) InTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+	session, err := m.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(context.Background())
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	if TransactionsUnsupported(err) {
+		return fn(ctx)
+	}
+	return err
+}
+
+func (m *Mongo) EnsureIndex(ctx context.Context, name string, model mongo.IndexModel) error {
+	_, err := m.db.Collection(name).Indexes().CreateOne(ctx, model)
+	return err
+}
+
+func (m *Mongo) Indexes(ctx context.Context, name string) ([]string, error) {
+	cursor, err := m.db.Collection(name).Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var indexes []struct {
+		Name string `bson:"name"`
+	}
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(indexes))
+	for i, index := range indexes {
+		names[i] = index.Name
+	}
+	return names, nil
+}
+
+func notFound(err error) error {
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (m *Mongo) CreateUser(ctx context.Context, user models.User) error {
+	stampCreated(&user.CreatedAt, &user.UpdatedAt)
+	_, err := m.scoped("users").InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (m *Mongo) UserBySecretCodeHash(ctx context.Context, hash string) (models.User, error) {
+	var user models.User
+	err := m.scoped("users").FindOne(ctx, bson.M{"secretCodeHash": hash}).Decode(&user)
+	return user, notFound(err)
+}
+
+func (m *Mongo) SetSecretCodeHash(ctx context.Context, id primitive.ObjectID, hash string) error {
+	res, err := m.scoped("users").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"secretCodeHash": hash}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) UserByID(ctx context.Context, id primitive.ObjectID) (models.User, error) {
+	var user models.User
+	err := m.scoped("users").FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+	return user, notFound(err)
+}
+
+// VersionFilter matches documents at the given version. Documents written
+// before versioning was introduced have no version field and count as 0.
+func VersionFilter(version int) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return version
+}
+
+func (m *Mongo) UpdateProfile(ctx context.Context, id primitive.ObjectID, version int, update ProfileUpdate) (models.User, error) {
+	var user models.User
+	err := m.scoped("users").FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "version": VersionFilter(version)},
+		bson.M{
+			"$set": bson.M{"name": update.Name, "email": update.Email, "locale": update.Locale},
+			"$inc": bson.M{"version": 1},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if mongo.IsDuplicateKeyError(err) {
+		return user, ErrDuplicate
+	}
+	if err == mongo.ErrNoDocuments {
+		if m.scoped("users").FindOne(ctx, bson.M{"_id": id}).Err() == nil {
+			return user, ErrVersionConflict
+		}
+		return user, ErrNotFound
+	}
+	return user, err
+}
+
+// CreateComplaint runs the insert and the user link in a transaction, or in
+// the caller's transaction if ctx carries one. A standalone mongod rejects
+// transactions, so there it falls back to plain writes and deletes the
+// complaint again if linking fails.
+func (m *Mongo) CreateComplaint(ctx context.Context, complaint models.Complaint) (models.User, error) {
+	stampCreated(&complaint.CreatedAt, &complaint.UpdatedAt)
+	if mongo.SessionFromContext(ctx) != nil {
+		user, err := m.saveComplaint(ctx, complaint)
+		if mongo.IsDuplicateKeyError(err) {
+			return user, ErrDuplicate
+		}
+		return user, err
+	}
+	session, err := m.client.StartSession()
+	if err != nil {
+		return models.User{}, err
+	}
+	defer session.EndSession(context.Background())
+
+	// WithTransaction aborts on any error from the callback and retries the
+	// whole unit on transient errors and unknown commit results, so a
+	// failover mid-submit neither loses nor doubles the complaint.
+	opts := options.Transaction().SetWriteConcern(writeconcern.Majority())
+	result, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return m.saveComplaint(sc, complaint)
+	}, opts)
+	user, _ := result.(models.User)
+	if TransactionsUnsupported(err) {
+		user, err = m.saveComplaintCompensated(ctx, complaint)
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return user, ErrDuplicate
+	}
+	return user, err
+}
+
+// saveComplaintCompensated is the fallback for a standalone mongod, which has
+// no transactions: if linking the complaint to its user fails, the inserted
+// complaint is deleted again rather than left orphaned.
+func (m *Mongo) saveComplaintCompensated(ctx context.Context, complaint models.Complaint) (models.User, error) {
+	user, err := m.saveComplaint(ctx, complaint)
+	if err == nil || mongo.IsDuplicateKeyError(err) {
+		return user, err
+	}
+	if _, derr := m.scoped("complaints").DeleteOne(context.WithoutCancel(ctx), bson.M{"_id": complaint.ID}); derr != nil {
+		log.Printf("roll back complaint %s: %v", complaint.ID.Hex(), derr)
+		return user, fmt.Errorf("%w (and the complaint could not be rolled back: %v)", err, derr)
+	}
+	return user, err
+}
+
+// saveComplaint inserts the complaint and links it to its user. When ctx is a
+// session context both writes happen inside the caller's transaction. The link
+// is an atomic $push so concurrent submissions by one user cannot drop each
+// other's references.
+func (m *Mongo) saveComplaint(ctx context.Context, complaint models.Complaint) (models.User, error) {
+	var user models.User
+	if _, err := m.scoped("complaints").InsertOne(ctx, complaint); err != nil {
+		return user, err
+	}
+
+	err := m.scoped("users").FindOneAndUpdate(ctx,
+		bson.M{"_id": complaint.UserID},
+		bson.M{"$push": bson.M{"complaints": complaint.ID}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	return user, notFound(err)
+}
+
+// TransactionsUnsupported reports whether err came from a standalone mongod,
+// which rejects multi-document transactions.
+func TransactionsUnsupported(err error) bool {
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) {
+		return false
+	}
+	return cmdErr.HasErrorCode(20) || strings.Contains(cmdErr.Message, "Transaction numbers are only allowed")
+}
+
+func (m *Mongo) Complaint(ctx context.Context, id primitive.ObjectID) (models.Complaint, error) {
+	var complaint models.Complaint
+	err := m.scoped("complaints").FindOne(ctx, bson.M{"_id": id}).Decode(&complaint)
+	return complaint, notFound(err)
+}
+
+func (m *Mongo) findComplaints(ctx context.Context, filter bson.M) ([]models.Complaint, error) {
+	cursor, err := m.scoped("complaints").Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	complaints := []models.Complaint{}
+	if err := cursor.All(ctx, &complaints); err != nil {
+		return nil, err
+	}
+	return complaints, nil
+}
+
+func (m *Mongo) Complaints(ctx context.Context, filter ComplaintFilter) ([]models.Complaint, error) {
+	return m.findComplaints(ctx, filter.BSON())
+}
+
+func (m *Mongo) ComplaintsSince(ctx context.Context, userID primitive.ObjectID, since time.Time) ([]models.Complaint, error) {
+	return m.findComplaints(ctx, bson.M{"userId": userID, "createdAt": bson.M{"$gte": since}})
+}
+
+func (m *Mongo) ComplaintByIdempotencyKey(ctx context.Context, userID primitive.ObjectID, key string) (models.Complaint, error) {
+	var complaint models.Complaint
+	err := m.scoped("complaints").FindOne(ctx, bson.M{"userId": userID, "idempotencyKey": key}).Decode(&complaint)
+	return complaint, notFound(err)
+}
+
+func (m *Mongo) ReleaseIdempotencyKey(ctx context.Context, userID primitive.ObjectID, key string, before time.Time) error {
+	_, err := m.scoped("complaints").UpdateMany(ctx,
+		bson.M{"userId": userID, "idempotencyKey": key, "createdAt": bson.M{"$lt": before}},
+		bson.M{"$unset": bson.M{"idempotencyKey": "", "idempotentResponse": ""}})
+	return err
+}
+
+func (m *Mongo) CountComplaints(ctx context.Context, userID primitive.ObjectID) (int64, int64, error) {
+	total, err := m.scoped("complaints").CountDocuments(ctx, bson.M{"userId": userID, "deletedAt": NotDeleted()})
+	if err != nil {
+		return 0, 0, err
+	}
+	resolved, err := m.scoped("complaints").CountDocuments(ctx, bson.M{"userId": userID, "resolved": true, "deletedAt": NotDeleted()})
+	return total, resolved, err
+}
+
+func (m *Mongo) DeleteComplaint(ctx context.Context, id primitive.ObjectID, deletedBy string) (models.Complaint, error) {
+	var complaint models.Complaint
+	err := m.scoped("complaints").FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "deletedAt": NotDeleted()},
+		bson.M{"$set": bson.M{"deletedAt": time.Now().UTC(), "deletedBy": deletedBy}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&complaint)
+	return complaint, notFound(err)
+}
+
+func (m *Mongo) RestoreComplaint(ctx context.Context, id primitive.ObjectID) (models.Complaint, error) {
+	var complaint models.Complaint
+	err := m.scoped("complaints").FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "deletedAt": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"deletedAt": "", "deletedBy": ""}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&complaint)
+	return complaint, notFound(err)
+}
+
+func (m *Mongo) NextSequence(ctx context.Context, name string) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := m.db.Collection("counters").FindOneAndUpdate(ctx,
+		bson.M{"_id": name},
+		bson.M{"$inc": bson.M{"seq": int64(1)}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	return counter.Seq, err
+}
+
+var (
+	_ Documents = (*Mongo)(nil)
+	_ Documents = (*Memory)(nil)
+	_ Documents = (*Postgres)(nil)
+)
@@ -0,0 +1,591 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// engineCollection is a collection of an engine, taking filters and updates
+// as they are passed to the mongo driver.
+type engineCollection struct {
+	e    *engine
+	name string
+}
+
+func (c engineCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	o := options.MergeFindOptions(opts...)
+	f, err := asDoc(filter, "filter")
+	if err != nil {
+		return nil, err
+	}
+	h := hintOf(f)
+	if o.Limit != nil && *o.Limit != 0 {
+		h.limit = max(*o.Limit, -*o.Limit)
+		if o.Skip != nil && *o.Skip > 0 {
+			h.limit += *o.Skip
+		}
+		if o.Sort != nil {
+			if h.sort, err = asDoc(o.Sort, "sort"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var docs []bson.D
+	err = c.e.run(ctx, false, func(t txn) error {
+		rows, err := c.e.queryHinted(t, c.name, f, h)
+		if err != nil {
+			return err
+		}
+		docs, err = shape(rows, o.Sort, o.Projection, o.Skip, o.Limit)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cursorOf(docs)
+}
+
+// shape sorts, pages and projects query results as find options ask.
+func shape(rows []row, sortSpec, projection interface{}, skip, limit *int64) ([]bson.D, error) {
+	if sortSpec != nil {
+		spec, err := asDoc(sortSpec, "sort")
+		if err != nil {
+			return nil, err
+		}
+		if err := sortRows(rows, spec); err != nil {
+			return nil, err
+		}
+	}
+	if skip != nil && *skip > 0 {
+		rows = rows[min(int(*skip), len(rows)):]
+	}
+	if limit != nil && *limit != 0 {
+		n := *limit
+		if n < 0 {
+			n = -n
+		}
+		rows = rows[:min(int(n), len(rows))]
+	}
+	var spec bson.D
+	if projection != nil {
+		var err error
+		if spec, err = asDoc(projection, "projection"); err != nil {
+			return nil, err
+		}
+	}
+	docs := make([]bson.D, len(rows))
+	for i, r := range rows {
+		docs[i] = r.doc
+		if len(spec) > 0 {
+			var err error
+			if docs[i], err = project(r.doc, spec, newEvaluator(r.doc, nil), r.score); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return docs, nil
+}
+
+func (c engineCollection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	o := options.MergeFindOneOptions(opts...)
+	one := int64(1)
+	cursor, err := c.Find(ctx, filter, &options.FindOptions{Sort: o.Sort, Projection: o.Projection, Skip: o.Skip, Limit: &one})
+	return first(ctx, cursor, err)
+}
+
+func first(ctx context.Context, cursor *mongo.Cursor, err error) *mongo.SingleResult {
+	if err != nil {
+		return errorResult(err)
+	}
+	docs, err := readAll(ctx, cursor)
+	if err != nil || len(docs) == 0 {
+		return singleResult(nil, err)
+	}
+	return singleResult(docs[0], nil)
+}
+
+func (c engineCollection) FindOneAndUpdate(ctx context.Context, filter, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	o := options.MergeFindOneAndUpdateOptions(opts...)
+	f, err := asDoc(filter, "filter")
+	if err != nil {
+		return errorResult(err)
+	}
+	var arrayFilters []interface{}
+	if o.ArrayFilters != nil {
+		arrayFilters = o.ArrayFilters.Filters
+	}
+	up, err := newUpdate(update, arrayFilters)
+	if err != nil {
+		return errorResult(err)
+	}
+	var sortSpec bson.D
+	if o.Sort != nil {
+		if sortSpec, err = asDoc(o.Sort, "sort"); err != nil {
+			return errorResult(err)
+		}
+	}
+	var res updateResult
+	err = c.e.run(ctx, true, func(t txn) error {
+		res, err = c.e.update(t, c.name, f, up, false, o.Upsert != nil && *o.Upsert, sortSpec)
+		return err
+	})
+	if err != nil {
+		return errorResult(err)
+	}
+	doc := res.before
+	if o.ReturnDocument != nil && *o.ReturnDocument == options.After {
+		doc = res.after
+	}
+	if doc == nil {
+		return errorResult(mongo.ErrNoDocuments)
+	}
+	if o.Projection != nil {
+		docs, err := shape([]row{{doc: doc}}, nil, o.Projection, nil, nil)
+		if err != nil {
+			return errorResult(err)
+		}
+		doc = docs[0]
+	}
+	return singleResult(doc, nil)
+}
+
+func (c engineCollection) FindOneAndDelete(ctx context.Context, filter interface{}, opts ...*options.FindOneAndDeleteOptions) *mongo.SingleResult {
+	o := options.MergeFindOneAndDeleteOptions(opts...)
+	f, err := asDoc(filter, "filter")
+	if err != nil {
+		return errorResult(err)
+	}
+	var sortSpec bson.D
+	if o.Sort != nil {
+		if sortSpec, err = asDoc(o.Sort, "sort"); err != nil {
+			return errorResult(err)
+		}
+	}
+	var deleted []bson.D
+	err = c.e.run(ctx, true, func(t txn) error {
+		deleted, err = c.e.delete(t, c.name, f, false, sortSpec)
+		return err
+	})
+	if err != nil || len(deleted) == 0 {
+		return singleResult(nil, err)
+	}
+	docs, err := shape([]row{{doc: deleted[0]}}, nil, o.Projection, nil, nil)
+	if err != nil {
+		return errorResult(err)
+	}
+	return singleResult(docs[0], nil)
+}
+
+func (c engineCollection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	var id interface{}
+	err := c.e.run(ctx, true, func(t txn) error {
+		var err error
+		id, err = c.e.insert(t, c.name, document)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &mongo.InsertOneResult{InsertedID: id}, nil
+}
+
+// InsertMany inserts in order and, unless told the order does not matter,
+// stops at the first failure, keeping what was inserted before it.
+func (c engineCollection) InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	o := options.MergeInsertManyOptions(opts...)
+	ordered := o.Ordered == nil || *o.Ordered
+	res := &mongo.InsertManyResult{}
+	var failed mongo.WriteErrors
+	err := c.e.run(ctx, true, func(t txn) error {
+		for i, d := range documents {
+			id, err := c.e.insert(t, c.name, d)
+			if err == nil {
+				res.InsertedIDs = append(res.InsertedIDs, id)
+				continue
+			}
+			we, ok := asWriteError(err, i)
+			if !ok {
+				return err
+			}
+			failed = append(failed, we)
+			if ordered {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(failed) > 0 {
+		bulk := mongo.BulkWriteException{}
+		for _, we := range failed {
+			bulk.WriteErrors = append(bulk.WriteErrors, mongo.BulkWriteError{WriteError: we})
+		}
+		return res, bulk
+	}
+	return res, nil
+}
+
+// asWriteError turns a duplicate key error on the i-th write of a batch
+// into the write error MongoDB reports for it.
+func asWriteError(err error, i int) (mongo.WriteError, bool) {
+	we, ok := err.(mongo.WriteException)
+	if !ok || len(we.WriteErrors) == 0 {
+		return mongo.WriteError{}, false
+	}
+	e := we.WriteErrors[0]
+	e.Index = i
+	return e, true
+}
+
+func (c engineCollection) UpdateOne(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.updateMatching(ctx, filter, update, false, opts)
+}
+
+func (c engineCollection) UpdateMany(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.updateMatching(ctx, filter, update, true, opts)
+}
+
+func (c engineCollection) updateMatching(ctx context.Context, filter, update interface{}, many bool, opts []*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	o := options.MergeUpdateOptions(opts...)
+	var arrayFilters []interface{}
+	if o.ArrayFilters != nil {
+		arrayFilters = o.ArrayFilters.Filters
+	}
+	var res updateResult
+	err := c.e.run(ctx, true, func(t txn) error {
+		var err error
+		res, err = c.e.updateWith(t, c.name, filter, update, arrayFilters, many, o.Upsert != nil && *o.Upsert)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updateResultOf(res), nil
+}
+
+func (c engineCollection) ReplaceOne(ctx context.Context, filter, replacement interface{}, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error) {
+	o := options.MergeReplaceOptions(opts...)
+	var res updateResult
+	err := c.e.run(ctx, true, func(t txn) error {
+		var err error
+		res, err = c.e.replace(t, c.name, filter, replacement, o.Upsert != nil && *o.Upsert)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updateResultOf(res), nil
+}
+
+func (e *engine) updateWith(t txn, coll string, filter, update interface{}, arrayFilters []interface{}, many, upsert bool) (updateResult, error) {
+	f, err := asDoc(filter, "filter")
+	if err != nil {
+		return updateResult{}, err
+	}
+	up, err := newUpdate(update, arrayFilters)
+	if err != nil {
+		return updateResult{}, err
+	}
+	return e.update(t, coll, f, up, many, upsert, nil)
+}
+
+func updateResultOf(res updateResult) *mongo.UpdateResult {
+	out := &mongo.UpdateResult{MatchedCount: res.matched, ModifiedCount: res.modified, UpsertedID: res.upsertedID}
+	if res.upsertedID != nil {
+		out.UpsertedCount = 1
+	}
+	return out
+}
+
+func (c engineCollection) DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return c.deleteMatching(ctx, filter, false)
+}
+
+func (c engineCollection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return c.deleteMatching(ctx, filter, true)
+}
+
+func (c engineCollection) deleteMatching(ctx context.Context, filter interface{}, many bool) (*mongo.DeleteResult, error) {
+	f, err := asDoc(filter, "filter")
+	if err != nil {
+		return nil, err
+	}
+	var deleted []bson.D
+	err = c.e.run(ctx, true, func(t txn) error {
+		deleted, err = c.e.delete(t, c.name, f, many, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &mongo.DeleteResult{DeletedCount: int64(len(deleted))}, nil
+}
+
+// BulkWrite applies the writes in one unit of work, stopping at the first
+// failure unless the order does not matter.
+func (c engineCollection) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	o := options.MergeBulkWriteOptions(opts...)
+	ordered := o.Ordered == nil || *o.Ordered
+	res := &mongo.BulkWriteResult{UpsertedIDs: map[int64]interface{}{}}
+	var failed []mongo.BulkWriteError
+	err := c.e.run(ctx, true, func(t txn) error {
+		for i, m := range models {
+			err := c.e.bulkWrite(t, c.name, m, int64(i), res)
+			if err == nil {
+				continue
+			}
+			we, ok := asWriteError(err, i)
+			if !ok {
+				return err
+			}
+			failed = append(failed, mongo.BulkWriteError{WriteError: we, Request: m})
+			if ordered {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(failed) > 0 {
+		return res, mongo.BulkWriteException{WriteErrors: failed}
+	}
+	return res, nil
+}
+
+func (e *engine) bulkWrite(t txn, coll string, model mongo.WriteModel, i int64, res *mongo.BulkWriteResult) error {
+	upsert := func(b *bool) bool { return b != nil && *b }
+	filters := func(af *options.ArrayFilters) []interface{} {
+		if af == nil {
+			return nil
+		}
+		return af.Filters
+	}
+	var u updateResult
+	var err error
+	switch m := model.(type) {
+	case *mongo.InsertOneModel:
+		if _, err = e.insert(t, coll, m.Document); err == nil {
+			res.InsertedCount++
+		}
+		return err
+	case *mongo.UpdateOneModel:
+		u, err = e.updateWith(t, coll, m.Filter, m.Update, filters(m.ArrayFilters), false, upsert(m.Upsert))
+	case *mongo.UpdateManyModel:
+		u, err = e.updateWith(t, coll, m.Filter, m.Update, filters(m.ArrayFilters), true, upsert(m.Upsert))
+	case *mongo.ReplaceOneModel:
+		u, err = e.replace(t, coll, m.Filter, m.Replacement, upsert(m.Upsert))
+	case *mongo.DeleteOneModel, *mongo.DeleteManyModel:
+		var filter interface{}
+		many := false
+		if d, ok := m.(*mongo.DeleteOneModel); ok {
+			filter = d.Filter
+		} else {
+			filter, many = m.(*mongo.DeleteManyModel).Filter, true
+		}
+		f, err := asDoc(filter, "filter")
+		if err != nil {
+			return err
+		}
+		deleted, err := e.delete(t, coll, f, many, nil)
+		res.DeletedCount += int64(len(deleted))
+		return err
+	default:
+		return fmt.Errorf("store: unsupported write model %T", model)
+	}
+	if err != nil {
+		return err
+	}
+	res.MatchedCount += u.matched
+	res.ModifiedCount += u.modified
+	if u.upsertedID != nil {
+		res.UpsertedCount++
+		res.UpsertedIDs[i] = u.upsertedID
+	}
+	return nil
+}
+
+// replace swaps the first document matching filter for replacement,
+// keeping its _id.
+func (e *engine) replace(t txn, coll string, filter, replacement interface{}, upsert bool) (updateResult, error) {
+	f, err := asDoc(filter, "filter")
+	if err != nil {
+		return updateResult{}, err
+	}
+	doc, err := toDoc(replacement)
+	if err != nil {
+		return updateResult{}, err
+	}
+	up := &update{pipeline: []bson.D{{{Key: "$replaceWith", Value: bson.D{{Key: "$literal", Value: doc}}}}}}
+	return e.update(t, coll, f, up, false, upsert, nil)
+}
+
+func (c engineCollection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	o := options.MergeCountOptions(opts...)
+	f, err := asDoc(filter, "filter")
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	err = c.e.run(ctx, false, func(t txn) error {
+		rows, err := c.e.query(t, c.name, f)
+		n = int64(len(rows))
+		return err
+	})
+	if o.Skip != nil {
+		n = max(0, n-*o.Skip)
+	}
+	if o.Limit != nil && *o.Limit > 0 {
+		n = min(n, *o.Limit)
+	}
+	return n, err
+}
+
+func (c engineCollection) Distinct(ctx context.Context, field string, filter interface{}, opts ...*options.DistinctOptions) ([]interface{}, error) {
+	f, err := asDoc(filter, "filter")
+	if err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	err = c.e.run(ctx, false, func(t txn) error {
+		rows, err := c.e.query(t, c.name, f)
+		if err != nil {
+			return err
+		}
+		seen := map[string]bool{}
+		for _, r := range rows {
+			for _, v := range resolve(r.doc, splitPath(field)) {
+				list, ok := v.(bson.A)
+				if !ok {
+					list = bson.A{v}
+				}
+				for _, e := range list {
+					if e == missing {
+						continue
+					}
+					if k := groupKey(e); !seen[k] {
+						seen[k] = true
+						values = append(values, e)
+					}
+				}
+			}
+		}
+		return nil
+	})
+	return values, err
+}
+
+func (c engineCollection) Aggregate(ctx context.Context, spec interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	st, err := stages(spec)
+	if err != nil {
+		return nil, err
+	}
+	var docs []bson.D
+	err = c.e.run(ctx, false, func(t txn) error {
+		var filter bson.D
+		if len(st) > 0 && st[0][0].Key == "$match" {
+			filter, _ = st[0][0].Value.(bson.D)
+		}
+		if docs, err = t.scan(c.name, hintOf(filter)); err != nil {
+			return err
+		}
+		// $lookup reads through the same unit of work.
+		p := &pipeline{ctx: context.WithValue(ctx, txnKey{c.e}, t), text: c.e.textIndex(c.name), from: c.e.Collection}
+		docs, err = p.run(docs, st)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cursorOf(docs)
+}
+
+func (c engineCollection) Watch(ctx context.Context, pipeline interface{}, opts ...*options.ChangeStreamOptions) (ChangeStream, error) {
+	return c.e.watch(ctx, c.name, pipeline, options.MergeChangeStreamOptions(opts...))
+}
+
+// scopedCollection confines an engine collection to the organization of
+// each call's context and stamps updates, as Scoped does.
+type scopedCollection struct {
+	c engineCollection
+}
+
+func (s scopedCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	return s.c.Find(ctx, scopeFilter(ctx, "orgId", filter), opts...)
+}
+
+func (s scopedCollection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	return s.c.FindOne(ctx, scopeFilter(ctx, "orgId", filter), opts...)
+}
+
+func (s scopedCollection) FindOneAndUpdate(ctx context.Context, filter, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	return s.c.FindOneAndUpdate(ctx, scopeFilter(ctx, "orgId", filter), stampUpdate(update), opts...)
+}
+
+func (s scopedCollection) FindOneAndDelete(ctx context.Context, filter interface{}, opts ...*options.FindOneAndDeleteOptions) *mongo.SingleResult {
+	return s.c.FindOneAndDelete(ctx, scopeFilter(ctx, "orgId", filter), opts...)
+}
+
+func (s scopedCollection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	return s.c.InsertOne(ctx, document, opts...)
+}
+
+func (s scopedCollection) InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	return s.c.InsertMany(ctx, documents, opts...)
+}
+
+func (s scopedCollection) UpdateOne(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return s.c.UpdateOne(ctx, scopeFilter(ctx, "orgId", filter), stampUpdate(update), opts...)
+}
+
+func (s scopedCollection) ReplaceOne(ctx context.Context, filter, replacement interface{}, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error) {
+	return s.c.ReplaceOne(ctx, scopeFilter(ctx, "orgId", filter), replacement, opts...)
+}
+
+func (s scopedCollection) UpdateMany(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return s.c.UpdateMany(ctx, scopeFilter(ctx, "orgId", filter), stampUpdate(update), opts...)
+}
+
+func (s scopedCollection) DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return s.c.DeleteOne(ctx, scopeFilter(ctx, "orgId", filter), opts...)
+}
+
+func (s scopedCollection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return s.c.DeleteMany(ctx, scopeFilter(ctx, "orgId", filter), opts...)
+}
+
+func (s scopedCollection) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	scope := func(filter interface{}) interface{} { return scopeFilter(ctx, "orgId", filter) }
+	return s.c.BulkWrite(ctx, stampWrites(scope, models), opts...)
+}
+
+func (s scopedCollection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	return s.c.CountDocuments(ctx, scopeFilter(ctx, "orgId", filter), opts...)
+}
+
+func (s scopedCollection) Distinct(ctx context.Context, field string, filter interface{}, opts ...*options.DistinctOptions) ([]interface{}, error) {
+	return s.c.Distinct(ctx, field, scopeFilter(ctx, "orgId", filter), opts...)
+}
+
+func (s scopedCollection) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	scoped, err := scopePipeline(ctx, "orgId", pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return s.c.Aggregate(ctx, scoped, opts...)
+}
+
+func (s scopedCollection) Watch(ctx context.Context, pipeline interface{}, opts ...*options.ChangeStreamOptions) (ChangeStream, error) {
+	scoped, err := scopePipeline(ctx, "fullDocument.orgId", pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return s.c.Watch(ctx, scoped, opts...)
+}
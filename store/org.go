@@ -0,0 +1,179 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type orgKey struct{}
+
+// WithOrg confines the users and complaints reached through ctx to one
+// organization. The empty organization holds the documents that belong to
+// none, which includes everything written before organizations existed.
+func WithOrg(ctx context.Context, org string) context.Context {
+	return context.WithValue(ctx, orgKey{}, org)
+}
+
+// Unscoped lifts ctx's organization, if any, for work that spans every
+// organization.
+func Unscoped(ctx context.Context) context.Context {
+	return context.WithValue(ctx, orgKey{}, nil)
+}
+
+// OrgFrom returns the organization ctx is confined to. ok is false for an
+// unscoped context, which sees every organization.
+func OrgFrom(ctx context.Context) (org string, ok bool) {
+	org, ok = ctx.Value(orgKey{}).(string)
+	return org, ok
+}
+
+// InOrg reports whether a document belonging to org is visible through ctx.
+func InOrg(ctx context.Context, org string) bool {
+	scope, ok := OrgFrom(ctx)
+	return !ok || scope == org
+}
+
+func orgMatch(org string) interface{} {
+	if org == "" {
+		return bson.M{"$in": bson.A{nil, ""}}
+	}
+	return org
+}
+
+// scopeFilter adds ctx's organization to a query filter. The caller's filter
+// is kept whole under $and so its own $or and orgId conditions still apply.
+func scopeFilter(ctx context.Context, field string, filter interface{}) interface{} {
+	org, ok := OrgFrom(ctx)
+	if !ok {
+		return filter
+	}
+	if filter == nil {
+		filter = bson.M{}
+	}
+	return bson.M{"$and": bson.A{filter, bson.M{field: orgMatch(org)}}}
+}
+
+// scopePipeline puts a $match on ctx's organization in front of an
+// aggregation pipeline.
+func scopePipeline(ctx context.Context, field string, pipeline interface{}) (interface{}, error) {
+	org, ok := OrgFrom(ctx)
+	if !ok {
+		return pipeline, nil
+	}
+	match := bson.D{{Key: "$match", Value: bson.M{field: orgMatch(org)}}}
+	switch p := pipeline.(type) {
+	case nil:
+		return mongo.Pipeline{match}, nil
+	case mongo.Pipeline:
+		return append(mongo.Pipeline{match}, p...), nil
+	case bson.A:
+		return append(bson.A{match}, p...), nil
+	case []bson.M:
+		return append(bson.A{match}, toA(p)...), nil
+	case []bson.D:
+		return append(mongo.Pipeline{match}, p...), nil
+	}
+	return nil, fmt.Errorf("store: cannot scope a pipeline of type %T", pipeline)
+}
+
+func toA(stages []bson.M) bson.A {
+	a := make(bson.A, len(stages))
+	for i, s := range stages {
+		a[i] = s
+	}
+	return a
+}
+
+// Scoped wraps a collection of organization-owned documents, users or
+// complaints, so that every read and update made through it is confined to
+// the organization of its context, and every update stamps updatedAt.
+// Unscoped contexts see everything. Methods not overridden here pass
+// straight through; inserts carry their own orgId and timestamps.
+type Scoped struct {
+	*mongo.Collection
+}
+
+func Scope(c *mongo.Collection) Scoped {
+	return Scoped{Collection: c}
+}
+
+func (s Scoped) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	return s.Collection.Find(ctx, scopeFilter(ctx, "orgId", filter), opts...)
+}
+
+func (s Scoped) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	return s.Collection.FindOne(ctx, scopeFilter(ctx, "orgId", filter), opts...)
+}
+
+func (s Scoped) FindOneAndUpdate(ctx context.Context, filter, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	return s.Collection.FindOneAndUpdate(ctx, scopeFilter(ctx, "orgId", filter), stampUpdate(update), opts...)
+}
+
+func (s Scoped) FindOneAndDelete(ctx context.Context, filter interface{}, opts ...*options.FindOneAndDeleteOptions) *mongo.SingleResult {
+	return s.Collection.FindOneAndDelete(ctx, scopeFilter(ctx, "orgId", filter), opts...)
+}
+
+func (s Scoped) UpdateOne(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return s.Collection.UpdateOne(ctx, scopeFilter(ctx, "orgId", filter), stampUpdate(update), opts...)
+}
+
+func (s Scoped) ReplaceOne(ctx context.Context, filter, replacement interface{}, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error) {
+	return s.Collection.ReplaceOne(ctx, scopeFilter(ctx, "orgId", filter), replacement, opts...)
+}
+
+func (s Scoped) UpdateMany(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return s.Collection.UpdateMany(ctx, scopeFilter(ctx, "orgId", filter), stampUpdate(update), opts...)
+}
+
+func (s Scoped) DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return s.Collection.DeleteOne(ctx, scopeFilter(ctx, "orgId", filter), opts...)
+}
+
+func (s Scoped) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return s.Collection.DeleteMany(ctx, scopeFilter(ctx, "orgId", filter), opts...)
+}
+
+func (s Scoped) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	scope := func(filter interface{}) interface{} { return scopeFilter(ctx, "orgId", filter) }
+	return s.Collection.BulkWrite(ctx, stampWrites(scope, models), opts...)
+}
+
+func (s Scoped) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	return s.Collection.CountDocuments(ctx, scopeFilter(ctx, "orgId", filter), opts...)
+}
+
+func (s Scoped) Distinct(ctx context.Context, field string, filter interface{}, opts ...*options.DistinctOptions) ([]interface{}, error) {
+	return s.Collection.Distinct(ctx, field, scopeFilter(ctx, "orgId", filter), opts...)
+}
+
+func (s Scoped) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	scoped, err := scopePipeline(ctx, "orgId", pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return s.Collection.Aggregate(ctx, scoped, opts...)
+}
+
+// Watch matches on the changed document, so a scoped stream needs the full
+// document looked up and does not see hard deletes.
+func (s Scoped) Watch(ctx context.Context, pipeline interface{}, opts ...*options.ChangeStreamOptions) (ChangeStream, error) {
+	scoped, err := scopePipeline(ctx, "fullDocument.orgId", pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return watch(s.Collection, ctx, scoped, opts)
+}
+
+// watch opens a change stream, keeping a failed one from becoming a
+// non-nil ChangeStream holding a nil pointer.
+func watch(c *mongo.Collection, ctx context.Context, pipeline interface{}, opts []*options.ChangeStreamOptions) (ChangeStream, error) {
+	stream, err := c.Watch(ctx, pipeline, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
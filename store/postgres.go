@@ -0,0 +1,584 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // registers the "pgx" driver
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// postgresSchema creates the tables on first use. Users and complaints have
+// tables of their own, with the fields queries filter and sort on in
+// indexed columns; the other collections share documents, which keeps the
+// values of each document's top-level fields in fields for filtering. Every
+// row keeps the whole document as BSON in doc, so models round-trip exactly
+// as they do through MongoDB. unique_keys enforces unique indexes across
+// concurrent writers and changes backs change streams.
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS users (
+	id               text PRIMARY KEY,
+	seq              bigserial,
+	org_id           text NOT NULL DEFAULT '',
+	email            text UNIQUE,
+	secret_code_hash text UNIQUE,
+	role             text,
+	created_at       timestamptz,
+	irregular        boolean NOT NULL DEFAULT false,
+	doc              bytea NOT NULL
+);
+CREATE INDEX IF NOT EXISTS users_seq ON users (seq);
+CREATE INDEX IF NOT EXISTS users_org_role ON users (org_id, role);
+CREATE INDEX IF NOT EXISTS users_created ON users (created_at DESC);
+CREATE INDEX IF NOT EXISTS users_irregular ON users (seq) WHERE irregular;
+CREATE TABLE IF NOT EXISTS complaints (
+	id                  text PRIMARY KEY,
+	seq                 bigserial,
+	org_id              text NOT NULL DEFAULT '',
+	user_id             text,
+	created_at          timestamptz,
+	updated_at          timestamptz,
+	deleted_at          timestamptz,
+	resolved            boolean,
+	rating              double precision,
+	category            text,
+	tags                jsonb,
+	assigned_to         text,
+	ref_number          double precision,
+	idempotency_key     text,
+	tracking_token_hash text,
+	irregular           boolean NOT NULL DEFAULT false,
+	doc                 bytea NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS complaints_user_idempotency_key ON complaints (user_id, idempotency_key)
+	WHERE idempotency_key IS NOT NULL;
+CREATE INDEX IF NOT EXISTS complaints_seq ON complaints (seq);
+CREATE INDEX IF NOT EXISTS complaints_user_created ON complaints (user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS complaints_org_created ON complaints (org_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS complaints_created ON complaints (created_at DESC);
+CREATE INDEX IF NOT EXISTS complaints_category ON complaints (category, resolved, created_at DESC);
+CREATE INDEX IF NOT EXISTS complaints_assigned ON complaints (assigned_to, created_at DESC);
+CREATE INDEX IF NOT EXISTS complaints_resolved_updated ON complaints (resolved, updated_at);
+CREATE INDEX IF NOT EXISTS complaints_ref_number ON complaints (ref_number);
+CREATE INDEX IF NOT EXISTS complaints_tracking_token ON complaints (tracking_token_hash);
+CREATE INDEX IF NOT EXISTS complaints_tags ON complaints USING gin (tags jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS complaints_irregular ON complaints (seq) WHERE irregular;
+CREATE TABLE IF NOT EXISTS documents (
+	collection text NOT NULL,
+	id         text NOT NULL,
+	seq        bigserial,
+	org_id     text NOT NULL DEFAULT '',
+	fields     jsonb NOT NULL DEFAULT '{}',
+	irregular  boolean NOT NULL DEFAULT false,
+	doc        bytea NOT NULL,
+	PRIMARY KEY (collection, id)
+);
+CREATE INDEX IF NOT EXISTS documents_collection_seq ON documents (collection, seq);
+CREATE INDEX IF NOT EXISTS documents_collection_org ON documents (collection, org_id, seq);
+CREATE INDEX IF NOT EXISTS documents_fields ON documents USING gin (fields jsonb_path_ops);
+CREATE TABLE IF NOT EXISTS unique_keys (
+	collection text NOT NULL,
+	index_name text NOT NULL,
+	key        text NOT NULL,
+	id         text NOT NULL,
+	PRIMARY KEY (collection, index_name, key)
+);
+CREATE TABLE IF NOT EXISTS changes (
+	pos        bigint PRIMARY KEY,
+	collection text NOT NULL,
+	event      bytea NOT NULL,
+	at         timestamptz NOT NULL DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS changes_pruned (
+	only_row boolean PRIMARY KEY DEFAULT true CHECK (only_row),
+	pos      bigint NOT NULL
+);
+INSERT INTO changes_pruned (pos) VALUES (0) ON CONFLICT DO NOTHING;
+CREATE TABLE IF NOT EXISTS indexes (
+	collection text NOT NULL,
+	name       text NOT NULL,
+	spec       bytea NOT NULL,
+	PRIMARY KEY (collection, name)
+);
+`
+
+// legacySchemaUpgrade brings the users and complaints tables of earlier
+// versions, which required the columns their models always set, to the
+// current layout. Their columns are filled in again from doc afterwards.
+const legacySchemaUpgrade = `
+ALTER TABLE users
+	ALTER COLUMN email DROP NOT NULL,
+	DROP COLUMN IF EXISTS version,
+	ADD COLUMN IF NOT EXISTS seq bigserial,
+	ADD COLUMN IF NOT EXISTS role text,
+	ADD COLUMN IF NOT EXISTS created_at timestamptz,
+	ADD COLUMN IF NOT EXISTS irregular boolean NOT NULL DEFAULT false;
+ALTER TABLE complaints
+	DROP CONSTRAINT IF EXISTS complaints_user_id_fkey,
+	ALTER COLUMN user_id DROP NOT NULL,
+	ALTER COLUMN created_at DROP NOT NULL,
+	ALTER COLUMN resolved DROP NOT NULL,
+	ALTER COLUMN resolved DROP DEFAULT,
+	ALTER COLUMN rating DROP NOT NULL,
+	ALTER COLUMN rating DROP DEFAULT,
+	ALTER COLUMN rating TYPE double precision,
+	ALTER COLUMN category DROP NOT NULL,
+	ALTER COLUMN category DROP DEFAULT,
+	ALTER COLUMN tags DROP NOT NULL,
+	ALTER COLUMN tags DROP DEFAULT,
+	ADD COLUMN IF NOT EXISTS seq bigserial,
+	ADD COLUMN IF NOT EXISTS updated_at timestamptz,
+	ADD COLUMN IF NOT EXISTS assigned_to text,
+	ADD COLUMN IF NOT EXISTS ref_number double precision,
+	ADD COLUMN IF NOT EXISTS tracking_token_hash text,
+	ADD COLUMN IF NOT EXISTS irregular boolean NOT NULL DEFAULT false;
+`
+
+// schemaLock is the advisory lock an instance holds while it creates or
+// upgrades the schema, so instances starting together do not race.
+const schemaLock = 0x636f6d706c61696e
+
+// Changes are logged at positions made of the writing transaction's ID and
+// the change's place in it, changeBits wide. Readers stop below the oldest
+// transaction still running, so a change never commits behind a position a
+// stream has read up to. A long-running writer holds streams back until it
+// ends.
+const changeBits = 20
+
+// changeRetention is how long logged changes stay resumable.
+const changeRetention = 24 * time.Hour
+
+// Postgres implements UserStore, ComplaintStore and Documents on PostgreSQL
+// 13 or later through database/sql. The pgx driver is linked in; another
+// registered under the name passed to OpenPostgres also works. Indexes
+// created by another instance are picked up when this one restarts.
+type Postgres struct {
+	*engine
+	db *sql.DB
+}
+
+// OpenPostgres connects with the named driver and creates the schema.
+func OpenPostgres(ctx context.Context, driver, dsn string) (*Postgres, error) {
+	if !slices.Contains(sql.Drivers(), driver) {
+		return nil, fmt.Errorf("store: no database/sql driver named %q is linked into this binary", driver)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := createSchema(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("store: create schema: %w", err)
+	}
+	e, err := newEngine(ctx, &postgresBackend{db: db})
+	if err == nil {
+		err = e.ensureStoreIndexes(ctx)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Postgres{engine: e, db: db}, nil
+}
+
+func (p *Postgres) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+func (p *Postgres) Close() error {
+	return p.db.Close()
+}
+
+// createSchema creates the tables, upgrading the ones earlier versions
+// kept users, complaints and counters in. The counters table only exists
+// in those versions; its rows move to documents.
+func createSchema(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", int64(schemaLock)); err != nil {
+		return err
+	}
+	var legacy bool
+	if err := tx.QueryRowContext(ctx, "SELECT to_regclass('counters') IS NOT NULL").Scan(&legacy); err != nil {
+		return err
+	}
+	if legacy {
+		if _, err := tx.ExecContext(ctx, legacySchemaUpgrade); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, postgresSchema); err != nil {
+		return err
+	}
+	if legacy {
+		if err := upgradeLegacyRows(&postgresTxn{ctx: ctx, tx: tx, q: tx}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// upgradeLegacyRows fills in the columns of the users and complaints the
+// earlier tables held and moves their counters to documents.
+func upgradeLegacyRows(t *postgresTxn) error {
+	for _, table := range []string{"users", "complaints"} {
+		docs, err := t.docs("SELECT doc FROM "+table+" ORDER BY seq", nil)
+		if err != nil {
+			return err
+		}
+		for _, d := range docs {
+			if err := t.replace(table, d); err != nil {
+				return fmt.Errorf("upgrade %s: %w", table, err)
+			}
+		}
+	}
+	rows, err := t.tx.QueryContext(t.ctx, "SELECT name, seq FROM counters")
+	if err != nil {
+		return err
+	}
+	var counters []bson.D
+	for rows.Next() {
+		var name string
+		var seq int64
+		if err := rows.Scan(&name, &seq); err != nil {
+			rows.Close()
+			return err
+		}
+		counters = append(counters, bson.D{{Key: "_id", Value: name}, {Key: "seq", Value: seq}})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, c := range counters {
+		if _, err := t.insert("counters", c); err != nil {
+			return fmt.Errorf("upgrade counters: %w", err)
+		}
+	}
+	_, err = t.tx.ExecContext(t.ctx, "ALTER TABLE counters RENAME TO legacy_counters")
+	return err
+}
+
+type postgresBackend struct {
+	db *sql.DB
+
+	// lastPrune is when, in Unix seconds, this process last pruned the
+	// change log.
+	lastPrune atomic.Int64
+}
+
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (b *postgresBackend) begin(ctx context.Context, write bool) (txn, error) {
+	t := &postgresTxn{ctx: ctx, b: b, q: b.db}
+	if !write {
+		return t, nil
+	}
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	t.tx, t.q = tx, tx
+	return t, nil
+}
+
+func (b *postgresBackend) loadIndexes(ctx context.Context) ([]indexDef, error) {
+	rows, err := b.db.QueryContext(ctx, "SELECT spec FROM indexes ORDER BY collection, name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var defs []indexDef
+	for rows.Next() {
+		var spec []byte
+		if err := rows.Scan(&spec); err != nil {
+			return nil, err
+		}
+		var def indexDef
+		if err := bson.Unmarshal(spec, &def); err != nil {
+			return nil, err
+		}
+		defs = append(defs, def)
+	}
+	return defs, rows.Err()
+}
+
+// changes reads the log in one snapshot, up to the first position the
+// oldest transaction still running could log at.
+func (b *postgresBackend) changes(ctx context.Context, after int64) ([]change, int64, int64, error) {
+	tx, err := b.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer tx.Rollback()
+	var bound, pruned, latest int64
+	err = tx.QueryRowContext(ctx, `SELECT s.bound, p.pos, greatest(p.pos, (SELECT max(pos) FROM changes WHERE pos < s.bound))
+		FROM changes_pruned p, (SELECT pg_snapshot_xmin(pg_current_snapshot())::text::bigint << $1 AS bound) s`,
+		changeBits).Scan(&bound, &pruned, &latest)
+	if err != nil || after >= latest {
+		return nil, pruned + 1, latest, err
+	}
+	rows, err := tx.QueryContext(ctx,
+		"SELECT pos, collection, event, at FROM changes WHERE pos > $1 AND pos < $2 ORDER BY pos LIMIT 1000", after, bound)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer rows.Close()
+	var out []change
+	for rows.Next() {
+		var c change
+		var event []byte
+		if err := rows.Scan(&c.seq, &c.coll, &event, &c.at); err != nil {
+			return nil, 0, 0, err
+		}
+		if c.event, err = toDoc(bson.Raw(event)); err != nil {
+			return nil, 0, 0, err
+		}
+		out = append(out, c)
+	}
+	return out, pruned + 1, latest, rows.Err()
+}
+
+// changed is nil: streams poll the changes table.
+func (b *postgresBackend) changed() <-chan struct{} {
+	return nil
+}
+
+// retryable reports serialization failures and deadlocks. Drivers expose
+// the SQLSTATE differently; pgx and lib/pq both have a SQLState method.
+func (b *postgresBackend) retryable(err error) bool {
+	var state interface{ SQLState() string }
+	return errors.As(err, &state) && (state.SQLState() == "40001" || state.SQLState() == "40P01")
+}
+
+// postgresTxn is a unit of work on PostgreSQL: a transaction if it writes,
+// single statements on the pool otherwise.
+type postgresTxn struct {
+	ctx    context.Context
+	b      *postgresBackend
+	tx     *sql.Tx
+	q      querier
+	events []change
+}
+
+// docs runs a query selecting documents.
+func (t *postgresTxn) docs(query string, args []interface{}) ([]bson.D, error) {
+	rows, err := t.q.QueryContext(t.ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var docs []bson.D
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		d, err := toDoc(bson.Raw(raw))
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, d)
+	}
+	return docs, rows.Err()
+}
+
+func (t *postgresTxn) scan(coll string, h hint) ([]bson.D, error) {
+	var q sqlArgs
+	var query string
+	if tb, ok := postgresTables[coll]; ok {
+		query = tb.selectSQL(h, &q)
+	} else {
+		query = documentsSQL(coll, h, &q)
+	}
+	return t.docs(query, q)
+}
+
+func (t *postgresTxn) lock(coll string, ids []string) ([]bson.D, error) {
+	if _, ok := postgresTables[coll]; ok {
+		return t.docs("SELECT doc FROM "+coll+" WHERE id = ANY($1) ORDER BY seq FOR UPDATE", []interface{}{ids})
+	}
+	return t.docs("SELECT doc FROM documents WHERE collection = $1 AND id = ANY($2) ORDER BY seq FOR UPDATE",
+		[]interface{}{coll, ids})
+}
+
+func (t *postgresTxn) insert(coll string, doc bson.D) (bool, error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return false, err
+	}
+	var res sql.Result
+	if tb, ok := postgresTables[coll]; ok {
+		res, err = t.q.ExecContext(t.ctx, tb.insertSQL(), tb.row(doc, raw)...)
+	} else {
+		var fields string
+		var irregular bool
+		if fields, irregular, err = fieldKeys(doc); err != nil {
+			return false, err
+		}
+		res, err = t.q.ExecContext(t.ctx, `INSERT INTO documents (collection, id, org_id, fields, irregular, doc)
+			VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING`,
+			coll, idKey(idOf(doc)), orgOf(doc), fields, irregular, raw)
+	}
+	if err != nil {
+		return false, uniqueViolation(coll, err)
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (t *postgresTxn) replace(coll string, doc bson.D) error {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if tb, ok := postgresTables[coll]; ok {
+		_, err = t.q.ExecContext(t.ctx, tb.updateSQL(), tb.row(doc, raw)...)
+		return uniqueViolation(coll, err)
+	}
+	fields, irregular, err := fieldKeys(doc)
+	if err != nil {
+		return err
+	}
+	_, err = t.q.ExecContext(t.ctx, `UPDATE documents SET org_id = $3, fields = $4, irregular = $5, doc = $6
+		WHERE collection = $1 AND id = $2`, coll, idKey(idOf(doc)), orgOf(doc), fields, irregular, raw)
+	return err
+}
+
+func (t *postgresTxn) remove(coll string, id interface{}) error {
+	if _, ok := postgresTables[coll]; ok {
+		_, err := t.q.ExecContext(t.ctx, "DELETE FROM "+coll+" WHERE id = $1", idKey(id))
+		return err
+	}
+	_, err := t.q.ExecContext(t.ctx, "DELETE FROM documents WHERE collection = $1 AND id = $2", coll, idKey(id))
+	return err
+}
+
+// uniqueViolation reports a write a table's own unique constraint refused
+// as MongoDB reports a duplicate key. The engine checks unique indexes
+// before writing, so this only happens if a constraint is stricter than
+// the index it backs.
+func uniqueViolation(coll string, err error) error {
+	var state interface{ SQLState() string }
+	if errors.As(err, &state) && state.SQLState() == "23505" {
+		return mongo.WriteException{WriteErrors: mongo.WriteErrors{{
+			Code:    11000,
+			Message: fmt.Sprintf("E11000 duplicate key error collection: %s: %v", coll, err),
+		}}}
+	}
+	return err
+}
+
+// claim inserts the key, or finds its owner if another transaction has. An
+// insert racing one that is not yet committed waits for it to end.
+func (t *postgresTxn) claim(coll, index, key, id string) (string, error) {
+	for {
+		res, err := t.q.ExecContext(t.ctx,
+			"INSERT INTO unique_keys (collection, index_name, key, id) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING",
+			coll, index, key, id)
+		if err != nil {
+			return "", err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 1 {
+			return id, err
+		}
+		var owner string
+		err = t.q.QueryRowContext(t.ctx,
+			"SELECT id FROM unique_keys WHERE collection = $1 AND index_name = $2 AND key = $3",
+			coll, index, key).Scan(&owner)
+		if err != sql.ErrNoRows {
+			return owner, err
+		}
+		// Released since the insert: try again.
+	}
+}
+
+func (t *postgresTxn) release(coll, index, key string) error {
+	_, err := t.q.ExecContext(t.ctx, "DELETE FROM unique_keys WHERE collection = $1 AND index_name = $2 AND key = $3",
+		coll, index, key)
+	return err
+}
+
+func (t *postgresTxn) saveIndex(def indexDef) error {
+	spec, err := bson.Marshal(def)
+	if err != nil {
+		return err
+	}
+	_, err = t.q.ExecContext(t.ctx,
+		"INSERT INTO indexes (collection, name, spec) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+		def.Collection, def.Name, spec)
+	return err
+}
+
+func (t *postgresTxn) record(coll string, event bson.D) {
+	t.events = append(t.events, change{coll: coll, event: event})
+}
+
+// commit logs the unit's changes in the same transaction as its writes.
+func (t *postgresTxn) commit() error {
+	if t.tx == nil {
+		return nil
+	}
+	if len(t.events) > 0 {
+		if err := t.logChanges(); err != nil {
+			t.tx.Rollback()
+			return err
+		}
+	}
+	return t.tx.Commit()
+}
+
+func (t *postgresTxn) logChanges() error {
+	if len(t.events) >= 1<<changeBits {
+		return fmt.Errorf("store: a transaction cannot log more than %d changes", 1<<changeBits-1)
+	}
+	var xid int64
+	if err := t.tx.QueryRowContext(t.ctx, "SELECT pg_current_xact_id()::text::bigint").Scan(&xid); err != nil {
+		return err
+	}
+	for i, c := range t.events {
+		event, err := bson.Marshal(c.event)
+		if err != nil {
+			return err
+		}
+		if _, err := t.tx.ExecContext(t.ctx, "INSERT INTO changes (pos, collection, event) VALUES ($1, $2, $3)",
+			xid<<changeBits|int64(i), c.coll, event); err != nil {
+			return err
+		}
+	}
+	// Prune at most once a minute from each process, noting how far so a
+	// stream resuming from before then is told its history is lost.
+	now := time.Now()
+	last := t.b.lastPrune.Load()
+	if now.Unix()-last < 60 || !t.b.lastPrune.CompareAndSwap(last, now.Unix()) {
+		return nil
+	}
+	_, err := t.tx.ExecContext(t.ctx, `WITH gone AS (DELETE FROM changes WHERE at < $1 RETURNING pos)
+		UPDATE changes_pruned SET pos = greatest(pos, (SELECT max(pos) FROM gone)) WHERE EXISTS (SELECT FROM gone)`,
+		now.Add(-changeRetention))
+	return err
+}
+
+func (t *postgresTxn) rollback() error {
+	if t.tx == nil {
+		return nil
+	}
+	return t.tx.Rollback()
+}
@@ -0,0 +1,578 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The PostgreSQL backend narrows scans in SQL by the conditions of a hint
+// it can express exactly and leaves the rest to the engine. A row is
+// irregular when a field of its document does not have the type its column
+// or key expects, such as a userId that is a string; irregular rows are
+// returned whatever the conditions, since SQL cannot tell whether they
+// match.
+
+// pgTable is a table of its own for one collection, with some of its
+// fields in columns next to the document.
+type pgTable struct {
+	name    string
+	columns []pgColumn
+}
+
+type pgColumn struct {
+	name  string
+	field string
+	kind  columnKind
+}
+
+type columnKind int
+
+const (
+	textColumn   columnKind = iota // a string
+	idColumn                       // an ObjectID, as hex
+	timeColumn                     // a date
+	boolColumn                     // a boolean
+	numberColumn                   // a number, as a double
+	tagsColumn                     // an array of strings, as jsonb
+	// presenceColumn is a date queried for with $exists: a value that is
+	// not a date, even null, makes the row irregular.
+	presenceColumn
+)
+
+var postgresTables = map[string]*pgTable{
+	"users": {name: "users", columns: []pgColumn{
+		{"email", "email", textColumn},
+		{"secret_code_hash", "secretCodeHash", textColumn},
+		{"role", "role", textColumn},
+		{"created_at", "createdAt", timeColumn},
+	}},
+	"complaints": {name: "complaints", columns: []pgColumn{
+		{"user_id", "userId", idColumn},
+		{"created_at", "createdAt", timeColumn},
+		{"updated_at", "updatedAt", timeColumn},
+		{"deleted_at", "deletedAt", presenceColumn},
+		{"resolved", "resolved", boolColumn},
+		{"rating", "rating", numberColumn},
+		{"category", "category", textColumn},
+		{"tags", "tags", tagsColumn},
+		{"assigned_to", "assignedTo", textColumn},
+		{"ref_number", "refNumber", numberColumn},
+		{"idempotency_key", "idempotencyKey", textColumn},
+		{"tracking_token_hash", "trackingTokenHash", textColumn},
+	}},
+}
+
+func (tb *pgTable) column(field string) *pgColumn {
+	for i := range tb.columns {
+		if tb.columns[i].field == field {
+			return &tb.columns[i]
+		}
+	}
+	return nil
+}
+
+// row returns the values insertSQL and updateSQL take for doc: its id,
+// organization, columns and whether it is irregular, then raw.
+func (tb *pgTable) row(doc bson.D, raw []byte) []interface{} {
+	_, isOID := idOf(doc).(primitive.ObjectID)
+	org, _ := get(doc, "orgId")
+	_, isString := org.(string)
+	irregular := !isOID || !isString && org != nil && org != missing
+	args := []interface{}{idKey(idOf(doc)), orgOf(doc)}
+	for _, c := range tb.columns {
+		v, ok := c.value(doc)
+		args = append(args, v)
+		irregular = irregular || !ok
+	}
+	return append(args, irregular, raw)
+}
+
+func (tb *pgTable) insertSQL() string {
+	names := []string{"id", "org_id"}
+	for _, c := range tb.columns {
+		names = append(names, c.name)
+	}
+	names = append(names, "irregular", "doc")
+	params := make([]string, len(names))
+	for i := range params {
+		params[i] = fmt.Sprintf("$%d", i+1)
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (id) DO NOTHING",
+		tb.name, strings.Join(names, ", "), strings.Join(params, ", "))
+}
+
+func (tb *pgTable) updateSQL() string {
+	sets := []string{"org_id = $2"}
+	for i, c := range tb.columns {
+		sets = append(sets, fmt.Sprintf("%s = $%d", c.name, i+3))
+	}
+	n := len(tb.columns) + 3
+	sets = append(sets, fmt.Sprintf("irregular = $%d", n), fmt.Sprintf("doc = $%d", n+1))
+	return fmt.Sprintf("UPDATE %s SET %s WHERE id = $1", tb.name, strings.Join(sets, ", "))
+}
+
+// selectSQL selects the documents a scan by h returns. When every
+// condition of an exact hint has a column, the regular rows are also
+// sorted and limited as the hint asks, and the irregular ones added for
+// the engine to place.
+func (tb *pgTable) selectSQL(h hint, q *sqlArgs) string {
+	scope := scopeSQL(h, q)
+	precise := h.exact
+	var conds []string
+	for _, c := range h.conds {
+		sqls, ok := tb.condSQL(c, q)
+		conds = append(conds, sqls...)
+		precise = precise && ok
+	}
+	if order, ok := tb.orderSQL(h.sort); ok && precise && h.limit > 0 {
+		regular := append(append(slices.Clip(scope), "NOT irregular"), conds...)
+		irregular := append(slices.Clip(scope), "irregular")
+		return fmt.Sprintf(`SELECT doc FROM (
+			(SELECT seq, doc FROM %[1]s WHERE %[2]s ORDER BY %[3]s LIMIT %[4]d)
+			UNION ALL (SELECT seq, doc FROM %[1]s WHERE %[5]s)) found ORDER BY seq`,
+			tb.name, strings.Join(regular, " AND "), order, h.limit, strings.Join(irregular, " AND "))
+	}
+	where := scope
+	if len(conds) > 0 {
+		where = append(where, "(irregular OR "+strings.Join(conds, " AND ")+")")
+	}
+	if len(where) == 0 {
+		return "SELECT doc FROM " + tb.name + " ORDER BY seq"
+	}
+	return "SELECT doc FROM " + tb.name + " WHERE " + strings.Join(where, " AND ") + " ORDER BY seq"
+}
+
+// orderSQL returns the ORDER BY terms for a sort on _id and columns. NULL
+// stands for missing and null, which MongoDB sorts first.
+func (tb *pgTable) orderSQL(spec bson.D) (string, bool) {
+	var terms []string
+	for _, k := range spec {
+		n, ok := toInt64(k.Value)
+		if !ok || n != 1 && n != -1 {
+			return "", false
+		}
+		term := `id COLLATE "C"`
+		if k.Key != "_id" {
+			c := tb.column(k.Key)
+			if c == nil || c.kind == tagsColumn {
+				return "", false
+			}
+			term = c.name
+			if c.kind == textColumn || c.kind == idColumn {
+				term += ` COLLATE "C"`
+			}
+		}
+		if n == 1 {
+			term += " ASC NULLS FIRST"
+		} else {
+			term += " DESC NULLS LAST"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(append(terms, "seq"), ", "), true
+}
+
+// value returns the column's value for doc, and whether the field has the
+// column's type or, for most kinds, is missing or null.
+func (c pgColumn) value(doc bson.D) (interface{}, bool) {
+	v, ok := get(doc, c.field)
+	if !ok {
+		return nil, true
+	}
+	if v == nil {
+		return nil, c.kind != presenceColumn
+	}
+	if c.kind == tagsColumn {
+		list, ok := v.(bson.A)
+		if !ok {
+			return nil, false
+		}
+		tags := make([]string, len(list))
+		for i, e := range list {
+			if tags[i], ok = e.(string); !ok || !sqlText(tags[i]) {
+				return nil, false
+			}
+		}
+		return jsonText(tags), true
+	}
+	return c.param(v)
+}
+
+// param converts a value to the column's type, reporting false if it has
+// another. For tags it takes one tag.
+func (c pgColumn) param(v interface{}) (interface{}, bool) {
+	switch c.kind {
+	case textColumn, tagsColumn:
+		s, ok := v.(string)
+		return s, ok && sqlText(s)
+	case idColumn:
+		id, ok := v.(primitive.ObjectID)
+		return id.Hex(), ok
+	case timeColumn, presenceColumn:
+		d, ok := v.(primitive.DateTime)
+		if t := d.Time(); ok && t.Year() >= 1 && t.Year() <= 9999 {
+			return t.UTC(), true
+		}
+	case boolColumn:
+		b, ok := v.(bool)
+		return b, ok
+	case numberColumn:
+		if n, ok := v.(int64); ok && (n > 1<<53 || n < -1<<53) {
+			return nil, false
+		}
+		switch v.(type) {
+		case int32, int64, float64:
+			if f := toFloat(v); !math.IsNaN(f) {
+				return f, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// cast is the type a parameter compared with the column is cast to.
+func (c pgColumn) cast() string {
+	if c.kind == numberColumn {
+		return "::float8"
+	}
+	return ""
+}
+
+// condSQL returns SQL for the parts of a condition the table's columns
+// can express, and whether that is all of it.
+func (tb *pgTable) condSQL(c cond, q *sqlArgs) ([]string, bool) {
+	col := tb.column(c.field)
+	if col == nil {
+		return nil, false
+	}
+	ops, isOps := operators(c.value)
+	if !isOps {
+		ops = bson.D{{Key: "$eq", Value: c.value}}
+	}
+	var out []string
+	precise := true
+	for _, op := range ops {
+		s, ok := col.operatorSQL(op.Key, op.Value, q)
+		if ok {
+			out = append(out, s)
+		}
+		precise = precise && ok
+	}
+	return out, precise
+}
+
+func (c pgColumn) operatorSQL(op string, v interface{}, q *sqlArgs) (string, bool) {
+	switch op {
+	case "$eq":
+		if isNullish(v) {
+			return c.name + " IS NULL", true
+		}
+		p, ok := c.param(v)
+		if !ok {
+			return "", false
+		}
+		if c.kind == tagsColumn {
+			return c.name + " @> " + q.arg(jsonText([]string{p.(string)})) + "::jsonb", true
+		}
+		return c.name + " = " + q.arg(p) + c.cast(), true
+	case "$in":
+		list, ok := v.(bson.A)
+		if !ok || len(list) > maxInList {
+			return "", false
+		}
+		var params []interface{}
+		var tags []string
+		orNull := ""
+		for _, e := range list {
+			if isNullish(e) {
+				orNull = " OR " + c.name + " IS NULL"
+				continue
+			}
+			p, ok := c.param(e)
+			if !ok {
+				return "", false
+			}
+			params = append(params, p)
+			if c.kind == tagsColumn {
+				tags = append(tags, p.(string))
+			}
+		}
+		if c.kind == tagsColumn {
+			return "(" + c.name + " ?| " + q.arg(tags) + "::text[]" + orNull + ")", true
+		}
+		return "(" + c.name + " = ANY(" + q.arg(typedList(c.kind, params)) + ")" + orNull + ")", true
+	case "$gt", "$gte", "$lt", "$lte":
+		if c.kind == tagsColumn || c.kind == boolColumn {
+			return "", false
+		}
+		p, ok := c.param(v)
+		if !ok {
+			return "", false
+		}
+		name := c.name
+		if c.kind == textColumn || c.kind == idColumn {
+			name += ` COLLATE "C"`
+		}
+		return name + " " + comparison[op] + " " + q.arg(p) + c.cast(), true
+	case "$exists":
+		if c.kind != presenceColumn {
+			return "", false
+		}
+		if truthy(v) {
+			return c.name + " IS NOT NULL", true
+		}
+		return c.name + " IS NULL", true
+	case "$all":
+		list, ok := v.(bson.A)
+		if c.kind != tagsColumn || !ok || len(list) == 0 {
+			return "", false
+		}
+		tags := make([]string, len(list))
+		for i, e := range list {
+			if tags[i], ok = e.(string); !ok || !sqlText(tags[i]) {
+				return "", false
+			}
+		}
+		return c.name + " @> " + q.arg(jsonText(tags)) + "::jsonb", true
+	}
+	return "", false
+}
+
+// maxInList is the longest $in list a condition is sent to the database
+// with.
+const maxInList = 1000
+
+var comparison = map[string]string{"$gt": ">", "$gte": ">=", "$lt": "<", "$lte": "<="}
+
+// typedList gives a list of column parameters the slice type the driver
+// sends as an array of the column's type.
+func typedList(kind columnKind, params []interface{}) interface{} {
+	switch kind {
+	case timeColumn, presenceColumn:
+		return convertList[time.Time](params)
+	case boolColumn:
+		return convertList[bool](params)
+	case numberColumn:
+		return convertList[float64](params)
+	}
+	return convertList[string](params)
+}
+
+func convertList[T any](params []interface{}) interface{} {
+	out := make([]T, 0, len(params))
+	for _, p := range params {
+		out = append(out, p.(T))
+	}
+	return out
+}
+
+// scopeSQL returns the conditions on the id and organization of a row,
+// which hold for irregular rows too.
+func scopeSQL(h hint, q *sqlArgs) []string {
+	var where []string
+	if h.ids != nil {
+		where = append(where, "id = ANY("+q.arg(h.ids)+")")
+	}
+	if h.org != nil {
+		where = append(where, "org_id = "+q.arg(*h.org))
+	}
+	return where
+}
+
+// documentsSQL selects the documents of a collection kept in documents
+// that a scan by h returns, narrowed by the conditions on top-level fields
+// their keys can express.
+func documentsSQL(coll string, h hint, q *sqlArgs) string {
+	where := append([]string{"collection = " + q.arg(coll)}, scopeSQL(h, q)...)
+	var conds []string
+	for _, c := range h.conds {
+		conds = append(conds, keyConds(c, q)...)
+	}
+	if len(conds) > 0 {
+		where = append(where, "(irregular OR "+strings.Join(conds, " AND ")+")")
+	}
+	return "SELECT doc FROM documents WHERE " + strings.Join(where, " AND ") + " ORDER BY seq"
+}
+
+// fieldKeys returns the fields column for doc: for each top-level field
+// the keys of its value or of the elements of its array, as a JSON object
+// of arrays. Keys are prefixed by type and, within one, sort as their
+// values do, to compare as text. The document is irregular if a value
+// matches keys it has none of: NaN, which MongoDB compares equal to any
+// number, or a symbol, which compares as a string.
+func fieldKeys(doc bson.D) (string, bool, error) {
+	fields := map[string][]string{}
+	irregular := false
+	for _, e := range doc {
+		if e.Key == "_id" || !utf8.ValidString(e.Key) {
+			continue
+		}
+		list, isArray := e.Value.(bson.A)
+		if !isArray {
+			list = bson.A{e.Value}
+		}
+		for _, v := range list {
+			switch x := v.(type) {
+			case primitive.Symbol:
+				irregular = true
+			case int32, int64, float64, primitive.Decimal128:
+				irregular = irregular || math.IsNaN(toFloat(x))
+			}
+			if k, ok := valueKey(v); ok {
+				fields[e.Key] = append(fields[e.Key], k)
+			}
+		}
+	}
+	b, err := json.Marshal(fields)
+	return string(b), irregular, err
+}
+
+// maxKeyLength is the longest string kept as a key. Conditions on longer
+// strings are left to the engine.
+const maxKeyLength = 256
+
+// valueKey returns the key of a scalar value, one each of string, ObjectID,
+// boolean, date and number. Numbers are keyed by their double, so equal
+// numbers of different types share a key; a long integer or decimal may
+// share it with a neighbor, which the engine tells apart.
+func valueKey(v interface{}) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return "s" + x, len(x) <= maxKeyLength && sqlText(x)
+	case primitive.ObjectID:
+		return "o" + x.Hex(), true
+	case bool:
+		if x {
+			return "b1", true
+		}
+		return "b0", true
+	case primitive.DateTime:
+		return fmt.Sprintf("d%016x", uint64(x)^1<<63), true
+	case int32, int64, float64, primitive.Decimal128:
+		f := toFloat(x)
+		if math.IsNaN(f) {
+			return "", false
+		}
+		if f == 0 {
+			f = 0 // -0 keys as 0
+		}
+		bits := math.Float64bits(f)
+		if f >= 0 {
+			bits ^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		return fmt.Sprintf("n%016x", bits), true
+	}
+	return "", false
+}
+
+// keyConds returns SQL for the parts of a condition the keys in fields can
+// express; conditions on _id and orgId are left to scopeSQL.
+func keyConds(c cond, q *sqlArgs) []string {
+	if c.field == "_id" || c.field == "orgId" || strings.Contains(c.field, ".") || !utf8.ValidString(c.field) {
+		return nil
+	}
+	ops, isOps := operators(c.value)
+	if !isOps {
+		ops = bson.D{{Key: "$eq", Value: c.value}}
+	}
+	var out []string
+	for _, op := range ops {
+		switch op.Key {
+		case "$eq":
+			if _, isArray := op.Value.(bson.A); !isArray {
+				if k, ok := valueKey(op.Value); ok {
+					out = append(out, "fields @> "+q.arg(jsonText(map[string][]string{c.field: {k}}))+"::jsonb")
+				}
+			}
+		case "$in":
+			list, ok := op.Value.(bson.A)
+			if !ok || len(list) > maxInList {
+				continue
+			}
+			var keys []string
+			for _, e := range list {
+				if k, ok := valueKey(e); ok {
+					keys = append(keys, k)
+				}
+			}
+			if len(keys) == len(list) && len(keys) > 0 {
+				alts := make([]string, len(keys))
+				for i, k := range keys {
+					alts[i] = "fields @> " + q.arg(jsonText(map[string][]string{c.field: {k}})) + "::jsonb"
+				}
+				out = append(out, "("+strings.Join(alts, " OR ")+")")
+			}
+		case "$all":
+			list, ok := op.Value.(bson.A)
+			if !ok || len(list) == 0 {
+				continue
+			}
+			keys := make([]string, 0, len(list))
+			for _, e := range list {
+				if k, ok := valueKey(e); ok {
+					keys = append(keys, k)
+				}
+			}
+			if len(keys) == len(list) {
+				out = append(out, "fields @> "+q.arg(jsonText(map[string][]string{c.field: keys}))+"::jsonb")
+			}
+		case "$gt", "$gte", "$lt", "$lte":
+			if s, ok := keyRange(c.field, op.Key, op.Value, q); ok {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// keyRange compares keys of dates, numbers and ObjectIDs, which sort as
+// their values within the key's type. Number keys are compared inclusively,
+// since a number may share its key with a neighbor.
+func keyRange(field, op string, v interface{}, q *sqlArgs) (string, bool) {
+	k, ok := valueKey(v)
+	if !ok || k[0] == 's' || k[0] == 'b' {
+		return "", false
+	}
+	bound := comparison[op]
+	if k[0] == 'n' {
+		bound = strings.TrimSuffix(bound, "=") + "="
+	}
+	// Every key of the type sorts after its prefix and before the prefix
+	// followed by a letter past the hex digits.
+	lo, hi := k[:1], k[:1]+"g"
+	if op == "$gt" || op == "$gte" {
+		return fmt.Sprintf(`EXISTS (SELECT FROM jsonb_array_elements_text(fields -> %s::text) AS k(v) WHERE v COLLATE "C" %s %s AND v COLLATE "C" < %s)`,
+			q.arg(field), bound, q.arg(k), q.arg(hi)), true
+	}
+	return fmt.Sprintf(`EXISTS (SELECT FROM jsonb_array_elements_text(fields -> %s::text) AS k(v) WHERE v COLLATE "C" %s %s AND v COLLATE "C" > %s)`,
+		q.arg(field), bound, q.arg(k), q.arg(lo)), true
+}
+
+// sqlArgs collects the parameters of a statement.
+type sqlArgs []interface{}
+
+// arg adds a parameter and returns its placeholder.
+func (q *sqlArgs) arg(v interface{}) string {
+	*q = append(*q, v)
+	return fmt.Sprintf("$%d", len(*q))
+}
+
+// sqlText reports whether PostgreSQL can store s as text or in jsonb.
+func sqlText(s string) bool {
+	return utf8.ValidString(s) && !strings.ContainsRune(s, 0)
+}
+
+func jsonText(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
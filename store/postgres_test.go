@@ -0,0 +1,49 @@
+package store
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestValueKeysSortAsValues(t *testing.T) {
+	base := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, ordered := range [][]interface{}{
+		{math.Inf(-1), int64(-1 << 40), int32(-3), -2.5, 0.0, int32(1), 1.5, int64(1 << 40), math.Inf(1)},
+		{primitive.NewDateTimeFromTime(time.Unix(-86400, 0)), primitive.NewDateTimeFromTime(base),
+			primitive.NewDateTimeFromTime(base.Add(time.Millisecond))},
+		{primitive.NilObjectID, primitive.NewObjectIDFromTimestamp(base), primitive.NewObjectIDFromTimestamp(base.Add(time.Second))},
+	} {
+		for i := 1; i < len(ordered); i++ {
+			a, _ := valueKey(ordered[i-1])
+			b, _ := valueKey(ordered[i])
+			if a >= b {
+				t.Errorf("key of %v = %q, not below %q of %v", ordered[i-1], a, b, ordered[i])
+			}
+		}
+	}
+	for _, same := range [][2]interface{}{{int32(2), 2.0}, {int64(2), int32(2)}, {math.Copysign(0, -1), int32(0)},
+		{mustDecimal(t, "2.0"), int32(2)}} {
+		a, _ := valueKey(same[0])
+		b, _ := valueKey(same[1])
+		if a != b {
+			t.Errorf("keys of %v and %v = %q and %q, want them equal", same[0], same[1], a, b)
+		}
+	}
+	for _, v := range []interface{}{math.NaN(), nil, "bad \x00 text", primitive.Regex{Pattern: "a"}} {
+		if k, ok := valueKey(v); ok {
+			t.Errorf("valueKey(%#v) = %q, want none", v, k)
+		}
+	}
+}
+
+func mustDecimal(t *testing.T, s string) primitive.Decimal128 {
+	t.Helper()
+	d, err := primitive.ParseDecimal128(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
@@ -0,0 +1,166 @@
+// Package store defines the persistence interfaces the handlers depend on,
+// with MongoDB and PostgreSQL implementations and an in-memory one for tests.
+package store
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"complain/models"
+)
+
+var (
+	ErrNotFound        = errors.New("not found")
+	ErrDuplicate       = errors.New("duplicate")
+	ErrVersionConflict = errors.New("modified by another request")
+)
+
+// ProfileUpdate holds the user fields a profile update may change.
+type ProfileUpdate struct {
+	Name   string
+	Email  string
+	Locale string
+}
+
+// ComplaintFilter narrows complaint listings. Zero values match everything
+// but soft-deleted complaints, which IncludeDeleted adds back; From is
+// inclusive and To exclusive.
+type ComplaintFilter struct {
+	UserID         primitive.ObjectID
+	Resolved       *bool
+	MinRating      int
+	MaxRating      int
+	From           time.Time
+	To             time.Time
+	Category       string
+	Tags           []string // complaints carrying all of them
+	IncludeDeleted bool
+}
+
+// NotDeleted matches complaints that have not been soft-deleted, as the
+// value of a deletedAt condition.
+func NotDeleted() bson.M {
+	return bson.M{"$exists": false}
+}
+
+// BSON translates the filter to a MongoDB query.
+func (f ComplaintFilter) BSON() bson.M {
+	filter := bson.M{}
+	if !f.IncludeDeleted {
+		filter["deletedAt"] = NotDeleted()
+	}
+	if !f.UserID.IsZero() {
+		filter["userId"] = f.UserID
+	}
+	if f.Resolved != nil {
+		filter["resolved"] = *f.Resolved
+	}
+	if f.Category != "" {
+		filter["category"] = f.Category
+	}
+	if len(f.Tags) > 0 {
+		filter["tags"] = bson.M{"$all": f.Tags}
+	}
+	rating := bson.M{}
+	if f.MinRating > 0 {
+		rating["$gte"] = f.MinRating
+	}
+	if f.MaxRating > 0 {
+		rating["$lte"] = f.MaxRating
+	}
+	if len(rating) > 0 {
+		filter["rating"] = rating
+	}
+	created := bson.M{}
+	if !f.From.IsZero() {
+		created["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		created["$lt"] = f.To
+	}
+	if len(created) > 0 {
+		filter["createdAt"] = created
+	}
+	return filter
+}
+
+// Matches applies the filter to a complaint in memory.
+func (f ComplaintFilter) Matches(c models.Complaint) bool {
+	switch {
+	case !f.IncludeDeleted && c.DeletedAt != nil:
+		return false
+	case !f.UserID.IsZero() && c.UserID != f.UserID:
+		return false
+	case f.Resolved != nil && c.Resolved != *f.Resolved:
+		return false
+	case f.Category != "" && c.Category != f.Category:
+		return false
+	case !hasAllTags(c.Tags, f.Tags):
+		return false
+	case f.MinRating > 0 && c.Rating < f.MinRating:
+		return false
+	case f.MaxRating > 0 && c.Rating > f.MaxRating:
+		return false
+	case !f.From.IsZero() && c.CreatedAt.Before(f.From):
+		return false
+	case !f.To.IsZero() && !c.CreatedAt.Before(f.To):
+		return false
+	}
+	return true
+}
+
+func hasAllTags(have, want []string) bool {
+	for _, t := range want {
+		if !slices.Contains(have, t) {
+			return false
+		}
+	}
+	return true
+}
+
+type UserStore interface {
+	// CreateUser returns ErrDuplicate if the email is already registered.
+	CreateUser(ctx context.Context, user models.User) error
+	UserBySecretCodeHash(ctx context.Context, hash string) (models.User, error)
+	// SetSecretCodeHash replaces the user's secret code, returning ErrNotFound
+	// if the user does not exist.
+	SetSecretCodeHash(ctx context.Context, id primitive.ObjectID, hash string) error
+	UserByID(ctx context.Context, id primitive.ObjectID) (models.User, error)
+	// UpdateProfile applies the update only if the user is still at version,
+	// returning ErrVersionConflict otherwise and ErrDuplicate if the new email
+	// belongs to someone else. Users stored before versioning count as 0.
+	UpdateProfile(ctx context.Context, id primitive.ObjectID, version int, update ProfileUpdate) (models.User, error)
+}
+
+type ComplaintStore interface {
+	// CreateComplaint inserts the complaint and links it to its user as one
+	// unit, returning the updated user. It returns ErrNotFound if the user does
+	// not exist and ErrDuplicate if the user already used the idempotency key.
+	CreateComplaint(ctx context.Context, complaint models.Complaint) (models.User, error)
+	Complaint(ctx context.Context, id primitive.ObjectID) (models.Complaint, error)
+	// Complaints returns the complaints matching filter.
+	Complaints(ctx context.Context, filter ComplaintFilter) ([]models.Complaint, error)
+	// ComplaintsSince returns the user's complaints created at or after since,
+	// soft-deleted ones included so deleting does not reset rate checks.
+	ComplaintsSince(ctx context.Context, userID primitive.ObjectID, since time.Time) ([]models.Complaint, error)
+	ComplaintByIdempotencyKey(ctx context.Context, userID primitive.ObjectID, key string) (models.Complaint, error)
+	// ReleaseIdempotencyKey clears the key from the user's complaints created
+	// before the given time so it can be used again.
+	ReleaseIdempotencyKey(ctx context.Context, userID primitive.ObjectID, key string, before time.Time) error
+	// CountComplaints counts the user's complaints that are not soft-deleted.
+	CountComplaints(ctx context.Context, userID primitive.ObjectID) (total, resolved int64, err error)
+	// DeleteComplaint soft-deletes the complaint, keeping its comments and
+	// attachments so it can be restored, and returns it. It returns
+	// ErrNotFound if the complaint does not exist or is already deleted.
+	DeleteComplaint(ctx context.Context, id primitive.ObjectID, deletedBy string) (models.Complaint, error)
+	// RestoreComplaint undoes DeleteComplaint, returning ErrNotFound if the
+	// complaint does not exist or is not deleted.
+	RestoreComplaint(ctx context.Context, id primitive.ObjectID) (models.Complaint, error)
+	// NextSequence atomically increments and returns a named counter.
+	NextSequence(ctx context.Context, name string) (int64, error)
+}
@@ -0,0 +1,167 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"complain/models"
+)
+
+// testStore is a store under test. Tests share one interface so every
+// implementation is held to the same behavior.
+type testStore interface {
+	UserStore
+	ComplaintStore
+	Documents
+}
+
+// stores opens each implementation for a test, skipping the ones whose
+// server is not configured.
+var stores = []struct {
+	name string
+	open func(t *testing.T) testStore
+}{
+	{"memory", func(t *testing.T) testStore { return NewMemory() }},
+	{"mongo", openMongo},
+	{"postgres", openPostgres},
+}
+
+// forEachStore runs test against every implementation.
+func forEachStore(t *testing.T, test func(t *testing.T, s testStore)) {
+	for _, impl := range stores {
+		t.Run(impl.name, func(t *testing.T) {
+			test(t, impl.open(t))
+		})
+	}
+}
+
+// openMongo connects to the server at STORE_TEST_MONGO_URI and returns a
+// store on a throwaway database, skipping the test when the variable is
+// unset.
+func openMongo(t *testing.T) testStore {
+	t.Helper()
+	uri := os.Getenv("STORE_TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("STORE_TEST_MONGO_URI not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connect to mongo: %v", err)
+	}
+	db := client.Database(fmt.Sprintf("complain_test_%s", primitive.NewObjectID().Hex()))
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+	return NewMongo(client, db)
+}
+
+// openPostgres connects to the server at STORE_TEST_POSTGRES_DSN and
+// returns a store in a throwaway schema, skipping the test when the
+// variable is unset.
+func openPostgres(t *testing.T) testStore {
+	t.Helper()
+	dsn := os.Getenv("STORE_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("STORE_TEST_POSTGRES_DSN not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	admin, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("connect to postgres: %v", err)
+	}
+	schema := "complain_test_" + primitive.NewObjectID().Hex()
+	if _, err := admin.ExecContext(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	if strings.Contains(dsn, "://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatalf("STORE_TEST_POSTGRES_DSN: %v", err)
+		}
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		dsn = u.String()
+	} else {
+		dsn += " search_path=" + schema
+	}
+	pg, err := OpenPostgres(ctx, "pgx", dsn)
+	if err != nil {
+		t.Fatalf("open postgres store: %v", err)
+	}
+	t.Cleanup(func() {
+		pg.Close()
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+	return pg
+}
+
+func newTestUser(t *testing.T, s testStore) models.User {
+	t.Helper()
+	user := models.User{ID: primitive.NewObjectID(), Name: "Ann", Email: primitive.NewObjectID().Hex() + "@example.com",
+		Complaints: []primitive.ObjectID{}}
+	if err := s.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
+
+// testConcurrentSubmits submits complaints for one user from many goroutines
+// at once and checks that none of the links to the user is lost.
+func testConcurrentSubmits(t *testing.T, s testStore) {
+	const n = 50
+	ctx := context.Background()
+	user := newTestUser(t, s)
+
+	ids := make([]primitive.ObjectID, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range ids {
+		ids[i] = primitive.NewObjectID()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = s.CreateComplaint(ctx, models.Complaint{ID: ids[i], UserID: user.ID, Title: fmt.Sprintf("complaint %d", i)})
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("CreateComplaint %d: %v", i, err)
+		}
+	}
+
+	got, err := s.UserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("UserByID: %v", err)
+	}
+	if len(got.Complaints) != n {
+		t.Errorf("user has %d complaints, want %d", len(got.Complaints), n)
+	}
+	for _, id := range ids {
+		if !slices.Contains(got.Complaints, id) {
+			t.Errorf("complaint %s missing from the user's complaints", id.Hex())
+		}
+	}
+}
+
+func TestConcurrentSubmits(t *testing.T) {
+	forEachStore(t, testConcurrentSubmits)
+}
@@ -0,0 +1,224 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// pollInterval is how often a stream over a backend that cannot signal new
+// changes looks for them.
+const pollInterval = 200 * time.Millisecond
+
+// stream is a change stream over an engine's change log. Its resume tokens
+// carry the sequence number of the last change it read, and are only
+// meaningful to the store that issued them.
+type stream struct {
+	e      *engine
+	coll   string
+	stages []bson.D
+	lookup bool
+	await  time.Duration
+
+	after   int64
+	checked bool
+	pending []pendingEvent
+	current bson.Raw
+	read    int64
+	err     error
+	closed  bool
+}
+
+type pendingEvent struct {
+	seq   int64
+	event bson.D
+}
+
+func (e *engine) watch(ctx context.Context, coll string, pipeline interface{}, o *options.ChangeStreamOptions) (ChangeStream, error) {
+	st, err := stages(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	s := &stream{e: e, coll: coll, stages: st, await: time.Second}
+	s.lookup = o.FullDocument != nil && *o.FullDocument != options.Default && *o.FullDocument != ""
+	if o.MaxAwaitTime != nil {
+		s.await = *o.MaxAwaitTime
+	}
+	token := o.ResumeAfter
+	if token == nil {
+		token = o.StartAfter
+	}
+	if token != nil {
+		if s.after, err = parseToken(token); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	// A new stream starts after the latest change.
+	_, _, s.after, err = e.b.changes(ctx, math.MaxInt64)
+	if err != nil {
+		return nil, err
+	}
+	s.checked = true
+	return s, nil
+}
+
+func resumeToken(seq int64) bson.D {
+	return bson.D{{Key: "_data", Value: fmt.Sprintf("%016X", seq)}}
+}
+
+func parseToken(token interface{}) (int64, error) {
+	d, err := asDoc(token, "resume token")
+	if err == nil {
+		data, _ := get(d, "_data")
+		if s, ok := data.(string); ok {
+			if seq, err := strconv.ParseInt(s, 16, 64); err == nil {
+				return seq, nil
+			}
+		}
+	}
+	return 0, mongo.CommandError{Code: 280, Name: "ChangeStreamFatalError", Message: "store: malformed resume token"}
+}
+
+func (s *stream) Next(ctx context.Context) bool {
+	for {
+		ch := s.e.b.changed()
+		if s.fetch(ctx) {
+			return true
+		}
+		if s.err != nil || s.closed || !s.wait(ctx, ch, time.Hour) {
+			return false
+		}
+	}
+}
+
+// TryNext returns the next change if there is one, waiting up to the
+// stream's MaxAwaitTime for one to be logged.
+func (s *stream) TryNext(ctx context.Context) bool {
+	ch := s.e.b.changed()
+	if s.fetch(ctx) {
+		return true
+	}
+	if s.err != nil || s.closed || s.await <= 0 || !s.wait(ctx, ch, s.await) {
+		return false
+	}
+	return s.fetch(ctx)
+}
+
+// wait blocks until ch is closed, d passes or ctx is done, checking every
+// pollInterval when the backend has no ch to close.
+func (s *stream) wait(ctx context.Context, ch <-chan struct{}, d time.Duration) bool {
+	if ch == nil && d > pollInterval {
+		d = pollInterval
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ch:
+	case <-t.C:
+	case <-ctx.Done():
+		s.err = ctx.Err()
+		return false
+	}
+	return true
+}
+
+// fetch moves to the next matching change, reading the log if none is
+// pending.
+func (s *stream) fetch(ctx context.Context) bool {
+	if s.closed || s.err != nil {
+		return false
+	}
+	if len(s.pending) == 0 {
+		changes, oldest, _, err := s.e.b.changes(ctx, s.after)
+		if err != nil {
+			s.err = err
+			return false
+		}
+		if !s.checked && s.after+1 < oldest {
+			s.err = mongo.CommandError{Code: 286, Name: "ChangeStreamHistoryLost",
+				Message: "store: the resume point is no longer in the change log"}
+			return false
+		}
+		s.checked = true
+		for _, c := range changes {
+			s.after = c.seq
+			if c.coll != s.coll {
+				continue
+			}
+			events, err := s.render(ctx, c)
+			if err != nil {
+				s.err = err
+				return false
+			}
+			for _, ev := range events {
+				s.pending = append(s.pending, pendingEvent{c.seq, ev})
+			}
+		}
+	}
+	if len(s.pending) == 0 {
+		return false
+	}
+	raw, err := bson.Marshal(s.pending[0].event)
+	if err != nil {
+		s.err = err
+		return false
+	}
+	s.read, s.current = s.pending[0].seq, raw
+	s.pending = s.pending[1:]
+	return true
+}
+
+// render gives a logged change the fields MongoDB adds when it is read and
+// runs it through the stream's pipeline.
+func (s *stream) render(ctx context.Context, c change) ([]bson.D, error) {
+	event := bson.D{{Key: "_id", Value: resumeToken(c.seq)}}
+	for _, f := range c.event {
+		if f.Key == "fullDocument" && !s.lookup && !isInsert(c.event) {
+			continue
+		}
+		event = append(event, f)
+	}
+	event = append(event, bson.E{Key: "clusterTime", Value: primitive.Timestamp{T: uint32(c.at.Unix()), I: uint32(c.seq)}})
+	p := &pipeline{ctx: ctx, from: s.e.Collection}
+	return p.run([]bson.D{event}, s.stages)
+}
+
+func isInsert(event bson.D) bool {
+	op, _ := get(event, "operationType")
+	return op == "insert"
+}
+
+func (s *stream) Decode(v interface{}) error {
+	if s.current == nil {
+		return mongo.ErrNilDocument
+	}
+	return bson.Unmarshal(s.current, v)
+}
+
+func (s *stream) Err() error {
+	return s.err
+}
+
+func (s *stream) Close(ctx context.Context) error {
+	s.closed = true
+	return nil
+}
+
+// ResumeToken returns the token of the last change read, or of the point
+// the stream has scanned up to.
+func (s *stream) ResumeToken() bson.Raw {
+	seq := s.after
+	if len(s.pending) > 0 {
+		seq = s.read
+	}
+	raw, _ := bson.Marshal(resumeToken(seq))
+	return raw
+}
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// stampUpdate sets updatedAt on an update document or pipeline, and
+// resolvedAt on one that resolves without saying when. Fields the update
+// sets itself are left alone, and updates of other shapes pass through.
+func stampUpdate(update interface{}) interface{} {
+	now := time.Now().UTC()
+	switch u := update.(type) {
+	case bson.M:
+		set, ok := u["$set"].(bson.M)
+		if !ok && u["$set"] != nil {
+			return update
+		}
+		stamped := bson.M{}
+		for k, v := range u {
+			stamped[k] = v
+		}
+		newSet := bson.M{"updatedAt": now}
+		for k, v := range set {
+			newSet[k] = v
+		}
+		if newSet["resolved"] == true && newSet["resolvedAt"] == nil && !unsets(u, "resolvedAt") {
+			newSet["resolvedAt"] = now
+		}
+		stamped["$set"] = newSet
+		return stamped
+	case mongo.Pipeline:
+		return append(append(mongo.Pipeline{}, u...), bson.D{{Key: "$set", Value: bson.M{"updatedAt": now}}})
+	case bson.A:
+		return append(append(bson.A{}, u...), bson.M{"$set": bson.M{"updatedAt": now}})
+	}
+	return update
+}
+
+func unsets(update bson.M, field string) bool {
+	unset, ok := update["$unset"].(bson.M)
+	if !ok {
+		return false
+	}
+	_, found := unset[field]
+	return found
+}
+
+// stampWrites stamps and scopes the updates in a bulk write.
+func stampWrites(filter func(interface{}) interface{}, models []mongo.WriteModel) []mongo.WriteModel {
+	stamped := make([]mongo.WriteModel, len(models))
+	for i, m := range models {
+		switch m := m.(type) {
+		case *mongo.UpdateOneModel:
+			c := *m
+			c.Filter, c.Update = filter(m.Filter), stampUpdate(m.Update)
+			stamped[i] = &c
+		case *mongo.UpdateManyModel:
+			c := *m
+			c.Filter, c.Update = filter(m.Filter), stampUpdate(m.Update)
+			stamped[i] = &c
+		case *mongo.DeleteOneModel:
+			c := *m
+			c.Filter = filter(m.Filter)
+			stamped[i] = &c
+		case *mongo.DeleteManyModel:
+			c := *m
+			c.Filter = filter(m.Filter)
+			stamped[i] = &c
+		default:
+			stamped[i] = m
+		}
+	}
+	return stamped
+}
+
+// stampCreated gives a new document its creation time, unless the caller
+// already chose one, and starts updatedAt there unless the caller chose a
+// later one, as imports of older records do.
+func stampCreated(createdAt, updatedAt *time.Time) {
+	if createdAt.IsZero() {
+		*createdAt = time.Now().UTC()
+	}
+	if updatedAt.Before(*createdAt) {
+		*updatedAt = *createdAt
+	}
+}
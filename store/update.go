@@ -0,0 +1,319 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// update is a normalized update: operators, or a pipeline of $set, $unset
+// and the like, with the array filters its $[name] paths refer to.
+type update struct {
+	ops          bson.D
+	pipeline     []bson.D
+	arrayFilters map[string]bson.D
+}
+
+func newUpdate(u interface{}, arrayFilters []interface{}) (*update, error) {
+	n, err := normalize(u)
+	if err != nil {
+		return nil, err
+	}
+	up := &update{arrayFilters: map[string]bson.D{}}
+	switch x := n.(type) {
+	case bson.D:
+		for _, e := range x {
+			if !strings.HasPrefix(e.Key, "$") {
+				return nil, fmt.Errorf("store: update field %s is not an operator", e.Key)
+			}
+		}
+		up.ops = x
+	case bson.A:
+		if up.pipeline, err = stages(x); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("store: an update must be a document or a pipeline, not %T", u)
+	}
+	for _, f := range arrayFilters {
+		d, err := asDoc(f, "array filter")
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range d {
+			name, _, _ := strings.Cut(e.Key, ".")
+			up.arrayFilters[name] = append(up.arrayFilters[name], e)
+		}
+	}
+	return up, nil
+}
+
+// apply returns doc with the update applied. insert is true when the
+// document is being upserted, which is when $setOnInsert applies.
+func (u *update) apply(doc bson.D, insert bool) (bson.D, error) {
+	if u.pipeline != nil {
+		p := &pipeline{}
+		for _, st := range u.pipeline {
+			switch st[0].Key {
+			case "$set", "$addFields", "$unset", "$project", "$replaceRoot", "$replaceWith":
+			default:
+				return nil, fmt.Errorf("store: %s is not allowed in an update pipeline", st[0].Key)
+			}
+		}
+		out, err := p.run([]bson.D{doc}, u.pipeline)
+		if err != nil {
+			return nil, err
+		}
+		return keepID(doc, out[0])
+	}
+	id := idOf(doc)
+	for _, op := range u.ops {
+		fields, ok := op.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("store: %s needs a document", op.Key)
+		}
+		if op.Key == "$setOnInsert" && !insert {
+			continue
+		}
+		for _, f := range fields {
+			paths, err := u.expand(doc, splitPath(f.Key))
+			if err != nil {
+				return nil, err
+			}
+			for _, path := range paths {
+				if doc, err = applyOp(doc, op.Key, path, f.Value); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if id != missing && !equal(idOf(doc), id) {
+		return nil, fmt.Errorf("store: an update cannot change _id")
+	}
+	return doc, nil
+}
+
+func keepID(before, after bson.D) (bson.D, error) {
+	id := idOf(before)
+	switch got := idOf(after); {
+	case got == missing:
+		return append(bson.D{{Key: "_id", Value: id}}, after...), nil
+	case !equal(got, id):
+		return nil, fmt.Errorf("store: an update cannot change _id")
+	}
+	return after, nil
+}
+
+// expand resolves the all-positional $[] and filtered $[name] parts of a
+// path to the array positions they stand for.
+func (u *update) expand(doc bson.D, path []string) ([][]string, error) {
+	for i, p := range path {
+		if p == "$" {
+			return nil, fmt.Errorf("store: the positional $ operator is not supported; use $[name] with an array filter")
+		}
+		if !strings.HasPrefix(p, "$[") || !strings.HasSuffix(p, "]") {
+			continue
+		}
+		v, _ := getPath(doc, path[:i])
+		a, ok := v.(bson.A)
+		if !ok {
+			if v == missing {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("store: %s needs an array at %s", p, strings.Join(path[:i], "."))
+		}
+		name := p[2 : len(p)-1]
+		filter, ok := u.arrayFilters[name]
+		if name != "" && !ok {
+			return nil, fmt.Errorf("store: no array filter for %s", name)
+		}
+		var out [][]string
+		for j, e := range a {
+			if name != "" {
+				var m matcher
+				ok, err := m.match(bson.D{{Key: name, Value: e}}, filter)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					continue
+				}
+			}
+			concrete := append(append(append([]string{}, path[:i]...), strconv.Itoa(j)), path[i+1:]...)
+			rest, err := u.expand(doc, concrete)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, rest...)
+		}
+		return out, nil
+	}
+	return [][]string{path}, nil
+}
+
+func applyOp(doc bson.D, op string, path []string, arg interface{}) (bson.D, error) {
+	old, exists := getPath(doc, path)
+	switch op {
+	case "$set", "$setOnInsert":
+		return setPath(doc, path, arg)
+	case "$unset":
+		return unsetPath(doc, path), nil
+	case "$inc":
+		if !isNumber(arg) {
+			return nil, fmt.Errorf("store: $inc needs a number")
+		}
+		if !exists || old == nil {
+			return setPath(doc, path, arg)
+		}
+		if !isNumber(old) {
+			return nil, fmt.Errorf("store: cannot $inc %s, a %s", strings.Join(path, "."), typeName(old))
+		}
+		sum, err := arithmetic("$add", []interface{}{old, arg})
+		if err != nil {
+			return nil, err
+		}
+		if _, wide := old.(int64); wide {
+			if n, ok := sum.(int32); ok {
+				sum = int64(n)
+			}
+		}
+		return setPath(doc, path, sum)
+	case "$min", "$max":
+		c := compare(arg, old)
+		if !exists || (op == "$min" && c < 0) || (op == "$max" && c > 0) {
+			return setPath(doc, path, arg)
+		}
+		return doc, nil
+	case "$currentDate":
+		return setPath(doc, path, primitive.NewDateTimeFromTime(time.Now()))
+	case "$push", "$addToSet":
+		list, ok := old.(bson.A)
+		if exists && !ok {
+			return nil, fmt.Errorf("store: cannot %s to %s, a %s", op, strings.Join(path, "."), typeName(old))
+		}
+		list = append(bson.A{}, list...)
+		values := bson.A{arg}
+		var slice interface{}
+		if d, ok := arg.(bson.D); ok {
+			if each, ok := get(d, "$each"); ok {
+				if values, ok = each.(bson.A); !ok {
+					return nil, fmt.Errorf("store: $each needs an array")
+				}
+				slice, _ = get(d, "$slice")
+			}
+		}
+		for _, v := range values {
+			if op == "$addToSet" && containsEqual(list, v) {
+				continue
+			}
+			list = append(list, v)
+		}
+		if slice != nil && slice != missing {
+			n, ok := toInt64(slice)
+			if !ok {
+				return nil, fmt.Errorf("store: $slice needs an integer")
+			}
+			switch {
+			case n >= 0 && n < int64(len(list)):
+				list = list[:n]
+			case n < 0 && -n < int64(len(list)):
+				list = list[int64(len(list))+n:]
+			}
+		}
+		return setPath(doc, path, list)
+	case "$pull", "$pullAll":
+		list, ok := old.(bson.A)
+		if !exists || old == nil {
+			return doc, nil
+		}
+		if !ok {
+			return nil, fmt.Errorf("store: cannot %s from %s, a %s", op, strings.Join(path, "."), typeName(old))
+		}
+		kept := bson.A{}
+		for _, e := range list {
+			drop, err := pulls(op, e, arg)
+			if err != nil {
+				return nil, err
+			}
+			if !drop {
+				kept = append(kept, e)
+			}
+		}
+		return setPath(doc, path, kept)
+	}
+	return nil, fmt.Errorf("store: unsupported update operator %s", op)
+}
+
+func containsEqual(list bson.A, v interface{}) bool {
+	for _, e := range list {
+		if equal(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// pulls reports whether $pull's condition, or $pullAll's list, removes e.
+func pulls(op string, e, cond interface{}) (bool, error) {
+	if op == "$pullAll" {
+		list, ok := cond.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("store: $pullAll needs an array")
+		}
+		return containsEqual(list, e), nil
+	}
+	if ops, ok := operators(cond); ok {
+		return matchOps([]interface{}{e}, ops)
+	}
+	if filter, ok := cond.(bson.D); ok {
+		if d, isDoc := e.(bson.D); isDoc {
+			var m matcher
+			return m.match(d, filter)
+		}
+		return false, nil
+	}
+	return matchEq(e, cond), nil
+}
+
+// upsertSeed is the document an upsert starts from: the fields the filter
+// pins to a single value.
+func upsertSeed(filter bson.D) (bson.D, error) {
+	doc := bson.D{}
+	var seed func(f bson.D) error
+	seed = func(f bson.D) error {
+		for _, e := range f {
+			if e.Key == "$and" {
+				clauses, _ := e.Value.(bson.A)
+				for _, c := range clauses {
+					if d, ok := c.(bson.D); ok {
+						if err := seed(d); err != nil {
+							return err
+						}
+					}
+				}
+				continue
+			}
+			if strings.HasPrefix(e.Key, "$") {
+				continue
+			}
+			v := e.Value
+			if ops, ok := operators(v); ok {
+				eq, ok := get(ops, "$eq")
+				if !ok {
+					continue
+				}
+				v = eq
+			}
+			var err error
+			if doc, err = setPath(doc, splitPath(e.Key), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return doc, seed(filter)
+}
@@ -0,0 +1,579 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The documents of the PostgreSQL and in-memory stores are held as BSON and
+// decoded as bson.D, with arrays as bson.A and every other value in its
+// primitive form, as the mongo driver decodes them. Queries, updates and
+// pipelines are decoded the same way first, so a time.Time in a filter
+// compares with the primitive.DateTime in a document. The functions here give
+// those values MongoDB's semantics.
+
+// missingValue stands for a field a document does not have. Queries and
+// sorts treat it like null; expressions can tell them apart.
+type missingValue struct{}
+
+var missing = missingValue{}
+
+// normalize converts a value from a caller to its decoded BSON form.
+func normalize(v interface{}) (interface{}, error) {
+	raw, err := bson.Marshal(bson.D{{Key: "v", Value: v}})
+	if err != nil {
+		return nil, err
+	}
+	var d bson.D
+	if err := bson.Unmarshal(raw, &d); err != nil {
+		return nil, err
+	}
+	return d[0].Value, nil
+}
+
+// toDoc converts a document from a caller, a struct, map, bson.D or raw
+// BSON, to its decoded form.
+func toDoc(v interface{}) (bson.D, error) {
+	if v == nil {
+		return bson.D{}, nil
+	}
+	var raw []byte
+	switch r := v.(type) {
+	case bson.Raw:
+		raw = r
+	case []byte:
+		raw = r
+	default:
+		var err error
+		if raw, err = bson.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	var d bson.D
+	if err := bson.Unmarshal(raw, &d); err != nil {
+		return nil, err
+	}
+	if d == nil {
+		d = bson.D{}
+	}
+	return d, nil
+}
+
+// asDoc converts a filter, update or pipeline stage, which must be a
+// document.
+func asDoc(v interface{}, what string) (bson.D, error) {
+	if v == nil {
+		return bson.D{}, nil
+	}
+	n, err := normalize(v)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := n.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("store: %s must be a document, not %T", what, v)
+	}
+	return d, nil
+}
+
+func get(d bson.D, key string) (interface{}, bool) {
+	for _, e := range d {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return missing, false
+}
+
+// set returns d with key set to v, replacing the value in place or
+// appending the field as MongoDB does.
+func set(d bson.D, key string, v interface{}) bson.D {
+	for i, e := range d {
+		if e.Key == key {
+			d[i].Value = v
+			return d
+		}
+	}
+	return append(d, bson.E{Key: key, Value: v})
+}
+
+func remove(d bson.D, key string) (bson.D, bool) {
+	for i, e := range d {
+		if e.Key == key {
+			return append(d[:i:i], d[i+1:]...), true
+		}
+	}
+	return d, false
+}
+
+// idOf returns a document's _id.
+func idOf(d bson.D) interface{} {
+	id, _ := get(d, "_id")
+	return id
+}
+
+// idKey is the string a store keys a document by: the hex of an ObjectID,
+// a string as it is, and anything else by its extended JSON.
+func idKey(id interface{}) string {
+	switch v := id.(type) {
+	case primitive.ObjectID:
+		return v.Hex()
+	case string:
+		return v
+	}
+	b, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: id}}, true, false)
+	if err != nil {
+		return fmt.Sprint(id)
+	}
+	return string(b)
+}
+
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case int32, int64, float64, primitive.Decimal128:
+		return true
+	}
+	return false
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	case primitive.Decimal128:
+		f, err := strconv.ParseFloat(n.String(), 64)
+		if err != nil {
+			return math.NaN()
+		}
+		return f
+	}
+	return math.NaN()
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case float64:
+		if n == math.Trunc(n) {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+// number gives an integer result the narrowest integer type that holds it,
+// as MongoDB's arithmetic does.
+func number(n int64) interface{} {
+	if n >= math.MinInt32 && n <= math.MaxInt32 {
+		return int32(n)
+	}
+	return n
+}
+
+// typeRank orders values of different types as MongoDB sorts them.
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case primitive.MinKey:
+		return 1
+	case nil, missingValue, primitive.Null, primitive.Undefined:
+		return 2
+	case int32, int64, float64, primitive.Decimal128:
+		return 3
+	case string, primitive.Symbol:
+		return 4
+	case bson.D:
+		return 5
+	case bson.A:
+		return 6
+	case primitive.Binary:
+		return 7
+	case primitive.ObjectID:
+		return 8
+	case bool:
+		return 9
+	case primitive.DateTime:
+		return 10
+	case primitive.Timestamp:
+		return 11
+	case primitive.Regex:
+		return 12
+	case primitive.MaxKey:
+		return 14
+	}
+	return 13
+}
+
+// compare orders two values as MongoDB sorts them, returning -1, 0 or 1.
+func compare(a, b interface{}) int {
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		return cmpInt(ra, rb)
+	}
+	switch ra {
+	case 1, 2, 14:
+		return 0
+	}
+	switch x := a.(type) {
+	case int32, int64, float64, primitive.Decimal128:
+		if i, ok := a.(int64); ok {
+			if j, ok := b.(int64); ok {
+				return cmpInt64(i, j)
+			}
+		}
+		return cmpFloat(toFloat(a), toFloat(b))
+	case string:
+		return strings.Compare(x, stringOf(b))
+	case primitive.Symbol:
+		return strings.Compare(string(x), stringOf(b))
+	case bson.D:
+		y := b.(bson.D)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compare(x[i].Value, y[i].Value); c != 0 {
+				return c
+			}
+			if c := strings.Compare(x[i].Key, y[i].Key); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(len(x), len(y))
+	case bson.A:
+		y := b.(bson.A)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compare(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(len(x), len(y))
+	case primitive.Binary:
+		y := b.(primitive.Binary)
+		if len(x.Data) != len(y.Data) {
+			return cmpInt(len(x.Data), len(y.Data))
+		}
+		if x.Subtype != y.Subtype {
+			return cmpInt(int(x.Subtype), int(y.Subtype))
+		}
+		return bytes.Compare(x.Data, y.Data)
+	case primitive.ObjectID:
+		y := b.(primitive.ObjectID)
+		return bytes.Compare(x[:], y[:])
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case primitive.DateTime:
+		return cmpInt64(int64(x), int64(b.(primitive.DateTime)))
+	case primitive.Timestamp:
+		y := b.(primitive.Timestamp)
+		if x.T != y.T {
+			return cmpInt64(int64(x.T), int64(y.T))
+		}
+		return cmpInt64(int64(x.I), int64(y.I))
+	case primitive.Regex:
+		y := b.(primitive.Regex)
+		if c := strings.Compare(x.Pattern, y.Pattern); c != 0 {
+			return c
+		}
+		return strings.Compare(x.Options, y.Options)
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func stringOf(v interface{}) string {
+	if s, ok := v.(primitive.Symbol); ok {
+		return string(s)
+	}
+	s, _ := v.(string)
+	return s
+}
+
+func cmpInt(a, b int) int {
+	return cmpInt64(int64(a), int64(b))
+}
+
+func cmpInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// equal reports whether two values are the same as far as MongoDB's
+// equality goes: numbers compare by value and null equals missing.
+func equal(a, b interface{}) bool {
+	return typeRank(a) == typeRank(b) && compare(a, b) == 0
+}
+
+// typeName is the name $type gives a value.
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case missingValue:
+		return "missing"
+	case nil, primitive.Null:
+		return "null"
+	case primitive.Undefined:
+		return "undefined"
+	case float64:
+		return "double"
+	case string:
+		return "string"
+	case primitive.Symbol:
+		return "symbol"
+	case bson.D:
+		return "object"
+	case bson.A:
+		return "array"
+	case primitive.Binary:
+		return "binData"
+	case primitive.ObjectID:
+		return "objectId"
+	case bool:
+		return "bool"
+	case primitive.DateTime:
+		return "date"
+	case primitive.Regex:
+		return "regex"
+	case primitive.JavaScript, primitive.CodeWithScope:
+		return "javascript"
+	case int32:
+		return "int"
+	case primitive.Timestamp:
+		return "timestamp"
+	case int64:
+		return "long"
+	case primitive.Decimal128:
+		return "decimal"
+	case primitive.MinKey:
+		return "minKey"
+	case primitive.MaxKey:
+		return "maxKey"
+	}
+	return "unknown"
+}
+
+// typeCodes are the numeric aliases of $type.
+var typeCodes = map[int64]string{
+	1: "double", 2: "string", 3: "object", 4: "array", 5: "binData", 6: "undefined", 7: "objectId",
+	8: "bool", 9: "date", 10: "null", 11: "regex", 13: "javascript", 14: "symbol", 16: "int",
+	17: "timestamp", 18: "long", 19: "decimal", -1: "minKey", 127: "maxKey",
+}
+
+func isNullish(v interface{}) bool {
+	switch v.(type) {
+	case nil, missingValue, primitive.Null, primitive.Undefined:
+		return true
+	}
+	return false
+}
+
+// truthy is how expressions such as $cond read a value: false, null,
+// missing and zero are false, everything else true.
+func truthy(v interface{}) bool {
+	switch x := v.(type) {
+	case bool:
+		return x
+	case nil, missingValue, primitive.Null, primitive.Undefined:
+		return false
+	case int32, int64, float64, primitive.Decimal128:
+		return toFloat(x) != 0
+	}
+	return true
+}
+
+// resolve returns the values path reaches in v the way a query sees them:
+// through arrays of documents, and into arrays by numeric position. A path
+// that reaches nothing yields missing.
+func resolve(v interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{v}
+	}
+	switch x := v.(type) {
+	case bson.D:
+		child, _ := get(x, path[0])
+		return resolve(child, path[1:])
+	case bson.A:
+		var found []interface{}
+		if i, err := strconv.Atoi(path[0]); err == nil && i >= 0 && i < len(x) {
+			found = append(found, resolve(x[i], path[1:])...)
+		}
+		for _, e := range x {
+			if d, ok := e.(bson.D); ok {
+				for _, r := range resolve(d, path) {
+					if r != missing {
+						found = append(found, r)
+					}
+				}
+			}
+		}
+		if len(found) == 0 {
+			return []interface{}{missing}
+		}
+		return found
+	}
+	return []interface{}{missing}
+}
+
+// fieldPath returns the value an expression's "$a.b" reaches in v: through
+// an array it is the array of what each element reaches.
+func fieldPath(v interface{}, path []string) interface{} {
+	for i, p := range path {
+		switch x := v.(type) {
+		case bson.D:
+			v, _ = get(x, p)
+		case bson.A:
+			out := bson.A{}
+			for _, e := range x {
+				if r := fieldPath(e, path[i:]); r != missing {
+					out = append(out, r)
+				}
+			}
+			return out
+		default:
+			return missing
+		}
+	}
+	return v
+}
+
+// setPath sets a dotted path in d, creating documents along the way and
+// indexing into arrays by position, and returns the updated document. It
+// copies what it changes, so other references to d's parts are unaffected.
+func setPath(d bson.D, path []string, v interface{}) (bson.D, error) {
+	out, err := setIn(d, path, v)
+	if err != nil {
+		return nil, err
+	}
+	return out.(bson.D), nil
+}
+
+func setIn(container interface{}, path []string, v interface{}) (interface{}, error) {
+	switch x := container.(type) {
+	case bson.D:
+		if len(path) == 1 {
+			return set(append(bson.D{}, x...), path[0], v), nil
+		}
+		child, ok := get(x, path[0])
+		if !ok || isNullish(child) {
+			child = bson.D{}
+		}
+		updated, err := setIn(child, path[1:], v)
+		if err != nil {
+			return nil, err
+		}
+		return set(append(bson.D{}, x...), path[0], updated), nil
+	case bson.A:
+		i, err := strconv.Atoi(path[0])
+		if err != nil || i < 0 {
+			return nil, fmt.Errorf("store: cannot create field %q in an array", path[0])
+		}
+		out := append(bson.A{}, x...)
+		for len(out) <= i {
+			out = append(out, nil)
+		}
+		if len(path) == 1 {
+			out[i] = v
+			return out, nil
+		}
+		child := out[i]
+		if isNullish(child) {
+			child = bson.D{}
+		}
+		if out[i], err = setIn(child, path[1:], v); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("store: cannot set %q in a %s", path[0], typeName(container))
+}
+
+// unsetPath removes a dotted path from d; in an array the element becomes
+// null, as $unset does.
+func unsetPath(d bson.D, path []string) bson.D {
+	out, _ := unsetIn(d, path).(bson.D)
+	return out
+}
+
+func unsetIn(container interface{}, path []string) interface{} {
+	switch x := container.(type) {
+	case bson.D:
+		if len(path) == 1 {
+			out, _ := remove(append(bson.D{}, x...), path[0])
+			return out
+		}
+		child, ok := get(x, path[0])
+		if !ok {
+			return x
+		}
+		return set(append(bson.D{}, x...), path[0], unsetIn(child, path[1:]))
+	case bson.A:
+		i, err := strconv.Atoi(path[0])
+		if err != nil || i < 0 || i >= len(x) {
+			return x
+		}
+		out := append(bson.A{}, x...)
+		if len(path) == 1 {
+			out[i] = nil
+		} else {
+			out[i] = unsetIn(out[i], path[1:])
+		}
+		return out
+	}
+	return container
+}
+
+// getPath returns the value at a dotted path, indexing arrays by position
+// only, as updates address fields.
+func getPath(v interface{}, path []string) (interface{}, bool) {
+	for _, p := range path {
+		switch x := v.(type) {
+		case bson.D:
+			var ok bool
+			if v, ok = get(x, p); !ok {
+				return missing, false
+			}
+		case bson.A:
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(x) {
+				return missing, false
+			}
+			v = x[i]
+		default:
+			return missing, false
+		}
+	}
+	return v, true
+}
+
+func splitPath(path string) []string {
+	return strings.Split(path, ".")
+}